@@ -316,6 +316,140 @@ func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
 	return nil
 }
 
+// ExportProgressFunc is a function that [Client.Export] invokes as the
+// bundle is received.
+// It's similar to other progress function types like [PullProgressFunc].
+type ExportProgressFunc func(ProgressResponse) error
+
+// Export writes the models in req to w as a self-contained bundle, a tarball
+// in the OCI image layout that [Client.Import] adds to another Ollama
+// install. Blobs shared by the models are only written once. fn, if not nil,
+// is called as the bundle is written.
+func (c *Client) Export(ctx context.Context, req *ExportRequest, w io.Writer, fn ExportProgressFunc) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	requestURL := c.base.JoinPath("/api/export")
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/x-tar")
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		return checkError(response, body)
+	}
+
+	buf := make([]byte, 1<<20)
+	var completed int64
+	for {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			completed += int64(n)
+			if fn != nil {
+				if err := fn(ProgressResponse{Status: "exporting", Total: response.ContentLength, Completed: completed}); err != nil {
+					return err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Import adds the models in a bundle written by [Client.Export], read from r,
+// and returns their names. Blobs are checked against their digests, and the
+// models are only added once all their blobs are. Models which already exist
+// are only replaced if req.Force is set.
+func (c *Client) Import(ctx context.Context, req *ImportRequest, r io.Reader) (*ImportResponse, error) {
+	requestURL := c.base.JoinPath("/api/import")
+	if req != nil && req.Force {
+		requestURL.RawQuery = url.Values{"force": {"true"}}.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL.String(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-tar")
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkError(response, body); err != nil {
+		return nil, err
+	}
+
+	var resp ImportResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GC removes the blobs not used by any model and the files left behind by
+// interrupted downloads, and reports the disk usage of the models. If
+// req.DryRun is set, nothing is removed.
+func (c *Client) GC(ctx context.Context, req *GCRequest) (*GCResponse, error) {
+	var resp GCResponse
+	if err := c.do(ctx, http.MethodPost, "/api/gc", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// VerifyProgressFunc is a function that [Client.Verify] invokes when progress
+// is made.
+type VerifyProgressFunc func(VerifyResponse) error
+
+// Verify checks the blobs of the models in req against their digests, and
+// their model files for valid headers. fn is called each time progress is
+// made, and with the problems found when done.
+func (c *Client) Verify(ctx context.Context, req *VerifyRequest, fn VerifyProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/verify", req, func(bts []byte) error {
+		var resp VerifyResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
 // Delete deletes a model and its data.
 func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	if err := c.do(ctx, http.MethodDelete, "/api/delete", req, nil); err != nil {
@@ -360,6 +494,25 @@ func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*Embedd
 	return &resp, nil
 }
 
+// Rerank scores the relevance of documents to a query using a cross-encoder
+// model.
+func (c *Client) Rerank(ctx context.Context, req *RerankRequest) (*RerankResponse, error) {
+	var resp RerankResponse
+	if err := c.do(ctx, http.MethodPost, "/api/rerank", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Transcribe converts speech in an audio file to text.
+func (c *Client) Transcribe(ctx context.Context, req *TranscribeRequest) (*TranscribeResponse, error) {
+	var resp TranscribeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/transcribe", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // CreateBlob creates a blob from a file on the server. digest is the
 // expected SHA256 digest of the file, and r represents the file.
 func (c *Client) CreateBlob(ctx context.Context, digest string, r io.Reader) error {
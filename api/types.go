@@ -38,6 +38,9 @@ func (e StatusError) Error() string {
 // ImageData represents the raw binary data of an image file.
 type ImageData []byte
 
+// AudioData represents the raw binary data of a WAV or FLAC audio file.
+type AudioData []byte
+
 // GenerateRequest describes a request sent by [Client.Generate]. While you
 // have to specify the Model and Prompt fields, all the other fields have
 // reasonable defaults for basic uses.
@@ -79,6 +82,10 @@ type GenerateRequest struct {
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
 
+	// Adapter is the name of a model with a LoRA adapter for Model to apply
+	// to this request in place of the adapters of Model.
+	Adapter string `json:"adapter,omitempty"`
+
 	// Options lists model-specific options. For example, temperature can be
 	// set through this field, if the model supports it.
 	Options map[string]interface{} `json:"options"`
@@ -105,6 +112,10 @@ type ChatRequest struct {
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
+	// Adapter is the name of a model with a LoRA adapter, as in
+	// [GenerateRequest].
+	Adapter string `json:"adapter,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
@@ -123,11 +134,12 @@ func (t Tool) String() string {
 
 // Message is a single message in a chat sequence. The message contains the
 // role ("system", "user", or "assistant"), the content and an optional list
-// of images.
+// of images and audio clips.
 type Message struct {
 	Role      string      `json:"role"`
 	Content   string      `json:"content"`
 	Images    []ImageData `json:"images,omitempty"`
+	Audio     []AudioData `json:"audio,omitempty"`
 	ToolCalls []ToolCall  `json:"tool_calls,omitempty"`
 }
 
@@ -294,6 +306,101 @@ type EmbeddingResponse struct {
 	Embedding []float64 `json:"embedding"`
 }
 
+// RerankRequest is the request passed to [Client.Rerank].
+type RerankRequest struct {
+	// Model is the model name. It must be a cross-encoder model.
+	Model string `json:"model"`
+
+	// Query is the text the documents are scored against.
+	Query string `json:"query"`
+
+	// Documents is the list of documents to score.
+	Documents []string `json:"documents"`
+
+	// TopN limits the response to the N most relevant documents. If zero, all
+	// documents are returned.
+	TopN int `json:"top_n,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	Truncate *bool `json:"truncate,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// RerankResult is the relevance score of a single document.
+type RerankResult struct {
+	// Index is the position of the document in the request.
+	Index          int     `json:"index"`
+	Document       string  `json:"document"`
+	RelevanceScore float32 `json:"relevance_score"`
+}
+
+// RerankResponse is the response from [Client.Rerank]. Results are sorted by
+// descending relevance.
+type RerankResponse struct {
+	Model   string         `json:"model"`
+	Results []RerankResult `json:"results"`
+
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+}
+
+// TranscribeRequest is the request passed to [Client.Transcribe].
+type TranscribeRequest struct {
+	// Model is the model name. It must be a speech recognition model.
+	Model string `json:"model"`
+
+	// Audio is the WAV or FLAC file to transcribe.
+	Audio AudioData `json:"audio"`
+
+	// Language is the ISO 639-1 code of the spoken language, e.g. "en". The
+	// language is detected by the model if empty.
+	Language string `json:"language,omitempty"`
+
+	// Prompt is text that precedes the audio, used to guide the spelling and
+	// style of the transcription.
+	Prompt string `json:"prompt,omitempty"`
+
+	// Timestamps requests the start and end time of each segment.
+	Timestamps bool `json:"timestamps,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// TranscribeSegment is a span of the transcription with its position in the
+// audio, in seconds.
+type TranscribeSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// TranscribeResponse is the response from [Client.Transcribe].
+type TranscribeResponse struct {
+	Model    string `json:"model"`
+	Text     string `json:"text"`
+	Language string `json:"language,omitempty"`
+
+	// Duration is the length of the audio in seconds.
+	Duration float64 `json:"duration"`
+
+	// Segments is only set when timestamps are requested.
+	Segments []TranscribeSegment `json:"segments,omitempty"`
+
+	TotalDuration time.Duration `json:"total_duration,omitempty"`
+	LoadDuration  time.Duration `json:"load_duration,omitempty"`
+}
+
 // CreateRequest is the request passed to [Client.Create].
 type CreateRequest struct {
 	Model    string `json:"model"`
@@ -309,12 +416,72 @@ type CreateRequest struct {
 	Parameters map[string]any    `json:"parameters,omitempty"`
 	Messages   []Message         `json:"messages,omitempty"`
 
+	// Imatrix is the digest of a blob of calibration text. When quantizing,
+	// the text is run through the model to compute an importance matrix that
+	// guides the rounding of the weights.
+	Imatrix string `json:"imatrix,omitempty"`
+
+	// QuantizeMixed keeps the attention and output weights at 8 bits when
+	// quantizing.
+	QuantizeMixed bool `json:"quantize_mixed,omitempty"`
+
+	// MergeAdapters folds the LoRA adapters into the model's weights instead
+	// of keeping them as separate layers.
+	MergeAdapters bool `json:"merge_adapters,omitempty"`
+
+	// MergeMethod combines the updates of several adapters to the same
+	// weight. It is "linear" (the default) or "ties".
+	MergeMethod string `json:"merge_method,omitempty"`
+
+	// MergeDensity is the fraction of each adapter's updates kept by the
+	// "ties" merge method.
+	MergeDensity float32 `json:"merge_density,omitempty"`
+
+	// AdapterWeights scales the updates of each adapter when merging, in the
+	// order of the adapters of the base model followed by Adapters sorted by
+	// file name. Each adapter has a weight of 1 if not set.
+	AdapterWeights []float32 `json:"adapter_weights,omitempty"`
+
+	// Merge merges the weights of other local models into the model in From.
+	Merge *MergeRequest `json:"merge,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 	// Deprecated: use Quantize instead
 	Quantization string `json:"quantization,omitempty"`
 }
 
+// MergeRequest describes how models are merged by [CreateRequest].
+type MergeRequest struct {
+	// Method is "linear" (the default), "slerp" or "task_arithmetic".
+	// "linear" and "slerp" interpolate from the model in From towards each of
+	// Models by its weight. "slerp" merges a single model. "task_arithmetic"
+	// adds the weighted differences between each of Models and Base to
+	// Base. The model in From is weighted like the others if it's listed in
+	// Models, and by 1 if not.
+	Method string `json:"method,omitempty"`
+
+	// Base is the model "task_arithmetic" takes the differences from.
+	Base string `json:"base,omitempty"`
+
+	Models []MergeModel `json:"models"`
+}
+
+// MergeModel is a model merged with a weight, for all of its tensors or
+// a range of layers. A model can be listed several times with different
+// ranges of layers.
+type MergeModel struct {
+	Model string `json:"model"`
+
+	// Weight is 1/(n+1) for "linear" merges of n models, 0.5 for "slerp" and
+	// 1 for "task_arithmetic" if not set.
+	Weight *float32 `json:"weight,omitempty"`
+
+	// Layers is the first and last layer merged. Every tensor is merged if
+	// it's empty.
+	Layers []int `json:"layers,omitempty"`
+}
+
 // DeleteRequest is the request passed to [Client.Delete].
 type DeleteRequest struct {
 	Model string `json:"model"`
@@ -359,6 +526,95 @@ type CopyRequest struct {
 	Destination string `json:"destination"`
 }
 
+// ExportRequest is the request passed to [Client.Export].
+type ExportRequest struct {
+	// Models are the names of the models to export.
+	Models []string `json:"models"`
+}
+
+// ImportRequest is the request passed to [Client.Import].
+type ImportRequest struct {
+	// Force, if true, replaces models which already exist.
+	Force bool `json:"force,omitempty"`
+}
+
+// ImportResponse is the response returned from [Client.Import].
+type ImportResponse struct {
+	// Models are the names of the models imported.
+	Models []string `json:"models"`
+}
+
+// GCRequest is the request passed to [Client.GC].
+type GCRequest struct {
+	// DryRun, if true, only reports what would be removed.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// GCResponse is the response returned from [Client.GC].
+type GCResponse struct {
+	// Models is the disk usage of each model.
+	Models []ModelUsage `json:"models"`
+
+	// Size is the total size of the files in the model store.
+	Size int64 `json:"size"`
+
+	// Blobs is the number of blobs not used by any model, and Partials
+	// the number of files left behind by interrupted downloads. They
+	// are removed unless DryRun was set.
+	Blobs    int `json:"blobs"`
+	Partials int `json:"partials"`
+
+	// Reclaimable is the size of the blobs and files removed, or that
+	// would be removed.
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+// ModelUsage is the disk usage of a single model in [GCResponse].
+type ModelUsage struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+
+	// Unique is the size of the layers used by no other model, which
+	// deleting the model frees, and Shared the size of the layers it
+	// shares with other models.
+	Unique int64 `json:"unique"`
+	Shared int64 `json:"shared"`
+}
+
+// VerifyRequest is the request passed to [Client.Verify].
+type VerifyRequest struct {
+	// Models are the names of the models to verify. If empty, all models
+	// are verified.
+	Models []string `json:"models,omitempty"`
+
+	// Repair, if true, pulls the models with problems again, over plain
+	// HTTP if Insecure is set.
+	Repair   bool  `json:"repair,omitempty"`
+	Insecure bool  `json:"insecure,omitempty"`
+	Stream   *bool `json:"stream,omitempty"`
+}
+
+// VerifyResponse is the response passed to [VerifyProgressFunc]. The last
+// response has the status "success", and lists the problems found.
+type VerifyResponse struct {
+	ProgressResponse
+	Problems []VerifyProblem `json:"problems,omitempty"`
+}
+
+// VerifyProblem is a problem with a model found by [Client.Verify].
+type VerifyProblem struct {
+	Model string `json:"model"`
+
+	// Digest is the digest of the blob with the problem, if the problem
+	// is with a blob.
+	Digest string `json:"digest,omitempty"`
+	Error  string `json:"error"`
+
+	// Repaired reports whether the problem was fixed by pulling the
+	// model again.
+	Repaired bool `json:"repaired,omitempty"`
+}
+
 // PullRequest is the request passed to [Client.Pull].
 type PullRequest struct {
 	Model    string `json:"model"`
@@ -367,6 +623,14 @@ type PullRequest struct {
 	Password string `json:"password"`           // Deprecated: ignored
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// LimitRate, if positive, is the maximum rate, in bytes per second, at
+	// which the model is downloaded.
+	LimitRate int64 `json:"limit_rate,omitempty"`
+
+	// Window, if set, is the hours of the day, e.g. "22:00-06:00", in which
+	// the model is downloaded. Outside of them, the pull waits.
+	Window string `json:"window,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 }
@@ -388,6 +652,10 @@ type PushRequest struct {
 	Password string `json:"password"`
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// Sign, if true, signs the manifest of the model with the key of the
+	// server and pushes the signature with it.
+	Sign bool `json:"sign,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 }
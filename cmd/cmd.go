@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
@@ -18,6 +19,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -111,11 +113,32 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		req.Quantize = quantize
 	}
 
+	req.QuantizeMixed, _ = cmd.Flags().GetBool("mixed")
+	req.MergeAdapters, _ = cmd.Flags().GetBool("merge-adapters")
+	req.MergeMethod, _ = cmd.Flags().GetString("merge-method")
+	req.MergeDensity, _ = cmd.Flags().GetFloat32("merge-density")
+	req.AdapterWeights, _ = cmd.Flags().GetFloat32Slice("adapter-weights")
+
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
 		return err
 	}
 
+	if imatrix, _ := cmd.Flags().GetString("imatrix"); imatrix != "" {
+		if req.Quantize == "" {
+			return errors.New("--imatrix requires --quantize")
+		}
+
+		digest, err := fileDigest(imatrix)
+		if err != nil {
+			return err
+		}
+
+		if req.Imatrix, err = createBlob(cmd, client, imatrix, digest, p); err != nil {
+			return err
+		}
+	}
+
 	if len(req.Files) > 0 {
 		fileMap := map[string]string{}
 		for f, digest := range req.Files {
@@ -148,6 +171,18 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 				p.Add(resp.Digest, bar)
 			}
 
+			bar.Set(resp.Completed)
+		} else if resp.Total > 0 {
+			bar, ok := bars[resp.Status]
+			if !ok {
+				spinner.Stop()
+
+				status = resp.Status
+				bar = progress.NewBar(status, resp.Total, resp.Completed)
+				bars[status] = bar
+				p.Add(status, bar)
+			}
+
 			bar.Set(resp.Completed)
 		} else if status != resp.Status {
 			spinner.Stop()
@@ -218,6 +253,22 @@ func createBlob(cmd *cobra.Command, client *api.Client, path string, digest stri
 	return digest, nil
 }
 
+// fileDigest returns the sha256 digest of the file at path
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
 type progressWriter struct {
 	n atomic.Int64
 }
@@ -384,6 +435,11 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	sign, err := cmd.Flags().GetBool("sign")
+	if err != nil {
+		return err
+	}
+
 	p := progress.NewProgress(os.Stderr)
 	defer p.Stop()
 
@@ -418,7 +474,7 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PushRequest{Name: args[0], Insecure: insecure}
+	request := api.PushRequest{Name: args[0], Insecure: insecure, Sign: sign}
 
 	n := model.ParseName(args[0])
 	if err := client.Push(cmd.Context(), &request, fn); err != nil {
@@ -770,12 +826,381 @@ func CopyHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func ExportHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+
+	f := os.Stdout
+	if output == "" || output == "-" {
+		if term.IsTerminal(int(f.Fd())) {
+			return errors.New("refusing to write a bundle to a terminal, use --output")
+		}
+	} else {
+		f, err = os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	var bar *progress.Bar
+	fn := func(resp api.ProgressResponse) error {
+		if bar == nil {
+			bar = progress.NewBar(fmt.Sprintf("exporting %s...", strings.Join(args, ", ")), resp.Total, resp.Completed)
+			p.Add("", bar)
+		}
+		bar.Set(resp.Completed)
+		return nil
+	}
+
+	if err := client.Export(cmd.Context(), &api.ExportRequest{Models: args}, f, fn); err != nil {
+		if f != os.Stdout {
+			// don't leave a truncated bundle behind
+			f.Close()
+			os.Remove(f.Name())
+		}
+		return err
+	}
+
+	if f == os.Stdout {
+		return nil
+	}
+	return f.Close()
+}
+
+// progressReader sets bar to the number of bytes read from r.
+type progressReader struct {
+	r   io.Reader
+	n   int64
+	bar *progress.Bar
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	r.bar.Set(r.n)
+	return n, err
+}
+
+func ImportHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	bar := progress.NewBar(fmt.Sprintf("importing %s...", filepath.Base(args[0])), fi.Size(), 0)
+	p.Add("", bar)
+
+	resp, err := client.Import(cmd.Context(), &api.ImportRequest{Force: force}, &progressReader{r: f, bar: bar})
+	p.Stop()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range resp.Models {
+		fmt.Printf("imported '%s'\n", m)
+	}
+	return nil
+}
+
+func GCHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	resp, err := client.GC(cmd.Context(), &api.GCRequest{DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+
+	if resp.Blobs == 0 && resp.Partials == 0 {
+		fmt.Println("nothing to remove")
+		return nil
+	}
+
+	summary := fmt.Sprintf("%d unused blobs and %d partial downloads", resp.Blobs, resp.Partials)
+	if dryRun {
+		fmt.Printf("%s, %s reclaimable\n", summary, format.HumanBytes(resp.Reclaimable))
+	} else {
+		fmt.Printf("removed %s, %s reclaimed\n", summary, format.HumanBytes(resp.Reclaimable))
+	}
+	return nil
+}
+
+func DiskUsageHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GC(cmd.Context(), &api.GCRequest{DryRun: true})
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, m := range resp.Models {
+		if len(args) == 0 || strings.HasPrefix(strings.ToLower(m.Name), strings.ToLower(args[0])) {
+			data = append(data, []string{m.Name, format.HumanBytes(m.Size), format.HumanBytes(m.Unique), format.HumanBytes(m.Shared)})
+		}
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"NAME", "SIZE", "UNIQUE", "SHARED"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	fmt.Printf("\ntotal %s", format.HumanBytes(resp.Size))
+	if resp.Reclaimable > 0 {
+		fmt.Printf(", %s reclaimable with ollama gc", format.HumanBytes(resp.Reclaimable))
+	}
+	fmt.Println()
+	return nil
+}
+
+func VerifyHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	repair, _ := cmd.Flags().GetBool("repair")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	bars := make(map[string]*progress.Bar)
+
+	var status string
+	var spinner *progress.Spinner
+	var problems []api.VerifyProblem
+
+	fn := func(resp api.VerifyResponse) error {
+		if resp.Digest != "" {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			// blobs are verified, and pulled again when
+			// repaired, under different statuses
+			bar, ok := bars[resp.Status]
+			if !ok {
+				bar = progress.NewBar(resp.Status+"...", resp.Total, resp.Completed)
+				bars[resp.Status] = bar
+				p.Add(resp.Status, bar)
+			}
+
+			bar.Set(resp.Completed)
+		} else if resp.Status == "success" {
+			problems = resp.Problems
+		} else if status != resp.Status {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			status = resp.Status
+			spinner = progress.NewSpinner(status)
+			p.Add(status, spinner)
+		}
+
+		return nil
+	}
+
+	if err := client.Verify(cmd.Context(), &api.VerifyRequest{Models: args, Repair: repair, Insecure: insecure}, fn); err != nil {
+		return err
+	}
+	p.Stop()
+
+	var unrepaired int
+	for _, pr := range problems {
+		what := pr.Model
+		if pr.Digest != "" {
+			what += " " + pr.Digest
+		}
+		if pr.Repaired {
+			fmt.Printf("%s: %s (repaired)\n", what, pr.Error)
+		} else {
+			fmt.Printf("%s: %s\n", what, pr.Error)
+			unrepaired++
+		}
+	}
+
+	switch {
+	case len(problems) == 0:
+		fmt.Println("no problems found")
+	case unrepaired == 0:
+		fmt.Println("all problems repaired")
+	case repair:
+		return fmt.Errorf("%d problems could not be repaired", unrepaired)
+	default:
+		return fmt.Errorf("%d problems found, run with --repair to pull the models again", unrepaired)
+	}
+	return nil
+}
+
+func MergeHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	method, _ := cmd.Flags().GetString("method")
+	base, _ := cmd.Flags().GetString("base")
+	merge := api.MergeRequest{Method: method, Base: base}
+
+	// task_arithmetic weighs the difference of FROM from the base too
+	merged := args[2:]
+	if method == "task_arithmetic" {
+		merged = args[1:]
+	}
+
+	weights, _ := cmd.Flags().GetStringArray("weight")
+	for _, name := range merged {
+		var ms []api.MergeModel
+		for _, w := range weights {
+			m, err := parseMergeWeight(w)
+			if err != nil {
+				return err
+			} else if m.Model == name {
+				ms = append(ms, m)
+			}
+		}
+
+		// FROM is only listed with a weight, it's 1 otherwise
+		if len(ms) == 0 && name != args[1] {
+			ms = append(ms, api.MergeModel{Model: name})
+		}
+
+		merge.Models = append(merge.Models, ms...)
+	}
+
+	for _, w := range weights {
+		if m, _ := parseMergeWeight(w); !slices.Contains(merged, m.Model) {
+			return fmt.Errorf("--weight %s is for a model which isn't merged", w)
+		}
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	status := "gathering model components"
+	spinner := progress.NewSpinner(status)
+	p.Add(status, spinner)
+
+	bars := make(map[string]*progress.Bar)
+	fn := func(resp api.ProgressResponse) error {
+		if resp.Total > 0 {
+			bar, ok := bars[resp.Status]
+			if !ok {
+				spinner.Stop()
+
+				status = resp.Status
+				bar = progress.NewBar(status, resp.Total, resp.Completed)
+				bars[status] = bar
+				p.Add(status, bar)
+			}
+
+			bar.Set(resp.Completed)
+		} else if status != resp.Status {
+			spinner.Stop()
+
+			status = resp.Status
+			spinner = progress.NewSpinner(status)
+			p.Add(status, spinner)
+		}
+
+		return nil
+	}
+
+	return client.Create(cmd.Context(), &api.CreateRequest{Model: args[0], From: args[1], Merge: &merge}, fn)
+}
+
+// parseMergeWeight parses the weight of a merged model given as MODEL=WEIGHT,
+// or MODEL@FIRST-LAST=WEIGHT for a range of layers
+func parseMergeWeight(s string) (api.MergeModel, error) {
+	name, value, ok := cutLast(s, "=")
+	if !ok {
+		return api.MergeModel{}, fmt.Errorf("invalid weight %q, expected MODEL=WEIGHT", s)
+	}
+
+	w, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return api.MergeModel{}, fmt.Errorf("invalid weight %q: %w", s, err)
+	}
+
+	weight := float32(w)
+	m := api.MergeModel{Model: name, Weight: &weight}
+	if name, layers, ok := cutLast(name, "@"); ok {
+		var first, last int
+		if _, err := fmt.Sscanf(layers, "%d-%d", &first, &last); err != nil {
+			return api.MergeModel{}, fmt.Errorf("invalid layers in weight %q", s)
+		}
+
+		m.Model = name
+		m.Layers = []int{first, last}
+	}
+
+	return m, nil
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
 func PullHandler(cmd *cobra.Command, args []string) error {
 	insecure, err := cmd.Flags().GetBool("insecure")
 	if err != nil {
 		return err
 	}
 
+	// limit-rate and at are only defined by pull, not by run, which
+	// pulls missing models too
+	var limitRate int64
+	if s, _ := cmd.Flags().GetString("limit-rate"); s != "" {
+		limitRate, err = format.ParseBytes(s)
+		if err != nil {
+			return fmt.Errorf("invalid --limit-rate: %w", err)
+		}
+	}
+	window, _ := cmd.Flags().GetString("at")
+
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
 		return err
@@ -816,7 +1241,7 @@ func PullHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PullRequest{Name: args[0], Insecure: insecure}
+	request := api.PullRequest{Name: args[0], Insecure: insecure, LimitRate: limitRate, Window: window}
 	if err := client.Pull(cmd.Context(), &request, fn); err != nil {
 		return err
 	}
@@ -1223,6 +1648,141 @@ func NewCLI() *cobra.Command {
 
 	createCmd.Flags().StringP("file", "f", "", "Name of the Modelfile (default \"Modelfile\"")
 	createCmd.Flags().StringP("quantize", "q", "", "Quantize model to this level (e.g. q4_0)")
+	createCmd.Flags().String("imatrix", "", "Calibration text used to compute an importance matrix when quantizing")
+	createCmd.Flags().Bool("mixed", false, "Keep attention and output weights at 8 bits when quantizing")
+	createCmd.Flags().Bool("merge-adapters", false, "Merge adapters into the model's weights")
+	createCmd.Flags().String("merge-method", "", "Method of merging several adapters (linear or ties)")
+	createCmd.Flags().Float32("merge-density", 0, "Fraction of each adapter's updates kept by ties merging (default 0.5)")
+	createCmd.Flags().Float32Slice("adapter-weights", nil, "Weights of the merged adapters, in order")
+
+	mergeCmd := &cobra.Command{
+		Use:   "merge MODEL FROM MERGE [MERGE...]",
+		Short: "Create a model by merging local models",
+		Long: `Create a model by merging local models with the same architecture.
+
+FROM is the model the others are merged into. Weights are given for each merged
+model as MODEL=WEIGHT, or MODEL@FIRST-LAST=WEIGHT for a range of layers. With
+task_arithmetic, FROM can be given a weight too.`,
+		Example: `  ollama merge my-model instruct-model code-model --weight code-model=0.3
+  ollama merge my-model instruct-model code-model --method slerp --weight code-model@0-15=0.2 --weight code-model@16-31=0.6
+  ollama merge my-model instruct-model code-model math-model --method task_arithmetic --base base-model --weight instruct-model=0.5`,
+		Args:    cobra.MinimumNArgs(3),
+		PreRunE: checkServerHeartbeat,
+		RunE:    MergeHandler,
+	}
+
+	mergeCmd.Flags().String("method", "linear", "Merge method (linear, slerp or task_arithmetic)")
+	mergeCmd.Flags().String("base", "", "Base model the differences of task_arithmetic are taken from")
+	mergeCmd.Flags().StringArrayP("weight", "w", nil, "Weight of a merged model (MODEL=WEIGHT or MODEL@FIRST-LAST=WEIGHT)")
+
+	ggufCmd := &cobra.Command{
+		Use:   "gguf",
+		Short: "Inspect and edit GGUF files",
+		Long: `Inspect and edit GGUF files.
+
+Each FILE is either the path of a GGUF file or the name of a local model, in
+which case its model weights are used.`,
+	}
+
+	ggufInspectCmd := &cobra.Command{
+		Use:   "inspect FILE",
+		Short: "Show the metadata and tensors of a GGUF file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GGUFInspectHandler,
+	}
+
+	ggufInspectCmd.Flags().Bool("all", false, "Show every element of arrays")
+	ggufInspectCmd.Flags().Bool("json", false, "Output as JSON")
+
+	ggufSetKVCmd := &cobra.Command{
+		Use:   "set-kv FILE KEY VALUE",
+		Short: "Set a metadata key of a GGUF file",
+		Long: `Set a metadata key of a GGUF file.
+
+The value keeps the type of the existing key unless --type is set. New keys are
+strings by default. Arrays are given as JSON, e.g. --type '[]string' '["a", "b"]'.`,
+		Example: `  ollama gguf set-kv model.gguf general.name my-model
+  ollama gguf set-kv model.gguf llama.context_length 8192 --type uint32
+  ollama gguf set-kv llama3.2 general.name my-model -o model.gguf`,
+		Args: cobra.ExactArgs(3),
+		RunE: GGUFSetKVHandler,
+	}
+
+	ggufSetKVCmd.Flags().String("type", "", "Type of the value, e.g. string, uint32, float32, bool or []string")
+	ggufSetKVCmd.Flags().StringP("output", "o", "", "Write the edited file to this path instead of replacing FILE")
+
+	ggufRemoveKVCmd := &cobra.Command{
+		Use:   "rm-kv FILE KEY [KEY...]",
+		Short: "Remove metadata keys from a GGUF file",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  GGUFRemoveKVHandler,
+	}
+
+	ggufRemoveKVCmd.Flags().StringP("output", "o", "", "Write the edited file to this path instead of replacing FILE")
+
+	ggufExtractTensorCmd := &cobra.Command{
+		Use:   "extract-tensor FILE TENSOR",
+		Short: "Write the data of a tensor in a GGUF file",
+		Args:  cobra.ExactArgs(2),
+		RunE:  GGUFExtractTensorHandler,
+	}
+
+	ggufExtractTensorCmd.Flags().StringP("output", "o", "", "Path to write the tensor data to instead of stdout")
+	ggufExtractTensorCmd.Flags().Bool("f32", false, "Dequantize the data to little endian float32s")
+
+	ggufDiffCmd := &cobra.Command{
+		Use:   "diff FILE FILE",
+		Short: "Compare the metadata and tensors of two GGUF files",
+		Args:  cobra.ExactArgs(2),
+		RunE:  GGUFDiffHandler,
+	}
+
+	ggufDiffCmd.Flags().Bool("data", false, "Compare the data of tensors too")
+
+	ggufCmd.AddCommand(ggufInspectCmd, ggufSetKVCmd, ggufRemoveKVCmd, ggufExtractTensorCmd, ggufDiffCmd)
+
+	registryCmd := &cobra.Command{
+		Use:   "registry",
+		Short: "Host a model registry",
+	}
+
+	registryServeCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a registry that models can be pushed to and pulled from",
+		Long: `Serve a registry that models can be pushed to and pulled from.
+
+Models are pushed to and pulled from the registry by prefixing their names with
+its address, e.g. ollama push 192.168.1.2:5000/team/mymodel. Use --insecure
+with push and pull unless the registry is served with TLS. --url is the URL
+clients reach the registry at, e.g. http://192.168.1.2:5000, which is what
+they sign to authenticate.
+
+Pushing requires a key listed in --authorized-keys, which uses the format of
+ssh's authorized_keys, e.g. the contents of ~/.ollama/id_ed25519.pub of each
+user. Without authorized keys anyone can push.
+
+With --mirror, the registry is instead a read-only mirror of another registry,
+such as https://registry.ollama.ai. Models are fetched from it the first time
+they are pulled, and served from --dir after that, with each blob downloaded
+only once however many clients pull it at the same time. Point Ollama at the
+mirror by setting OLLAMA_REGISTRY_MIRROR=http://<addr> for ollama serve;
+models keep their usual names. Use --max-size, e.g. --max-size 500GB, to limit
+the size of the models kept, evicting the least recently pulled first.`,
+		Args: cobra.ExactArgs(0),
+		RunE: RegistryServeHandler,
+	}
+
+	registryServeCmd.Flags().String("addr", "127.0.0.1:5000", "Address to listen on")
+	registryServeCmd.Flags().String("url", "", "URL clients reach the registry at (e.g. https://registry.example.com)")
+	registryServeCmd.Flags().String("dir", "", "Directory models are stored in (default ~/.ollama/registry)")
+	registryServeCmd.Flags().String("authorized-keys", "", "Path of a file with the public keys allowed to push")
+	registryServeCmd.Flags().Bool("private", false, "Require an authorized key to pull too")
+	registryServeCmd.Flags().String("tls-cert", "", "Path of a TLS certificate to serve the registry with")
+	registryServeCmd.Flags().String("tls-key", "", "Path of the key of the TLS certificate")
+	registryServeCmd.Flags().String("mirror", "", "URL of a registry to mirror (e.g. https://registry.ollama.ai)")
+	registryServeCmd.Flags().String("max-size", "", "Maximum size of the models kept by a mirror (e.g. 500GB)")
+
+	registryCmd.AddCommand(registryServeCmd)
 
 	showCmd := &cobra.Command{
 		Use:     "show MODEL",
@@ -1278,6 +1838,8 @@ func NewCLI() *cobra.Command {
 	}
 
 	pullCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pullCmd.Flags().String("limit-rate", "", "Maximum download rate in bytes per second (e.g. 10MB)")
+	pullCmd.Flags().String("at", "", "Only download during these hours, pausing outside of them (e.g. 22:00-06:00)")
 
 	pushCmd := &cobra.Command{
 		Use:     "push MODEL",
@@ -1288,6 +1850,7 @@ func NewCLI() *cobra.Command {
 	}
 
 	pushCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pushCmd.Flags().Bool("sign", false, "Sign the model with your key")
 
 	listCmd := &cobra.Command{
 		Use:     "list",
@@ -1312,6 +1875,68 @@ func NewCLI() *cobra.Command {
 		RunE:    CopyHandler,
 	}
 
+	exportCmd := &cobra.Command{
+		Use:   "export MODEL [MODEL...]",
+		Short: "Export models to a file",
+		Long: `Export models to a self-contained file that ollama import adds to another
+machine, e.g. one without internet access. The file is a tarball in the OCI
+image layout, holding the models and every blob they use. Blobs shared by the
+models are only written once.`,
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    ExportHandler,
+	}
+
+	exportCmd.Flags().StringP("output", "o", "", "File to write the models to (default stdout)")
+
+	importCmd := &cobra.Command{
+		Use:     "import FILE",
+		Short:   "Import models from a file written by export",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    ImportHandler,
+	}
+
+	importCmd.Flags().Bool("force", false, "Replace models that already exist")
+
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove unused blobs and partial downloads",
+		Long: `Remove the blobs not used by any model, and the files left behind by
+interrupted downloads. Files written in the last hour are kept, so that pulls
+and creates in progress are not disturbed.`,
+		Args:    cobra.NoArgs,
+		PreRunE: checkServerHeartbeat,
+		RunE:    GCHandler,
+	}
+
+	gcCmd.Flags().Bool("dry-run", false, "Only report what would be removed")
+
+	duCmd := &cobra.Command{
+		Use:   "du [MODEL]",
+		Short: "Show disk usage of models",
+		Long: `Show the disk usage of models. UNIQUE is the size of the layers used by no
+other model, which removing the model frees, and SHARED the size of the layers
+it shares with other models.`,
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    DiskUsageHandler,
+	}
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify [MODEL...]",
+		Short: "Check models for corrupted files",
+		Long: `Check the files of models, or of all models if none are given, for
+corruption: every blob is read and checked against its digest, and model files
+are checked for valid headers. With --repair, models with corrupted or missing
+files are pulled again.`,
+		PreRunE: checkServerHeartbeat,
+		RunE:    VerifyHandler,
+	}
+
+	verifyCmd.Flags().Bool("repair", false, "Pull models with problems again")
+	verifyCmd.Flags().Bool("insecure", false, "Use an insecure registry to repair models")
+
 	deleteCmd := &cobra.Command{
 		Use:     "rm MODEL [MODEL...]",
 		Short:   "Remove a model",
@@ -1338,7 +1963,9 @@ func NewCLI() *cobra.Command {
 
 	for _, cmd := range []*cobra.Command{
 		createCmd,
+		mergeCmd,
 		showCmd,
+		ggufCmd,
 		runCmd,
 		stopCmd,
 		pullCmd,
@@ -1346,12 +1973,19 @@ func NewCLI() *cobra.Command {
 		listCmd,
 		psCmd,
 		copyCmd,
+		exportCmd,
+		importCmd,
+		gcCmd,
+		duCmd,
+		verifyCmd,
 		deleteCmd,
 		serveCmd,
 	} {
 		switch cmd {
 		case runCmd:
 			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_HOST"], envVars["OLLAMA_NOHISTORY"]})
+		case ggufCmd:
+			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_MODELS"]})
 		case serveCmd:
 			appendEnvDocs(cmd, []envconfig.EnvVar{
 				envVars["OLLAMA_DEBUG"],
@@ -1363,8 +1997,12 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
+				envVars["OLLAMA_REGISTRY_MIRROR"],
 				envVars["OLLAMA_SCHED_SPREAD"],
 				envVars["OLLAMA_TMPDIR"],
+				envVars["OLLAMA_TRUSTED_KEYS"],
+				envVars["OLLAMA_MAX_DOWNLOAD_RATE"],
+				envVars["OLLAMA_DOWNLOAD_WINDOW"],
 				envVars["OLLAMA_FLASH_ATTENTION"],
 				envVars["OLLAMA_KV_CACHE_TYPE"],
 				envVars["OLLAMA_LLM_LIBRARY"],
@@ -1379,7 +2017,9 @@ func NewCLI() *cobra.Command {
 	rootCmd.AddCommand(
 		serveCmd,
 		createCmd,
+		mergeCmd,
 		showCmd,
+		ggufCmd,
 		runCmd,
 		stopCmd,
 		pullCmd,
@@ -1387,7 +2027,13 @@ func NewCLI() *cobra.Command {
 		listCmd,
 		psCmd,
 		copyCmd,
+		exportCmd,
+		importCmd,
+		gcCmd,
+		duCmd,
+		verifyCmd,
 		deleteCmd,
+		registryCmd,
 		runnerCmd,
 	)
 
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -496,6 +499,7 @@ func TestPushHandler(t *testing.T) {
 
 			cmd := &cobra.Command{}
 			cmd.Flags().Bool("insecure", false, "")
+			cmd.Flags().Bool("sign", false, "")
 			cmd.SetContext(context.TODO())
 
 			// Redirect stderr to capture progress output
@@ -541,6 +545,93 @@ func TestPushHandler(t *testing.T) {
 	}
 }
 
+func TestExportImportHandler(t *testing.T) {
+	bundle := "a bundle of models"
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/export":
+			var req api.ExportRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Models[0] == "unknown" {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": `model "unknown" not found`})
+				return
+			}
+			if diff := cmp.Diff([]string{"a", "b"}, req.Models); diff != "" {
+				t.Errorf("models mismatch (-want +got):\n%s", diff)
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(bundle)))
+			io.WriteString(w, bundle)
+		case "/api/import":
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != bundle {
+				t.Errorf("imported %q; want %q", data, bundle)
+			}
+			if got := r.URL.Query().Get("force"); got != "true" {
+				t.Errorf("force = %q; want true", got)
+			}
+			json.NewEncoder(w).Encode(api.ImportResponse{Models: []string{"a:latest", "b:latest"}})
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	t.Setenv("OLLAMA_HOST", mockServer.URL)
+
+	output := filepath.Join(t.TempDir(), "models.tar")
+
+	cmd := &cobra.Command{}
+	cmd.Flags().StringP("output", "o", "", "")
+	cmd.Flags().Bool("force", false, "")
+	cmd.SetContext(t.Context())
+	if err := cmd.Flags().Set("output", output); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ExportHandler(cmd, []string{"unknown"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if _, err := os.Stat(output); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no output after a failed export, got %v", err)
+	}
+
+	if err := ExportHandler(cmd, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != bundle {
+		t.Fatalf("exported %q, %v; want %q", data, err, bundle)
+	}
+
+	if err := cmd.Flags().Set("force", "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := ImportHandler(cmd, []string{output})
+
+	w.Close()
+	os.Stdout = oldStdout
+	stdout, _ := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(stdout), "imported 'a:latest'\nimported 'b:latest'\n"; got != want {
+		t.Errorf("expected output %q, got %q", want, got)
+	}
+}
+
 func TestListHandler(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -886,3 +977,80 @@ func TestNewCreateRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeHandler(t *testing.T) {
+	var got api.CreateRequest
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/create" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(api.ProgressResponse{Status: "success"}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	t.Setenv("OLLAMA_HOST", mockServer.URL)
+	t.Cleanup(mockServer.Close)
+
+	newCmd := func(weights ...string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.SetContext(context.TODO())
+		cmd.Flags().String("method", "slerp", "")
+		cmd.Flags().String("base", "", "")
+		cmd.Flags().StringArray("weight", weights, "")
+		return cmd
+	}
+
+	if err := MergeHandler(newCmd("code:7b@0-15=0.2", "code:7b@16-31=0.6"), []string{"merged", "instruct", "code:7b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	low, high := float32(0.2), float32(0.6)
+	if diff := cmp.Diff(api.CreateRequest{
+		Model: "merged",
+		From:  "instruct",
+		Merge: &api.MergeRequest{
+			Method: "slerp",
+			Models: []api.MergeModel{
+				{Model: "code:7b", Weight: &low, Layers: []int{0, 15}},
+				{Model: "code:7b", Weight: &high, Layers: []int{16, 31}},
+			},
+		},
+	}, got); diff != "" {
+		t.Errorf("request mismatch (-want +got):\n%s", diff)
+	}
+
+	// task_arithmetic takes a weight for FROM
+	taskArithmetic := newCmd("instruct=0.5")
+	taskArithmetic.Flags().Set("method", "task_arithmetic")
+	taskArithmetic.Flags().Set("base", "base")
+	got = api.CreateRequest{}
+	if err := MergeHandler(taskArithmetic, []string{"merged", "instruct", "code:7b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	half := float32(0.5)
+	if diff := cmp.Diff(&api.MergeRequest{
+		Method: "task_arithmetic",
+		Base:   "base",
+		Models: []api.MergeModel{
+			{Model: "instruct", Weight: &half},
+			{Model: "code:7b"},
+		},
+	}, got.Merge); diff != "" {
+		t.Errorf("request mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, weights := range [][]string{{"code:7b"}, {"code:7b=high"}, {"code:7b@0=0.5"}, {"other=0.5"}, {"instruct=0.5"}} {
+		if err := MergeHandler(newCmd(weights...), []string{"merged", "instruct", "code:7b"}); err == nil {
+			t.Errorf("expected error for %v", weights)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/server"
+)
+
+// ggufPath returns the path of the GGUF file name, which is either a file or
+// a local model whose model blob is used. model is true for models.
+func ggufPath(name string) (path string, model bool, err error) {
+	if _, err := os.Stat(name); err == nil {
+		return name, false, nil
+	}
+
+	m, err := server.GetModel(name)
+	if err != nil {
+		return "", false, fmt.Errorf("%s is neither a file nor a local model: %w", name, err)
+	}
+
+	return m.ModelPath, true, nil
+}
+
+func openGGUF(name string) (*os.File, error) {
+	path, _, err := ggufPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(path)
+}
+
+func GGUFInspectHandler(cmd *cobra.Command, args []string) error {
+	f, err := openGGUF(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	all, _ := cmd.Flags().GetBool("all")
+	maxArraySize, n := 0, 8
+	if all {
+		maxArraySize, n = -1, -1
+	}
+
+	g, _, err := ggml.Decode(f, maxArraySize)
+	if err != nil {
+		return err
+	}
+
+	kv := g.KV()
+	keys := slices.Sorted(maps.Keys(kv))
+
+	tensors := g.Tensors()
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		type tensor struct {
+			Name   string   `json:"name"`
+			Type   string   `json:"type"`
+			Shape  []uint64 `json:"shape"`
+			Offset uint64   `json:"offset"`
+			Size   uint64   `json:"size"`
+		}
+
+		var out struct {
+			Format   string         `json:"format"`
+			Metadata map[string]any `json:"metadata"`
+			Tensors  []tensor       `json:"tensors"`
+		}
+
+		out.Format = g.Name()
+		out.Metadata = kv
+		for _, t := range tensors.Items() {
+			out.Tensors = append(out.Tensors, tensor{Name: t.Name, Type: t.Type(), Shape: t.Shape, Offset: tensors.Offset + t.Offset, Size: t.Size()})
+		}
+
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(out)
+	}
+
+	newTable := func(header ...string) *tablewriter.Table {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetHeaderLine(false)
+		table.SetBorder(false)
+		table.SetNoWhiteSpace(true)
+		table.SetTablePadding("    ")
+		table.SetAutoWrapText(false)
+		return table
+	}
+
+	fmt.Printf("Format: %s, %d key-value pairs, %d tensors\n\n", g.Name(), len(kv), len(tensors.Items()))
+
+	table := newTable("KEY", "TYPE", "VALUE")
+	for _, k := range keys {
+		table.Append([]string{k, ggml.TypeOf(kv[k]), ggml.FormatValue(kv[k], n)})
+	}
+	table.Render()
+	fmt.Println()
+
+	table = newTable("NAME", "TYPE", "SHAPE", "OFFSET", "SIZE")
+	for _, t := range tensors.Items() {
+		table.Append([]string{t.Name, t.Type(), fmt.Sprint(t.Shape), strconv.FormatUint(tensors.Offset+t.Offset, 10), format.HumanBytes2(t.Size())})
+	}
+	table.Render()
+
+	return nil
+}
+
+// editGGUF rewrites the GGUF file or model name with its metadata changed by
+// edit. Files are replaced unless --output is set but models, whose blobs are
+// addressed by their digest, must be written to --output.
+func editGGUF(cmd *cobra.Command, name string, edit func(ggml.KV) error) error {
+	path, model, err := ggufPath(name)
+	if err != nil {
+		return err
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+	if model && output == "" {
+		return fmt.Errorf("%s is a model, use --output to write the edited file", name)
+	}
+
+	r, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dir := filepath.Dir(path)
+	if output != "" {
+		dir = filepath.Dir(output)
+	}
+
+	w, err := os.CreateTemp(dir, ".gguf-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(w.Name())
+	defer w.Close()
+
+	if fi, err := r.Stat(); err == nil {
+		if err := w.Chmod(fi.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	if err := ggml.EditKV(w, r, edit); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(w.Name(), cmp.Or(output, path))
+}
+
+func GGUFSetKVHandler(cmd *cobra.Command, args []string) error {
+	typ, _ := cmd.Flags().GetString("type")
+	return editGGUF(cmd, args[0], func(kv ggml.KV) error {
+		t := typ
+		if t == "" {
+			t = "string"
+			if v, ok := kv[args[1]]; ok {
+				t = ggml.TypeOf(v)
+			}
+		}
+
+		v, err := ggml.ParseValue(t, args[2])
+		if err != nil {
+			return err
+		}
+
+		kv[args[1]] = v
+		return nil
+	})
+}
+
+func GGUFRemoveKVHandler(cmd *cobra.Command, args []string) error {
+	return editGGUF(cmd, args[0], func(kv ggml.KV) error {
+		for _, k := range args[1:] {
+			if _, ok := kv[k]; !ok {
+				return fmt.Errorf("key %s not found", k)
+			}
+
+			delete(kv, k)
+		}
+
+		return nil
+	})
+}
+
+func GGUFExtractTensorHandler(cmd *cobra.Command, args []string) error {
+	f, err := openGGUF(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	f32, _ := cmd.Flags().GetBool("f32")
+	output, _ := cmd.Flags().GetString("output")
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		o, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer o.Close()
+		w = o
+	} else if term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("refusing to write tensor data to a terminal, use --output or redirect the output")
+	}
+
+	_, err = ggml.ExtractTensor(w, f, args[1], f32)
+	return err
+}
+
+func GGUFDiffHandler(cmd *cobra.Command, args []string) error {
+	a, err := openGGUF(args[0])
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	b, err := openGGUF(args[1])
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	data, _ := cmd.Flags().GetBool("data")
+	diffs, err := ggml.Diff(a, b, data)
+	if err != nil {
+		return err
+	}
+
+	describe := func(v any) string {
+		if t, ok := v.(*ggml.Tensor); ok {
+			return fmt.Sprintf("%s %v", t.Type(), t.Shape)
+		}
+
+		return fmt.Sprintf("%s %s", ggml.TypeOf(v), ggml.FormatValue(v, 8))
+	}
+
+	for _, d := range diffs {
+		name := d.Name
+		if d.Tensor {
+			name = "tensor " + name
+		}
+
+		switch {
+		case d.Data:
+			fmt.Printf("~ %s: data differs\n", name)
+		case d.B == nil:
+			fmt.Printf("- %s: %s\n", name, describe(d.A))
+		case d.A == nil:
+			fmt.Printf("+ %s: %s\n", name, describe(d.B))
+		default:
+			fmt.Printf("~ %s: %s => %s\n", name, describe(d.A), describe(d.B))
+		}
+	}
+
+	if len(diffs) == 0 {
+		fmt.Println("no differences")
+	}
+
+	return nil
+}
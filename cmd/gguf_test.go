@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestGGUFEditHandlers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ggml.WriteGGUF(f, ggml.KV{
+		"general.architecture": "llama",
+		"general.name":         "a",
+		"llama.block_count":    uint32(1),
+	}, []ggml.Tensor{
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	newCmd := func(typ string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("type", typ, "")
+		cmd.Flags().StringP("output", "o", "", "")
+		return cmd
+	}
+
+	if err := GGUFSetKVHandler(newCmd(""), []string{path, "llama.block_count", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GGUFSetKVHandler(newCmd("float32"), []string{path, "llama.rope.freq_base", "500000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GGUFSetKVHandler(newCmd(""), []string{path, "llama.block_count", "many"}); err == nil {
+		t.Error("expected error setting an invalid uint32")
+	}
+
+	if err := GGUFRemoveKVHandler(newCmd(""), []string{path, "general.name"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GGUFRemoveKVHandler(newCmd(""), []string{path, "general.name"}); err == nil {
+		t.Error("expected error removing a missing key")
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv := g.KV()
+	if v, ok := kv["llama.block_count"].(uint32); !ok || v != 2 {
+		t.Errorf("expected llama.block_count uint32 2, got %T %v", kv["llama.block_count"], kv["llama.block_count"])
+	}
+
+	if v, ok := kv["llama.rope.freq_base"].(float32); !ok || v != 500000 {
+		t.Errorf("expected llama.rope.freq_base float32 500000, got %T %v", kv["llama.rope.freq_base"], kv["llama.rope.freq_base"])
+	}
+
+	if _, ok := kv["general.name"]; ok {
+		t.Error("expected general.name to be removed")
+	}
+
+	if n := len(g.Tensors().Items()); n != 1 {
+		t.Errorf("expected 1 tensor, got %d", n)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), ".gguf-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) > 0 {
+		t.Errorf("expected temporary files to be removed, got %v", matches)
+	}
+}
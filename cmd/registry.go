@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/server"
+)
+
+func RegistryServeHandler(cmd *cobra.Command, _ []string) error {
+	addr, _ := cmd.Flags().GetString("addr")
+	u, _ := cmd.Flags().GetString("url")
+	dir, _ := cmd.Flags().GetString("dir")
+	keys, _ := cmd.Flags().GetString("authorized-keys")
+	private, _ := cmd.Flags().GetBool("private")
+	cert, _ := cmd.Flags().GetString("tls-cert")
+	key, _ := cmd.Flags().GetString("tls-key")
+	mirror, _ := cmd.Flags().GetString("mirror")
+	maxSize, _ := cmd.Flags().GetString("max-size")
+
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(home, ".ollama", "registry")
+	}
+
+	if u == "" {
+		return errors.New("--url is required, e.g. --url http://192.168.1.2:5000")
+	}
+
+	if (cert == "") != (key == "") {
+		return errors.New("--tls-cert and --tls-key must be set together")
+	}
+
+	var size int64
+	if maxSize != "" {
+		if mirror == "" {
+			return errors.New("--max-size requires --mirror")
+		}
+		var err error
+		size, err = format.ParseBytes(maxSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	err = server.ServeRegistry(ln, server.RegistryConfig{
+		Dir:            dir,
+		URL:            u,
+		AuthorizedKeys: keys,
+		Private:        private,
+		CertFile:       cert,
+		KeyFile:        key,
+		Mirror:         mirror,
+		MaxSize:        size,
+	})
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
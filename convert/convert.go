@@ -23,13 +23,21 @@ type TextParameters struct {
 }
 
 type AdapterParameters struct {
-	Alpha          uint32 `json:"lora_alpha"`
-	LoraLayers     uint32 `json:"lora_layers"`
+	Alpha          float32 `json:"lora_alpha"`
+	LoraLayers     uint32  `json:"lora_layers"`
 	LoraParameters struct {
 		Rank  uint32  `json:"rank"`
 		Alpha float32 `json:"alpha"`
 		Scale float32 `json:"scale"`
 	} `json:"lora_parameters"`
+
+	// PEFT configuration
+	Rank          uint32             `json:"r"`
+	UseRSLoRA     bool               `json:"use_rslora"`
+	UseDoRA       bool               `json:"use_dora"`
+	TargetModules targetModules      `json:"target_modules"`
+	RankPattern   map[string]uint32  `json:"rank_pattern"`
+	AlphaPattern  map[string]float32 `json:"alpha_pattern"`
 }
 
 func (ModelParameters) KV(t *Tokenizer) ggml.KV {
@@ -59,16 +67,23 @@ func (ModelParameters) KV(t *Tokenizer) ggml.KV {
 	return kv
 }
 
-func (p AdapterParameters) KV() ggml.KV {
-	var alpha float32
-	if p.LoraParameters.Alpha == 0 {
-		alpha = float32(p.Alpha)
-	} else {
-		alpha = p.LoraParameters.Alpha
+// alpha returns the LoRA alpha, which is divided by the rank of each weight to
+// scale its update
+func (p AdapterParameters) alpha() float32 {
+	switch {
+	case p.LoraParameters.Alpha > 0:
+		return p.LoraParameters.Alpha
+	case p.Alpha > 0:
+		return p.Alpha
+	default:
+		// MLX adapters may set the scale of updates directly
+		return p.LoraParameters.Scale * float32(p.LoraParameters.Rank)
 	}
+}
 
+func (p AdapterParameters) KV() ggml.KV {
 	kv := ggml.KV{
-		"adapter.lora.alpha": alpha,
+		"adapter.lora.alpha": p.alpha(),
 		"adapter.type":       "lora",
 		"general.file_type":  uint32(1),
 		"general.type":       "adapter",
@@ -111,6 +126,12 @@ type moreParser interface {
 	parseMore(fs.FS) error
 }
 
+// validator is implemented by converters which check the model can be
+// converted before anything is written
+type validator interface {
+	validate([]Tensor) error
+}
+
 type AdapterConverter interface {
 	// KV maps parameters to LLM key-values
 	KV(ggml.KV) ggml.KV
@@ -123,30 +144,24 @@ type AdapterConverter interface {
 	writeFile(io.WriteSeeker, ggml.KV, []ggml.Tensor) error
 }
 
-func ConvertAdapter(fsys fs.FS, ws io.WriteSeeker, baseKV ggml.KV) error {
+func ConvertAdapter(fsys fs.FS, ws io.WriteSeeker, baseKV ggml.KV, fn func(completed, total uint64)) error {
 	bts, err := fs.ReadFile(fsys, "adapter_config.json")
 	if err != nil {
 		return err
 	}
 
-	var p AdapterParameters
-	if err := json.Unmarshal(bts, &p); err != nil {
-		return err
+	if _, ok := baseKV["general.architecture"]; !ok {
+		return errors.New("architecture not set for the base model")
 	}
 
-	arch, ok := baseKV["general.architecture"]
-	if !ok {
-		return errors.New("architecture not set for the base model")
+	base, err := adapterBase(baseKV)
+	if err != nil {
+		return err
 	}
 
-	var conv AdapterConverter
-	switch arch {
-	case "llama":
-		conv = &llamaAdapter{}
-	case "gemma2":
-		conv = &gemma2Adapter{}
-	default:
-		return errors.New("unsupported architecture")
+	conv := &loraAdapter{base: base, baseKV: baseKV}
+	if err := json.Unmarshal(bts, conv); err != nil {
+		return err
 	}
 
 	ts, err := parseTensors(fsys, strings.NewReplacer(conv.Replacements()...))
@@ -154,18 +169,20 @@ func ConvertAdapter(fsys fs.FS, ws io.WriteSeeker, baseKV ggml.KV) error {
 		return err
 	}
 
-	if err := json.Unmarshal(bts, conv); err != nil {
+	if err := conv.validate(ts); err != nil {
 		return err
 	}
 
-	return conv.writeFile(ws, conv.KV(baseKV), conv.Tensors(ts))
+	return conv.writeFile(ws, conv.KV(baseKV), withProgress(conv.Tensors(ts), fn))
 }
 
 // Convert writes an Ollama compatible model to the provided io.WriteSeeker based on configurations
 // and files it finds in the input path.
 // Supported input model formats include safetensors.
 // Supported input tokenizers files include tokenizer.json (preferred) and tokenizer.model.
-func ConvertModel(fsys fs.FS, ws io.WriteSeeker) error {
+// fn, if not nil, is called after each tensor is written with the number of bytes
+// written so far and the expected total.
+func ConvertModel(fsys fs.FS, ws io.WriteSeeker, fn func(completed, total uint64)) error {
 	bts, err := fs.ReadFile(fsys, "config.json")
 	if err != nil {
 		return err
@@ -196,8 +213,30 @@ func ConvertModel(fsys fs.FS, ws io.WriteSeeker) error {
 		conv = &phi3Model{}
 	case "Qwen2ForCausalLM":
 		conv = &qwen2Model{}
-	case "BertModel":
-		conv = &bertModel{}
+	case "Qwen2MoeForCausalLM":
+		conv = &qwen2MoeModel{}
+	case "LlavaForConditionalGeneration":
+		conv = &pixtralModel{}
+	case "Qwen2VLForConditionalGeneration":
+		conv = &qwen2VLModel{}
+	case "Qwen2AudioForConditionalGeneration":
+		conv = &qwen2AudioModel{}
+	case "WhisperForConditionalGeneration":
+		conv = &whisperModel{}
+	case "DeepseekV2ForCausalLM", "DeepseekV3ForCausalLM":
+		conv = &deepseek2Model{}
+	case "GraniteForCausalLM":
+		conv = &graniteModel{}
+	case "OlmoForCausalLM", "Olmo2ForCausalLM":
+		conv = &olmoModel{Architecture: p.Architectures[0]}
+	case "StableLmForCausalLM":
+		conv = &stablelmModel{}
+	case "Starcoder2ForCausalLM":
+		conv = &starcoder2Model{}
+	case "FalconForCausalLM", "RWForCausalLM":
+		conv = &falconModel{}
+	case "BertModel", "BertForSequenceClassification":
+		conv = &bertModel{Architecture: p.Architectures[0]}
 	case "CohereForCausalLM":
 		conv = &commandrModel{}
 	default:
@@ -246,5 +285,39 @@ func ConvertModel(fsys fs.FS, ws io.WriteSeeker) error {
 		return err
 	}
 
-	return conv.writeFile(ws, conv.KV(t), conv.Tensors(ts))
+	if v, ok := conv.(validator); ok {
+		if err := v.validate(ts); err != nil {
+			return err
+		}
+	}
+
+	return conv.writeFile(ws, conv.KV(t), withProgress(conv.Tensors(ts), fn))
+}
+
+// withProgress wraps the writers of ts to call fn after each tensor is written
+func withProgress(ts []ggml.Tensor, fn func(completed, total uint64)) []ggml.Tensor {
+	if fn == nil {
+		return ts
+	}
+
+	var completed, total uint64
+	for _, t := range ts {
+		total += t.Size()
+	}
+
+	for i := range ts {
+		wt, size := ts[i].WriterTo, ts[i].Size()
+		ts[i].WriterTo = ggml.WriterFunc(func(w io.Writer) (int64, error) {
+			n, err := wt.WriteTo(w)
+			if err != nil {
+				return n, err
+			}
+
+			completed += size
+			fn(completed, total)
+			return n, nil
+		})
+	}
+
+	return ts
 }
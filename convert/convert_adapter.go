@@ -0,0 +1,483 @@
+package convert
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// loraAdapter converts LoRA adapters for any architecture with a
+// ModelConverter. Adapter weights are named after the base weights they apply
+// to and repacked the same way.
+type loraAdapter struct {
+	AdapterParameters
+	base   ModelConverter
+	baseKV ggml.KV
+}
+
+var _ AdapterConverter = (*loraAdapter)(nil)
+
+// adapterBase returns the converter of the base model's architecture,
+// configured from its key-values with what it needs to repack weights
+func adapterBase(kv ggml.KV) (ModelConverter, error) {
+	arch := kv.Architecture()
+	heads, _ := kv[arch+".attention.head_count"].(uint32)
+	headsKV, _ := kv[arch+".attention.head_count_kv"].(uint32)
+	embedding, _ := kv[arch+".embedding_length"].(uint32)
+
+	llama := llamaModel{NumAttentionHeads: heads, NumKeyValueHeads: headsKV}
+	switch arch {
+	case "llama", "granite", "olmo", "falcon":
+		// these repack the query and key weights by head
+		if heads == 0 {
+			return nil, fmt.Errorf("%s base model doesn't set the number of attention heads", arch)
+		}
+	}
+
+	switch arch {
+	case "llama":
+		return &llama, nil
+	case "granite":
+		return &graniteModel{llamaModel: llama}, nil
+	case "olmo":
+		return &olmoModel{llamaModel: llama, Architecture: "OlmoForCausalLM"}, nil
+	case "olmo2":
+		return &olmoModel{llamaModel: llama, Architecture: "Olmo2ForCausalLM"}, nil
+	case "falcon":
+		return &falconModel{HiddenSize: embedding, NumAttentionHeads: heads, NumKVHeads: headsKV}, nil
+	case "gemma":
+		return &gemmaModel{}, nil
+	case "gemma2":
+		return &gemma2Model{}, nil
+	case "gemma3":
+		return &gemma3Model{Architecture: "Gemma3ForCausalLM"}, nil
+	case "phi3":
+		return &phi3Model{}, nil
+	case "qwen2":
+		return &qwen2Model{}, nil
+	case "qwen2moe":
+		return &qwen2MoeModel{}, nil
+	case "qwen2vl":
+		return &qwen2VLModel{}, nil
+	case "deepseek2":
+		return &deepseek2Model{}, nil
+	case "stablelm":
+		return &stablelmModel{}, nil
+	case "starcoder2":
+		return &starcoder2Model{}, nil
+	case "bert":
+		return &bertModel{}, nil
+	case "command-r":
+		return &commandrModel{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported architecture for adapters: %s", arch)
+	}
+}
+
+func (p *loraAdapter) KV(baseKV ggml.KV) ggml.KV {
+	kv := p.AdapterParameters.KV()
+
+	arch := baseKV.Architecture()
+	kv["general.architecture"] = arch
+	for _, key := range []string{"attention.head_count", "attention.head_count_kv"} {
+		if v, ok := baseKV[arch+"."+key]; ok {
+			kv[arch+"."+key] = v
+		}
+	}
+
+	return kv
+}
+
+// Replacements only removes the prefix PEFT adds to tensor names. Module
+// names are kept until Tensors so they can be matched against the patterns
+// in the adapter configuration.
+func (p *loraAdapter) Replacements() []string {
+	return []string{
+		"base_model.model.", "",
+	}
+}
+
+// loraWeight is the low rank update of a single base weight
+type loraWeight struct {
+	// module is the name of the adapted module in the checkpoint and name is
+	// the name of its weight in the model
+	module, name string
+
+	a, b Tensor
+	// m is the DoRA magnitude of each output feature, if any
+	m Tensor
+
+	// the checkpoint stores a and b in the opposite orientation, i.e. MLX
+	// adapters which compute x·A·B
+	transposeA, transposeB bool
+}
+
+// rank returns the rank of the update, i.e. the inner dimension of b·a
+func (w loraWeight) rank() uint64 {
+	if w.transposeA {
+		return w.a.Shape()[1]
+	}
+
+	return w.a.Shape()[0]
+}
+
+// in and out return the dimensions of the base weight
+func (w loraWeight) in() uint64 {
+	if w.transposeA {
+		return w.a.Shape()[0]
+	}
+
+	return w.a.Shape()[1]
+}
+
+func (w loraWeight) out() uint64 {
+	if w.transposeB {
+		return w.b.Shape()[1]
+	}
+
+	return w.b.Shape()[0]
+}
+
+var loraSuffixes = []struct {
+	suffix string
+	part   byte
+}{
+	{".lora_A.weight", 'a'},
+	{".lora_B.weight", 'b'},
+	{".lora_a", 'a'},
+	{".lora_b", 'b'},
+	{".lora_magnitude_vector.weight", 'm'},
+	{".lora_magnitude_vector", 'm'},
+}
+
+// weights groups the tensors of the adapter by the base weight they update
+func (p *loraAdapter) weights(ts []Tensor) ([]*loraWeight, error) {
+	replacer := strings.NewReplacer(p.base.Replacements()...)
+
+	byModule := make(map[string]*loraWeight)
+	var ws []*loraWeight
+	for _, t := range ts {
+		var module string
+		var part byte
+		for _, s := range loraSuffixes {
+			if m, ok := strings.CutSuffix(t.Name(), s.suffix); ok {
+				module, part = m, s.part
+				break
+			}
+		}
+
+		switch {
+		case strings.Contains(t.Name(), "lora_embedding_"):
+			return nil, fmt.Errorf("%s: adapters of embeddings are not supported", t.Name())
+		case module == "":
+			// e.g. modules_to_save or trained biases which replace base weights
+			// rather than updating them
+			return nil, fmt.Errorf("%s: unexpected tensor in adapter, only LoRA weights are supported", t.Name())
+		}
+
+		w, ok := byModule[module]
+		if !ok {
+			w = &loraWeight{module: module, name: replacer.Replace(module + ".weight")}
+			if w.name == module+".weight" {
+				return nil, fmt.Errorf("%s: module isn't part of %s models", module, p.baseKV.Architecture())
+			}
+
+			byModule[module] = w
+			ws = append(ws, w)
+		}
+
+		switch part {
+		case 'a':
+			w.a = t
+		case 'b':
+			w.b = t
+		case 'm':
+			w.m = t
+		}
+	}
+
+	for _, w := range ws {
+		if w.a == nil || w.b == nil {
+			return nil, fmt.Errorf("%s: missing LoRA A or B weights", w.module)
+		} else if len(w.a.Shape()) != 2 || len(w.b.Shape()) != 2 {
+			return nil, fmt.Errorf("%s: LoRA weights must have two dimensions", w.module)
+		}
+
+		if r := p.rank(w.module); r > 0 {
+			w.transposeA = w.a.Shape()[0] != r && w.a.Shape()[1] == r
+			w.transposeB = w.b.Shape()[1] != r && w.b.Shape()[0] == r
+		} else {
+			// assume the rank is smaller than the dimensions of the weight
+			w.transposeA = w.a.Shape()[0] > w.a.Shape()[1]
+			w.transposeB = w.b.Shape()[0] < w.b.Shape()[1]
+		}
+	}
+
+	return ws, nil
+}
+
+// validate checks the adapter matches its configuration and the dimensions
+// of the base model
+func (p *loraAdapter) validate(ts []Tensor) error {
+	ws, err := p.weights(ts)
+	if err != nil {
+		return err
+	} else if len(ws) == 0 {
+		return errors.New("adapter has no LoRA weights")
+	}
+
+	arch := p.baseKV.Architecture()
+	blocks, _ := p.baseKV[arch+".block_count"].(uint32)
+	for _, w := range ws {
+		if !p.TargetModules.match(w.module) {
+			return fmt.Errorf("%s: module isn't one of the adapter's target modules", w.module)
+		}
+
+		r := w.rank()
+		if rank := p.rank(w.module); rank > 0 && r != rank {
+			return fmt.Errorf("%s: rank %d doesn't match rank %d of the adapter configuration", w.module, r, rank)
+		}
+
+		if w.transposeB && w.b.Shape()[0] != r || !w.transposeB && w.b.Shape()[1] != r {
+			return fmt.Errorf("%s: LoRA B weights with shape %v don't match rank %d", w.module, w.b.Shape(), r)
+		}
+
+		if w.m != nil && (len(w.m.Shape()) != 1 || w.m.Shape()[0] != w.out()) {
+			return fmt.Errorf("%s: DoRA magnitude with shape %v doesn't match %d output features", w.module, w.m.Shape(), w.out())
+		}
+
+		var block uint32
+		var module string
+		if _, err := fmt.Sscanf(w.name, "blk.%d.%s", &block, &module); err == nil && blocks > 0 && block >= blocks {
+			return fmt.Errorf("%s: layer %d is beyond the %d layers of the base model", w.module, block, blocks)
+		}
+
+		if in, out := p.baseShape(strings.TrimSuffix(module, ".weight")); in > 0 && w.in() != in || out > 0 && w.out() != out {
+			return fmt.Errorf("%s: update of shape %dx%d doesn't match the base model weight of shape %dx%d (hint: maybe wrong base model?)", w.module, w.out(), w.in(), out, in)
+		}
+	}
+
+	return nil
+}
+
+// baseShape returns the number of input and output features of a linear
+// layer of a base model block, or zero where they're unknown
+func (p *loraAdapter) baseShape(module string) (in, out uint64) {
+	arch := p.baseKV.Architecture()
+	value := func(key string, defaultValue uint64) uint64 {
+		if v, ok := p.baseKV[arch+"."+key].(uint32); ok {
+			return uint64(v)
+		}
+
+		return defaultValue
+	}
+
+	embedding := value("embedding_length", 0)
+	heads := value("attention.head_count", 0)
+	headsKV := value("attention.head_count_kv", heads)
+	feedForward := value("feed_forward_length", 0)
+
+	var headDim uint64
+	if heads > 0 {
+		headDim = embedding / heads
+	}
+
+	keyLength, valueLength := value("attention.key_length", headDim), value("attention.value_length", headDim)
+
+	switch module {
+	case "attn_q":
+		return embedding, heads * keyLength
+	case "attn_k":
+		return embedding, headsKV * keyLength
+	case "attn_v":
+		return embedding, headsKV * valueLength
+	case "attn_output":
+		return heads * valueLength, embedding
+	case "ffn_gate", "ffn_up":
+		return embedding, feedForward
+	case "ffn_down":
+		return feedForward, embedding
+	default:
+		return 0, 0
+	}
+}
+
+func (p *loraAdapter) Tensors(ts []Tensor) []ggml.Tensor {
+	// tensors have been validated before they're written
+	ws, _ := p.weights(ts)
+
+	var out []ggml.Tensor
+	for _, w := range ws {
+		r, in, outFeatures := w.rank(), w.in(), w.out()
+
+		a, b := w.a, w.b
+		a.SetRepacker(transposeRepacker(w.name, []uint64{r, in}, w.transposeA, nil, 1))
+		b.SetRepacker(transposeRepacker(w.name, []uint64{outFeatures, r}, w.transposeB,
+			p.baseRepacker(w.name, []uint64{outFeatures, r}), p.scale(w.module, r)))
+
+		out = append(out, ggml.Tensor{
+			Name:     w.name + ".lora_a",
+			Kind:     tensorKindF16,
+			Shape:    []uint64{r, in},
+			WriterTo: a,
+		}, ggml.Tensor{
+			Name:     w.name + ".lora_b",
+			Kind:     tensorKindF16,
+			Shape:    []uint64{outFeatures, r},
+			WriterTo: b,
+		})
+
+		if w.m != nil {
+			// the magnitude scales rows of the base weight so it is repacked
+			// like a weight with a single column
+			shape := []uint64{outFeatures, 1}
+			w.m.SetRepacker(transposeRepacker(w.name, shape, false, p.baseRepacker(w.name, shape), 1))
+
+			out = append(out, ggml.Tensor{
+				Name:     w.name + ".lora_m",
+				Kind:     tensorKindF32,
+				Shape:    []uint64{outFeatures},
+				WriterTo: w.m,
+			})
+		}
+	}
+
+	return out
+}
+
+// rank returns the rank the adapter configuration sets for module
+func (p *loraAdapter) rank(module string) uint64 {
+	if key, ok := matchPattern(p.RankPattern, module); ok {
+		return uint64(p.RankPattern[key])
+	}
+
+	return uint64(cmp.Or(p.Rank, p.LoraParameters.Rank))
+}
+
+// scale returns the factor by which to multiply the B weights of module so
+// that alpha / rank, as applied to all weights at runtime, gives the scale of
+// the adapter configuration
+func (p *loraAdapter) scale(module string, rank uint64) float32 {
+	scale := float32(1)
+	if key, ok := matchPattern(p.AlphaPattern, module); ok && p.alpha() > 0 {
+		scale = p.AlphaPattern[key] / p.alpha()
+	}
+
+	if p.UseRSLoRA {
+		// rsLoRA scales by alpha / sqrt(rank)
+		scale *= float32(math.Sqrt(float64(rank)))
+	}
+
+	return scale
+}
+
+// baseRepacker returns the repacker the base model converter applies to the
+// weight name, if any. Updates to the weight need the same repacking.
+func (p *loraAdapter) baseRepacker(name string, shape []uint64) repacker {
+	t := &adapterWeight{tensorBase: &tensorBase{name: name, shape: shape}}
+	p.base.Tensors([]Tensor{t})
+	return t.repacker
+}
+
+// adapterWeight stands in for a base model weight to find out how the base
+// model converter repacks it
+type adapterWeight struct {
+	*tensorBase
+}
+
+func (adapterWeight) WriteTo(io.Writer) (int64, error) {
+	return 0, errors.New("adapter weights can't be written")
+}
+
+// transposeRepacker returns a repacker for an update to the weight name. It
+// transposes data stored in the opposite orientation to shape, then repacks
+// it like the weight and scales it.
+func transposeRepacker(name string, shape []uint64, transpose bool, repack repacker, scale float32) repacker {
+	return func(_ string, data []float32, _ []uint64) ([]float32, error) {
+		if transpose {
+			rows, cols := int(shape[0]), int(shape[1])
+			f32s := make([]float32, len(data))
+			for i := range rows {
+				for j := range cols {
+					f32s[i*cols+j] = data[j*rows+i]
+				}
+			}
+			data = f32s
+		}
+
+		if repack != nil {
+			var err error
+			if data, err = repack(name, data, shape); err != nil {
+				return nil, err
+			}
+		}
+
+		if scale != 1 {
+			for i := range data {
+				data[i] *= scale
+			}
+		}
+
+		return data, nil
+	}
+}
+
+// targetModules is the target_modules of a PEFT adapter configuration, either
+// a list of module names or a regular expression matching them
+type targetModules struct {
+	names   []string
+	pattern *regexp.Regexp
+}
+
+func (t *targetModules) UnmarshalJSON(bts []byte) error {
+	var s string
+	if err := json.Unmarshal(bts, &s); err == nil {
+		if s == "all-linear" {
+			return nil
+		}
+
+		t.pattern, err = regexp.Compile("^(?:" + s + ")$")
+		return err
+	}
+
+	return json.Unmarshal(bts, &t.names)
+}
+
+func (t targetModules) match(module string) bool {
+	switch {
+	case t.pattern != nil:
+		return t.pattern.MatchString(module)
+	case len(t.names) > 0:
+		return slices.ContainsFunc(t.names, func(name string) bool {
+			return module == name || strings.HasSuffix(module, "."+name)
+		})
+	default:
+		return true
+	}
+}
+
+// matchPattern returns the first key of patterns, in sorted order, matching
+// the end of module like PEFT's rank_pattern and alpha_pattern
+func matchPattern[T any](patterns map[string]T, module string) (string, bool) {
+	keys := make([]string, 0, len(patterns))
+	for key := range patterns {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if re, err := regexp.Compile(`^(?:.*\.)?(?:` + key + `)$`); err == nil && re.MatchString(module) {
+			return key, true
+		}
+	}
+
+	return "", false
+}
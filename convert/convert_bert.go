@@ -12,6 +12,7 @@ import (
 )
 
 type bertModel struct {
+	Architecture string
 	ModelParameters
 	NLayers               uint32  `json:"n_layers"`
 	NumHiddenLayers       uint32  `json:"num_hidden_layers"`
@@ -37,7 +38,19 @@ var (
 	_ moreParser     = (*bertModel)(nil)
 )
 
+// isClassifier reports whether the checkpoint is a cross-encoder with a
+// sequence classification head, as used for reranking
+func (p *bertModel) isClassifier() bool {
+	return p.Architecture == "BertForSequenceClassification"
+}
+
 func (p *bertModel) parseMore(fsys fs.FS) error {
+	if p.isClassifier() {
+		// scores are computed from the classifier on the pooled CLS token
+		p.PoolingType = 4
+		return nil
+	}
+
 	bts, err := fs.ReadFile(fsys, "modules.json")
 	if err != nil {
 		return err
@@ -135,11 +148,12 @@ func (p *bertModel) KV(t *Tokenizer) ggml.KV {
 func (p *bertModel) Tensors(ts []Tensor) []ggml.Tensor {
 	var out []ggml.Tensor
 	for _, t := range ts {
-		if slices.Contains([]string{
-			"embeddings.position_ids",
-			"pooler.dense.weight",
-			"pooler.dense.bias",
-		}, t.Name()) {
+		if t.Name() == "embeddings.position_ids" {
+			continue
+		}
+
+		// the pooler is only used by the classification head
+		if !p.isClassifier() && slices.Contains([]string{"pooler.dense.weight", "pooler.dense.bias"}, t.Name()) {
 			continue
 		}
 
@@ -154,8 +168,8 @@ func (p *bertModel) Tensors(ts []Tensor) []ggml.Tensor {
 	return out
 }
 
-func (bertModel) Replacements() []string {
-	return []string{
+func (p *bertModel) Replacements() []string {
+	r := []string{
 		"encoder.layer", "blk",
 		"encoder.layers", "blk",
 		"embeddings.word_embeddings", "token_embd",
@@ -171,4 +185,14 @@ func (bertModel) Replacements() []string {
 		"output.dense", "ffn_down",
 		"output.LayerNorm", "layer_output_norm",
 	}
+
+	if p.isClassifier() {
+		r = append(r,
+			"bert.", "",
+			"pooler.dense", "cls",
+			"classifier", "cls.output",
+		)
+	}
+
+	return r
 }
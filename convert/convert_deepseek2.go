@@ -0,0 +1,163 @@
+package convert
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type deepseek2Model struct {
+	ModelParameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	RMSNormEPS            float32 `json:"rms_norm_eps"`
+	RopeTheta             float32 `json:"rope_theta"`
+	QLoraRank             uint32  `json:"q_lora_rank"`
+	KVLoraRank            uint32  `json:"kv_lora_rank"`
+	QKNopeHeadDim         uint32  `json:"qk_nope_head_dim"`
+	QKRopeHeadDim         uint32  `json:"qk_rope_head_dim"`
+	VHeadDim              uint32  `json:"v_head_dim"`
+	FirstKDenseReplace    uint32  `json:"first_k_dense_replace"`
+	MoeIntermediateSize   uint32  `json:"moe_intermediate_size"`
+	NRoutedExperts        uint32  `json:"n_routed_experts"`
+	NSharedExperts        uint32  `json:"n_shared_experts"`
+	NumExpertsPerToken    uint32  `json:"num_experts_per_tok"`
+	RoutedScalingFactor   float32 `json:"routed_scaling_factor"`
+	NormTopKProb          bool    `json:"norm_topk_prob"`
+	ScoringFunc           string  `json:"scoring_func"`
+	RopeScaling           struct {
+		Type                          string  `json:"type"`
+		Factor                        float32 `json:"factor"`
+		OriginalMaxPositionEmbeddings uint32  `json:"original_max_position_embeddings"`
+		MScaleAllDim                  float32 `json:"mscale_all_dim"`
+	} `json:"rope_scaling"`
+}
+
+var _ ModelConverter = (*deepseek2Model)(nil)
+
+func (p *deepseek2Model) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = "deepseek2"
+	kv["deepseek2.vocab_size"] = p.VocabSize
+	kv["deepseek2.block_count"] = p.HiddenLayers
+	kv["deepseek2.context_length"] = p.MaxPositionEmbeddings
+	kv["deepseek2.embedding_length"] = p.HiddenSize
+	kv["deepseek2.feed_forward_length"] = p.IntermediateSize
+	kv["deepseek2.attention.head_count"] = p.NumAttentionHeads
+	kv["deepseek2.attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NumAttentionHeads)
+	kv["deepseek2.attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
+	kv["deepseek2.rope.freq_base"] = cmp.Or(p.RopeTheta, 10000.0)
+	kv["deepseek2.rope.dimension_count"] = p.QKRopeHeadDim
+	kv["deepseek2.leading_dense_block_count"] = p.FirstKDenseReplace
+	if p.QLoraRank > 0 {
+		kv["deepseek2.attention.q_lora_rank"] = p.QLoraRank
+	}
+	kv["deepseek2.attention.kv_lora_rank"] = p.KVLoraRank
+	kv["deepseek2.attention.key_length"] = p.QKNopeHeadDim + p.QKRopeHeadDim
+	kv["deepseek2.attention.value_length"] = p.VHeadDim
+	kv["deepseek2.expert_count"] = p.NRoutedExperts
+	kv["deepseek2.expert_shared_count"] = p.NSharedExperts
+	kv["deepseek2.expert_used_count"] = p.NumExpertsPerToken
+	kv["deepseek2.expert_feed_forward_length"] = p.MoeIntermediateSize
+	kv["deepseek2.expert_weights_scale"] = cmp.Or(p.RoutedScalingFactor, 1.0)
+	kv["deepseek2.expert_weights_norm"] = p.NormTopKProb
+
+	switch p.ScoringFunc {
+	case "", "softmax":
+		kv["deepseek2.expert_gating_func"] = uint32(1)
+	case "sigmoid":
+		kv["deepseek2.expert_gating_func"] = uint32(2)
+	}
+
+	var yarnLogMultiplier float32
+	if p.RopeScaling.Type == "yarn" {
+		kv["deepseek2.rope.scaling.type"] = p.RopeScaling.Type
+		kv["deepseek2.rope.scaling.factor"] = p.RopeScaling.Factor
+		kv["deepseek2.rope.scaling.original_context_length"] = p.RopeScaling.OriginalMaxPositionEmbeddings
+		yarnLogMultiplier = 0.1 * p.RopeScaling.MScaleAllDim
+	}
+
+	kv["deepseek2.rope.scaling.yarn_log_multiplier"] = yarnLogMultiplier
+	return kv
+}
+
+// validate checks the expert scoring function and rope scaling are ones the
+// model supports
+func (p *deepseek2Model) validate([]Tensor) error {
+	switch p.ScoringFunc {
+	case "", "softmax", "sigmoid":
+	default:
+		return fmt.Errorf("unsupported scoring function %q", p.ScoringFunc)
+	}
+
+	switch p.RopeScaling.Type {
+	case "", "yarn":
+	default:
+		return fmt.Errorf("unsupported rope scaling type %q", p.RopeScaling.Type)
+	}
+
+	return nil
+}
+
+func (p *deepseek2Model) Tensors(ts []Tensor) []ggml.Tensor {
+	// DeepSeek-V3 includes layers for multi-token prediction after the last
+	// block which aren't used for inference
+	ts = slices.DeleteFunc(ts, func(t Tensor) bool {
+		var layer uint32
+		if _, err := fmt.Sscanf(t.Name(), "blk.%d.", &layer); err == nil {
+			return layer >= p.HiddenLayers
+		}
+
+		return false
+	})
+
+	out, ts := mergeTensors(ts,
+		merge{"blk.*.mlp.experts.*.gate_proj.weight", "blk.%s.ffn_gate_exps.weight"},
+		merge{"blk.*.mlp.experts.*.up_proj.weight", "blk.%s.ffn_up_exps.weight"},
+		merge{"blk.*.mlp.experts.*.down_proj.weight", "blk.%s.ffn_down_exps.weight"},
+	)
+
+	for _, t := range ts {
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *deepseek2Model) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.q_a_proj", "attn_q_a",
+		"self_attn.q_a_layernorm", "attn_q_a_norm",
+		"self_attn.q_b_proj", "attn_q_b",
+		"self_attn.kv_a_proj_with_mqa", "attn_kv_a_mqa",
+		"self_attn.kv_a_layernorm", "attn_kv_a_norm",
+		"self_attn.kv_b_proj", "attn_kv_b",
+		"self_attn.o_proj", "attn_output",
+		"post_attention_layernorm", "ffn_norm",
+		"mlp.shared_experts.gate_proj", "ffn_gate_shexp",
+		"mlp.shared_experts.up_proj", "ffn_up_shexp",
+		"mlp.shared_experts.down_proj", "ffn_down_shexp",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.up_proj", "ffn_up",
+		"mlp.down_proj", "ffn_down",
+		"mlp.gate.e_score_correction_bias", "exp_probs_b.bias",
+		"mlp.gate.weight", "ffn_gate_inp.weight",
+	}
+}
@@ -0,0 +1,116 @@
+package convert
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type falconModel struct {
+	ModelParameters
+	HiddenSize        uint32  `json:"hidden_size"`
+	NumHiddenLayers   uint32  `json:"num_hidden_layers"`
+	NLayer            uint32  `json:"n_layer"`
+	NumAttentionHeads uint32  `json:"num_attention_heads"`
+	NHead             uint32  `json:"n_head"`
+	NumKVHeads        uint32  `json:"num_kv_heads"`
+	NHeadKV           uint32  `json:"n_head_kv"`
+	LayerNormEpsilon  float32 `json:"layer_norm_epsilon"`
+}
+
+var _ ModelConverter = (*falconModel)(nil)
+
+func (p *falconModel) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = "falcon"
+	kv["falcon.block_count"] = cmp.Or(p.NumHiddenLayers, p.NLayer)
+	kv["falcon.context_length"] = uint32(2048)
+	kv["falcon.embedding_length"] = p.HiddenSize
+	kv["falcon.feed_forward_length"] = 4 * p.HiddenSize
+	kv["falcon.attention.head_count"] = p.heads()
+	kv["falcon.attention.head_count_kv"] = p.headsKV()
+	kv["falcon.attention.layer_norm_epsilon"] = p.LayerNormEpsilon
+	kv["falcon.tensor_data_layout"] = "jploski"
+	return kv
+}
+
+func (p *falconModel) heads() uint32 {
+	return cmp.Or(p.NumAttentionHeads, p.NHead)
+}
+
+func (p *falconModel) headsKV() uint32 {
+	// multi-query models without the new decoder architecture don't set the
+	// number of key/value heads
+	return cmp.Or(p.NumKVHeads, p.NHeadKV, 1)
+}
+
+func (p *falconModel) Tensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+	for _, t := range ts {
+		if strings.HasSuffix(t.Name(), "attn_qkv.weight") {
+			t.SetRepacker(p.repack)
+		}
+
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *falconModel) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"transformer.word_embeddings", "token_embd",
+		"transformer.ln_f", "output_norm",
+		"transformer.h", "blk",
+		"input_layernorm", "attn_norm",
+		"ln_attn", "attn_norm",
+		"ln_mlp", "attn_norm_2",
+		"self_attention.query_key_value", "attn_qkv",
+		"self_attention.dense", "attn_output",
+		"mlp.dense_h_to_4h", "ffn_up",
+		"mlp.dense_4h_to_h", "ffn_down",
+	}
+}
+
+// repack reorders the rows of the fused query, key and value projection. The
+// checkpoint groups each key and value head with the query heads sharing it,
+// e.g. q0 q1 k0 v0 q2 q3 k1 v1, while all query heads are expected first,
+// followed by the key heads and then the value heads.
+func (p *falconModel) repack(name string, data []float32, shape []uint64) ([]float32, error) {
+	heads, headsKV := int(p.heads()), int(p.headsKV())
+	headDim := int(p.HiddenSize) / heads
+	group := heads / headsKV
+	if int(shape[0]) != headsKV*(group+2)*headDim {
+		return nil, fmt.Errorf("%s: unexpected shape %v", name, shape)
+	}
+
+	size := headDim * int(shape[1])
+	head := func(i int) []float32 {
+		return data[i*size : (i+1)*size]
+	}
+
+	out := make([]float32, 0, len(data))
+	for i := range headsKV {
+		for j := range group {
+			out = append(out, head(i*(group+2)+j)...)
+		}
+	}
+
+	for i := range headsKV {
+		out = append(out, head(i*(group+2)+group)...)
+	}
+
+	for i := range headsKV {
+		out = append(out, head(i*(group+2)+group+1)...)
+	}
+
+	return out, nil
+}
@@ -46,7 +46,7 @@ func (p *gemmaModel) Tensors(ts []Tensor) []ggml.Tensor {
 	var out []ggml.Tensor
 	for _, t := range ts {
 		if !strings.HasPrefix(t.Name(), "v.") && strings.HasSuffix(t.Name(), "_norm.weight") {
-			t.SetRepacker(p.addOne)
+			t.SetRowRepacker(1, p.addOne)
 		}
 
 		out = append(out, ggml.Tensor{
@@ -0,0 +1,37 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// graniteModel is a llama model which scales its embeddings, attention,
+// residuals and logits by constants from its configuration
+type graniteModel struct {
+	llamaModel
+	AttentionMultiplier float32 `json:"attention_multiplier"`
+	EmbeddingMultiplier float32 `json:"embedding_multiplier"`
+	ResidualMultiplier  float32 `json:"residual_multiplier"`
+	LogitsScaling       float32 `json:"logits_scaling"`
+}
+
+var _ ModelConverter = (*graniteModel)(nil)
+
+func (p *graniteModel) KV(t *Tokenizer) ggml.KV {
+	kv := make(ggml.KV)
+	for k, v := range p.llamaModel.KV(t) {
+		if after, ok := strings.CutPrefix(k, "llama."); ok {
+			k = "granite." + after
+		}
+
+		kv[k] = v
+	}
+
+	kv["general.architecture"] = "granite"
+	kv["granite.attention.scale"] = p.AttentionMultiplier
+	kv["granite.embedding_scale"] = p.EmbeddingMultiplier
+	kv["granite.residual_scale"] = p.ResidualMultiplier
+	kv["granite.logit_scale"] = p.LogitsScaling
+	return kv
+}
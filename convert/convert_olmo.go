@@ -0,0 +1,85 @@
+package convert
+
+import (
+	"cmp"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// olmoModel converts OLMo and OLMo 2. The first has no learned norm weights
+// and rotates pairs of adjacent dimensions like llama while the second
+// normalizes queries and keys and applies its norms after each sublayer.
+type olmoModel struct {
+	llamaModel
+	Architecture string
+	ClipQKV      float32 `json:"clip_qkv"`
+}
+
+var _ ModelConverter = (*olmoModel)(nil)
+
+func (p *olmoModel) KV(t *Tokenizer) ggml.KV {
+	arch := "olmo"
+	if p.Architecture == "Olmo2ForCausalLM" {
+		arch = "olmo2"
+	}
+
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = arch
+	kv[arch+".block_count"] = p.NumHiddenLayers
+	kv[arch+".context_length"] = p.MaxPositionEmbeddings
+	kv[arch+".embedding_length"] = p.HiddenSize
+	kv[arch+".feed_forward_length"] = p.IntermediateSize
+	kv[arch+".attention.head_count"] = p.NumAttentionHeads
+	kv[arch+".attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NumAttentionHeads)
+	kv[arch+".rope.freq_base"] = cmp.Or(p.RopeTheta, 10000.0)
+
+	switch arch {
+	case "olmo":
+		kv["olmo.attention.layer_norm_epsilon"] = float32(1e-5)
+		if p.ClipQKV > 0 {
+			kv["olmo.attention.clamp_kqv"] = p.ClipQKV
+		}
+	case "olmo2":
+		kv["olmo2.attention.layer_norm_rms_epsilon"] = p.RMSNormEPS
+	}
+
+	return kv
+}
+
+func (p *olmoModel) Tensors(ts []Tensor) []ggml.Tensor {
+	if p.Architecture != "Olmo2ForCausalLM" {
+		return p.llamaModel.Tensors(ts)
+	}
+
+	var out []ggml.Tensor
+	for _, t := range ts {
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *olmoModel) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.o_proj", "attn_output",
+		"self_attn.q_norm", "attn_q_norm",
+		"self_attn.k_norm", "attn_k_norm",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.down_proj", "ffn_down",
+		"mlp.up_proj", "ffn_up",
+		"post_attention_layernorm", "post_attention_norm",
+		"post_feedforward_layernorm", "post_ffw_norm",
+	}
+}
@@ -0,0 +1,134 @@
+package convert
+
+import (
+	"cmp"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// pixtralModel converts Pixtral, a Mistral language model with a vision
+// encoder whose patch embeddings are projected into the text embeddings
+type pixtralModel struct {
+	ModelParameters
+	TextModel struct {
+		MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+		HiddenSize            uint32  `json:"hidden_size"`
+		HiddenLayers          uint32  `json:"num_hidden_layers"`
+		IntermediateSize      uint32  `json:"intermediate_size"`
+		NumAttentionHeads     uint32  `json:"num_attention_heads"`
+		NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+		HeadDim               uint32  `json:"head_dim"`
+		RopeTheta             float32 `json:"rope_theta"`
+		RMSNormEPS            float32 `json:"rms_norm_eps"`
+	} `json:"text_config"`
+	VisionModel struct {
+		HiddenSize        uint32  `json:"hidden_size"`
+		HiddenLayers      uint32  `json:"num_hidden_layers"`
+		IntermediateSize  uint32  `json:"intermediate_size"`
+		NumAttentionHeads uint32  `json:"num_attention_heads"`
+		NumChannels       uint32  `json:"num_channels"`
+		ImageSize         uint32  `json:"image_size"`
+		PatchSize         uint32  `json:"patch_size"`
+		RopeTheta         float32 `json:"rope_theta"`
+	} `json:"vision_config"`
+	ImageTokenIndex uint32 `json:"image_token_index"`
+}
+
+var _ ModelConverter = (*pixtralModel)(nil)
+
+func (p *pixtralModel) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = "pixtral"
+
+	headDim := cmp.Or(p.TextModel.HeadDim, p.TextModel.HiddenSize/p.TextModel.NumAttentionHeads)
+	kv["pixtral.block_count"] = p.TextModel.HiddenLayers
+	kv["pixtral.context_length"] = p.TextModel.MaxPositionEmbeddings
+	kv["pixtral.embedding_length"] = p.TextModel.HiddenSize
+	kv["pixtral.feed_forward_length"] = p.TextModel.IntermediateSize
+	kv["pixtral.attention.head_count"] = p.TextModel.NumAttentionHeads
+	kv["pixtral.attention.head_count_kv"] = cmp.Or(p.TextModel.NumKeyValueHeads, p.TextModel.NumAttentionHeads)
+	kv["pixtral.attention.key_length"] = headDim
+	kv["pixtral.attention.value_length"] = headDim
+	kv["pixtral.rope.dimension_count"] = headDim
+	kv["pixtral.rope.freq_base"] = cmp.Or(p.TextModel.RopeTheta, 1000000000)
+	kv["pixtral.attention.layer_norm_rms_epsilon"] = cmp.Or(p.TextModel.RMSNormEPS, 1e-5)
+
+	kv["pixtral.vision.block_count"] = p.VisionModel.HiddenLayers
+	kv["pixtral.vision.embedding_length"] = p.VisionModel.HiddenSize
+	kv["pixtral.vision.feed_forward_length"] = p.VisionModel.IntermediateSize
+	kv["pixtral.vision.attention.head_count"] = p.VisionModel.NumAttentionHeads
+	kv["pixtral.vision.attention.layer_norm_epsilon"] = float32(1e-5)
+	kv["pixtral.vision.rope.freq_base"] = cmp.Or(p.VisionModel.RopeTheta, 10000)
+	kv["pixtral.vision.num_channels"] = cmp.Or(p.VisionModel.NumChannels, 3)
+	kv["pixtral.vision.image_size"] = cmp.Or(p.VisionModel.ImageSize, 1024)
+	kv["pixtral.vision.patch_size"] = cmp.Or(p.VisionModel.PatchSize, 16)
+
+	// [IMG_BREAK] and [IMG_END] aren't in the config
+	kv["pixtral.vision.image_token_id"] = cmp.Or(p.ImageTokenIndex, 10)
+	kv["pixtral.vision.image_break_token_id"] = uint32(12)
+	kv["pixtral.vision.image_end_token_id"] = uint32(13)
+	return kv
+}
+
+func (p *pixtralModel) Tensors(ts []Tensor) []ggml.Tensor {
+	// both the text and vision attention use normal rather than neox rope, so
+	// the query and key projections are permuted as they are for llama
+	text := llamaModel{
+		NumAttentionHeads: p.TextModel.NumAttentionHeads,
+		NumKeyValueHeads:  p.TextModel.NumKeyValueHeads,
+	}
+	vision := llamaModel{NumAttentionHeads: p.VisionModel.NumAttentionHeads}
+
+	var out []ggml.Tensor
+	for _, t := range ts {
+		if strings.HasSuffix(t.Name(), "attn_q.weight") ||
+			strings.HasSuffix(t.Name(), "attn_k.weight") {
+			if strings.HasPrefix(t.Name(), "v.") {
+				t.SetRepacker(vision.repack)
+			} else {
+				t.SetRepacker(text.repack)
+			}
+		}
+
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *pixtralModel) Replacements() []string {
+	return []string{
+		"language_model.", "",
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.layers", "blk",
+		"model.norm", "output_norm",
+		"input_layernorm", "attn_norm",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.o_proj", "attn_output",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.down_proj", "ffn_down",
+		"mlp.up_proj", "ffn_up",
+		"post_attention_layernorm", "ffn_norm",
+		"vision_tower.patch_conv", "v.patch_conv",
+		"vision_tower.ln_pre", "v.encoder_norm",
+		"vision_tower.transformer.layers", "v.blk",
+		"attention.q_proj", "attn_q",
+		"attention.k_proj", "attn_k",
+		"attention.v_proj", "attn_v",
+		"attention.o_proj", "attn_output",
+		"attention_norm", "attn_norm",
+		"feed_forward.gate_proj", "ffn_gate",
+		"feed_forward.down_proj", "ffn_down",
+		"feed_forward.up_proj", "ffn_up",
+		"multi_modal_projector", "mm",
+	}
+}
@@ -0,0 +1,120 @@
+package convert
+
+import (
+	"cmp"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// qwen2AudioModel converts Qwen2-Audio, a Qwen2 language model with a Whisper
+// audio encoder whose pooled outputs are projected into the text embeddings
+type qwen2AudioModel struct {
+	ModelParameters
+	TextModel struct {
+		MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+		HiddenSize            uint32  `json:"hidden_size"`
+		HiddenLayers          uint32  `json:"num_hidden_layers"`
+		IntermediateSize      uint32  `json:"intermediate_size"`
+		NumAttentionHeads     uint32  `json:"num_attention_heads"`
+		NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+		RopeTheta             float32 `json:"rope_theta"`
+		RMSNormEPS            float32 `json:"rms_norm_eps"`
+	} `json:"text_config"`
+	AudioModel struct {
+		DModel                uint32 `json:"d_model"`
+		EncoderLayers         uint32 `json:"encoder_layers"`
+		EncoderAttentionHeads uint32 `json:"encoder_attention_heads"`
+		NumMelBins            uint32 `json:"num_mel_bins"`
+	} `json:"audio_config"`
+	AudioTokenIndex uint32 `json:"audio_token_index"`
+}
+
+var _ ModelConverter = (*qwen2AudioModel)(nil)
+
+func (q *qwen2AudioModel) KV(t *Tokenizer) ggml.KV {
+	kv := q.ModelParameters.KV(t)
+	kv["general.architecture"] = "qwen2audio"
+
+	// text_config only lists the values that differ from the Qwen2 defaults
+	numHeads := cmp.Or(q.TextModel.NumAttentionHeads, 32)
+	kv["qwen2audio.block_count"] = cmp.Or(q.TextModel.HiddenLayers, 32)
+	kv["qwen2audio.context_length"] = cmp.Or(q.TextModel.MaxPositionEmbeddings, 32768)
+	kv["qwen2audio.embedding_length"] = cmp.Or(q.TextModel.HiddenSize, 4096)
+	kv["qwen2audio.feed_forward_length"] = cmp.Or(q.TextModel.IntermediateSize, 22016)
+	kv["qwen2audio.attention.head_count"] = numHeads
+	kv["qwen2audio.attention.head_count_kv"] = cmp.Or(q.TextModel.NumKeyValueHeads, numHeads)
+	kv["qwen2audio.rope.freq_base"] = cmp.Or(q.TextModel.RopeTheta, 10000)
+	kv["qwen2audio.attention.layer_norm_rms_epsilon"] = cmp.Or(q.TextModel.RMSNormEPS, 1e-6)
+
+	kv["qwen2audio.audio.block_count"] = q.AudioModel.EncoderLayers
+	kv["qwen2audio.audio.embedding_length"] = q.AudioModel.DModel
+	kv["qwen2audio.audio.attention.head_count"] = q.AudioModel.EncoderAttentionHeads
+	kv["qwen2audio.audio.attention.layer_norm_epsilon"] = float32(1e-5)
+	kv["qwen2audio.audio.num_mel_bins"] = cmp.Or(q.AudioModel.NumMelBins, 128)
+	kv["qwen2audio.audio.audio_token_id"] = cmp.Or(q.AudioTokenIndex, 151646)
+	return kv
+}
+
+func (q *qwen2AudioModel) Tensors(ts []Tensor) []ggml.Tensor {
+	return audioTensors(ts)
+}
+
+func (q *qwen2AudioModel) Replacements() []string {
+	return append(
+		audioEncoderReplacements("audio_tower."),
+		"language_model.", "",
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.layers", "blk",
+		"model.norm", "output_norm",
+		"input_layernorm", "attn_norm",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.o_proj", "attn_output",
+		"mlp.down_proj", "ffn_down",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.up_proj", "ffn_up",
+		"post_attention_layernorm", "ffn_norm",
+		"multi_modal_projector", "mm",
+	)
+}
+
+// audioEncoderReplacements maps the names of a Whisper audio encoder whose
+// tensors start with prefix to those of the audio encoder
+func audioEncoderReplacements(prefix string) []string {
+	return []string{
+		prefix + "conv1", "a.conv1d.0",
+		prefix + "conv2", "a.conv1d.1",
+		prefix + "embed_positions", "a.position_embd",
+		prefix + "layers", "a.blk",
+		prefix + "layer_norm", "a.output_norm",
+		"self_attn_layer_norm", "attn_norm",
+		"self_attn.out_proj", "attn_output",
+		"final_layer_norm", "ffn_norm",
+		"fc1", "ffn_up",
+		"fc2", "ffn_down",
+	}
+}
+
+// audioTensors maps the tensors of models with a Whisper audio encoder. The
+// position embeddings are added to F32 activations so they're kept in F32.
+func audioTensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+	for _, t := range ts {
+		kind := t.Kind()
+		if strings.HasSuffix(t.Name(), "position_embd.weight") {
+			kind = tensorKindF32
+		}
+
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     kind,
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
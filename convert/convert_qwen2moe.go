@@ -0,0 +1,71 @@
+package convert
+
+import (
+	"fmt"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type qwen2MoeModel struct {
+	qwen2Model
+	NumExperts                   uint32 `json:"num_experts"`
+	NumExpertsPerToken           uint32 `json:"num_experts_per_tok"`
+	MoeIntermediateSize          uint32 `json:"moe_intermediate_size"`
+	SharedExpertIntermediateSize uint32 `json:"shared_expert_intermediate_size"`
+}
+
+var _ ModelConverter = (*qwen2MoeModel)(nil)
+
+func (q *qwen2MoeModel) KV(t *Tokenizer) ggml.KV {
+	kv := q.ModelParameters.KV(t)
+	kv["general.architecture"] = "qwen2moe"
+	kv["qwen2moe.block_count"] = q.HiddenLayers
+	kv["qwen2moe.context_length"] = q.MaxPositionEmbeddings
+	kv["qwen2moe.embedding_length"] = q.HiddenSize
+	kv["qwen2moe.feed_forward_length"] = q.IntermediateSize
+	kv["qwen2moe.attention.head_count"] = q.NumAttentionHeads
+	kv["qwen2moe.attention.head_count_kv"] = q.NumKeyValueHeads
+	kv["qwen2moe.rope.freq_base"] = q.RopeTheta
+	kv["qwen2moe.attention.layer_norm_rms_epsilon"] = q.RMSNormEPS
+	kv["qwen2moe.expert_count"] = q.NumExperts
+	kv["qwen2moe.expert_used_count"] = q.NumExpertsPerToken
+	kv["qwen2moe.expert_feed_forward_length"] = q.MoeIntermediateSize
+	kv["qwen2moe.expert_shared_feed_forward_length"] = q.SharedExpertIntermediateSize
+
+	if q.RopeScaling.Type == "yarn" {
+		kv["qwen2moe.rope.scaling.type"] = q.RopeScaling.Type
+		kv["qwen2moe.rope.scaling.factor"] = q.RopeScaling.Factor
+	}
+	return kv
+}
+
+func (q *qwen2MoeModel) validate([]Tensor) error {
+	switch q.RopeScaling.Type {
+	case "", "yarn":
+	default:
+		return fmt.Errorf("unsupported rope scaling type %q", q.RopeScaling.Type)
+	}
+
+	return nil
+}
+
+func (q *qwen2MoeModel) Tensors(ts []Tensor) []ggml.Tensor {
+	out, ts := mergeTensors(ts,
+		merge{"blk.*.mlp.experts.*.gate_proj.weight", "blk.%s.ffn_gate_exps.weight"},
+		merge{"blk.*.mlp.experts.*.up_proj.weight", "blk.%s.ffn_up_exps.weight"},
+		merge{"blk.*.mlp.experts.*.down_proj.weight", "blk.%s.ffn_down_exps.weight"},
+	)
+
+	return append(out, q.qwen2Model.Tensors(ts)...)
+}
+
+func (q *qwen2MoeModel) Replacements() []string {
+	return append(
+		q.qwen2Model.Replacements(),
+		"mlp.gate.weight", "ffn_gate_inp.weight",
+		"mlp.shared_expert_gate", "ffn_gate_inp_shexp",
+		"mlp.shared_expert.gate_proj", "ffn_gate_shexp",
+		"mlp.shared_expert.up_proj", "ffn_up_shexp",
+		"mlp.shared_expert.down_proj", "ffn_down_shexp",
+	)
+}
@@ -0,0 +1,213 @@
+package convert
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// qwen2VLModel converts Qwen2-VL, the language model along with its vision
+// encoder and the merger projecting image patches into the text embeddings
+type qwen2VLModel struct {
+	qwen2Model
+	RopeScaling struct {
+		MRopeSection []int32 `json:"mrope_section"`
+	} `json:"rope_scaling"`
+	VisionModel struct {
+		Depth             uint32 `json:"depth"`
+		EmbedDim          uint32 `json:"embed_dim"`
+		NumHeads          uint32 `json:"num_heads"`
+		InChannels        uint32 `json:"in_channels"`
+		InChans           uint32 `json:"in_chans"`
+		PatchSize         uint32 `json:"patch_size"`
+		SpatialMergeSize  uint32 `json:"spatial_merge_size"`
+		TemporalPatchSize uint32 `json:"temporal_patch_size"`
+	} `json:"vision_config"`
+	VisionStartTokenID uint32 `json:"vision_start_token_id"`
+	VisionEndTokenID   uint32 `json:"vision_end_token_id"`
+	ImageTokenID       uint32 `json:"image_token_id"`
+
+	// MinPixels and MaxPixels bound the size images are resized to, from
+	// preprocessor_config.json
+	MinPixels uint32
+	MaxPixels uint32
+}
+
+var _ ModelConverter = (*qwen2VLModel)(nil)
+
+func (q *qwen2VLModel) parseMore(fsys fs.FS) error {
+	bts, err := fs.ReadFile(fsys, "preprocessor_config.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var p struct {
+		MinPixels uint32 `json:"min_pixels"`
+		MaxPixels uint32 `json:"max_pixels"`
+	}
+
+	if err := json.Unmarshal(bts, &p); err != nil {
+		return err
+	}
+
+	q.MinPixels, q.MaxPixels = p.MinPixels, p.MaxPixels
+	return nil
+}
+
+func (q *qwen2VLModel) KV(t *Tokenizer) ggml.KV {
+	kv := q.ModelParameters.KV(t)
+	kv["general.architecture"] = "qwen2vl"
+	kv["qwen2vl.block_count"] = q.HiddenLayers
+	kv["qwen2vl.context_length"] = q.MaxPositionEmbeddings
+	kv["qwen2vl.embedding_length"] = q.HiddenSize
+	kv["qwen2vl.feed_forward_length"] = q.IntermediateSize
+	kv["qwen2vl.attention.head_count"] = q.NumAttentionHeads
+	kv["qwen2vl.attention.head_count_kv"] = q.NumKeyValueHeads
+	kv["qwen2vl.rope.freq_base"] = q.RopeTheta
+	kv["qwen2vl.attention.layer_norm_rms_epsilon"] = q.RMSNormEPS
+
+	// multimodal rope splits each head into sections for the temporal, height
+	// and width positions, padded to four
+	sections := make([]int32, 4)
+	copy(sections, q.RopeScaling.MRopeSection)
+	kv["qwen2vl.rope.dimension_sections"] = sections
+
+	kv["qwen2vl.vision.block_count"] = q.VisionModel.Depth
+	kv["qwen2vl.vision.embedding_length"] = q.VisionModel.EmbedDim
+	kv["qwen2vl.vision.attention.head_count"] = q.VisionModel.NumHeads
+	kv["qwen2vl.vision.attention.layer_norm_epsilon"] = float32(1e-6)
+	kv["qwen2vl.vision.rope.freq_base"] = float32(10000)
+	kv["qwen2vl.vision.num_channels"] = cmp.Or(q.VisionModel.InChannels, q.VisionModel.InChans, 3)
+	kv["qwen2vl.vision.patch_size"] = cmp.Or(q.VisionModel.PatchSize, 14)
+	kv["qwen2vl.vision.spatial_merge_size"] = cmp.Or(q.VisionModel.SpatialMergeSize, 2)
+	kv["qwen2vl.vision.start_token_id"] = cmp.Or(q.VisionStartTokenID, 151652)
+	kv["qwen2vl.vision.end_token_id"] = cmp.Or(q.VisionEndTokenID, 151653)
+	kv["qwen2vl.vision.image_token_id"] = cmp.Or(q.ImageTokenID, 151655)
+
+	if q.MinPixels > 0 {
+		kv["qwen2vl.vision.min_pixels"] = q.MinPixels
+	}
+
+	if q.MaxPixels > 0 {
+		kv["qwen2vl.vision.max_pixels"] = q.MaxPixels
+	}
+
+	return kv
+}
+
+func (q *qwen2VLModel) Tensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+	var rest []Tensor
+	for _, t := range ts {
+		switch {
+		case strings.Contains(t.Name(), ".attn_qkv."):
+			// the vision tower fuses the query, key and value projections
+			// which are written as separate tensors
+			shape := t.Shape()
+			for i, name := range []string{"attn_q", "attn_k", "attn_v"} {
+				out = append(out, ggml.Tensor{
+					Name:     strings.Replace(t.Name(), "attn_qkv", name, 1),
+					Kind:     t.Kind(),
+					Shape:    append([]uint64{shape[0] / 3}, shape[1:]...),
+					WriterTo: qkvSplit{t, i},
+				})
+			}
+		case t.Name() == "v.patch_embedding.weight":
+			// the patch embedding is a 3D convolution over pairs of video
+			// frames. images repeat the same frame so it's folded into a 2D
+			// convolution over one frame by summing its temporal dimension
+			shape := t.Shape()
+			t.SetRowRepacker(1, q.foldPatchEmbedding)
+			out = append(out, ggml.Tensor{
+				Name:     t.Name(),
+				Kind:     t.Kind(),
+				Shape:    []uint64{shape[0], shape[1], shape[3], shape[4]},
+				WriterTo: t,
+			})
+		default:
+			rest = append(rest, t)
+		}
+	}
+
+	return append(out, q.qwen2Model.Tensors(rest)...)
+}
+
+// validate checks the patch embedding is the 3D convolution folded by
+// [qwen2VLModel.Tensors]
+func (q *qwen2VLModel) validate(ts []Tensor) error {
+	for _, t := range ts {
+		if t.Name() == "v.patch_embedding.weight" && len(t.Shape()) != 5 {
+			return fmt.Errorf("unexpected patch embedding shape %v", t.Shape())
+		}
+	}
+
+	return nil
+}
+
+// foldPatchEmbedding sums the [out, in, temporal, height, width] kernel of
+// the patch embedding over its temporal dimension
+func (q *qwen2VLModel) foldPatchEmbedding(_ string, data []float32, shape []uint64) ([]float32, error) {
+	frames, size := int(shape[2]), int(shape[3]*shape[4])
+	f32s := make([]float32, len(data)/frames)
+	for i := range len(f32s) / size {
+		for t := range frames {
+			src := data[(i*frames+t)*size:][:size]
+			for j := range size {
+				f32s[i*size+j] += src[j]
+			}
+		}
+	}
+
+	return f32s, nil
+}
+
+func (q *qwen2VLModel) Replacements() []string {
+	return append(
+		q.qwen2Model.Replacements(),
+		"visual.patch_embed.proj", "v.patch_embedding",
+		"visual.blocks", "v.blk",
+		"visual.merger.ln_q", "mm.ln_q",
+		"visual.merger.mlp", "mm",
+		"norm1", "layer_norm1",
+		"norm2", "layer_norm2",
+		"attn.qkv", "attn_qkv",
+		"attn.proj", "attn_output",
+	)
+}
+
+// qkvSplit writes the i-th of the query, key and value projections stacked
+// along the first dimension of a fused tensor
+type qkvSplit struct {
+	Tensor
+	i int
+}
+
+// WriteTo streams the fused tensor through a row repacker which drops the
+// rows of the other projections, so only a chunk of it is in memory at a time
+func (s qkvSplit) WriteTo(w io.Writer) (int64, error) {
+	rows := s.Shape()[0] / 3
+	first, last := uint64(s.i)*rows, uint64(s.i+1)*rows
+
+	var offset uint64
+	s.SetRowRepacker(rows, func(_ string, data []float32, shape []uint64) ([]float32, error) {
+		rowSize := uint64(len(data)) / shape[0]
+		start, end := offset, offset+shape[0]
+		offset = end
+
+		lo, hi := max(start, first), min(end, last)
+		if lo >= hi {
+			return nil, nil
+		}
+		return data[(lo-start)*rowSize : (hi-start)*rowSize], nil
+	})
+
+	return s.Tensor.WriteTo(w)
+}
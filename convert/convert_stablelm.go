@@ -0,0 +1,76 @@
+package convert
+
+import (
+	"cmp"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type stablelmModel struct {
+	ModelParameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	RopeTheta             float32 `json:"rope_theta"`
+	PartialRotaryFactor   float32 `json:"partial_rotary_factor"`
+	UseParallelResidual   bool    `json:"use_parallel_residual"`
+	LayerNormEPS          float32 `json:"layer_norm_eps"`
+}
+
+var _ ModelConverter = (*stablelmModel)(nil)
+
+func (p *stablelmModel) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = "stablelm"
+	kv["stablelm.block_count"] = p.HiddenLayers
+	kv["stablelm.context_length"] = p.MaxPositionEmbeddings
+	kv["stablelm.embedding_length"] = p.HiddenSize
+	kv["stablelm.feed_forward_length"] = p.IntermediateSize
+	kv["stablelm.attention.head_count"] = p.NumAttentionHeads
+	kv["stablelm.attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NumAttentionHeads)
+	kv["stablelm.attention.layer_norm_epsilon"] = p.LayerNormEPS
+	kv["stablelm.rope.freq_base"] = cmp.Or(p.RopeTheta, 10000.0)
+	kv["stablelm.rope.dimension_count"] = uint32(cmp.Or(p.PartialRotaryFactor, 0.25) * float32(p.HiddenSize/p.NumAttentionHeads))
+	kv["stablelm.use_parallel_residual"] = p.UseParallelResidual
+	return kv
+}
+
+func (p *stablelmModel) Tensors(ts []Tensor) []ggml.Tensor {
+	// larger models normalize each head of the queries and keys separately
+	out, ts := mergeTensors(ts,
+		merge{"blk.*.self_attn.q_layernorm.norms.*.weight", "blk.%s.attn_q_norm.weight"},
+		merge{"blk.*.self_attn.k_layernorm.norms.*.weight", "blk.%s.attn_k_norm.weight"},
+	)
+
+	for _, t := range ts {
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *stablelmModel) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.o_proj", "attn_output",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.down_proj", "ffn_down",
+		"mlp.up_proj", "ffn_up",
+		"post_attention_layernorm", "ffn_norm",
+	}
+}
@@ -0,0 +1,66 @@
+package convert
+
+import (
+	"cmp"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type starcoder2Model struct {
+	ModelParameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	RopeTheta             float32 `json:"rope_theta"`
+	NormEpsilon           float32 `json:"norm_epsilon"`
+}
+
+var _ ModelConverter = (*starcoder2Model)(nil)
+
+func (p *starcoder2Model) KV(t *Tokenizer) ggml.KV {
+	kv := p.ModelParameters.KV(t)
+	kv["general.architecture"] = "starcoder2"
+	kv["starcoder2.block_count"] = p.HiddenLayers
+	kv["starcoder2.context_length"] = p.MaxPositionEmbeddings
+	kv["starcoder2.embedding_length"] = p.HiddenSize
+	kv["starcoder2.feed_forward_length"] = p.IntermediateSize
+	kv["starcoder2.attention.head_count"] = p.NumAttentionHeads
+	kv["starcoder2.attention.head_count_kv"] = cmp.Or(p.NumKeyValueHeads, p.NumAttentionHeads)
+	kv["starcoder2.attention.layer_norm_epsilon"] = cmp.Or(p.NormEpsilon, 1e-5)
+	kv["starcoder2.rope.freq_base"] = cmp.Or(p.RopeTheta, 10000.0)
+	return kv
+}
+
+func (p *starcoder2Model) Tensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+	for _, t := range ts {
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (p *starcoder2Model) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.norm", "output_norm",
+		"model.layers", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.o_proj", "attn_output",
+		"mlp.c_fc", "ffn_up",
+		"mlp.c_proj", "ffn_down",
+		"post_attention_layernorm", "ffn_norm",
+	}
+}
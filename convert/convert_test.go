@@ -18,6 +18,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/x448/float16"
 	"golang.org/x/exp/maps"
 
 	"github.com/ollama/ollama/fs/ggml"
@@ -27,6 +28,10 @@ type tensorData struct {
 	Offsets []int  `json:"data_offsets"`
 	Type    string `json:"dtype"`
 	Shape   []int  `json:"shape"`
+
+	// Data is written as the values of tensors without Offsets, which are
+	// zero filled F32 values if it's nil
+	Data any `json:"-"`
 }
 
 func convertFull(t *testing.T, fsys fs.FS) (*os.File, ggml.KV, ggml.Tensors) {
@@ -38,7 +43,7 @@ func convertFull(t *testing.T, fsys fs.FS) (*os.File, ggml.KV, ggml.Tensors) {
 	}
 	defer f.Close()
 
-	if err := ConvertModel(fsys, f); err != nil {
+	if err := ConvertModel(fsys, f, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -110,6 +115,16 @@ func TestConvertModel(t *testing.T) {
 		"gemma-2-9b-it",
 		"Qwen2.5-0.5B-Instruct",
 		"c4ai-command-r-v01",
+		// small checkpoints with the layout of each architecture
+		"tiny-Qwen2-MoE",
+		"tiny-Qwen2-VL",
+		"tiny-DeepSeek-V3",
+		"tiny-Granite",
+		"tiny-OLMo",
+		"tiny-OLMo-2",
+		"tiny-StableLM",
+		"tiny-Starcoder2",
+		"tiny-Falcon",
 	}
 
 	for i := range cases {
@@ -174,7 +189,7 @@ func TestConvertInvalidTensorNames(t *testing.T) {
 	}
 	generateSafetensorTestData(t, tempDir, td)
 
-	err = ConvertModel(os.DirFS(tempDir), f)
+	err = ConvertModel(os.DirFS(tempDir), f, nil)
 	if err == nil || !strings.HasPrefix(err.Error(), "duplicate tensor name") {
 		t.Errorf("expected error but didn't get one")
 	}
@@ -204,76 +219,120 @@ func TestConvertInvalidDatatype(t *testing.T) {
 	}
 	generateSafetensorTestData(t, tempDir, td)
 
-	err = ConvertModel(os.DirFS(tempDir), f)
+	err = ConvertModel(os.DirFS(tempDir), f, nil)
 	if err == nil || err.Error() != "unsupported safetensors model" {
 		t.Errorf("expected error but didn't get one")
 	}
 }
 
-func generateSafetensorTestData(t *testing.T, tempDir string, tensorData map[string]*tensorData) {
-	data, err := json.Marshal(tensorData)
-	if err != nil {
-		t.Fatal(err)
-	}
+// safetensorTestFiles are the files generateSafetensorTestData writes: the
+// checkpoint, named model-00001-of-00001.safetensors unless set, and the
+// other files of the model by name, which default to a llama config and an
+// empty tokenizer
+type safetensorTestFiles struct {
+	checkpoint string
+	files      map[string]string
+}
 
-	var buf bytes.Buffer
+type safetensorTestOption func(*safetensorTestFiles)
 
-	l := int64(len(data))
-	err = binary.Write(&buf, binary.LittleEndian, l)
-	if err != nil {
-		t.Fatal(err)
-	}
+// withCheckpoint names the checkpoint, e.g. adapter_model.safetensors
+func withCheckpoint(name string) safetensorTestOption {
+	return func(f *safetensorTestFiles) { f.checkpoint = name }
+}
 
-	_, err = buf.Write(data)
-	if err != nil {
-		t.Fatal(err)
+// withFiles replaces the config and tokenizer written with the checkpoint
+func withFiles(files map[string]string) safetensorTestOption {
+	return func(f *safetensorTestFiles) { f.files = files }
+}
+
+func generateSafetensorTestData(t *testing.T, tempDir string, tensors map[string]*tensorData, opts ...safetensorTestOption) {
+	t.Helper()
+
+	files := safetensorTestFiles{
+		checkpoint: "model-00001-of-00001.safetensors",
+		files: map[string]string{
+			"config.json": `
+{
+  "architectures": [
+    "LlamaForCausalLM"
+  ]
+}
+`,
+			"tokenizer.json": `
+{
+}
+`,
+		},
+	}
+	for _, opt := range opts {
+		opt(&files)
 	}
 
-	fdata, err := os.Create(filepath.Join(tempDir, "model-00001-of-00001.safetensors"))
-	if err != nil {
-		t.Fatal(err)
+	names := maps.Keys(tensors)
+	slices.Sort(names)
+
+	header := make(map[string]*tensorData, len(tensors))
+	var values bytes.Buffer
+	for _, name := range names {
+		td := *tensors[name]
+		header[name] = &td
+		if td.Offsets != nil {
+			continue
+		}
+
+		data := td.Data
+		if data == nil {
+			n := 1
+			for _, dim := range td.Shape {
+				n *= dim
+			}
+			data = make([]float32, n)
+		}
+
+		offset := values.Len()
+		if err := binary.Write(&values, binary.LittleEndian, data); err != nil {
+			t.Fatal(err)
+		}
+		td.Offsets = []int{offset, values.Len()}
 	}
-	defer fdata.Close()
 
-	_, err = fdata.Write(buf.Bytes())
+	data, err := json.Marshal(header)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	configData := `
-{
-  "architectures": [
-    "LlamaForCausalLM"
-  ]
-}
-`
+	var buf bytes.Buffer
 
-	f, err := os.Create(filepath.Join(tempDir, "config.json"))
+	l := int64(len(data))
+	err = binary.Write(&buf, binary.LittleEndian, l)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer f.Close()
 
-	_, err = f.WriteString(configData)
+	_, err = buf.Write(data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tokenizerData := `
-{
-}
-`
+	buf.Write(values.Bytes())
 
-	f, err = os.Create(filepath.Join(tempDir, "tokenizer.json"))
+	fdata, err := os.Create(filepath.Join(tempDir, files.checkpoint))
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer f.Close()
+	defer fdata.Close()
 
-	_, err = f.WriteString(tokenizerData)
+	_, err = fdata.Write(buf.Bytes())
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	for name, content := range files.files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
 }
 
 func TestConvertAdapter(t *testing.T) {
@@ -322,7 +381,7 @@ func TestConvertAdapter(t *testing.T) {
 			tempDir := t.TempDir()
 			generateLoraTestData(t, tempDir)
 
-			if err = ConvertAdapter(os.DirFS(tempDir), f, c.BaseKV); err != nil {
+			if err = ConvertAdapter(os.DirFS(tempDir), f, c.BaseKV, nil); err != nil {
 				t.Fatal(err)
 			}
 
@@ -476,3 +535,1208 @@ func generateLoraTestData(t *testing.T, tempDir string) {
 		t.Fatal(err)
 	}
 }
+
+// bertTestTokenizer is the tokenizer of the bert test models
+const bertTestTokenizer = `{
+  "added_tokens": [
+    {"id": 0, "content": "[PAD]", "special": true},
+    {"id": 1, "content": "[UNK]", "special": true},
+    {"id": 2, "content": "[CLS]", "special": true},
+    {"id": 3, "content": "[SEP]", "special": true}
+  ],
+  "model": {"vocab": {"[PAD]": 0, "[UNK]": 1, "[CLS]": 2, "[SEP]": 3, "hello": 4, "##s": 5}}
+}`
+
+func TestConvertBertClassifier(t *testing.T) {
+	dir := t.TempDir()
+	generateSafetensorTestData(t, dir, map[string]*tensorData{
+		"bert.embeddings.word_embeddings.weight":                 {Type: "F32", Shape: []int{6, 4}},
+		"bert.embeddings.token_type_embeddings.weight":           {Type: "F32", Shape: []int{2, 4}},
+		"bert.embeddings.position_embeddings.weight":             {Type: "F32", Shape: []int{16, 4}},
+		"bert.embeddings.LayerNorm.weight":                       {Type: "F32", Shape: []int{4}},
+		"bert.embeddings.LayerNorm.bias":                         {Type: "F32", Shape: []int{4}},
+		"bert.encoder.layer.0.attention.self.query.weight":       {Type: "F32", Shape: []int{4, 4}},
+		"bert.encoder.layer.0.attention.self.key.weight":         {Type: "F32", Shape: []int{4, 4}},
+		"bert.encoder.layer.0.attention.self.value.weight":       {Type: "F32", Shape: []int{4, 4}},
+		"bert.encoder.layer.0.attention.output.dense.weight":     {Type: "F32", Shape: []int{4, 4}},
+		"bert.encoder.layer.0.attention.output.LayerNorm.weight": {Type: "F32", Shape: []int{4}},
+		"bert.encoder.layer.0.intermediate.dense.weight":         {Type: "F32", Shape: []int{8, 4}},
+		"bert.encoder.layer.0.output.dense.weight":               {Type: "F32", Shape: []int{4, 8}},
+		"bert.encoder.layer.0.output.LayerNorm.weight":           {Type: "F32", Shape: []int{4}},
+		"bert.pooler.dense.weight":                               {Type: "F32", Shape: []int{4, 4}},
+		"bert.pooler.dense.bias":                                 {Type: "F32", Shape: []int{4}},
+		"classifier.weight":                                      {Type: "F32", Shape: []int{1, 4}},
+		"classifier.bias":                                        {Type: "F32", Shape: []int{1}},
+	}, withFiles(map[string]string{
+		"config.json": `{
+  "architectures": ["BertForSequenceClassification"],
+  "num_hidden_layers": 1,
+  "hidden_size": 4,
+  "intermediate_size": 8,
+  "num_attention_heads": 2,
+  "max_position_embeddings": 16
+}`,
+		"tokenizer.json": bertTestTokenizer,
+	}))
+
+	_, kv, tensors := convertFull(t, os.DirFS(dir))
+
+	if got := kv.Uint("pooling_type"); got != 4 {
+		t.Errorf("unexpected pooling type: want 4, got %d", got)
+	}
+
+	var names []string
+	for _, tensor := range tensors.Items() {
+		names = append(names, tensor.Name)
+	}
+
+	for _, name := range []string{
+		"token_embd.weight",
+		"token_types.weight",
+		"blk.0.attn_q.weight",
+		"blk.0.ffn_down.weight",
+		"cls.weight",
+		"cls.bias",
+		"cls.output.weight",
+		"cls.output.bias",
+	} {
+		if !slices.Contains(names, name) {
+			t.Errorf("missing tensor %s in %v", name, names)
+		}
+	}
+}
+
+func TestConvertQwen2VL(t *testing.T) {
+	dir := t.TempDir()
+
+	// a patch embedding over 2 frames of 3 channels with 2x2 patches and 2
+	// output features, whose values are their index
+	patch := make([]float32, 2*3*2*2*2)
+	for i := range patch {
+		patch[i] = float32(i)
+	}
+
+	qkv := make([]float32, 6*2)
+	for i := range qkv {
+		qkv[i] = float32(i)
+	}
+
+	generateSafetensorTestData(t, dir, map[string]*tensorData{
+		"model.embed_tokens.weight":              {Type: "F32", Shape: []int{6, 4}},
+		"model.layers.0.self_attn.q_proj.weight": {Type: "F32", Shape: []int{4, 4}},
+		"visual.patch_embed.proj.weight":         {Type: "F32", Shape: []int{2, 3, 2, 2, 2}, Data: patch},
+		"visual.blocks.0.norm1.weight":           {Type: "F32", Shape: []int{2}},
+		"visual.blocks.0.attn.qkv.weight":        {Type: "F32", Shape: []int{6, 2}, Data: qkv},
+		"visual.blocks.0.attn.qkv.bias":          {Type: "F32", Shape: []int{6}, Data: qkv[:6]},
+		"visual.blocks.0.attn.proj.weight":       {Type: "F32", Shape: []int{2, 2}},
+		"visual.blocks.0.mlp.fc1.weight":         {Type: "F32", Shape: []int{4, 2}},
+		"visual.merger.ln_q.weight":              {Type: "F32", Shape: []int{2}},
+		"visual.merger.mlp.0.weight":             {Type: "F32", Shape: []int{8, 8}},
+		"visual.merger.mlp.2.weight":             {Type: "F32", Shape: []int{4, 8}},
+	}, withFiles(map[string]string{
+		"config.json": `{
+  "architectures": ["Qwen2VLForConditionalGeneration"],
+  "num_hidden_layers": 1,
+  "hidden_size": 4,
+  "num_attention_heads": 1,
+  "rope_scaling": {"type": "mrope", "mrope_section": [1, 1, 0]},
+  "vision_config": {"depth": 1, "embed_dim": 2, "num_heads": 1, "patch_size": 2, "temporal_patch_size": 2, "in_chans": 3},
+  "vision_start_token_id": 3,
+  "image_token_id": 5
+}`,
+		"preprocessor_config.json": `{"min_pixels": 64, "max_pixels": 4096}`,
+		"tokenizer.json": `{
+  "model": {"vocab": {"a": 0, "b": 1, "c": 2, "d": 3, "e": 4, "f": 5}}
+}`,
+	}))
+
+	f, kv, tensors := convertFull(t, os.DirFS(dir))
+
+	for k, want := range map[string]uint32{
+		"vision.block_count":          1,
+		"vision.embedding_length":     2,
+		"vision.attention.head_count": 1,
+		"vision.patch_size":           2,
+		"vision.spatial_merge_size":   2,
+		"vision.num_channels":         3,
+		"vision.start_token_id":       3,
+		"vision.end_token_id":         151653,
+		"vision.image_token_id":       5,
+		"vision.min_pixels":           64,
+		"vision.max_pixels":           4096,
+	} {
+		if got := kv.Uint(k); got != want {
+			t.Errorf("%s: want %d, got %d", k, want, got)
+		}
+	}
+
+	values := func(name string) []float32 {
+		t.Helper()
+		for _, tensor := range tensors.Items() {
+			if tensor.Name != name {
+				continue
+			}
+
+			sr := io.NewSectionReader(f, int64(tensors.Offset+tensor.Offset), int64(tensor.Size()))
+			if tensor.Kind == tensorKindF32 {
+				f32s := make([]float32, tensor.Size()/4)
+				if err := binary.Read(sr, binary.LittleEndian, f32s); err != nil {
+					t.Fatal(err)
+				}
+				return f32s
+			}
+
+			f16s := make([]uint16, tensor.Size()/2)
+			if err := binary.Read(sr, binary.LittleEndian, f16s); err != nil {
+				t.Fatal(err)
+			}
+
+			f32s := make([]float32, len(f16s))
+			for i := range f16s {
+				f32s[i] = float16.Frombits(f16s[i]).Float32()
+			}
+			return f32s
+		}
+
+		t.Fatalf("missing tensor %s", name)
+		return nil
+	}
+
+	for name, want := range map[string][]float32{
+		"v.blk.0.attn_q.weight": {0, 1, 2, 3},
+		"v.blk.0.attn_k.weight": {4, 5, 6, 7},
+		"v.blk.0.attn_v.weight": {8, 9, 10, 11},
+		"v.blk.0.attn_v.bias":   {4, 5},
+	} {
+		if got := values(name); !slices.Equal(got, want) {
+			t.Errorf("%s: want %v, got %v", name, want, got)
+		}
+	}
+
+	// each value of the kernel is the sum of the values of both frames
+	var want []float32
+	for i := range 2 * 3 {
+		for j := range 4 {
+			want = append(want, patch[i*8+j]+patch[i*8+4+j])
+		}
+	}
+
+	if got := values("v.patch_embedding.weight"); !slices.Equal(got, want) {
+		t.Errorf("patch embedding: want %v, got %v", want, got)
+	}
+
+	for _, name := range []string{
+		"token_embd.weight",
+		"blk.0.attn_q.weight",
+		"v.blk.0.layer_norm1.weight",
+		"v.blk.0.attn_output.weight",
+		"v.blk.0.mlp.fc1.weight",
+		"mm.ln_q.weight",
+		"mm.0.weight",
+		"mm.2.weight",
+	} {
+		values(name)
+	}
+}
+
+func TestConvertPixtral(t *testing.T) {
+	dir := t.TempDir()
+
+	// the vision query projection of one head of size 4, whose values are
+	// their index
+	q := make([]float32, 4*2)
+	for i := range q {
+		q[i] = float32(i)
+	}
+
+	generateSafetensorTestData(t, dir, map[string]*tensorData{
+		"language_model.model.embed_tokens.weight":                      {Type: "F32", Shape: []int{14, 4}},
+		"language_model.model.layers.0.self_attn.q_proj.weight":         {Type: "F32", Shape: []int{4, 4}},
+		"language_model.model.layers.0.mlp.gate_proj.weight":            {Type: "F32", Shape: []int{8, 4}},
+		"language_model.model.norm.weight":                              {Type: "F32", Shape: []int{4}},
+		"language_model.lm_head.weight":                                 {Type: "F32", Shape: []int{14, 4}},
+		"vision_tower.patch_conv.weight":                                {Type: "F32", Shape: []int{4, 3, 2, 2}},
+		"vision_tower.ln_pre.weight":                                    {Type: "F32", Shape: []int{4}},
+		"vision_tower.transformer.layers.0.attention.q_proj.weight":     {Type: "F32", Shape: []int{4, 2}, Data: q},
+		"vision_tower.transformer.layers.0.attention.o_proj.weight":     {Type: "F32", Shape: []int{4, 4}},
+		"vision_tower.transformer.layers.0.attention_norm.weight":       {Type: "F32", Shape: []int{4}},
+		"vision_tower.transformer.layers.0.ffn_norm.weight":             {Type: "F32", Shape: []int{4}},
+		"vision_tower.transformer.layers.0.feed_forward.up_proj.weight": {Type: "F32", Shape: []int{8, 4}},
+		"multi_modal_projector.linear_1.weight":                         {Type: "F32", Shape: []int{4, 4}},
+		"multi_modal_projector.linear_1.bias":                           {Type: "F32", Shape: []int{4}},
+		"multi_modal_projector.linear_2.weight":                         {Type: "F32", Shape: []int{4, 4}},
+	}, withFiles(map[string]string{
+		"config.json": `{
+  "architectures": ["LlavaForConditionalGeneration"],
+  "text_config": {"hidden_size": 4, "num_hidden_layers": 1, "num_attention_heads": 1, "intermediate_size": 8},
+  "vision_config": {"model_type": "pixtral", "hidden_size": 4, "num_hidden_layers": 1, "num_attention_heads": 1, "patch_size": 2},
+  "image_token_index": 10
+}`,
+		"tokenizer.json": `{
+  "model": {"vocab": {"a": 0, "b": 1, "c": 2, "d": 3, "e": 4, "f": 5, "g": 6, "h": 7, "i": 8, "j": 9, "[IMG]": 10, "k": 11, "[IMG_BREAK]": 12, "[IMG_END]": 13}}
+}`,
+	}))
+
+	f, kv, tensors := convertFull(t, os.DirFS(dir))
+
+	if got := kv.Architecture(); got != "pixtral" {
+		t.Errorf("architecture: want pixtral, got %s", got)
+	}
+
+	for k, want := range map[string]uint32{
+		"block_count":                 1,
+		"embedding_length":            4,
+		"attention.head_count":        1,
+		"attention.head_count_kv":     1,
+		"rope.dimension_count":        4,
+		"vision.block_count":          1,
+		"vision.embedding_length":     4,
+		"vision.attention.head_count": 1,
+		"vision.patch_size":           2,
+		"vision.image_token_id":       10,
+		"vision.image_break_token_id": 12,
+		"vision.image_end_token_id":   13,
+	} {
+		if got := kv.Uint(k); got != want {
+			t.Errorf("%s: want %d, got %d", k, want, got)
+		}
+	}
+
+	values := func(name string) []float32 {
+		t.Helper()
+		for _, tensor := range tensors.Items() {
+			if tensor.Name != name {
+				continue
+			}
+
+			sr := io.NewSectionReader(f, int64(tensors.Offset+tensor.Offset), int64(tensor.Size()))
+			if tensor.Kind == tensorKindF32 {
+				f32s := make([]float32, tensor.Size()/4)
+				if err := binary.Read(sr, binary.LittleEndian, f32s); err != nil {
+					t.Fatal(err)
+				}
+				return f32s
+			}
+
+			f16s := make([]uint16, tensor.Size()/2)
+			if err := binary.Read(sr, binary.LittleEndian, f16s); err != nil {
+				t.Fatal(err)
+			}
+
+			f32s := make([]float32, len(f16s))
+			for i := range f16s {
+				f32s[i] = float16.Frombits(f16s[i]).Float32()
+			}
+			return f32s
+		}
+
+		t.Fatalf("missing tensor %s", name)
+		return nil
+	}
+
+	// the rows of each head are interleaved so the vision encoder can use
+	// normal rope: rows 0 and 2 are rotated together, then rows 1 and 3
+	if got, want := values("v.blk.0.attn_q.weight"), []float32{0, 1, 4, 5, 2, 3, 6, 7}; !slices.Equal(got, want) {
+		t.Errorf("v.blk.0.attn_q.weight: want %v, got %v", want, got)
+	}
+
+	for _, name := range []string{
+		"token_embd.weight",
+		"blk.0.attn_q.weight",
+		"blk.0.ffn_gate.weight",
+		"output_norm.weight",
+		"output.weight",
+		"v.patch_conv.weight",
+		"v.encoder_norm.weight",
+		"v.blk.0.attn_output.weight",
+		"v.blk.0.attn_norm.weight",
+		"v.blk.0.ffn_norm.weight",
+		"v.blk.0.ffn_up.weight",
+		"mm.linear_1.weight",
+		"mm.linear_1.bias",
+		"mm.linear_2.weight",
+	} {
+		values(name)
+	}
+}
+
+func TestConvertQwen2Audio(t *testing.T) {
+	dir := t.TempDir()
+	generateSafetensorTestData(t, dir, map[string]*tensorData{
+		"language_model.model.embed_tokens.weight":              {Type: "F32", Shape: []int{6, 4}},
+		"language_model.model.layers.0.self_attn.q_proj.weight": {Type: "F32", Shape: []int{4, 4}},
+		"language_model.model.layers.0.mlp.gate_proj.weight":    {Type: "F32", Shape: []int{8, 4}},
+		"language_model.model.norm.weight":                      {Type: "F32", Shape: []int{4}},
+		"language_model.lm_head.weight":                         {Type: "F32", Shape: []int{6, 4}},
+		"audio_tower.conv1.weight":                              {Type: "F32", Shape: []int{2, 4, 3}},
+		"audio_tower.conv1.bias":                                {Type: "F32", Shape: []int{2}},
+		"audio_tower.conv2.weight":                              {Type: "F32", Shape: []int{2, 2, 3}},
+		"audio_tower.embed_positions.weight":                    {Type: "F32", Shape: []int{8, 2}},
+		"audio_tower.layers.0.self_attn.k_proj.weight":          {Type: "F32", Shape: []int{2, 2}},
+		"audio_tower.layers.0.self_attn.out_proj.weight":        {Type: "F32", Shape: []int{2, 2}},
+		"audio_tower.layers.0.self_attn_layer_norm.weight":      {Type: "F32", Shape: []int{2}},
+		"audio_tower.layers.0.final_layer_norm.weight":          {Type: "F32", Shape: []int{2}},
+		"audio_tower.layers.0.fc1.weight":                       {Type: "F32", Shape: []int{4, 2}},
+		"audio_tower.layers.0.fc2.weight":                       {Type: "F32", Shape: []int{2, 4}},
+		"audio_tower.layer_norm.weight":                         {Type: "F32", Shape: []int{2}},
+		"multi_modal_projector.linear.weight":                   {Type: "F32", Shape: []int{4, 2}},
+	}, withFiles(map[string]string{
+		"config.json": `{
+  "architectures": ["Qwen2AudioForConditionalGeneration"],
+  "audio_config": {"d_model": 2, "encoder_layers": 1, "encoder_attention_heads": 1, "num_mel_bins": 4},
+  "text_config": {"hidden_size": 4, "num_hidden_layers": 1, "num_attention_heads": 1, "intermediate_size": 8},
+  "audio_token_index": 5
+}`,
+		"tokenizer.json": `{
+  "model": {"vocab": {"a": 0, "b": 1, "c": 2, "d": 3, "e": 4, "f": 5}}
+}`,
+	}))
+
+	_, kv, tensors := convertFull(t, os.DirFS(dir))
+
+	if got := kv.Architecture(); got != "qwen2audio" {
+		t.Errorf("architecture: want qwen2audio, got %s", got)
+	}
+
+	for k, want := range map[string]uint32{
+		"block_count":                1,
+		"embedding_length":           4,
+		"attention.head_count":       1,
+		"attention.head_count_kv":    1,
+		"audio.block_count":          1,
+		"audio.embedding_length":     2,
+		"audio.attention.head_count": 1,
+		"audio.num_mel_bins":         4,
+		"audio.audio_token_id":       5,
+	} {
+		if got := kv.Uint(k); got != want {
+			t.Errorf("%s: want %d, got %d", k, want, got)
+		}
+	}
+
+	kinds := make(map[string]uint32)
+	for _, tensor := range tensors.Items() {
+		kinds[tensor.Name] = tensor.Kind
+	}
+
+	for _, name := range []string{
+		"token_embd.weight",
+		"blk.0.attn_q.weight",
+		"blk.0.ffn_gate.weight",
+		"output_norm.weight",
+		"output.weight",
+		"a.conv1d.0.weight",
+		"a.conv1d.0.bias",
+		"a.conv1d.1.weight",
+		"a.position_embd.weight",
+		"a.blk.0.attn_k.weight",
+		"a.blk.0.attn_output.weight",
+		"a.blk.0.attn_norm.weight",
+		"a.blk.0.ffn_norm.weight",
+		"a.blk.0.ffn_up.weight",
+		"a.blk.0.ffn_down.weight",
+		"a.output_norm.weight",
+		"mm.linear.weight",
+	} {
+		if _, ok := kinds[name]; !ok {
+			t.Errorf("missing tensor %s in %v", name, maps.Keys(kinds))
+		}
+	}
+
+	if kinds["a.position_embd.weight"] != tensorKindF32 {
+		t.Errorf("position embeddings: want F32, got kind %d", kinds["a.position_embd.weight"])
+	}
+}
+
+func TestConvertWhisper(t *testing.T) {
+	dir := t.TempDir()
+	generateSafetensorTestData(t, dir, map[string]*tensorData{
+		"model.encoder.conv1.weight":                            {Type: "F32", Shape: []int{2, 4, 3}},
+		"model.encoder.conv2.weight":                            {Type: "F32", Shape: []int{2, 2, 3}},
+		"model.encoder.embed_positions.weight":                  {Type: "F32", Shape: []int{8, 2}},
+		"model.encoder.layers.0.self_attn.q_proj.weight":        {Type: "F32", Shape: []int{2, 2}},
+		"model.encoder.layers.0.fc1.weight":                     {Type: "F32", Shape: []int{4, 2}},
+		"model.encoder.layer_norm.weight":                       {Type: "F32", Shape: []int{2}},
+		"model.decoder.embed_tokens.weight":                     {Type: "F32", Shape: []int{6, 2}},
+		"model.decoder.embed_positions.weight":                  {Type: "F32", Shape: []int{4, 2}},
+		"model.decoder.layers.0.self_attn.v_proj.weight":        {Type: "F32", Shape: []int{2, 2}},
+		"model.decoder.layers.0.self_attn_layer_norm.weight":    {Type: "F32", Shape: []int{2}},
+		"model.decoder.layers.0.encoder_attn.k_proj.weight":     {Type: "F32", Shape: []int{2, 2}},
+		"model.decoder.layers.0.encoder_attn.out_proj.weight":   {Type: "F32", Shape: []int{2, 2}},
+		"model.decoder.layers.0.encoder_attn_layer_norm.weight": {Type: "F32", Shape: []int{2}},
+		"model.decoder.layers.0.fc2.weight":                     {Type: "F32", Shape: []int{2, 4}},
+		"model.decoder.layers.0.final_layer_norm.weight":        {Type: "F32", Shape: []int{2}},
+		"model.decoder.layer_norm.weight":                       {Type: "F32", Shape: []int{2}},
+	}, withFiles(map[string]string{
+		"config.json": `{
+  "architectures": ["WhisperForConditionalGeneration"],
+  "d_model": 2,
+  "encoder_layers": 1,
+  "encoder_attention_heads": 1,
+  "decoder_layers": 1,
+  "decoder_attention_heads": 1,
+  "max_target_positions": 4,
+  "num_mel_bins": 4
+}`,
+		"tokenizer.json": `{
+  "model": {"vocab": {"a": 0, "b": 1, "c": 2, "d": 3, "e": 4, "f": 5}}
+}`,
+	}))
+
+	_, kv, tensors := convertFull(t, os.DirFS(dir))
+
+	if got := kv.Architecture(); got != "whisper" {
+		t.Errorf("architecture: want whisper, got %s", got)
+	}
+
+	for k, want := range map[string]uint32{
+		"block_count":                1,
+		"context_length":             4,
+		"embedding_length":           2,
+		"attention.head_count":       1,
+		"audio.block_count":          1,
+		"audio.embedding_length":     2,
+		"audio.attention.head_count": 1,
+		"audio.num_mel_bins":         4,
+	} {
+		if got := kv.Uint(k); got != want {
+			t.Errorf("%s: want %d, got %d", k, want, got)
+		}
+	}
+
+	var names []string
+	for _, tensor := range tensors.Items() {
+		names = append(names, tensor.Name)
+	}
+
+	for _, name := range []string{
+		"a.conv1d.0.weight",
+		"a.conv1d.1.weight",
+		"a.position_embd.weight",
+		"a.blk.0.attn_q.weight",
+		"a.blk.0.ffn_up.weight",
+		"a.output_norm.weight",
+		"token_embd.weight",
+		"position_embd.weight",
+		"blk.0.attn_v.weight",
+		"blk.0.attn_norm.weight",
+		"blk.0.cross_attn_k.weight",
+		"blk.0.cross_attn_output.weight",
+		"blk.0.cross_attn_norm.weight",
+		"blk.0.ffn_down.weight",
+		"blk.0.ffn_norm.weight",
+		"output_norm.weight",
+	} {
+		if !slices.Contains(names, name) {
+			t.Errorf("missing tensor %s in %v", name, names)
+		}
+	}
+}
+
+func TestConvertQuantizedSafetensors(t *testing.T) {
+	const in, out, groupSize = 16, 8, 8
+	const groups = in / groupSize
+
+	// quantized values, zero points and scales of a weight with out rows and in columns
+	q := func(i, j int) uint32 { return uint32(i*3+j*5) % 16 }
+	z := func(g, j int) uint32 { return uint32(g+j)%8 + 4 }
+	s := func(g, j int) float32 { return float32(int(1)<<(g+j%3)) / 64 }
+
+	packed := make([]float32, out*in)
+	scales := make([]uint16, groups*out)
+	for j := range out {
+		for i := range in {
+			g := i / groupSize
+			packed[j*in+i] = (float32(q(i, j)) - float32(z(g, j))) * s(g, j)
+			scales[g*out+j] = float16.Fromfloat32(s(g, j)).Bits()
+		}
+	}
+
+	gptqWeight := make([]uint32, in/8*out)
+	gptqZeros := make([]uint32, groups*out/8)
+	gidx := make([]int32, in)
+	for i := range in {
+		gidx[i] = int32(i / groupSize)
+		for j := range out {
+			gptqWeight[i/8*out+j] |= q(i, j) << (i % 8 * 4)
+		}
+	}
+
+	for g := range groups {
+		for j := range out {
+			gptqZeros[g*out/8+j/8] |= (z(g, j) - 1) << (j % 8 * 4)
+		}
+	}
+
+	// AWQ interleaves the output features of each packed int32
+	awqPack := [8]int{0, 2, 4, 6, 1, 3, 5, 7}
+	awqWeight := make([]uint32, in*out/8)
+	awqZeros := make([]uint32, groups*out/8)
+	for c := range out / 8 {
+		for k, col := range awqPack {
+			for i := range in {
+				awqWeight[i*out/8+c] |= q(i, c*8+col) << (k * 4)
+			}
+
+			for g := range groups {
+				awqZeros[g*out/8+c] |= z(g, c*8+col) << (k * 4)
+			}
+		}
+	}
+
+	// bitsandbytes 4-bit with double quantized scales
+	const blockSize, nestedBlockSize, nestedOffset = 64, 256, 0.25
+	quantMap := make([]float32, 16)
+	for k := range quantMap {
+		quantMap[k] = float32(k-8) / 8
+	}
+
+	nestedQuantMap := make([]float32, 256)
+	for k := range nestedQuantMap {
+		nestedQuantMap[k] = float32(k) / 256
+	}
+
+	absmaxCodes := []uint8{3, 200}
+	nestedAbsmax := []float32{0.5}
+	bnb4Weight := make([]uint8, out*in/2)
+	bnb4 := make([]float32, out*in)
+	for n := range bnb4 {
+		code := uint8(n*7) % 16
+		if n%2 == 0 {
+			bnb4Weight[n/2] |= code << 4
+		} else {
+			bnb4Weight[n/2] |= code
+		}
+
+		absmax := nestedQuantMap[absmaxCodes[n/blockSize]]*nestedAbsmax[0] + nestedOffset
+		bnb4[n] = quantMap[code] * absmax
+	}
+
+	quantState, err := json.Marshal(map[string]any{
+		"quant_type":       "nf4",
+		"blocksize":        blockSize,
+		"shape":            []int{out, in},
+		"dtype":            "bfloat16",
+		"nested_blocksize": nestedBlockSize,
+		"nested_offset":    nestedOffset,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bitsandbytes 8-bit with one scale per row
+	bnb8Weight := make([]int8, out*in)
+	scb := make([]float32, out)
+	bnb8 := make([]float32, out*in)
+	for j := range out {
+		scb[j] = float32(j+1) / 4
+		for i := range in {
+			bnb8Weight[j*in+i] = int8((j*in+i)*13%255 - 127)
+			bnb8[j*in+i] = float32(bnb8Weight[j*in+i]) * scb[j] / 127
+		}
+	}
+
+	cases := []struct {
+		name    string
+		tensors map[string]*tensorData
+		want    []float32
+	}{
+		{
+			name: "gptq",
+			tensors: map[string]*tensorData{
+				"layer.qweight": {Type: "I32", Shape: []int{in / 8, out}, Data: gptqWeight},
+				"layer.qzeros":  {Type: "I32", Shape: []int{groups, out / 8}, Data: gptqZeros},
+				"layer.scales":  {Type: "F16", Shape: []int{groups, out}, Data: scales},
+				"layer.g_idx":   {Type: "I32", Shape: []int{in}, Data: gidx},
+			},
+			want: packed,
+		},
+		{
+			name: "awq",
+			tensors: map[string]*tensorData{
+				"layer.qweight": {Type: "I32", Shape: []int{in, out / 8}, Data: awqWeight},
+				"layer.qzeros":  {Type: "I32", Shape: []int{groups, out / 8}, Data: awqZeros},
+				"layer.scales":  {Type: "F16", Shape: []int{groups, out}, Data: scales},
+			},
+			want: packed,
+		},
+		{
+			name: "bnb4",
+			tensors: map[string]*tensorData{
+				"layer.weight":                               {Type: "U8", Shape: []int{out * in / 2, 1}, Data: bnb4Weight},
+				"layer.weight.absmax":                        {Type: "U8", Shape: []int{len(absmaxCodes)}, Data: absmaxCodes},
+				"layer.weight.quant_map":                     {Type: "F32", Shape: []int{16}, Data: quantMap},
+				"layer.weight.nested_absmax":                 {Type: "F32", Shape: []int{1}, Data: nestedAbsmax},
+				"layer.weight.nested_quant_map":              {Type: "F32", Shape: []int{256}, Data: nestedQuantMap},
+				"layer.weight.quant_state.bitsandbytes__nf4": {Type: "U8", Shape: []int{len(quantState)}, Data: quantState},
+			},
+			want: bnb4,
+		},
+		{
+			name: "bnb8",
+			tensors: map[string]*tensorData{
+				"layer.weight": {Type: "I8", Shape: []int{out, in}, Data: bnb8Weight},
+				"layer.SCB":    {Type: "F32", Shape: []int{out}, Data: scb},
+			},
+			want: bnb8,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.tensors["layer.bias"] = &tensorData{Type: "F32", Shape: []int{out}}
+			generateSafetensorTestData(t, dir, tt.tensors)
+
+			ts, err := parseSafetensors(os.DirFS(dir), strings.NewReplacer("layer", "blk.0.ffn_up"), "model-00001-of-00001.safetensors")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, t := range ts {
+				names = append(names, t.Name())
+			}
+
+			if !slices.Equal(names, []string{"blk.0.ffn_up.bias", "blk.0.ffn_up.weight"}) {
+				t.Fatalf("unexpected tensors %v", names)
+			}
+
+			weight := ts[1]
+			if !slices.Equal(weight.Shape(), []uint64{out, in}) {
+				t.Fatalf("unexpected shape %v", weight.Shape())
+			}
+
+			var b bytes.Buffer
+			if _, err := weight.WriteTo(&b); err != nil {
+				t.Fatal(err)
+			}
+
+			f16s := make([]uint16, out*in)
+			if err := binary.Read(&b, binary.LittleEndian, f16s); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range f16s {
+				if got := float16.Frombits(f16s[i]).Float32(); math.Abs(float64(got-tt.want[i])) > 1e-3 {
+					t.Fatalf("value %d: want %v, got %v", i, tt.want[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertProgress(t *testing.T) {
+	dir := t.TempDir()
+	generateSafetensorTestData(t, dir, map[string]*tensorData{
+		"bert.embeddings.word_embeddings.weight":           {Type: "F32", Shape: []int{6, 4}},
+		"bert.embeddings.position_embeddings.weight":       {Type: "F32", Shape: []int{16, 4}},
+		"bert.embeddings.LayerNorm.weight":                 {Type: "F32", Shape: []int{4}},
+		"bert.encoder.layer.0.attention.self.query.weight": {Type: "F32", Shape: []int{4, 4}},
+		"bert.encoder.layer.0.output.dense.weight":         {Type: "F32", Shape: []int{4, 8}},
+	}, withFiles(map[string]string{
+		"config.json": `{
+  "architectures": ["BertForSequenceClassification"],
+  "num_hidden_layers": 1,
+  "hidden_size": 4,
+  "intermediate_size": 8,
+  "num_attention_heads": 2,
+  "max_position_embeddings": 16
+}`,
+		"tokenizer.json": bertTestTokenizer,
+	}))
+
+	f, err := os.CreateTemp(t.TempDir(), "f16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var calls []uint64
+	var total uint64
+	if err := ConvertModel(os.DirFS(dir), f, func(completed, t uint64) {
+		calls = append(calls, completed)
+		total = t
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 6x4 + 16x4 + 4x4 + 4x8 F16 values and 4 F32 values
+	if want := uint64((24+64+16+32)*2 + 4*4); total != want {
+		t.Errorf("unexpected total: want %d, got %d", want, total)
+	}
+
+	if len(calls) != 5 || !slices.IsSorted(calls) || calls[len(calls)-1] != total {
+		t.Errorf("unexpected progress %v of %d", calls, total)
+	}
+}
+
+func TestConvertValidation(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  string
+		tensors map[string]*tensorData
+		err     string
+	}{
+		{
+			name:   "deepseek2 scoring function",
+			config: `{"architectures": ["DeepseekV3ForCausalLM"], "scoring_func": "tanh"}`,
+			tensors: map[string]*tensorData{
+				"model.embed_tokens.weight": {Type: "F32", Shape: []int{2, 4}},
+			},
+			err: `unsupported scoring function "tanh"`,
+		},
+		{
+			name:   "deepseek2 rope scaling",
+			config: `{"architectures": ["DeepseekV2ForCausalLM"], "rope_scaling": {"type": "dynamic"}}`,
+			tensors: map[string]*tensorData{
+				"model.embed_tokens.weight": {Type: "F32", Shape: []int{2, 4}},
+			},
+			err: `unsupported rope scaling type "dynamic"`,
+		},
+		{
+			name:   "qwen2moe rope scaling",
+			config: `{"architectures": ["Qwen2MoeForCausalLM"], "rope_scaling": {"type": "dynamic"}}`,
+			tensors: map[string]*tensorData{
+				"model.embed_tokens.weight": {Type: "F32", Shape: []int{2, 4}},
+			},
+			err: `unsupported rope scaling type "dynamic"`,
+		},
+		{
+			name:   "qwen2vl patch embedding",
+			config: `{"architectures": ["Qwen2VLForConditionalGeneration"]}`,
+			tensors: map[string]*tensorData{
+				"visual.patch_embed.proj.weight": {Type: "F32", Shape: []int{2, 3, 2, 2}},
+			},
+			err: "unexpected patch embedding shape [2 3 2 2]",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			generateSafetensorTestData(t, dir, tt.tensors, withFiles(map[string]string{
+				"config.json":    tt.config,
+				"tokenizer.json": `{"model": {"vocab": {"a": 0, "b": 1}}}`,
+			}))
+
+			f, err := os.CreateTemp(t.TempDir(), "f16")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			if err := ConvertModel(os.DirFS(dir), f, nil); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("want error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestQKVSplitStreaming(t *testing.T) {
+	// rows of a fifth of a chunk, so each chunk holds the rows of one and a
+	// half projections
+	rowSize := safetensorsChunkSize / 5
+	f32s := make([]float32, 6*rowSize)
+	for i := range f32s {
+		f32s[i] = float32(i / rowSize)
+	}
+
+	dir := t.TempDir()
+	generateSafetensorTestData(t, dir, map[string]*tensorData{
+		"attn_qkv.weight": {Type: "F32", Shape: []int{6, rowSize}, Data: f32s},
+	})
+
+	ts, err := parseSafetensors(os.DirFS(dir), strings.NewReplacer(), "model-00001-of-00001.safetensors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 3 {
+		var b bytes.Buffer
+		n, err := qkvSplit{ts[0], i}.WriteTo(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != int64(2*rowSize*2) || b.Len() != 2*rowSize*2 {
+			t.Fatalf("split %d: unexpected size: %d, %d", i, n, b.Len())
+		}
+
+		f16s := make([]uint16, 2*rowSize)
+		if err := binary.Read(&b, binary.LittleEndian, f16s); err != nil {
+			t.Fatal(err)
+		}
+
+		for j := range f16s {
+			if got, want := float16.Frombits(f16s[j]).Float32(), float32(2*i+j/rowSize); got != want {
+				t.Fatalf("split %d value %d: want %v, got %v", i, j, want, got)
+			}
+		}
+	}
+}
+
+func TestSafetensorStreaming(t *testing.T) {
+	// more values than are converted at once
+	bf16s := make([]uint16, safetensorsChunkSize/5*5+10)
+	for i := range bf16s {
+		bf16s[i] = uint16(0x3f80 + i%64)
+	}
+
+	dir := t.TempDir()
+	generateSafetensorTestData(t, dir, map[string]*tensorData{
+		"a.weight": {Type: "BF16", Shape: []int{len(bf16s) / 5, 5}, Data: bf16s},
+		"b.weight": {Type: "F16", Shape: []int{2, 2}, Data: []uint16{0x3c00, 0x4000, 0x4200, 0x4400}},
+	})
+
+	ts, err := parseSafetensors(os.DirFS(dir), strings.NewReplacer(), "model-00001-of-00001.safetensors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	n, err := ts[0].WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(len(bf16s))*2 || b.Len() != len(bf16s)*2 {
+		t.Fatalf("unexpected size: %d, %d", n, b.Len())
+	}
+
+	f16s := make([]uint16, len(bf16s))
+	if err := binary.Read(&b, binary.LittleEndian, f16s); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range f16s {
+		want := math.Float32frombits(uint32(bf16s[i]) << 16)
+		if got := float16.Frombits(f16s[i]).Float32(); got != want {
+			t.Fatalf("value %d: want %v, got %v", i, want, got)
+		}
+	}
+
+	// tensors already in the output type are copied as is
+	b.Reset()
+	if _, err := ts[1].WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	f16s = make([]uint16, 4)
+	if err := binary.Read(&b, binary.LittleEndian, f16s); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(f16s, []uint16{0x3c00, 0x4000, 0x4200, 0x4400}) {
+		t.Errorf("unexpected values %x", f16s)
+	}
+
+	// row repackers are given whole rows a chunk at a time
+	var calls int
+	ts[0].SetRowRepacker(1, func(_ string, data []float32, shape []uint64) ([]float32, error) {
+		calls++
+		if len(shape) != 2 || shape[1] != 5 || uint64(len(data)) != shape[0]*5 {
+			t.Fatalf("repacker called with %d values of shape %v", len(data), shape)
+		}
+
+		for i := range data {
+			data[i] = -data[i]
+		}
+		return data, nil
+	})
+
+	b.Reset()
+	if n, err := ts[0].WriteTo(&b); err != nil {
+		t.Fatal(err)
+	} else if n != int64(len(bf16s))*2 {
+		t.Fatalf("unexpected size: %d", n)
+	}
+
+	if calls < 2 {
+		t.Errorf("repacker called %d times; want a call per chunk", calls)
+	}
+
+	f16s = make([]uint16, len(bf16s))
+	if err := binary.Read(&b, binary.LittleEndian, f16s); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range f16s {
+		want := -math.Float32frombits(uint32(bf16s[i]) << 16)
+		if got := float16.Frombits(f16s[i]).Float32(); got != want {
+			t.Fatalf("repacked value %d: want %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestConvertPEFTAdapter(t *testing.T) {
+	// a llama model with 2 layers, 2 heads of 4 dimensions and 16 features in its feed forward layers
+	baseKV := ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(2),
+		"llama.embedding_length":        uint32(8),
+		"llama.feed_forward_length":     uint32(16),
+		"llama.attention.head_count":    uint32(2),
+		"llama.attention.head_count_kv": uint32(2),
+	}
+
+	const rank = 2
+	values := func(n, seed int) []float32 {
+		f32s := make([]float32, n)
+		for i := range f32s {
+			f32s[i] = float32((i*7+seed)%17-8) / 8
+		}
+		return f32s
+	}
+
+	qA, qB := values(rank*8, 1), values(8*rank, 2)
+	vA, vB := values(rank*8, 3), values(8*rank, 4)
+	oA, oB, oM := values(rank*8, 5), values(8*rank, 6), values(8, 7)
+
+	config := map[string]any{
+		"r":              rank,
+		"lora_alpha":     16,
+		"use_rslora":     true,
+		"use_dora":       true,
+		"target_modules": []string{"q_proj", "v_proj", "o_proj"},
+		"alpha_pattern":  map[string]float32{"v_proj": 32},
+	}
+
+	qProj := map[string]*tensorData{
+		"base_model.model.model.layers.1.self_attn.q_proj.lora_A.weight": {Type: "F32", Shape: []int{rank, 8}, Data: qA},
+		"base_model.model.model.layers.1.self_attn.q_proj.lora_B.weight": {Type: "F32", Shape: []int{8, rank}, Data: qB},
+	}
+
+	// with returns the q_proj update along with more tensors
+	with := func(more map[string]*tensorData) map[string]*tensorData {
+		ts := maps.Clone(qProj)
+		maps.Copy(ts, more)
+		return ts
+	}
+
+	tensors := with(map[string]*tensorData{
+		"base_model.model.model.layers.1.self_attn.v_proj.lora_A.weight":         {Type: "F32", Shape: []int{rank, 8}, Data: vA},
+		"base_model.model.model.layers.1.self_attn.v_proj.lora_B.weight":         {Type: "F32", Shape: []int{8, rank}, Data: vB},
+		"base_model.model.model.layers.0.self_attn.o_proj.lora_A.weight":         {Type: "F32", Shape: []int{rank, 8}, Data: oA},
+		"base_model.model.model.layers.0.self_attn.o_proj.lora_B.weight":         {Type: "F32", Shape: []int{8, rank}, Data: oB},
+		"base_model.model.model.layers.0.self_attn.o_proj.lora_magnitude_vector": {Type: "F32", Shape: []int{8}, Data: oM},
+	})
+
+	convert := func(t *testing.T, config map[string]any, tensors map[string]*tensorData) (*os.File, ggml.KV, ggml.Tensors, error) {
+		t.Helper()
+
+		dir := t.TempDir()
+		generateSafetensorTestData(t, dir, tensors, withCheckpoint("adapter_model.safetensors"), withFiles(nil))
+
+		bts, err := json.Marshal(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, "adapter_config.json"), bts, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Create(filepath.Join(t.TempDir(), "adapter.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+
+		if err := ConvertAdapter(os.DirFS(dir), f, baseKV, nil); err != nil {
+			return nil, nil, ggml.Tensors{}, err
+		}
+
+		m, _, err := ggml.Decode(io.NewSectionReader(f, 0, math.MaxInt64), math.MaxInt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return f, m.KV(), m.Tensors(), nil
+	}
+
+	t.Run("convert", func(t *testing.T) {
+		f, kv, ts, err := convert(t, config, tensors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := kv["adapter.lora.alpha"]; got != float32(16) {
+			t.Errorf("unexpected alpha %v", got)
+		}
+
+		read := func(name string) []float32 {
+			t.Helper()
+			for _, tensor := range ts.Items() {
+				if tensor.Name != name {
+					continue
+				}
+
+				sr := io.NewSectionReader(f, int64(ts.Offset+tensor.Offset), int64(tensor.Size()))
+				switch tensor.Kind {
+				case 0:
+					f32s := make([]float32, tensor.Size()/4)
+					if err := binary.Read(sr, binary.LittleEndian, f32s); err != nil {
+						t.Fatal(err)
+					}
+					return f32s
+				case 1:
+					f16s := make([]uint16, tensor.Size()/2)
+					if err := binary.Read(sr, binary.LittleEndian, f16s); err != nil {
+						t.Fatal(err)
+					}
+
+					f32s := make([]float32, len(f16s))
+					for i := range f16s {
+						f32s[i] = float16.Frombits(f16s[i]).Float32()
+					}
+					return f32s
+				}
+			}
+
+			t.Fatalf("missing tensor %s", name)
+			return nil
+		}
+
+		// rows of the query and key weights are interleaved within each head
+		// and rows of their updates need to be as well
+		permute := func(rows []float32, cols int) []float32 {
+			const heads, dims = 2, 4
+			out := make([]float32, len(rows))
+			for h := range heads {
+				for i := range dims / 2 {
+					for j := range 2 {
+						copy(out[(h*dims+i*2+j)*cols:][:cols], rows[(h*dims+j*dims/2+i)*cols:][:cols])
+					}
+				}
+			}
+			return out
+		}
+
+		scale := func(f32s []float32, scale float32) []float32 {
+			out := make([]float32, len(f32s))
+			for i := range f32s {
+				out[i] = f32s[i] * scale
+			}
+			return out
+		}
+
+		// rsLoRA scales updates by alpha / sqrt(rank) rather than alpha / rank
+		rs := float32(math.Sqrt(rank))
+		for name, want := range map[string][]float32{
+			"blk.1.attn_q.weight.lora_a":      qA,
+			"blk.1.attn_q.weight.lora_b":      scale(permute(qB, rank), rs),
+			"blk.1.attn_v.weight.lora_a":      vA,
+			"blk.1.attn_v.weight.lora_b":      scale(vB, 2*rs),
+			"blk.0.attn_output.weight.lora_a": oA,
+			"blk.0.attn_output.weight.lora_b": scale(oB, rs),
+			"blk.0.attn_output.weight.lora_m": oM,
+		} {
+			got := read(name)
+			if len(got) != len(want) {
+				t.Fatalf("%s: want %d values, got %d", name, len(want), len(got))
+			}
+
+			for i := range got {
+				if math.Abs(float64(got[i]-want[i])) > 1e-3 {
+					t.Errorf("%s: value %d: want %v, got %v", name, i, want[i], got[i])
+					break
+				}
+			}
+		}
+	})
+
+	errorCases := []struct {
+		name    string
+		config  map[string]any
+		tensors map[string]*tensorData
+		err     string
+	}{
+		{
+			name:    "rank",
+			config:  map[string]any{"r": 4, "lora_alpha": 16},
+			tensors: qProj,
+			err:     "rank 2 doesn't match rank 4",
+		},
+		{
+			name:   "shape",
+			config: map[string]any{"r": rank, "lora_alpha": 16},
+			tensors: map[string]*tensorData{
+				"base_model.model.model.layers.0.mlp.up_proj.lora_A.weight": {Type: "F32", Shape: []int{rank, 8}, Data: values(rank*8, 0)},
+				"base_model.model.model.layers.0.mlp.up_proj.lora_B.weight": {Type: "F32", Shape: []int{12, rank}, Data: values(12*rank, 0)},
+			},
+			err: "update of shape 12x8 doesn't match the base model weight of shape 16x8",
+		},
+		{
+			name:   "layers",
+			config: map[string]any{"r": rank, "lora_alpha": 16},
+			tensors: with(map[string]*tensorData{
+				"base_model.model.model.layers.2.self_attn.q_proj.lora_A.weight": {Type: "F32", Shape: []int{rank, 8}, Data: qA},
+				"base_model.model.model.layers.2.self_attn.q_proj.lora_B.weight": {Type: "F32", Shape: []int{8, rank}, Data: qB},
+			}),
+			err: "layer 2 is beyond the 2 layers",
+		},
+		{
+			name:    "target modules",
+			config:  map[string]any{"r": rank, "lora_alpha": 16, "target_modules": "model.layers.1.self_attn.(v|o)_proj"},
+			tensors: qProj,
+			err:     "isn't one of the adapter's target modules",
+		},
+		{
+			name:   "missing",
+			config: map[string]any{"r": rank, "lora_alpha": 16},
+			tensors: map[string]*tensorData{
+				"base_model.model.model.layers.1.self_attn.q_proj.lora_A.weight": {Type: "F32", Shape: []int{rank, 8}, Data: qA},
+			},
+			err: "missing LoRA A or B weights",
+		},
+		{
+			name:   "modules to save",
+			config: map[string]any{"r": rank, "lora_alpha": 16},
+			tensors: with(map[string]*tensorData{
+				"base_model.model.lm_head.weight": {Type: "F32", Shape: []int{4, 8}, Data: values(32, 0)},
+			}),
+			err: "only LoRA weights are supported",
+		},
+	}
+
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := convert(t, tt.config, tt.tensors)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("want error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestAdapterBaseRepackers(t *testing.T) {
+	for _, arch := range []string{
+		"llama", "granite", "olmo", "olmo2", "falcon", "gemma", "gemma2", "gemma3", "phi3", "qwen2",
+		"qwen2moe", "qwen2vl", "deepseek2", "stablelm", "starcoder2", "bert", "command-r",
+	} {
+		t.Run(arch, func(t *testing.T) {
+			base, err := adapterBase(ggml.KV{
+				"general.architecture":            arch,
+				arch + ".embedding_length":        uint32(8),
+				arch + ".attention.head_count":    uint32(2),
+				arch + ".attention.head_count_kv": uint32(2),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := loraAdapter{base: base}
+			for _, name := range []string{"blk.0.attn_q.weight", "blk.0.attn_qkv.weight", "blk.0.ffn_up.weight"} {
+				repack := p.baseRepacker(name, []uint64{24, 2})
+				if repack == nil {
+					continue
+				}
+
+				if _, err := repack(name, make([]float32, 48), []uint64{24, 2}); err != nil && !strings.Contains(err.Error(), "unexpected shape") {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package convert
+
+import (
+	"cmp"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// whisperModel converts Whisper, an audio encoder and a text decoder which
+// attends to the encoded audio through cross attention
+type whisperModel struct {
+	ModelParameters
+	DModel                uint32 `json:"d_model"`
+	EncoderLayers         uint32 `json:"encoder_layers"`
+	EncoderAttentionHeads uint32 `json:"encoder_attention_heads"`
+	DecoderLayers         uint32 `json:"decoder_layers"`
+	DecoderAttentionHeads uint32 `json:"decoder_attention_heads"`
+	DecoderFFNDim         uint32 `json:"decoder_ffn_dim"`
+	MaxTargetPositions    uint32 `json:"max_target_positions"`
+	NumMelBins            uint32 `json:"num_mel_bins"`
+}
+
+var _ ModelConverter = (*whisperModel)(nil)
+
+func (w *whisperModel) KV(t *Tokenizer) ggml.KV {
+	kv := w.ModelParameters.KV(t)
+	kv["general.architecture"] = "whisper"
+	kv["whisper.block_count"] = w.DecoderLayers
+	kv["whisper.context_length"] = cmp.Or(w.MaxTargetPositions, 448)
+	kv["whisper.embedding_length"] = w.DModel
+	kv["whisper.feed_forward_length"] = w.DecoderFFNDim
+	kv["whisper.attention.head_count"] = w.DecoderAttentionHeads
+	kv["whisper.attention.layer_norm_epsilon"] = float32(1e-5)
+
+	kv["whisper.audio.block_count"] = w.EncoderLayers
+	kv["whisper.audio.embedding_length"] = w.DModel
+	kv["whisper.audio.attention.head_count"] = w.EncoderAttentionHeads
+	kv["whisper.audio.attention.layer_norm_epsilon"] = float32(1e-5)
+	kv["whisper.audio.num_mel_bins"] = cmp.Or(w.NumMelBins, 80)
+	return kv
+}
+
+func (w *whisperModel) Tensors(ts []Tensor) []ggml.Tensor {
+	return audioTensors(ts)
+}
+
+func (w *whisperModel) Replacements() []string {
+	return append(
+		audioEncoderReplacements("model.encoder."),
+		"model.decoder.embed_tokens", "token_embd",
+		"model.decoder.embed_positions", "position_embd",
+		"model.decoder.layers", "blk",
+		"model.decoder.layer_norm", "output_norm",
+		"proj_out", "output",
+		"encoder_attn_layer_norm", "cross_attn_norm",
+		"encoder_attn.q_proj", "cross_attn_q",
+		"encoder_attn.k_proj", "cross_attn_k",
+		"encoder_attn.v_proj", "cross_attn_v",
+		"encoder_attn.out_proj", "cross_attn_output",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+	)
+}
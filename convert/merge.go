@@ -0,0 +1,79 @@
+package convert
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/maps"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// merge stacks tensors matching pattern, e.g. the weights of each expert of a
+// layer, into a single tensor. pattern is matched segment by segment where "*"
+// matches any one segment. The first "*" selects the layer, which is formatted
+// into name, and the second orders the tensors within it.
+type merge struct {
+	pattern, name string
+}
+
+func (m merge) match(name string) (layer, index string, ok bool) {
+	patterns, names := strings.Split(m.pattern, "."), strings.Split(name, ".")
+	if len(patterns) != len(names) {
+		return "", "", false
+	}
+
+	var wildcards []string
+	for i := range patterns {
+		if patterns[i] == "*" {
+			wildcards = append(wildcards, names[i])
+		} else if patterns[i] != names[i] {
+			return "", "", false
+		}
+	}
+
+	if len(wildcards) != 2 {
+		return "", "", false
+	}
+
+	return wildcards[0], wildcards[1], true
+}
+
+// mergeTensors stacks the tensors matching each merge along a new, outermost
+// dimension and returns them along with the tensors that weren't merged
+func mergeTensors(unmatched []Tensor, merges ...merge) (out []ggml.Tensor, _ []Tensor) {
+	for _, m := range merges {
+		layers := make(map[string][]Tensor)
+		indices := make(map[string]int)
+		unmatched = slices.DeleteFunc(unmatched, func(t Tensor) bool {
+			layer, index, ok := m.match(t.Name())
+			if ok {
+				layers[layer] = append(layers[layer], t)
+				indices[t.Name()], _ = strconv.Atoi(index)
+			}
+
+			return ok
+		})
+
+		keys := maps.Keys(layers)
+		slices.Sort(keys)
+		for _, layer := range keys {
+			ts := layers[layer]
+			slices.SortFunc(ts, func(a, b Tensor) int {
+				return cmp.Compare(indices[a.Name()], indices[b.Name()])
+			})
+
+			out = append(out, ggml.Tensor{
+				Name:     fmt.Sprintf(m.name, layer),
+				Kind:     ts[0].Kind(),
+				Shape:    append([]uint64{uint64(len(ts))}, ts[0].Shape()...),
+				WriterTo: experts(ts),
+			})
+		}
+	}
+
+	return out, unmatched
+}
@@ -12,6 +12,7 @@ type Tensor interface {
 	Shape() []uint64
 	Kind() uint32
 	SetRepacker(repacker)
+	SetRowRepacker(uint64, repacker)
 	WriteTo(io.Writer) (int64, error)
 }
 
@@ -19,6 +20,11 @@ type tensorBase struct {
 	name  string
 	shape []uint64
 	repacker
+
+	// repackRows is the number of rows, along the first dimension, the
+	// repacker transforms independently of the rest of the tensor, or 0 if
+	// it needs the whole tensor
+	repackRows uint64
 }
 
 func (t tensorBase) Name() string {
@@ -52,7 +58,14 @@ func (t tensorBase) Kind() uint32 {
 }
 
 func (t *tensorBase) SetRepacker(fn repacker) {
-	t.repacker = fn
+	t.repacker, t.repackRows = fn, 0
+}
+
+// SetRowRepacker sets a repacker that transforms each block of rows of the
+// tensor on its own, so the tensor can be repacked as it's streamed. fn is
+// called with the shape of the rows it's given, which are a multiple of rows.
+func (t *tensorBase) SetRowRepacker(rows uint64, fn repacker) {
+	t.repacker, t.repackRows = fn, rows
 }
 
 type repacker func(string, []float32, []uint64) ([]float32, error)
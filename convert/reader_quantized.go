@@ -0,0 +1,424 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// dequantizeSafetensors replaces the packed tensors of GPTQ, AWQ and
+// bitsandbytes checkpoints with float tensors of the original weights and
+// renames all tensors with replacer
+func dequantizeSafetensors(sts []safetensor, replacer *strings.Replacer) ([]Tensor, error) {
+	byName := make(map[string]safetensor, len(sts))
+	for _, st := range sts {
+		byName[st.name] = st
+	}
+
+	groups := make(map[string]Tensor)
+	parts := make(map[string]struct{})
+	for _, st := range sts {
+		var t *quantizedSafetensor
+		var err error
+		if base, ok := strings.CutSuffix(st.name, ".qweight"); ok {
+			t, err = newPackedSafetensor(base, byName)
+		} else if base, ok := strings.CutSuffix(st.name, ".weight"); ok {
+			if _, ok := byName[st.name+".quant_state.bitsandbytes__nf4"]; ok {
+				t, err = newBnb4Safetensor(base, "nf4", byName)
+			} else if _, ok := byName[st.name+".quant_state.bitsandbytes__fp4"]; ok {
+				t, err = newBnb4Safetensor(base, "fp4", byName)
+			} else if _, ok := byName[base+".SCB"]; ok && st.dtype == "I8" {
+				t, err = newBnb8Safetensor(base, byName)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		} else if t == nil {
+			continue
+		}
+
+		t.name = replacer.Replace(t.name)
+		groups[st.name] = t
+		for _, part := range t.parts {
+			parts[part.name] = struct{}{}
+		}
+	}
+
+	ts := make([]Tensor, 0, len(sts))
+	for _, st := range sts {
+		if t, ok := groups[st.name]; ok {
+			ts = append(ts, t)
+		} else if _, ok := parts[st.name]; !ok {
+			st.tensorBase.name = replacer.Replace(st.name)
+			ts = append(ts, st)
+		}
+	}
+
+	return ts, nil
+}
+
+// quantizedSafetensor is a weight stored as several packed tensors. It is
+// written as the float tensor they were quantized from.
+type quantizedSafetensor struct {
+	format string
+	parts  map[string]safetensor
+	bits   int
+
+	// bitsandbytes block sizes and the offset of the double quantized scales
+	blockSize, nestedBlockSize int
+	nestedOffset               float32
+
+	*tensorBase
+}
+
+func lookupParts(byName map[string]safetensor, base string, required []string, optional ...string) (map[string]safetensor, error) {
+	parts := make(map[string]safetensor)
+	for _, suffix := range required {
+		st, ok := byName[base+"."+suffix]
+		if !ok {
+			return nil, fmt.Errorf("%s: missing quantized tensor %s", base, suffix)
+		}
+		parts[suffix] = st
+	}
+
+	for _, suffix := range optional {
+		if st, ok := byName[base+"."+suffix]; ok {
+			parts[suffix] = st
+		}
+	}
+
+	return parts, nil
+}
+
+// newPackedSafetensor reads the shapes of a GPTQ or AWQ weight. Both pack
+// integers into int32 with one scale and zero point per group of input
+// features; GPTQ packs along the input features while AWQ packs along the
+// output features.
+func newPackedSafetensor(base string, byName map[string]safetensor) (*quantizedSafetensor, error) {
+	parts, err := lookupParts(byName, base, []string{"qweight", "qzeros", "scales"}, "g_idx")
+	if err != nil {
+		return nil, err
+	}
+
+	qweight, qzeros, scales := parts["qweight"].shape, parts["qzeros"].shape, parts["scales"].shape
+	if len(qweight) != 2 || len(qzeros) != 2 || len(scales) != 2 || scales[1] == 0 {
+		return nil, fmt.Errorf("%s: unexpected quantized tensor shapes", base)
+	}
+
+	out := scales[1]
+	t := quantizedSafetensor{parts: parts}
+	var in uint64
+	if qweight[1] == out {
+		t.format = "gptq"
+		t.bits = int(32 * qzeros[1] / out)
+		if t.bits > 0 {
+			in = qweight[0] * 32 / uint64(t.bits)
+		}
+	} else {
+		t.format = "awq"
+		t.bits = int(32 * qweight[1] / out)
+		in = qweight[0]
+	}
+
+	switch {
+	case t.format == "gptq" && t.bits != 2 && t.bits != 4 && t.bits != 8,
+		t.format == "awq" && t.bits != 4:
+		return nil, fmt.Errorf("%s: unsupported %d-bit %s weights", base, t.bits, t.format)
+	case in%scales[0] != 0:
+		return nil, fmt.Errorf("%s: %d input features don't divide into %d groups", base, in, scales[0])
+	}
+
+	t.tensorBase = &tensorBase{name: base + ".weight", shape: []uint64{out, in}}
+	return &t, nil
+}
+
+// newBnb4Safetensor reads the quantization state of a bitsandbytes 4-bit
+// weight. The state is serialized as JSON in a tensor of bytes.
+func newBnb4Safetensor(base, quantType string, byName map[string]safetensor) (*quantizedSafetensor, error) {
+	parts, err := lookupParts(byName, base, []string{
+		"weight",
+		"weight.absmax",
+		"weight.quant_map",
+		"weight.quant_state.bitsandbytes__" + quantType,
+	}, "weight.nested_absmax", "weight.nested_quant_map")
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := parts["weight.quant_state.bitsandbytes__"+quantType].bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var state struct {
+		Shape           []uint64 `json:"shape"`
+		BlockSize       int      `json:"blocksize"`
+		NestedBlockSize int      `json:"nested_blocksize"`
+		NestedOffset    float32  `json:"nested_offset"`
+	}
+	if err := json.Unmarshal(bts, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", base, err)
+	}
+
+	_, nested := parts["weight.nested_absmax"]
+	if len(state.Shape) == 0 || state.BlockSize <= 0 || nested && state.NestedBlockSize <= 0 {
+		return nil, fmt.Errorf("%s: invalid bitsandbytes quantization state", base)
+	}
+
+	return &quantizedSafetensor{
+		format:          "bnb4",
+		parts:           parts,
+		bits:            4,
+		blockSize:       state.BlockSize,
+		nestedBlockSize: state.NestedBlockSize,
+		nestedOffset:    state.NestedOffset,
+		tensorBase:      &tensorBase{name: base + ".weight", shape: state.Shape},
+	}, nil
+}
+
+// newBnb8Safetensor reads a bitsandbytes 8-bit weight which has one scale per
+// output feature
+func newBnb8Safetensor(base string, byName map[string]safetensor) (*quantizedSafetensor, error) {
+	parts, err := lookupParts(byName, base, []string{"weight", "SCB"}, "weight_format")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts["weight"].shape) != 2 {
+		return nil, fmt.Errorf("%s: unexpected quantized tensor shape", base)
+	}
+
+	return &quantizedSafetensor{
+		format:     "bnb8",
+		parts:      parts,
+		bits:       8,
+		tensorBase: &tensorBase{name: base + ".weight", shape: parts["weight"].shape},
+	}, nil
+}
+
+// WriteTo dequantizes the weight a chunk at a time. The packed tensors it's
+// dequantized from are read whole, which take a fraction of the memory of the
+// float weight.
+func (t quantizedSafetensor) WriteTo(w io.Writer) (int64, error) {
+	var dequantize dequantizer
+	var err error
+	switch t.format {
+	case "gptq", "awq":
+		dequantize, err = t.dequantizePacked()
+	case "bnb4":
+		dequantize, err = t.dequantizeBnb4()
+	case "bnb8":
+		dequantize, err = t.dequantizeBnb8()
+	default:
+		err = fmt.Errorf("unknown quantization format: %s", t.format)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int
+	var f32s []float32
+	return t.writeChunks(w, func(n int) ([]float32, error) {
+		if cap(f32s) < n {
+			f32s = make([]float32, n)
+		}
+
+		dequantize(f32s[:n], offset)
+		offset += n
+		return f32s[:n], nil
+	})
+}
+
+// dequantizer fills f32s with the values of a weight, in row major order,
+// starting from the value at offset
+type dequantizer func(f32s []float32, offset int)
+
+// awqOrder is the position within each packed int32 of eight consecutive
+// output features of an AWQ weight
+var awqOrder = [8]int{0, 4, 1, 5, 2, 6, 3, 7}
+
+func (t quantizedSafetensor) dequantizePacked() (dequantizer, error) {
+	qweight, err := t.parts["qweight"].uint32s()
+	if err != nil {
+		return nil, err
+	}
+
+	qzeros, err := t.parts["qzeros"].uint32s()
+	if err != nil {
+		return nil, err
+	}
+
+	scales, err := t.parts["scales"].float32s()
+	if err != nil {
+		return nil, err
+	}
+
+	var gidx []uint32
+	if st, ok := t.parts["g_idx"]; ok {
+		if gidx, err = st.uint32s(); err != nil {
+			return nil, err
+		}
+	}
+
+	out, in := int(t.shape[0]), int(t.shape[1])
+	groups := int(t.parts["scales"].shape[0])
+	per, mask := 32/t.bits, uint32(1)<<t.bits-1
+	if len(qweight) != in*out/per || len(qzeros) != groups*out/per || len(scales) != groups*out || gidx != nil && len(gidx) != in {
+		return nil, fmt.Errorf("%s: unexpected quantized tensor sizes", t.name)
+	}
+
+	// the group of each input feature
+	group := make([]int, in)
+	for i := range group {
+		group[i] = i / (in / groups)
+		if gidx != nil {
+			group[i] = int(gidx[i])
+			if group[i] >= groups {
+				return nil, fmt.Errorf("%s: group index %d out of range", t.name, group[i])
+			}
+		}
+	}
+
+	return func(f32s []float32, offset int) {
+		for k := range f32s {
+			j, i := (offset+k)/in, (offset+k)%in
+			g := group[i]
+
+			var q, z uint32
+			switch t.format {
+			case "gptq":
+				q = (qweight[i/per*out+j] >> (i % per * t.bits)) & mask
+				// zero points are stored minus one
+				z = (qzeros[g*out/per+j/per]>>(j%per*t.bits))&mask + 1
+			case "awq":
+				shift := awqOrder[j%per] * t.bits
+				q = (qweight[i*out/per+j/per] >> shift) & mask
+				z = (qzeros[g*out/per+j/per] >> shift) & mask
+			}
+
+			f32s[k] = (float32(q) - float32(z)) * scales[g*out+j]
+		}
+	}, nil
+}
+
+func (t quantizedSafetensor) dequantizeBnb4() (dequantizer, error) {
+	packed, err := t.parts["weight"].bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	quantMap, err := t.parts["weight.quant_map"].float32s()
+	if err != nil {
+		return nil, err
+	}
+
+	absmax, err := t.absmax()
+	if err != nil {
+		return nil, err
+	}
+
+	n := 1
+	for _, dim := range t.shape {
+		n *= int(dim)
+	}
+
+	if len(packed)*2 < n || len(absmax)*t.blockSize < n || len(quantMap) < 16 {
+		return nil, fmt.Errorf("%s: unexpected quantized tensor sizes", t.name)
+	}
+
+	return func(f32s []float32, offset int) {
+		for k := range f32s {
+			i := offset + k
+
+			// the first of each pair of values is in the high bits
+			q := packed[i/2] >> 4
+			if i%2 == 1 {
+				q = packed[i/2] & 0xf
+			}
+
+			f32s[k] = quantMap[q] * absmax[i/t.blockSize]
+		}
+	}, nil
+}
+
+// absmax returns the scale of each block of a bitsandbytes 4-bit weight. With
+// double quantization the scales are themselves quantized to 8 bits.
+func (t quantizedSafetensor) absmax() ([]float32, error) {
+	st, ok := t.parts["weight.nested_absmax"]
+	if !ok {
+		return t.parts["weight.absmax"].float32s()
+	}
+
+	nestedAbsmax, err := st.float32s()
+	if err != nil {
+		return nil, err
+	}
+
+	quantMap, err := t.parts["weight.nested_quant_map"].float32s()
+	if err != nil {
+		return nil, err
+	}
+
+	qs, err := t.parts["weight.absmax"].bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nestedAbsmax)*t.nestedBlockSize < len(qs) || len(quantMap) < 256 {
+		return nil, fmt.Errorf("%s: unexpected quantized tensor sizes", t.name)
+	}
+
+	absmax := make([]float32, len(qs))
+	for i, q := range qs {
+		absmax[i] = quantMap[q]*nestedAbsmax[i/t.nestedBlockSize] + t.nestedOffset
+	}
+
+	return absmax, nil
+}
+
+func (t quantizedSafetensor) dequantizeBnb8() (dequantizer, error) {
+	qs, err := t.parts["weight"].bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	scb, err := t.parts["SCB"].float32s()
+	if err != nil {
+		return nil, err
+	}
+
+	out, in := int(t.shape[0]), int(t.shape[1])
+	if len(qs) != out*in || len(scb) != out {
+		return nil, fmt.Errorf("%s: unexpected quantized tensor sizes", t.name)
+	}
+
+	return func(f32s []float32, offset int) {
+		for k := range f32s {
+			i := offset + k
+			f32s[k] = float32(int8(qs[i])) * scb[i/in] / 127
+		}
+	}, nil
+}
+
+// uint32s reads a tensor of packed 32-bit integers
+func (st safetensor) uint32s() ([]uint32, error) {
+	if st.dtype != "I32" && st.dtype != "U32" {
+		return nil, fmt.Errorf("%s: unexpected data type: %s", st.name, st.dtype)
+	}
+
+	bts, err := st.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	u32s := make([]uint32, len(bts)/4)
+	if err := binary.Read(bytes.NewReader(bts), binary.LittleEndian, u32s); err != nil {
+		return nil, err
+	}
+
+	return u32s, nil
+}
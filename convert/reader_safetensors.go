@@ -8,12 +8,14 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
 	"slices"
 	"strings"
 
 	"github.com/d4l3k/go-bfloat16"
 	"github.com/x448/float16"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/mmap"
 )
 
 type safetensorMetadata struct {
@@ -23,7 +25,7 @@ type safetensorMetadata struct {
 }
 
 func parseSafetensors(fsys fs.FS, replacer *strings.Replacer, ps ...string) ([]Tensor, error) {
-	var ts []Tensor
+	var sts []safetensor
 	for _, p := range ps {
 		f, err := fsys.Open(p)
 		if err != nil {
@@ -49,27 +51,16 @@ func parseSafetensors(fsys fs.FS, replacer *strings.Replacer, ps ...string) ([]T
 		keys := maps.Keys(headers)
 		slices.Sort(keys)
 
-		names := make(map[string]struct{}, len(keys))
-
 		for _, key := range keys {
 			if value := headers[key]; value.Type != "" {
-				// bitsandbytes quantized models are unsupported
-				if len(value.Shape) == 0 {
-					return nil, errors.New("unsupported safetensors model")
-				}
-				ggufName := replacer.Replace(key)
-				if _, ok := names[ggufName]; ok {
-					return nil, fmt.Errorf("duplicate tensor name '%s' was found for this model", ggufName)
-				}
-				names[ggufName] = struct{}{}
-				ts = append(ts, safetensor{
+				sts = append(sts, safetensor{
 					fs:     fsys,
 					path:   p,
 					dtype:  value.Type,
 					offset: safetensorsPad(n, value.Offsets[0]),
 					size:   safetensorsPad(n, value.Offsets[1]) - safetensorsPad(n, value.Offsets[0]),
 					tensorBase: &tensorBase{
-						name:  ggufName,
+						name:  key,
 						shape: value.Shape,
 					},
 				})
@@ -77,6 +68,27 @@ func parseSafetensors(fsys fs.FS, replacer *strings.Replacer, ps ...string) ([]T
 		}
 	}
 
+	// pre-quantized checkpoints split each weight into several packed tensors
+	// which are reassembled into a single float tensor
+	ts, err := dequantizeSafetensors(sts, replacer)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(ts))
+	for _, t := range ts {
+		// remaining tensors without a shape hold quantization state of an
+		// unsupported format
+		if len(t.Shape()) == 0 {
+			return nil, errors.New("unsupported safetensors model")
+		}
+
+		if _, ok := names[t.Name()]; ok {
+			return nil, fmt.Errorf("duplicate tensor name '%s' was found for this model", t.Name())
+		}
+		names[t.Name()] = struct{}{}
+	}
+
 	return ts, nil
 }
 
@@ -94,34 +106,170 @@ type safetensor struct {
 	*tensorBase
 }
 
+// safetensorsChunkSize is the number of values converted at a time while
+// streaming a tensor to the output
+const safetensorsChunkSize = 1 << 20
+
+// WriteTo converts the tensor a chunk at a time so memory use doesn't depend
+// on its size. Tensors already in the output type are copied as is.
 func (st safetensor) WriteTo(w io.Writer) (int64, error) {
-	f, err := st.fs.Open(st.path)
+	var size int
+	switch st.dtype {
+	case "F32":
+		size = 4
+	case "F16", "BF16":
+		size = 2
+	default:
+		return 0, fmt.Errorf("unknown data type: %s", st.dtype)
+	}
+
+	r, err := st.open()
 	if err != nil {
 		return 0, err
 	}
-	defer f.Close()
+	defer r.Close()
+
+	if st.repacker == nil && (st.dtype == "F32" && st.Kind() == tensorKindF32 || st.dtype == "F16" && st.Kind() == tensorKindF16) {
+		return io.Copy(w, r)
+	}
+
+	var buf []byte
+	return st.writeChunks(w, func(n int) ([]float32, error) {
+		if cap(buf) < n*size {
+			buf = make([]byte, n*size)
+		}
+
+		bts := buf[:n*size]
+		if _, err := io.ReadFull(r, bts); err != nil {
+			return nil, err
+		}
+
+		return decodeFloat32s(st.dtype, bts)
+	})
+}
+
+// writeChunks writes the values of the tensor, which next returns n at a
+// time, converted to its kind. Tensors with a row repacker are repacked a
+// chunk of whole blocks of rows at a time. Tensors with any other repacker,
+// such as those that reorder attention heads, are taken whole since
+// repacking may move values anywhere in the tensor.
+func (t *tensorBase) writeChunks(w io.Writer, next func(n int) ([]float32, error)) (int64, error) {
+	rowSize := 1
+	for _, dim := range t.shape[1:] {
+		rowSize *= int(dim)
+	}
+
+	total := int(t.shape[0]) * rowSize
+	chunkSize := safetensorsChunkSize
+	if t.repacker != nil {
+		if t.repackRows == 0 {
+			chunkSize = total
+		} else {
+			blockSize := int(t.repackRows) * rowSize
+			chunkSize = max(chunkSize/blockSize, 1) * blockSize
+		}
+	}
+
+	var written int64
+	for remaining := total; remaining > 0; {
+		f32s, err := next(min(chunkSize, remaining))
+		if err != nil {
+			return written, err
+		}
+		remaining -= len(f32s)
+
+		if t.repacker != nil {
+			shape := append([]uint64{uint64(len(f32s) / rowSize)}, t.shape[1:]...)
+			f32s, err = t.repacker(t.name, f32s, shape)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		n, err := writeFloat32s(w, t.Kind(), f32s)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// open returns a reader of the tensor's data. Files on disk are memory mapped
+// so reading pages them in from the shard instead of copying them through
+// intermediate buffers.
+func (st safetensor) open() (io.ReadCloser, error) {
+	f, err := st.fs.Open(st.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if file, ok := f.(*os.File); ok {
+		if m, err := mmap.Open(file.Name()); err == nil {
+			file.Close()
+			return readCloser{io.NewSectionReader(m, st.offset, st.size), m}, nil
+		}
+	}
 
 	if seeker, ok := f.(io.Seeker); ok {
 		if _, err := seeker.Seek(st.offset, io.SeekStart); err != nil {
-			return 0, err
+			f.Close()
+			return nil, err
 		}
 	} else {
 		if _, err := io.CopyN(io.Discard, f, st.offset); err != nil {
-			return 0, err
+			f.Close()
+			return nil, err
 		}
 	}
 
+	return readCloser{io.LimitReader(f, st.size), f}, nil
+}
+
+// bytes reads the raw data of the tensor
+func (st safetensor) bytes() ([]byte, error) {
+	r, err := st.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	bts := make([]byte, st.size)
+	if _, err := io.ReadFull(r, bts); err != nil {
+		return nil, err
+	}
+
+	return bts, nil
+}
+
+// float32s reads the tensor and converts it to float32
+func (st safetensor) float32s() ([]float32, error) {
+	bts, err := st.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeFloat32s(st.dtype, bts)
+}
+
+func decodeFloat32s(dtype string, bts []byte) ([]float32, error) {
 	var f32s []float32
-	switch st.dtype {
+	switch dtype {
 	case "F32":
-		f32s = make([]float32, st.size/4)
-		if err = binary.Read(f, binary.LittleEndian, f32s); err != nil {
-			return 0, err
+		f32s = make([]float32, len(bts)/4)
+		if err := binary.Read(bytes.NewReader(bts), binary.LittleEndian, f32s); err != nil {
+			return nil, err
 		}
 	case "F16":
-		u16s := make([]uint16, st.size/2)
-		if err = binary.Read(f, binary.LittleEndian, u16s); err != nil {
-			return 0, err
+		u16s := make([]uint16, len(bts)/2)
+		if err := binary.Read(bytes.NewReader(bts), binary.LittleEndian, u16s); err != nil {
+			return nil, err
 		}
 
 		f32s = make([]float32, len(u16s))
@@ -130,34 +278,26 @@ func (st safetensor) WriteTo(w io.Writer) (int64, error) {
 		}
 
 	case "BF16":
-		u8s := make([]uint8, st.size)
-		if err = binary.Read(f, binary.LittleEndian, u8s); err != nil {
-			return 0, err
-		}
-
-		f32s = bfloat16.DecodeFloat32(u8s)
+		f32s = bfloat16.DecodeFloat32(bts)
 	default:
-		return 0, fmt.Errorf("unknown data type: %s", st.dtype)
+		return nil, fmt.Errorf("unknown data type: %s", dtype)
 	}
 
-	if st.repacker != nil {
-		f32s, err = st.repacker(st.Name(), f32s, st.Shape())
-		if err != nil {
-			return 0, err
-		}
-	}
+	return f32s, nil
+}
 
-	switch st.Kind() {
+func writeFloat32s(w io.Writer, kind uint32, f32s []float32) (int64, error) {
+	switch kind {
 	case tensorKindF32:
-		return 0, binary.Write(w, binary.LittleEndian, f32s)
+		return int64(len(f32s)) * 4, binary.Write(w, binary.LittleEndian, f32s)
 	case tensorKindF16:
 		f16s := make([]uint16, len(f32s))
 		for i := range f32s {
 			f16s[i] = float16.Fromfloat32(f32s[i]).Bits()
 		}
 
-		return 0, binary.Write(w, binary.LittleEndian, f16s)
+		return int64(len(f16s)) * 2, binary.Write(w, binary.LittleEndian, f16s)
 	default:
-		return 0, fmt.Errorf("unknown storage type: %d", st.Kind())
+		return 0, fmt.Errorf("unknown storage type: %d", kind)
 	}
 }
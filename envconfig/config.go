@@ -12,6 +12,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/ollama/ollama/format"
 )
 
 // Host returns the scheme and host. Host can be configured via the OLLAMA_HOST environment variable.
@@ -94,6 +96,48 @@ func Models() string {
 	return filepath.Join(home, ".ollama", "models")
 }
 
+// RegistryMirror returns the scheme and host of a registry mirror that models
+// from the default registry are pulled from instead. RegistryMirror can be
+// configured via the OLLAMA_REGISTRY_MIRROR environment variable.
+// Default is no mirror. If no scheme is given, https is used.
+func RegistryMirror() *url.URL {
+	s := Var("OLLAMA_REGISTRY_MIRROR")
+	if s == "" {
+		return nil
+	}
+
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		slog.Warn("invalid registry mirror, ignoring", "mirror", s)
+		return nil
+	}
+
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}
+}
+
+// MaxDownloadRate returns the maximum rate, in bytes per second, at which all
+// pulls together download. MaxDownloadRate can be configured via the
+// OLLAMA_MAX_DOWNLOAD_RATE environment variable, e.g. 10MB. Default is no
+// limit.
+func MaxDownloadRate() int64 {
+	s := Var("OLLAMA_MAX_DOWNLOAD_RATE")
+	if s == "" {
+		return 0
+	}
+
+	n, err := format.ParseBytes(s)
+	if err != nil {
+		slog.Warn("invalid download rate, ignoring", "rate", s)
+		return 0
+	}
+
+	return n
+}
+
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
 // Negative values are treated as infinite. Zero is treated as no keep alive.
 // Default is 5 minutes.
@@ -180,6 +224,16 @@ func String(s string) func() string {
 
 var (
 	LLMLibrary = String("OLLAMA_LLM_LIBRARY")
+	// TrustedKeys is the path of a file of public keys, in authorized_keys
+	// format, that pulled and imported models must be signed by. TrustedKeys
+	// can be configured via the OLLAMA_TRUSTED_KEYS environment variable.
+	// Default is no trust policy, so unsigned models can be pulled.
+	TrustedKeys = String("OLLAMA_TRUSTED_KEYS")
+	// DownloadWindow is the hours of the day, e.g. 22:00-06:00, in which
+	// pulls download. Outside of them, pulls wait. DownloadWindow can be
+	// configured via the OLLAMA_DOWNLOAD_WINDOW environment variable. Default
+	// is all day.
+	DownloadWindow = String("OLLAMA_DOWNLOAD_WINDOW")
 
 	CudaVisibleDevices    = String("CUDA_VISIBLE_DEVICES")
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
@@ -241,6 +295,8 @@ func AsMap() map[string]EnvVar {
 		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
 		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
 		"OLLAMA_KV_CACHE_TYPE":     {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
+		"OLLAMA_DOWNLOAD_WINDOW":   {"OLLAMA_DOWNLOAD_WINDOW", DownloadWindow(), "Only download models during these hours (e.g. 22:00-06:00)"},
+		"OLLAMA_MAX_DOWNLOAD_RATE": {"OLLAMA_MAX_DOWNLOAD_RATE", MaxDownloadRate(), "Maximum download rate of pulls in bytes per second (e.g. 10MB)"},
 		"OLLAMA_GPU_OVERHEAD":      {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
 		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
 		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
@@ -249,6 +305,8 @@ func AsMap() map[string]EnvVar {
 		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
 		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
 		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_REGISTRY_MIRROR":   {"OLLAMA_REGISTRY_MIRROR", RegistryMirror(), "Pull models from the default registry through a mirror"},
+		"OLLAMA_TRUSTED_KEYS":      {"OLLAMA_TRUSTED_KEYS", TrustedKeys(), "Only pull and import models signed by the public keys in this file"},
 		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
 		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
 		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
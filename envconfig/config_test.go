@@ -47,6 +47,52 @@ func TestHost(t *testing.T) {
 	}
 }
 
+func TestRegistryMirror(t *testing.T) {
+	cases := map[string]struct {
+		value  string
+		expect string
+	}{
+		"empty":       {"", ""},
+		"only host":   {"mirror.example.com", "https://mirror.example.com"},
+		"host + port": {"10.0.0.1:5000", "https://10.0.0.1:5000"},
+		"http":        {"http://10.0.0.1:5000", "http://10.0.0.1:5000"},
+		"path":        {"https://mirror.example.com/v2/", "https://mirror.example.com"},
+		"invalid":     {"https://", ""},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("OLLAMA_REGISTRY_MIRROR", tt.value)
+			var got string
+			if u := RegistryMirror(); u != nil {
+				got = u.String()
+			}
+			if got != tt.expect {
+				t.Errorf("%s: expected %s, got %s", name, tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestMaxDownloadRate(t *testing.T) {
+	cases := map[string]int64{
+		"":        0,
+		"1000":    1000,
+		"10MB":    10 * 1000 * 1000,
+		"1.5 MiB": 1.5 * 1024 * 1024,
+		"fast":    0,
+	}
+
+	for value, expect := range cases {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("OLLAMA_MAX_DOWNLOAD_RATE", value)
+			if got := MaxDownloadRate(); got != expect {
+				t.Errorf("%s: expected %d, got %d", value, expect, got)
+			}
+		})
+	}
+}
+
 func TestOrigins(t *testing.T) {
 	cases := []struct {
 		value  string
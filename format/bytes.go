@@ -3,6 +3,8 @@ package format
 import (
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -61,3 +63,35 @@ func HumanBytes2(b uint64) string {
 		return fmt.Sprintf("%d B", b)
 	}
 }
+
+var byteUnits = map[string]float64{
+	"":    Byte,
+	"B":   Byte,
+	"KB":  KiloByte,
+	"MB":  MegaByte,
+	"GB":  GigaByte,
+	"TB":  TeraByte,
+	"KIB": KibiByte,
+	"MIB": MebiByte,
+	"GIB": GibiByte,
+}
+
+// ParseBytes parses a size such as 500MB, 1.5 GB or 2GiB into a number of
+// bytes. Sizes without a unit are in bytes.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	value, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := byteUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if err != nil || !ok || value*unit > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(value * unit), nil
+}
@@ -89,3 +89,38 @@ func TestHumanBytes2(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512B", 512},
+		{"1KB", 1000},
+		{"1.5 GB", 1500000000},
+		{"500mb", 500000000},
+		{"2TB", 2000000000000},
+		{"1KiB", 1024},
+		{"2GiB", 2 * 1024 * 1024 * 1024},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := ParseBytes(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tc.expected {
+				t.Errorf("Expected %d, got %d", tc.expected, result)
+			}
+		})
+	}
+
+	for _, input := range []string{"", "GB", "1PB", "-1GB", "1.2.3MB"} {
+		if _, err := ParseBytes(input); err == nil {
+			t.Errorf("expected error parsing %q", input)
+		}
+	}
+}
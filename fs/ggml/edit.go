@@ -0,0 +1,365 @@
+package ggml
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ggufTypeNames = map[uint32]string{
+	ggufTypeUint8:   "uint8",
+	ggufTypeInt8:    "int8",
+	ggufTypeUint16:  "uint16",
+	ggufTypeInt16:   "int16",
+	ggufTypeUint32:  "uint32",
+	ggufTypeInt32:   "int32",
+	ggufTypeUint64:  "uint64",
+	ggufTypeInt64:   "int64",
+	ggufTypeFloat32: "float32",
+	ggufTypeFloat64: "float64",
+	ggufTypeBool:    "bool",
+	ggufTypeString:  "string",
+}
+
+// TypeOf returns the name of the type of the metadata value v, e.g. uint32
+// or []string
+func TypeOf(v any) string {
+	if a, ok := v.(*array); ok {
+		return "[]" + ggufTypeNames[a.t]
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+// ParseValue parses s as a metadata value of the type named typ. Arrays are
+// given as JSON, e.g. ["a", "b"] for []string.
+func ParseValue(typ, s string) (any, error) {
+	if elem, ok := strings.CutPrefix(typ, "[]"); ok {
+		var t uint32
+		for k, v := range ggufTypeNames {
+			if v == elem {
+				t = k
+			}
+		}
+
+		if elem == "" || ggufTypeNames[t] != elem {
+			return nil, fmt.Errorf("unsupported type %s", typ)
+		}
+
+		var raw []json.RawMessage
+		if err := json.Unmarshal([]byte(s), &raw); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", typ, err)
+		}
+
+		a := &array{t: t, size: len(raw), values: make([]any, len(raw))}
+		for i, r := range raw {
+			e := string(r)
+			if t == ggufTypeString {
+				if err := json.Unmarshal(r, &e); err != nil {
+					return nil, fmt.Errorf("invalid %s: %w", typ, err)
+				}
+			}
+
+			v, err := ParseValue(elem, e)
+			if err != nil {
+				return nil, err
+			}
+
+			a.values[i] = v
+		}
+
+		return a, nil
+	}
+
+	var v any
+	var err error
+	switch typ {
+	case "string":
+		return s, nil
+	case "bool":
+		v, err = strconv.ParseBool(s)
+	case "uint8":
+		var n uint64
+		n, err = strconv.ParseUint(s, 0, 8)
+		v = uint8(n)
+	case "int8":
+		var n int64
+		n, err = strconv.ParseInt(s, 0, 8)
+		v = int8(n)
+	case "uint16":
+		var n uint64
+		n, err = strconv.ParseUint(s, 0, 16)
+		v = uint16(n)
+	case "int16":
+		var n int64
+		n, err = strconv.ParseInt(s, 0, 16)
+		v = int16(n)
+	case "uint32":
+		var n uint64
+		n, err = strconv.ParseUint(s, 0, 32)
+		v = uint32(n)
+	case "int32":
+		var n int64
+		n, err = strconv.ParseInt(s, 0, 32)
+		v = int32(n)
+	case "uint64":
+		v, err = strconv.ParseUint(s, 0, 64)
+	case "int64":
+		v, err = strconv.ParseInt(s, 0, 64)
+	case "float32":
+		var n float64
+		n, err = strconv.ParseFloat(s, 32)
+		v = float32(n)
+	case "float64":
+		v, err = strconv.ParseFloat(s, 64)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", typ)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", typ, s)
+	}
+
+	return v, nil
+}
+
+// FormatValue formats the metadata value v for display with at most n
+// elements of arrays, or all of them if n is negative
+func FormatValue(v any, n int) string {
+	var values []any
+	var size int
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case *array:
+		if len(v.values) != v.size {
+			// arrays larger than maxArraySize are decoded without their values
+			return fmt.Sprintf("[%d values]", v.size)
+		}
+
+		values, size = v.values, v.size
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			return fmt.Sprint(v)
+		}
+
+		size = rv.Len()
+		for i := range size {
+			values = append(values, rv.Index(i).Interface())
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i, e := range values {
+		if n >= 0 && i >= n {
+			fmt.Fprintf(&sb, " ... (%d values)", size)
+			break
+		}
+
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+
+		sb.WriteString(FormatValue(e, n))
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// EditKV reads the GGUF file in r and writes it to ws with its metadata
+// changed by edit. Tensors are copied as they are.
+func EditKV(ws io.WriteSeeker, r io.ReaderAt, edit func(KV) error) error {
+	f, _, err := Decode(io.NewSectionReader(r, 0, math.MaxInt64), -1)
+	if err != nil {
+		return err
+	}
+
+	if f.Name() != "gguf" {
+		return ErrUnsupportedFormat
+	}
+
+	kv := maps.Clone(f.KV())
+	delete(kv, "general.parameter_count")
+	delete(kv, "general.alignment")
+	if err := edit(kv); err != nil {
+		return err
+	}
+
+	tensors := f.Tensors()
+	ts := make([]Tensor, len(tensors.Items()))
+	for i, t := range tensors.Items() {
+		src := io.NewSectionReader(r, int64(tensors.Offset+t.Offset), int64(t.Size()))
+		ts[i] = Tensor{
+			Name: t.Name,
+			Kind: t.Kind,
+			// WriteGGUF expects the outermost dimension first
+			Shape: slices.Clone(t.Shape),
+			WriterTo: WriterFunc(func(w io.Writer) (int64, error) {
+				return io.Copy(w, src)
+			}),
+		}
+		slices.Reverse(ts[i].Shape)
+	}
+
+	return WriteGGUF(ws, kv, ts)
+}
+
+// ExtractTensor writes the data of the tensor named name in the GGUF file in
+// r to w, dequantized to little endian float32s if f32 is true
+func ExtractTensor(w io.Writer, r io.ReaderAt, name string, f32 bool) (*Tensor, error) {
+	f, _, err := Decode(io.NewSectionReader(r, 0, math.MaxInt64), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	tensors := f.Tensors()
+	i := slices.IndexFunc(tensors.Items(), func(t *Tensor) bool { return t.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("tensor %s not found", name)
+	}
+
+	t := tensors.Items()[i]
+	src := io.NewSectionReader(r, int64(tensors.Offset+t.Offset), int64(t.Size()))
+	if !f32 {
+		_, err := io.Copy(w, src)
+		return t, err
+	}
+
+	values, err := readFloat32s(src, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, binary.Write(w, binary.LittleEndian, values)
+}
+
+// Difference is a key or tensor that differs between two GGUF files
+type Difference struct {
+	// Name is the key or the name of the tensor
+	Name string
+
+	// Tensor is true if Name is a tensor
+	Tensor bool
+
+	// A and B are the values of the key, or the tensors, in each file. One
+	// is nil if Name is missing from that file.
+	A, B any
+
+	// Data is true if the tensors have the same type and shape but their
+	// data differs
+	Data bool
+}
+
+// Diff compares the metadata and tensors of the GGUF files in a and b. The
+// data of tensors is only compared if data is true.
+func Diff(a, b io.ReaderAt, data bool) ([]Difference, error) {
+	fa, _, err := Decode(io.NewSectionReader(a, 0, math.MaxInt64), -1)
+	if err != nil {
+		return nil, err
+	}
+
+	fb, _, err := Decode(io.NewSectionReader(b, 0, math.MaxInt64), -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []Difference
+	for _, k := range unionKeys(fa.KV(), fb.KV()) {
+		va, oka := fa.KV()[k]
+		vb, okb := fb.KV()[k]
+		if oka && okb && reflect.DeepEqual(va, vb) {
+			continue
+		}
+
+		d := Difference{Name: k}
+		if oka {
+			d.A = va
+		}
+		if okb {
+			d.B = vb
+		}
+		diffs = append(diffs, d)
+	}
+
+	ta := make(map[string]*Tensor)
+	for _, t := range fa.Tensors().Items() {
+		ta[t.Name] = t
+	}
+
+	tb := make(map[string]*Tensor)
+	for _, t := range fb.Tensors().Items() {
+		tb[t.Name] = t
+	}
+
+	for _, name := range unionKeys(ta, tb) {
+		d := Difference{Name: name, Tensor: true}
+		x, okx := ta[name]
+		y, oky := tb[name]
+		if okx {
+			d.A = x
+		}
+		if oky {
+			d.B = y
+		}
+
+		switch {
+		case !okx || !oky, x.Kind != y.Kind, !slices.Equal(x.Shape, y.Shape):
+		case data:
+			hx, err := tensorHash(a, fa.Tensors().Offset, x)
+			if err != nil {
+				return nil, err
+			}
+
+			hy, err := tensorHash(b, fb.Tensors().Offset, y)
+			if err != nil {
+				return nil, err
+			}
+
+			if hx == hy {
+				continue
+			}
+
+			d.Data = true
+		default:
+			continue
+		}
+
+		diffs = append(diffs, d)
+	}
+
+	return diffs, nil
+}
+
+// unionKeys returns the sorted keys of a and b
+func unionKeys[M ~map[string]V, V any](a, b M) []string {
+	keys := slices.Collect(maps.Keys(a))
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	slices.Sort(keys)
+	return keys
+}
+
+func tensorHash(r io.ReaderAt, offset uint64, t *Tensor) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, int64(offset+t.Offset), int64(t.Size()))); err != nil {
+		return sum, err
+	}
+
+	h.Sum(sum[:0])
+	return sum, nil
+}
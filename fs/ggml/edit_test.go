@@ -0,0 +1,196 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseValue(t *testing.T) {
+	cases := []struct {
+		typ, s string
+		want   any
+	}{
+		{"string", "llama", "llama"},
+		{"bool", "true", true},
+		{"uint32", "4096", uint32(4096)},
+		{"int8", "-1", int8(-1)},
+		{"uint64", "0x10", uint64(16)},
+		{"float32", "0.5", float32(0.5)},
+		{"[]string", `["a", "b"]`, &array{t: ggufTypeString, size: 2, values: []any{"a", "b"}}},
+		{"[]int32", `[1, -2]`, &array{t: ggufTypeInt32, size: 2, values: []any{int32(1), int32(-2)}}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.typ, func(t *testing.T) {
+			got, err := ParseValue(tt.typ, tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(array{})); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+
+			if TypeOf(got) != tt.typ {
+				t.Errorf("expected type %s, got %s", tt.typ, TypeOf(got))
+			}
+		})
+	}
+
+	for _, tt := range [][2]string{{"uint8", "256"}, {"bool", "maybe"}, {"[]string", "a"}, {"complex64", "1"}, {"[]array", "[]"}} {
+		if _, err := ParseValue(tt[0], tt[1]); err == nil {
+			t.Errorf("expected error parsing %q as %s", tt[1], tt[0])
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	a := &array{t: ggufTypeString, size: 3, values: []any{"a", "b", "c"}}
+	cases := []struct {
+		v    any
+		n    int
+		want string
+	}{
+		{"llama", 0, `"llama"`},
+		{uint32(1), 0, "1"},
+		{a, -1, `["a" "b" "c"]`},
+		{a, 2, `["a" "b" ... (3 values)]`},
+		{&array{t: ggufTypeString, size: 4096}, -1, "[4096 values]"},
+		{[]int32{1, 2}, -1, "[1 2]"},
+	}
+
+	for _, tt := range cases {
+		if got := FormatValue(tt.v, tt.n); got != tt.want {
+			t.Errorf("FormatValue(%v, %d) = %s, want %s", tt.v, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestEditKV(t *testing.T) {
+	f32s := func(x ...float32) *bytes.Reader {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, x); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.NewReader(b.Bytes())
+	}
+
+	writeGGUF := func(kv KV, ts ...Tensor) *os.File {
+		f, err := os.Create(filepath.Join(t.TempDir(), "test.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+
+		if err := WriteGGUF(f, kv, ts); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	a := writeGGUF(KV{
+		"general.architecture": "llama",
+		"general.name":         "a",
+		"llama.block_count":    uint32(1),
+	},
+		Tensor{Name: "blk.0.attn_norm.weight", Kind: tensorTypeF32, Shape: []uint64{3}, WriterTo: f32s(1, 2, 3)},
+		Tensor{Name: "blk.0.attn_q.weight", Kind: tensorTypeF32, Shape: []uint64{2, 3}, WriterTo: f32s(1, 2, 3, 4, 5, 6)},
+	)
+
+	b, err := os.Create(filepath.Join(t.TempDir(), "edited.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := EditKV(b, a, func(kv KV) error {
+		kv["general.name"] = "b"
+		kv["llama.context_length"] = uint32(8192)
+		delete(kv, "llama.block_count")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := Diff(a, b, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Difference{
+		{Name: "general.name", A: "a", B: "b"},
+		{Name: "llama.block_count", A: uint32(1)},
+		{Name: "llama.context_length", B: uint32(8192)},
+	}
+	if diff := cmp.Diff(want, diffs); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	tensor, err := ExtractTensor(&buf, b, "blk.0.attn_q.weight", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tensor.Name != "blk.0.attn_q.weight" {
+		t.Errorf("expected blk.0.attn_q.weight, got %s", tensor.Name)
+	}
+
+	want32 := []float32{1, 2, 3, 4, 5, 6}
+	got32 := make([]float32, len(want32))
+	if err := binary.Read(&buf, binary.LittleEndian, got32); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want32, got32); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := ExtractTensor(io.Discard, b, "blk.0.attn_k.weight", false); err == nil {
+		t.Error("expected error extracting missing tensor")
+	}
+
+	c := writeGGUF(KV{
+		"general.architecture": "llama",
+		"general.name":         "a",
+		"llama.block_count":    uint32(1),
+	},
+		Tensor{Name: "blk.0.attn_norm.weight", Kind: tensorTypeF32, Shape: []uint64{3}, WriterTo: f32s(1, 2, 4)},
+		Tensor{Name: "blk.0.attn_k.weight", Kind: tensorTypeF32, Shape: []uint64{2, 3}, WriterTo: f32s(1, 2, 3, 4, 5, 6)},
+	)
+
+	for _, data := range []bool{false, true} {
+		diffs, err := Diff(a, c, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, d := range diffs {
+			if !d.Tensor {
+				t.Errorf("unexpected difference in %s", d.Name)
+			}
+
+			if d.Data {
+				names = append(names, d.Name+" data")
+			} else {
+				names = append(names, d.Name)
+			}
+		}
+
+		want := []string{"blk.0.attn_k.weight", "blk.0.attn_q.weight"}
+		if data {
+			want = []string{"blk.0.attn_k.weight", "blk.0.attn_norm.weight data", "blk.0.attn_q.weight"}
+		}
+
+		if diff := cmp.Diff(want, names); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
@@ -106,6 +106,10 @@ func (kv KV) Bool(key string, defaultValue ...bool) bool {
 
 func (kv KV) Strings(key string, defaultValue ...[]string) []string {
 	r := keyValue(kv, key, &array{})
+	if r.size == 0 && len(defaultValue) > 0 {
+		return defaultValue[0]
+	}
+
 	s := make([]string, r.size)
 	for i := range r.size {
 		s[i] = r.values[i].(string)
@@ -116,6 +120,10 @@ func (kv KV) Strings(key string, defaultValue ...[]string) []string {
 
 func (kv KV) Uints(key string, defaultValue ...[]uint32) []uint32 {
 	r := keyValue(kv, key, &array{})
+	if r.size == 0 && len(defaultValue) > 0 {
+		return defaultValue[0]
+	}
+
 	s := make([]uint32, r.size)
 	for i := range r.size {
 		s[i] = uint32(r.values[i].(int32))
@@ -126,6 +134,10 @@ func (kv KV) Uints(key string, defaultValue ...[]uint32) []uint32 {
 
 func (kv KV) Floats(key string, defaultValue ...[]float32) []float32 {
 	r := keyValue(kv, key, &array{})
+	if r.size == 0 && len(defaultValue) > 0 {
+		return defaultValue[0]
+	}
+
 	s := make([]float32, r.size)
 	for i := range r.size {
 		s[i] = float32(r.values[i].(float32))
@@ -134,7 +146,7 @@ func (kv KV) Floats(key string, defaultValue ...[]float32) []float32 {
 }
 
 func (kv KV) OllamaEngineRequired() bool {
-	return kv.Architecture() == "gemma3"
+	return slices.Contains([]string{"gemma3", "pixtral", "qwen2audio", "qwen2vl", "whisper"}, kv.Architecture())
 }
 
 func keyValue[T string | uint32 | uint64 | float32 | *array | bool](kv KV, key string, defaultValue ...T) T {
@@ -623,10 +635,51 @@ func (llm GGML) VisionGraphSize() (weights, graphSize uint64) {
 			embeddingLength*numPatches*maxNumTiles +
 			9*embeddingLength*numPaddedPatches*maxNumTiles +
 			numPaddedPatches*maxNumTiles*numPaddedPatches*maxNumTiles*headCount)
-	case "gemma3":
+	case "gemma3", "pixtral":
 		graphSize = 4 * (imageSize*imageSize*numChannels +
 			embeddingLength*patchSize +
 			numPatches*numPatches*headCount)
+	case "qwen2vl":
+		maxPixels := uint64(llm.KV().Uint("vision.max_pixels", 14*14*4*1280))
+		numPatches = maxPixels / (patchSize * patchSize)
+
+		graphSize = 4 * (maxPixels*numChannels +
+			embeddingLength*numPatches +
+			numPatches*numPatches*headCount)
+	}
+
+	return weights, graphSize
+}
+
+// AudioGraphSize estimates the weights and compute graph of the audio encoder
+// for a single chunk of audio
+func (llm GGML) AudioGraphSize() (weights, graphSize uint64) {
+	if llm.KV().Uint("audio.block_count") == 0 {
+		return
+	}
+
+	for name, layer := range llm.Tensors().GroupLayers() {
+		if name == "a" || strings.HasPrefix(name, "a.") {
+			for _, tensor := range layer {
+				weights += tensor.Size()
+			}
+		}
+	}
+
+	// 30 seconds of audio at 100 frames per second, halved by the convolutions
+	const numFrames = 3000
+	numMels := uint64(llm.KV().Uint("audio.num_mel_bins", 80))
+	headCount := uint64(llm.KV().Uint("audio.attention.head_count"))
+	embeddingLength := uint64(llm.KV().Uint("audio.embedding_length"))
+
+	graphSize = 4 * (numFrames*numMels +
+		3*embeddingLength*numFrames +
+		numFrames/2*numFrames/2*headCount)
+
+	// encoder-decoder models also keep the cross attention keys and values
+	// of every decoder layer for the encoded chunk
+	if llm.KV().Architecture() == "whisper" {
+		graphSize += 4 * 2 * numFrames / 2 * uint64(llm.KV().BlockCount()) * llm.KV().EmbeddingLength()
 	}
 
 	return weights, graphSize
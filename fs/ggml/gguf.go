@@ -356,6 +356,8 @@ func writeGGUFString(w io.Writer, s string) error {
 }
 
 type array struct {
+	// t is the gguf type of the elements
+	t      uint32
 	size   int
 	values []any
 }
@@ -375,7 +377,7 @@ func readGGUFV1Array(llm *gguf, r io.Reader) (*array, error) {
 		return nil, err
 	}
 
-	a := &array{size: int(n)}
+	a := &array{t: t, size: int(n)}
 	if llm.canCollectArray(int(n)) {
 		a.values = make([]any, 0, int(n))
 	}
@@ -437,7 +439,7 @@ func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
 		return nil, err
 	}
 
-	a := &array{size: int(n)}
+	a := &array{t: t, size: int(n)}
 	if llm.canCollectArray(int(n)) {
 		a.values = make([]any, int(n))
 	}
@@ -541,8 +543,11 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 		}
 	})
 
+	var alignment int64 = 32
+
 	var s uint64
 	for _, t := range ts {
+		s += uint64(ggufPadding(int64(s), alignment))
 		t.Offset = s
 		if err := ggufWriteTensorInfo(ws, t); err != nil {
 			return err
@@ -550,7 +555,6 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 		s += t.Size()
 	}
 
-	var alignment int64 = 32
 	for _, t := range ts {
 		if err := ggufWriteTensor(ws, t, alignment); err != nil {
 			return err
@@ -572,10 +576,26 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 
 	var err error
 	switch v := v.(type) {
+	case uint8:
+		err = writeGGUF(ws, ggufTypeUint8, v)
+	case int8:
+		err = writeGGUF(ws, ggufTypeInt8, v)
+	case uint16:
+		err = writeGGUF(ws, ggufTypeUint16, v)
+	case int16:
+		err = writeGGUF(ws, ggufTypeInt16, v)
 	case uint32:
 		err = writeGGUF(ws, ggufTypeUint32, v)
+	case int32:
+		err = writeGGUF(ws, ggufTypeInt32, v)
+	case uint64:
+		err = writeGGUF(ws, ggufTypeUint64, v)
+	case int64:
+		err = writeGGUF(ws, ggufTypeInt64, v)
 	case float32:
 		err = writeGGUF(ws, ggufTypeFloat32, v)
+	case float64:
+		err = writeGGUF(ws, ggufTypeFloat64, v)
 	case bool:
 		err = writeGGUF(ws, ggufTypeBool, v)
 	case string:
@@ -608,6 +628,13 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 				return err
 			}
 		}
+	case *array:
+		// arrays larger than maxArraySize are decoded without their values
+		if len(v.values) != v.size {
+			return fmt.Errorf("missing values for '%s'", k)
+		}
+
+		err = writeGGUFAnyArray(ws, v)
 	default:
 		return fmt.Errorf("improper type for '%s'", k)
 	}
@@ -615,6 +642,37 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 	return err
 }
 
+// writeGGUFAnyArray writes an array decoded from a gguf file
+func writeGGUFAnyArray(w io.Writer, a *array) error {
+	if err := binary.Write(w, binary.LittleEndian, ggufTypeArray); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, a.t); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint64(a.size)); err != nil {
+		return err
+	}
+
+	for _, e := range a.values {
+		if s, ok := e.(string); ok {
+			if err := binary.Write(w, binary.LittleEndian, uint64(len(s))); err != nil {
+				return err
+			}
+
+			if _, err := io.WriteString(w, s); err != nil {
+				return err
+			}
+		} else if err := binary.Write(w, binary.LittleEndian, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func ggufWriteTensorInfo(ws io.WriteSeeker, t Tensor) error {
 	slog.Debug(t.Name, "kind", t.Kind, "shape", t.Shape, "offset", t.Offset)
 	if err := binary.Write(ws, binary.LittleEndian, uint64(len(t.Name))); err != nil {
@@ -0,0 +1,130 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// Imatrix is an importance matrix: the mean of the squared activations going
+// into each column of the weights of a model, keyed by tensor name. It is
+// computed by running calibration text through the model and lets the
+// quantizer spend its precision on the columns that matter most.
+type Imatrix struct {
+	// Chunks is the number of chunks of calibration text that were evaluated
+	Chunks int
+
+	// Dataset is a description of the calibration text
+	Dataset string
+
+	Values map[string][]float32
+}
+
+const imatrixType = "imatrix"
+
+// WriteImatrix writes m to ws as a GGUF file with one F32 tensor per weight
+func WriteImatrix(ws io.WriteSeeker, m Imatrix) error {
+	kv := KV{
+		"general.type":        imatrixType,
+		"imatrix.chunk_count": uint32(m.Chunks),
+		"imatrix.dataset":     m.Dataset,
+	}
+
+	var ts []Tensor
+	for _, name := range slices.Sorted(maps.Keys(m.Values)) {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, m.Values[name]); err != nil {
+			return err
+		}
+
+		ts = append(ts, Tensor{
+			Name:     name,
+			Kind:     tensorTypeF32,
+			Shape:    []uint64{uint64(len(m.Values[name]))},
+			WriterTo: &b,
+		})
+	}
+
+	return WriteGGUF(ws, kv, ts)
+}
+
+// ReadImatrix reads an importance matrix written by WriteImatrix
+func ReadImatrix(rs io.ReadSeeker) (*Imatrix, error) {
+	f, _, err := Decode(rs, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.KV().Kind() != imatrixType {
+		return nil, errors.New("not an importance matrix")
+	}
+
+	// the keys aren't namespaced by an architecture so they are read directly
+	chunks, _ := f.KV()["imatrix.chunk_count"].(uint32)
+	dataset, _ := f.KV()["imatrix.dataset"].(string)
+
+	m := Imatrix{
+		Chunks:  int(chunks),
+		Dataset: dataset,
+		Values:  make(map[string][]float32),
+	}
+
+	for _, t := range f.Tensors().Items() {
+		if t.Kind != tensorTypeF32 {
+			return nil, fmt.Errorf("%s: unexpected tensor type %s", t.Name, t.Type())
+		}
+
+		if _, err := rs.Seek(int64(f.Tensors().Offset+t.Offset), io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		values := make([]float32, t.parameters())
+		if err := binary.Read(rs, binary.LittleEndian, values); err != nil {
+			return nil, err
+		}
+
+		m.Values[t.Name] = values
+	}
+
+	return &m, nil
+}
+
+// weights returns the importance of each column of the tensor name with rows
+// of cols elements or nil if it is unknown. Columns that were never activated
+// are given the mean importance so they aren't quantized to zero.
+func (m *Imatrix) weights(name string, cols uint64) []float32 {
+	if m == nil {
+		return nil
+	}
+
+	values, ok := m.Values[name]
+	if !ok || uint64(len(values)) != cols {
+		return nil
+	}
+
+	var sum float32
+	var n int
+	for _, v := range values {
+		if v > 0 {
+			sum += v
+			n++
+		}
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	weights := slices.Clone(values)
+	for i, v := range weights {
+		if v <= 0 {
+			weights[i] = sum / float32(n)
+		}
+	}
+
+	return weights
+}
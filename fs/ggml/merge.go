@@ -0,0 +1,672 @@
+package ggml
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Adapter is a LoRA adapter to merge into a model with [MergeAdapters]
+type Adapter struct {
+	// R reads the adapter's GGUF file
+	R io.ReaderAt
+	// Weight scales the updates of the adapter
+	Weight float32
+}
+
+// Model is a model to merge into another with [MergeModels]
+type Model struct {
+	// R reads the model's GGUF file
+	R io.ReaderAt
+	// Weight returns the weight of the model for the tensor name
+	Weight func(name string) float32
+}
+
+// Methods of combining adapters or models
+const (
+	// MergeLinear sums the updates of adapters, or interpolates linearly
+	// between models
+	MergeLinear = "linear"
+	// MergeTIES keeps the largest updates of each adapter and averages those
+	// agreeing with the sign of their sum
+	MergeTIES = "ties"
+	// MergeSLERP interpolates spherically between two models
+	MergeSLERP = "slerp"
+	// MergeTaskArithmetic adds the differences between models and a base
+	// model
+	MergeTaskArithmetic = "task_arithmetic"
+)
+
+// MergeOptions controls how adapters or models are merged
+type MergeOptions struct {
+	// Method is [MergeLinear] if empty
+	Method string
+	// Density is the fraction of the updates of each adapter kept by
+	// [MergeTIES]
+	Density float32
+	// Base reads the GGUF file of the model [MergeTaskArithmetic] takes the
+	// differences from
+	Base io.ReaderAt
+	// Weight returns the weight of the difference between the model merged
+	// into and Base for the tensor name with [MergeTaskArithmetic]. It's 1
+	// if nil.
+	Weight func(name string) float32
+}
+
+// loraUpdate is the update of one adapter to a weight
+type loraUpdate struct {
+	r       io.ReaderAt
+	offset  uint64
+	a, b, m *Tensor
+	// scale is the alpha of the adapter divided by the rank of the update
+	// and multiplied by the weight of the adapter
+	scale float32
+}
+
+// MergeAdapters reads the GGUF model in r and writes it to ws with the updates
+// of adapters added to its weights. Updated weights are dequantized, merged and
+// quantized again to their type, or F16 where the type can't be quantized to.
+// fn, if not nil, is called after each tensor is written with the number of
+// bytes written so far and the expected total.
+func MergeAdapters(ws io.WriteSeeker, r io.ReaderAt, adapters []Adapter, opts MergeOptions, fn func(completed, total uint64)) error {
+	switch opts.Method {
+	case "", MergeLinear:
+	case MergeTIES:
+		if opts.Density <= 0 || opts.Density > 1 {
+			return fmt.Errorf("invalid density %v", opts.Density)
+		}
+	default:
+		return fmt.Errorf("unknown merge method %q", opts.Method)
+	}
+
+	f, _, err := Decode(io.NewSectionReader(r, 0, math.MaxInt64), -1)
+	if err != nil {
+		return err
+	}
+
+	if f.Name() != "gguf" {
+		return ErrUnsupportedFormat
+	}
+
+	updates := make(map[string][]loraUpdate)
+	for _, adapter := range adapters {
+		a, _, err := Decode(io.NewSectionReader(adapter.R, 0, math.MaxInt64), -1)
+		if err != nil {
+			return err
+		}
+
+		if a.KV().Kind() != "adapter" || a.KV()["adapter.type"] != "lora" {
+			return errors.New("only LoRA adapters can be merged")
+		} else if a.KV().Architecture() != f.KV().Architecture() {
+			return fmt.Errorf("%s adapter can't be merged into a %s model", a.KV().Architecture(), f.KV().Architecture())
+		}
+
+		alpha, _ := a.KV()["adapter.lora.alpha"].(float32)
+
+		tensors := a.Tensors()
+		byName := make(map[string]*loraUpdate)
+		for _, t := range tensors.Items() {
+			name, part, ok := cutLoraSuffix(t.Name)
+			if !ok {
+				return fmt.Errorf("unexpected tensor %s in adapter", t.Name)
+			}
+
+			u, ok := byName[name]
+			if !ok {
+				u = &loraUpdate{r: adapter.R, offset: tensors.Offset}
+				byName[name] = u
+			}
+
+			switch part {
+			case "lora_a":
+				u.a = t
+			case "lora_b":
+				u.b = t
+			case "lora_m":
+				u.m = t
+			}
+		}
+
+		for name, u := range byName {
+			if u.a == nil || u.b == nil {
+				return fmt.Errorf("%s: adapter is missing LoRA A or B weights", name)
+			}
+
+			// updates are scaled by alpha / rank at runtime, or not at all
+			// if alpha isn't set
+			u.scale = adapter.Weight
+			if rank := u.a.Shape[len(u.a.Shape)-1]; alpha > 0 && rank > 0 {
+				u.scale *= alpha / float32(rank)
+			}
+
+			updates[name] = append(updates[name], *u)
+		}
+	}
+
+	kv := maps.Clone(f.KV())
+	delete(kv, "general.parameter_count")
+	delete(kv, "general.alignment")
+
+	tensors := f.Tensors()
+
+	var completed, total uint64
+	ts := make([]Tensor, len(tensors.Items()))
+	for i, t := range tensors.Items() {
+		src := io.NewSectionReader(r, int64(tensors.Offset+t.Offset), int64(t.Size()))
+
+		us := updates[t.Name]
+		delete(updates, t.Name)
+
+		kind := t.Kind
+		if _, ok := quantizers[kind]; len(us) > 0 && !ok {
+			kind = tensorTypeF16
+		}
+
+		total += Tensor{Kind: kind, Shape: t.Shape}.Size()
+		ts[i] = Tensor{
+			Name: t.Name,
+			Kind: kind,
+			// WriteGGUF expects the outermost dimension first
+			Shape: slices.Clone(t.Shape),
+			WriterTo: WriterFunc(func(w io.Writer) (n int64, err error) {
+				if len(us) == 0 {
+					n, err = io.Copy(w, src)
+				} else {
+					n, err = mergeTensor(w, src, t, kind, us, opts)
+				}
+
+				completed += uint64(n)
+				if fn != nil && err == nil {
+					fn(completed, total)
+				}
+
+				return n, err
+			}),
+		}
+		slices.Reverse(ts[i].Shape)
+	}
+
+	if names := slices.Sorted(maps.Keys(updates)); len(names) > 0 {
+		return fmt.Errorf("adapter updates %s which isn't in the model", names[0])
+	}
+
+	return WriteGGUF(ws, kv, ts)
+}
+
+// cutLoraSuffix splits the name of an adapter tensor into the name of the
+// weight it updates and its part of the update
+func cutLoraSuffix(name string) (string, string, bool) {
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return "", "", false
+	}
+
+	switch part := name[i+1:]; part {
+	case "lora_a", "lora_b", "lora_m":
+		return name[:i], part, true
+	default:
+		return "", "", false
+	}
+}
+
+// mergeTensor adds updates to the weight t read from r and writes it to w as kind
+func mergeTensor(w io.Writer, r io.Reader, t *Tensor, kind uint32, updates []loraUpdate, opts MergeOptions) (int64, error) {
+	if len(t.Shape) != 2 {
+		return 0, fmt.Errorf("%s: only matrices can be merged", t.Name)
+	}
+
+	cols, rows := t.Shape[0], t.Shape[1]
+
+	x, err := readFloat32s(r, t)
+	if err != nil {
+		return 0, err
+	}
+
+	deltas := make([][]float32, len(updates))
+	for i, u := range updates {
+		if u.m != nil && len(updates) > 1 {
+			return 0, fmt.Errorf("%s: DoRA adapters can't be merged with other adapters", t.Name)
+		}
+
+		if deltas[i], err = u.delta(t.Name, rows, cols); err != nil {
+			return 0, err
+		}
+	}
+
+	var delta []float32
+	switch {
+	case len(deltas) == 1:
+		delta = deltas[0]
+	case opts.Method == MergeTIES:
+		delta = ties(deltas, opts.Density)
+	default:
+		delta = deltas[0]
+		for _, d := range deltas[1:] {
+			for i := range d {
+				delta[i] += d[i]
+			}
+		}
+	}
+
+	for i := range x {
+		x[i] += delta[i]
+	}
+
+	if m := updates[0].m; m != nil {
+		magnitude, err := readFloat32s(io.NewSectionReader(updates[0].r, int64(updates[0].offset+m.Offset), int64(m.Size())), m)
+		if err != nil {
+			return 0, err
+		} else if uint64(len(magnitude)) != rows {
+			return 0, fmt.Errorf("%s: DoRA magnitude doesn't match the weight's %d rows", t.Name, rows)
+		}
+
+		// DoRA rescales each row of the updated weight to its magnitude
+		for i := range rows {
+			row := x[i*cols : (i+1)*cols]
+
+			var norm float64
+			for _, v := range row {
+				norm += float64(v) * float64(v)
+			}
+
+			if norm > 0 {
+				scale := magnitude[i] / float32(math.Sqrt(norm))
+				for j := range row {
+					row[j] *= scale
+				}
+			}
+		}
+	}
+
+	dst := make([]byte, Tensor{Kind: kind, Shape: t.Shape}.Size())
+	quantizers[kind](dst, x, nil)
+
+	n, err := w.Write(dst)
+	return int64(n), err
+}
+
+// delta returns the update B·A of a weight with rows and cols multiplied by
+// its scale
+func (u loraUpdate) delta(name string, rows, cols uint64) ([]float32, error) {
+	if len(u.a.Shape) != 2 || len(u.b.Shape) != 2 ||
+		u.a.Shape[0] != cols || u.b.Shape[1] != rows || u.a.Shape[1] != u.b.Shape[0] {
+		return nil, fmt.Errorf("%s: adapter doesn't match the weight's shape", name)
+	}
+
+	rank := u.a.Shape[1]
+
+	a, err := readFloat32s(io.NewSectionReader(u.r, int64(u.offset+u.a.Offset), int64(u.a.Size())), u.a)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := readFloat32s(io.NewSectionReader(u.r, int64(u.offset+u.b.Offset), int64(u.b.Size())), u.b)
+	if err != nil {
+		return nil, err
+	}
+
+	delta := make([]float32, rows*cols)
+
+	// rows are independent so each worker takes a contiguous range of them
+	workers := uint64(runtime.GOMAXPROCS(0))
+	step := (rows + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for i := uint64(0); i < rows; i += step {
+		wg.Add(1)
+		go func(start, end uint64) {
+			defer wg.Done()
+			for row := start; row < end; row++ {
+				d := delta[row*cols : (row+1)*cols]
+				for k := range rank {
+					s := b[row*rank+k] * u.scale
+					if s == 0 {
+						continue
+					}
+
+					for j, v := range a[k*cols : (k+1)*cols] {
+						d[j] += s * v
+					}
+				}
+			}
+		}(i, min(i+step, rows))
+	}
+	wg.Wait()
+
+	return delta, nil
+}
+
+// ties combines deltas with TIES merging: each delta is trimmed to the
+// fraction density of its values with the largest magnitude, then values
+// agreeing with the sign of their sum are averaged. The deltas are already
+// scaled by the weights of their adapters.
+func ties(deltas [][]float32, density float32) []float32 {
+	for _, d := range deltas {
+		magnitudes := make([]float32, len(d))
+		for i, v := range d {
+			magnitudes[i] = float32(math.Abs(float64(v)))
+		}
+		slices.Sort(magnitudes)
+
+		keep := max(int(math.Ceil(float64(density)*float64(len(d)))), 1)
+		threshold := magnitudes[len(magnitudes)-keep]
+		for i, v := range d {
+			if float32(math.Abs(float64(v))) < threshold {
+				d[i] = 0
+			}
+		}
+	}
+
+	merged := make([]float32, len(deltas[0]))
+	for i := range merged {
+		var sum float32
+		for _, d := range deltas {
+			sum += d[i]
+		}
+
+		if sum == 0 {
+			continue
+		}
+
+		var agreeing float32
+		var count int
+		for _, d := range deltas {
+			if d[i] != 0 && (d[i] > 0) == (sum > 0) {
+				agreeing += d[i]
+				count++
+			}
+		}
+
+		if count > 0 {
+			merged[i] = agreeing / float32(count)
+		}
+	}
+
+	return merged
+}
+
+// readFloat32s reads all of the data of t from r as float32
+func readFloat32s(r io.Reader, t *Tensor) ([]float32, error) {
+	dequantize, ok := dequantizers[t.Kind]
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported tensor type %s", t.Name, t.Type())
+	}
+
+	src := make([]byte, t.Size())
+	if _, err := io.ReadFull(r, src); err != nil {
+		return nil, err
+	}
+
+	x := make([]float32, t.parameters())
+	dequantize(x, src)
+	return x, nil
+}
+
+// mergeKeys are the hyperparameters models must share to be merged
+var mergeKeys = []string{
+	"block_count",
+	"embedding_length",
+	"feed_forward_length",
+	"attention.head_count",
+	"attention.head_count_kv",
+}
+
+// mergeSource is a model whose tensors are merged
+type mergeSource struct {
+	r       io.ReaderAt
+	offset  uint64
+	tensors map[string]*Tensor
+}
+
+// MergeModels reads the GGUF model in r and writes it to ws with its weights
+// merged with those of models, which must have the same architecture.
+// [MergeLinear] interpolates linearly between the model and models by their
+// weights, [MergeSLERP] interpolates spherically between the model and a
+// single other model and [MergeTaskArithmetic] adds the weighted differences
+// of the model and of models from opts.Base to opts.Base. Tensors which all
+// of models weigh zero, and opts.Weight weighs one with [MergeTaskArithmetic],
+// are copied as they are. Merged tensors are quantized to their type, or F16
+// where the type can't be quantized to. fn, if not nil, is called after each
+// tensor is written with the number of bytes written so far and the expected
+// total.
+func MergeModels(ws io.WriteSeeker, r io.ReaderAt, models []Model, opts MergeOptions, fn func(completed, total uint64)) error {
+	switch opts.Method {
+	case "", MergeLinear:
+	case MergeSLERP:
+		if len(models) != 1 {
+			return errors.New("slerp merges exactly two models")
+		}
+	case MergeTaskArithmetic:
+		if opts.Base == nil {
+			return errors.New("task arithmetic requires a base model")
+		}
+	default:
+		return fmt.Errorf("unknown merge method %q", opts.Method)
+	}
+
+	if len(models) == 0 {
+		return errors.New("no models to merge")
+	}
+
+	f, _, err := Decode(io.NewSectionReader(r, 0, math.MaxInt64), -1)
+	if err != nil {
+		return err
+	}
+
+	if f.Name() != "gguf" {
+		return ErrUnsupportedFormat
+	}
+
+	open := func(r io.ReaderAt) (*mergeSource, error) {
+		g, _, err := Decode(io.NewSectionReader(r, 0, math.MaxInt64), -1)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := mergeable(f, g); err != nil {
+			return nil, err
+		}
+
+		s := mergeSource{r: r, offset: g.Tensors().Offset, tensors: make(map[string]*Tensor)}
+		for _, t := range g.Tensors().Items() {
+			s.tensors[t.Name] = t
+		}
+
+		return &s, nil
+	}
+
+	sources := make([]*mergeSource, len(models))
+	for i, m := range models {
+		if sources[i], err = open(m.R); err != nil {
+			return err
+		}
+	}
+
+	var base *mergeSource
+	if opts.Method == MergeTaskArithmetic {
+		if base, err = open(opts.Base); err != nil {
+			return err
+		}
+	}
+
+	kv := maps.Clone(f.KV())
+	delete(kv, "general.parameter_count")
+	delete(kv, "general.alignment")
+
+	tensors := f.Tensors()
+
+	var completed, total uint64
+	ts := make([]Tensor, len(tensors.Items()))
+	for i, t := range tensors.Items() {
+		src := io.NewSectionReader(r, int64(tensors.Offset+t.Offset), int64(t.Size()))
+
+		weights := make([]float32, len(models))
+		for j, m := range models {
+			weights[j] = m.Weight(t.Name)
+		}
+
+		var weight float32 = 1
+		if opts.Method == MergeTaskArithmetic && opts.Weight != nil {
+			weight = opts.Weight(t.Name)
+		}
+
+		merged := weight != 1 || slices.ContainsFunc(weights, func(w float32) bool { return w != 0 })
+
+		kind := t.Kind
+		if _, ok := quantizers[kind]; merged && !ok {
+			kind = tensorTypeF16
+		}
+
+		total += Tensor{Kind: kind, Shape: t.Shape}.Size()
+		ts[i] = Tensor{
+			Name: t.Name,
+			Kind: kind,
+			// WriteGGUF expects the outermost dimension first
+			Shape: slices.Clone(t.Shape),
+			WriterTo: WriterFunc(func(w io.Writer) (n int64, err error) {
+				if !merged {
+					n, err = io.Copy(w, src)
+				} else {
+					n, err = mergeModelTensor(w, src, t, kind, weight, sources, weights, base, opts.Method)
+				}
+
+				completed += uint64(n)
+				if fn != nil && err == nil {
+					fn(completed, total)
+				}
+
+				return n, err
+			}),
+		}
+		slices.Reverse(ts[i].Shape)
+	}
+
+	return WriteGGUF(ws, kv, ts)
+}
+
+// mergeable returns an error if the model g can't be merged into f
+func mergeable(f, g *GGML) error {
+	if g.KV().Kind() == "adapter" {
+		return errors.New("adapters can't be merged as models")
+	} else if f.KV().Architecture() != g.KV().Architecture() {
+		return fmt.Errorf("can't merge a %s model with a %s model", g.KV().Architecture(), f.KV().Architecture())
+	}
+
+	for _, key := range mergeKeys {
+		key = f.KV().Architecture() + "." + key
+		if !reflect.DeepEqual(f.KV()[key], g.KV()[key]) {
+			return fmt.Errorf("models have different %s", key)
+		}
+	}
+
+	shapes := make(map[string][]uint64)
+	for _, t := range g.Tensors().Items() {
+		shapes[t.Name] = t.Shape
+	}
+
+	if len(shapes) != len(f.Tensors().Items()) {
+		return errors.New("models have different tensors")
+	}
+
+	for _, t := range f.Tensors().Items() {
+		if shape, ok := shapes[t.Name]; !ok {
+			return fmt.Errorf("%s isn't in every model", t.Name)
+		} else if !slices.Equal(t.Shape, shape) {
+			return fmt.Errorf("%s has different shapes %v and %v", t.Name, t.Shape, shape)
+		}
+	}
+
+	return nil
+}
+
+// mergeModelTensor merges the tensor t read from r, weighted by weight, with
+// the same tensor of sources by their weights and writes it to w as kind
+func mergeModelTensor(w io.Writer, r io.Reader, t *Tensor, kind uint32, weight float32, sources []*mergeSource, weights []float32, base *mergeSource, method string) (int64, error) {
+	x, err := readFloat32s(r, t)
+	if err != nil {
+		return 0, err
+	}
+
+	read := func(s *mergeSource) ([]float32, error) {
+		u := s.tensors[t.Name]
+		return readFloat32s(io.NewSectionReader(s.r, int64(s.offset+u.Offset), int64(u.Size())), u)
+	}
+
+	if method == MergeSLERP {
+		y, err := read(sources[0])
+		if err != nil {
+			return 0, err
+		}
+
+		x = slerp(x, y, weights[0])
+	} else {
+		// linear interpolation adds the differences from the model itself
+		b := slices.Clone(x)
+		if method == MergeTaskArithmetic {
+			if b, err = read(base); err != nil {
+				return 0, err
+			}
+
+			// the difference of the model itself is weighted too
+			for i := range x {
+				x[i] = b[i] + weight*(x[i]-b[i])
+			}
+		}
+
+		for j, s := range sources {
+			if weights[j] == 0 {
+				continue
+			}
+
+			y, err := read(s)
+			if err != nil {
+				return 0, err
+			}
+
+			for i := range x {
+				x[i] += weights[j] * (y[i] - b[i])
+			}
+		}
+	}
+
+	dst := make([]byte, Tensor{Kind: kind, Shape: t.Shape}.Size())
+	quantizers[kind](dst, x, nil)
+
+	n, err := w.Write(dst)
+	return int64(n), err
+}
+
+// slerp interpolates spherically from x to y by t, treating each as a single
+// vector. It interpolates linearly if either is zero or they're nearly
+// parallel.
+func slerp(x, y []float32, t float32) []float32 {
+	var dot, nx, ny float64
+	for i := range x {
+		dot += float64(x[i]) * float64(y[i])
+		nx += float64(x[i]) * float64(x[i])
+		ny += float64(y[i]) * float64(y[i])
+	}
+
+	s0, s1 := 1-float64(t), float64(t)
+	if nx > 0 && ny > 0 {
+		if cos := dot / math.Sqrt(nx*ny); math.Abs(cos) < 0.9995 {
+			theta := math.Acos(cos)
+			s0 = math.Sin((1-float64(t))*theta) / math.Sin(theta)
+			s1 = math.Sin(float64(t)*theta) / math.Sin(theta)
+		}
+	}
+
+	z := make([]float32, len(x))
+	for i := range z {
+		z[i] = float32(s0*float64(x[i]) + s1*float64(y[i]))
+	}
+
+	return z
+}
@@ -0,0 +1,376 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestMergeAdapters(t *testing.T) {
+	f32s := func(x ...float32) *bytes.Reader {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, x); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.NewReader(b.Bytes())
+	}
+
+	writeGGUF := func(kv KV, ts ...Tensor) *os.File {
+		f, err := os.Create(filepath.Join(t.TempDir(), "test.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+
+		if err := WriteGGUF(f, kv, ts); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	// W is 2x3
+	model := writeGGUF(KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(0),
+		"llama.block_count":    uint32(1),
+	},
+		Tensor{Name: "blk.0.attn_norm.weight", Kind: tensorTypeF32, Shape: []uint64{3}, WriterTo: f32s(1, 2, 3)},
+		Tensor{Name: "blk.0.attn_q.weight", Kind: tensorTypeF32, Shape: []uint64{2, 3}, WriterTo: f32s(1, 2, 3, 4, 5, 6)},
+	)
+
+	adapter := func(arch string, alpha float32, name string, a, b []float32, m ...float32) *os.File {
+		ts := []Tensor{
+			{Name: name + ".lora_a", Kind: tensorTypeF32, Shape: []uint64{uint64(len(a) / 3), 3}, WriterTo: f32s(a...)},
+			{Name: name + ".lora_b", Kind: tensorTypeF32, Shape: []uint64{2, uint64(len(b) / 2)}, WriterTo: f32s(b...)},
+		}
+		if len(m) > 0 {
+			ts = append(ts, Tensor{Name: name + ".lora_m", Kind: tensorTypeF32, Shape: []uint64{uint64(len(m))}, WriterTo: f32s(m...)})
+		}
+
+		return writeGGUF(KV{
+			"general.architecture": arch,
+			"general.type":         "adapter",
+			"adapter.type":         "lora",
+			"adapter.lora.alpha":   alpha,
+		}, ts...)
+	}
+
+	// rank 1 updates with alpha 2 are scaled by 2:
+	// first is 2 * [1, 2]^T [1, 0, -1] = [[2, 0, -2], [4, 0, -4]]
+	first := adapter("llama", 2, "blk.0.attn_q.weight", []float32{1, 0, -1}, []float32{1, 2})
+	// second is 2 * [1, -1]^T [1, 1, 1] = [[2, 2, 2], [-2, -2, -2]]
+	second := adapter("llama", 2, "blk.0.attn_q.weight", []float32{1, 1, 1}, []float32{1, -1})
+	// dora has a magnitude of 5 for each row
+	dora := adapter("llama", 2, "blk.0.attn_q.weight", []float32{1, 0, -1}, []float32{1, 2}, 5, 5)
+
+	merge := func(t *testing.T, opts MergeOptions, adapters ...Adapter) map[string][]float32 {
+		t.Helper()
+
+		out, err := os.Create(filepath.Join(t.TempDir(), "merged.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+
+		var completed, total uint64
+		if err := MergeAdapters(out, model, adapters, opts, func(c, t uint64) {
+			completed, total = c, t
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if completed != total {
+			t.Errorf("progress: completed = %d, total = %d", completed, total)
+		}
+
+		f, _, err := Decode(io.NewSectionReader(out, 0, math.MaxInt64), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.KV().Kind() == "adapter" || f.KV().Architecture() != "llama" {
+			t.Errorf("merged model has kind %q and architecture %q", f.KV().Kind(), f.KV().Architecture())
+		}
+
+		values := make(map[string][]float32)
+		for _, tensor := range f.Tensors().Items() {
+			x, err := readFloat32s(io.NewSectionReader(out, int64(f.Tensors().Offset+tensor.Offset), int64(tensor.Size())), tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values[tensor.Name] = x
+		}
+		return values
+	}
+
+	norm := float32(math.Sqrt(3*3 + 2*2 + 1*1))
+
+	cases := []struct {
+		name     string
+		opts     MergeOptions
+		adapters []Adapter
+		want     []float32
+	}{
+		{
+			name:     "single",
+			adapters: []Adapter{{R: first, Weight: 1}},
+			want:     []float32{3, 2, 1, 8, 5, 2},
+		},
+		{
+			name:     "linear",
+			opts:     MergeOptions{Method: MergeLinear},
+			adapters: []Adapter{{R: first, Weight: 1}, {R: second, Weight: 0.5}},
+			want:     []float32{4, 3, 2, 7, 4, 1},
+		},
+		{
+			// with a density of 0.5 the first drops its zeros and the second
+			// keeps everything. the third column of the first row sums to
+			// zero so neither update is kept
+			name:     "ties",
+			opts:     MergeOptions{Method: MergeTIES, Density: 0.5},
+			adapters: []Adapter{{R: first, Weight: 1}, {R: second, Weight: 1}},
+			want:     []float32{1 + 2, 2 + 2, 3, 4 + 4, 5 - 2, 6 - 3},
+		},
+		{
+			name:     "dora",
+			adapters: []Adapter{{R: dora, Weight: 1}},
+			want: []float32{
+				3 * 5 / norm, 2 * 5 / norm, 1 * 5 / norm,
+				8 * 5 / float32(math.Sqrt(8*8+5*5+2*2)), 5 * 5 / float32(math.Sqrt(8*8+5*5+2*2)), 2 * 5 / float32(math.Sqrt(8*8+5*5+2*2)),
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			values := merge(t, tt.opts, tt.adapters...)
+			if diff := cmp.Diff(tt.want, values["blk.0.attn_q.weight"], cmpopts.EquateApprox(0, 1e-5)); diff != "" {
+				t.Errorf("merged weight mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff([]float32{1, 2, 3}, values["blk.0.attn_norm.weight"]); diff != "" {
+				t.Errorf("untouched weight mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		out, err := os.Create(filepath.Join(t.TempDir(), "merged.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+
+		for _, tt := range []struct {
+			name     string
+			opts     MergeOptions
+			adapters []Adapter
+		}{
+			{"method", MergeOptions{Method: "dare"}, []Adapter{{R: first, Weight: 1}}},
+			{"density", MergeOptions{Method: MergeTIES}, []Adapter{{R: first, Weight: 1}}},
+			{"architecture", MergeOptions{}, []Adapter{{R: adapter("gemma", 2, "blk.0.attn_q.weight", []float32{1, 0, -1}, []float32{1, 2}), Weight: 1}}},
+			{"tensor", MergeOptions{}, []Adapter{{R: adapter("llama", 2, "blk.0.attn_k.weight", []float32{1, 0, -1}, []float32{1, 2}), Weight: 1}}},
+			{"model", MergeOptions{}, []Adapter{{R: model, Weight: 1}}},
+			{"dora", MergeOptions{}, []Adapter{{R: dora, Weight: 1}, {R: first, Weight: 1}}},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := MergeAdapters(out, model, tt.adapters, tt.opts, nil); err == nil {
+					t.Error("expected error")
+				}
+			})
+		}
+	})
+}
+
+func TestMergeModels(t *testing.T) {
+	f32s := func(x ...float32) *bytes.Reader {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, x); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.NewReader(b.Bytes())
+	}
+
+	model := func(arch string, blk0, blk1, norm []float32) *os.File {
+		f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+
+		if err := WriteGGUF(f, KV{
+			"general.architecture": arch,
+			"general.file_type":    uint32(0),
+			arch + ".block_count":  uint32(2),
+		}, []Tensor{
+			{Name: "blk.0.attn_q.weight", Kind: tensorTypeF32, Shape: []uint64{2, 2}, WriterTo: f32s(blk0...)},
+			{Name: "blk.1.attn_q.weight", Kind: tensorTypeF32, Shape: []uint64{2, uint64(len(blk1) / 2)}, WriterTo: f32s(blk1...)},
+			{Name: "output_norm.weight", Kind: tensorTypeF32, Shape: []uint64{2}, WriterTo: f32s(norm...)},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	a := model("llama", []float32{1, 1, 1, 1}, []float32{2, 2, 2, 2}, []float32{1, 0})
+	b := model("llama", []float32{3, 3, 3, 3}, []float32{4, 4, 4, 4}, []float32{0, 1})
+	base := model("llama", []float32{1, 1, 1, 1}, []float32{1, 1, 1, 1}, []float32{0, 0})
+
+	all := func(w float32) func(string) float32 {
+		return func(string) float32 { return w }
+	}
+
+	merge := func(t *testing.T, opts MergeOptions, models ...Model) map[string][]float32 {
+		t.Helper()
+
+		out, err := os.Create(filepath.Join(t.TempDir(), "merged.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+
+		var completed, total uint64
+		if err := MergeModels(out, a, models, opts, func(c, t uint64) {
+			completed, total = c, t
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if completed != total {
+			t.Errorf("progress: completed = %d, total = %d", completed, total)
+		}
+
+		f, _, err := Decode(io.NewSectionReader(out, 0, math.MaxInt64), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := make(map[string][]float32)
+		for _, tensor := range f.Tensors().Items() {
+			x, err := readFloat32s(io.NewSectionReader(out, int64(f.Tensors().Offset+tensor.Offset), int64(tensor.Size())), tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values[tensor.Name] = x
+		}
+		return values
+	}
+
+	cases := []struct {
+		name   string
+		opts   MergeOptions
+		models []Model
+		want   map[string][]float32
+	}{
+		{
+			name:   "linear",
+			models: []Model{{R: b, Weight: all(0.5)}},
+			want: map[string][]float32{
+				"blk.0.attn_q.weight": {2, 2, 2, 2},
+				"blk.1.attn_q.weight": {3, 3, 3, 3},
+				"output_norm.weight":  {0.5, 0.5},
+			},
+		},
+		{
+			name: "layers",
+			opts: MergeOptions{Method: MergeLinear},
+			models: []Model{{R: b, Weight: func(name string) float32 {
+				if strings.HasPrefix(name, "blk.1.") {
+					return 0.25
+				}
+				return 0
+			}}},
+			want: map[string][]float32{
+				"blk.0.attn_q.weight": {1, 1, 1, 1},
+				"blk.1.attn_q.weight": {2.5, 2.5, 2.5, 2.5},
+				"output_norm.weight":  {1, 0},
+			},
+		},
+		{
+			// parallel tensors are interpolated linearly
+			name:   "slerp",
+			opts:   MergeOptions{Method: MergeSLERP},
+			models: []Model{{R: b, Weight: all(0.5)}},
+			want: map[string][]float32{
+				"blk.0.attn_q.weight": {2, 2, 2, 2},
+				"blk.1.attn_q.weight": {3, 3, 3, 3},
+				"output_norm.weight":  {math.Sqrt2 / 2, math.Sqrt2 / 2},
+			},
+		},
+		{
+			name:   "task arithmetic",
+			opts:   MergeOptions{Method: MergeTaskArithmetic, Base: base},
+			models: []Model{{R: b, Weight: all(1)}, {R: base, Weight: all(0.5)}},
+			want: map[string][]float32{
+				"blk.0.attn_q.weight": {3, 3, 3, 3},
+				"blk.1.attn_q.weight": {5, 5, 5, 5},
+				"output_norm.weight":  {1, 1},
+			},
+		},
+		{
+			// base + 0.5(a - base) + 0.5(b - base)
+			name:   "task arithmetic weighted",
+			opts:   MergeOptions{Method: MergeTaskArithmetic, Base: base, Weight: all(0.5)},
+			models: []Model{{R: b, Weight: all(0.5)}},
+			want: map[string][]float32{
+				"blk.0.attn_q.weight": {2, 2, 2, 2},
+				"blk.1.attn_q.weight": {3, 3, 3, 3},
+				"output_norm.weight":  {0.5, 0.5},
+			},
+		},
+		{
+			// only the model's own difference is weighted
+			name:   "task arithmetic model",
+			opts:   MergeOptions{Method: MergeTaskArithmetic, Base: base, Weight: all(0)},
+			models: []Model{{R: b, Weight: all(0)}},
+			want: map[string][]float32{
+				"blk.0.attn_q.weight": {1, 1, 1, 1},
+				"blk.1.attn_q.weight": {1, 1, 1, 1},
+				"output_norm.weight":  {0, 0},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, merge(t, tt.opts, tt.models...), cmpopts.EquateApprox(0, 1e-5)); diff != "" {
+				t.Errorf("merged weights mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		out, err := os.Create(filepath.Join(t.TempDir(), "merged.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+
+		for _, tt := range []struct {
+			name   string
+			opts   MergeOptions
+			models []Model
+		}{
+			{"method", MergeOptions{Method: "ties"}, []Model{{R: b, Weight: all(1)}}},
+			{"none", MergeOptions{}, nil},
+			{"slerp", MergeOptions{Method: MergeSLERP}, []Model{{R: b, Weight: all(1)}, {R: base, Weight: all(1)}}},
+			{"base", MergeOptions{Method: MergeTaskArithmetic}, []Model{{R: b, Weight: all(1)}}},
+			{"architecture", MergeOptions{}, []Model{{R: model("gemma", []float32{1, 1, 1, 1}, []float32{2, 2, 2, 2}, []float32{1, 0}), Weight: all(1)}}},
+			{"shape", MergeOptions{}, []Model{{R: model("llama", []float32{1, 1, 1, 1}, []float32{2, 2}, []float32{1, 0}), Weight: all(1)}}},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := MergeModels(out, a, tt.models, tt.opts, nil); err == nil {
+					t.Error("expected error")
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,1154 @@
+package ggml
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/x448/float16"
+)
+
+// Tensor types as stored in the Kind field of a [Tensor]
+const (
+	tensorTypeF32  uint32 = 0
+	tensorTypeF16  uint32 = 1
+	tensorTypeQ4_0 uint32 = 2
+	tensorTypeQ4_1 uint32 = 3
+	tensorTypeQ5_0 uint32 = 6
+	tensorTypeQ5_1 uint32 = 7
+	tensorTypeQ8_0 uint32 = 8
+	tensorTypeQ2_K uint32 = 10
+	tensorTypeQ3_K uint32 = 11
+	tensorTypeQ4_K uint32 = 12
+	tensorTypeQ5_K uint32 = 13
+	tensorTypeQ6_K uint32 = 14
+	tensorTypeBF16 uint32 = 30
+)
+
+const (
+	qk   = 32  // elements in a block of the legacy quantization types
+	qk_K = 256 // elements in a super-block of the k-quant types
+
+	groupMaxEps = 1e-15
+)
+
+// quantizer quantizes whole blocks of x into dst, which must hold the
+// quantized size of x. weights optionally holds the importance of each
+// element of x and is nil if unknown.
+type quantizer func(dst []byte, x, weights []float32)
+
+// dequantizer is the inverse of a quantizer
+type dequantizer func(dst []float32, src []byte)
+
+var quantizers = map[uint32]quantizer{
+	tensorTypeF32:  quantizeF32,
+	tensorTypeF16:  quantizeF16,
+	tensorTypeQ4_0: quantizeQ4_0,
+	tensorTypeQ4_1: quantizeQ4_1,
+	tensorTypeQ5_0: quantizeQ5_0,
+	tensorTypeQ5_1: quantizeQ5_1,
+	tensorTypeQ8_0: quantizeQ8_0,
+	tensorTypeQ2_K: quantizeQ2_K,
+	tensorTypeQ3_K: quantizeQ3_K,
+	tensorTypeQ4_K: quantizeQ4_K,
+	tensorTypeQ5_K: quantizeQ5_K,
+	tensorTypeQ6_K: quantizeQ6_K,
+}
+
+var dequantizers = map[uint32]dequantizer{
+	tensorTypeF32:  dequantizeF32,
+	tensorTypeF16:  dequantizeF16,
+	tensorTypeBF16: dequantizeBF16,
+	tensorTypeQ4_0: dequantizeQ4_0,
+	tensorTypeQ4_1: dequantizeQ4_1,
+	tensorTypeQ5_0: dequantizeQ5_0,
+	tensorTypeQ5_1: dequantizeQ5_1,
+	tensorTypeQ8_0: dequantizeQ8_0,
+	tensorTypeQ2_K: dequantizeQ2_K,
+	tensorTypeQ3_K: dequantizeQ3_K,
+	tensorTypeQ4_K: dequantizeQ4_K,
+	tensorTypeQ5_K: dequantizeQ5_K,
+	tensorTypeQ6_K: dequantizeQ6_K,
+}
+
+func fp16(f float32) uint16 {
+	return float16.Fromfloat32(f).Bits()
+}
+
+func fp32(b []byte) float32 {
+	return float16.Frombits(binary.LittleEndian.Uint16(b)).Float32()
+}
+
+func putFP16(b []byte, f float32) {
+	binary.LittleEndian.PutUint16(b, fp16(f))
+}
+
+// nearestInt rounds half to even like the float trick used by ggml
+func nearestInt(f float32) int {
+	return int(math.RoundToEven(float64(f)))
+}
+
+// meanSquare returns the mean of the squares of x
+func meanSquare(x []float32) float32 {
+	var sum float32
+	for _, v := range x {
+		sum += v * v
+	}
+
+	return sum / float32(len(x))
+}
+
+// importanceWeights scales the importance qw of each element of x by its
+// magnitude relative to sigma2 so larger elements are reproduced more closely
+func importanceWeights(dst, x, qw []float32, sigma2 float32) {
+	for i, v := range x {
+		dst[i] = qw[i] * sqrt32(sigma2+v*v)
+	}
+}
+
+// advance returns s without its first n elements, or nil if s is nil
+func advance(s []float32, n int) []float32 {
+	if s == nil {
+		return nil
+	}
+
+	return s[n:]
+}
+
+func abs32(f float32) float32 {
+	return float32(math.Abs(float64(f)))
+}
+
+func sqrt32(f float32) float32 {
+	return float32(math.Sqrt(float64(f)))
+}
+
+func quantizeF32(dst []byte, x, _ []float32) {
+	for i, v := range x {
+		binary.LittleEndian.PutUint32(dst[4*i:], math.Float32bits(v))
+	}
+}
+
+func dequantizeF32(dst []float32, src []byte) {
+	for i := range dst {
+		dst[i] = math.Float32frombits(binary.LittleEndian.Uint32(src[4*i:]))
+	}
+}
+
+func quantizeF16(dst []byte, x, _ []float32) {
+	for i, v := range x {
+		putFP16(dst[2*i:], v)
+	}
+}
+
+func dequantizeF16(dst []float32, src []byte) {
+	for i := range dst {
+		dst[i] = fp32(src[2*i:])
+	}
+}
+
+func dequantizeBF16(dst []float32, src []byte) {
+	for i := range dst {
+		dst[i] = math.Float32frombits(uint32(binary.LittleEndian.Uint16(src[2*i:])) << 16)
+	}
+}
+
+// Q4_0: d (f16), qs[16]
+func quantizeQ4_0(dst []byte, x, weights []float32) {
+	var l [qk]int8
+	var w [qk]float32
+	for ; len(x) >= qk; x, dst = x[qk:], dst[18:] {
+		if weights != nil {
+			importanceWeights(w[:], x[:qk], weights[:qk], meanSquare(x[:qk]))
+			weights = weights[qk:]
+
+			putFP16(dst, makeQXQuants(8, x[:qk], l[:], 1, w[:]))
+			for j := range qk / 2 {
+				dst[2+j] = uint8(l[j]) | uint8(l[qk/2+j])<<4
+			}
+
+			continue
+		}
+
+		var amax, xmax float32
+		for _, v := range x[:qk] {
+			if amax < abs32(v) {
+				amax = abs32(v)
+				xmax = v
+			}
+		}
+
+		d := xmax / -8
+		var id float32
+		if d != 0 {
+			id = 1 / d
+		}
+
+		putFP16(dst, d)
+		for j := range qk / 2 {
+			x0 := min(15, uint8(int8(x[j]*id+8.5)))
+			x1 := min(15, uint8(int8(x[qk/2+j]*id+8.5)))
+			dst[2+j] = x0 | x1<<4
+		}
+	}
+}
+
+func dequantizeQ4_0(dst []float32, src []byte) {
+	for ; len(dst) >= qk; dst, src = dst[qk:], src[18:] {
+		d := fp32(src)
+		for j := range qk / 2 {
+			dst[j] = float32(int(src[2+j]&0xf)-8) * d
+			dst[qk/2+j] = float32(int(src[2+j]>>4)-8) * d
+		}
+	}
+}
+
+// Q4_1: d (f16), m (f16), qs[16]
+func quantizeQ4_1(dst []byte, x, weights []float32) {
+	var l, laux [qk]uint8
+	var w [qk]float32
+	for ; len(x) >= qk; x, dst = x[qk:], dst[20:] {
+		if weights != nil {
+			importanceWeights(w[:], x[:qk], weights[:qk], meanSquare(x[:qk]))
+			weights = weights[qk:]
+
+			d, m := makeQKX2Quants(15, x[:qk], w[:], l[:], laux[:], -0.9, 0.05, 36, false)
+			putFP16(dst, d)
+			putFP16(dst[2:], -m)
+			for j := range qk / 2 {
+				dst[4+j] = l[j] | l[qk/2+j]<<4
+			}
+
+			continue
+		}
+
+		min_, max_ := float32(math.MaxFloat32), float32(-math.MaxFloat32)
+		for _, v := range x[:qk] {
+			min_ = min(min_, v)
+			max_ = max(max_, v)
+		}
+
+		d := (max_ - min_) / 15
+		var id float32
+		if d != 0 {
+			id = 1 / d
+		}
+
+		putFP16(dst, d)
+		putFP16(dst[2:], min_)
+		for j := range qk / 2 {
+			x0 := min(15, uint8(int8((x[j]-min_)*id+0.5)))
+			x1 := min(15, uint8(int8((x[qk/2+j]-min_)*id+0.5)))
+			dst[4+j] = x0 | x1<<4
+		}
+	}
+}
+
+func dequantizeQ4_1(dst []float32, src []byte) {
+	for ; len(dst) >= qk; dst, src = dst[qk:], src[20:] {
+		d, m := fp32(src), fp32(src[2:])
+		for j := range qk / 2 {
+			dst[j] = float32(src[4+j]&0xf)*d + m
+			dst[qk/2+j] = float32(src[4+j]>>4)*d + m
+		}
+	}
+}
+
+// Q5_0: d (f16), qh (u32), qs[16]
+func quantizeQ5_0(dst []byte, x, weights []float32) {
+	var l [qk]int8
+	var w [qk]float32
+	for ; len(x) >= qk; x, dst = x[qk:], dst[22:] {
+		if weights != nil {
+			importanceWeights(w[:], x[:qk], weights[:qk], meanSquare(x[:qk]))
+			weights = weights[qk:]
+
+			putFP16(dst, makeQXQuants(16, x[:qk], l[:], 1, w[:]))
+			var qh uint32
+			for j := range qk / 2 {
+				x0, x1 := uint8(l[j]), uint8(l[qk/2+j])
+				dst[6+j] = x0&0xf | (x1&0xf)<<4
+				qh |= uint32(x0&0x10) >> 4 << j
+				qh |= uint32(x1&0x10) >> 4 << (j + qk/2)
+			}
+
+			binary.LittleEndian.PutUint32(dst[2:], qh)
+			continue
+		}
+
+		var amax, xmax float32
+		for _, v := range x[:qk] {
+			if amax < abs32(v) {
+				amax = abs32(v)
+				xmax = v
+			}
+		}
+
+		d := xmax / -16
+		var id float32
+		if d != 0 {
+			id = 1 / d
+		}
+
+		putFP16(dst, d)
+
+		var qh uint32
+		for j := range qk / 2 {
+			x0 := min(31, uint8(int8(x[j]*id+16.5)))
+			x1 := min(31, uint8(int8(x[qk/2+j]*id+16.5)))
+			dst[6+j] = x0&0xf | (x1&0xf)<<4
+
+			// the fifth bit of each quant is stored in qh
+			qh |= uint32(x0&0x10) >> 4 << j
+			qh |= uint32(x1&0x10) >> 4 << (j + qk/2)
+		}
+
+		binary.LittleEndian.PutUint32(dst[2:], qh)
+	}
+}
+
+func dequantizeQ5_0(dst []float32, src []byte) {
+	for ; len(dst) >= qk; dst, src = dst[qk:], src[22:] {
+		d := fp32(src)
+		qh := binary.LittleEndian.Uint32(src[2:])
+		for j := range qk / 2 {
+			x0 := int(src[6+j]&0xf) | int(qh>>j&1)<<4
+			x1 := int(src[6+j]>>4) | int(qh>>(j+qk/2)&1)<<4
+			dst[j] = float32(x0-16) * d
+			dst[qk/2+j] = float32(x1-16) * d
+		}
+	}
+}
+
+// Q5_1: d (f16), m (f16), qh (u32), qs[16]
+func quantizeQ5_1(dst []byte, x, weights []float32) {
+	var l, laux [qk]uint8
+	var w [qk]float32
+	for ; len(x) >= qk; x, dst = x[qk:], dst[24:] {
+		if weights != nil {
+			importanceWeights(w[:], x[:qk], weights[:qk], meanSquare(x[:qk]))
+			weights = weights[qk:]
+
+			d, m := makeQKX2Quants(31, x[:qk], w[:], l[:], laux[:], -0.9, 0.05, 36, false)
+			putFP16(dst, d)
+			putFP16(dst[2:], -m)
+			var qh uint32
+			for j := range qk / 2 {
+				x0, x1 := l[j], l[qk/2+j]
+				dst[8+j] = x0&0xf | (x1&0xf)<<4
+				qh |= uint32(x0&0x10) >> 4 << j
+				qh |= uint32(x1&0x10) >> 4 << (j + qk/2)
+			}
+
+			binary.LittleEndian.PutUint32(dst[4:], qh)
+			continue
+		}
+
+		min_, max_ := float32(math.MaxFloat32), float32(-math.MaxFloat32)
+		for _, v := range x[:qk] {
+			min_ = min(min_, v)
+			max_ = max(max_, v)
+		}
+
+		d := (max_ - min_) / 31
+		var id float32
+		if d != 0 {
+			id = 1 / d
+		}
+
+		putFP16(dst, d)
+		putFP16(dst[2:], min_)
+
+		var qh uint32
+		for j := range qk / 2 {
+			x0 := uint8((x[j]-min_)*id + 0.5)
+			x1 := uint8((x[qk/2+j]-min_)*id + 0.5)
+			dst[8+j] = x0&0xf | (x1&0xf)<<4
+
+			qh |= uint32(x0&0x10) >> 4 << j
+			qh |= uint32(x1&0x10) >> 4 << (j + qk/2)
+		}
+
+		binary.LittleEndian.PutUint32(dst[4:], qh)
+	}
+}
+
+func dequantizeQ5_1(dst []float32, src []byte) {
+	for ; len(dst) >= qk; dst, src = dst[qk:], src[24:] {
+		d, m := fp32(src), fp32(src[2:])
+		qh := binary.LittleEndian.Uint32(src[4:])
+		for j := range qk / 2 {
+			x0 := int(src[8+j]&0xf) | int(qh>>j&1)<<4
+			x1 := int(src[8+j]>>4) | int(qh>>(j+qk/2)&1)<<4
+			dst[j] = float32(x0)*d + m
+			dst[qk/2+j] = float32(x1)*d + m
+		}
+	}
+}
+
+// Q8_0: d (f16), qs[32]
+func quantizeQ8_0(dst []byte, x, _ []float32) {
+	for ; len(x) >= qk; x, dst = x[qk:], dst[34:] {
+		var amax float32
+		for _, v := range x[:qk] {
+			amax = max(amax, abs32(v))
+		}
+
+		d := amax / 127
+		var id float32
+		if d != 0 {
+			id = 1 / d
+		}
+
+		putFP16(dst, d)
+		for j, v := range x[:qk] {
+			dst[2+j] = byte(int8(math.Round(float64(v * id))))
+		}
+	}
+}
+
+func dequantizeQ8_0(dst []float32, src []byte) {
+	for ; len(dst) >= qk; dst, src = dst[qk:], src[34:] {
+		d := fp32(src)
+		for j := range qk {
+			dst[j] = float32(int8(src[2+j])) * d
+		}
+	}
+}
+
+// makeQXQuants finds a symmetric scale for x with quants in [-nmax, nmax)
+// and stores the quants offset by nmax in l. The scale minimizes the error
+// weighted by qw, or by a function of x selected by rmseType if qw is nil.
+func makeQXQuants(nmax int, x []float32, l []int8, rmseType int, qw []float32) float32 {
+	var xmax, amax float32
+	for _, v := range x {
+		if ax := abs32(v); ax > amax {
+			amax = ax
+			xmax = v
+		}
+	}
+
+	if amax < groupMaxEps {
+		clear(l[:len(x)])
+		return 0
+	}
+
+	iscale := -float32(nmax) / xmax
+	quant := func(v float32) int {
+		return min(max(nearestInt(iscale*v), -nmax), nmax-1)
+	}
+
+	if rmseType == 0 {
+		for i, v := range x {
+			l[i] = int8(nmax + quant(v))
+		}
+
+		return 1 / iscale
+	}
+
+	returnEarly := rmseType < 0
+	if returnEarly {
+		rmseType = -rmseType
+	}
+
+	weight := func(i int) float32 {
+		switch {
+		case qw != nil:
+			return qw[i]
+		case rmseType == 1:
+			return x[i] * x[i]
+		case rmseType == 2:
+			return 1
+		case rmseType == 3:
+			return abs32(x[i])
+		default:
+			return sqrt32(abs32(x[i]))
+		}
+	}
+
+	var sumlx, suml2 float32
+	for i, v := range x {
+		q := quant(v)
+		l[i] = int8(q + nmax)
+		w := weight(i)
+		sumlx += w * v * float32(q)
+		suml2 += w * float32(q) * float32(q)
+	}
+
+	var scale float32
+	if suml2 != 0 {
+		scale = sumlx / suml2
+	}
+
+	if returnEarly {
+		if suml2 > 0 {
+			return 0.5 * (scale + 1/iscale)
+		}
+
+		return 1 / iscale
+	}
+
+	best := scale * sumlx
+	for is := -9; is <= 9; is++ {
+		if is == 0 {
+			continue
+		}
+
+		iscale = -(float32(nmax) + 0.1*float32(is)) / xmax
+		sumlx, suml2 = 0, 0
+		for i, v := range x {
+			q := quant(v)
+			w := weight(i)
+			sumlx += w * v * float32(q)
+			suml2 += w * float32(q) * float32(q)
+		}
+
+		if suml2 > 0 && sumlx*sumlx > best*suml2 {
+			for i, v := range x {
+				l[i] = int8(nmax + quant(v))
+			}
+
+			scale = sumlx / suml2
+			best = scale * sumlx
+		}
+	}
+
+	return scale
+}
+
+// makeQ3Quants is makeQXQuants for 3 bit quants, optionally refining the
+// quants one at a time to reduce the squared error
+func makeQ3Quants(nmax int, x []float32, l []int8, doRMSE bool) float32 {
+	var xmax, amax float32
+	for _, v := range x {
+		if ax := abs32(v); ax > amax {
+			amax = ax
+			xmax = v
+		}
+	}
+
+	if amax < groupMaxEps {
+		clear(l[:len(x)])
+		return 0
+	}
+
+	iscale := -float32(nmax) / xmax
+	if doRMSE {
+		var sumlx, suml2 float32
+		for i, v := range x {
+			q := min(max(nearestInt(iscale*v), -nmax), nmax-1)
+			l[i] = int8(q)
+			w := v * v
+			sumlx += w * v * float32(q)
+			suml2 += w * float32(q) * float32(q)
+		}
+
+		for range 5 {
+			var changed int
+			for i, v := range x {
+				w := v * v
+				slx := sumlx - w*v*float32(l[i])
+				if slx > 0 {
+					sl2 := suml2 - w*float32(l[i])*float32(l[i])
+					q := min(max(nearestInt(v*sl2/slx), -nmax), nmax-1)
+					if q != int(l[i]) {
+						slx += w * v * float32(q)
+						sl2 += w * float32(q) * float32(q)
+						if sl2 > 0 && slx*slx*suml2 > sumlx*sumlx*sl2 {
+							l[i] = int8(q)
+							sumlx, suml2 = slx, sl2
+							changed++
+						}
+					}
+				}
+			}
+
+			if changed == 0 {
+				break
+			}
+		}
+
+		for i := range x {
+			l[i] += int8(nmax)
+		}
+
+		return sumlx / suml2
+	}
+
+	for i, v := range x {
+		l[i] = int8(min(max(nearestInt(iscale*v), -nmax), nmax-1) + nmax)
+	}
+
+	return 1 / iscale
+}
+
+// makeQKX2Quants finds an asymmetric scale and minimum for x with quants in
+// [0, nmax] minimizing the weighted error, searching nstep scales around the
+// initial estimate. It returns the scale and the negated minimum.
+func makeQKX2Quants(nmax int, x, weights []float32, l, laux []uint8, rmin, rdelta float32, nstep int, useMAD bool) (float32, float32) {
+	min_, max_ := x[0], x[0]
+	sumW := weights[0]
+	sumX := sumW * x[0]
+	for i := 1; i < len(x); i++ {
+		min_ = min(min_, x[i])
+		max_ = max(max_, x[i])
+		sumW += weights[i]
+		sumX += weights[i] * x[i]
+	}
+
+	min_ = min(min_, 0)
+	if max_ == min_ {
+		clear(l[:len(x)])
+		return 0, -min_
+	}
+
+	errorOf := func(diff float32) float32 {
+		if useMAD {
+			return abs32(diff)
+		}
+
+		return diff * diff
+	}
+
+	iscale := float32(nmax) / (max_ - min_)
+	scale := 1 / iscale
+	var bestMAD float32
+	for i, v := range x {
+		l[i] = uint8(min(max(nearestInt(iscale*(v-min_)), 0), nmax))
+		bestMAD += weights[i] * errorOf(scale*float32(l[i])+min_-v)
+	}
+
+	if nstep < 1 {
+		return scale, -min_
+	}
+
+	for is := 0; is <= nstep; is++ {
+		iscale = (rmin + rdelta*float32(is) + float32(nmax)) / (max_ - min_)
+		var sumL, sumL2, sumXL float32
+		for i, v := range x {
+			q := min(max(nearestInt(iscale*(v-min_)), 0), nmax)
+			laux[i] = uint8(q)
+			w := weights[i]
+			sumL += w * float32(q)
+			sumL2 += w * float32(q) * float32(q)
+			sumXL += w * float32(q) * v
+		}
+
+		D := sumW*sumL2 - sumL*sumL
+		if D > 0 {
+			thisScale := (sumW*sumXL - sumX*sumL) / D
+			thisMin := (sumL2*sumX - sumL*sumXL) / D
+			if thisMin > 0 {
+				thisMin = 0
+				thisScale = sumXL / sumL2
+			}
+
+			var mad float32
+			for i, v := range x {
+				mad += weights[i] * errorOf(thisScale*float32(laux[i])+thisMin-v)
+			}
+
+			if mad < bestMAD {
+				copy(l[:len(x)], laux[:len(x)])
+				bestMAD = mad
+				scale = thisScale
+				min_ = thisMin
+			}
+		}
+	}
+
+	return scale, -min_
+}
+
+// Q2_K: scales[16], qs[64], d (f16), dmin (f16)
+func quantizeQ2_K(dst []byte, x, qw []float32) {
+	var l [qk_K]uint8
+	var laux [16]uint8
+	var weights [16]float32
+	var mins, scales [qk_K / 16]float32
+
+	const q4scale = 15
+
+	for ; len(x) >= qk_K; x, dst, qw = x[qk_K:], dst[84:], advance(qw, qk_K) {
+		sigma2 := meanSquare(x[:qk_K])
+
+		var maxScale, maxMin float32
+		for j := range qk_K / 16 {
+			if qw != nil {
+				importanceWeights(weights[:], x[16*j:16*j+16], qw[16*j:16*j+16], sigma2)
+				scales[j], mins[j] = makeQKX2Quants(3, x[16*j:16*j+16], weights[:], l[16*j:], laux[:], -0.9, 0.05, 36, false)
+			} else {
+				for i := range 16 {
+					weights[i] = abs32(x[16*j+i])
+				}
+
+				scales[j], mins[j] = makeQKX2Quants(3, x[16*j:16*j+16], weights[:], l[16*j:], laux[:], -0.5, 0.1, 15, true)
+			}
+
+			maxScale = max(maxScale, scales[j])
+			maxMin = max(maxMin, mins[j])
+		}
+
+		sc := dst[:16]
+		if maxScale > 0 {
+			iscale := q4scale / maxScale
+			for j := range qk_K / 16 {
+				sc[j] = uint8(nearestInt(iscale * scales[j]))
+			}
+
+			putFP16(dst[80:], maxScale/q4scale)
+		} else {
+			clear(sc)
+			putFP16(dst[80:], 0)
+		}
+
+		if maxMin > 0 {
+			iscale := q4scale / maxMin
+			for j := range qk_K / 16 {
+				sc[j] |= uint8(nearestInt(iscale*mins[j])) << 4
+			}
+
+			putFP16(dst[82:], maxMin/q4scale)
+		} else {
+			putFP16(dst[82:], 0)
+		}
+
+		for j := range qk_K / 16 {
+			d := fp32(dst[80:]) * float32(sc[j]&0xf)
+			if d == 0 {
+				continue
+			}
+
+			dm := fp32(dst[82:]) * float32(sc[j]>>4)
+			for i := range 16 {
+				l[16*j+i] = uint8(min(max(nearestInt((x[16*j+i]+dm)/d), 0), 3))
+			}
+		}
+
+		qs := dst[16:80]
+		for j := 0; j < qk_K; j += 128 {
+			for i := range 32 {
+				qs[j/4+i] = l[j+i] | l[j+i+32]<<2 | l[j+i+64]<<4 | l[j+i+96]<<6
+			}
+		}
+	}
+}
+
+func dequantizeQ2_K(dst []float32, src []byte) {
+	for ; len(dst) >= qk_K; src = src[84:] {
+		d, dmin := fp32(src[80:]), fp32(src[82:])
+		q := src[16:80]
+
+		var is int
+		for range qk_K / 128 {
+			for shift := 0; shift < 8; shift += 2 {
+				for _, half := range []int{0, 16} {
+					sc := src[is]
+					is++
+
+					dl, ml := d*float32(sc&0xf), dmin*float32(sc>>4)
+					for i := range 16 {
+						dst[i] = dl*float32(q[half+i]>>shift&3) - ml
+					}
+
+					dst = dst[16:]
+				}
+			}
+
+			q = q[32:]
+		}
+	}
+}
+
+// q3KScale unpacks the 6 bit scale j of a Q3_K block
+func q3KScale(scales []byte, j int) int8 {
+	var sc int8
+	if j < 8 {
+		sc = int8(scales[j] & 0xf)
+	} else {
+		sc = int8(scales[j-8] >> 4)
+	}
+
+	return (sc | int8(scales[8+j%4]>>(2*(j/4))&3)<<4) - 32
+}
+
+// Q3_K: hmask[32], qs[64], scales[12], d (f16)
+func quantizeQ3_K(dst []byte, x, qw []float32) {
+	var l [qk_K]int8
+	var weights [16]float32
+	var scales [qk_K / 16]float32
+
+	for ; len(x) >= qk_K; x, dst, qw = x[qk_K:], dst[110:], advance(qw, qk_K) {
+		sigma2 := meanSquare(x[:qk_K])
+
+		var maxScale, amax float32
+		for j := range qk_K / 16 {
+			if qw != nil {
+				importanceWeights(weights[:], x[16*j:16*j+16], qw[16*j:16*j+16], sigma2)
+				scales[j] = makeQXQuants(4, x[16*j:16*j+16], l[16*j:], 1, weights[:])
+			} else {
+				scales[j] = makeQ3Quants(4, x[16*j:16*j+16], l[16*j:], true)
+			}
+
+			if scale := abs32(scales[j]); scale > amax {
+				amax = scale
+				maxScale = scales[j]
+			}
+		}
+
+		sc := dst[96:108]
+		clear(sc)
+		if maxScale != 0 {
+			iscale := -32 / maxScale
+			for j := range qk_K / 16 {
+				q := int8(nearestInt(iscale * scales[j]))
+				q = min(max(q, -32), 31) + 32
+				if j < 8 {
+					sc[j] = uint8(q) & 0xf
+				} else {
+					sc[j-8] |= (uint8(q) & 0xf) << 4
+				}
+
+				q >>= 4
+				sc[j%4+8] |= uint8(q) << (2 * (j / 4))
+			}
+
+			putFP16(dst[108:], 1/iscale)
+		} else {
+			putFP16(dst[108:], 0)
+		}
+
+		for j := range qk_K / 16 {
+			d := fp32(dst[108:]) * float32(q3KScale(sc, j))
+			if d == 0 {
+				continue
+			}
+
+			for i := range 16 {
+				l[16*j+i] = int8(min(max(nearestInt(x[16*j+i]/d), -4), 3) + 4)
+			}
+		}
+
+		// the high bit of the first 32 quants goes into bit 0, the next 32
+		// into bit 1 and so on
+		hmask := dst[:32]
+		clear(hmask)
+		var m int
+		hm := uint8(1)
+		for j := range qk_K {
+			if l[j] > 3 {
+				hmask[m] |= hm
+				l[j] -= 4
+			}
+
+			if m++; m == qk_K/8 {
+				m = 0
+				hm <<= 1
+			}
+		}
+
+		qs := dst[32:96]
+		for j := 0; j < qk_K; j += 128 {
+			for i := range 32 {
+				qs[j/4+i] = uint8(l[j+i] | l[j+i+32]<<2 | l[j+i+64]<<4 | l[j+i+96]<<6)
+			}
+		}
+	}
+}
+
+func dequantizeQ3_K(dst []float32, src []byte) {
+	for ; len(dst) >= qk_K; src = src[110:] {
+		d := fp32(src[108:])
+		hm := src[:32]
+		q := src[32:96]
+		sc := src[96:108]
+
+		var is int
+		m := uint8(1)
+		for range qk_K / 128 {
+			for shift := 0; shift < 8; shift += 2 {
+				for _, half := range []int{0, 16} {
+					dl := d * float32(q3KScale(sc, is))
+					is++
+
+					for i := range 16 {
+						v := int8(q[half+i] >> shift & 3)
+						if hm[half+i]&m == 0 {
+							v -= 4
+						}
+
+						dst[i] = dl * float32(v)
+					}
+
+					dst = dst[16:]
+				}
+
+				m <<= 1
+			}
+
+			q = q[32:]
+		}
+	}
+}
+
+// scaleMinK4 unpacks the 6 bit scale and minimum j of a Q4_K or Q5_K block
+func scaleMinK4(j int, q []byte) (uint8, uint8) {
+	if j < 4 {
+		return q[j] & 63, q[j+4] & 63
+	}
+
+	return q[j+4]&0xf | (q[j-4]>>6)<<4, q[j+4]>>4 | (q[j]>>6)<<4
+}
+
+// packScaleMinK4 packs the 6 bit scales and minimums of a Q4_K or Q5_K block
+func packScaleMinK4(dst []byte, scales, mins []float32, maxScale, maxMin float32) {
+	var invScale, invMin float32
+	if maxScale > 0 {
+		invScale = 63 / maxScale
+	}
+
+	if maxMin > 0 {
+		invMin = 63 / maxMin
+	}
+
+	for j := range qk_K / 32 {
+		ls := min(63, uint8(nearestInt(invScale*scales[j])))
+		lm := min(63, uint8(nearestInt(invMin*mins[j])))
+		if j < 4 {
+			dst[j] = ls
+			dst[j+4] = lm
+		} else {
+			dst[j+4] = ls&0xf | (lm&0xf)<<4
+			dst[j-4] |= (ls >> 4) << 6
+			dst[j] |= (lm >> 4) << 6
+		}
+	}
+}
+
+// quantizeK4 computes the scales, minimums and quants in [0, nmax] shared by
+// Q4_K and Q5_K for a super-block, writing d, dmin and the scales to dst. qw
+// is the importance of each element of x, or nil.
+func quantizeK4(dst []byte, x, qw []float32, l []uint8, nmax int, rmin float32, nstep int) {
+	var laux [32]uint8
+	var weights [32]float32
+	var mins, scales [qk_K / 32]float32
+
+	sigma2 := 2 * meanSquare(x[:qk_K])
+
+	var maxScale, maxMin float32
+	for j := range qk_K / 32 {
+		if qw != nil {
+			importanceWeights(weights[:], x[32*j:32*j+32], qw[32*j:32*j+32], sigma2)
+			scales[j], mins[j] = makeQKX2Quants(nmax, x[32*j:32*j+32], weights[:], l[32*j:], laux[:], -0.9, 0.05, 36, false)
+		} else {
+			avX := sqrt32(meanSquare(x[32*j : 32*j+32]))
+			for i := range 32 {
+				weights[i] = avX + abs32(x[32*j+i])
+			}
+
+			scales[j], mins[j] = makeQKX2Quants(nmax, x[32*j:32*j+32], weights[:], l[32*j:], laux[:], rmin, 0.1, nstep, false)
+		}
+
+		maxScale = max(maxScale, scales[j])
+		maxMin = max(maxMin, mins[j])
+	}
+
+	packScaleMinK4(dst[4:16], scales[:], mins[:], maxScale, maxMin)
+	putFP16(dst, maxScale/63)
+	putFP16(dst[2:], maxMin/63)
+
+	for j := range qk_K / 32 {
+		sc, m := scaleMinK4(j, dst[4:16])
+		d := fp32(dst) * float32(sc)
+		if d == 0 {
+			continue
+		}
+
+		dm := fp32(dst[2:]) * float32(m)
+		for i := range 32 {
+			l[32*j+i] = uint8(min(max(nearestInt((x[32*j+i]+dm)/d), 0), nmax))
+		}
+	}
+}
+
+// Q4_K: d (f16), dmin (f16), scales[12], qs[128]
+func quantizeQ4_K(dst []byte, x, qw []float32) {
+	var l [qk_K]uint8
+	for ; len(x) >= qk_K; x, dst, qw = x[qk_K:], dst[144:], advance(qw, qk_K) {
+		quantizeK4(dst, x, qw, l[:], 15, -1, 20)
+
+		q := dst[16:144]
+		for j := 0; j < qk_K; j += 64 {
+			for i := range 32 {
+				q[i] = l[j+i] | l[j+i+32]<<4
+			}
+
+			q = q[32:]
+		}
+	}
+}
+
+func dequantizeQ4_K(dst []float32, src []byte) {
+	for ; len(dst) >= qk_K; src = src[144:] {
+		d, dmin := fp32(src), fp32(src[2:])
+		q := src[16:144]
+		for is := 0; is < qk_K/32; is += 2 {
+			sc, m := scaleMinK4(is, src[4:16])
+			d1, m1 := d*float32(sc), dmin*float32(m)
+			sc, m = scaleMinK4(is+1, src[4:16])
+			d2, m2 := d*float32(sc), dmin*float32(m)
+			for i := range 32 {
+				dst[i] = d1*float32(q[i]&0xf) - m1
+				dst[32+i] = d2*float32(q[i]>>4) - m2
+			}
+
+			dst, q = dst[64:], q[32:]
+		}
+	}
+}
+
+// Q5_K: d (f16), dmin (f16), scales[12], qh[32], qs[128]
+func quantizeQ5_K(dst []byte, x, qw []float32) {
+	var l [qk_K]uint8
+	for ; len(x) >= qk_K; x, dst, qw = x[qk_K:], dst[176:], advance(qw, qk_K) {
+		quantizeK4(dst, x, qw, l[:], 31, -0.5, 15)
+
+		qh, ql := dst[16:48], dst[48:176]
+		clear(qh)
+		m1, m2 := uint8(1), uint8(2)
+		for n := 0; n < qk_K; n += 64 {
+			for j := range 32 {
+				l1, l2 := l[n+j], l[n+j+32]
+				if l1 > 15 {
+					l1 -= 16
+					qh[j] |= m1
+				}
+
+				if l2 > 15 {
+					l2 -= 16
+					qh[j] |= m2
+				}
+
+				ql[j] = l1 | l2<<4
+			}
+
+			m1 <<= 2
+			m2 <<= 2
+			ql = ql[32:]
+		}
+	}
+}
+
+func dequantizeQ5_K(dst []float32, src []byte) {
+	for ; len(dst) >= qk_K; src = src[176:] {
+		d, dmin := fp32(src), fp32(src[2:])
+		qh, ql := src[16:48], src[48:176]
+		u1, u2 := uint8(1), uint8(2)
+		for is := 0; is < qk_K/32; is += 2 {
+			sc, m := scaleMinK4(is, src[4:16])
+			d1, m1 := d*float32(sc), dmin*float32(m)
+			sc, m = scaleMinK4(is+1, src[4:16])
+			d2, m2 := d*float32(sc), dmin*float32(m)
+			for i := range 32 {
+				h1, h2 := float32(0), float32(0)
+				if qh[i]&u1 != 0 {
+					h1 = 16
+				}
+
+				if qh[i]&u2 != 0 {
+					h2 = 16
+				}
+
+				dst[i] = d1*(float32(ql[i]&0xf)+h1) - m1
+				dst[32+i] = d2*(float32(ql[i]>>4)+h2) - m2
+			}
+
+			dst, ql = dst[64:], ql[32:]
+			u1 <<= 2
+			u2 <<= 2
+		}
+	}
+}
+
+// Q6_K: ql[128], qh[64], scales[16], d (f16)
+func quantizeQ6_K(dst []byte, x, qw []float32) {
+	var l [qk_K]int8
+	var weights [16]float32
+	var scales [qk_K / 16]float32
+
+	for ; len(x) >= qk_K; x, dst, qw = x[qk_K:], dst[210:], advance(qw, qk_K) {
+		sigma2 := meanSquare(x[:qk_K])
+
+		var maxScale, maxAbsScale float32
+		for ib := range qk_K / 16 {
+			var w []float32
+			if qw != nil {
+				importanceWeights(weights[:], x[16*ib:16*ib+16], qw[16*ib:16*ib+16], sigma2)
+				w = weights[:]
+			}
+
+			scales[ib] = makeQXQuants(32, x[16*ib:16*ib+16], l[16*ib:], 1, w)
+			if abs := abs32(scales[ib]); abs > maxAbsScale {
+				maxAbsScale = abs
+				maxScale = scales[ib]
+			}
+		}
+
+		if maxAbsScale < groupMaxEps {
+			clear(dst[:210])
+			continue
+		}
+
+		iscale := -128 / maxScale
+		putFP16(dst[208:], 1/iscale)
+
+		sc := dst[192:208]
+		for ib := range qk_K / 16 {
+			sc[ib] = byte(int8(min(127, nearestInt(iscale*scales[ib]))))
+		}
+
+		for j := range qk_K / 16 {
+			d := fp32(dst[208:]) * float32(int8(sc[j]))
+			if d == 0 {
+				continue
+			}
+
+			for i := range 16 {
+				l[16*j+i] = int8(min(max(nearestInt(x[16*j+i]/d), -32), 31) + 32)
+			}
+		}
+
+		ql, qh := dst[:128], dst[128:192]
+		for j := 0; j < qk_K; j += 128 {
+			for i := range 32 {
+				q1, q2, q3, q4 := uint8(l[j+i]), uint8(l[j+i+32]), uint8(l[j+i+64]), uint8(l[j+i+96])
+				ql[i] = q1&0xf | (q3&0xf)<<4
+				ql[i+32] = q2&0xf | (q4&0xf)<<4
+				qh[i] = q1>>4 | (q2>>4)<<2 | (q3>>4)<<4 | (q4>>4)<<6
+			}
+
+			ql, qh = ql[64:], qh[32:]
+		}
+	}
+}
+
+func dequantizeQ6_K(dst []float32, src []byte) {
+	for ; len(dst) >= qk_K; src = src[210:] {
+		d := fp32(src[208:])
+		ql, qh, sc := src[:128], src[128:192], src[192:208]
+		for range qk_K / 128 {
+			for i := range 32 {
+				is := i / 16
+				q1 := int8(ql[i]&0xf|(qh[i]>>0&3)<<4) - 32
+				q2 := int8(ql[i+32]&0xf|(qh[i]>>2&3)<<4) - 32
+				q3 := int8(ql[i]>>4|(qh[i]>>4&3)<<4) - 32
+				q4 := int8(ql[i+32]>>4|(qh[i]>>6&3)<<4) - 32
+				dst[i] = d * float32(int8(sc[is])) * float32(q1)
+				dst[i+32] = d * float32(int8(sc[is+2])) * float32(q2)
+				dst[i+64] = d * float32(int8(sc[is+4])) * float32(q3)
+				dst[i+96] = d * float32(int8(sc[is+6])) * float32(q4)
+			}
+
+			dst, ql, qh, sc = dst[128:], ql[64:], qh[32:], sc[8:]
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package ggml
+
+import (
+	"encoding/hex"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// referenceInput is a deterministic input with a mix of magnitudes and
+// outliers. It matches the input used to generate the reference blocks below.
+func referenceInput(n int) []float32 {
+	x := make([]float32, n)
+	for i := range x {
+		v := float32((i*37)%101-50) / 17
+		v = float32(v * (1 + float32(i%7)*0.25))
+		if i%13 == 0 {
+			v += 3
+		}
+
+		x[i] = v - 0.1
+	}
+
+	return x
+}
+
+func TestQuantizeReference(t *testing.T) {
+	// blocks produced by ggml's quantize_row_*_ref functions for referenceInput
+	cases := []struct {
+		name string
+		kind uint32
+		n    int
+		want string
+	}{
+		{"Q4_0", tensorTypeQ4_0, 64,
+			"dfba48b9761db973cd9854da461faa703ba8293b9bb267aa6578cd288ab0579ae468cd54"},
+		{"Q4_1", tensorTypeQ4_1, 64,
+			"ab3aa1c5b73589e2468c3167ab25b9e0558fc457d73a29c7abb278ab6679dd298bc067aaf468cd54"},
+		{"Q5_0", tensorTypeQ5_0, 64,
+			"dfb65bdad2929072db2a61d68b2f99a48c3d43e065402937e9694b5b3664de44cbe18a410560be34d8b079a7"},
+		{"Q5_1", tensorTypeQ5_1, 64,
+			"7436a1c524252d6d6e7b13d48d1963cf564962c0aa0f98ae9e3629c7ed6d4b5b4884e056dcf2ac521680c046f9c19bb8"},
+		{"Q8_0", tensorTypeQ8_0, 64,
+			"ed2affec25b2f851ad063ade1e98e67fd6fe3acb1471d014c2f337b43d6be41050e4372b2e9ef421d6084d082481f320c3fc49bc162ee71ce1ee42a30031d714" +
+				"64db38d2"},
+		{"Q2_K", tensorTypeQ2_K, 512,
+			"ad6afcbc8adebbbe7bcbbb7aebcf8aee1db18a58b50a5c895a618a58b5db6da55a68851b64d518619668b62b6c955a641a78863b60d52c719a6cc52b61d56c75" +
+				"9758a2166d825ba18658a2d65eb65769f834a337dcad7afcbc8adeb9befdcbbb6aebcf8a8768a2166e9257618658a2daadb65761c61db1ca58b50a5cc95aa1ca" +
+				"58b5db6d8a5d618a58b50b6c851a61865879ca5db61b79873861d62d721b6dc62b61d66df5347b37"},
+		{"Q3_K", tensorTypeQ3_K, 512,
+			"87d92ec7f926d37f26d93ec7f92ed3e97cc936dc8b76dd2b76c93278d936fc8b440563c64c61fbb09621738709d099585e3af79c3627862ca27bcafc71a74929" +
+				"be1a95dc1d33c6ec725204cc1d274b279249f8dd73e897abb588fc1140c4937686997ca9db04c942c0300f00292c67f8136db917edba57ec9365f0b36dba749f" +
+				"c936cfe13ecb77bec936dfe136cbb279e8dd71d95f1bb288ec29f2c49f2e6fc805a346cce17eb09621b387c91099a709e4e376cca05eacda21f34700127da8be" +
+				"1b96dd1f31c7ecf25204cf1f254ad80b87866645fc45fc0fcffc45ac"},
+		{"Q4_K", tensorTypeQ4_K, 512,
+			"6d24472ff0f5f2f2f2fff7efb240ef91b72679a2568cd187ab0579a0458fc4479bb368ae6478c3268ab25b9ee568bc55177e93487e0348a3366a92377dc53b9c" +
+				"355a902669f4177ca4498f0a59b3466b9ca56d9f2569c4177ce3599f0659b34a7bb2378ae549cd356ba0277ae8588dc44579b13888e4378ac468e02678d3568a" +
+				"c557a905689245bac14699f4679e3479bc23712ff9fef0f9f7fdedf5bf88fe0d8bb2589ae9599d457bb037bae4689d346aa7267aa3578dc288ab0579a1467fc5" +
+				"c259ab056a95467dc0479bf4a99f356cb4278fa3589f1358b2467ba1478ed54caab4268aa3579fd358bb0679a2868cd547abb56ca03579d4278bf358ae1668c3" +
+				"a5587b95498e155ca4076b93387ef6499c365a922a6ac5287c255ac0176ab447"},
+		{"Q5_K", tensorTypeQ5_K, 512,
+			"3020902ff1b3f3f3effdf3eda42eff7332649b76c49326cab3649932449b26dc9366d933649932c49b26cdb776d933648e4b0363cd29c32f6609f2609a1fb88e" +
+				"4687e15dd7f1a65c1584c64cfac099ba2dec2680fc0690466cc4336eeb9a67386ab3203bd2f83ee857921f03a2758dd7487adb5f4be3b93f08f7a24f0db39694" +
+				"f7848025fb82ab6bd6704f05e0b02bb89be3637202ca7f1689c2c04de2b7be068ca0430bb1359c65847e24fad03f6ad4e81f6f2ff8f8edf6f7fdebb4af95f8ea" +
+				"bb664cb36749336489324c9b6e6db364c9326c99664c93664b336499324c9b660684af34f1b13a9af5807e84e8d03a68d47f4df455be29b520560bf3529c0faa" +
+				"84a2460ab32a8cfa807f27f8322f59d8774d0f45a01e25b1648be6418e0db98953884c0356be2ec6bf650bf2530c17ba8e5579d7506af1b85d15f6b04b2bc095" +
+				"5ca1e82a93ff1b9a490fc82771eefd733b6db72446d58b42eb3b95801fb57980"},
+		{"Q6_K", tensorTypeQ6_K, 512,
+			"300537d3123cc45f6228a92946a1caa0907fcb619dcb01f4e1a51f73a81c3a085b38ed5876026332e9e1fdf841fff2ffc93178070a061bee20708d6a40ec9e0f" +
+				"90ac8b817a5a1f1240721dc2761be5084ec87f6ab63356365b006d9acf00955007411666e8046acaaf800be84f39cdedf1b080ec819feb32f501b71053a93c4a" +
+				"2a82b55b86385ff92952b52b86e40e82a59679e49a29e34e699748d49239a09ad5a648e46e19e27e15a309d4ae1993792a945de6917aa35d3ae45d2a914ae791" +
+				"94a070aaa993508a9a67a5a47580b09836184188117b759559b7ce000ecb9102b9e1e7200427d2112cb34e6228981836a1cae0906eba619cca00e3e1940f7397" +
+				"1b36aaacc82ea896f294d21a111d08c11003c61b0306bf10e0b02e421668f5348ec82191b486908666122e508f14de7a15fc194fc8606aa62447364c006e9ac0" +
+				"0186f1f7321646e6134ac86f711ac83d48bd3b945ae6926aa78d3aa45d22514ae78d25ea42759b46f89f39e99275eb4628ce69aa52799a46e88f75e992759b4a" +
+				"289e3e97a54be46d1ae17dd7a00ad7ad1a905e939f6ca9aa96b08a6563a2a47180ad4818"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := Tensor{Kind: tt.kind, Shape: []uint64{uint64(tt.n)}}
+			got := make([]byte, tensor.Size())
+			quantizers[tt.kind](got, referenceInput(tt.n), nil)
+			if hex.EncodeToString(got) != tt.want {
+				t.Errorf("got %x\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuantizeRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		kind uint32
+
+		// maxError is the largest acceptable RMS error relative to the RMS of
+		// the input
+		maxError float64
+	}{
+		{"F32", tensorTypeF32, 0},
+		{"F16", tensorTypeF16, 0.001},
+		{"Q8_0", tensorTypeQ8_0, 0.01},
+		{"Q6_K", tensorTypeQ6_K, 0.02},
+		{"Q5_0", tensorTypeQ5_0, 0.05},
+		{"Q5_1", tensorTypeQ5_1, 0.05},
+		{"Q5_K", tensorTypeQ5_K, 0.05},
+		{"Q4_0", tensorTypeQ4_0, 0.1},
+		{"Q4_1", tensorTypeQ4_1, 0.1},
+		{"Q4_K", tensorTypeQ4_K, 0.1},
+		{"Q3_K", tensorTypeQ3_K, 0.2},
+		{"Q2_K", tensorTypeQ2_K, 0.4},
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+	x := make([]float32, 4096)
+	for i := range x {
+		x[i] = float32(r.NormFloat64())
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := Tensor{Kind: tt.kind, Shape: []uint64{uint64(len(x))}}
+			quantized := make([]byte, tensor.Size())
+			quantizers[tt.kind](quantized, x, nil)
+
+			y := make([]float32, len(x))
+			dequantizers[tt.kind](y, quantized)
+
+			var sumErr, sumX float64
+			for i := range x {
+				sumErr += float64(x[i]-y[i]) * float64(x[i]-y[i])
+				sumX += float64(x[i]) * float64(x[i])
+			}
+
+			if rel := math.Sqrt(sumErr / sumX); rel > tt.maxError {
+				t.Errorf("relative error %f exceeds %f", rel, tt.maxError)
+			}
+		})
+	}
+}
+
+func TestQuantizeZeros(t *testing.T) {
+	x := make([]float32, qk_K)
+	for kind, quantize := range quantizers {
+		tensor := Tensor{Kind: kind, Shape: []uint64{qk_K}}
+		quantized := make([]byte, tensor.Size())
+		quantize(quantized, x, nil)
+
+		y := make([]float32, qk_K)
+		dequantizers[kind](y, quantized)
+		for i, v := range y {
+			if v != 0 {
+				t.Fatalf("kind %d: y[%d] = %f, want 0", kind, i, v)
+			}
+		}
+	}
+}
+
+func TestQuantizeImportance(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	x := make([]float32, 4096)
+	for i := range x {
+		x[i] = float32(r.NormFloat64())
+	}
+
+	// a few columns are much more important than the rest
+	qw := make([]float32, len(x))
+	for i := range qw {
+		qw[i] = 0.1 + r.Float32()
+		if i%17 == 0 {
+			qw[i] = 50
+		}
+	}
+
+	weightedError := func(kind uint32, weights []float32) float64 {
+		tensor := Tensor{Kind: kind, Shape: []uint64{uint64(len(x))}}
+		quantized := make([]byte, tensor.Size())
+		quantizers[kind](quantized, x, weights)
+
+		y := make([]float32, len(x))
+		dequantizers[kind](y, quantized)
+
+		var sum float64
+		for i := range x {
+			sum += float64(qw[i]) * float64(x[i]-y[i]) * float64(x[i]-y[i])
+		}
+
+		return sum
+	}
+
+	for _, tt := range []struct {
+		name string
+		kind uint32
+	}{
+		{"Q4_0", tensorTypeQ4_0},
+		{"Q4_1", tensorTypeQ4_1},
+		{"Q5_0", tensorTypeQ5_0},
+		{"Q5_1", tensorTypeQ5_1},
+		{"Q2_K", tensorTypeQ2_K},
+		{"Q3_K", tensorTypeQ3_K},
+		{"Q4_K", tensorTypeQ4_K},
+		{"Q5_K", tensorTypeQ5_K},
+		{"Q6_K", tensorTypeQ6_K},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			unweighted, weighted := weightedError(tt.kind, nil), weightedError(tt.kind, qw)
+			if weighted >= unweighted {
+				t.Errorf("weighted error %f is not less than unweighted error %f", weighted, unweighted)
+			}
+		})
+	}
+}
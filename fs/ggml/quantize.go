@@ -0,0 +1,341 @@
+package ggml
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// quantizeBatchSize is the approximate number of elements of a tensor that
+// are held in memory at once while quantizing
+const quantizeBatchSize = 4 << 20
+
+// QuantizeOptions controls how the weights of a model are quantized
+type QuantizeOptions struct {
+	// Imatrix, if not nil, weights the rounding of each block by the
+	// importance of its columns
+	Imatrix *Imatrix
+
+	// Mixed keeps the attention and output weights at 8 bits
+	Mixed bool
+}
+
+// Quantize reads the GGUF model in r and writes it to ws with its weights
+// quantized to ft. Tensors are read, quantized and written one batch of rows
+// at a time so memory use doesn't depend on the size of the model. fn, if
+// not nil, is called after each tensor is written with the number of bytes
+// written so far and the expected total.
+func Quantize(ws io.WriteSeeker, r io.ReaderAt, ft fileType, opts QuantizeOptions, fn func(completed, total uint64)) error {
+	if _, ok := quantizers[fileTypeTensorType(ft)]; !ok {
+		return fmt.Errorf("unsupported quantization type %s", ft)
+	}
+
+	f, _, err := Decode(io.NewSectionReader(r, 0, math.MaxInt64), -1)
+	if err != nil {
+		return err
+	}
+
+	if f.Name() != "gguf" {
+		return ErrUnsupportedFormat
+	}
+
+	kv := maps.Clone(f.KV())
+	delete(kv, "general.parameter_count")
+	delete(kv, "general.alignment")
+	kv["general.file_type"] = ft.Value()
+	kv["general.quantization_version"] = uint32(2)
+
+	tensors := f.Tensors()
+
+	var completed, total uint64
+	ts := make([]Tensor, len(tensors.Items()))
+	for i, t := range tensors.Items() {
+		kind := quantizationType(t, ft, kv, opts.Mixed)
+		total += Tensor{Kind: kind, Shape: t.Shape}.Size()
+
+		src := io.NewSectionReader(r, int64(tensors.Offset+t.Offset), int64(t.Size()))
+		ts[i] = Tensor{
+			Name: t.Name,
+			Kind: kind,
+			// WriteGGUF expects the outermost dimension first
+			Shape: slices.Clone(t.Shape),
+			WriterTo: WriterFunc(func(w io.Writer) (n int64, err error) {
+				if kind == t.Kind {
+					n, err = io.Copy(w, src)
+				} else {
+					n, err = quantizeTensor(w, src, t, kind, opts.Imatrix.weights(t.Name, t.Shape[0]))
+				}
+
+				completed += uint64(n)
+				if fn != nil && err == nil {
+					fn(completed, total)
+				}
+
+				return n, err
+			}),
+		}
+		slices.Reverse(ts[i].Shape)
+	}
+
+	return WriteGGUF(ws, kv, ts)
+}
+
+// WriterFunc is an [io.WriterTo] that writes the data of a tensor with fn
+type WriterFunc func(io.Writer) (int64, error)
+
+func (fn WriterFunc) WriteTo(w io.Writer) (int64, error) {
+	return fn(w)
+}
+
+// quantizeTensor converts the data of t read from r to kind and writes it to w.
+// weights is the importance of each column of t, or nil.
+func quantizeTensor(w io.Writer, r io.Reader, t *Tensor, kind uint32, weights []float32) (int64, error) {
+	dequantize, ok := dequantizers[t.Kind]
+	if !ok {
+		return 0, fmt.Errorf("%s: unsupported tensor type %s", t.Name, t.Type())
+	}
+
+	quantize := quantizers[kind]
+
+	cols := t.Shape[0]
+	rows := t.parameters() / cols
+	srcRowSize := Tensor{Kind: t.Kind, Shape: []uint64{cols}}.Size()
+	dstRowSize := Tensor{Kind: kind, Shape: []uint64{cols}}.Size()
+
+	batch := max(quantizeBatchSize/cols, 1)
+	src := make([]byte, batch*srcRowSize)
+	x := make([]float32, batch*cols)
+	dst := make([]byte, batch*dstRowSize)
+
+	workers := uint64(runtime.GOMAXPROCS(0))
+
+	var written int64
+	for row := uint64(0); row < rows; row += batch {
+		n := min(batch, rows-row)
+		if _, err := io.ReadFull(r, src[:n*srcRowSize]); err != nil {
+			return written, err
+		}
+
+		// rows are independent so each worker takes a contiguous range of them
+		var wg sync.WaitGroup
+		step := (n + workers - 1) / workers
+		for i := uint64(0); i < n; i += step {
+			j := min(i+step, n)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dequantize(x[i*cols:j*cols], src[i*srcRowSize:j*srcRowSize])
+				if weights == nil {
+					quantize(dst[i*dstRowSize:j*dstRowSize], x[i*cols:j*cols], nil)
+					return
+				}
+
+				// the importance is per column so every row is quantized
+				// with the same weights
+				for k := i; k < j; k++ {
+					quantize(dst[k*dstRowSize:(k+1)*dstRowSize], x[k*cols:(k+1)*cols], weights)
+				}
+			}()
+		}
+		wg.Wait()
+
+		m, err := w.Write(dst[:n*dstRowSize])
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// fileTypeTensorType returns the tensor type used for most weights in a model
+// of file type ft
+func fileTypeTensorType(ft fileType) uint32 {
+	switch ft {
+	case fileTypeF32:
+		return tensorTypeF32
+	case fileTypeF16:
+		return tensorTypeF16
+	case fileTypeQ4_0:
+		return tensorTypeQ4_0
+	case fileTypeQ4_1:
+		return tensorTypeQ4_1
+	case fileTypeQ5_0:
+		return tensorTypeQ5_0
+	case fileTypeQ5_1:
+		return tensorTypeQ5_1
+	case fileTypeQ8_0:
+		return tensorTypeQ8_0
+	case fileTypeQ2_K:
+		return tensorTypeQ2_K
+	case fileTypeQ3_K_S, fileTypeQ3_K_M, fileTypeQ3_K_L:
+		return tensorTypeQ3_K
+	case fileTypeQ4_K_S, fileTypeQ4_K_M:
+		return tensorTypeQ4_K
+	case fileTypeQ5_K_S, fileTypeQ5_K_M:
+		return tensorTypeQ5_K
+	case fileTypeQ6_K:
+		return tensorTypeQ6_K
+	default:
+		return math.MaxUint32
+	}
+}
+
+// quantizationType returns the type of t in a model quantized to ft. It
+// follows llama.cpp in keeping small and sensitive tensors at a higher
+// precision. mixed additionally keeps attention and output weights at 8 bits.
+func quantizationType(t *Tensor, ft fileType, kv KV, mixed bool) uint32 {
+	if _, ok := dequantizers[t.Kind]; !ok || !shouldQuantize(t) {
+		return t.Kind
+	}
+
+	kind := fileTypeTensorType(ft)
+	if kind == tensorTypeF32 || kind == tensorTypeF16 {
+		return kind
+	}
+
+	layer, layers := t.block(), int(kv.BlockCount())
+	// useMoreBits selects the first and last eighth of the layers along with
+	// every third layer in between
+	useMoreBits := layer >= 0 && (layer < layers/8 || layer >= 7*layers/8 || (layer-layers/8)%3 == 2)
+
+	switch {
+	case mixed && isAttentionOrOutput(t.Name):
+		kind = tensorTypeQ8_0
+	case t.Name == "output.weight":
+		if kind != tensorTypeQ8_0 {
+			kind = tensorTypeQ6_K
+		}
+	case strings.HasSuffix(t.Name, "attn_v.weight"):
+		switch {
+		case ft == fileTypeQ2_K:
+			kind = tensorTypeQ3_K
+			if gqa(kv) >= 4 {
+				kind = tensorTypeQ4_K
+			}
+		case ft == fileTypeQ3_K_M:
+			kind = tensorTypeQ4_K
+			if layer < 2 {
+				kind = tensorTypeQ5_K
+			}
+		case ft == fileTypeQ3_K_L:
+			kind = tensorTypeQ5_K
+		case (ft == fileTypeQ4_K_M || ft == fileTypeQ5_K_M) && useMoreBits:
+			kind = tensorTypeQ6_K
+		case ft == fileTypeQ4_K_S && layer < 4:
+			kind = tensorTypeQ5_K
+		}
+	case strings.HasSuffix(t.Name, "ffn_down.weight"):
+		switch {
+		case ft == fileTypeQ2_K:
+			kind = tensorTypeQ3_K
+		case ft == fileTypeQ3_K_M:
+			kind = tensorTypeQ4_K
+			if layer < layers/16 {
+				kind = tensorTypeQ5_K
+			}
+		case ft == fileTypeQ3_K_L:
+			kind = tensorTypeQ5_K
+		case (ft == fileTypeQ4_K_M || ft == fileTypeQ5_K_M) && useMoreBits:
+			kind = tensorTypeQ6_K
+		case ft == fileTypeQ4_K_S && layer < layers/8:
+			kind = tensorTypeQ5_K
+		}
+	case strings.HasSuffix(t.Name, "attn_output.weight"):
+		switch ft {
+		case fileTypeQ2_K:
+			kind = tensorTypeQ3_K
+		case fileTypeQ3_K_M:
+			kind = tensorTypeQ4_K
+		case fileTypeQ3_K_L:
+			kind = tensorTypeQ5_K
+		}
+	case strings.HasSuffix(t.Name, "attn_qkv.weight"):
+		switch ft {
+		case fileTypeQ3_K_M, fileTypeQ3_K_L:
+			kind = tensorTypeQ4_K
+		case fileTypeQ4_K_M:
+			kind = tensorTypeQ5_K
+		case fileTypeQ5_K_M:
+			kind = tensorTypeQ6_K
+		}
+	}
+
+	// rows that don't divide into blocks fall back to a similar type with
+	// smaller blocks
+	if t.Shape[0]%(Tensor{Kind: kind}).blockSize() != 0 {
+		switch kind {
+		case tensorTypeQ2_K, tensorTypeQ3_K:
+			kind = tensorTypeQ4_0
+		case tensorTypeQ4_K:
+			kind = tensorTypeQ5_0
+		case tensorTypeQ5_K:
+			kind = tensorTypeQ5_1
+		case tensorTypeQ6_K:
+			kind = tensorTypeQ8_0
+		}
+
+		if t.Shape[0]%(Tensor{Kind: kind}).blockSize() != 0 {
+			kind = tensorTypeF16
+		}
+	}
+
+	return kind
+}
+
+// shouldQuantize reports whether t is a weight matrix of the text model.
+// Norms, biases, embeddings of positions and tensors of the vision and audio
+// encoders are kept as they are.
+func shouldQuantize(t *Tensor) bool {
+	if len(t.Shape) < 2 || !strings.HasSuffix(t.Name, ".weight") {
+		return false
+	}
+
+	for _, prefix := range []string{"v.", "a.", "mm.", "position_embd.", "token_types."} {
+		if strings.HasPrefix(t.Name, prefix) {
+			return false
+		}
+	}
+
+	for _, s := range []string{"_norm.weight", "ffn_gate_inp.weight", "ssm_conv1d.weight"} {
+		if strings.HasSuffix(t.Name, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isAttentionOrOutput reports whether name is an attention projection or the
+// output projection of a model
+func isAttentionOrOutput(name string) bool {
+	if name == "output.weight" {
+		return true
+	}
+
+	for _, s := range []string{"attn_q.weight", "attn_k.weight", "attn_v.weight", "attn_qkv.weight", "attn_output.weight"} {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// gqa returns the number of query heads per key/value head, or 1 if it isn't
+// known
+func gqa(kv KV) uint32 {
+	heads, _ := kv[kv.Architecture()+".attention.head_count"].(uint32)
+	headsKV, _ := kv[kv.Architecture()+".attention.head_count_kv"].(uint32)
+	if heads == 0 || headsKV == 0 {
+		return 1
+	}
+
+	return heads / headsKV
+}
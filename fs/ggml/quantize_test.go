@@ -0,0 +1,216 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQuantize(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	f32 := func(shape ...uint64) ([]float32, *bytes.Reader) {
+		n := uint64(1)
+		for _, d := range shape {
+			n *= d
+		}
+
+		x := make([]float32, n)
+		for i := range x {
+			x[i] = float32(r.NormFloat64())
+		}
+
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, x); err != nil {
+			t.Fatal(err)
+		}
+
+		return x, bytes.NewReader(b.Bytes())
+	}
+
+	embd, embdData := f32(32, 512)
+	down, downData := f32(512, 256)
+	_, normData := f32(512)
+	_, qData := f32(512, 96)
+	_, outputData := f32(32, 512)
+
+	p := filepath.Join(t.TempDir(), "model.gguf")
+	in, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	if err := WriteGGUF(in, KV{
+		"general.architecture":   "llama",
+		"general.file_type":      uint32(0),
+		"llama.block_count":      uint32(1),
+		"llama.rope.freq_base":   float32(10000),
+		"llama.vocab_only":       false,
+		"tokenizer.ggml.tokens":  []string{"a", "b"},
+		"tokenizer.ggml.scores":  []float32{0, 1},
+		"tokenizer.ggml.bos_ids": []int32{1, 2},
+	}, []Tensor{
+		{Name: "token_embd.weight", Kind: tensorTypeF32, Shape: []uint64{32, 512}, WriterTo: embdData},
+		{Name: "blk.0.attn_norm.weight", Kind: tensorTypeF32, Shape: []uint64{512}, WriterTo: normData},
+		{Name: "blk.0.attn_q.weight", Kind: tensorTypeF32, Shape: []uint64{512, 96}, WriterTo: qData},
+		{Name: "blk.0.ffn_down.weight", Kind: tensorTypeF32, Shape: []uint64{512, 256}, WriterTo: downData},
+		{Name: "output.weight", Kind: tensorTypeF32, Shape: []uint64{32, 512}, WriterTo: outputData},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "quantized.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	var calls int
+	var completed, total uint64
+	if err := Quantize(out, in, fileTypeQ4_K_M, QuantizeOptions{}, func(c, t uint64) {
+		calls++
+		completed, total = c, t
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 5 || completed != total {
+		t.Errorf("progress: calls = %d, completed = %d, total = %d", calls, completed, total)
+	}
+
+	if _, err := out.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _, err := Decode(out, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ft := f.KV().FileType(); ft != fileTypeQ4_K_M {
+		t.Errorf("file type = %s, want Q4_K_M", ft)
+	}
+
+	if diff := cmp.Diff([]string{"a", "b"}, f.KV().Strings("tokenizer.ggml.tokens")); diff != "" {
+		t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+	}
+
+	if v := f.KV().Float("rope.freq_base"); v != 10000 {
+		t.Errorf("rope.freq_base = %v, want 10000", v)
+	}
+
+	kinds := make(map[string]uint32)
+	for _, tensor := range f.Tensors().Items() {
+		kinds[tensor.Name] = tensor.Kind
+	}
+
+	if diff := cmp.Diff(map[string]uint32{
+		"token_embd.weight":      tensorTypeQ4_K,
+		"blk.0.attn_norm.weight": tensorTypeF32,
+		// rows of 96 don't divide into blocks of 256
+		"blk.0.attn_q.weight":   tensorTypeQ5_0,
+		"blk.0.ffn_down.weight": tensorTypeQ6_K,
+		"output.weight":         tensorTypeQ6_K,
+	}, kinds); diff != "" {
+		t.Errorf("tensor types mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, tt := range []struct {
+		name string
+		x    []float32
+	}{
+		{"token_embd.weight", embd},
+		{"blk.0.ffn_down.weight", down},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := f.Tensors().Items(tt.name)[0]
+			b := make([]byte, tensor.Size())
+			if _, err := out.ReadAt(b, int64(f.Tensors().Offset+tensor.Offset)); err != nil {
+				t.Fatal(err)
+			}
+
+			y := make([]float32, len(tt.x))
+			dequantizers[tensor.Kind](y, b)
+
+			var sumErr, sumX float64
+			for i := range tt.x {
+				sumErr += float64(tt.x[i]-y[i]) * float64(tt.x[i]-y[i])
+				sumX += float64(tt.x[i]) * float64(tt.x[i])
+			}
+
+			if sumErr/sumX > 0.01 {
+				t.Errorf("relative squared error %f", sumErr/sumX)
+			}
+		})
+	}
+}
+
+func TestImatrix(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "imatrix.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	want := Imatrix{
+		Chunks:  3,
+		Dataset: "calibration.txt",
+		Values: map[string][]float32{
+			"blk.0.attn_q.weight":   {1, 2, 3, 4},
+			"blk.0.ffn_down.weight": {0, 0.5, 0, 1.5},
+		},
+	}
+
+	if err := WriteImatrix(f, want); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadImatrix(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&want, got); diff != "" {
+		t.Errorf("imatrix mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]float32{1, 0.5, 1, 1.5}, got.weights("blk.0.ffn_down.weight", 4)); diff != "" {
+		t.Errorf("weights mismatch (-want +got):\n%s", diff)
+	}
+
+	if w := got.weights("blk.0.attn_q.weight", 8); w != nil {
+		t.Errorf("weights for the wrong number of columns = %v, want nil", w)
+	}
+}
+
+func TestQuantizationTypeMixed(t *testing.T) {
+	kv := KV{"general.architecture": "llama", "llama.block_count": uint32(32)}
+	for _, tt := range []struct {
+		name        string
+		plain, want uint32
+	}{
+		{"blk.10.attn_q.weight", tensorTypeQ4_K, tensorTypeQ8_0},
+		{"blk.10.attn_output.weight", tensorTypeQ4_K, tensorTypeQ8_0},
+		{"blk.10.ffn_up.weight", tensorTypeQ4_K, tensorTypeQ4_K},
+		{"output.weight", tensorTypeQ6_K, tensorTypeQ8_0},
+		{"token_embd.weight", tensorTypeQ4_K, tensorTypeQ4_K},
+	} {
+		tensor := Tensor{Name: tt.name, Kind: tensorTypeF16, Shape: []uint64{4096, 4096}}
+		if got := quantizationType(&tensor, fileTypeQ4_K_M, kv, false); got != tt.plain {
+			t.Errorf("%s: type = %d, want %d", tt.name, got, tt.plain)
+		}
+
+		if got := quantizationType(&tensor, fileTypeQ4_K_M, kv, true); got != tt.want {
+			t.Errorf("%s: mixed type = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -449,6 +449,10 @@ func (t *testTensor) AvgPool2D(ctx ml.Context, k, s int, p float32) ml.Tensor {
 	panic("not implemented")
 }
 
+func (t *testTensor) Conv1D(ctx ml.Context, t2 ml.Tensor, s0, p0, d0 int) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) Conv2D(ctx ml.Context, weight ml.Tensor, s0, s1, p0, p1, d0, d1 int) ml.Tensor {
 	panic("not implemented")
 }
@@ -457,6 +461,10 @@ func (t *testTensor) RoPE(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, di
 	panic("not implemented")
 }
 
+func (t *testTensor) RoPEMulti(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, dim uint32, sections [4]int, ropeType uint32, base, scale float32) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) Tanh(ctx ml.Context) ml.Tensor {
 	panic("not implemented")
 }
@@ -465,6 +473,10 @@ func (t *testTensor) GELU(ctx ml.Context) ml.Tensor {
 	panic("not implemented")
 }
 
+func (t *testTensor) QuickGELU(ctx ml.Context) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) SILU(ctx ml.Context) ml.Tensor {
 	panic("not implemented")
 }
@@ -173,8 +173,14 @@ func (c *Context) GetEmbeddingsSeq(seqId int) []float32 {
 		return nil
 	}
 
-	embeddings := make([]float32, c.Model().NEmbd())
-	_ = copy(embeddings, unsafe.Slice((*float32)(e), c.Model().NEmbd()))
+	n := c.Model().NEmbd()
+	if C.llama_pooling_type(c.c) == C.LLAMA_POOLING_TYPE_RANK {
+		// reranking models produce a single score per sequence
+		n = 1
+	}
+
+	embeddings := make([]float32, n)
+	_ = copy(embeddings, unsafe.Slice((*float32)(e), n))
 	return embeddings
 }
 
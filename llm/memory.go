@@ -20,7 +20,7 @@ func PredictServerFit(allGpus discover.GpuInfoList, f *ggml.GGML, adapters, proj
 	var estimatedVRAM uint64
 	for _, gpus := range allGpus.ByLibrary() {
 		var layerCount int
-		estimate := EstimateGPULayers(gpus, f, projectors, opts)
+		estimate := EstimateGPULayers(gpus, f, adapters, projectors, opts)
 		layerCount, estimatedVRAM = estimate.Layers, estimate.VRAMSize
 		if opts.NumGPU < 0 {
 			if layerCount > 0 && layerCount >= int(f.KV().BlockCount()+1) {
@@ -71,7 +71,7 @@ type MemoryEstimate struct {
 
 // Given a model and one or more GPU targets, predict how many layers and bytes we can load, and the total size
 // The GPUs provided must all be the same Library
-func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []string, opts api.Options) MemoryEstimate {
+func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, adapters, projectors []string, opts api.Options) MemoryEstimate {
 	// Graph size for a partial offload, applies to all GPUs
 	var graphPartialOffload uint64
 
@@ -119,7 +119,15 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		projectorWeights, projectorGraph = f.VisionGraphSize()
 	}
 
+	// the audio encoder runs separately from any vision encoder
+	if audioWeights, audioGraph := f.AudioGraphSize(); audioWeights > 0 {
+		projectorWeights += audioWeights
+		projectorGraph = max(projectorGraph, audioGraph)
+	}
+
 	layers := f.Tensors().GroupLayers()
+	addAdapterLayers(layers, adapters)
+
 	// add one layer worth of memory as a buffer
 	if blk0, ok := layers["blk.0"]; ok {
 		layerSize = blk0.Size()
@@ -401,6 +409,33 @@ func (m MemoryEstimate) LogValue() slog.Value {
 	return slog.GroupValue(attrs...)
 }
 
+// addAdapterLayers adds the tensors of the LoRA adapters to the layers of the
+// weights they update, since they are loaded next to them
+func addAdapterLayers(layers map[string]ggml.Layer, adapters []string) {
+	for _, adapter := range adapters {
+		file, err := os.Open(adapter)
+		if err != nil {
+			continue
+		}
+
+		a, _, err := ggml.Decode(file, 0)
+		file.Close()
+		if err != nil {
+			continue
+		}
+
+		for name, layer := range a.Tensors().GroupLayers() {
+			if _, ok := layers[name]; !ok {
+				layers[name] = make(ggml.Layer)
+			}
+
+			for k, t := range layer {
+				layers[name][adapter+":"+k] = t
+			}
+		}
+	}
+}
+
 func projectorMemoryRequirements(filename string) (weights, graphSize uint64) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -38,12 +38,18 @@ type LlamaServer interface {
 	WaitUntilRunning(ctx context.Context) error
 	Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error
 	Embedding(ctx context.Context, input string) ([]float32, error)
+	EmbeddingTokens(ctx context.Context, tokens []int) ([]float32, error)
+	Imatrix(ctx context.Context, req ImatrixRequest) (*ImatrixResponse, error)
 	Tokenize(ctx context.Context, content string) ([]int, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
 	Close() error
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
 	EstimatedVRAMByGPU(gpuID string) uint64
+
+	// SwapsAdapters reports whether the server applies the adapter of each
+	// CompletionRequest instead of the adapters it was started with
+	SwapsAdapters() bool
 }
 
 // llmServer is an instance of the llama.cpp server
@@ -109,7 +115,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		gpus = discover.GetCPUInfo()
 	}
 
-	estimate := EstimateGPULayers(gpus, f, projectors, opts)
+	estimate := EstimateGPULayers(gpus, f, adapters, projectors, opts)
 	if len(gpus) > 1 || gpus[0].Library != "cpu" {
 		switch {
 		case gpus[0].Library == "metal" && estimate.VRAMSize > systemTotalMemory:
@@ -673,6 +679,10 @@ type CompletionRequest struct {
 	Options *api.Options
 
 	Grammar string // set before sending the request to the subprocess
+
+	// Adapter is the path of the LoRA adapter to apply to this request. It is
+	// only supported by the Ollama engine.
+	Adapter string
 }
 
 type CompletionResponse struct {
@@ -843,6 +853,11 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 
 type EmbeddingRequest struct {
 	Content string `json:"content"`
+
+	// Tokens, if set, is the input instead of Content. It is used as is,
+	// without special tokens added, for inputs made of tokens, such as the
+	// query and document pairs of rerankers.
+	Tokens []int `json:"tokens,omitempty"`
 }
 
 type EmbeddingResponse struct {
@@ -850,6 +865,16 @@ type EmbeddingResponse struct {
 }
 
 func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, error) {
+	return s.embedding(ctx, EmbeddingRequest{Content: input})
+}
+
+// EmbeddingTokens returns the embedding of tokens, which are not tokenized
+// again, so special tokens in them are kept as they are and none are added.
+func (s *llmServer) EmbeddingTokens(ctx context.Context, tokens []int) ([]float32, error) {
+	return s.embedding(ctx, EmbeddingRequest{Tokens: tokens})
+}
+
+func (s *llmServer) embedding(ctx context.Context, req EmbeddingRequest) ([]float32, error) {
 	if err := s.sem.Acquire(ctx, 1); err != nil {
 		if errors.Is(err, context.Canceled) {
 			slog.Info("aborting embedding request due to client closing the connection")
@@ -868,7 +893,7 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("unexpected server status: %s", status)
 	}
 
-	data, err := json.Marshal(EmbeddingRequest{Content: input})
+	data, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling embed data: %w", err)
 	}
@@ -911,6 +936,66 @@ type TokenizeResponse struct {
 	Tokens []int `json:"tokens"`
 }
 
+type ImatrixRequest struct {
+	Content string `json:"content"`
+
+	// ChunkSize is the number of tokens evaluated at a time, each chunk
+	// starting from an empty cache
+	ChunkSize int `json:"chunk_size,omitempty"`
+}
+
+type ImatrixResponse struct {
+	Chunks int                  `json:"chunks"`
+	Values map[string][]float32 `json:"values"`
+}
+
+// Imatrix computes an importance matrix for the model by running the
+// calibration text in req through it
+func (s *llmServer) Imatrix(ctx context.Context, req ImatrixRequest) (*ImatrixResponse, error) {
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer s.sem.Release(1)
+
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return nil, err
+	} else if status != ServerStatusReady {
+		return nil, fmt.Errorf("unexpected server status: %s", status)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling imatrix data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/imatrix", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creating imatrix request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("do imatrix request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.New("importance matrices require the Ollama engine")
+	} else if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+
+	var imatrix ImatrixResponse
+	if err := json.NewDecoder(resp.Body).Decode(&imatrix); err != nil {
+		return nil, fmt.Errorf("unmarshal imatrix response: %w", err)
+	}
+
+	return &imatrix, nil
+}
+
 func (s *llmServer) Tokenize(ctx context.Context, content string) ([]int, error) {
 	s.llamaModelLock.Lock()
 	defer s.llamaModelLock.Unlock()
@@ -1000,6 +1085,11 @@ func (s *llmServer) EstimatedTotal() uint64 {
 	return s.estimate.TotalSize
 }
 
+func (s *llmServer) SwapsAdapters() bool {
+	// only the Ollama engine can apply adapters per request
+	return s.textProcessor != nil
+}
+
 func (s *llmServer) EstimatedVRAMByGPU(gpuID string) uint64 {
 	for i, gpu := range s.gpus {
 		if gpu.ID == gpuID {
@@ -94,6 +94,32 @@ func NewBackend(f *os.File, params BackendParams) (Backend, error) {
 	return nil, fmt.Errorf("unsupported backend")
 }
 
+// ImatrixCollector is implemented by backends that can record the inputs to
+// the weights of a model as it runs in order to compute an importance matrix
+// for quantization
+type ImatrixCollector interface {
+	// CollectImatrix starts or stops recording the inputs to weights
+	CollectImatrix(enabled bool)
+
+	// Imatrix returns the mean of the squares of the inputs to each column of
+	// each weight recorded since collection was started
+	Imatrix() map[string][]float32
+}
+
+// AdapterLoader is implemented by backends that can apply LoRA adapters to
+// the weights of a model at runtime, with a different adapter for each input
+// of a batch
+type AdapterLoader interface {
+	// LoadAdapter loads the adapter at path and returns its id, which is
+	// always greater than 0
+	LoadAdapter(path string) (int, error)
+
+	// SetAdapters selects the adapter applied to each input of the batch
+	// computed in ctx. An id of 0 applies no adapter. outputs are the
+	// indices of the inputs with outputs, as in input.Options.
+	SetAdapters(ctx Context, adapters []int, outputs []int32) error
+}
+
 type Context interface {
 	Empty(dtype DType, shape ...int) Tensor
 	Zeros(dtype DType, shape ...int) Tensor
@@ -136,12 +162,15 @@ type Tensor interface {
 	Scale(ctx Context, s float64) Tensor
 
 	AvgPool2D(ctx Context, k, s int, p float32) Tensor
+	Conv1D(ctx Context, t2 Tensor, s0, p0, d0 int) Tensor
 	Conv2D(ctx Context, weight Tensor, s0, s1, p0, p1, d0, d1 int) Tensor
 
 	RoPE(ctx Context, positionIDs, ropeFactors Tensor, dim, ropeType uint32, base, scale float32) Tensor
+	RoPEMulti(ctx Context, positionIDs, ropeFactors Tensor, dim uint32, sections [4]int, ropeType uint32, base, scale float32) Tensor
 
 	Tanh(ctx Context) Tensor
 	GELU(ctx Context) Tensor
+	QuickGELU(ctx Context) Tensor
 	SILU(ctx Context) Tensor
 
 	Reshape(ctx Context, shape ...int) Tensor
@@ -43,6 +43,12 @@ type Backend struct {
 	sched   *C.struct_ggml_backend_sched
 	tensors map[string]*C.struct_ggml_tensor
 
+	// schedBackends, schedBufts and schedParallel are used to create the
+	// scheduler again when the maximum number of graph nodes changes
+	schedBackends []*C.struct_ggml_backend
+	schedBufts    []*C.struct_ggml_backend_buffer_type
+	schedParallel bool
+
 	// input is the backend used for inputs
 	input *C.struct_ggml_backend_buffer_type
 
@@ -56,6 +62,12 @@ type Backend struct {
 
 	// maxGraphNodes is the maximum allowed number of graph nodes in this scheduler
 	maxGraphNodes int
+
+	// imatrix records the inputs to the weights if not nil
+	imatrix *imatrix
+
+	// lora holds the adapters that can be applied to the weights per input
+	lora *loras
 }
 
 func New(r *os.File, params ml.BackendParams) (ml.Backend, error) {
@@ -363,20 +375,15 @@ func New(r *os.File, params ml.BackendParams) (ml.Backend, error) {
 		}
 	}
 
-	maxGraphNodes := max(8192, len(meta.Tensors().Items())*5)
-	return &Backend{
+	b := &Backend{
 		flashAttention: params.FlashAttention,
 		meta:           meta,
 		tensors:        tensors,
-		sched: C.ggml_backend_sched_new(
-			(*C.ggml_backend_t)(unsafe.Pointer(&schedBackends[0])),
-			(*C.ggml_backend_buffer_type_t)(unsafe.Pointer(&schedBufts[0])),
-			C.int(len(schedBackends)),
-			C.size_t(maxGraphNodes),
-			C._Bool(len(gpus) > 1 && slices.Contains(gpus, output.d)),
-		),
-		input:  deviceBufferTypes[input.d],
-		output: deviceBufferTypes[output.d],
+		schedBackends:  schedBackends,
+		schedBufts:     schedBufts,
+		schedParallel:  len(gpus) > 1 && slices.Contains(gpus, output.d),
+		input:          deviceBufferTypes[input.d],
+		output:         deviceBufferTypes[output.d],
 		layers: func() map[int]*C.struct_ggml_backend_buffer_type {
 			m := make(map[int]*C.struct_ggml_backend_buffer_type)
 			for i, layer := range layers {
@@ -384,8 +391,33 @@ func New(r *os.File, params ml.BackendParams) (ml.Backend, error) {
 			}
 			return m
 		}(),
-		maxGraphNodes: maxGraphNodes,
-	}, nil
+	}
+
+	b.setMaxGraphNodes(b.graphNodes())
+	return b, nil
+}
+
+// graphNodes is the number of graph nodes reserved for the forward pass of
+// the model
+func (b *Backend) graphNodes() int {
+	return max(8192, len(b.meta.Tensors().Items())*5)
+}
+
+// setMaxGraphNodes creates the scheduler for graphs of up to n nodes. It must
+// not be called while a graph is being computed.
+func (b *Backend) setMaxGraphNodes(n int) {
+	if b.sched != nil {
+		C.ggml_backend_sched_free(b.sched)
+	}
+
+	b.sched = C.ggml_backend_sched_new(
+		(*C.ggml_backend_t)(unsafe.Pointer(&b.schedBackends[0])),
+		(*C.ggml_backend_buffer_type_t)(unsafe.Pointer(&b.schedBufts[0])),
+		C.int(len(b.schedBackends)),
+		C.size_t(n),
+		C._Bool(b.schedParallel),
+	)
+	b.maxGraphNodes = n
 }
 
 func init() {
@@ -496,9 +528,13 @@ func (c *Context) Forward(tensors ...ml.Tensor) ml.Context {
 }
 
 func (c Context) Compute(tensors ...ml.Tensor) {
+	c.b.expandActivations(c)
+
 	C.ggml_backend_sched_graph_compute_async(c.b.sched, c.graph)
 	C.ggml_backend_sched_reset(c.b.sched)
 
+	c.b.accumulateActivations(c)
+
 	needSync := true
 	sync := func() {
 		if needSync {
@@ -628,6 +664,8 @@ func (c Context) FromIntSlice(s []int32, shape ...int) (ml.Tensor, error) {
 
 func (c *Context) Close() {
 	if c != nil {
+		c.b.discardActivations(c)
+		c.b.discardAdapters(c)
 		C.ggml_free(c.ctx)
 	}
 }
@@ -739,10 +777,11 @@ func (t *Tensor) Mul(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 }
 
 func (t *Tensor) Mulmat(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
-	return &Tensor{
+	t.b.recordActivation(ctx.(*Context), t, t2.(*Tensor))
+	return t.b.applyAdapters(ctx.(*Context), t, t2.(*Tensor), &Tensor{
 		b: t.b,
 		t: C.ggml_mul_mat(ctx.(*Context).ctx, t.t, t2.(*Tensor).t),
-	}
+	})
 }
 
 func (t *Tensor) MulmatFullPrec(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
@@ -756,7 +795,11 @@ func (t *Tensor) MulmatFullPrec(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 }
 
 func (t *Tensor) LayerNorm(ctx ml.Context, w, b ml.Tensor, eps float32) ml.Tensor {
-	tt := (&Tensor{b: t.b, t: C.ggml_norm(ctx.(*Context).ctx, t.t, C.float(eps))}).Mul(ctx, w)
+	var tt ml.Tensor = &Tensor{b: t.b, t: C.ggml_norm(ctx.(*Context).ctx, t.t, C.float(eps))}
+	if w != nil {
+		tt = tt.Mul(ctx, w)
+	}
+
 	if b != nil {
 		tt = tt.Add(ctx, b)
 	}
@@ -765,7 +808,12 @@ func (t *Tensor) LayerNorm(ctx ml.Context, w, b ml.Tensor, eps float32) ml.Tenso
 }
 
 func (t *Tensor) RMSNorm(ctx ml.Context, w ml.Tensor, eps float32) ml.Tensor {
-	return (&Tensor{b: t.b, t: C.ggml_rms_norm(ctx.(*Context).ctx, t.t, C.float(eps))}).Mul(ctx, w)
+	var tt ml.Tensor = &Tensor{b: t.b, t: C.ggml_rms_norm(ctx.(*Context).ctx, t.t, C.float(eps))}
+	if w != nil {
+		tt = tt.Mul(ctx, w)
+	}
+
+	return tt
 }
 
 func (t *Tensor) Pad(ctx ml.Context, shape ...int) ml.Tensor {
@@ -933,6 +981,39 @@ func (t *Tensor) RoPE(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, ropeDi
 	}
 }
 
+// RoPEMulti applies rotary embeddings where the rotated dimensions are split
+// into up to four sections, each driven by its own set of positions. positionIDs
+// must contain 4*n positions laid out section by section.
+func (t *Tensor) RoPEMulti(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, ropeDim uint32, sections [4]int, ropeType uint32, ropeBase, ropeScale float32) ml.Tensor {
+	if ropeFactors == nil {
+		ropeFactors = &Tensor{b: t.b}
+	}
+
+	dequant := t.t
+	if C.ggml_is_quantized(t.t._type) {
+		dequant = C.ggml_cast(ctx.(*Context).ctx, t.t, C.GGML_TYPE_F32)
+	}
+
+	cSections := [4]C.int{C.int(sections[0]), C.int(sections[1]), C.int(sections[2]), C.int(sections[3])}
+
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_rope_multi(
+			ctx.(*Context).ctx, dequant, positionIDs.(*Tensor).t, ropeFactors.(*Tensor).t,
+			C.int(ropeDim),
+			&cSections[0],
+			C.int(ropeType),
+			131072, // YaRN n_ctx_train
+			C.float(ropeBase),
+			C.float(ropeScale),
+			0.,  // YaRN ext_factor
+			1.,  // YaRN attn_factor
+			32., // YaRN beta_fast
+			1.,  // YaRN beta_slow
+		),
+	}
+}
+
 func (t *Tensor) GELU(ctx ml.Context) ml.Tensor {
 	return &Tensor{
 		b: t.b,
@@ -940,6 +1021,13 @@ func (t *Tensor) GELU(ctx ml.Context) ml.Tensor {
 	}
 }
 
+func (t *Tensor) QuickGELU(ctx ml.Context) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_gelu_quick_inplace(ctx.(*Context).ctx, t.t),
+	}
+}
+
 func (t *Tensor) SILU(ctx ml.Context) ml.Tensor {
 	return &Tensor{
 		b: t.b,
@@ -947,6 +1035,13 @@ func (t *Tensor) SILU(ctx ml.Context) ml.Tensor {
 	}
 }
 
+func (t *Tensor) Conv1D(ctx ml.Context, t2 ml.Tensor, s0, p0, d0 int) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_conv_1d(ctx.(*Context).ctx, t.t, t2.(*Tensor).t, C.int(s0), C.int(p0), C.int(d0)),
+	}
+}
+
 func (t *Tensor) Conv2D(ctx ml.Context, t2 ml.Tensor, s0, s1, p0, p1, d0, d1 int) ml.Tensor {
 	return &Tensor{
 		b: t.b,
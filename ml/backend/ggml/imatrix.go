@@ -0,0 +1,160 @@
+package ggml
+
+// #cgo CPPFLAGS: -I${SRCDIR}/ggml/include
+// #include "ggml.h"
+// #include "ggml-backend.h"
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// imatrix accumulates the squares of the inputs to the weights of the model
+type imatrix struct {
+	mu sync.Mutex
+
+	sums   map[string][]float32
+	counts map[string]int
+
+	// pending holds the sums added to the graph of each context that haven't
+	// been read back yet
+	pending map[*C.struct_ggml_context][]activation
+}
+
+// activation is the sum over a batch of the squared inputs to a weight
+type activation struct {
+	name   string
+	sum    *C.struct_ggml_tensor
+	tokens int
+}
+
+// activationNodes is the most graph nodes recordActivation adds for a weight
+const activationNodes = 6
+
+// CollectImatrix starts or stops recording the inputs to the weights. While
+// recording, the scheduler reserves room for the nodes added by
+// recordActivation for each weight used once in a forward pass.
+func (b *Backend) CollectImatrix(enabled bool) {
+	if !enabled && b.imatrix != nil {
+		b.imatrix = nil
+		b.setMaxGraphNodes(b.graphNodes())
+	} else if enabled && b.imatrix == nil {
+		b.imatrix = &imatrix{
+			sums:    make(map[string][]float32),
+			counts:  make(map[string]int),
+			pending: make(map[*C.struct_ggml_context][]activation),
+		}
+		b.setMaxGraphNodes(b.graphNodes() + len(b.tensors)*activationNodes)
+	}
+}
+
+func (b *Backend) Imatrix() map[string][]float32 {
+	if b.imatrix == nil {
+		return nil
+	}
+
+	b.imatrix.mu.Lock()
+	defer b.imatrix.mu.Unlock()
+
+	m := make(map[string][]float32, len(b.imatrix.sums))
+	for name, sums := range b.imatrix.sums {
+		means := make([]float32, len(sums))
+		for i, sum := range sums {
+			means[i] = sum / float32(b.imatrix.counts[name])
+		}
+
+		m[name] = means
+	}
+
+	return m
+}
+
+// recordActivation adds the sum of the squares of x, the input to the model
+// weight w, to the graph of c so it can be accumulated once computed
+func (b *Backend) recordActivation(c *Context, w, x *Tensor) {
+	if b.imatrix == nil || x.t._type != C.GGML_TYPE_F32 || C.ggml_n_dims(w.t) != 2 || w.t.ne[0] != x.t.ne[0] {
+		return
+	}
+
+	name := C.GoString(C.ggml_get_name(w.t))
+	if b.tensors[name] != w.t {
+		// not a weight of the model, e.g. keys in attention
+		return
+	}
+
+	t := x.t
+	if !C.ggml_is_contiguous(t) {
+		t = C.ggml_cont(c.ctx, t)
+	}
+
+	tokens := int(C.ggml_nelements(t) / t.ne[0])
+	t = C.ggml_reshape_2d(c.ctx, t, t.ne[0], C.int64_t(tokens))
+	t = C.ggml_sqr(c.ctx, t)
+	t = C.ggml_cont(c.ctx, C.ggml_transpose(c.ctx, t))
+	t = C.ggml_sum_rows(c.ctx, t)
+	C.ggml_set_output(t)
+
+	b.imatrix.mu.Lock()
+	defer b.imatrix.mu.Unlock()
+	b.imatrix.pending[c.ctx] = append(b.imatrix.pending[c.ctx], activation{name: name, sum: t, tokens: tokens})
+}
+
+// expandActivations adds the pending sums of c to its graph
+func (b *Backend) expandActivations(c Context) {
+	if b.imatrix == nil {
+		return
+	}
+
+	b.imatrix.mu.Lock()
+	defer b.imatrix.mu.Unlock()
+	for _, a := range b.imatrix.pending[c.ctx] {
+		C.ggml_build_forward_expand(c.graph, a.sum)
+	}
+}
+
+// accumulateActivations reads back the computed sums of c
+func (b *Backend) accumulateActivations(c Context) {
+	if b.imatrix == nil {
+		return
+	}
+
+	b.imatrix.mu.Lock()
+	defer b.imatrix.mu.Unlock()
+
+	pending := b.imatrix.pending[c.ctx]
+	delete(b.imatrix.pending, c.ctx)
+	if len(pending) == 0 {
+		return
+	}
+
+	C.ggml_backend_sched_synchronize(b.sched)
+	for _, a := range pending {
+		values := make([]float32, C.ggml_nelements(a.sum))
+		C.ggml_backend_tensor_get(a.sum, unsafe.Pointer(&values[0]), 0, C.ggml_nbytes(a.sum))
+
+		sums, ok := b.imatrix.sums[a.name]
+		if !ok {
+			sums = make([]float32, len(values))
+			b.imatrix.sums[a.name] = sums
+		}
+
+		for i, v := range values {
+			sums[i] += v
+		}
+
+		b.imatrix.counts[a.name] += a.tokens
+	}
+}
+
+// discardActivations forgets the pending sums of a context that is closed
+// without being computed
+func (b *Backend) discardActivations(c *Context) {
+	if b.imatrix == nil {
+		return
+	}
+
+	b.imatrix.mu.Lock()
+	defer b.imatrix.mu.Unlock()
+	delete(b.imatrix.pending, c.ctx)
+}
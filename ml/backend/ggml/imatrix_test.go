@@ -0,0 +1,63 @@
+package ggml
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+)
+
+func TestImatrix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	writeGGUF(t, path, fs.KV{"general.architecture": "test"},
+		map[string][]float32{"output.weight": {1, 0, 0, 0, 0, 1, 0, 0}},
+		map[string][]uint64{"output.weight": {2, 4}})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	backend, err := New(f, ml.BackendParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := backend.(*Backend)
+
+	// the recorded activations need room in the graph besides the model
+	nodes := b.maxGraphNodes
+	b.CollectImatrix(true)
+	if want := nodes + activationNodes; b.maxGraphNodes != want {
+		t.Fatalf("max graph nodes = %d while collecting; want %d", b.maxGraphNodes, want)
+	}
+
+	ctx := b.NewContext()
+	defer ctx.Close()
+
+	x, err := ctx.Input().FromFloatSlice([]float32{
+		1, 2, 0, 0,
+		3, 4, 1, 0,
+	}, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := b.Get("output.weight").Mulmat(ctx, x)
+	ctx.Forward(out).Compute(out)
+
+	if got, want := b.Imatrix()["output.weight"], []float32{5, 10, 0.5, 0}; !slices.Equal(got, want) {
+		t.Errorf("imatrix = %v; want %v", got, want)
+	}
+
+	b.CollectImatrix(false)
+	if b.maxGraphNodes != nodes {
+		t.Errorf("max graph nodes = %d after collecting; want %d", b.maxGraphNodes, nodes)
+	}
+	if b.Imatrix() != nil {
+		t.Error("expected no imatrix after collecting")
+	}
+}
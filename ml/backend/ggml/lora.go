@@ -0,0 +1,327 @@
+package ggml
+
+// #cgo CPPFLAGS: -I${SRCDIR}/ggml/include
+// #include <stdlib.h>
+// #include "ggml.h"
+// #include "ggml-backend.h"
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"unsafe"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+)
+
+// loras holds the LoRA adapters loaded alongside the weights of the model
+type loras struct {
+	mu sync.Mutex
+
+	// adapters are indexed by their id minus one
+	adapters []*lora
+
+	// active holds the masks selecting the inputs of each context that
+	// an adapter applies to
+	active map[*C.struct_ggml_context][]loraMask
+}
+
+type lora struct {
+	path    string
+	buffers []*C.struct_ggml_backend_buffer
+
+	// weights maps the name of a model weight to its low rank update
+	weights map[string]loraWeight
+}
+
+// loraWeight is the update B·A·scale to a weight. a has the shape
+// [in, rank] and b the shape [rank, out] in ggml order
+type loraWeight struct {
+	a, b  *C.struct_ggml_tensor
+	scale float32
+}
+
+type loraMask struct {
+	lora *lora
+
+	// mask is 1 for the inputs using the adapter and 0 otherwise
+	mask *C.struct_ggml_tensor
+
+	// outputs is the rows of mask for the inputs with outputs, for the
+	// weights applied after the hidden state is reduced to them
+	outputs *C.struct_ggml_tensor
+}
+
+// LoadAdapter reads the LoRA adapter at path into the same buffers as the
+// weights it updates. Loading the same path again returns the existing id.
+func (b *Backend) LoadAdapter(path string) (int, error) {
+	if b.lora == nil {
+		b.lora = &loras{active: make(map[*C.struct_ggml_context][]loraMask)}
+	}
+
+	b.lora.mu.Lock()
+	defer b.lora.mu.Unlock()
+
+	for i, l := range b.lora.adapters {
+		if l.path == path {
+			return i + 1, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	meta, n, err := fs.Decode(f, -1)
+	if err != nil {
+		return 0, err
+	}
+
+	if kind, _ := meta.KV()["adapter.type"].(string); kind != "lora" {
+		return 0, fmt.Errorf("%s: unsupported adapter type %q", path, kind)
+	}
+
+	alpha, _ := meta.KV()["adapter.lora.alpha"].(float32)
+
+	type pair struct{ a, b *fs.Tensor }
+	pairs := make(map[string]*pair)
+	for _, t := range meta.Tensors().Items() {
+		name, part, ok := strings.Cut(t.Name, ".lora_")
+		if !ok {
+			return 0, fmt.Errorf("%s: unexpected tensor %s", path, t.Name)
+		}
+
+		p, ok := pairs[name]
+		if !ok {
+			p = &pair{}
+			pairs[name] = p
+		}
+
+		switch part {
+		case "a":
+			p.a = t
+		case "b":
+			p.b = t
+		case "m":
+			return 0, fmt.Errorf("%s: DoRA adapters cannot be applied at runtime", path)
+		default:
+			return 0, fmt.Errorf("%s: unexpected tensor %s", path, t.Name)
+		}
+	}
+
+	// adapter tensors are created next to the weight they update so the
+	// update is computed on the same device
+	type source struct {
+		t   *fs.Tensor
+		dst **C.struct_ggml_tensor
+	}
+
+	l := lora{path: path, weights: make(map[string]loraWeight, len(pairs))}
+	ctxs := make(map[*C.struct_ggml_backend_buffer_type]*C.struct_ggml_context)
+	defer func() {
+		for _, c := range ctxs {
+			C.ggml_free(c)
+		}
+	}()
+
+	var sources []source
+	for name, p := range pairs {
+		w, ok := b.tensors[name]
+		if !ok {
+			return 0, fmt.Errorf("%s: no weight %s in model", path, name)
+		}
+
+		if p.a == nil || p.b == nil {
+			return 0, fmt.Errorf("%s: incomplete update for %s", path, name)
+		}
+
+		if len(p.a.Shape) != 2 || len(p.b.Shape) != 2 ||
+			p.a.Shape[0] != uint64(w.ne[0]) || p.b.Shape[1] != uint64(w.ne[1]) || p.a.Shape[1] != p.b.Shape[0] {
+			return 0, fmt.Errorf("%s: shape of update for %s doesn't match model", path, name)
+		}
+
+		bt := C.ggml_backend_buffer_get_type(w.buffer)
+		if _, ok := ctxs[bt]; !ok {
+			ctxs[bt] = C.ggml_init(C.struct_ggml_init_params{
+				mem_size: C.ggml_tensor_overhead() * C.size_t(2*len(pairs)),
+				no_alloc: true,
+			})
+		}
+
+		var lw loraWeight
+		for _, s := range []source{{t: p.a, dst: &lw.a}, {t: p.b, dst: &lw.b}} {
+			cname := C.CString(s.t.Name)
+			*s.dst = C.ggml_new_tensor(ctxs[bt], s.t.Kind, C.int(len(s.t.Shape)), (*C.int64_t)(unsafe.Pointer(&s.t.Shape[0])))
+			C.ggml_set_name(*s.dst, cname)
+			C.free(unsafe.Pointer(cname))
+			sources = append(sources, s)
+		}
+
+		// updates are scaled by alpha / rank, or not at all if alpha isn't set
+		lw.scale = 1
+		if rank := p.a.Shape[1]; alpha > 0 && rank > 0 {
+			lw.scale = alpha / float32(rank)
+		}
+
+		l.weights[name] = lw
+	}
+
+	for bt, c := range ctxs {
+		buf := C.ggml_backend_alloc_ctx_tensors_from_buft(c, bt)
+		if buf == nil {
+			l.free()
+			return 0, fmt.Errorf("%s: failed to allocate adapter buffer", path)
+		}
+
+		C.ggml_backend_buffer_set_usage(buf, C.GGML_BACKEND_BUFFER_USAGE_WEIGHTS)
+		l.buffers = append(l.buffers, buf)
+	}
+
+	sr := io.NewSectionReader(f, int64(meta.Tensors().Offset), n-int64(meta.Tensors().Offset))
+	for _, s := range sources {
+		buf := make([]byte, s.t.Size())
+		if _, err := io.ReadFull(io.NewSectionReader(sr, int64(s.t.Offset), int64(s.t.Size())), buf); err != nil {
+			l.free()
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+
+		C.ggml_backend_tensor_set(*s.dst, unsafe.Pointer(&buf[0]), 0, C.size_t(len(buf)))
+	}
+
+	slog.Info("loaded adapter", "path", path, "weights", len(l.weights))
+
+	b.lora.adapters = append(b.lora.adapters, &l)
+	return len(b.lora.adapters), nil
+}
+
+func (l *lora) free() {
+	for _, buf := range l.buffers {
+		C.ggml_backend_buffer_free(buf)
+	}
+
+	l.buffers = nil
+}
+
+// SetAdapters selects the adapter applied to each input of the batch
+// computed in ctx. An id of 0 applies no adapter. outputs are the indices of
+// the inputs with outputs.
+func (b *Backend) SetAdapters(ctx ml.Context, adapters []int, outputs []int32) error {
+	c := ctx.(*Context)
+
+	var masks []loraMask
+	seen := make(map[int]bool)
+	for _, id := range adapters {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if b.lora == nil || id < 0 || id > len(b.lora.adapters) {
+			return fmt.Errorf("unknown adapter %d", id)
+		}
+
+		mask := make([]float32, len(adapters))
+		for i := range adapters {
+			if adapters[i] == id {
+				mask[i] = 1
+			}
+		}
+
+		t, err := c.Input().FromFloatSlice(mask, 1, len(mask))
+		if err != nil {
+			return err
+		}
+
+		lm := loraMask{lora: b.lora.adapters[id-1], mask: t.(*Tensor).t}
+		if len(outputs) > 0 {
+			rows := make([]float32, len(outputs))
+			for i, j := range outputs {
+				rows[i] = mask[j]
+			}
+
+			t, err := c.Input().FromFloatSlice(rows, 1, len(rows))
+			if err != nil {
+				return err
+			}
+
+			lm.outputs = t.(*Tensor).t
+		}
+
+		masks = append(masks, lm)
+	}
+
+	if len(masks) == 0 {
+		return nil
+	}
+
+	b.lora.mu.Lock()
+	defer b.lora.mu.Unlock()
+	b.lora.active[c.ctx] = masks
+	return nil
+}
+
+// applyAdapters adds the updates of the adapters active in c for the model
+// weight w to mul, the product of w and x
+func (b *Backend) applyAdapters(c *Context, w, x, mul *Tensor) *Tensor {
+	if b.lora == nil {
+		return mul
+	}
+
+	b.lora.mu.Lock()
+	masks := b.lora.active[c.ctx]
+	b.lora.mu.Unlock()
+	if len(masks) == 0 || C.ggml_n_dims(w.t) != 2 {
+		return mul
+	}
+
+	name := C.GoString(C.ggml_get_name(w.t))
+	if b.tensors[name] != w.t {
+		return mul
+	}
+
+	t := mul.t
+	for _, m := range masks {
+		lw, ok := m.lora.weights[name]
+		if !ok {
+			continue
+		}
+
+		// the rows of the product are either each input of the batch or,
+		// once the hidden state is reduced to them, each output
+		mask := m.mask
+		if rows := t.ne[1] * t.ne[2] * t.ne[3]; rows != mask.ne[1] {
+			if m.outputs == nil || rows != m.outputs.ne[1] {
+				slog.Warn("adapter not applied to weight with unexpected rows", "weight", name, "rows", rows)
+				continue
+			}
+
+			mask = m.outputs
+		}
+
+		u := C.ggml_mul_mat(c.ctx, lw.a, x.t)
+		u = C.ggml_mul_mat(c.ctx, lw.b, u)
+		u = C.ggml_scale(c.ctx, u, C.float(lw.scale))
+		u = C.ggml_mul(c.ctx, u, C.ggml_reshape_4d(c.ctx, mask, 1, t.ne[1], t.ne[2], t.ne[3]))
+		t = C.ggml_add(c.ctx, t, u)
+	}
+
+	return &Tensor{b: b, t: t}
+}
+
+// discardAdapters forgets the masks of a context that is closed
+func (b *Backend) discardAdapters(c *Context) {
+	if b.lora == nil {
+		return
+	}
+
+	b.lora.mu.Lock()
+	defer b.lora.mu.Unlock()
+	delete(b.lora.active, c.ctx)
+}
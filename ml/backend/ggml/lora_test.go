@@ -0,0 +1,126 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+)
+
+func writeGGUF(t *testing.T, path string, kv fs.KV, tensors map[string][]float32, shapes map[string][]uint64) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var ts []fs.Tensor
+	for name, data := range tensors {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, data); err != nil {
+			t.Fatal(err)
+		}
+
+		ts = append(ts, fs.Tensor{Name: name, Kind: 0, Shape: shapes[name], WriterTo: bytes.NewReader(b.Bytes())})
+	}
+
+	if err := fs.WriteGGUF(f, kv, ts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdapters(t *testing.T) {
+	dir := t.TempDir()
+
+	// a weight with 4 inputs and 2 outputs, and a rank 1 update adding
+	// 1, 2, 3, 4 times the first input to the outputs
+	writeGGUF(t, filepath.Join(dir, "model.gguf"), fs.KV{"general.architecture": "test"},
+		map[string][]float32{"output.weight": {1, 0, 0, 0, 0, 1, 0, 0}},
+		map[string][]uint64{"output.weight": {2, 4}})
+	writeGGUF(t, filepath.Join(dir, "adapter.gguf"), fs.KV{"general.architecture": "test", "adapter.type": "lora"},
+		map[string][]float32{
+			"output.weight.lora_a": {1, 0, 0, 0},
+			"output.weight.lora_b": {1, 2},
+		},
+		map[string][]uint64{
+			"output.weight.lora_a": {1, 4},
+			"output.weight.lora_b": {2, 1},
+		})
+
+	f, err := os.Open(filepath.Join(dir, "model.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	backend, err := New(f, ml.BackendParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := backend.(*Backend)
+
+	id, err := b.LoadAdapter(filepath.Join(dir, "adapter.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again, err := b.LoadAdapter(filepath.Join(dir, "adapter.gguf")); err != nil || again != id {
+		t.Fatalf("loading again returned %d, %v; want %d", again, err, id)
+	}
+
+	// three inputs of which the last two use the adapter
+	x := []float32{
+		1, 2, 0, 0,
+		1, 2, 0, 0,
+		3, 4, 0, 0,
+	}
+
+	forward := func(outputs []int32, rows bool) []float32 {
+		t.Helper()
+		ctx := b.NewContext()
+		defer ctx.Close()
+
+		if err := b.SetAdapters(ctx, []int{0, id, id}, outputs); err != nil {
+			t.Fatal(err)
+		}
+
+		in, err := ctx.Input().FromFloatSlice(x, 4, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rows {
+			indices, err := ctx.Input().FromIntSlice(outputs, len(outputs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			in = in.Rows(ctx, indices)
+		}
+
+		out := b.Get("output.weight").Mulmat(ctx, in)
+		ctx.Forward(out).Compute(out)
+		return out.Floats()
+	}
+
+	if got, want := forward([]int32{2}, false), []float32{1, 2, 2, 4, 6, 10}; !slices.Equal(got, want) {
+		t.Errorf("all inputs = %v; want %v", got, want)
+	}
+
+	// weights applied to the outputs only use the rows of their inputs
+	if got, want := forward([]int32{0, 2}, true), []float32{1, 2, 6, 10}; !slices.Equal(got, want) {
+		t.Errorf("outputs = %v; want %v", got, want)
+	}
+
+	ctx := b.NewContext()
+	defer ctx.Close()
+	if err := b.SetAdapters(ctx, []int{id + 1}, nil); err == nil {
+		t.Error("expected error for unknown adapter")
+	}
+}
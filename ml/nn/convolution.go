@@ -2,6 +2,22 @@ package nn
 
 import "github.com/ollama/ollama/ml"
 
+type Conv1D struct {
+	Weight ml.Tensor `gguf:"weight"`
+	Bias   ml.Tensor `gguf:"bias"`
+}
+
+// Forward convolves t, laid out as [length, channels], and returns the result
+// laid out as [length, output channels]
+func (m *Conv1D) Forward(ctx ml.Context, t ml.Tensor, s0, p0, d0 int) ml.Tensor {
+	t = m.Weight.Conv1D(ctx, t, s0, p0, d0)
+	if m.Bias != nil {
+		t = t.Add(ctx, m.Bias.Reshape(ctx, 1, m.Bias.Dim(0)))
+	}
+
+	return t
+}
+
 type Conv2D struct {
 	Weight ml.Tensor `gguf:"weight"`
 }
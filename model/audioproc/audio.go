@@ -0,0 +1,235 @@
+package audioproc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported audio format, expected WAV or FLAC")
+	ErrTooLong           = errors.New("audio is too long")
+)
+
+// Decode reads a WAV or FLAC stream and returns its samples mixed down to a
+// single channel in the range [-1, 1] together with the sample rate.
+func Decode(r io.Reader) ([]float32, int, error) {
+	return DecodeMax(r, 0)
+}
+
+// DecodeMax is like Decode but returns ErrTooLong, without decoding the rest
+// of the stream, once the audio is longer than maxDuration. A maxDuration of 0
+// doesn't limit the length of the audio.
+func DecodeMax(r io.Reader, maxDuration time.Duration) ([]float32, int, error) {
+	bts, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var channels [][]float32
+	var sampleRate int
+	switch {
+	case len(bts) >= 12 && bytes.Equal(bts[:4], []byte("RIFF")) && bytes.Equal(bts[8:12], []byte("WAVE")):
+		channels, sampleRate, err = decodeWAV(bts, maxDuration)
+	case len(bts) >= 4 && bytes.Equal(bts[:4], []byte("fLaC")):
+		channels, sampleRate, err = decodeFLAC(bts, maxDuration)
+	default:
+		return nil, 0, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(channels) == 0 || sampleRate <= 0 {
+		return nil, 0, errors.New("audio has no samples")
+	}
+
+	return Mono(channels), sampleRate, nil
+}
+
+// Mono averages the channels into a single channel.
+func Mono(channels [][]float32) []float32 {
+	if len(channels) == 1 {
+		return channels[0]
+	}
+
+	mono := make([]float32, len(channels[0]))
+	for _, c := range channels {
+		for i, s := range c {
+			mono[i] += s
+		}
+	}
+
+	for i := range mono {
+		mono[i] /= float32(len(channels))
+	}
+
+	return mono
+}
+
+// Resample converts samples from one sample rate to another using linear
+// interpolation. Samples are low pass filtered with a moving average first
+// when downsampling to reduce aliasing.
+func Resample(samples []float32, from, to int) []float32 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+
+	if from > to {
+		width := from / to
+		if width > 1 {
+			filtered := make([]float32, len(samples))
+			var sum float32
+			for i, s := range samples {
+				sum += s
+				if i >= width {
+					sum -= samples[i-width]
+				}
+
+				filtered[i] = sum / float32(min(i+1, width))
+			}
+
+			samples = filtered
+		}
+	}
+
+	n := int(int64(len(samples)) * int64(to) / int64(from))
+	resampled := make([]float32, n)
+	ratio := float64(from) / float64(to)
+	for i := range resampled {
+		pos := float64(i) * ratio
+		j := int(pos)
+		frac := float32(pos - float64(j))
+		if j+1 < len(samples) {
+			resampled[i] = samples[j]*(1-frac) + samples[j+1]*frac
+		} else {
+			resampled[i] = samples[len(samples)-1]
+		}
+	}
+
+	return resampled
+}
+
+// HannWindow returns a periodic Hann window of length n.
+func HannWindow(n int) []float32 {
+	window := make([]float32, n)
+	for i := range window {
+		window[i] = float32(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n)))
+	}
+
+	return window
+}
+
+// PowerSpectrogram computes the squared magnitude of the short time Fourier
+// transform of samples. The signal is reflect padded by nFFT/2 on both sides
+// so that frames are centered on multiples of hop. The result holds
+// nFFT/2+1 frequency bins for each frame.
+func PowerSpectrogram(samples []float32, nFFT, hop int, window []float32) [][]float32 {
+	pad := nFFT / 2
+	padded := make([]float32, len(samples)+2*pad)
+	copy(padded[pad:], samples)
+	for i := range pad {
+		if i+1 < len(samples) {
+			padded[pad-1-i] = samples[i+1]
+			padded[pad+len(samples)+i] = samples[len(samples)-2-i]
+		}
+	}
+
+	nBins := nFFT/2 + 1
+	cos := make([]float64, nFFT)
+	sin := make([]float64, nFFT)
+	for i := range nFFT {
+		cos[i] = math.Cos(2 * math.Pi * float64(i) / float64(nFFT))
+		sin[i] = math.Sin(2 * math.Pi * float64(i) / float64(nFFT))
+	}
+
+	nFrames := 1 + (len(padded)-nFFT)/hop
+	frames := make([][]float32, nFrames)
+	frame := make([]float64, nFFT)
+	for f := range frames {
+		silent := true
+		for i := range nFFT {
+			frame[i] = float64(padded[f*hop+i] * window[i])
+			silent = silent && frame[i] == 0
+		}
+
+		power := make([]float32, nBins)
+		frames[f] = power
+		if silent {
+			// skip the transform for padding
+			continue
+		}
+
+		for k := range nBins {
+			var re, im float64
+			for i, v := range frame {
+				idx := (k * i) % nFFT
+				re += v * cos[idx]
+				im -= v * sin[idx]
+			}
+
+			power[k] = float32(re*re + im*im)
+		}
+	}
+
+	return frames
+}
+
+func hzToMel(hz float64) float64 {
+	const minLogHz, minLogMel, fsp = 1000.0, 15.0, 200.0 / 3
+	if hz < minLogHz {
+		return hz / fsp
+	}
+
+	return minLogMel + math.Log(hz/minLogHz)/(math.Log(6.4)/27)
+}
+
+func melToHz(mel float64) float64 {
+	const minLogHz, minLogMel, fsp = 1000.0, 15.0, 200.0 / 3
+	if mel < minLogMel {
+		return mel * fsp
+	}
+
+	return minLogHz * math.Exp((math.Log(6.4)/27)*(mel-minLogMel))
+}
+
+// MelFilterBank returns nMels triangular filters over the nFFT/2+1 frequency
+// bins of a spectrogram, using the Slaney mel scale and area normalization.
+func MelFilterBank(sampleRate, nFFT, nMels int) [][]float32 {
+	nBins := nFFT/2 + 1
+	fftFreqs := make([]float64, nBins)
+	for i := range fftFreqs {
+		fftFreqs[i] = float64(i) * float64(sampleRate) / float64(nFFT)
+	}
+
+	minMel, maxMel := hzToMel(0), hzToMel(float64(sampleRate)/2)
+	melFreqs := make([]float64, nMels+2)
+	for i := range melFreqs {
+		melFreqs[i] = melToHz(minMel + (maxMel-minMel)*float64(i)/float64(nMels+1))
+	}
+
+	filters := make([][]float32, nMels)
+	for m := range filters {
+		filters[m] = make([]float32, nBins)
+		norm := 2 / (melFreqs[m+2] - melFreqs[m])
+		for k, f := range fftFreqs {
+			lower := (f - melFreqs[m]) / (melFreqs[m+1] - melFreqs[m])
+			upper := (melFreqs[m+2] - f) / (melFreqs[m+2] - melFreqs[m+1])
+			filters[m][k] = float32(max(0, min(lower, upper)) * norm)
+		}
+	}
+
+	return filters
+}
+
+// maxSamples returns the number of samples per channel in maxDuration of
+// audio at sampleRate, or -1 if maxDuration is 0
+func maxSamples(maxDuration time.Duration, sampleRate int) int {
+	if maxDuration <= 0 {
+		return -1
+	}
+
+	return int(maxDuration.Seconds() * float64(sampleRate))
+}
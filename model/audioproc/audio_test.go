@@ -0,0 +1,400 @@
+package audioproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func wav(t *testing.T, sampleRate int, channels ...[]int16) []byte {
+	t.Helper()
+
+	var data bytes.Buffer
+	for i := range channels[0] {
+		for _, c := range channels {
+			if err := binary.Write(&data, binary.LittleEndian, c[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+data.Len()))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(wavFormatPCM))
+	binary.Write(&b, binary.LittleEndian, uint16(len(channels)))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate*2*len(channels)))
+	binary.Write(&b, binary.LittleEndian, uint16(2*len(channels)))
+	binary.Write(&b, binary.LittleEndian, uint16(16))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(data.Len()))
+	b.Write(data.Bytes())
+	return b.Bytes()
+}
+
+type bitWriter struct {
+	b     []byte
+	nbits int
+}
+
+func (w *bitWriter) write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.nbits%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+
+		w.b[len(w.b)-1] |= byte(v>>i&1) << (7 - w.nbits%8)
+		w.nbits++
+	}
+}
+
+// flac builds a stereo 16 bit stream with a single left/side coded frame.
+// The left channel is a constant subframe and the side channel is predicted
+// with a first order fixed predictor and rice coded residuals.
+func flac(t *testing.T) []byte {
+	t.Helper()
+
+	var w bitWriter
+	w.write(uint64(binary.BigEndian.Uint32([]byte("fLaC"))), 32)
+
+	// last metadata block, stream info
+	w.write(1, 1)
+	w.write(0, 7)
+	w.write(34, 24)
+	w.write(4, 16)     // min block size
+	w.write(4, 16)     // max block size
+	w.write(0, 24)     // min frame size
+	w.write(0, 24)     // max frame size
+	w.write(16000, 20) // sample rate
+	w.write(1, 3)      // channels - 1
+	w.write(15, 5)     // bits per sample - 1
+	w.write(4, 36)     // total samples
+	w.write(0, 64)     // md5
+	w.write(0, 64)
+
+	// frame header
+	w.write(0x7ffc, 15)
+	w.write(0, 1)
+	w.write(6, 4) // 8 bit block size follows
+	w.write(0, 4) // sample rate from stream info
+	w.write(8, 4) // left, side
+	w.write(4, 3) // 16 bits per sample
+	w.write(0, 1)
+	w.write(0, 8) // frame number
+	w.write(3, 8) // block size - 1
+	w.write(0, 8) // crc-8
+
+	// left: constant 100
+	w.write(0, 8)
+	w.write(100, 16)
+
+	// side: fixed order 1, warm up 0 and residuals 2, 2, 2
+	w.write(9<<1, 8)
+	w.write(0, 17)
+	w.write(0, 2) // rice
+	w.write(0, 4) // partition order
+	w.write(2, 4) // rice parameter
+	for range 3 {
+		// zigzag(2) = 4 = 0b1_00
+		w.write(0b01, 2)
+		w.write(0, 2)
+	}
+
+	for w.nbits%8 != 0 {
+		w.write(0, 1)
+	}
+
+	w.write(0, 16) // crc-16
+	return w.b
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name       string
+		data       []byte
+		sampleRate int
+		want       []float32
+	}{
+		{
+			name:       "wav mono",
+			data:       wav(t, 16000, []int16{0, 16384, -16384, 32767}),
+			sampleRate: 16000,
+			want:       []float32{0, 0.5, -0.5, 32767.0 / 32768},
+		},
+		{
+			name:       "wav stereo",
+			data:       wav(t, 44100, []int16{16384, 0}, []int16{0, -16384}),
+			sampleRate: 44100,
+			want:       []float32{0.25, -0.25},
+		},
+		{
+			name:       "flac left side",
+			data:       flac(t),
+			sampleRate: 16000,
+			want: []float32{
+				(100 + 100) / 2.0 / 32768,
+				(100 + 98) / 2.0 / 32768,
+				(100 + 96) / 2.0 / 32768,
+				(100 + 94) / 2.0 / 32768,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, sampleRate, err := Decode(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sampleRate != tt.sampleRate {
+				t.Errorf("sample rate = %d, want %d", sampleRate, tt.sampleRate)
+			}
+
+			if !slices.Equal(samples, tt.want) {
+				t.Errorf("samples = %v, want %v", samples, tt.want)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		if _, _, err := Decode(bytes.NewReader([]byte("ID3\x04"))); err != ErrUnsupportedFormat {
+			t.Errorf("err = %v, want %v", err, ErrUnsupportedFormat)
+		}
+	})
+}
+
+// flacMono builds a mono 16 bit stream with a single frame of blockSize
+// samples whose subframe is written by subframe.
+func flacMono(t *testing.T, blockSize int, subframe func(*bitWriter)) []byte {
+	t.Helper()
+
+	var w bitWriter
+	w.write(uint64(binary.BigEndian.Uint32([]byte("fLaC"))), 32)
+
+	// last metadata block, stream info
+	w.write(1, 1)
+	w.write(0, 7)
+	w.write(34, 24)
+	w.write(uint64(blockSize), 16)
+	w.write(uint64(blockSize), 16)
+	w.write(0, 24)
+	w.write(0, 24)
+	w.write(16000, 20)
+	w.write(0, 3)
+	w.write(15, 5)
+	w.write(uint64(blockSize), 36)
+	w.write(0, 64)
+	w.write(0, 64)
+
+	// frame header
+	w.write(0x7ffc, 15)
+	w.write(0, 1)
+	w.write(6, 4) // 8 bit block size follows
+	w.write(0, 4) // sample rate from stream info
+	w.write(0, 4) // mono
+	w.write(4, 3) // 16 bits per sample
+	w.write(0, 1)
+	w.write(0, 8)                   // frame number
+	w.write(uint64(blockSize-1), 8) // block size - 1
+	w.write(0, 8)                   // crc-8
+
+	subframe(&w)
+
+	for w.nbits%8 != 0 {
+		w.write(0, 1)
+	}
+
+	w.write(0, 16) // crc-16
+	return w.b
+}
+
+func TestDecodeMalformedFLAC(t *testing.T) {
+	cases := []struct {
+		name      string
+		blockSize int
+		subframe  func(*bitWriter)
+	}{
+		{
+			name:      "fixed order exceeds block size",
+			blockSize: 1,
+			subframe: func(w *bitWriter) {
+				w.write(12<<1, 8)
+				for range 4 {
+					w.write(0, 16)
+				}
+			},
+		},
+		{
+			name:      "lpc order exceeds block size",
+			blockSize: 4,
+			subframe: func(w *bitWriter) {
+				w.write((32+7)<<1, 8)
+				for range 8 {
+					w.write(0, 16)
+				}
+			},
+		},
+		{
+			name:      "partition shorter than order",
+			blockSize: 4,
+			subframe: func(w *bitWriter) {
+				w.write(10<<1, 8)
+				w.write(0, 16)
+				w.write(0, 16)
+				w.write(0, 2) // rice
+				w.write(2, 4) // partition order, 1 sample per partition
+				for range 4 {
+					w.write(0, 4)
+					w.write(1, 1)
+				}
+			},
+		},
+		{
+			name:      "block size not divisible by partitions",
+			blockSize: 6,
+			subframe: func(w *bitWriter) {
+				w.write(8<<1, 8)
+				w.write(0, 2) // rice
+				w.write(2, 4) // partition order
+				for range 4 {
+					w.write(0, 4)
+					w.write(1, 1)
+				}
+			},
+		},
+		{
+			name:      "wasted bits exceed sample size",
+			blockSize: 4,
+			subframe: func(w *bitWriter) {
+				w.write(1<<1|1, 8)
+				w.write(0, 20)
+				w.write(1, 1)
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Decode(bytes.NewReader(flacMono(t, tt.blockSize, tt.subframe))); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		b := flac(t)
+		for i := range b {
+			// any prefix must fail cleanly rather than panic
+			Decode(bytes.NewReader(b[:i]))
+		}
+	})
+}
+
+func TestDecodeMax(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"wav":  wav(t, 16000, []int16{0, 1, 2, 3}),
+		"flac": flac(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			// both streams hold 4 samples, 250µs of audio
+			if _, _, err := DecodeMax(bytes.NewReader(data), 200*time.Microsecond); !errors.Is(err, ErrTooLong) {
+				t.Errorf("err = %v, want %v", err, ErrTooLong)
+			}
+
+			if _, _, err := DecodeMax(bytes.NewReader(data), 250*time.Microsecond); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEncodeWAV(t *testing.T) {
+	want := []float32{0, 0.25, -0.5, 1}
+
+	var b bytes.Buffer
+	if err := EncodeWAV(&b, want, 16000); err != nil {
+		t.Fatal(err)
+	}
+
+	samples, sampleRate, err := Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sampleRate != 16000 {
+		t.Errorf("sample rate = %d, want 16000", sampleRate)
+	}
+
+	if !slices.Equal(samples, want) {
+		t.Errorf("samples = %v, want %v", samples, want)
+	}
+}
+
+func TestResample(t *testing.T) {
+	samples := make([]float32, 48000)
+	got := Resample(samples, 48000, 16000)
+	if len(got) != 16000 {
+		t.Errorf("len = %d, want 16000", len(got))
+	}
+
+	if got := Resample(samples, 16000, 16000); len(got) != len(samples) {
+		t.Errorf("len = %d, want %d", len(got), len(samples))
+	}
+}
+
+func TestPowerSpectrogram(t *testing.T) {
+	const sampleRate, nFFT, hop = 16000, 400, 160
+
+	// a 1 kHz tone falls in bin 1000 * nFFT / sampleRate = 25
+	samples := make([]float32, sampleRate)
+	for i := range samples {
+		samples[i] = float32(math.Sin(2 * math.Pi * 1000 * float64(i) / sampleRate))
+	}
+
+	frames := PowerSpectrogram(samples, nFFT, hop, HannWindow(nFFT))
+	if len(frames) != 1+len(samples)/hop {
+		t.Fatalf("frames = %d, want %d", len(frames), 1+len(samples)/hop)
+	}
+
+	frame := frames[len(frames)/2]
+	if len(frame) != nFFT/2+1 {
+		t.Fatalf("bins = %d, want %d", len(frame), nFFT/2+1)
+	}
+
+	if peak := slices.Index(frame, slices.Max(frame)); peak != 25 {
+		t.Errorf("peak bin = %d, want 25", peak)
+	}
+}
+
+func TestMelFilterBank(t *testing.T) {
+	filters := MelFilterBank(16000, 400, 80)
+	if len(filters) != 80 {
+		t.Fatalf("filters = %d, want 80", len(filters))
+	}
+
+	last := -1
+	for i, f := range filters {
+		if len(f) != 201 {
+			t.Fatalf("filter %d has %d bins, want 201", i, len(f))
+		}
+
+		if slices.Min(f) < 0 {
+			t.Errorf("filter %d has negative weights", i)
+		}
+
+		// filter centers increase monotonically with the mel index
+		peak := slices.Index(f, slices.Max(f))
+		if peak < last {
+			t.Errorf("filter %d peaks at bin %d before previous peak %d", i, peak, last)
+		}
+		last = peak
+	}
+}
@@ -0,0 +1,528 @@
+package audioproc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var errFLACSync = errors.New("flac: invalid frame sync code")
+
+// bitReader reads big endian bit fields from a byte slice
+type bitReader struct {
+	b   []byte
+	pos int // position in bits
+}
+
+func (r *bitReader) read(n int) (uint64, error) {
+	if r.pos+n > len(r.b)*8 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var v uint64
+	for range n {
+		bit := r.b[r.pos/8] >> (7 - r.pos%8) & 1
+		v = v<<1 | uint64(bit)
+		r.pos++
+	}
+
+	return v, nil
+}
+
+// readSigned reads an n bit two's complement value
+func (r *bitReader) readSigned(n int) (int64, error) {
+	v, err := r.read(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary counts the zero bits preceding the next one bit
+func (r *bitReader) readUnary() (int, error) {
+	var n int
+	for {
+		bit, err := r.read(1)
+		if err != nil {
+			return 0, err
+		}
+
+		if bit == 1 {
+			return n, nil
+		}
+
+		n++
+	}
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+// readUTF8 reads the variable length frame or sample number of a frame header
+func (r *bitReader) readUTF8() (uint64, error) {
+	first, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for mask := uint64(0x80); first&mask != 0; mask >>= 1 {
+		n++
+	}
+
+	switch {
+	case n == 0:
+		return first, nil
+	case n == 1 || n > 7:
+		return 0, errors.New("flac: invalid utf-8 coded number")
+	}
+
+	v := first & (0xff >> (n + 1))
+	for range n - 1 {
+		b, err := r.read(8)
+		if err != nil {
+			return 0, err
+		}
+
+		v = v<<6 | b&0x3f
+	}
+
+	return v, nil
+}
+
+type flacStreamInfo struct {
+	sampleRate    int
+	numChannels   int
+	bitsPerSample int
+}
+
+// decodeFLAC decodes a native FLAC stream
+func decodeFLAC(bts []byte, maxDuration time.Duration) ([][]float32, int, error) {
+	var info *flacStreamInfo
+
+	b := bts[4:]
+	for last := false; !last; {
+		if len(b) < 4 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+
+		last = b[0]&0x80 != 0
+		blockType := b[0] & 0x7f
+		size := int(binary.BigEndian.Uint32(b[:4]) & 0xffffff)
+		b = b[4:]
+		if size > len(b) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+
+		if blockType == 0 {
+			if size < 18 {
+				return nil, 0, errors.New("flac: invalid stream info")
+			}
+
+			r := bitReader{b: b[10:18]}
+			sampleRate, _ := r.read(20)
+			numChannels, _ := r.read(3)
+			bitsPerSample, _ := r.read(5)
+			info = &flacStreamInfo{
+				sampleRate:    int(sampleRate),
+				numChannels:   int(numChannels) + 1,
+				bitsPerSample: int(bitsPerSample) + 1,
+			}
+		}
+
+		b = b[size:]
+	}
+
+	if info == nil {
+		return nil, 0, errors.New("flac: missing stream info")
+	} else if info.sampleRate == 0 {
+		return nil, 0, errors.New("flac: invalid sample rate")
+	}
+
+	// frames are decoded one at a time so the length is checked as they're
+	// decoded, since a short stream can expand to a lot of samples
+	limit := maxSamples(maxDuration, info.sampleRate)
+
+	channels := make([][]int32, info.numChannels)
+	r := bitReader{b: b}
+	for r.pos/8 < len(r.b)-2 {
+		if err := decodeFLACFrame(&r, info, channels); errors.Is(err, errFLACSync) && len(channels[0]) > 0 {
+			// trailing data such as id3 tags after the last frame
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		if limit >= 0 && len(channels[0]) > limit {
+			return nil, 0, ErrTooLong
+		}
+	}
+
+	scale := float32(int64(1) << (info.bitsPerSample - 1))
+	out := make([][]float32, len(channels))
+	for c := range channels {
+		out[c] = make([]float32, len(channels[c]))
+		for i, s := range channels[c] {
+			out[c][i] = float32(s) / scale
+		}
+	}
+
+	return out, info.sampleRate, nil
+}
+
+func decodeFLACFrame(r *bitReader, info *flacStreamInfo, channels [][]int32) error {
+	sync, err := r.read(15)
+	if err != nil {
+		return err
+	}
+
+	if sync != 0x7ffc {
+		return errFLACSync
+	}
+
+	// blocking strategy
+	if _, err := r.read(1); err != nil {
+		return err
+	}
+
+	header, err := r.read(16)
+	if err != nil {
+		return err
+	}
+
+	blockSizeCode := int(header >> 12 & 0xf)
+	sampleRateCode := int(header >> 8 & 0xf)
+	channelAssignment := int(header >> 4 & 0xf)
+	sampleSizeCode := int(header >> 1 & 0x7)
+
+	if _, err := r.readUTF8(); err != nil {
+		return err
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode >= 2 && blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		v, err := r.read(8)
+		if err != nil {
+			return err
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode == 7:
+		v, err := r.read(16)
+		if err != nil {
+			return err
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode >= 8:
+		blockSize = 256 << (blockSizeCode - 8)
+	default:
+		return errors.New("flac: reserved block size")
+	}
+
+	// the sample rate is taken from the stream info, skip any explicit value
+	switch sampleRateCode {
+	case 12:
+		_, err = r.read(8)
+	case 13, 14:
+		_, err = r.read(16)
+	case 15:
+		err = errors.New("flac: invalid sample rate")
+	}
+	if err != nil {
+		return err
+	}
+
+	bitsPerSample := info.bitsPerSample
+	switch sampleSizeCode {
+	case 1:
+		bitsPerSample = 8
+	case 2:
+		bitsPerSample = 12
+	case 4:
+		bitsPerSample = 16
+	case 5:
+		bitsPerSample = 20
+	case 6:
+		bitsPerSample = 24
+	case 7:
+		bitsPerSample = 32
+	}
+
+	numChannels := channelAssignment + 1
+	if channelAssignment >= 8 && channelAssignment <= 10 {
+		numChannels = 2
+	} else if channelAssignment > 10 {
+		return errors.New("flac: reserved channel assignment")
+	}
+
+	if numChannels != len(channels) {
+		return fmt.Errorf("flac: frame has %d channels, expected %d", numChannels, len(channels))
+	}
+
+	// crc-8 of the header
+	if _, err := r.read(8); err != nil {
+		return err
+	}
+
+	subframes := make([][]int64, numChannels)
+	for c := range subframes {
+		bps := bitsPerSample
+		// the side channel carries an extra bit
+		if (channelAssignment == 8 || channelAssignment == 10) && c == 1 || channelAssignment == 9 && c == 0 {
+			bps++
+		}
+
+		subframes[c], err = decodeFLACSubframe(r, blockSize, bps)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch channelAssignment {
+	case 8: // left, side
+		for i := range blockSize {
+			subframes[1][i] = subframes[0][i] - subframes[1][i]
+		}
+	case 9: // side, right
+		for i := range blockSize {
+			subframes[0][i] += subframes[1][i]
+		}
+	case 10: // mid, side
+		for i := range blockSize {
+			mid, side := subframes[0][i]<<1|subframes[1][i]&1, subframes[1][i]
+			subframes[0][i], subframes[1][i] = (mid+side)>>1, (mid-side)>>1
+		}
+	}
+
+	// scale samples to the stream's bit depth
+	shift := info.bitsPerSample - bitsPerSample
+	for c := range subframes {
+		for _, s := range subframes[c] {
+			if shift >= 0 {
+				channels[c] = append(channels[c], int32(s<<shift))
+			} else {
+				channels[c] = append(channels[c], int32(s>>-shift))
+			}
+		}
+	}
+
+	// zero padding and crc-16 of the frame
+	r.align()
+	_, err = r.read(16)
+	return err
+}
+
+// fixedCoefficients are the predictor coefficients of FIXED subframes by order
+var fixedCoefficients = [][]int64{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+func decodeFLACSubframe(r *bitReader, blockSize, bps int) ([]int64, error) {
+	header, err := r.read(8)
+	if err != nil {
+		return nil, err
+	}
+
+	if header&0x80 != 0 {
+		return nil, errors.New("flac: invalid subframe padding")
+	}
+
+	var wasted int
+	if header&1 != 0 {
+		n, err := r.readUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		wasted = n + 1
+		bps -= wasted
+		if bps < 1 {
+			return nil, errors.New("flac: invalid wasted bits")
+		}
+	}
+
+	samples := make([]int64, blockSize)
+	switch kind := int(header >> 1 & 0x3f); {
+	case kind == 0: // constant
+		v, err := r.readSigned(bps)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range samples {
+			samples[i] = v
+		}
+	case kind == 1: // verbatim
+		for i := range samples {
+			if samples[i], err = r.readSigned(bps); err != nil {
+				return nil, err
+			}
+		}
+	case kind >= 8 && kind <= 12: // fixed
+		order := kind - 8
+		if order > blockSize {
+			return nil, errors.New("flac: predictor order exceeds block size")
+		}
+
+		for i := range order {
+			if samples[i], err = r.readSigned(bps); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := decodeFLACResidual(r, samples, order); err != nil {
+			return nil, err
+		}
+
+		predict(samples, fixedCoefficients[order], 0)
+	case kind >= 32: // lpc
+		order := kind - 31
+		if order > blockSize {
+			return nil, errors.New("flac: predictor order exceeds block size")
+		}
+
+		for i := range order {
+			if samples[i], err = r.readSigned(bps); err != nil {
+				return nil, err
+			}
+		}
+
+		precision, err := r.read(4)
+		if err != nil {
+			return nil, err
+		} else if precision == 0xf {
+			return nil, errors.New("flac: invalid lpc precision")
+		}
+
+		shift, err := r.readSigned(5)
+		if err != nil {
+			return nil, err
+		} else if shift < 0 {
+			return nil, errors.New("flac: negative lpc shift")
+		}
+
+		coefficients := make([]int64, order)
+		for i := range coefficients {
+			if coefficients[i], err = r.readSigned(int(precision) + 1); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := decodeFLACResidual(r, samples, order); err != nil {
+			return nil, err
+		}
+
+		predict(samples, coefficients, int(shift))
+	default:
+		return nil, fmt.Errorf("flac: reserved subframe type %d", kind)
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+
+	return samples, nil
+}
+
+// predict adds the linear prediction from the previous samples to the
+// residuals stored after the warm up samples
+func predict(samples, coefficients []int64, shift int) {
+	for i := len(coefficients); i < len(samples); i++ {
+		var sum int64
+		for j, c := range coefficients {
+			sum += c * samples[i-1-j]
+		}
+
+		samples[i] += sum >> shift
+	}
+}
+
+// decodeFLACResidual reads the rice coded residuals following the warm up
+// samples of a predicted subframe
+func decodeFLACResidual(r *bitReader, samples []int64, order int) error {
+	method, err := r.read(2)
+	if err != nil {
+		return err
+	} else if method > 1 {
+		return errors.New("flac: reserved residual coding method")
+	}
+
+	paramBits := 4 + int(method)
+	escape := uint64(1)<<paramBits - 1
+
+	partitionOrder, err := r.read(4)
+	if err != nil {
+		return err
+	}
+
+	numPartitions := 1 << partitionOrder
+	partitionSize := len(samples) >> partitionOrder
+	if partitionSize*numPartitions != len(samples) || partitionSize < order {
+		return errors.New("flac: invalid residual partition order")
+	}
+
+	i := order
+	for p := range numPartitions {
+		n := partitionSize
+		if p == 0 {
+			n -= order
+		}
+
+		if n < 0 || i+n > len(samples) {
+			return errors.New("flac: invalid residual partition")
+		}
+
+		param, err := r.read(paramBits)
+		if err != nil {
+			return err
+		}
+
+		if param == escape {
+			bits, err := r.read(5)
+			if err != nil {
+				return err
+			}
+
+			for range n {
+				if samples[i], err = r.readSigned(int(bits)); err != nil {
+					return err
+				}
+				i++
+			}
+
+			continue
+		}
+
+		for range n {
+			q, err := r.readUnary()
+			if err != nil {
+				return err
+			}
+
+			low, err := r.read(int(param))
+			if err != nil {
+				return err
+			}
+
+			u := uint64(q)<<param | low
+			samples[i] = int64(u>>1) ^ -int64(u&1)
+			i++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package audioproc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xfffe
+)
+
+// decodeWAV decodes integer PCM and IEEE float RIFF/WAVE files
+func decodeWAV(bts []byte, maxDuration time.Duration) ([][]float32, int, error) {
+	var format, numChannels, bitsPerSample uint16
+	var sampleRate uint32
+	var data []byte
+
+	for b := bts[12:]; len(b) >= 8; {
+		id, size := string(b[:4]), binary.LittleEndian.Uint32(b[4:8])
+		b = b[8:]
+		if uint64(size) > uint64(len(b)) {
+			// tolerate truncated files and streams with an unknown data size
+			size = uint32(len(b))
+		}
+
+		chunk := b[:size]
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, 0, errors.New("wav: invalid fmt chunk")
+			}
+
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			numChannels = binary.LittleEndian.Uint16(chunk[2:4])
+			sampleRate = binary.LittleEndian.Uint32(chunk[4:8])
+			bitsPerSample = binary.LittleEndian.Uint16(chunk[14:16])
+			if format == wavFormatExtensible {
+				if len(chunk) < 26 {
+					return nil, 0, errors.New("wav: invalid extensible fmt chunk")
+				}
+
+				// the first two bytes of the sub format GUID hold the format code
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+		case "data":
+			data = chunk
+		}
+
+		// chunks are padded to an even number of bytes
+		b = b[min(int(size)+int(size&1), len(b)):]
+	}
+
+	if numChannels == 0 || data == nil {
+		return nil, 0, errors.New("wav: missing fmt or data chunk")
+	} else if sampleRate == 0 {
+		return nil, 0, errors.New("wav: invalid sample rate")
+	}
+
+	bytesPerSample := int(bitsPerSample+7) / 8
+	var sample func([]byte) float32
+	switch {
+	case format == wavFormatPCM && bytesPerSample == 1:
+		sample = func(b []byte) float32 { return (float32(b[0]) - 128) / 128 }
+	case format == wavFormatPCM && bytesPerSample == 2:
+		sample = func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case format == wavFormatPCM && bytesPerSample == 3:
+		sample = func(b []byte) float32 {
+			return float32(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case format == wavFormatPCM && bytesPerSample == 4:
+		sample = func(b []byte) float32 { return float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == wavFormatFloat && bytesPerSample == 4:
+		sample = func(b []byte) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b)) }
+	case format == wavFormatFloat && bytesPerSample == 8:
+		sample = func(b []byte) float32 { return float32(math.Float64frombits(binary.LittleEndian.Uint64(b))) }
+	default:
+		return nil, 0, fmt.Errorf("wav: unsupported format %d with %d bits per sample", format, bitsPerSample)
+	}
+
+	frameSize := bytesPerSample * int(numChannels)
+	numFrames := len(data) / frameSize
+	if n := maxSamples(maxDuration, int(sampleRate)); n >= 0 && numFrames > n {
+		return nil, 0, ErrTooLong
+	}
+
+	channels := make([][]float32, numChannels)
+	for c := range channels {
+		channels[c] = make([]float32, numFrames)
+		for i := range numFrames {
+			offset := i*frameSize + c*bytesPerSample
+			channels[c][i] = sample(data[offset : offset+bytesPerSample])
+		}
+	}
+
+	return channels, int(sampleRate), nil
+}
+
+// EncodeWAV writes mono samples as a 32-bit IEEE float RIFF/WAVE file
+func EncodeWAV(w io.Writer, samples []float32, sampleRate int) error {
+	dataSize := uint32(4 * len(samples))
+	header := []any{
+		[4]byte{'R', 'I', 'F', 'F'}, 36 + dataSize, [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16),
+		uint16(wavFormatFloat), uint16(1), uint32(sampleRate), uint32(4 * sampleRate), uint16(4), uint16(32),
+		[4]byte{'d', 'a', 't', 'a'}, dataSize,
+	}
+
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	return binary.Write(w, binary.LittleEndian, samples)
+}
@@ -22,7 +22,10 @@ import (
 	"github.com/ollama/ollama/model/input"
 )
 
-var ErrNoVisionModel = errors.New("this model is missing data required for image input")
+var (
+	ErrNoVisionModel = errors.New("this model is missing data required for image input")
+	ErrNoAudioModel  = errors.New("this model is missing data required for audio input")
+)
 
 // Model implements a specific model architecture, defining the forward pass and any model-specific configuration
 type Model interface {
@@ -63,6 +66,14 @@ type MultimodalProcessor interface {
 	PostTokenize([]input.Input) ([]input.Input, error)
 }
 
+// Embedder must be implemented by models that produce embeddings instead of
+// logits, such as encoder-only models. For these models, Forward returns one
+// pooled embedding of length EmbeddingLength for every index in
+// input.Options.Outputs, computed over the whole sequence containing it.
+type Embedder interface {
+	EmbeddingLength() int
+}
+
 // Base implements the common fields and methods for all models
 type Base struct {
 	b ml.Backend
@@ -0,0 +1,297 @@
+package bert
+
+import (
+	"cmp"
+	"math"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+// Pooling types as stored in the pooling_type GGUF key
+const (
+	poolingNone = iota
+	poolingMean
+	poolingCLS
+	poolingLast
+	poolingRank
+)
+
+type Options struct {
+	hiddenSize, numHeads int
+	eps                  float32
+	ropeBase             float32
+	pooling              uint32
+	normalize            bool
+}
+
+type Model struct {
+	model.Base
+	model.WordPiece
+
+	TokenEmbedding     *nn.Embedding `gguf:"token_embd"`
+	TypeEmbedding      *nn.Embedding `gguf:"token_types"`
+	PositionEmbedding  *nn.Embedding `gguf:"position_embd"`
+	TokenEmbeddingNorm *nn.LayerNorm `gguf:"token_embd_norm"`
+
+	Layers []Layer `gguf:"blk"`
+
+	// cross-encoders score the pooled output with a classification head
+	ClassifierDense  *nn.Linear `gguf:"cls"`
+	ClassifierOutput *nn.Linear `gguf:"cls.output"`
+
+	*Options
+}
+
+var _ model.Embedder = (*Model)(nil)
+
+func New(c ml.Config) (model.Model, error) {
+	m := Model{
+		WordPiece: model.NewWordPiece(
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				BOS:    int32(cmp.Or(c.Uint("tokenizer.ggml.cls_token_id"), c.Uint("tokenizer.ggml.bos_token_id"))),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", true),
+				EOS:    int32(cmp.Or(c.Uint("tokenizer.ggml.seperator_token_id"), c.Uint("tokenizer.ggml.eos_token_id"))),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", true),
+			},
+		),
+		Layers: make([]Layer, c.Uint("block_count")),
+		Options: &Options{
+			hiddenSize: int(c.Uint("embedding_length")),
+			numHeads:   int(c.Uint("attention.head_count")),
+			eps:        c.Float("attention.layer_norm_epsilon", 1e-12),
+			ropeBase:   c.Float("rope.freq_base", 1000.0),
+			pooling:    c.Uint("pooling_type", poolingMean),
+			normalize:  c.Bool("normalize_embeddings", true),
+		},
+	}
+
+	// the cache is only used to mask attention between sequences in the same
+	// batch, encoders attend to the whole sequence and never shift
+	m.Cache = kvcache.NewCausalCache(nil)
+
+	return &m, nil
+}
+
+// EmbeddingLength returns the size of the pooled output, which for
+// cross-encoders is the number of labels produced by the classifier
+func (m *Model) EmbeddingLength() int {
+	if m.pooling == poolingRank {
+		return m.ClassifierOutput.Weight.Dim(1)
+	}
+
+	return m.hiddenSize
+}
+
+type SelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	QKV    *nn.Linear `gguf:"attn_qkv"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	var q, k, v ml.Tensor
+	if sa.QKV != nil {
+		qkv := sa.QKV.Forward(ctx, hiddenState)
+		q = qkv.View(ctx, 0, opts.hiddenSize, qkv.Stride(1), batchSize)
+		k = qkv.View(ctx, opts.hiddenSize*qkv.Stride(0), opts.hiddenSize, qkv.Stride(1), batchSize)
+		v = qkv.View(ctx, 2*opts.hiddenSize*qkv.Stride(0), opts.hiddenSize, qkv.Stride(1), batchSize)
+		q, k, v = q.Contiguous(ctx), k.Contiguous(ctx), v.Contiguous(ctx)
+	} else {
+		q = sa.Query.Forward(ctx, hiddenState)
+		k = sa.Key.Forward(ctx, hiddenState)
+		v = sa.Value.Forward(ctx, hiddenState)
+	}
+
+	q = q.Reshape(ctx, headDim, opts.numHeads, batchSize)
+	k = k.Reshape(ctx, headDim, opts.numHeads, batchSize)
+	v = v.Reshape(ctx, headDim, opts.numHeads, batchSize)
+
+	// nomic-bert style models replace absolute position embeddings with rope
+	if positionIDs != nil {
+		ropeType := uint32(2)
+		q = q.RoPE(ctx, positionIDs, nil, uint32(headDim), ropeType, opts.ropeBase, 1)
+		k = k.RoPE(ctx, positionIDs, nil, uint32(headDim), ropeType, opts.ropeBase, 1)
+	}
+
+	kqv := nn.Attention(ctx, q, k, v, 1.0/math.Sqrt(float64(headDim)), cache)
+	kqv = kqv.Reshape(ctx, opts.hiddenSize, batchSize)
+
+	return sa.Output.Forward(ctx, kqv)
+}
+
+type MLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Gate *nn.Linear `gguf:"ffn_gate"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	if mlp.Gate != nil {
+		hiddenState = mlp.Gate.Forward(ctx, hiddenState).SILU(ctx).Mul(ctx, mlp.Up.Forward(ctx, hiddenState))
+	} else {
+		hiddenState = mlp.Up.Forward(ctx, hiddenState).GELU(ctx)
+	}
+
+	return mlp.Down.Forward(ctx, hiddenState)
+}
+
+type Layer struct {
+	SelfAttention       *SelfAttention
+	AttentionOutputNorm *nn.LayerNorm `gguf:"attn_output_norm"`
+	MLP                 *MLP
+	LayerOutputNorm     *nn.LayerNorm `gguf:"layer_output_norm"`
+}
+
+func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, positionIDs, cache, opts)
+	hiddenState = hiddenState.Add(ctx, residual)
+	hiddenState = l.AttentionOutputNorm.Forward(ctx, hiddenState, opts.eps)
+	residual = hiddenState
+
+	hiddenState = l.MLP.Forward(ctx, hiddenState)
+	hiddenState = hiddenState.Add(ctx, residual)
+	return l.LayerOutputNorm.Forward(ctx, hiddenState, opts.eps)
+}
+
+// poolingWeights builds a batch x outputs matrix that selects or averages the
+// hidden states belonging to the sequence of each requested output
+func (m *Model) poolingWeights(opts input.Options) []float32 {
+	batchSize := len(opts.Inputs)
+	weights := make([]float32, batchSize*len(opts.Outputs))
+
+	for i, output := range opts.Outputs {
+		seq := opts.Sequences[output]
+
+		var members []int
+		for j := range batchSize {
+			if opts.Sequences[j] == seq {
+				members = append(members, j)
+			}
+		}
+
+		row := weights[i*batchSize : (i+1)*batchSize]
+		switch m.pooling {
+		case poolingMean:
+			for _, j := range members {
+				row[j] = 1 / float32(len(members))
+			}
+		case poolingCLS, poolingRank:
+			row[members[0]] = 1
+		default:
+			row[output] = 1
+		}
+	}
+
+	return weights
+}
+
+// tokenTypes assigns the second segment type to every token following the
+// first separator of each sequence, so that cross-encoders can tell the query
+// apart from the document. Other models treat all inputs as the first segment.
+func (m *Model) tokenTypes(opts input.Options) []int32 {
+	types := make([]int32, len(opts.Inputs))
+	if m.pooling != poolingRank || m.TypeEmbedding.Weight.Dim(1) < 2 {
+		return types
+	}
+
+	separated := make(map[int]bool)
+	for i, id := range opts.Inputs {
+		seq := opts.Sequences[i]
+		if separated[seq] {
+			types[i] = 1
+		} else if m.Is(id, model.SpecialEOS) {
+			separated[seq] = true
+		}
+	}
+
+	return types
+}
+
+func (m *Model) Forward(ctx ml.Context, opts input.Options) (ml.Tensor, error) {
+	inputs, err := ctx.Input().FromIntSlice(opts.Inputs, len(opts.Inputs))
+	if err != nil {
+		return nil, err
+	}
+
+	positions, err := ctx.Input().FromIntSlice(opts.Positions, len(opts.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	pooling, err := ctx.Output().FromFloatSlice(m.poolingWeights(opts), len(opts.Inputs), len(opts.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenState := m.TokenEmbedding.Forward(ctx, inputs)
+	if m.TypeEmbedding != nil {
+		typeIDs, err := ctx.Input().FromIntSlice(m.tokenTypes(opts), len(opts.Inputs))
+		if err != nil {
+			return nil, err
+		}
+
+		hiddenState = hiddenState.Add(ctx, m.TypeEmbedding.Forward(ctx, typeIDs))
+	}
+
+	var positionIDs ml.Tensor
+	if m.PositionEmbedding != nil {
+		hiddenState = hiddenState.Add(ctx, m.PositionEmbedding.Forward(ctx, positions))
+	} else {
+		positionIDs = positions
+	}
+
+	hiddenState = m.TokenEmbeddingNorm.Forward(ctx, hiddenState, m.eps)
+
+	// attend to every token in the sequence, not just the preceding ones
+	except := make([]int, len(opts.Inputs))
+	for i := range except {
+		except[i] = i
+	}
+
+	m.Cache.(*kvcache.Causal).SetCausal(ctx, kvcache.CausalOptions{Except: except})
+
+	for i, layer := range m.Layers {
+		m.Cache.SetLayer(i)
+		hiddenState = layer.Forward(ctx, hiddenState, positionIDs, m.Cache, m.Options)
+	}
+
+	return m.pool(ctx, hiddenState, pooling), nil
+}
+
+// pool combines the hidden states of each sequence with the pooling weights
+// into its embedding, or its score for cross-encoders
+func (m *Model) pool(ctx ml.Context, hiddenState, pooling ml.Tensor) ml.Tensor {
+	hiddenState = hiddenState.Permute(ctx, 1, 0, 2, 3).Contiguous(ctx)
+	hiddenState = hiddenState.Mulmat(ctx, pooling)
+
+	if m.pooling == poolingRank {
+		hiddenState = m.ClassifierDense.Forward(ctx, hiddenState).Tanh(ctx)
+		return m.ClassifierOutput.Forward(ctx, hiddenState)
+	}
+
+	if m.normalize {
+		// rms norm without a weight divides by the L2 norm scaled by sqrt(n)
+		hiddenState = hiddenState.RMSNorm(ctx, nil, 1e-12)
+		hiddenState = hiddenState.Scale(ctx, 1/math.Sqrt(float64(m.hiddenSize)))
+	}
+
+	return hiddenState
+}
+
+func init() {
+	model.Register("bert", New)
+	model.Register("nomic-bert", New)
+}
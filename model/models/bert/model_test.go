@@ -0,0 +1,154 @@
+package bert
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/backend/ggml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+// two sequences of three and two inputs, with an output for the last input
+// of each
+var testOptions = input.Options{
+	Inputs:    []int32{1, 5, 2, 1, 6},
+	Sequences: []int{0, 0, 0, 1, 1},
+	Outputs:   []int32{2, 4},
+}
+
+func TestPoolingWeights(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		pooling uint32
+		want    []float32
+	}{
+		{"mean", poolingMean, []float32{1.0 / 3, 1.0 / 3, 1.0 / 3, 0, 0, 0, 0, 0, 0.5, 0.5}},
+		{"cls", poolingCLS, []float32{1, 0, 0, 0, 0, 0, 0, 0, 1, 0}},
+		{"rank", poolingRank, []float32{1, 0, 0, 0, 0, 0, 0, 0, 1, 0}},
+		{"last", poolingLast, []float32{0, 0, 1, 0, 0, 0, 0, 0, 0, 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Model{Options: &Options{pooling: tt.pooling}}
+			if got := m.poolingWeights(testOptions); !slices.Equal(got, tt.want) {
+				t.Errorf("weights = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type testTensor struct {
+	ml.Tensor
+	dims []int
+}
+
+func (t testTensor) Dim(n int) int {
+	return t.dims[n]
+}
+
+func TestTokenTypes(t *testing.T) {
+	m := Model{
+		WordPiece:     model.NewWordPiece(&model.Vocabulary{BOS: 1, EOS: 2, EOT: 2}),
+		TypeEmbedding: &nn.Embedding{Weight: testTensor{dims: []int{4, 2}}},
+		Options:       &Options{pooling: poolingRank},
+	}
+
+	opts := input.Options{
+		Inputs:    []int32{1, 5, 2, 6, 2, 1, 7, 2},
+		Sequences: []int{0, 0, 0, 0, 0, 1, 1, 1},
+	}
+
+	if got, want := m.tokenTypes(opts), []int32{0, 0, 0, 1, 1, 0, 0, 0}; !slices.Equal(got, want) {
+		t.Errorf("types = %v; want %v", got, want)
+	}
+
+	// embedding models put everything in the first segment
+	m.pooling = poolingMean
+	if got, want := m.tokenTypes(opts), make([]int32, 8); !slices.Equal(got, want) {
+		t.Errorf("types = %v; want %v", got, want)
+	}
+}
+
+func newTestBackend(t *testing.T) ml.Backend {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fs.WriteGGUF(f, fs.KV{"general.architecture": "bert"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ggml.New(f, ml.BackendParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPool(t *testing.T) {
+	b := newTestBackend(t)
+
+	// hidden states of size 2 for each input
+	states := []float32{
+		1, 2,
+		3, 4,
+		5, 6,
+		2, 0,
+		4, 0,
+	}
+
+	pool := func(opts *Options) []float32 {
+		t.Helper()
+		ctx := b.NewContext()
+		defer ctx.Close()
+
+		m := Model{Options: opts}
+		hiddenState, err := ctx.Input().FromFloatSlice(states, 2, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pooling, err := ctx.Input().FromFloatSlice(m.poolingWeights(testOptions), 5, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := m.pool(ctx, hiddenState, pooling)
+		ctx.Forward(out).Compute(out)
+		return out.Floats()
+	}
+
+	approx := func(got, want []float32) bool {
+		return slices.EqualFunc(got, want, func(a, b float32) bool {
+			return math.Abs(float64(a-b)) < 1e-5
+		})
+	}
+
+	if got, want := pool(&Options{hiddenSize: 2, pooling: poolingMean}), []float32{3, 4, 3, 0}; !approx(got, want) {
+		t.Errorf("mean = %v; want %v", got, want)
+	}
+
+	// normalized embeddings have unit length
+	if got, want := pool(&Options{hiddenSize: 2, pooling: poolingMean, normalize: true}), []float32{0.6, 0.8, 1, 0}; !approx(got, want) {
+		t.Errorf("normalized mean = %v; want %v", got, want)
+	}
+
+	if got, want := pool(&Options{hiddenSize: 2, pooling: poolingCLS, normalize: true}), []float32{1 / float32(math.Sqrt(5)), 2 / float32(math.Sqrt(5)), 1, 0}; !approx(got, want) {
+		t.Errorf("normalized cls = %v; want %v", got, want)
+	}
+}
@@ -1,8 +1,13 @@
 package models
 
 import (
+	_ "github.com/ollama/ollama/model/models/bert"
 	_ "github.com/ollama/ollama/model/models/gemma2"
 	_ "github.com/ollama/ollama/model/models/gemma3"
 	_ "github.com/ollama/ollama/model/models/llama"
 	_ "github.com/ollama/ollama/model/models/mllama"
+	_ "github.com/ollama/ollama/model/models/pixtral"
+	_ "github.com/ollama/ollama/model/models/qwen2audio"
+	_ "github.com/ollama/ollama/model/models/qwen2vl"
+	_ "github.com/ollama/ollama/model/models/whisper"
 )
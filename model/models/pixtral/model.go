@@ -0,0 +1,170 @@
+package pixtral
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"image"
+	"slices"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+type Model struct {
+	model.Base
+	model.BytePairEncoding
+
+	*VisionModel `gguf:"v,vision"`
+	*TextModel
+
+	*MultiModalProjector `gguf:"mm"`
+
+	ImageProcessor
+
+	imageToken, imageBreakToken, imageEndToken int32
+}
+
+var _ model.MultimodalProcessor = (*Model)(nil)
+
+type MultiModalProjector struct {
+	Linear1 *nn.Linear `gguf:"linear_1"`
+	Linear2 *nn.Linear `gguf:"linear_2"`
+}
+
+func (p *MultiModalProjector) Forward(ctx ml.Context, visionOutputs ml.Tensor) ml.Tensor {
+	visionOutputs = p.Linear1.Forward(ctx, visionOutputs).GELU(ctx)
+	return p.Linear2.Forward(ctx, visionOutputs)
+}
+
+func New(c ml.Config) (model.Model, error) {
+	m := Model{
+		BytePairEncoding: model.NewBytePairEncoding(
+			c.String("tokenizer.ggml.pretokenizer", `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				Merges: c.Strings("tokenizer.ggml.merges"),
+				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id", 1)),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", true),
+				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id", 2)),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+			},
+		),
+		ImageProcessor:      newImageProcessor(c),
+		VisionModel:         newVisionModel(c),
+		TextModel:           newTextModel(c),
+		MultiModalProjector: &MultiModalProjector{},
+
+		imageToken:      int32(c.Uint("vision.image_token_id", 10)),
+		imageBreakToken: int32(c.Uint("vision.image_break_token_id", 12)),
+		imageEndToken:   int32(c.Uint("vision.image_end_token_id", 13)),
+	}
+
+	m.Cache = kvcache.NewCausalCache(m.TextModel.Shift)
+
+	return &m, nil
+}
+
+// EncodeMultimodal returns the image embeddings split into one tensor per row
+// of patches, since the rows are separated by break tokens in the input.
+func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, error) {
+	if len(m.VisionModel.Layers) == 0 {
+		return nil, model.ErrNoVisionModel
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(multimodalData))
+	if err != nil {
+		return nil, err
+	}
+
+	f32s, size, err := m.ImageProcessor.ProcessImage(img)
+	if err != nil {
+		return nil, err
+	}
+
+	pixelValues, err := ctx.Input().FromFloatSlice(f32s, size.X, size.Y, m.ImageProcessor.numChannels)
+	if err != nil {
+		return nil, err
+	}
+
+	visionOutputs := m.VisionModel.Forward(ctx, pixelValues)
+	visionOutputs = m.MultiModalProjector.Forward(ctx, visionOutputs)
+
+	numPatchesW := size.X / m.ImageProcessor.patchSize
+	numPatchesH := size.Y / m.ImageProcessor.patchSize
+
+	rows := make([]ml.Tensor, numPatchesH)
+	for i := range rows {
+		rows[i] = visionOutputs.View(ctx, i*numPatchesW*visionOutputs.Stride(1), visionOutputs.Dim(0), visionOutputs.Stride(1), numPatchesW)
+	}
+
+	return rows, nil
+}
+
+func (m *Model) PostTokenize(inputs []input.Input) ([]input.Input, error) {
+	var result []input.Input
+	fnvHash := fnv.New64a()
+
+	for _, inp := range inputs {
+		if inp.Multimodal == nil {
+			result = append(result, inp)
+		} else {
+			rows := inp.Multimodal.([]ml.Tensor)
+
+			var numTokens int
+			for _, row := range rows {
+				numTokens += row.Dim(1) + 1
+			}
+
+			for i, row := range rows {
+				fnvHash.Reset()
+				binary.Write(fnvHash, binary.NativeEndian, inp.MultimodalHash)
+				binary.Write(fnvHash, binary.NativeEndian, int64(i))
+
+				rowInput := input.Input{Token: m.imageToken, Multimodal: row, MultimodalHash: fnvHash.Sum64()}
+				if i == 0 {
+					rowInput.SameBatch = numTokens - 1
+				}
+
+				// the row data is on the first placeholder
+				result = append(result, rowInput)
+				result = append(result, slices.Repeat([]input.Input{{Token: m.imageToken}}, row.Dim(1)-1)...)
+
+				if i < len(rows)-1 {
+					result = append(result, input.Input{Token: m.imageBreakToken})
+				} else {
+					result = append(result, input.Input{Token: m.imageEndToken})
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (m *Model) Forward(ctx ml.Context, opts input.Options) (ml.Tensor, error) {
+	inputs, err := ctx.Input().FromIntSlice(opts.Inputs, len(opts.Inputs))
+	if err != nil {
+		return nil, err
+	}
+
+	positions, err := ctx.Input().FromIntSlice(opts.Positions, len(opts.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Output().FromIntSlice(opts.Outputs, len(opts.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.TextModel.Forward(ctx, inputs, positions, outputs, opts.Multimodal, m.Cache), nil
+}
+
+func init() {
+	model.Register("pixtral", New)
+}
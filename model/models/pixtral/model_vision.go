@@ -0,0 +1,153 @@
+package pixtral
+
+import (
+	"math"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+const ropeTypeNormal = 0
+
+type VisionSelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+// rope2D rotates the first half of each head by the row position of the patch
+// and the second half by the column position, as build_rope_2d in llama.cpp.
+// Rotating half of the head with normal rope only uses every other frequency
+// of the full head, so the column frequencies are scaled by base^(-2/headDim)
+// to get the ones in between. This only matches Pixtral's rotate_half for
+// queries and keys whose projections were permuted when converting.
+func rope2D(ctx ml.Context, t, rows, cols ml.Tensor, base float32) ml.Tensor {
+	headDim := t.Dim(0)
+
+	first := t.View(ctx, 0, headDim/2, t.Stride(1), t.Dim(1), t.Stride(2), t.Dim(2))
+	first = first.RoPE(ctx, rows, nil, uint32(headDim/2), ropeTypeNormal, base, 1)
+
+	// rope doesn't handle views which don't start at the beginning of a row
+	second := t.View(ctx, headDim/2*t.Stride(0), headDim/2, t.Stride(1), t.Dim(1), t.Stride(2), t.Dim(2))
+	second = second.Contiguous(ctx)
+	second = second.RoPE(ctx, cols, nil, uint32(headDim/2), ropeTypeNormal, base, float32(math.Pow(float64(base), -2.0/float64(headDim))))
+
+	return first.Concat(ctx, second, 0)
+}
+
+func (sa *VisionSelfAttention) Forward(ctx ml.Context, hiddenState, rows, cols ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	headDim := opts.hiddenSize / opts.numHeads
+	numPatches := hiddenState.Dim(1)
+
+	query := sa.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, headDim, opts.numHeads, numPatches)
+	query = rope2D(ctx, query, rows, cols, opts.ropeBase)
+
+	key := sa.Key.Forward(ctx, hiddenState)
+	key = key.Reshape(ctx, headDim, opts.numHeads, numPatches)
+	key = rope2D(ctx, key, rows, cols, opts.ropeBase)
+
+	value := sa.Value.Forward(ctx, hiddenState)
+	value = value.Reshape(ctx, headDim, opts.numHeads, numPatches)
+
+	attention := nn.Attention(ctx, query, key, value, 1.0/math.Sqrt(float64(headDim)), nil)
+	attention = attention.Reshape(ctx, opts.hiddenSize, numPatches)
+
+	return sa.Output.Forward(ctx, attention)
+}
+
+type VisionMLP struct {
+	Gate *nn.Linear `gguf:"ffn_gate"`
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *VisionMLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	hiddenState = mlp.Gate.Forward(ctx, hiddenState).SILU(ctx).Mul(ctx, mlp.Up.Forward(ctx, hiddenState))
+	return mlp.Down.Forward(ctx, hiddenState)
+}
+
+type VisionEncoderLayer struct {
+	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
+	SelfAttention *VisionSelfAttention
+
+	MLPNorm *nn.RMSNorm `gguf:"ffn_norm"`
+	MLP     *VisionMLP
+}
+
+func (e *VisionEncoderLayer) Forward(ctx ml.Context, hiddenState, rows, cols ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = e.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.SelfAttention.Forward(ctx, hiddenState, rows, cols, opts)
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = e.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+type VisionModelOptions struct {
+	hiddenSize, numHeads int
+	patchSize            int
+	eps, ropeBase        float32
+}
+
+type VisionModel struct {
+	PatchEmbedding *nn.Conv2D  `gguf:"patch_conv"`
+	EncoderNorm    *nn.RMSNorm `gguf:"encoder_norm"`
+
+	Layers []VisionEncoderLayer `gguf:"blk"`
+
+	*VisionModelOptions
+}
+
+// Forward returns one embedding per patch in row-major order
+func (m *VisionModel) Forward(ctx ml.Context, pixelValues ml.Tensor) ml.Tensor {
+	numPatchesW := pixelValues.Dim(0) / m.patchSize
+	numPatchesH := pixelValues.Dim(1) / m.patchSize
+	numPatches := numPatchesW * numPatchesH
+
+	hiddenState := m.PatchEmbedding.Forward(ctx, pixelValues, m.patchSize, m.patchSize, 0, 0, 1, 1)
+	hiddenState = hiddenState.Reshape(ctx, numPatches, m.hiddenSize)
+	hiddenState = hiddenState.Permute(ctx, 1, 0, 2, 3).Contiguous(ctx)
+	hiddenState = m.EncoderNorm.Forward(ctx, hiddenState, m.eps)
+
+	rows := make([]int32, numPatches)
+	cols := make([]int32, numPatches)
+	for i := range numPatches {
+		rows[i] = int32(i / numPatchesW)
+		cols[i] = int32(i % numPatchesW)
+	}
+
+	rowIDs, err := ctx.Input().FromIntSlice(rows, len(rows))
+	if err != nil {
+		panic(err)
+	}
+
+	colIDs, err := ctx.Input().FromIntSlice(cols, len(cols))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, layer := range m.Layers {
+		hiddenState = layer.Forward(ctx, hiddenState, rowIDs, colIDs, m.VisionModelOptions)
+	}
+
+	return hiddenState
+}
+
+func newVisionModel(c ml.Config) *VisionModel {
+	return &VisionModel{
+		Layers: make([]VisionEncoderLayer, c.Uint("vision.block_count")),
+		VisionModelOptions: &VisionModelOptions{
+			hiddenSize: int(c.Uint("vision.embedding_length")),
+			numHeads:   int(c.Uint("vision.attention.head_count")),
+			patchSize:  int(c.Uint("vision.patch_size", 16)),
+			eps:        c.Float("vision.attention.layer_norm_epsilon", 1e-5),
+			ropeBase:   c.Float("vision.rope.freq_base", 10000.0),
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package pixtral
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/backend/ggml"
+)
+
+func newTestBackend(t *testing.T) ml.Backend {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fs.WriteGGUF(f, fs.KV{"general.architecture": "pixtral"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ggml.New(f, ml.BackendParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// rotateHalf applies Pixtral's 2D rope as the reference implementation does
+// to the head x of the patch at row and col: the first quarter of the
+// frequencies come from the even frequencies of the head rotated by the row
+// and the second quarter from the odd ones rotated by the column, and each
+// value is rotated with the one half a head away.
+func rotateHalf(x []float32, row, col int, base float64) []float32 {
+	headDim := len(x)
+	out := make([]float32, headDim)
+	for i := range headDim / 2 {
+		var theta float64
+		if i < headDim/4 {
+			theta = float64(row) * math.Pow(base, -float64(4*i)/float64(headDim))
+		} else {
+			theta = float64(col) * math.Pow(base, -float64(4*(i-headDim/4)+2)/float64(headDim))
+		}
+
+		sin, cos := math.Sincos(theta)
+		a, b := float64(x[i]), float64(x[i+headDim/2])
+		out[i] = float32(a*cos - b*sin)
+		out[i+headDim/2] = float32(b*cos + a*sin)
+	}
+	return out
+}
+
+// permute interleaves the two halves of the head x, as the query and key
+// projections are permuted when converting
+func permute(x []float32) []float32 {
+	out := make([]float32, len(x))
+	for i := range len(x) / 2 {
+		out[2*i] = x[i]
+		out[2*i+1] = x[i+len(x)/2]
+	}
+	return out
+}
+
+func TestRope2D(t *testing.T) {
+	b := newTestBackend(t)
+	ctx := b.NewContext()
+	defer ctx.Close()
+
+	const (
+		headDim, numHeads = 8, 2
+		numPatchesW       = 3
+		numPatchesH       = 2
+		numPatches        = numPatchesW * numPatchesH
+		base              = 100
+	)
+
+	var heads [][]float32
+	var values []float32
+	for i := range numPatches * numHeads {
+		head := make([]float32, headDim)
+		for j := range head {
+			head[j] = float32(math.Sin(float64(i*headDim + j + 1)))
+		}
+		heads = append(heads, head)
+		values = append(values, permute(head)...)
+	}
+
+	rows := make([]int32, numPatches)
+	cols := make([]int32, numPatches)
+	for i := range numPatches {
+		rows[i] = int32(i / numPatchesW)
+		cols[i] = int32(i % numPatchesW)
+	}
+
+	x, err := ctx.Input().FromFloatSlice(values, headDim, numHeads, numPatches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rowIDs, err := ctx.Input().FromIntSlice(rows, numPatches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	colIDs, err := ctx.Input().FromIntSlice(cols, numPatches)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := rope2D(ctx, x, rowIDs, colIDs, base)
+	if got := []int{out.Dim(0), out.Dim(1), out.Dim(2)}; got[0] != headDim || got[1] != numHeads || got[2] != numPatches {
+		t.Fatalf("shape = %v; want [%d %d %d]", got, headDim, numHeads, numPatches)
+	}
+
+	ctx.Forward(out).Compute(out)
+	got := out.Floats()
+
+	for i, head := range heads {
+		patch := i / numHeads
+		want := permute(rotateHalf(head, int(rows[patch]), int(cols[patch]), base))
+		for j := range want {
+			if math.Abs(float64(got[i*headDim+j]-want[j])) > 1e-4 {
+				t.Fatalf("patch %d head %d = %v; want %v", patch, i%numHeads, got[i*headDim:][:headDim], want)
+			}
+		}
+	}
+}
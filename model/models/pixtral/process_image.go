@@ -0,0 +1,31 @@
+package pixtral
+
+import (
+	"image"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/imageproc"
+)
+
+type ImageProcessor struct {
+	imageSize, patchSize, numChannels int
+}
+
+func newImageProcessor(c ml.Config) ImageProcessor {
+	return ImageProcessor{
+		imageSize:   int(c.Uint("vision.image_size", 1024)),
+		patchSize:   int(c.Uint("vision.patch_size", 16)),
+		numChannels: int(c.Uint("vision.num_channels", 3)),
+	}
+}
+
+// ProcessImage resizes the image so that its longest edge fits within imageSize
+// and both sides are a multiple of patchSize. It returns the normalized, channel
+// first pixel values together with the resulting image size.
+func (p ImageProcessor) ProcessImage(img image.Image) ([]float32, image.Point, error) {
+	img = imageproc.Composite(img)
+	img = resizeImage(img, "", p.imageSize, image.Point{p.patchSize, p.patchSize})
+
+	data := imageproc.Normalize(img, imageproc.ClipDefaultMean, imageproc.ClipDefaultSTD, true, true)
+	return data, img.Bounds().Size(), nil
+}
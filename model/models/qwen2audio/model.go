@@ -0,0 +1,148 @@
+package qwen2audio
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"slices"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/model/models/whisper"
+)
+
+type Model struct {
+	model.Base
+	model.BytePairEncoding
+
+	AudioEncoder *whisper.Encoder `gguf:"a"`
+	*TextModel
+
+	*MultiModalProjector `gguf:"mm"`
+
+	whisper.AudioProcessor
+
+	audioToken, audioStartToken, audioEndToken int32
+}
+
+var _ model.MultimodalProcessor = (*Model)(nil)
+
+type MultiModalProjector struct {
+	Linear *nn.Linear `gguf:"linear"`
+}
+
+func (p *MultiModalProjector) Forward(ctx ml.Context, audioOutputs ml.Tensor) ml.Tensor {
+	return p.Linear.Forward(ctx, audioOutputs)
+}
+
+func New(c ml.Config) (model.Model, error) {
+	m := Model{
+		BytePairEncoding: model.NewBytePairEncoding(
+			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				Merges: c.Strings("tokenizer.ggml.merges"),
+				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", false),
+				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+			},
+		),
+		// the audio tower averages pairs of encoder frames before projecting them
+		AudioEncoder:        whisper.NewEncoder(c, 2),
+		TextModel:           newTextModel(c),
+		MultiModalProjector: &MultiModalProjector{},
+		AudioProcessor:      whisper.NewAudioProcessor(c),
+
+		audioToken:      int32(c.Uint("audio.audio_token_id", 151646)),
+		audioStartToken: int32(c.Uint("audio.audio_start_token_id", 151647)),
+		audioEndToken:   int32(c.Uint("audio.audio_end_token_id", 151648)),
+	}
+
+	m.Cache = kvcache.NewCausalCache(m.TextModel.Shift)
+
+	return &m, nil
+}
+
+// EncodeMultimodal returns the audio embeddings split into one tensor per
+// chunk of audio, trimmed to exclude the padding of the last chunk
+func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, error) {
+	if m.AudioEncoder == nil || len(m.AudioEncoder.Layers) == 0 {
+		return nil, model.ErrNoAudioModel
+	}
+
+	chunks, err := m.AudioProcessor.ProcessAudio(multimodalData)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]ml.Tensor, len(chunks))
+	for i, chunk := range chunks {
+		mel, err := ctx.Input().FromFloatSlice(chunk.Data, m.AudioProcessor.NumFrames(), len(chunk.Data)/m.AudioProcessor.NumFrames())
+		if err != nil {
+			return nil, err
+		}
+
+		audioOutputs := m.AudioEncoder.Forward(ctx, mel)
+		audioOutputs = m.MultiModalProjector.Forward(ctx, audioOutputs)
+
+		n := min(m.AudioEncoder.NumOutputs(chunk.Frames), audioOutputs.Dim(1))
+		outputs[i] = audioOutputs.View(ctx, 0, audioOutputs.Dim(0), audioOutputs.Stride(1), n)
+	}
+
+	return outputs, nil
+}
+
+// PostTokenize wraps each audio clip in start and end tokens and expands every
+// chunk into one placeholder token per embedding
+func (m *Model) PostTokenize(inputs []input.Input) ([]input.Input, error) {
+	var result []input.Input
+	fnvHash := fnv.New64a()
+
+	for _, inp := range inputs {
+		if inp.Multimodal == nil {
+			result = append(result, inp)
+			continue
+		}
+
+		result = append(result, input.Input{Token: m.audioStartToken})
+		for i, chunk := range inp.Multimodal.([]ml.Tensor) {
+			fnvHash.Reset()
+			binary.Write(fnvHash, binary.NativeEndian, inp.MultimodalHash)
+			binary.Write(fnvHash, binary.NativeEndian, int64(i))
+
+			// the chunk data is on the first placeholder
+			result = append(result, input.Input{Token: m.audioToken, Multimodal: chunk, MultimodalHash: fnvHash.Sum64(), SameBatch: chunk.Dim(1) - 1})
+			result = append(result, slices.Repeat([]input.Input{{Token: m.audioToken}}, chunk.Dim(1)-1)...)
+		}
+		result = append(result, input.Input{Token: m.audioEndToken})
+	}
+
+	return result, nil
+}
+
+func (m *Model) Forward(ctx ml.Context, opts input.Options) (ml.Tensor, error) {
+	inputs, err := ctx.Input().FromIntSlice(opts.Inputs, len(opts.Inputs))
+	if err != nil {
+		return nil, err
+	}
+
+	positions, err := ctx.Input().FromIntSlice(opts.Positions, len(opts.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Output().FromIntSlice(opts.Outputs, len(opts.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.TextModel.Forward(ctx, inputs, positions, outputs, opts.Multimodal, m.Cache), nil
+}
+
+func init() {
+	model.Register("qwen2audio", New)
+}
@@ -0,0 +1,186 @@
+package qwen2vl
+
+import (
+	"bytes"
+	"image"
+	"slices"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+type Model struct {
+	model.Base
+	model.BytePairEncoding
+
+	*VisionModel `gguf:"v,vision"`
+	*TextModel
+
+	*PatchMerger `gguf:"mm"`
+
+	ImageProcessor
+
+	visionStartToken, visionEndToken, imageToken int32
+
+	ropeIndex *ropeIndex
+}
+
+var _ model.MultimodalProcessor = (*Model)(nil)
+
+// imageFeatures holds the projected embeddings for an image along with the
+// size of the image measured in merged patches, which is needed to compute
+// the 2D positions of the image tokens.
+type imageFeatures struct {
+	ml.Tensor
+	grid image.Point
+}
+
+func New(c ml.Config) (model.Model, error) {
+	m := Model{
+		BytePairEncoding: model.NewBytePairEncoding(
+			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				Merges: c.Strings("tokenizer.ggml.merges"),
+				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", false),
+				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+			},
+		),
+		ImageProcessor: newImageProcessor(c),
+		VisionModel:    newVisionModel(c),
+		TextModel:      newTextModel(c),
+		PatchMerger:    &PatchMerger{},
+
+		visionStartToken: int32(c.Uint("vision.start_token_id", 151652)),
+		visionEndToken:   int32(c.Uint("vision.end_token_id", 151653)),
+		imageToken:       int32(c.Uint("vision.image_token_id", 151655)),
+	}
+
+	m.ropeIndex = newRopeIndex(kvcache.NewCausalCache(m.TextModel.Shift))
+	m.Cache = m.ropeIndex
+
+	return &m, nil
+}
+
+func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, error) {
+	if len(m.VisionModel.Layers) == 0 {
+		return nil, model.ErrNoVisionModel
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(multimodalData))
+	if err != nil {
+		return nil, err
+	}
+
+	f32s, size, err := m.ImageProcessor.ProcessImage(img)
+	if err != nil {
+		return nil, err
+	}
+
+	pixelValues, err := ctx.Input().FromFloatSlice(f32s, size.X, size.Y, m.ImageProcessor.numChannels)
+	if err != nil {
+		return nil, err
+	}
+
+	visionOutputs := m.VisionModel.Forward(ctx, pixelValues)
+	visionOutputs = m.PatchMerger.Forward(ctx, visionOutputs, m.VisionModel.VisionModelOptions)
+
+	factor := m.ImageProcessor.patchSize * m.ImageProcessor.mergeSize
+	return &imageFeatures{
+		Tensor: visionOutputs,
+		grid:   image.Point{size.X / factor, size.Y / factor},
+	}, nil
+}
+
+func (m *Model) PostTokenize(inputs []input.Input) ([]input.Input, error) {
+	var result []input.Input
+
+	for _, inp := range inputs {
+		if inp.Multimodal == nil {
+			result = append(result, inp)
+		} else {
+			features := inp.Multimodal.(*imageFeatures)
+			numTokens := features.Dim(1)
+
+			result = append(result,
+				input.Input{Token: m.visionStartToken, SameBatch: numTokens + 1},
+				input.Input{Token: m.imageToken, Multimodal: features, MultimodalHash: inp.MultimodalHash},
+			)
+
+			// add image token placeholders, the image data is on the first one
+			result = append(result, slices.Repeat([]input.Input{{Token: m.imageToken}}, numTokens-1)...)
+
+			result = append(result, input.Input{Token: m.visionEndToken})
+		}
+	}
+
+	return result, nil
+}
+
+// positions expands the sequential positions assigned by the runner into the
+// four sections used by M-RoPE. Text tokens use the same position in every
+// section while image tokens share the temporal position of the first image
+// token and are offset by their row and column within the image. The text
+// after an image continues from the largest of those positions.
+func (m *Model) positions(opts input.Options) []int32 {
+	for _, mi := range opts.Multimodal {
+		features := mi.Multimodal.(*imageFeatures)
+		numTokens := int32(features.Dim(1))
+		m.ropeIndex.add(opts.Sequences[mi.Index], opts.Positions[mi.Index]+numTokens-1,
+			int32(max(features.grid.X, features.grid.Y))-numTokens)
+	}
+
+	n := len(opts.Positions)
+
+	positions := make([]int32, 4*n)
+	for i, p := range opts.Positions {
+		p += m.ropeIndex.offset(opts.Sequences[i], p)
+		positions[i] = p
+		positions[n+i] = p
+		positions[2*n+i] = p
+		positions[3*n+i] = p
+	}
+
+	for _, mi := range opts.Multimodal {
+		features := mi.Multimodal.(*imageFeatures)
+		start := positions[mi.Index]
+		for j := range features.Dim(1) {
+			i := mi.Index + j
+			positions[i] = start
+			positions[n+i] = start + int32(j/features.grid.X)
+			positions[2*n+i] = start + int32(j%features.grid.X)
+			positions[3*n+i] = start
+		}
+	}
+
+	return positions
+}
+
+func (m *Model) Forward(ctx ml.Context, opts input.Options) (ml.Tensor, error) {
+	inputs, err := ctx.Input().FromIntSlice(opts.Inputs, len(opts.Inputs))
+	if err != nil {
+		return nil, err
+	}
+
+	positions := m.positions(opts)
+	positionIDs, err := ctx.Input().FromIntSlice(positions, len(positions))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Output().FromIntSlice(opts.Outputs, len(opts.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.TextModel.Forward(ctx, inputs, positionIDs, outputs, opts.Multimodal, m.Cache), nil
+}
+
+func init() {
+	model.Register("qwen2vl", New)
+}
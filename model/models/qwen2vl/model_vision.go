@@ -0,0 +1,167 @@
+package qwen2vl
+
+import (
+	"math"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+const ropeTypeVision = 24
+
+type VisionSelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *VisionSelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	headDim := opts.hiddenSize / opts.numHeads
+	numPatches := hiddenState.Dim(1)
+
+	// the vision tower rotates half of each head by row position and the other
+	// half by column position
+	sections := [4]int{headDim / 4, headDim / 4, headDim / 4, headDim / 4}
+
+	query := sa.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, headDim, opts.numHeads, numPatches)
+	query = query.RoPEMulti(ctx, positionIDs, nil, uint32(headDim/2), sections, ropeTypeVision, opts.ropeBase, 1)
+
+	key := sa.Key.Forward(ctx, hiddenState)
+	key = key.Reshape(ctx, headDim, opts.numHeads, numPatches)
+	key = key.RoPEMulti(ctx, positionIDs, nil, uint32(headDim/2), sections, ropeTypeVision, opts.ropeBase, 1)
+
+	value := sa.Value.Forward(ctx, hiddenState)
+	value = value.Reshape(ctx, headDim, opts.numHeads, numPatches)
+
+	attention := nn.Attention(ctx, query, key, value, 1.0/math.Sqrt(float64(headDim)), nil)
+	attention = attention.Reshape(ctx, opts.hiddenSize, numPatches)
+
+	return sa.Output.Forward(ctx, attention)
+}
+
+type VisionMLP struct {
+	FC1 *nn.Linear `gguf:"fc1"`
+	FC2 *nn.Linear `gguf:"fc2"`
+}
+
+func (mlp *VisionMLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	hiddenState = mlp.FC1.Forward(ctx, hiddenState).QuickGELU(ctx)
+	return mlp.FC2.Forward(ctx, hiddenState)
+}
+
+type VisionEncoderLayer struct {
+	LayerNorm1    *nn.LayerNorm `gguf:"layer_norm1"`
+	SelfAttention *VisionSelfAttention
+
+	LayerNorm2 *nn.LayerNorm `gguf:"layer_norm2"`
+	MLP        *VisionMLP    `gguf:"mlp"`
+}
+
+func (e *VisionEncoderLayer) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = e.LayerNorm1.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.SelfAttention.Forward(ctx, hiddenState, positionIDs, opts)
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = e.LayerNorm2.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+// PatchMerger concatenates each 2x2 group of neighbouring patches and projects
+// them into the text embedding space
+type PatchMerger struct {
+	LayerNorm *nn.LayerNorm `gguf:"ln_q"`
+	FC1       *nn.Linear    `gguf:"0"`
+	FC2       *nn.Linear    `gguf:"2"`
+}
+
+func (m *PatchMerger) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	mergedSize := opts.hiddenSize * opts.spatialMergeSize * opts.spatialMergeSize
+
+	hiddenState = m.LayerNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = hiddenState.Reshape(ctx, mergedSize, hiddenState.Dim(1)/(opts.spatialMergeSize*opts.spatialMergeSize))
+	hiddenState = m.FC1.Forward(ctx, hiddenState).GELU(ctx)
+	return m.FC2.Forward(ctx, hiddenState)
+}
+
+type VisionModelOptions struct {
+	hiddenSize, numHeads int
+	patchSize            int
+	spatialMergeSize     int
+	eps, ropeBase        float32
+}
+
+type VisionModel struct {
+	// PatchEmbedding is the 3D convolution over pairs of frames of the
+	// reference implementation, folded by the converter into a 2D
+	// convolution over a single image
+	PatchEmbedding *nn.Conv2D `gguf:"patch_embedding"`
+
+	Layers []VisionEncoderLayer `gguf:"blk"`
+
+	*VisionModelOptions
+}
+
+// Forward runs the vision tower over pixelValues, returning one embedding per
+// patch. Patches are ordered so that each spatialMergeSize x spatialMergeSize
+// window is contiguous, which is the layout expected by the PatchMerger.
+func (m *VisionModel) Forward(ctx ml.Context, pixelValues ml.Tensor) ml.Tensor {
+	numPatchesW := pixelValues.Dim(0) / m.patchSize
+	numPatchesH := pixelValues.Dim(1) / m.patchSize
+	numPatches := numPatchesW * numPatchesH
+
+	hiddenState := m.PatchEmbedding.Forward(ctx, pixelValues, m.patchSize, m.patchSize, 0, 0, 1, 1)
+	hiddenState = hiddenState.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+
+	// regroup patches from raster order into merge windows
+	merge := m.spatialMergeSize
+	hiddenState = hiddenState.Reshape(ctx, m.hiddenSize*merge, numPatchesW/merge, merge, numPatchesH/merge)
+	hiddenState = hiddenState.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+	hiddenState = hiddenState.Reshape(ctx, m.hiddenSize, numPatches)
+
+	positions := make([]int32, 4*numPatches)
+	var i int
+	for y := 0; y < numPatchesH; y += merge {
+		for x := 0; x < numPatchesW; x += merge {
+			for dy := range merge {
+				for dx := range merge {
+					positions[i] = int32(y + dy)
+					positions[numPatches+i] = int32(x + dx)
+					positions[2*numPatches+i] = int32(y + dy)
+					positions[3*numPatches+i] = int32(x + dx)
+					i++
+				}
+			}
+		}
+	}
+
+	positionIDs, err := ctx.Input().FromIntSlice(positions, len(positions))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, layer := range m.Layers {
+		hiddenState = layer.Forward(ctx, hiddenState, positionIDs, m.VisionModelOptions)
+	}
+
+	return hiddenState
+}
+
+func newVisionModel(c ml.Config) *VisionModel {
+	return &VisionModel{
+		Layers: make([]VisionEncoderLayer, c.Uint("vision.block_count")),
+		VisionModelOptions: &VisionModelOptions{
+			hiddenSize:       int(c.Uint("vision.embedding_length")),
+			numHeads:         int(c.Uint("vision.attention.head_count")),
+			patchSize:        int(c.Uint("vision.patch_size", 14)),
+			spatialMergeSize: int(c.Uint("vision.spatial_merge_size", 2)),
+			eps:              c.Float("vision.attention.layer_norm_epsilon", 1e-6),
+			ropeBase:         c.Float("vision.rope.freq_base", 10000.0),
+		},
+	}
+}
@@ -0,0 +1,46 @@
+package qwen2vl
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/imageproc"
+)
+
+type ImageProcessor struct {
+	patchSize, mergeSize, numChannels int
+	minPixels, maxPixels              int
+}
+
+func newImageProcessor(c ml.Config) ImageProcessor {
+	return ImageProcessor{
+		patchSize:   int(c.Uint("vision.patch_size", 14)),
+		mergeSize:   int(c.Uint("vision.spatial_merge_size", 2)),
+		numChannels: int(c.Uint("vision.num_channels", 3)),
+		minPixels:   int(c.Uint("vision.min_pixels", DefaultMinPixels)),
+		maxPixels:   int(c.Uint("vision.max_pixels", DefaultMaxPixels)),
+	}
+}
+
+// ProcessImage resizes the image so that both sides are a multiple of the merged
+// patch size and returns the normalized, channel first pixel values together with
+// the resulting image size.
+func (p ImageProcessor) ProcessImage(img image.Image) ([]float32, image.Point, error) {
+	factor := p.patchSize * p.mergeSize
+
+	size := img.Bounds().Size()
+	if size.X < factor || size.Y < factor {
+		return nil, image.Point{}, fmt.Errorf("image is too small: %dx%d, minimum is %dx%d", size.X, size.Y, factor, factor)
+	} else if max(size.X, size.Y)/min(size.X, size.Y) > 200 {
+		return nil, image.Point{}, fmt.Errorf("image aspect ratio must be less than 200:1")
+	}
+
+	outputSize := smartResize(size, factor, p.minPixels, p.maxPixels)
+
+	img = imageproc.Composite(img)
+	img = imageproc.Resize(img, outputSize, imageproc.ResizeBilinear)
+
+	data := imageproc.Normalize(img, imageproc.ClipDefaultMean, imageproc.ClipDefaultSTD, true, true)
+	return data, outputSize, nil
+}
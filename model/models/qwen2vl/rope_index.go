@@ -0,0 +1,110 @@
+package qwen2vl
+
+import (
+	"math"
+	"slices"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+// ropeIndex tracks how far the M-RoPE positions of each sequence are from the
+// sequential positions assigned by the runner. An image takes up a position
+// per token in the cache but, as in get_rope_index of the reference
+// implementation, only advances the positions of the text after it by the
+// larger side of its grid.
+//
+// It wraps the cache so that the offsets follow the tokens of a sequence as
+// they are removed, shifted or copied to another sequence.
+type ropeIndex struct {
+	kvcache.Cache
+
+	// offsets holds the offsets of each sequence in order of position.
+	// Each applies to the tokens after its position, up to the next one.
+	offsets map[int][]ropeOffset
+}
+
+type ropeOffset struct {
+	pos, offset int32
+}
+
+func newRopeIndex(c kvcache.Cache) *ropeIndex {
+	return &ropeIndex{Cache: c, offsets: make(map[int][]ropeOffset)}
+}
+
+// offset returns the offset of the token at pos in seq
+func (r *ropeIndex) offset(seq int, pos int32) int32 {
+	var offset int32
+	for _, o := range r.offsets[seq] {
+		if o.pos >= pos {
+			break
+		}
+		offset = o.offset
+	}
+
+	return offset
+}
+
+// add moves the tokens of seq after pos by delta, in addition to any earlier
+// offsets. Calls must be in order of position.
+func (r *ropeIndex) add(seq int, pos, delta int32) {
+	r.offsets[seq] = append(r.offsets[seq], ropeOffset{pos: pos, offset: r.offset(seq, pos+1) + delta})
+}
+
+// truncate forgets the offsets of the tokens of seq from pos onwards
+func (r *ropeIndex) truncate(seq int, pos int32) {
+	r.offsets[seq] = slices.DeleteFunc(r.offsets[seq], func(o ropeOffset) bool {
+		return o.pos >= pos
+	})
+}
+
+// StartForward forgets the offsets of tokens that are being processed again,
+// such as after a prompt diverged from the cached one
+func (r *ropeIndex) StartForward(ctx ml.Context, opts input.Options) error {
+	for i, pos := range opts.Positions {
+		r.truncate(opts.Sequences[i], pos)
+	}
+
+	return r.Cache.StartForward(ctx, opts)
+}
+
+func (r *ropeIndex) CopyPrefix(srcSeq, dstSeq int, len int32) {
+	r.Cache.CopyPrefix(srcSeq, dstSeq, len)
+
+	r.offsets[dstSeq] = slices.DeleteFunc(slices.Clone(r.offsets[srcSeq]), func(o ropeOffset) bool {
+		return o.pos >= len
+	})
+}
+
+func (r *ropeIndex) Remove(seq int, beginIndex, endIndex int32) error {
+	if err := r.Cache.Remove(seq, beginIndex, endIndex); err != nil {
+		return err
+	}
+
+	if endIndex == math.MaxInt32 {
+		r.truncate(seq, beginIndex)
+		return nil
+	}
+
+	// the tokens after the removed ones are shifted down, keeping their
+	// offsets, including those of images that were removed
+	var offsets []ropeOffset
+	for _, o := range r.offsets[seq] {
+		if o.pos >= endIndex {
+			o.pos -= endIndex - beginIndex
+		} else if o.pos >= beginIndex {
+			o.pos = beginIndex - 1
+		}
+
+		// later offsets include the earlier ones at the same position
+		if n := len(offsets); n > 0 && offsets[n-1].pos == o.pos {
+			offsets[n-1] = o
+		} else {
+			offsets = append(offsets, o)
+		}
+	}
+
+	r.offsets[seq] = offsets
+	return nil
+}
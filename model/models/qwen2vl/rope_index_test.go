@@ -0,0 +1,101 @@
+package qwen2vl
+
+import (
+	"image"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+type testCache struct {
+	kvcache.Cache
+}
+
+func (testCache) StartForward(ml.Context, input.Options) error { return nil }
+func (testCache) CopyPrefix(int, int, int32)                   {}
+func (testCache) Remove(int, int32, int32) error               { return nil }
+
+type testTensor struct {
+	ml.Tensor
+	dims []int
+}
+
+func (t testTensor) Dim(n int) int {
+	return t.dims[n]
+}
+
+func TestPositions(t *testing.T) {
+	m := Model{ropeIndex: newRopeIndex(testCache{})}
+
+	forward := func(seq int, positions []int32, multimodal ...input.MultimodalIndex) []int32 {
+		t.Helper()
+		opts := input.Options{Positions: positions, Multimodal: multimodal}
+		for range positions {
+			opts.Sequences = append(opts.Sequences, seq)
+		}
+
+		if err := m.ropeIndex.StartForward(nil, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		return m.positions(opts)
+	}
+
+	// two text tokens, an image of 3x2 merged patches and two more tokens
+	image := input.MultimodalIndex{Index: 2, Multimodal: &imageFeatures{
+		Tensor: testTensor{dims: []int{8, 6}},
+		grid:   image.Point{3, 2},
+	}}
+
+	got := forward(0, []int32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, image)
+	want := []int32{
+		0, 1, 2, 2, 2, 2, 2, 2, 5, 6, // temporal
+		0, 1, 2, 2, 2, 3, 3, 3, 5, 6, // height
+		0, 1, 2, 3, 4, 2, 3, 4, 5, 6, // width
+		0, 1, 2, 2, 2, 2, 2, 2, 5, 6,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("positions = %v; want %v", got, want)
+	}
+
+	// text in later batches continues after the image
+	if got := forward(0, []int32{10}); !slices.Equal(got, []int32{7, 7, 7, 7}) {
+		t.Errorf("positions = %v; want 7", got)
+	}
+
+	// other sequences are unaffected, unless they share the prefix
+	if got := forward(1, []int32{10}); !slices.Equal(got, []int32{10, 10, 10, 10}) {
+		t.Errorf("positions = %v; want 10", got)
+	}
+
+	m.ropeIndex.CopyPrefix(0, 2, 9)
+	if got := forward(2, []int32{9}); !slices.Equal(got, []int32{6, 6, 6, 6}) {
+		t.Errorf("positions = %v; want 6", got)
+	}
+
+	// shifting the context keeps the offset of the removed image
+	if err := m.ropeIndex.Remove(0, 1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := forward(0, []int32{7}); !slices.Equal(got, []int32{4, 4, 4, 4}) {
+		t.Errorf("positions = %v; want 4", got)
+	}
+
+	// processing the image again replaces its offset
+	if err := m.ropeIndex.Remove(0, 2, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+	image.Index = 0
+	if got := forward(0, []int32{2, 3, 4, 5, 6, 7, 8, 9}, image); got[6] != 5 || got[7] != 6 {
+		t.Errorf("positions = %v; want text at 5 and 6", got)
+	}
+
+	// reprocessing tokens drops the offsets after them
+	if got := forward(0, []int32{2}); !slices.Equal(got, []int32{2, 2, 2, 2}) {
+		t.Errorf("positions = %v; want 2", got)
+	}
+}
@@ -0,0 +1,123 @@
+package whisper
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+// ErrAudioTooLong is returned when a clip doesn't fit in a single chunk. Longer
+// audio has to be split and transcribed one chunk at a time.
+var ErrAudioTooLong = errors.New("audio is longer than 30 seconds")
+
+type Model struct {
+	model.Base
+	model.BytePairEncoding
+
+	*Encoder `gguf:"a"`
+	*Decoder
+
+	AudioProcessor
+}
+
+var _ model.MultimodalProcessor = (*Model)(nil)
+
+func New(c ml.Config) (model.Model, error) {
+	m := Model{
+		BytePairEncoding: model.NewBytePairEncoding(
+			c.String("tokenizer.ggml.pretokenizer", `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`),
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				Merges: c.Strings("tokenizer.ggml.merges"),
+				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", false),
+				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+			},
+		),
+		Encoder:        NewEncoder(c, 1),
+		Decoder:        newDecoder(c),
+		AudioProcessor: NewAudioProcessor(c),
+	}
+
+	encoderCache := kvcache.NewEncoderCache()
+	encoderCache.SetConfig(ml.CacheConfig{})
+	m.Cache = kvcache.NewWrapperCache(encoderCache, kvcache.NewCausalCache(m.Decoder.Shift))
+
+	return &m, nil
+}
+
+func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, error) {
+	if len(m.Encoder.Layers) == 0 {
+		return nil, model.ErrNoAudioModel
+	}
+
+	chunks, err := m.AudioProcessor.ProcessAudio(multimodalData)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunks) > 1 {
+		return nil, ErrAudioTooLong
+	}
+
+	mel, err := ctx.Input().FromFloatSlice(chunks[0].Data, m.AudioProcessor.NumFrames(), len(chunks[0].Data)/m.AudioProcessor.NumFrames())
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Encoder.Forward(ctx, mel), nil
+}
+
+// PostTokenize moves the encoded audio onto the token that follows it, since
+// the decoder only sees the audio through cross attention
+func (m *Model) PostTokenize(inputs []input.Input) ([]input.Input, error) {
+	var audio *input.Input
+	for i := range inputs {
+		if inputs[i].Multimodal == nil {
+			if audio != nil {
+				inputs[i].Multimodal = audio.Multimodal
+				inputs[i].MultimodalHash = audio.MultimodalHash
+				audio = nil
+			}
+		} else {
+			audio = &input.Input{Multimodal: inputs[i].Multimodal, MultimodalHash: inputs[i].MultimodalHash}
+			inputs[i].Token = -1
+		}
+	}
+
+	return slices.DeleteFunc(inputs, func(input input.Input) bool { return input.Token == -1 }), nil
+}
+
+func (m *Model) Forward(ctx ml.Context, opts input.Options) (ml.Tensor, error) {
+	var encoderOutputs ml.Tensor
+	if len(opts.Multimodal) > 0 {
+		encoderOutputs = opts.Multimodal[len(opts.Multimodal)-1].Multimodal.(ml.Tensor)
+	}
+
+	inputs, err := ctx.Input().FromIntSlice(opts.Inputs, len(opts.Inputs))
+	if err != nil {
+		return nil, err
+	}
+
+	positions, err := ctx.Input().FromIntSlice(opts.Positions, len(opts.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Output().FromIntSlice(opts.Outputs, len(opts.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Decoder.Forward(ctx, inputs, positions, outputs, encoderOutputs, m.Cache.(*kvcache.WrapperCache)), nil
+}
+
+func init() {
+	model.Register("whisper", New)
+}
@@ -0,0 +1,178 @@
+package whisper
+
+import (
+	"math"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+const (
+	crossAttentionLayer = iota
+	selfAttentionLayer
+)
+
+type DecoderSelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *DecoderSelfAttention) Forward(ctx ml.Context, hiddenState ml.Tensor, cache *kvcache.WrapperCache, opts *DecoderOptions) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	query := sa.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, headDim, opts.numHeads, batchSize)
+
+	key := sa.Key.Forward(ctx, hiddenState)
+	key = key.Reshape(ctx, headDim, opts.numHeads, batchSize)
+
+	value := sa.Value.Forward(ctx, hiddenState)
+	value = value.Reshape(ctx, headDim, opts.numHeads, batchSize)
+
+	attention := nn.Attention(ctx, query, key, value, 1.0/math.Sqrt(float64(headDim)), cache)
+	attention = attention.Reshape(ctx, opts.hiddenSize, batchSize)
+
+	return sa.Output.Forward(ctx, attention)
+}
+
+type DecoderCrossAttention struct {
+	Query  *nn.Linear `gguf:"cross_attn_q"`
+	Key    *nn.Linear `gguf:"cross_attn_k"`
+	Value  *nn.Linear `gguf:"cross_attn_v"`
+	Output *nn.Linear `gguf:"cross_attn_output"`
+}
+
+func (ca *DecoderCrossAttention) Forward(ctx ml.Context, hiddenState, encoderOutputs ml.Tensor, cache *kvcache.WrapperCache, opts *DecoderOptions) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	query := ca.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, headDim, opts.numHeads, batchSize)
+
+	if encoderOutputs != nil {
+		numFrames := encoderOutputs.Dim(1)
+
+		key := ca.Key.Forward(ctx, encoderOutputs)
+		key = key.Reshape(ctx, headDim, opts.numHeads, numFrames)
+
+		value := ca.Value.Forward(ctx, encoderOutputs)
+		value = value.Reshape(ctx, headDim, opts.numHeads, numFrames)
+
+		cache.Put(ctx, key, value)
+	}
+
+	key, value, _ := cache.Get(ctx)
+
+	query = query.Permute(ctx, 0, 2, 1, 3)
+	key = key.Permute(ctx, 0, 2, 1, 3)
+	value = value.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+
+	kq := key.MulmatFullPrec(ctx, query)
+	kq = kq.Scale(ctx, 1.0/math.Sqrt(float64(headDim)))
+	kq = kq.Softmax(ctx)
+
+	kqv := value.Mulmat(ctx, kq)
+	attention := kqv.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+	attention = attention.Reshape(ctx, opts.hiddenSize, batchSize)
+
+	return ca.Output.Forward(ctx, attention)
+}
+
+type DecoderLayer struct {
+	AttentionNorm *nn.LayerNorm `gguf:"attn_norm"`
+	SelfAttention *DecoderSelfAttention
+
+	CrossAttentionNorm *nn.LayerNorm `gguf:"cross_attn_norm"`
+	CrossAttention     *DecoderCrossAttention
+
+	MLPNorm *nn.LayerNorm `gguf:"ffn_norm"`
+	MLP     *EncoderMLP
+}
+
+func (d *DecoderLayer) Forward(ctx ml.Context, hiddenState, outputs, encoderOutputs ml.Tensor, cache *kvcache.WrapperCache, opts *DecoderOptions) ml.Tensor {
+	residual := hiddenState
+
+	cache.SetLayerType(selfAttentionLayer)
+	hiddenState = d.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = d.SelfAttention.Forward(ctx, hiddenState, cache, opts)
+
+	// In the final layer (outputs != nil), optimize by pruning to just the token positions
+	// we need logits for.
+	if outputs != nil {
+		hiddenState = hiddenState.Rows(ctx, outputs)
+		residual = residual.Rows(ctx, outputs)
+	}
+
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	// the decoder can't attend to audio that hasn't been encoded yet
+	if encoderOutputs != nil || cache.UnderlyingCache().(*kvcache.EncoderCache).EncoderCached() {
+		cache.SetLayerType(crossAttentionLayer)
+		hiddenState = d.CrossAttentionNorm.Forward(ctx, hiddenState, opts.eps)
+		hiddenState = d.CrossAttention.Forward(ctx, hiddenState, encoderOutputs, cache, opts)
+		hiddenState = hiddenState.Add(ctx, residual)
+		residual = hiddenState
+	}
+
+	hiddenState = d.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = d.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+type DecoderOptions struct {
+	hiddenSize, numHeads int
+	eps                  float32
+}
+
+// Decoder is the Whisper text decoder, which predicts tokens from the encoded
+// audio through cross attention
+type Decoder struct {
+	TokenEmbedding    *nn.Embedding  `gguf:"token_embd"`
+	PositionEmbedding *nn.Embedding  `gguf:"position_embd"`
+	Layers            []DecoderLayer `gguf:"blk"`
+	OutputNorm        *nn.LayerNorm  `gguf:"output_norm"`
+	Output            *nn.Linear     `gguf:"output,alt:token_embd"`
+
+	*DecoderOptions
+}
+
+func (d *Decoder) Forward(ctx ml.Context, inputs, positions, outputs, encoderOutputs ml.Tensor, cache *kvcache.WrapperCache) ml.Tensor {
+	hiddenState := d.TokenEmbedding.Forward(ctx, inputs)
+	hiddenState = hiddenState.Add(ctx, d.PositionEmbedding.Forward(ctx, positions))
+
+	for i, layer := range d.Layers {
+		cache.SetLayer(i)
+
+		var lastLayerOutputs ml.Tensor
+		if i == len(d.Layers)-1 {
+			lastLayerOutputs = outputs
+		}
+
+		hiddenState = layer.Forward(ctx, hiddenState, lastLayerOutputs, encoderOutputs, cache, d.DecoderOptions)
+	}
+
+	hiddenState = d.OutputNorm.Forward(ctx, hiddenState, d.eps)
+	return d.Output.Forward(ctx, hiddenState)
+}
+
+// Shift leaves keys unchanged since positions are learned embeddings added to
+// the input rather than rotations of the keys
+func (d *Decoder) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
+	return key, nil
+}
+
+func newDecoder(c ml.Config) *Decoder {
+	return &Decoder{
+		Layers: make([]DecoderLayer, c.Uint("block_count")),
+		DecoderOptions: &DecoderOptions{
+			hiddenSize: int(c.Uint("embedding_length")),
+			numHeads:   int(c.Uint("attention.head_count")),
+			eps:        c.Float("attention.layer_norm_epsilon", 1e-5),
+		},
+	}
+}
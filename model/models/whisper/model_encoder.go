@@ -0,0 +1,141 @@
+package whisper
+
+import (
+	"math"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+type EncoderSelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *EncoderSelfAttention) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *EncoderOptions) ml.Tensor {
+	headDim := opts.hiddenSize / opts.numHeads
+	numFrames := hiddenState.Dim(1)
+
+	query := sa.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, headDim, opts.numHeads, numFrames)
+
+	key := sa.Key.Forward(ctx, hiddenState)
+	key = key.Reshape(ctx, headDim, opts.numHeads, numFrames)
+
+	value := sa.Value.Forward(ctx, hiddenState)
+	value = value.Reshape(ctx, headDim, opts.numHeads, numFrames)
+
+	attention := nn.Attention(ctx, query, key, value, 1.0/math.Sqrt(float64(headDim)), nil)
+	attention = attention.Reshape(ctx, opts.hiddenSize, numFrames)
+
+	return sa.Output.Forward(ctx, attention)
+}
+
+type EncoderMLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *EncoderMLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	return mlp.Down.Forward(ctx, mlp.Up.Forward(ctx, hiddenState).GELU(ctx))
+}
+
+type EncoderLayer struct {
+	AttentionNorm *nn.LayerNorm `gguf:"attn_norm"`
+	SelfAttention *EncoderSelfAttention
+
+	MLPNorm *nn.LayerNorm `gguf:"ffn_norm"`
+	MLP     *EncoderMLP
+}
+
+func (e *EncoderLayer) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *EncoderOptions) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = e.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.SelfAttention.Forward(ctx, hiddenState, opts)
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = e.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+type EncoderOptions struct {
+	hiddenSize, numHeads int
+	eps                  float32
+
+	// poolSize is the stride of the average pooling applied to the frames
+	// before the final norm, 1 disables pooling
+	poolSize int
+}
+
+// Encoder is the Whisper audio encoder, which turns a log-mel spectrogram into
+// one embedding per pair of frames
+type Encoder struct {
+	Conv1             *nn.Conv1D    `gguf:"conv1d.0"`
+	Conv2             *nn.Conv1D    `gguf:"conv1d.1"`
+	PositionEmbedding *nn.Embedding `gguf:"position_embd"`
+
+	Layers []EncoderLayer `gguf:"blk"`
+
+	OutputNorm *nn.LayerNorm `gguf:"output_norm"`
+
+	*EncoderOptions
+}
+
+// Forward encodes mel, laid out as [frames, mel bins], into a [hidden size,
+// frames / (2 * pool size)] tensor
+func (e *Encoder) Forward(ctx ml.Context, mel ml.Tensor) ml.Tensor {
+	hiddenState := e.Conv1.Forward(ctx, mel, 1, 1, 1).GELU(ctx)
+	hiddenState = e.Conv2.Forward(ctx, hiddenState, 2, 1, 1).GELU(ctx)
+	hiddenState = hiddenState.Permute(ctx, 1, 0, 2, 3).Contiguous(ctx)
+
+	numFrames := hiddenState.Dim(1)
+	positions := e.PositionEmbedding.Weight.View(ctx, 0, e.hiddenSize, e.PositionEmbedding.Weight.Stride(1), numFrames)
+	hiddenState = hiddenState.Add(ctx, positions)
+
+	for _, layer := range e.Layers {
+		hiddenState = layer.Forward(ctx, hiddenState, e.EncoderOptions)
+	}
+
+	if e.poolSize > 1 {
+		// average neighboring frames by viewing each group as a single row
+		numFrames = numFrames / e.poolSize
+		rows := hiddenState.View(ctx, 0, e.hiddenSize*e.poolSize, hiddenState.Stride(1)*e.poolSize, numFrames)
+
+		pooled := rows.View(ctx, 0, e.hiddenSize, rows.Stride(1), numFrames)
+		for i := 1; i < e.poolSize; i++ {
+			pooled = pooled.Add(ctx, rows.View(ctx, i*e.hiddenSize*rows.Stride(0), e.hiddenSize, rows.Stride(1), numFrames))
+		}
+
+		hiddenState = pooled.Scale(ctx, 1/float64(e.poolSize))
+	}
+
+	return e.OutputNorm.Forward(ctx, hiddenState, e.eps)
+}
+
+// NumOutputs returns the number of encoder outputs that correspond to the
+// given number of spectrogram frames, excluding those that only cover padding
+func (e *Encoder) NumOutputs(numFrames int) int {
+	n := (numFrames-1)/2 + 1
+	if e.poolSize > 1 {
+		n = (n-e.poolSize)/e.poolSize + 1
+	}
+
+	return max(n, 1)
+}
+
+func NewEncoder(c ml.Config, poolSize int) *Encoder {
+	return &Encoder{
+		Layers: make([]EncoderLayer, c.Uint("audio.block_count")),
+		EncoderOptions: &EncoderOptions{
+			hiddenSize: int(c.Uint("audio.embedding_length")),
+			numHeads:   int(c.Uint("audio.attention.head_count")),
+			eps:        c.Float("audio.attention.layer_norm_epsilon", 1e-5),
+			poolSize:   int(c.Uint("audio.pooling_size", uint32(poolSize))),
+		},
+	}
+}
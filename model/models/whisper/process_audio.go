@@ -0,0 +1,107 @@
+package whisper
+
+import (
+	"bytes"
+	"math"
+	"slices"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/audioproc"
+)
+
+const (
+	SampleRate = 16000
+
+	// ChunkLength is the length in seconds of the audio the encoder processes
+	// at once. Shorter audio is padded and longer audio is split.
+	ChunkLength = 30
+)
+
+// Chunk is the log-mel spectrogram of ChunkLength seconds of audio
+type Chunk struct {
+	// Data holds the spectrogram laid out as [frames, mel bins]
+	Data []float32
+
+	// Frames is the number of frames covering the audio, excluding padding
+	Frames int
+}
+
+type AudioProcessor struct {
+	nMels, nFFT, hopLength int
+
+	window  []float32
+	filters [][]float32
+}
+
+func NewAudioProcessor(c ml.Config) AudioProcessor {
+	nFFT := int(c.Uint("audio.n_fft", 400))
+	nMels := int(c.Uint("audio.num_mel_bins", 80))
+	return AudioProcessor{
+		nMels:     nMels,
+		nFFT:      nFFT,
+		hopLength: int(c.Uint("audio.hop_length", 160)),
+		window:    audioproc.HannWindow(nFFT),
+		filters:   audioproc.MelFilterBank(SampleRate, nFFT, nMels),
+	}
+}
+
+// NumFrames is the number of spectrogram frames in a full chunk
+func (p AudioProcessor) NumFrames() int {
+	return ChunkLength * SampleRate / p.hopLength
+}
+
+// ProcessAudio decodes a WAV or FLAC file and returns the normalized log-mel
+// spectrograms of each chunk of the resampled audio
+func (p AudioProcessor) ProcessAudio(bts []byte) ([]Chunk, error) {
+	samples, sampleRate, err := audioproc.Decode(bytes.NewReader(bts))
+	if err != nil {
+		return nil, err
+	}
+
+	samples = audioproc.Resample(samples, sampleRate, SampleRate)
+
+	var chunks []Chunk
+	for chunk := range slices.Chunk(samples, ChunkLength*SampleRate) {
+		chunks = append(chunks, p.logMel(chunk))
+	}
+
+	if len(chunks) == 0 {
+		chunks = append(chunks, p.logMel(nil))
+	}
+
+	return chunks, nil
+}
+
+// logMel pads samples to a full chunk and computes its log-mel spectrogram,
+// clamped to 8 orders of magnitude below the peak and scaled to about [-1, 1]
+func (p AudioProcessor) logMel(samples []float32) Chunk {
+	padded := make([]float32, ChunkLength*SampleRate)
+	copy(padded, samples)
+
+	numFrames := p.NumFrames()
+	spectrogram := audioproc.PowerSpectrogram(padded, p.nFFT, p.hopLength, p.window)[:numFrames]
+
+	data := make([]float32, numFrames*p.nMels)
+	peak := math.Inf(-1)
+	for m, filter := range p.filters {
+		for t, frame := range spectrogram {
+			var energy float32
+			for k, w := range filter {
+				energy += w * frame[k]
+			}
+
+			v := math.Log10(max(float64(energy), 1e-10))
+			data[m*numFrames+t] = float32(v)
+			peak = max(peak, v)
+		}
+	}
+
+	for i, v := range data {
+		data[i] = (max(v, float32(peak-8)) + 4) / 4
+	}
+
+	return Chunk{
+		Data:   data,
+		Frames: min(numFrames, max(len(samples)/p.hopLength, 1)),
+	}
+}
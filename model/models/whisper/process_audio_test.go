@@ -0,0 +1,77 @@
+package whisper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ollama/ollama/model/audioproc"
+)
+
+func TestProcessAudio(t *testing.T) {
+	p := AudioProcessor{
+		nMels:     80,
+		nFFT:      400,
+		hopLength: 160,
+		window:    audioproc.HannWindow(400),
+		filters:   audioproc.MelFilterBank(SampleRate, 400, 80),
+	}
+
+	// 31 seconds of 8 kHz silence, which is resampled and split into two chunks
+	const sampleRate = 8000
+	samples := make([]int16, 31*sampleRate)
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+2*len(samples)))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(sampleRate), uint32(2 * sampleRate), uint16(2), uint16(16)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(2*len(samples)))
+	binary.Write(&b, binary.LittleEndian, samples)
+
+	chunks, err := p.ProcessAudio(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunks) != 2 {
+		t.Fatalf("chunks = %d, want 2", len(chunks))
+	}
+
+	for i, want := range []int{3000, 100} {
+		if len(chunks[i].Data) != 3000*80 {
+			t.Errorf("chunk %d has %d values, want %d", i, len(chunks[i].Data), 3000*80)
+		}
+
+		if chunks[i].Frames != want {
+			t.Errorf("chunk %d has %d frames, want %d", i, chunks[i].Frames, want)
+		}
+	}
+
+	// silence is clamped to the peak, which normalizes to (log10(1e-10) + 4) / 4
+	if got := chunks[1].Data[0]; got != -1.5 {
+		t.Errorf("value = %v, want -1.5", got)
+	}
+}
+
+func TestNumOutputs(t *testing.T) {
+	cases := []struct {
+		poolSize, frames, want int
+	}{
+		{1, 3000, 1500},
+		{2, 3000, 750},
+		{2, 100, 25},
+		{1, 1, 1},
+		{2, 1, 1},
+	}
+
+	for _, tt := range cases {
+		e := Encoder{EncoderOptions: &EncoderOptions{poolSize: tt.poolSize}}
+		if got := e.NumOutputs(tt.frames); got != tt.want {
+			t.Errorf("NumOutputs(%d) with pool size %d = %d, want %d", tt.frames, tt.poolSize, got, tt.want)
+		}
+	}
+}
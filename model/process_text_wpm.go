@@ -0,0 +1,162 @@
+package model
+
+import (
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+// WordPiece implements the greedy longest-match-first tokenizer used by BERT
+// style models. Vocabulary entries that begin a word are expected to carry the
+// phantom space prefix (▁) while continuation pieces are stored as-is.
+type WordPiece struct {
+	vocab *Vocabulary
+}
+
+var _ TextProcessor = (*WordPiece)(nil)
+
+func NewWordPiece(vocab *Vocabulary) WordPiece {
+	return WordPiece{vocab: vocab}
+}
+
+func (wpm WordPiece) Is(id int32, special Special) bool {
+	return wpm.vocab.Is(id, special)
+}
+
+// words splits s into lowercased words, separating punctuation and CJK
+// characters into words of their own as BERT's basic tokenizer does.
+func (wpm WordPiece) words(s string) []string {
+	var words []string
+	var sb strings.Builder
+
+	flush := func() {
+		if sb.Len() > 0 {
+			words = append(words, sb.String())
+			sb.Reset()
+		}
+	}
+
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == 0 || r == unicode.ReplacementChar || unicode.IsControl(r):
+			// skip invalid and control characters
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.Is(unicode.Han, r):
+			flush()
+			words = append(words, string(r))
+		default:
+			sb.WriteRune(unicode.ToLower(r))
+		}
+	}
+
+	flush()
+	return words
+}
+
+func (wpm WordPiece) Encode(s string, addSpecial bool) ([]int32, error) {
+	fragments := []fragment{{value: s}}
+	for _, special := range wpm.vocab.SpecialVocabulary() {
+		id := wpm.vocab.Encode(special)
+		for i := 0; i < len(fragments); i++ {
+			frag := fragments[i]
+			if len(frag.ids) > 0 {
+				continue
+			}
+
+			var middle []fragment
+			switch i := strings.Index(frag.value, special); {
+			case i < 0:
+				middle = append(middle, frag)
+			case i > 0:
+				middle = append(middle, fragment{value: frag.value[:i]})
+				fallthrough
+			default:
+				middle = append(middle, fragment{value: special, ids: []int32{id}})
+				if rest := frag.value[i+len(special):]; rest != "" {
+					middle = append(middle, fragment{value: rest})
+				}
+			}
+
+			fragments = append(fragments[:i], append(middle, fragments[i+1:]...)...)
+		}
+	}
+
+	unknown := wpm.vocab.Encode("[UNK]")
+
+	var ids []int32
+	for _, frag := range fragments {
+		if len(frag.ids) > 0 {
+			ids = append(ids, frag.ids...)
+			continue
+		}
+
+		for _, word := range wpm.words(frag.value) {
+			var pieces []int32
+			for start := 0; start < len(word); {
+				end := len(word)
+				for ; end > start; end-- {
+					piece := word[start:end]
+					if start == 0 {
+						piece = spmWhitespaceSep + piece
+					}
+
+					if id := wpm.vocab.Encode(piece); id >= 0 {
+						pieces = append(pieces, id)
+						break
+					}
+				}
+
+				if end == start {
+					// no prefix of the remaining word is in the vocabulary
+					pieces = nil
+					break
+				}
+
+				start = end
+			}
+
+			if pieces == nil {
+				if unknown >= 0 {
+					ids = append(ids, unknown)
+				}
+				continue
+			}
+
+			ids = append(ids, pieces...)
+		}
+	}
+
+	if addSpecial && len(ids) > 0 {
+		if wpm.vocab.AddBOS {
+			slog.Debug("adding bos token to prompt", "id", wpm.vocab.BOS)
+			ids = append([]int32{wpm.vocab.BOS}, ids...)
+		}
+
+		if wpm.vocab.AddEOS {
+			slog.Debug("adding eos token to prompt", "id", wpm.vocab.EOS)
+			ids = append(ids, wpm.vocab.EOS)
+		}
+	}
+
+	return ids, nil
+}
+
+func (wpm WordPiece) Decode(ids []int32) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		piece := wpm.vocab.Decode(id)
+		if rest, ok := strings.CutPrefix(piece, spmWhitespaceSep); ok {
+			if sb.Len() > 0 {
+				sb.WriteString(" ")
+			}
+			piece = rest
+		}
+
+		if _, err := sb.WriteString(piece); err != nil {
+			return "", err
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func wordPiece(t testing.TB) WordPiece {
+	t.Helper()
+
+	values := []string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "▁hello", "▁world", "▁un", "believ", "able", "▁!", "▁,"}
+	types := make([]uint32, len(values))
+	for i := range types {
+		types[i] = TOKEN_TYPE_NORMAL
+		if i < 4 {
+			types[i] = TOKEN_TYPE_CONTROL
+		}
+	}
+
+	return NewWordPiece(&Vocabulary{
+		Values: values,
+		Types:  types,
+		BOS:    2,
+		AddBOS: true,
+		EOS:    3,
+		AddEOS: true,
+	})
+}
+
+func TestWordPiece(t *testing.T) {
+	tokenizer := wordPiece(t)
+
+	cases := []struct {
+		in         string
+		addSpecial bool
+		want       []int32
+	}{
+		{"hello world", false, []int32{4, 5}},
+		{"Hello, World!", false, []int32{4, 10, 5, 9}},
+		{"unbelievable", false, []int32{6, 7, 8}},
+		{"hello xyz", false, []int32{4, 1}},
+		{"hello world", true, []int32{2, 4, 5, 3}},
+		{"hello[SEP]world", false, []int32{4, 3, 5}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			ids, err := tokenizer.Encode(tt.in, tt.addSpecial)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("Encode(%q) = %v, want %v", tt.in, ids, tt.want)
+			}
+		})
+	}
+
+	t.Run("decode", func(t *testing.T) {
+		s, err := tokenizer.Decode([]int32{4, 6, 7, 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s != "hello unbelievable" {
+			t.Errorf("Decode() = %q, want %q", s, "hello unbelievable")
+		}
+	})
+}
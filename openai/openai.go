@@ -11,6 +11,8 @@ import (
 	"log/slog"
 	"math/rand"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -193,6 +195,25 @@ type EmbeddingUsage struct {
 	TotalTokens  int `json:"total_tokens"`
 }
 
+type TranscriptionSegment struct {
+	Id    int     `json:"id"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+type Transcription struct {
+	Text string `json:"text"`
+}
+
+type VerboseTranscription struct {
+	Task     string                 `json:"task"`
+	Language string                 `json:"language"`
+	Duration float64                `json:"duration"`
+	Text     string                 `json:"text"`
+	Segments []TranscriptionSegment `json:"segments"`
+}
+
 func NewError(code int, message string) ErrorResponse {
 	var etype string
 	switch code {
@@ -383,6 +404,49 @@ func toEmbeddingList(model string, r api.EmbedResponse) EmbeddingList {
 	return EmbeddingList{}
 }
 
+func toVerboseTranscription(r api.TranscribeResponse) VerboseTranscription {
+	segments := make([]TranscriptionSegment, len(r.Segments))
+	for i, s := range r.Segments {
+		segments[i] = TranscriptionSegment{Id: i, Start: s.Start, End: s.End, Text: s.Text}
+	}
+
+	return VerboseTranscription{
+		Task:     "transcribe",
+		Language: r.Language,
+		Duration: r.Duration,
+		Text:     r.Text,
+		Segments: segments,
+	}
+}
+
+// toSubtitles formats the segments as SubRip (srt) or WebVTT (vtt) captions
+func toSubtitles(format string, r api.TranscribeResponse) string {
+	timestamp := func(t float64) string {
+		ms := int64(t*1000 + 0.5)
+		sep := ","
+		if format == "vtt" {
+			sep = "."
+		}
+
+		return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+	}
+
+	var sb strings.Builder
+	if format == "vtt" {
+		sb.WriteString("WEBVTT\n\n")
+	}
+
+	for i, s := range r.Segments {
+		if format == "srt" {
+			fmt.Fprintf(&sb, "%d\n", i+1)
+		}
+
+		fmt.Fprintf(&sb, "%s --> %s\n%s\n\n", timestamp(s.Start), timestamp(s.End), s.Text)
+	}
+
+	return sb.String()
+}
+
 func toModel(r api.ShowResponse, m string) Model {
 	return Model{
 		Id:      m,
@@ -444,6 +508,27 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 					}
 
 					messages = append(messages, api.Message{Role: msg.Role, Images: []api.ImageData{img}})
+				case "input_audio":
+					inputAudio, ok := data["input_audio"].(map[string]any)
+					if !ok {
+						return nil, errors.New("invalid message format")
+					}
+
+					encoded, ok := inputAudio["data"].(string)
+					if !ok {
+						return nil, errors.New("invalid message format")
+					}
+
+					if format, _ := inputAudio["format"].(string); !slices.Contains([]string{"wav", "flac"}, format) {
+						return nil, errors.New("invalid audio input, expected wav or flac format")
+					}
+
+					audio, err := base64.StdEncoding.DecodeString(encoded)
+					if err != nil {
+						return nil, errors.New("invalid message format")
+					}
+
+					messages = append(messages, api.Message{Role: msg.Role, Audio: []api.AudioData{audio}})
 				default:
 					return nil, errors.New("invalid message format")
 				}
@@ -615,6 +700,11 @@ type EmbedWriter struct {
 	model string
 }
 
+type TranscriptionWriter struct {
+	BaseWriter
+	format string
+}
+
 func (w *BaseWriter) writeError(data []byte) (int, error) {
 	var serr api.StatusError
 	err := json.Unmarshal(data, &serr)
@@ -839,6 +929,46 @@ func (w *EmbedWriter) Write(data []byte) (int, error) {
 	return w.writeResponse(data)
 }
 
+func (w *TranscriptionWriter) writeResponse(data []byte) (int, error) {
+	var transcribeResponse api.TranscribeResponse
+	err := json.Unmarshal(data, &transcribeResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	switch w.format {
+	case "text", "srt", "vtt":
+		text := transcribeResponse.Text + "\n"
+		if w.format != "text" {
+			text = toSubtitles(w.format, transcribeResponse)
+		}
+
+		w.ResponseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = w.ResponseWriter.Write([]byte(text))
+	case "verbose_json":
+		w.ResponseWriter.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w.ResponseWriter).Encode(toVerboseTranscription(transcribeResponse))
+	default:
+		w.ResponseWriter.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w.ResponseWriter).Encode(Transcription{Text: transcribeResponse.Text})
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *TranscriptionWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(data)
+	}
+
+	return w.writeResponse(data)
+}
+
 func ListMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		w := &ListWriter{
@@ -950,6 +1080,80 @@ func EmbeddingsMiddleware() gin.HandlerFunc {
 	}
 }
 
+// TranscriptionsMiddleware converts the multipart form upload of
+// /v1/audio/transcriptions into a request for /api/transcribe
+func TranscriptionsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "file is required"))
+			return
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		defer f.Close()
+
+		audio, err := io.ReadAll(f)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		format := c.DefaultPostForm("response_format", "json")
+		if !slices.Contains([]string{"json", "text", "srt", "verbose_json", "vtt"}, format) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, fmt.Sprintf("invalid response_format %q", format)))
+			return
+		}
+
+		granularities := c.PostFormArray("timestamp_granularities[]")
+		if slices.Contains(granularities, "word") {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "word timestamps are not supported"))
+			return
+		}
+
+		req := api.TranscribeRequest{
+			Model:      c.PostForm("model"),
+			Audio:      audio,
+			Language:   c.PostForm("language"),
+			Prompt:     c.PostForm("prompt"),
+			Timestamps: format != "json" && format != "text" || slices.Contains(granularities, "segment"),
+			Options:    map[string]any{},
+		}
+
+		if v := c.PostForm("temperature"); v != "" {
+			temperature, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "invalid temperature"))
+				return
+			}
+
+			req.Options["temperature"] = temperature
+		}
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(req); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(&b)
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		w := &TranscriptionWriter{
+			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
+			format:     format,
+		}
+
+		c.Writer = w
+
+		c.Next()
+	}
+}
+
 func ChatMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req ChatCompletionRequest
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -197,6 +198,74 @@ func TestChatMiddleware(t *testing.T) {
 				Stream: &False,
 			},
 		},
+		{
+			name: "chat handler with audio content",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{
+						"role": "user",
+						"content": [
+							{
+								"type": "text",
+								"text": "What is said here?"
+							},
+							{
+								"type": "input_audio",
+								"input_audio": {
+									"data": "UklGRg==",
+									"format": "wav"
+								}
+							}
+						]
+					}
+				]
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "What is said here?",
+					},
+					{
+						Role:  "user",
+						Audio: []api.AudioData{[]byte("RIFF")},
+					},
+				},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream: &False,
+			},
+		},
+		{
+			name: "chat handler with unsupported audio format",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{
+						"role": "user",
+						"content": [
+							{
+								"type": "input_audio",
+								"input_audio": {
+									"data": "UklGRg==",
+									"format": "mp3"
+								}
+							}
+						]
+					}
+				]
+			}`,
+			err: ErrorResponse{
+				Error: Error{
+					Message: "invalid audio input, expected wav or flac format",
+					Type:    "invalid_request_error",
+				},
+			},
+		},
 		{
 			name: "chat handler with tools",
 			body: `{
@@ -590,6 +659,164 @@ func TestEmbeddingsMiddleware(t *testing.T) {
 	}
 }
 
+func TestTranscriptionsMiddleware(t *testing.T) {
+	type testCase struct {
+		name   string
+		fields map[string][]string
+		file   []byte
+		req    api.TranscribeRequest
+		resp   string
+		err    ErrorResponse
+	}
+
+	var capturedRequest *api.TranscribeRequest
+
+	testCases := []testCase{
+		{
+			name:   "transcription json",
+			fields: map[string][]string{"model": {"test-model"}},
+			file:   []byte("RIFF"),
+			req: api.TranscribeRequest{
+				Model:   "test-model",
+				Audio:   []byte("RIFF"),
+				Options: map[string]any{},
+			},
+			resp: `{"text":"Hello there. General Kenobi."}` + "\n",
+		},
+		{
+			name:   "transcription text with language and temperature",
+			fields: map[string][]string{"model": {"test-model"}, "language": {"en"}, "response_format": {"text"}, "temperature": {"0.2"}},
+			file:   []byte("RIFF"),
+			req: api.TranscribeRequest{
+				Model:    "test-model",
+				Audio:    []byte("RIFF"),
+				Language: "en",
+				Options:  map[string]any{"temperature": 0.2},
+			},
+			resp: "Hello there. General Kenobi.\n",
+		},
+		{
+			name:   "transcription srt",
+			fields: map[string][]string{"model": {"test-model"}, "response_format": {"srt"}},
+			file:   []byte("RIFF"),
+			req: api.TranscribeRequest{
+				Model:      "test-model",
+				Audio:      []byte("RIFF"),
+				Timestamps: true,
+				Options:    map[string]any{},
+			},
+			resp: "1\n00:00:00,000 --> 00:00:01,500\nHello there.\n\n2\n00:00:01,500 --> 00:01:02,250\nGeneral Kenobi.\n\n",
+		},
+		{
+			name:   "transcription verbose json",
+			fields: map[string][]string{"model": {"test-model"}, "response_format": {"verbose_json"}, "timestamp_granularities[]": {"segment"}},
+			file:   []byte("RIFF"),
+			req: api.TranscribeRequest{
+				Model:      "test-model",
+				Audio:      []byte("RIFF"),
+				Timestamps: true,
+				Options:    map[string]any{},
+			},
+			resp: `{"task":"transcribe","language":"en","duration":62.25,"text":"Hello there. General Kenobi.","segments":[{"id":0,"start":0,"end":1.5,"text":"Hello there."},{"id":1,"start":1.5,"end":62.25,"text":"General Kenobi."}]}` + "\n",
+		},
+		{
+			name:   "transcription missing file",
+			fields: map[string][]string{"model": {"test-model"}},
+			err: ErrorResponse{
+				Error: Error{
+					Message: "file is required",
+					Type:    "invalid_request_error",
+				},
+			},
+		},
+		{
+			name:   "transcription word timestamps",
+			fields: map[string][]string{"model": {"test-model"}, "timestamp_granularities[]": {"word"}},
+			file:   []byte("RIFF"),
+			err: ErrorResponse{
+				Error: Error{
+					Message: "word timestamps are not supported",
+					Type:    "invalid_request_error",
+				},
+			},
+		},
+	}
+
+	endpoint := func(c *gin.Context) {
+		c.JSON(http.StatusOK, api.TranscribeResponse{
+			Model:    "test-model",
+			Text:     "Hello there. General Kenobi.",
+			Language: "en",
+			Duration: 62.25,
+			Segments: []api.TranscribeSegment{
+				{Start: 0, End: 1.5, Text: "Hello there."},
+				{Start: 1.5, End: 62.25, Text: "General Kenobi."},
+			},
+		})
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(TranscriptionsMiddleware(), captureRequestMiddleware(&capturedRequest))
+	router.Handle(http.MethodPost, "/api/transcribe", endpoint)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			for k, vs := range tc.fields {
+				for _, v := range vs {
+					if err := mw.WriteField(k, v); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if tc.file != nil {
+				fw, err := mw.CreateFormFile("file", "audio.wav")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := fw.Write(tc.file); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := mw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, "/api/transcribe", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			var errResp ErrorResponse
+			if resp.Code != http.StatusOK {
+				if err := json.Unmarshal(resp.Body.Bytes(), &errResp); err != nil {
+					t.Fatal(err)
+				}
+			} else if diff := cmp.Diff(tc.resp, resp.Body.String()); diff != "" {
+				t.Errorf("response mismatch (-want +got):\n%s", diff)
+			}
+
+			if capturedRequest != nil {
+				if diff := cmp.Diff(tc.req, *capturedRequest); diff != "" {
+					t.Errorf("request mismatch (-want +got):\n%s", diff)
+				}
+			}
+
+			if !reflect.DeepEqual(tc.err, errResp) {
+				t.Fatalf("errors did not match: %v", errResp)
+			}
+
+			capturedRequest = nil
+		})
+	}
+}
+
 func TestListMiddleware(t *testing.T) {
 	type testCase struct {
 		name     string
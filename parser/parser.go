@@ -80,7 +80,21 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 				return nil, err
 			}
 
-			req.Adapters = digestMap
+			if req.Adapters == nil {
+				req.Adapters = digestMap
+			} else {
+				for k, v := range digestMap {
+					req.Adapters[k] = v
+				}
+			}
+		case "merge":
+			if req.Merge == nil {
+				req.Merge = &api.MergeRequest{}
+			}
+
+			if err := parseMerge(req.Merge, c.Args); err != nil {
+				return nil, err
+			}
 		case "template":
 			req.Template = c.Args
 		case "system":
@@ -126,6 +140,51 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 	return req, nil
 }
 
+// parseMerge adds the arguments of a MERGE command to r. They're either
+// METHOD or BASE followed by a value, or a model followed by an optional
+// weight and an optional range of layers such as 0-15
+func parseMerge(r *api.MergeRequest, args string) error {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 2 && fields[0] == "METHOD":
+		r.Method = fields[1]
+	case len(fields) == 2 && fields[0] == "BASE":
+		r.Base = fields[1]
+	case len(fields) >= 1 && len(fields) <= 3:
+		m := api.MergeModel{Model: fields[0]}
+		for _, field := range fields[1:] {
+			if w, err := strconv.ParseFloat(field, 32); err == nil && m.Weight == nil {
+				weight := float32(w)
+				m.Weight = &weight
+				continue
+			}
+
+			first, last, ok := strings.Cut(field, "-")
+			if !ok || m.Layers != nil {
+				return fmt.Errorf("invalid MERGE argument %q", field)
+			}
+
+			i, err := strconv.Atoi(first)
+			if err != nil {
+				return fmt.Errorf("invalid MERGE layers %q", field)
+			}
+
+			j, err := strconv.Atoi(last)
+			if err != nil || j < i {
+				return fmt.Errorf("invalid MERGE layers %q", field)
+			}
+
+			m.Layers = []int{i, j}
+		}
+
+		r.Models = append(r.Models, m)
+	default:
+		return errors.New("MERGE takes a model, an optional weight and an optional range of layers")
+	}
+
+	return nil
+}
+
 func fileDigestMap(path string) (map[string]string, error) {
 	fl := make(map[string]string)
 
@@ -278,6 +337,8 @@ func (c Command) String() string {
 		fmt.Fprintf(&sb, "FROM %s", c.Args)
 	case "license", "template", "system", "adapter":
 		fmt.Fprintf(&sb, "%s %s", strings.ToUpper(c.Name), quote(c.Args))
+	case "merge":
+		fmt.Fprintf(&sb, "MERGE %s", c.Args)
 	case "message":
 		role, message, _ := strings.Cut(c.Args, ": ")
 		fmt.Fprintf(&sb, "MESSAGE %s %s", role, quote(message))
@@ -302,7 +363,7 @@ const (
 var (
 	errMissingFrom        = errors.New("no FROM line")
 	errInvalidMessageRole = errors.New("message role must be one of \"system\", \"user\", or \"assistant\"")
-	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"parameter\", or \"message\"")
+	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"merge\", \"parameter\", or \"message\"")
 )
 
 type ParserError struct {
@@ -562,7 +623,7 @@ func isValidMessageRole(role string) bool {
 
 func isValidCommand(cmd string) bool {
 	switch strings.ToLower(cmd) {
-	case "from", "license", "template", "system", "adapter", "parameter", "message":
+	case "from", "license", "template", "system", "adapter", "merge", "parameter", "message":
 		return true
 	default:
 		return false
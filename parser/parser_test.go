@@ -182,6 +182,27 @@ PARAMETER param1
 	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 }
 
+func TestCreateRequestBadMerge(t *testing.T) {
+	for _, input := range []string{
+		"MERGE other 0.5 0.5",
+		"MERGE other 0-15 16-31",
+		"MERGE other 15-0",
+		"MERGE other a-b",
+		"MERGE other 0.5 0-15 extra",
+	} {
+		t.Run(input, func(t *testing.T) {
+			p, err := ParseFile(strings.NewReader("FROM test\n" + input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := p.CreateRequest(""); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
 func TestParseFileBadCommand(t *testing.T) {
 	input := `
 FROM foo
@@ -733,6 +754,27 @@ MESSAGE assistant Hi! How are you?
 				},
 			},
 		},
+		{
+			`FROM test
+MERGE METHOD task_arithmetic
+MERGE BASE base
+MERGE other
+MERGE another -0.5 0-15
+MERGE another 16-31 1.5
+`,
+			&api.CreateRequest{
+				From: "test",
+				Merge: &api.MergeRequest{
+					Method: "task_arithmetic",
+					Base:   "base",
+					Models: []api.MergeModel{
+						{Model: "other"},
+						{Model: "another", Weight: &[]float32{-0.5}[0], Layers: []int{0, 15}},
+						{Model: "another", Weight: &[]float32{1.5}[0], Layers: []int{16, 31}},
+					},
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -82,6 +82,10 @@ type InputCacheSlot struct {
 	// Inputs that are stored in the KV cache
 	Inputs []input.Input
 
+	// Adapter is the id of the LoRA adapter the inputs were processed with,
+	// 0 for none
+	Adapter int
+
 	// is this cache actively being processed as part of a sequence?
 	InUse bool
 
@@ -89,7 +93,7 @@ type InputCacheSlot struct {
 	lastUsed time.Time
 }
 
-func (c *InputCache) LoadCacheSlot(prompt []input.Input) (*InputCacheSlot, []input.Input, error) {
+func (c *InputCache) LoadCacheSlot(prompt []input.Input, adapter int, cachePrompt bool) (*InputCacheSlot, []input.Input, error) {
 	var slot *InputCacheSlot
 	var numPast int32
 	var err error
@@ -99,16 +103,21 @@ func (c *InputCache) LoadCacheSlot(prompt []input.Input) (*InputCacheSlot, []inp
 	// For multiple users, the "best" cache slot produces better input cache hit rates
 	// at the cost of worse performance when we miss the input cache.
 	if !c.multiUserCache {
-		slot, numPast, err = c.findLongestCacheSlot(prompt)
+		slot, numPast, err = c.findLongestCacheSlot(prompt, adapter)
 	} else {
-		slot, numPast, err = c.findBestCacheSlot(prompt)
+		slot, numPast, err = c.findBestCacheSlot(prompt, adapter)
 	}
 	if err != nil {
 		return nil, nil, err
 	}
 
+	if !cachePrompt {
+		numPast = 0
+	}
+
 	slot.InUse = true
 	slot.lastUsed = time.Now()
+	slot.Adapter = adapter
 
 	if numPast == int32(len(prompt)) {
 		// Leave one input to sample so we can get a response
@@ -136,7 +145,7 @@ func (c *InputCache) LoadCacheSlot(prompt []input.Input) (*InputCacheSlot, []inp
 	return slot, prompt, nil
 }
 
-func (c *InputCache) findLongestCacheSlot(prompt []input.Input) (*InputCacheSlot, int32, error) {
+func (c *InputCache) findLongestCacheSlot(prompt []input.Input, adapter int) (*InputCacheSlot, int32, error) {
 	longest := int32(-1)
 	var longestSlot *InputCacheSlot
 
@@ -145,7 +154,7 @@ func (c *InputCache) findLongestCacheSlot(prompt []input.Input) (*InputCacheSlot
 			continue
 		}
 
-		count := countCommonPrefix(s.Inputs, prompt)
+		count := s.commonPrefix(prompt, adapter)
 		if count > longest {
 			longest = count
 			longestSlot = &c.slots[i]
@@ -159,7 +168,7 @@ func (c *InputCache) findLongestCacheSlot(prompt []input.Input) (*InputCacheSlot
 	return longestSlot, longest, nil
 }
 
-func (c *InputCache) findBestCacheSlot(prompt []input.Input) (*InputCacheSlot, int32, error) {
+func (c *InputCache) findBestCacheSlot(prompt []input.Input, adapter int) (*InputCacheSlot, int32, error) {
 	oldest := time.Now()
 	var oldestSlot *InputCacheSlot
 
@@ -167,7 +176,7 @@ func (c *InputCache) findBestCacheSlot(prompt []input.Input) (*InputCacheSlot, i
 	var longestSlot *InputCacheSlot
 
 	for i, s := range c.slots {
-		count := countCommonPrefix(s.Inputs, prompt)
+		count := s.commonPrefix(prompt, adapter)
 		if count > longest {
 			longest = count
 			longestSlot = &c.slots[i]
@@ -205,6 +214,16 @@ func (c *InputCache) findBestCacheSlot(prompt []input.Input) (*InputCacheSlot, i
 	return oldestSlot, longest, nil
 }
 
+// commonPrefix is the number of inputs of prompt already in the slot. Inputs
+// processed with a different adapter can't be reused.
+func (s *InputCacheSlot) commonPrefix(prompt []input.Input, adapter int) int32 {
+	if s.Adapter != adapter {
+		return 0
+	}
+
+	return countCommonPrefix(s.Inputs, prompt)
+}
+
 func countCommonPrefix(a []input.Input, b []input.Input) int32 {
 	var count int32
 
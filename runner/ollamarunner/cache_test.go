@@ -212,7 +212,7 @@ func TestFindCacheSlot(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run("Longest-"+tt.name, func(t *testing.T) {
-			result, resultLen, err := tt.cache.findLongestCacheSlot(tt.prompt)
+			result, resultLen, err := tt.cache.findLongestCacheSlot(tt.prompt, 0)
 			if err != nil {
 				t.Errorf("findLongestCacheSlot: err %v", err)
 			} else if result.Id != tt.longest.result || resultLen != tt.longest.len {
@@ -224,7 +224,7 @@ func TestFindCacheSlot(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run("Best-"+tt.name, func(t *testing.T) {
-			result, resultLen, err := tt.cache.findBestCacheSlot(tt.prompt)
+			result, resultLen, err := tt.cache.findBestCacheSlot(tt.prompt, 0)
 			if err != nil {
 				t.Errorf("findBestCacheSlot: err %v", err)
 			} else if result.Id != tt.best.result || resultLen != tt.best.len {
@@ -303,10 +303,62 @@ func TestLoadCacheSlot(t *testing.T) {
 		name           string
 		cache          InputCache
 		prompt         []input.Input
+		adapter        int
 		wantErr        bool
 		expectedSlotId int
 		expectedPrompt int // expected length of remaining prompt
 	}{
+		{
+			name: "Adapter cache hit - single user",
+			cache: InputCache{
+				multiUserCache: false,
+				slots: []InputCacheSlot{
+					{
+						Id:       0,
+						Inputs:   []input.Input{{Token: 1}, {Token: 2}},
+						InUse:    false,
+						lastUsed: time.Now().Add(-time.Second),
+					},
+					{
+						Id:       1,
+						Inputs:   []input.Input{{Token: 1}},
+						Adapter:  1,
+						InUse:    false,
+						lastUsed: time.Now().Add(-2 * time.Second),
+					},
+				},
+			},
+			prompt:         []input.Input{{Token: 1}, {Token: 2}, {Token: 3}},
+			adapter:        1,
+			wantErr:        false,
+			expectedSlotId: 1,
+			expectedPrompt: 2, // inputs of slot 0 were processed without the adapter
+		},
+		{
+			name: "Adapter cache miss - multi user",
+			cache: InputCache{
+				multiUserCache: true,
+				slots: []InputCacheSlot{
+					{
+						Id:       0,
+						Inputs:   []input.Input{{Token: 1}, {Token: 2}},
+						InUse:    false,
+						lastUsed: time.Now().Add(-time.Second),
+					},
+					{
+						Id:       1,
+						Inputs:   []input.Input{},
+						InUse:    false,
+						lastUsed: time.Now().Add(-2 * time.Second),
+					},
+				},
+			},
+			prompt:         []input.Input{{Token: 1}, {Token: 2}, {Token: 3}},
+			adapter:        1,
+			wantErr:        false,
+			expectedSlotId: 1,
+			expectedPrompt: 3,
+		},
 		{
 			name: "Basic cache hit - single user",
 			cache: InputCache{
@@ -395,7 +447,7 @@ func TestLoadCacheSlot(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			slot, remainingPrompt, err := tt.cache.LoadCacheSlot(tt.prompt)
+			slot, remainingPrompt, err := tt.cache.LoadCacheSlot(tt.prompt, tt.adapter, true)
 
 			// Check error state
 			if (err != nil) != tt.wantErr {
@@ -417,6 +469,10 @@ func TestLoadCacheSlot(t *testing.T) {
 				t.Errorf("LoadCacheSlot() slot not marked InUse")
 			}
 
+			if slot.Adapter != tt.adapter {
+				t.Errorf("LoadCacheSlot() slot adapter = %v, expected %v", slot.Adapter, tt.adapter)
+			}
+
 			// Verify remaining prompt length
 			if len(remainingPrompt) != tt.expectedPrompt {
 				t.Errorf("LoadCacheSlot() remaining prompt length = %v, expected %v",
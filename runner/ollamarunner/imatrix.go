@@ -0,0 +1,129 @@
+package ollamarunner
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+// imatrix runs calibration text through the model while recording the inputs
+// to its weights and responds with the resulting importance matrix. The text
+// is evaluated in chunks, each starting from an empty cache.
+func (s *Server) imatrix(w http.ResponseWriter, r *http.Request) {
+	var req llm.ImatrixRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	collector, ok := s.model.Backend().(ml.ImatrixCollector)
+	if !ok {
+		http.Error(w, "backend does not support importance matrices", http.StatusNotImplemented)
+		return
+	}
+
+	tokens, err := s.model.(model.TextProcessor).Encode(req.Content, false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to tokenize calibration text: %v", err), http.StatusInternalServerError)
+		return
+	} else if len(tokens) == 0 {
+		http.Error(w, "calibration text is empty", http.StatusBadRequest)
+		return
+	}
+
+	chunkSize := min(cmp.Or(req.ChunkSize, 512), int(s.cache.numCtx))
+
+	// hold every sequence so nothing else is evaluated while collecting
+	if err := s.seqsSem.Acquire(r.Context(), int64(s.parallel)); err != nil {
+		slog.Info("aborting imatrix request", "error", err)
+		return
+	}
+	defer s.seqsSem.Release(int64(s.parallel))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collector.CollectImatrix(true)
+	defer collector.CollectImatrix(false)
+
+	var chunks int
+	for chunk := range slices.Chunk(tokens, chunkSize) {
+		// a partial chunk at the end is only used if it's the only one
+		if len(chunk) < chunkSize && chunks > 0 {
+			break
+		}
+
+		if err := s.evaluateChunk(chunk); err != nil {
+			http.Error(w, fmt.Sprintf("failed to evaluate calibration text: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		chunks++
+		slog.Debug("imatrix", "chunk", chunks, "tokens", len(chunk))
+	}
+
+	if err := json.NewEncoder(w).Encode(&llm.ImatrixResponse{
+		Chunks: chunks,
+		Values: collector.Imatrix(),
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// evaluateChunk runs tokens through the model in a cache slot of its own
+func (s *Server) evaluateChunk(tokens []int32) error {
+	inputs := make([]input.Input, len(tokens))
+	for i, token := range tokens {
+		inputs[i] = input.Input{Token: token}
+	}
+
+	slot, inputs, err := s.cache.LoadCacheSlot(inputs, 0, false)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		slot.InUse = false
+		slot.Inputs = nil
+		if s.cache.cache != nil {
+			if err := s.cache.cache.Remove(slot.Id, 0, math.MaxInt32); err != nil {
+				slog.Warn("failed to clear cache", "error", err)
+			}
+		}
+	}()
+
+	for batch := range slices.Chunk(inputs, s.batchSize) {
+		var opts input.Options
+		for _, inp := range batch {
+			opts.Inputs = append(opts.Inputs, inp.Token)
+			opts.Positions = append(opts.Positions, int32(len(slot.Inputs)))
+			opts.Sequences = append(opts.Sequences, slot.Id)
+			slot.Inputs = append(slot.Inputs, inp)
+		}
+
+		opts.Outputs = []int32{int32(len(opts.Inputs) - 1)}
+
+		ctx := s.model.Backend().NewContext()
+		_, err := model.Forward(ctx, s.model, opts)
+		ctx.Close()
+		if err != nil {
+			return err
+		}
+
+		if !s.cache.enabled && len(slot.Inputs) < len(inputs) {
+			return errors.New("caching disabled but unable to fit the chunk in a batch")
+		}
+	}
+
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -82,6 +83,9 @@ type Sequence struct {
 	// true if an embedding are to be returned instead of text generation
 	embeddingOnly bool
 
+	// id of the LoRA adapter applied to the inputs, 0 for none
+	adapter int
+
 	doneReason string
 
 	// Metrics
@@ -97,14 +101,37 @@ type NewSequenceParams struct {
 	numKeep    int32
 	sampler    sample.Sampler
 	embedding  bool
+	adapter    int
+
+	// tokens, if set, are the inputs instead of the prompt
+	tokens []int
 }
 
 func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSequenceParams) (*Sequence, error) {
 	s.ready.Wait()
 
+	_, embedder := s.model.(model.Embedder)
+	if params.embedding {
+		if !embedder {
+			return nil, errors.New("this model does not support embeddings")
+		}
+	} else if embedder {
+		return nil, errors.New("this model only supports embeddings")
+	}
+
 	startTime := time.Now()
 
-	inputs, ctxs, err := s.inputs(prompt, images)
+	var inputs []input.Input
+	var ctxs *contextList
+	var err error
+	if params.tokens != nil {
+		for _, t := range params.tokens {
+			inputs = append(inputs, input.Input{Token: int32(t)})
+		}
+		ctxs = &contextList{}
+	} else {
+		inputs, ctxs, err = s.inputs(prompt, images)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to process inputs: %w", err)
 	} else if len(inputs) == 0 {
@@ -122,14 +149,27 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 	params.numKeep = min(params.numKeep, s.cache.numCtx-1)
 
 	if int32(len(inputs)) > s.cache.numCtx {
-		discard := int32(len(inputs)) - s.cache.numCtx
-		newInputs := inputs[:params.numKeep]
-		newInputs = append(newInputs, inputs[params.numKeep+discard:]...)
+		var newInputs []input.Input
+		if params.embedding {
+			// encoders pool from the first input, such as [CLS], and expect
+			// the last, such as [SEP], so like truncating tokenizers the end
+			// of the text is dropped instead of the start
+			newInputs = append(inputs[:s.cache.numCtx-1:s.cache.numCtx-1], inputs[len(inputs)-1])
+		} else {
+			discard := int32(len(inputs)) - s.cache.numCtx
+			newInputs = inputs[:params.numKeep]
+			newInputs = append(newInputs, inputs[params.numKeep+discard:]...)
+		}
 
 		slog.Warn("truncating input prompt", "limit", s.cache.numCtx, "prompt", len(inputs), "keep", params.numKeep, "new", len(newInputs))
 		inputs = newInputs
 	}
 
+	if params.embedding {
+		// encoders attend to the whole input so it must be processed in one batch
+		inputs[0].SameBatch = len(inputs) - 1
+	}
+
 	// TODO(jessegross): Ingest cached history for grammar
 
 	return &Sequence{
@@ -146,12 +186,13 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		embeddingOnly:       params.embedding,
 		stop:                params.stop,
 		numKeep:             params.numKeep,
+		adapter:             params.adapter,
 	}, nil
 }
 
-// inputs processes the prompt and images into a list of inputs
-// by splitting the prompt on [img-<n>] tags, tokenizing text and
-// decoding images
+// inputs processes the prompt and multimodal data into a list of inputs
+// by splitting the prompt on [img-<n>] and [audio-<n>] tags, tokenizing text
+// and decoding images and audio
 func (s *Server) inputs(prompt string, images []llm.ImageData) ([]input.Input, *contextList, error) {
 	var inputs []input.Input
 	var parts []string
@@ -160,7 +201,7 @@ func (s *Server) inputs(prompt string, images []llm.ImageData) ([]input.Input, *
 	multimodalProcessor, visionModel := s.model.(model.MultimodalProcessor)
 
 	if visionModel {
-		re := regexp.MustCompile(`\[img-(\d+)\]`)
+		re := regexp.MustCompile(`\[(?:img|audio)-(\d+)\]`)
 		parts = re.Split(prompt, -1)
 		matches = re.FindAllStringSubmatch(prompt, -1)
 	} else {
@@ -349,6 +390,8 @@ func (s *Server) processBatch() error {
 	defer s.mu.Unlock()
 
 	var options input.Options
+	var adapters []int
+	var hasAdapters bool
 
 	for i, seq := range s.seqs {
 		if seq == nil {
@@ -402,6 +445,8 @@ func (s *Server) processBatch() error {
 
 			options.Positions = append(options.Positions, int32(len(seq.cache.Inputs)+len(seq.pendingInputs)))
 			options.Sequences = append(options.Sequences, seq.cache.Id)
+			adapters = append(adapters, seq.adapter)
+			hasAdapters = hasAdapters || seq.adapter != 0
 
 			seq.iBatch = len(options.Outputs)
 			if j+1 == len(seq.inputs) {
@@ -420,6 +465,12 @@ func (s *Server) processBatch() error {
 	ctx := s.model.Backend().NewContext()
 	defer ctx.Close()
 
+	if hasAdapters {
+		if err := s.model.Backend().(ml.AdapterLoader).SetAdapters(ctx, adapters, options.Outputs); err != nil {
+			return fmt.Errorf("failed to set adapters: %w", err)
+		}
+	}
+
 	modelOutput, err := model.Forward(ctx, s.model, options)
 	if err != nil {
 		return fmt.Errorf("failed to decode batch: %w", err)
@@ -453,8 +504,9 @@ func (s *Server) processBatch() error {
 
 		// if done processing the prompt, generate an embedding and return
 		if seq.embeddingOnly {
-			// TODO(jessegross): Embedding support
-			slog.Warn("generation of embedding outputs not yet supported")
+			n := s.model.(model.Embedder).EmbeddingLength()
+			seq.embedding <- slices.Clone(logits[seq.iBatch*n : (seq.iBatch+1)*n])
+
 			s.removeSequence(i, "")
 			continue
 		}
@@ -570,12 +622,19 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		grammar,
 	)
 
+	adapter, err := s.loadAdapter(req.Adapter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to load adapter: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
 		numPredict: req.Options.NumPredict,
 		stop:       req.Options.Stop,
 		numKeep:    int32(req.Options.NumKeep),
 		sampler:    sampler,
 		embedding:  false,
+		adapter:    adapter,
 	})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
@@ -596,7 +655,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	found := false
 	for i, sq := range s.seqs {
 		if sq == nil {
-			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs)
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, seq.adapter, true)
 			if err != nil {
 				s.mu.Unlock()
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
@@ -655,6 +714,67 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
+	var req llm.EmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	slog.Debug("embedding request", "content", req.Content)
+
+	seq, err := s.NewSequence(req.Content, nil, NewSequenceParams{embedding: true, tokens: req.Tokens})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Ensure there is a place to put the sequence, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+		if errors.Is(err, context.Canceled) {
+			slog.Info("aborting embeddings request due to client closing the connection")
+		} else {
+			slog.Error("Failed to acquire semaphore", "error", err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	found := false
+	for i, sq := range s.seqs {
+		if sq == nil {
+			// the embedding depends on the whole input so nothing from the cache can be reused
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, 0, false)
+			if err != nil {
+				s.mu.Unlock()
+				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			s.seqs[i] = seq
+			s.cond.Signal()
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if !found {
+		http.Error(w, "could not find an available sequence", http.StatusInternalServerError)
+		return
+	}
+
+	embedding := <-seq.embedding
+
+	if err := json.NewEncoder(w).Encode(&llm.EmbeddingResponse{
+		Embedding: embedding,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(&llm.ServerStatusResponse{
@@ -665,6 +785,24 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// loadAdapter loads the LoRA adapter at path if needed and returns its id
+// in the backend, or 0 if path is empty
+func (s *Server) loadAdapter(path string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+
+	loader, ok := s.model.Backend().(ml.AdapterLoader)
+	if !ok {
+		return 0, errors.New("backend does not support adapters")
+	}
+
+	// adapters are allocated while no batch is being processed
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return loader.LoadAdapter(path)
+}
+
 type multiLPath []string
 
 func (m *multiLPath) Set(value string) error {
@@ -693,9 +831,11 @@ func (s *Server) loadModel(
 
 	s.vocab = sample.NewVocab(mpath)
 
-	// TODO(jessegross): LoRA loading
-	if lpath.String() != "" {
-		panic("loras are not yet implemented")
+	// adapters given at startup are loaded ahead of the first request that uses them
+	for _, path := range lpath {
+		if _, err := s.loadAdapter(path); err != nil {
+			panic(err)
+		}
 	}
 
 	s.cache, err = NewInputCache(s.model, kvCacheType, int32(kvSize), parallel, multiUserCache)
@@ -808,10 +948,8 @@ func Execute(args []string) error {
 	defer listener.Close()
 
 	mux := http.NewServeMux()
-	// TODO: support embeddings
-	mux.HandleFunc("POST /embedding", func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "this model does not support embeddings", http.StatusNotImplemented)
-	})
+	mux.HandleFunc("POST /embedding", server.embeddings)
+	mux.HandleFunc("POST /imatrix", server.imatrix)
 
 	mux.HandleFunc("POST /completion", server.completion)
 	mux.HandleFunc("GET /health", server.health)
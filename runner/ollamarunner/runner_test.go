@@ -0,0 +1,66 @@
+package ollamarunner
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/model"
+)
+
+// testEmbedder tokenizes each word of the prompt as its length, between a
+// leading 100 and a trailing 101 like the [CLS] and [SEP] of BERT
+type testEmbedder struct {
+	model.Model
+	model.TextProcessor
+}
+
+func (testEmbedder) Encode(s string, addSpecial bool) ([]int32, error) {
+	tokens := []int32{100}
+	for _, w := range strings.Fields(s) {
+		tokens = append(tokens, int32(len(w)))
+	}
+	return append(tokens, 101), nil
+}
+
+func (testEmbedder) EmbeddingLength() int { return 4 }
+
+func TestNewSequenceEmbedding(t *testing.T) {
+	s := &Server{model: testEmbedder{}, cache: &InputCache{numCtx: 4}}
+
+	tokens := func(seq *Sequence) []int32 {
+		var tokens []int32
+		for _, in := range seq.inputs {
+			tokens = append(tokens, in.Token)
+		}
+		return tokens
+	}
+
+	seq, err := s.NewSequence("a bb", nil, NewSequenceParams{embedding: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tokens(seq), []int32{100, 1, 2, 101}; !slices.Equal(got, want) {
+		t.Errorf("inputs = %v; want %v", got, want)
+	}
+	if seq.inputs[0].SameBatch != 3 {
+		t.Errorf("SameBatch = %d; want 3", seq.inputs[0].SameBatch)
+	}
+
+	// truncation keeps the first and last inputs that are pooled and
+	// expected by the encoder
+	seq, err = s.NewSequence("a bb ccc dddd", nil, NewSequenceParams{embedding: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tokens(seq), []int32{100, 1, 2, 101}; !slices.Equal(got, want) {
+		t.Errorf("truncated inputs = %v; want %v", got, want)
+	}
+	if seq.inputs[0].SameBatch != 3 {
+		t.Errorf("truncated SameBatch = %d; want 3", seq.inputs[0].SameBatch)
+	}
+
+	if _, err := s.NewSequence("a", nil, NewSequenceParams{}); err == nil {
+		t.Error("expected error generating text with an embedding model")
+	}
+}
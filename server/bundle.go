@@ -0,0 +1,454 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/registry"
+	"github.com/ollama/ollama/types/model"
+)
+
+// Model bundles are tarballs in the OCI image layout
+// (https://github.com/opencontainers/image-spec/blob/main/image-layout.md):
+//
+//	oci-layout
+//	index.json
+//	blobs/sha256/<hex>
+//
+// The index lists the manifest of each model, annotated with its name and
+// the digest of its signature by the exporting server, and the blobs
+// directory holds the manifests, their signatures and every blob they
+// reference, once each.
+const (
+	ociLayoutVersion          = "1.0.0"
+	ociIndexMediaType         = "application/vnd.oci.image.index.v1+json"
+	ociRefNameAnnotation      = "org.opencontainers.image.ref.name"
+	ollamaSignatureAnnotation = "com.ollama.signature"
+
+	// maxBundleIndexSize is the maximum size of the index of a bundle
+	// that is imported.
+	maxBundleIndexSize = 4 << 20
+)
+
+var (
+	errBundleInvalid = errors.New("invalid model bundle")
+	errModelExists   = errors.New("model already exists")
+)
+
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// bundleEntry is a file in a bundle, with either its data or the path of
+// the file it is read from.
+type bundleEntry struct {
+	name string
+	size int64
+	data []byte
+	path string
+}
+
+// bundle is a set of models to export.
+type bundle struct {
+	entries []bundleEntry
+}
+
+// newBundle returns a bundle of the models, checking they and all their
+// blobs exist so that writing the bundle only fails on I/O errors. Each
+// model is signed with the key of the server, if it has one, so it can be
+// imported by servers that trust it.
+func newBundle(ctx context.Context, names []model.Name) (*bundle, error) {
+	index := ociIndex{SchemaVersion: 2, MediaType: ociIndexMediaType}
+
+	var blobs []bundleEntry
+	seen := make(map[string]bool)
+	addBlob := func(digest string, size int64, data []byte) error {
+		if digest == "" || seen[digest] {
+			return nil
+		}
+		seen[digest] = true
+
+		e := bundleEntry{name: "blobs/" + strings.Replace(digest, ":", "/", 1), size: size, data: data}
+		if data == nil {
+			p, err := GetBlobsPath(digest)
+			if err != nil {
+				return err
+			}
+			fi, err := os.Stat(p)
+			if err != nil {
+				return err
+			}
+			if fi.Size() != size {
+				return fmt.Errorf("blob %s has size %d, expected %d", digest, fi.Size(), size)
+			}
+			e.path = p
+		}
+
+		blobs = append(blobs, e)
+		return nil
+	}
+
+	for _, n := range names {
+		m, err := ParseNamedManifest(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n.DisplayShortest(), err)
+		}
+
+		// The manifest is read again, to keep the bytes its digest
+		// is of.
+		data, err := os.ReadFile(m.filepath)
+		if err != nil {
+			return nil, err
+		}
+		d := blob.DigestFromBytes(data)
+
+		annotations := map[string]string{ociRefNameAnnotation: n.String()}
+		if sig, err := sign(ctx, n.Namespace+"/"+n.Model, n.Tag, d.String()); err != nil {
+			slog.Warn("exporting unsigned model", "model", n.DisplayShortest(), "error", err)
+		} else {
+			sigJSON, err := json.Marshal(sig)
+			if err != nil {
+				return nil, err
+			}
+
+			sd := blob.DigestFromBytes(sigJSON)
+			annotations[ollamaSignatureAnnotation] = sd.String()
+			if err := addBlob(sd.String(), int64(len(sigJSON)), sigJSON); err != nil {
+				return nil, err
+			}
+		}
+
+		index.Manifests = append(index.Manifests, ociDescriptor{
+			MediaType:   m.MediaType,
+			Digest:      d.String(),
+			Size:        int64(len(data)),
+			Annotations: annotations,
+		})
+
+		if err := addBlob(d.String(), int64(len(data)), data); err != nil {
+			return nil, err
+		}
+		for _, l := range append(m.Layers, m.Config) {
+			if err := addBlob(l.Digest, l.Size, nil); err != nil {
+				return nil, fmt.Errorf("%s: %w", n.DisplayShortest(), err)
+			}
+		}
+	}
+
+	layout, err := json.Marshal(ociLayout{ImageLayoutVersion: ociLayoutVersion})
+	if err != nil {
+		return nil, err
+	}
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bundle{
+		entries: append([]bundleEntry{
+			{name: "oci-layout", size: int64(len(layout)), data: layout},
+			{name: "index.json", size: int64(len(indexData)), data: indexData},
+		}, blobs...),
+	}, nil
+}
+
+// size returns the number of bytes written by writeTo.
+func (b *bundle) size() (int64, error) {
+	var w countingWriter
+	if err := b.write(&w, true); err != nil {
+		return 0, err
+	}
+	return int64(w), nil
+}
+
+// writeTo writes the bundle to w as a tarball.
+func (b *bundle) writeTo(w io.Writer) error {
+	return b.write(w, false)
+}
+
+// zeros is written in place of the data of blobs when only the size of a
+// bundle is needed.
+var zeros = make([]byte, 1<<20)
+
+func (b *bundle) write(w io.Writer, sizeOnly bool) error {
+	tw := tar.NewWriter(w)
+	for _, e := range b.entries {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     e.name,
+			Size:     e.size,
+			Mode:     0o644,
+			ModTime:  time.Unix(0, 0),
+		}); err != nil {
+			return err
+		}
+
+		switch {
+		case e.data != nil:
+			if _, err := tw.Write(e.data); err != nil {
+				return err
+			}
+		case sizeOnly:
+			for n := e.size; n > 0; n -= int64(len(zeros)) {
+				if _, err := tw.Write(zeros[:min(n, int64(len(zeros)))]); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := copyBlob(tw, e.path, e.size); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+func copyBlob(w io.Writer, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(w, f, size)
+	return err
+}
+
+// writeManifestData writes the manifest data of the model n, replacing any
+// manifest it has.
+func writeManifestData(n model.Name, data []byte) error {
+	p, err := manifestFilepath(n)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+// manifestFilepath returns the path of the manifest of the model n.
+func manifestFilepath(n model.Name) (string, error) {
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(manifests, n.Filepath()), nil
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// ExportModels writes the models to w as a self-contained bundle, which
+// [ImportModels] adds to another models directory. Blobs shared by the
+// models are written once.
+func ExportModels(ctx context.Context, w io.Writer, names []model.Name) error {
+	b, err := newBundle(ctx, names)
+	if err != nil {
+		return err
+	}
+	return b.writeTo(w)
+}
+
+// ImportModels adds the models in the bundle read from r, as written by
+// [ExportModels], and returns their names. Blobs are checked against their
+// digests as they are imported, and the models are only added once all the
+// blobs they reference are.
+//
+// If OLLAMA_TRUSTED_KEYS is set, every model has to be signed by one of the
+// trusted keys, as for pulls. Models which already exist with another
+// manifest are only replaced if force is true, and otherwise nothing is
+// added and errModelExists is returned.
+func ImportModels(r io.Reader, force bool) ([]model.Name, error) {
+	c, err := blob.Open(envconfig.Models())
+	if err != nil {
+		return nil, err
+	}
+
+	var layout *ociLayout
+	var index *ociIndex
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBundleInvalid, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch name := path.Clean(hdr.Name); {
+		case name == "oci-layout":
+			if err := json.NewDecoder(io.LimitReader(tr, maxBundleIndexSize)).Decode(&layout); err != nil {
+				return nil, fmt.Errorf("%w: oci-layout: %w", errBundleInvalid, err)
+			}
+		case name == "index.json":
+			if err := json.NewDecoder(io.LimitReader(tr, maxBundleIndexSize)).Decode(&index); err != nil {
+				return nil, fmt.Errorf("%w: index.json: %w", errBundleInvalid, err)
+			}
+		case strings.HasPrefix(name, "blobs/sha256/"):
+			want, err := blob.ParseDigest("sha256:" + strings.TrimPrefix(name, "blobs/sha256/"))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", errBundleInvalid, hdr.Name, err)
+			}
+			if fi, err := os.Stat(c.GetFile(want)); err == nil && fi.Size() == hdr.Size {
+				continue
+			}
+
+			got, err := c.Import(tr, hdr.Size)
+			if err != nil {
+				return nil, err
+			}
+			if got != want {
+				return nil, fmt.Errorf("%w: blob %s has digest %s", errBundleInvalid, want, got)
+			}
+		}
+	}
+
+	if layout == nil || layout.ImageLayoutVersion != ociLayoutVersion {
+		return nil, fmt.Errorf("%w: missing or unsupported oci-layout", errBundleInvalid)
+	}
+	if index == nil {
+		return nil, fmt.Errorf("%w: missing index.json", errBundleInvalid)
+	}
+
+	var trusted []ssh.PublicKey
+	if path := envconfig.TrustedKeys(); path != "" {
+		trusted, err = registry.ReadAuthorizedKeys(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading trusted keys: %w", err)
+		}
+	}
+
+	var names []model.Name
+	var manifests [][]byte
+	for _, desc := range index.Manifests {
+		n := model.ParseName(desc.Annotations[ociRefNameAnnotation])
+		if !n.IsFullyQualified() {
+			return nil, fmt.Errorf("%w: invalid model name %q", errBundleInvalid, desc.Annotations[ociRefNameAnnotation])
+		}
+
+		d, err := blob.ParseDigest(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", errBundleInvalid, n.DisplayShortest(), err)
+		}
+
+		data, err := os.ReadFile(c.GetFile(d))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: missing manifest %s", errBundleInvalid, n.DisplayShortest(), d)
+		}
+
+		var m Manifest
+		if err := json.NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", errBundleInvalid, n.DisplayShortest(), err)
+		}
+
+		for _, l := range append(m.Layers, m.Config) {
+			if l.Digest == "" {
+				continue
+			}
+			p, err := GetBlobsPath(l.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", errBundleInvalid, n.DisplayShortest(), err)
+			}
+			if fi, err := os.Stat(p); err != nil || fi.Size() != l.Size {
+				return nil, fmt.Errorf("%w: %s: missing blob %s", errBundleInvalid, n.DisplayShortest(), l.Digest)
+			}
+		}
+
+		if trusted != nil {
+			if err := verifyBundleSignature(c, desc, n, trusted); err != nil {
+				return nil, fmt.Errorf("%s: %w", n.DisplayShortest(), err)
+			}
+		}
+
+		if !force {
+			p, err := manifestFilepath(n)
+			if err != nil {
+				return nil, err
+			}
+			if existing, err := os.ReadFile(p); err == nil && !bytes.Equal(existing, data) {
+				return nil, fmt.Errorf("%s: %w", n.DisplayShortest(), errModelExists)
+			}
+		}
+
+		names = append(names, n)
+		manifests = append(manifests, data)
+	}
+
+	for i, n := range names {
+		if err := writeManifestData(n, manifests[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+// verifyBundleSignature checks the manifest of the model n, described by desc
+// in the index of a bundle, is signed by one of the trusted keys. The
+// signature is a blob of the bundle, already in c.
+func verifyBundleSignature(c *blob.DiskCache, desc ociDescriptor, n model.Name, trusted []ssh.PublicKey) error {
+	v, ok := desc.Annotations[ollamaSignatureAnnotation]
+	if !ok {
+		return errModelUnsigned
+	}
+
+	d, err := blob.ParseDigest(v)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBundleInvalid, err)
+	}
+
+	f, err := os.Open(c.GetFile(d))
+	if err != nil {
+		return fmt.Errorf("%w: missing signature %s", errBundleInvalid, d)
+	}
+	defer f.Close()
+
+	var s signature
+	if err := json.NewDecoder(io.LimitReader(f, maxSignatureSize)).Decode(&s); err != nil {
+		return fmt.Errorf("%w: invalid signature: %w", errBundleInvalid, err)
+	}
+
+	if !s.verify(trusted, n.Namespace+"/"+n.Model, n.Tag, desc.Digest) {
+		return errModelUntrusted
+	}
+	return nil
+}
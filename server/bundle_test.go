@@ -0,0 +1,339 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func importRequest(t *testing.T, s *Server, data []byte, force bool) *http.Response {
+	t.Helper()
+	w := NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = &http.Request{
+		URL:  &url.URL{RawQuery: url.Values{"force": {strconv.FormatBool(force)}}.Encode()},
+		Body: io.NopCloser(bytes.NewReader(data)),
+	}
+	s.ImportHandler(c)
+	return w.Result()
+}
+
+// rewriteBundle returns a copy of the bundle data with the contents of the
+// entries replaced by fn.
+func rewriteBundle(t *testing.T, data []byte, fn func(name string, data []byte) []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = fn(hdr.Name, data)
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestExportImport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	key := writeKey(t, home)
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test",
+		Files: map[string]string{"test.gguf": digest},
+	}); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test2",
+		From:   "test",
+		System: "You are a bundled model.",
+	}); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+
+	want := make(map[string]*Manifest)
+	for _, name := range []string{"test", "test2"} {
+		m, err := ParseNamedManifest(model.ParseName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[name] = m
+	}
+
+	w := createRequest(t, s.ExportHandler, api.ExportRequest{Models: []string{"test", "test2", "test"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: %d %s", w.Code, w.Body.String())
+	}
+	bundle := w.Body.Bytes()
+
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(bundle)) {
+		t.Errorf("Content-Length = %s; want %d", got, len(bundle))
+	}
+
+	// layers shared by the models are exported once
+	var entries []string
+	tr := tar.NewReader(bytes.NewReader(bundle))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, hdr.Name)
+	}
+	if n := strings.Count(strings.Join(entries, "\n"), "blobs/sha256/"+strings.TrimPrefix(digest, "sha256:")); n != 1 {
+		t.Errorf("model layer exported %d times; want 1 in %v", n, entries)
+	}
+
+	t.Run("import", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		res := importRequest(t, &s, bundle, false)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("import: %d", res.StatusCode)
+		}
+
+		var resp api.ImportResponse
+		if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"test:latest", "test2:latest"}, resp.Models); diff != "" {
+			t.Errorf("models mismatch (-want +got):\n%s", diff)
+		}
+
+		for name, m := range want {
+			got, err := ParseNamedManifest(model.ParseName(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(m, got, cmpopts.IgnoreUnexported(Manifest{}, Layer{})); diff != "" {
+				t.Errorf("%s manifest mismatch (-want +got):\n%s", name, diff)
+			}
+			if got.digest != m.digest {
+				t.Errorf("%s digest = %s; want %s", name, got.digest, m.digest)
+			}
+
+			for _, l := range append(got.Layers, got.Config) {
+				p, err := GetBlobsPath(l.Digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi, err := os.Stat(p); err != nil || fi.Size() != l.Size {
+					t.Errorf("blob %s not imported: %v", l.Digest, err)
+				}
+			}
+		}
+
+		// importing again is a no-op
+		if res := importRequest(t, &s, bundle, false); res.StatusCode != http.StatusOK {
+			t.Fatalf("import again: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		corrupt := rewriteBundle(t, bundle, func(name string, data []byte) []byte {
+			if name == "blobs/sha256/"+strings.TrimPrefix(digest, "sha256:") {
+				data[len(data)-1] ^= 0xff
+			}
+			return data
+		})
+
+		if res := importRequest(t, &s, corrupt, false); res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("import: %d; want %d", res.StatusCode, http.StatusBadRequest)
+		}
+		if _, err := ParseNamedManifest(model.ParseName("test")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("err = %v; want %v", err, os.ErrNotExist)
+		}
+	})
+
+	t.Run("missing blob", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		var b bytes.Buffer
+		tw := tar.NewWriter(&b)
+		tr := tar.NewReader(bytes.NewReader(bundle))
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name == "blobs/sha256/"+strings.TrimPrefix(digest, "sha256:") {
+				continue
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if res := importRequest(t, &s, b.Bytes(), false); res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("import: %d; want %d", res.StatusCode, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		if res := importRequest(t, &s, bundle, false); res.StatusCode != http.StatusOK {
+			t.Fatalf("import: %d", res.StatusCode)
+		}
+
+		if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   "test",
+			From:   "test",
+			System: "You are another model.",
+		}); w.Code != http.StatusOK {
+			t.Fatalf("create: %d %s", w.Code, w.Body.String())
+		}
+		changed, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// models are not replaced unless forced
+		if res := importRequest(t, &s, bundle, false); res.StatusCode != http.StatusConflict {
+			t.Fatalf("import: %d; want %d", res.StatusCode, http.StatusConflict)
+		}
+		got, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.digest != changed.digest {
+			t.Errorf("digest = %s; want %s", got.digest, changed.digest)
+		}
+
+		if res := importRequest(t, &s, bundle, true); res.StatusCode != http.StatusOK {
+			t.Fatalf("import with force: %d", res.StatusCode)
+		}
+		got, err = ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.digest != want["test"].digest {
+			t.Errorf("digest = %s; want %s", got.digest, want["test"].digest)
+		}
+	})
+
+	t.Run("trusted keys", func(t *testing.T) {
+		trusted := filepath.Join(t.TempDir(), "trusted_keys")
+		if err := os.WriteFile(trusted, ssh.MarshalAuthorizedKey(key), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		untrusted := filepath.Join(t.TempDir(), "trusted_keys")
+		if err := os.WriteFile(untrusted, ssh.MarshalAuthorizedKey(writeKey(t, t.TempDir())), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		// a bundle exported by a server without a key
+		t.Setenv("HOME", t.TempDir())
+		t.Setenv("USERPROFILE", os.Getenv("HOME"))
+		w := createRequest(t, s.ExportHandler, api.ExportRequest{Models: []string{"test"}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("export: %d %s", w.Code, w.Body.String())
+		}
+		unsigned := w.Body.Bytes()
+
+		// a bundle whose model was renamed after it was signed
+		renamed := rewriteBundle(t, bundle, func(name string, data []byte) []byte {
+			if name == "index.json" {
+				return bytes.ReplaceAll(data, []byte("/test:latest"), []byte("/test:other"))
+			}
+			return data
+		})
+
+		cases := []struct {
+			name   string
+			keys   string
+			bundle []byte
+			want   int
+		}{
+			{"signed", trusted, bundle, http.StatusOK},
+			{"untrusted", untrusted, bundle, http.StatusForbidden},
+			{"unsigned", trusted, unsigned, http.StatusForbidden},
+			{"renamed", trusted, renamed, http.StatusForbidden},
+			{"unsigned without keys", "", unsigned, http.StatusOK},
+		}
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				t.Setenv("OLLAMA_TRUSTED_KEYS", tt.keys)
+				t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+				if res := importRequest(t, &s, tt.bundle, false); res.StatusCode != tt.want {
+					t.Fatalf("import: %d; want %d", res.StatusCode, tt.want)
+				}
+
+				_, err := ParseNamedManifest(model.ParseName("test"))
+				if tt.want != http.StatusOK && !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("err = %v; want %v", err, os.ErrNotExist)
+				}
+			})
+		}
+	})
+
+	if w := createRequest(t, s.ExportHandler, api.ExportRequest{Models: []string{"unknown"}}); w.Code != http.StatusNotFound {
+		t.Errorf("export unknown: %d; want %d", w.Code, http.StatusNotFound)
+	}
+	if w := createRequest(t, s.ExportHandler, api.ExportRequest{}); w.Code != http.StatusBadRequest {
+		t.Errorf("export nothing: %d; want %d", w.Code, http.StatusBadRequest)
+	}
+	if res := importRequest(t, &s, []byte("not a bundle"), false); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("import invalid: %d; want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -23,7 +24,7 @@ import (
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
-	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/template"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
@@ -36,6 +37,8 @@ var (
 	errUnknownType             = errors.New("unknown type")
 	errNeitherFromOrFiles      = errors.New("neither 'from' or 'files' was specified")
 	errFilePath                = errors.New("file path must be relative")
+	errDoRAUnsupported         = errors.New("DoRA adapters are not supported at runtime, use merge_adapters to merge them into the model")
+	errMergeOptions            = errors.New("merge_method, merge_density and adapter_weights require merge_adapters")
 )
 
 func (s *Server) CreateHandler(c *gin.Context) {
@@ -67,6 +70,48 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		return
 	}
 
+	if r.Imatrix != "" {
+		if cmp.Or(r.Quantize, r.Quantization) == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "imatrix requires quantize"})
+			return
+		}
+
+		p, err := GetBlobsPath(r.Imatrix)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := os.Stat(p); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("imatrix blob %s not found", r.Imatrix)})
+			return
+		}
+	}
+
+	if !r.MergeAdapters && (r.MergeMethod != "" || r.MergeDensity != 0 || len(r.AdapterWeights) > 0) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errMergeOptions.Error()})
+		return
+	}
+
+	switch r.MergeMethod {
+	case "", ggml.MergeLinear:
+	case ggml.MergeTIES:
+		if r.MergeDensity < 0 || r.MergeDensity > 1 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "merge_density must be between 0 and 1"})
+			return
+		}
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown merge method %q", r.MergeMethod)})
+		return
+	}
+
+	if r.Merge != nil {
+		if err := validateMerge(r.Merge); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -109,9 +154,29 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			return
 		}
 
+		if r.Merge != nil {
+			baseLayers, err = mergeModelLayers(baseLayers, r.From, r.Merge, fn)
+			if err != nil {
+				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
+				return
+			}
+		}
+
 		var adapterLayers []*layerGGML
 		if r.Adapters != nil {
-			adapterLayers, err = convertModelFromFiles(r.Adapters, baseLayers, true, fn)
+			if r.MergeAdapters && detectModelTypeFromFiles(r.Adapters) == "gguf" {
+				// adapters are merged in the order of their file names
+				for _, k := range slices.Sorted(maps.Keys(r.Adapters)) {
+					var layers []*layerGGML
+					layers, err = convertModelFromFiles(map[string]string{k: r.Adapters[k]}, baseLayers, true, fn)
+					if err != nil {
+						break
+					}
+					adapterLayers = append(adapterLayers, layers...)
+				}
+			} else {
+				adapterLayers, err = convertModelFromFiles(r.Adapters, baseLayers, true, fn)
+			}
 			if err != nil {
 				for _, badReq := range []error{errNoFilesProvided, errOnlyOneAdapterSupported, errOnlyGGUFSupported, errUnknownType, errFilePath} {
 					if errors.Is(err, badReq) {
@@ -122,12 +187,40 @@ func (s *Server) CreateHandler(c *gin.Context) {
 				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 				return
 			}
+
+			for _, layer := range adapterLayers {
+				if !r.MergeAdapters && layer.GGML != nil && slices.ContainsFunc(layer.Tensors().Items(), func(t *ggml.Tensor) bool {
+					return strings.HasSuffix(t.Name, ".lora_m")
+				}) {
+					ch <- gin.H{"error": errDoRAUnsupported.Error(), "status": http.StatusBadRequest}
+					return
+				}
+			}
 		}
 
 		if len(adapterLayers) > 0 {
 			baseLayers = append(baseLayers, adapterLayers...)
 		}
 
+		if r.MergeAdapters {
+			baseLayers, err = mergeAdapterLayers(baseLayers, r, fn)
+			if err != nil {
+				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
+				return
+			}
+		}
+
+		if r.Imatrix != "" {
+			ctx, cancel := context.WithCancel(c.Request.Context())
+			defer cancel()
+
+			baseLayers, err = s.imatrixLayer(ctx, name, baseLayers, r.Imatrix, fn)
+			if err != nil {
+				ch <- gin.H{"error": err.Error()}
+				return
+			}
+		}
+
 		if err := createModel(r, name, baseLayers, fn); err != nil {
 			if errors.Is(err, errBadTemplate) {
 				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
@@ -265,7 +358,9 @@ func convertFromSafetensors(files map[string]string, baseLayers []*layerGGML, is
 	if !isAdapter {
 		fn(api.ProgressResponse{Status: "converting model"})
 		mediaType = "application/vnd.ollama.image.model"
-		if err := convert.ConvertModel(os.DirFS(tmpDir), t); err != nil {
+		if err := convert.ConvertModel(os.DirFS(tmpDir), t, func(completed, total uint64) {
+			fn(api.ProgressResponse{Status: "converting model", Total: int64(total), Completed: int64(completed)})
+		}); err != nil {
 			return nil, err
 		}
 	} else {
@@ -275,7 +370,9 @@ func convertFromSafetensors(files map[string]string, baseLayers []*layerGGML, is
 		}
 		fn(api.ProgressResponse{Status: "converting adapter"})
 		mediaType = "application/vnd.ollama.image.adapter"
-		if err := convert.ConvertAdapter(os.DirFS(tmpDir), t, kv); err != nil {
+		if err := convert.ConvertAdapter(os.DirFS(tmpDir), t, kv, func(completed, total uint64) {
+			fn(api.ProgressResponse{Status: "converting adapter", Total: int64(total), Completed: int64(completed)})
+		}); err != nil {
 			return nil, err
 		}
 	}
@@ -325,6 +422,16 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		},
 	}
 
+	var opts ggml.QuantizeOptions
+	opts.Mixed = r.QuantizeMixed
+	for _, layer := range baseLayers {
+		if layer.MediaType == imatrixMediaType {
+			if opts.Imatrix, err = readImatrix(layer.Digest); err != nil {
+				return err
+			}
+		}
+	}
+
 	var layers []Layer
 	for _, layer := range baseLayers {
 		if layer.GGML != nil {
@@ -336,10 +443,10 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 				}
 
 				ft := layer.GGML.KV().FileType()
-				if !slices.Contains([]string{"F16", "F32"}, ft.String()) {
-					return errors.New("quantization is only supported for F16 and F32 models")
+				if !slices.Contains([]string{"F16", "F32", "BF16"}, ft.String()) {
+					return errors.New("quantization is only supported for F16, BF16 and F32 models")
 				} else if ft != want {
-					layer, err = quantizeLayer(layer, quantType, fn)
+					layer, err = quantizeLayer(layer, quantType, opts, fn)
 					if err != nil {
 						return err
 					}
@@ -423,9 +530,10 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 	return nil
 }
 
-func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
+func quantizeLayer(layer *layerGGML, quantizeType string, opts ggml.QuantizeOptions, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
 	ft := layer.GGML.KV().FileType()
-	fn(api.ProgressResponse{Status: fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)})
+	status := fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)
+	fn(api.ProgressResponse{Status: status})
 
 	want, err := ggml.ParseFileType(quantizeType)
 	if err != nil {
@@ -437,6 +545,12 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 		return nil, err
 	}
 
+	in, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
 	temp, err := os.CreateTemp(filepath.Dir(blob), quantizeType)
 	if err != nil {
 		return nil, err
@@ -444,7 +558,13 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 	defer temp.Close()
 	defer os.Remove(temp.Name())
 
-	if err := llama.Quantize(blob, temp.Name(), uint32(want)); err != nil {
+	if err := ggml.Quantize(temp, in, want, opts, func(completed, total uint64) {
+		fn(api.ProgressResponse{Status: status, Total: int64(total), Completed: int64(completed)})
+	}); err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
 		return nil, err
 	}
 
@@ -466,6 +586,417 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 	return &layerGGML{newLayer, f}, nil
 }
 
+// mergeAdapterLayers returns layers with the updates of their adapters merged
+// into the weights of their model and the adapters removed
+func mergeAdapterLayers(layers []*layerGGML, r api.CreateRequest, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
+	var model *layerGGML
+	var adapters []*layerGGML
+	for _, layer := range layers {
+		switch {
+		case layer.GGML == nil:
+		case layer.MediaType == "application/vnd.ollama.image.model":
+			if model != nil {
+				return nil, errors.New("adapters can only be merged into a single model")
+			}
+			model = layer
+		case layer.MediaType == "application/vnd.ollama.image.adapter":
+			adapters = append(adapters, layer)
+		}
+	}
+
+	if model == nil {
+		return nil, errors.New("merging adapters requires a model")
+	} else if len(adapters) == 0 {
+		return nil, errors.New("no adapters to merge")
+	} else if len(r.AdapterWeights) > 0 && len(r.AdapterWeights) != len(adapters) {
+		return nil, fmt.Errorf("%d adapter weights given for %d adapters", len(r.AdapterWeights), len(adapters))
+	}
+
+	status := "merging adapters"
+	fn(api.ProgressResponse{Status: status})
+
+	blob, err := GetBlobsPath(model.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	ggmlAdapters := make([]ggml.Adapter, len(adapters))
+	for i, adapter := range adapters {
+		p, err := GetBlobsPath(adapter.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		ggmlAdapters[i] = ggml.Adapter{R: f, Weight: 1}
+		if len(r.AdapterWeights) > 0 {
+			ggmlAdapters[i].Weight = r.AdapterWeights[i]
+		}
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(blob), "merge")
+	if err != nil {
+		return nil, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	opts := ggml.MergeOptions{Method: r.MergeMethod, Density: cmp.Or(r.MergeDensity, 0.5)}
+	if err := ggml.MergeAdapters(temp, in, ggmlAdapters, opts, func(completed, total uint64) {
+		fn(api.ProgressResponse{Status: status, Total: int64(total), Completed: int64(completed)})
+	}); err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	layer, err := NewLayer(temp, model.MediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	f, _, err := ggml.Decode(temp, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make([]*layerGGML, 0, len(layers)-len(adapters))
+	for _, l := range layers {
+		switch {
+		case l == model:
+			merged = append(merged, &layerGGML{layer, f})
+		case slices.Contains(adapters, l):
+		default:
+			merged = append(merged, l)
+		}
+	}
+
+	return merged, nil
+}
+
+// validateMerge returns an error if r can't describe a merge
+func validateMerge(r *api.MergeRequest) error {
+	if len(r.Models) == 0 {
+		return errors.New("merge requires at least one model")
+	}
+
+	names := make(map[string]bool)
+	for _, m := range r.Models {
+		if !model.ParseName(m.Model).IsValid() {
+			return fmt.Errorf("invalid merge model name %q", m.Model)
+		}
+
+		if len(m.Layers) != 0 && (len(m.Layers) != 2 || m.Layers[0] < 0 || m.Layers[1] < m.Layers[0]) {
+			return fmt.Errorf("invalid layers %v for merge model %s", m.Layers, m.Model)
+		}
+
+		names[m.Model] = true
+	}
+
+	switch r.Method {
+	case "", ggml.MergeLinear:
+	case ggml.MergeSLERP:
+		if len(names) != 1 {
+			return errors.New("slerp merges exactly one model")
+		}
+	case ggml.MergeTaskArithmetic:
+		if r.Base == "" {
+			return errors.New("task_arithmetic requires a base model")
+		}
+	default:
+		return fmt.Errorf("unknown merge method %q", r.Method)
+	}
+
+	if r.Base != "" && r.Method != ggml.MergeTaskArithmetic {
+		return errors.New("a base model is only used by task_arithmetic")
+	}
+
+	return nil
+}
+
+// mergeModelLayers returns layers, of the model from, with the weights of
+// their model merged with those of the local models in r
+func mergeModelLayers(layers []*layerGGML, from string, r *api.MergeRequest, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
+	var m *layerGGML
+	for _, layer := range layers {
+		if layer.GGML != nil && layer.MediaType == "application/vnd.ollama.image.model" {
+			if m != nil {
+				return nil, errors.New("models can only be merged into a single model")
+			}
+			m = layer
+		}
+	}
+
+	if m == nil {
+		return nil, errors.New("merging requires a model")
+	}
+
+	// open returns the model layer of a local model
+	open := func(name string) (*os.File, error) {
+		manifest, err := ParseNamedManifest(model.ParseName(name))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("merge model %s not found", name)
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, layer := range manifest.Layers {
+			if layer.MediaType == "application/vnd.ollama.image.model" {
+				p, err := GetBlobsPath(layer.Digest)
+				if err != nil {
+					return nil, err
+				}
+
+				return os.Open(p)
+			}
+		}
+
+		return nil, fmt.Errorf("merge model %s has no weights", name)
+	}
+
+	var names []string
+	byName := make(map[string][]api.MergeModel)
+	for _, mm := range r.Models {
+		if _, ok := byName[mm.Model]; !ok {
+			names = append(names, mm.Model)
+		}
+		byName[mm.Model] = append(byName[mm.Model], mm)
+	}
+
+	var weight float32
+	switch r.Method {
+	case ggml.MergeSLERP:
+		weight = 0.5
+	case ggml.MergeTaskArithmetic:
+		weight = 1
+	default:
+		weight = 1 / float32(len(names)+1)
+	}
+
+	opts := ggml.MergeOptions{Method: r.Method}
+
+	var models []ggml.Model
+	for _, name := range names {
+		// task arithmetic weighs the difference of the model merged into
+		// too, which is given by listing it with the merged models
+		if r.Method == ggml.MergeTaskArithmetic && from != "" && model.ParseName(name).EqualFold(model.ParseName(from)) {
+			opts.Weight = mergeWeight(byName[name], weight)
+			continue
+		}
+
+		f, err := open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		models = append(models, ggml.Model{R: f, Weight: mergeWeight(byName[name], weight)})
+	}
+
+	if len(models) == 0 {
+		return nil, errors.New("merge requires at least one other model")
+	}
+	if r.Base != "" {
+		f, err := open(r.Base)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		opts.Base = f
+	}
+
+	status := fmt.Sprintf("merging %d models", len(models)+1)
+	fn(api.ProgressResponse{Status: status})
+
+	blob, err := GetBlobsPath(m.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	temp, err := os.CreateTemp(filepath.Dir(blob), "merge")
+	if err != nil {
+		return nil, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := ggml.MergeModels(temp, in, models, opts, func(completed, total uint64) {
+		fn(api.ProgressResponse{Status: status, Total: int64(total), Completed: int64(completed)})
+	}); err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	layer, err := NewLayer(temp, m.MediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	f, _, err := ggml.Decode(temp, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := slices.Clone(layers)
+	merged[slices.Index(layers, m)] = &layerGGML{layer, f}
+	return merged, nil
+}
+
+// mergeWeight returns the weight of a model listed as entries for each
+// tensor. Entries for a range of layers take precedence over an entry for
+// every tensor, and tensors outside of every entry have a weight of zero
+func mergeWeight(entries []api.MergeModel, weight float32) func(string) float32 {
+	return func(name string) float32 {
+		var w float32
+		for _, e := range entries {
+			ew := weight
+			if e.Weight != nil {
+				ew = *e.Weight
+			}
+
+			if len(e.Layers) == 0 {
+				w = ew
+				continue
+			}
+
+			var n int
+			if _, err := fmt.Sscanf(name, "blk.%d.", &n); err == nil && n >= e.Layers[0] && n <= e.Layers[1] {
+				return ew
+			}
+		}
+
+		return w
+	}
+}
+
+const imatrixMediaType = "application/vnd.ollama.image.imatrix"
+
+// imatrixLayer runs the calibration text in the blob digest through the model
+// in layers and returns layers with the resulting importance matrix added to
+// them, replacing any that was there already
+func (s *Server) imatrixLayer(ctx context.Context, name model.Name, layers []*layerGGML, digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
+	status := "computing importance matrix"
+	fn(api.ProgressResponse{Status: status})
+
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	m := Model{Name: name.String()}
+	for _, layer := range layers {
+		if layer.GGML != nil && layer.MediaType == "application/vnd.ollama.image.model" {
+			if m.ModelPath, err = GetBlobsPath(layer.Digest); err != nil {
+				return nil, err
+			}
+
+			m.Config.ModelFamilies = append(m.Config.ModelFamilies, layer.GGML.KV().Architecture())
+		}
+	}
+
+	if m.ModelPath == "" {
+		return nil, errors.New("importance matrices require a model")
+	}
+
+	opts := api.DefaultOptions()
+	opts.NumCtx = 512
+
+	runnerCh, errCh := s.sched.GetRunner(ctx, &m, opts, &api.Duration{})
+	var runner *runnerRef
+	select {
+	case runner = <-runnerCh:
+	case err := <-errCh:
+		return nil, err
+	}
+
+	resp, err := runner.llama.Imatrix(ctx, llm.ImatrixRequest{Content: string(text)})
+	if err != nil {
+		return nil, err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(p), "imatrix")
+	if err != nil {
+		return nil, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := ggml.WriteImatrix(temp, ggml.Imatrix{
+		Chunks:  resp.Chunks,
+		Dataset: digest,
+		Values:  resp.Values,
+	}); err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	layer, err := NewLayer(temp, imatrixMediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	layers = slices.DeleteFunc(layers, func(l *layerGGML) bool {
+		return l.MediaType == imatrixMediaType
+	})
+
+	return append(layers, &layerGGML{Layer: layer}), nil
+}
+
+// readImatrix reads the importance matrix in the blob digest
+func readImatrix(digest string) (*ggml.Imatrix, error) {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ggml.ReadImatrix(f)
+}
+
 func ggufLayers(digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
 	var layers []*layerGGML
 
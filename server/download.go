@@ -13,6 +13,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,7 +24,9 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/server/internal/throttle"
 )
 
 const maxRetries = 6
@@ -35,6 +38,24 @@ var (
 
 var blobDownloadManager sync.Map
 
+// downloadLimiter is shared by all downloads to limit them to the rate set by
+// OLLAMA_MAX_DOWNLOAD_RATE. Use globalDownloadLimiter to get it.
+var downloadLimiter struct {
+	sync.Mutex
+	*throttle.Limiter
+}
+
+// globalDownloadLimiter returns the limiter shared by all downloads, or nil if
+// the download rate is not limited.
+func globalDownloadLimiter() *throttle.Limiter {
+	downloadLimiter.Lock()
+	defer downloadLimiter.Unlock()
+	if rate := envconfig.MaxDownloadRate(); rate != downloadLimiter.Rate() {
+		downloadLimiter.Limiter = throttle.NewLimiter(rate)
+	}
+	return downloadLimiter.Limiter
+}
+
 type blobDownload struct {
 	Name   string
 	Digest string
@@ -49,6 +70,13 @@ type blobDownload struct {
 	done       chan struct{}
 	err        error
 	references atomic.Int32
+
+	// pulls are the options of the pulls waiting for the download, which
+	// is shared by all of them. The limits of each apply to it, so no pull
+	// is downloaded faster, or outside of its window, because another
+	// started the download.
+	pullsMu sync.Mutex
+	pulls   []*registryOptions
 }
 
 type blobDownloadPart struct {
@@ -289,6 +317,14 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 				case errors.Is(err, errPartStalled):
 					try--
 					continue
+				case errors.Is(err, throttle.ErrWindowClosed):
+					// pause until the download windows open again
+					slog.Info(fmt.Sprintf("%s part %d paused until its download window opens", b.Digest[7:19], part.N))
+					if err := b.waitWindows(inner); err != nil {
+						return err
+					}
+					try--
+					continue
 				case err != nil:
 					sleep := time.Second * time.Duration(math.Pow(2, float64(try)))
 					slog.Info(fmt.Sprintf("%s part %d attempt %d failed: %v, retrying in %s", b.Digest[7:19], part.N, try, err, sleep))
@@ -339,8 +375,9 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 		}
 		defer resp.Body.Close()
 
-		n, err := io.CopyN(w, io.TeeReader(resp.Body, part), part.Size-part.Completed.Load())
-		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		body := &throttledReader{ctx: ctx, r: resp.Body, b: b}
+		n, err := io.CopyN(w, io.TeeReader(body, part), part.Size-part.Completed.Load())
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, throttle.ErrWindowClosed) {
 			// rollback progress
 			b.Completed.Add(-n)
 			return err
@@ -351,7 +388,8 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 			return err
 		}
 
-		// return nil or context.Canceled or UnexpectedEOF (resumable)
+		// return nil or context.Canceled, UnexpectedEOF or
+		// ErrWindowClosed (resumable)
 		return err
 	})
 
@@ -386,6 +424,71 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 	return g.Wait()
 }
 
+// join adds the limits of the pull with opts to the download, until the
+// returned function is called.
+func (b *blobDownload) join(opts *registryOptions) (leave func()) {
+	b.pullsMu.Lock()
+	b.pulls = append(b.pulls, opts)
+	b.pullsMu.Unlock()
+	return func() {
+		b.pullsMu.Lock()
+		defer b.pullsMu.Unlock()
+		if i := slices.Index(b.pulls, opts); i >= 0 {
+			b.pulls = slices.Delete(b.pulls, i, i+1)
+		}
+	}
+}
+
+// throttles returns the download windows and limiters of the pulls waiting
+// for the download, and the limiter shared by all downloads.
+func (b *blobDownload) throttles() ([]throttle.Window, []*throttle.Limiter) {
+	b.pullsMu.Lock()
+	defer b.pullsMu.Unlock()
+	var windows []throttle.Window
+	limiters := []*throttle.Limiter{globalDownloadLimiter()}
+	for _, opts := range b.pulls {
+		if !opts.Window.IsZero() {
+			windows = append(windows, opts.Window)
+		}
+		limiters = append(limiters, opts.Limiter)
+	}
+	return windows, limiters
+}
+
+// waitWindows waits until the download windows of all pulls waiting for the
+// download are open.
+func (b *blobDownload) waitWindows(ctx context.Context) error {
+	for {
+		windows, _ := b.throttles()
+		i := slices.IndexFunc(windows, func(w throttle.Window) bool { return !w.Open(time.Now()) })
+		if i < 0 {
+			return nil
+		}
+		if err := windows[i].Wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// throttledReader reads the body of a part of b no faster than the limits
+// of the pulls waiting for b, as they are at each read, allow. It fails with
+// [throttle.ErrWindowClosed] once the window of any of them is closed.
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	b   *blobDownload
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	windows, limiters := r.b.throttles()
+	for _, w := range windows {
+		if !w.Open(time.Now()) {
+			return 0, throttle.ErrWindowClosed
+		}
+	}
+	return throttle.Reader(r.ctx, r.r, throttle.Window{}, limiters...).Read(p)
+}
+
 func (b *blobDownload) newPart(offset, size int64) error {
 	part := blobDownloadPart{blobDownload: b, Offset: offset, Size: size, N: len(b.Parts)}
 	if err := b.writePart(part.Name(), &part); err != nil {
@@ -486,8 +589,9 @@ func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ erro
 
 	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest})
 	download := data.(*blobDownload)
+	defer download.join(opts.regOpts)()
 	if !ok {
-		requestURL := opts.mp.BaseURL()
+		requestURL := opts.mp.PullURL()
 		requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
 		if err := download.Prepare(ctx, requestURL, opts.regOpts); err != nil {
 			blobDownloadManager.Delete(opts.digest)
@@ -7,7 +7,8 @@ import (
 )
 
 // fixBlobs walks the provided dir and replaces (":") to ("-") in the file
-// prefix. (e.g. sha256:1234 -> sha256-1234)
+// prefix. (e.g. sha256:1234 -> sha256-1234) It does not check the contents
+// of blobs; see VerifyModels.
 func fixBlobs(dir string) error {
 	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/server/internal/cache/blob"
+)
+
+// gcGracePeriod is how long files not used by any model are kept after they
+// were last written, so that blobs of pulls and creates in progress, which are
+// not used by a model until they finish, are not removed. The partial files of
+// a download are kept until gcGracePeriod after any of them was last written,
+// so that an interrupted pull can still be resumed.
+const gcGracePeriod = time.Hour
+
+// GarbageCollect removes the blobs not used by any model, the files left
+// behind by interrupted downloads, and the chunk indexes of blobs no longer
+// in the store, and reports the disk usage of the models. Files written in
+// the last gcGracePeriod and the partial files of downloads in progress, by
+// either client, are kept. If dryRun is true, nothing is removed.
+//
+// Unlike PruneLayers, GarbageCollect fails if any manifest cannot be read,
+// since the blobs of its model would be removed.
+func GarbageCollect(dryRun bool) (*api.GCResponse, error) {
+	manifests, err := Manifests(false)
+	if err != nil {
+		return nil, err
+	}
+
+	// the blobs used by each model, and the number of models using each
+	type usage struct {
+		name  string
+		sizes map[string]int64
+	}
+	var usages []usage
+	refs := make(map[string]int)
+	for n, m := range manifests {
+		u := usage{name: n.DisplayShortest(), sizes: make(map[string]int64)}
+		for _, l := range append(m.Layers, m.Config) {
+			if l.Digest != "" {
+				u.sizes[strings.Replace(l.Digest, ":", "-", 1)] = l.Size
+			}
+		}
+		for d := range u.sizes {
+			refs[d]++
+		}
+		if m.digest != "" {
+			// manifests pulled by the new client are also
+			// stored as blobs
+			refs["sha256-"+m.digest]++
+		}
+		usages = append(usages, u)
+	}
+
+	resp := api.GCResponse{Models: []api.ModelUsage{}}
+	for _, u := range usages {
+		mu := api.ModelUsage{Name: u.name}
+		for d, size := range u.sizes {
+			mu.Size += size
+			if refs[d] == 1 {
+				mu.Unique += size
+			} else {
+				mu.Shared += size
+			}
+		}
+		resp.Models = append(resp.Models, mu)
+	}
+	slices.SortFunc(resp.Models, func(a, b api.ModelUsage) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	blobsDir, err := GetBlobsPath("")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(blobsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// the partial files of a download, its data and its resume state, are
+	// kept or removed together, by when any of them was last written
+	infos := make([]fs.FileInfo, 0, len(entries))
+	lastWritten := make(map[string]time.Time)
+	for _, e := range entries {
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // removed underfoot
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		infos = append(infos, info)
+
+		if digest, partial := blobDigest(info.Name()); partial && info.ModTime().After(lastWritten[digest]) {
+			lastWritten[digest] = info.ModTime()
+		}
+	}
+
+	// downloading reports whether the blob digest is being downloaded by
+	// this process, which can be longer than gcGracePeriod since its
+	// partial files were last written if it waits for a download window
+	downloading := func(digest string) bool {
+		if _, ok := blobDownloadManager.Load(strings.Replace(digest, "-", ":", 1)); ok {
+			return true
+		}
+		name, err := filepath.Abs(filepath.Join(blobsDir, digest))
+		return err == nil && blob.Writing(name)
+	}
+
+	cutoff := time.Now().Add(-gcGracePeriod)
+	kept := make(map[string]bool)
+	remove := func(path string, size int64) {
+		resp.Reclaimable += size
+		if dryRun {
+			return
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("couldn't remove file", "path", path, "error", err)
+		}
+	}
+	for _, info := range infos {
+		resp.Size += info.Size()
+
+		name := info.Name()
+		digest, partial := blobDigest(name)
+		switch {
+		case refs[name] > 0:
+			kept[name] = true
+		case partial:
+			if lastWritten[digest].After(cutoff) || downloading(digest) {
+				continue
+			}
+			resp.Partials++
+			remove(filepath.Join(blobsDir, name), info.Size())
+		case info.ModTime().After(cutoff):
+			kept[name] = true
+		default:
+			resp.Blobs++
+			remove(filepath.Join(blobsDir, name), info.Size())
+		}
+	}
+
+	// Chunk indexes kept by the new client for blobs no longer in the
+	// store are removed with them.
+	indexes, err := filepath.Glob(filepath.Join(envconfig.Models(), "chunksums", "sha256-*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range indexes {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		resp.Size += info.Size()
+		if digest, _ := blobDigest(filepath.Base(path)); kept[digest] || info.ModTime().After(cutoff) {
+			continue
+		}
+		resp.Partials++
+		remove(path, info.Size())
+	}
+
+	return &resp, nil
+}
+
+// blobDigest returns the digest, in its file name form, at the start of the
+// name of a file in the blobs directory, and whether the file is something
+// other than the blob itself, such as a partial download. Files with no
+// digest in their name are reported as partial.
+func blobDigest(name string) (digest string, partial bool) {
+	const n = len("sha256-") + 64
+	if len(name) < n {
+		return name, true
+	}
+	if _, err := GetBlobsPath(name[:n]); err != nil {
+		return name, true
+	}
+	return name[:n], len(name) > n
+}
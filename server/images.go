@@ -20,11 +20,13 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/parser"
+	"github.com/ollama/ollama/server/internal/throttle"
 	"github.com/ollama/ollama/template"
 	"github.com/ollama/ollama/types/model"
 	"github.com/ollama/ollama/version"
@@ -35,6 +37,9 @@ var (
 	errCapabilityCompletion = errors.New("completion")
 	errCapabilityTools      = errors.New("tools")
 	errCapabilityInsert     = errors.New("insert")
+	errCapabilityRerank     = errors.New("rerank")
+	errCapabilityAudio      = errors.New("audio")
+	errCapabilityTranscribe = errors.New("transcribe")
 )
 
 type Capability string
@@ -43,6 +48,9 @@ const (
 	CapabilityCompletion = Capability("completion")
 	CapabilityTools      = Capability("tools")
 	CapabilityInsert     = Capability("insert")
+	CapabilityRerank     = Capability("rerank")
+	CapabilityAudio      = Capability("audio")
+	CapabilityTranscribe = Capability("transcribe")
 )
 
 type registryOptions struct {
@@ -51,6 +59,15 @@ type registryOptions struct {
 	Password string
 	Token    string
 
+	// Sign, if true, signs pushed manifests. See pushSignature.
+	Sign bool
+
+	// Limiter and Window throttle the downloads of pulls, in addition to
+	// OLLAMA_MAX_DOWNLOAD_RATE. Window, if set, is used instead of
+	// OLLAMA_DOWNLOAD_WINDOW.
+	Limiter *throttle.Limiter
+	Window  throttle.Window
+
 	CheckRedirect func(req *http.Request, via []*http.Request) error
 }
 
@@ -69,30 +86,30 @@ type Model struct {
 	Messages       []api.Message
 
 	Template *template.Template
+
+	// kv is the metadata of the model file, read once by decodeKV
+	kv ggml.KV
 }
 
 // CheckCapabilities checks if the model has the specified capabilities returning an error describing
 // any missing or unknown capabilities
 func (m *Model) CheckCapabilities(caps ...Capability) error {
+	var kv ggml.KV
+	if slices.ContainsFunc(caps, func(cap Capability) bool {
+		return cap == CapabilityCompletion || cap == CapabilityRerank || cap == CapabilityAudio || cap == CapabilityTranscribe
+	}) {
+		kv = m.decodeKV()
+	}
+
 	var errs []error
 	for _, cap := range caps {
 		switch cap {
 		case CapabilityCompletion:
-			r, err := os.Open(m.ModelPath)
-			if err != nil {
-				slog.Error("couldn't open model file", "error", err)
-				continue
-			}
-			defer r.Close()
-
-			// TODO(mxyng): decode the GGML into model to avoid doing this multiple times
-			f, _, err := ggml.Decode(r, 0)
-			if err != nil {
-				slog.Error("couldn't decode ggml", "error", err)
+			if kv == nil {
 				continue
 			}
 
-			if _, ok := f.KV()[fmt.Sprintf("%s.pooling_type", f.KV().Architecture())]; ok {
+			if _, ok := kv[fmt.Sprintf("%s.pooling_type", kv.Architecture())]; ok {
 				errs = append(errs, errCapabilityCompletion)
 			}
 		case CapabilityTools:
@@ -104,6 +121,33 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 			if !slices.Contains(vars, "suffix") {
 				errs = append(errs, errCapabilityInsert)
 			}
+		case CapabilityRerank:
+			if kv == nil {
+				continue
+			}
+
+			// cross-encoders attach a classification head with rank pooling
+			if kv.Uint("pooling_type") != 4 {
+				errs = append(errs, errCapabilityRerank)
+			}
+		case CapabilityAudio:
+			if kv == nil {
+				continue
+			}
+
+			if kv.Uint("audio.block_count") == 0 {
+				errs = append(errs, errCapabilityAudio)
+			}
+		case CapabilityTranscribe:
+			if kv == nil {
+				continue
+			}
+
+			// speech recognition models decode text from the audio encoder
+			// through cross attention
+			if kv.Architecture() != "whisper" {
+				errs = append(errs, errCapabilityTranscribe)
+			}
 		default:
 			slog.Error("unknown capability", "capability", cap)
 			return fmt.Errorf("unknown capability: %s", cap)
@@ -117,6 +161,30 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 	return nil
 }
 
+// decodeKV reads the metadata of the model file, logging and returning nil
+// if it can't be read. The metadata is only read the first time.
+func (m *Model) decodeKV() ggml.KV {
+	if m.kv != nil {
+		return m.kv
+	}
+
+	r, err := os.Open(m.ModelPath)
+	if err != nil {
+		slog.Error("couldn't open model file", "error", err)
+		return nil
+	}
+	defer r.Close()
+
+	f, _, err := ggml.Decode(r, 0)
+	if err != nil {
+		slog.Error("couldn't decode ggml", "error", err)
+		return nil
+	}
+
+	m.kv = f.KV()
+	return m.kv
+}
+
 func (m *Model) String() string {
 	var modelfile parser.Modelfile
 
@@ -510,14 +578,21 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return err
 	}
 
+	const mediaType = "application/vnd.docker.distribution.manifest.v2+json"
 	headers := make(http.Header)
-	headers.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	headers.Set("Content-Type", mediaType)
 	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(manifestJSON), regOpts)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if regOpts.Sign {
+		if err := pushSignature(ctx, mp, manifestJSON, mediaType, regOpts, fn); err != nil {
+			return err
+		}
+	}
+
 	fn(api.ProgressResponse{Status: "success"})
 
 	return nil
@@ -553,6 +628,26 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return fmt.Errorf("pull model manifest: %s", err)
 	}
 
+	if path := envconfig.TrustedKeys(); path != "" {
+		fn(api.ProgressResponse{Status: "verifying signature"})
+		if err := verifyManifestSignature(ctx, mp, "sha256:"+manifest.digest, path, regOpts); err != nil {
+			return err
+		}
+	}
+
+	if regOpts.Window.IsZero() {
+		regOpts.Window, err = throttle.ParseWindow(envconfig.DownloadWindow())
+		if err != nil {
+			return err
+		}
+	}
+	if !regOpts.Window.Open(time.Now()) {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("waiting for download window %s", regOpts.Window)})
+		if err := regOpts.Window.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
 	var layers []Layer
 	layers = append(layers, manifest.Layers...)
 	if manifest.Config.Digest != "" {
@@ -630,7 +725,7 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 }
 
 func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, error) {
-	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
+	requestURL := mp.PullURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
 
 	headers := make(http.Header)
 	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
@@ -640,10 +735,16 @@ func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptio
 	}
 	defer resp.Body.Close()
 
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	var m Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+	if err := json.Unmarshal(data, &m); err != nil {
 		return nil, err
 	}
+	m.digest = fmt.Sprintf("%x", sha256.Sum256(data))
 
 	return &m, err
 }
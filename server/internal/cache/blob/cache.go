@@ -210,6 +210,34 @@ func (c *DiskCache) Put(d Digest, r io.Reader, size int64) error {
 	return c.copyNamedFile(c.GetFile(d), r, d, size)
 }
 
+// CreateTemp creates a new temporary file in the blobs directory of the cache,
+// for data that is later put into place with [DiskCache.PutFile] without
+// copying it again. Its name is made with pattern as by [os.CreateTemp], and
+// is skipped by [DiskCache.Blobs]. The caller removes the file when done with
+// it.
+func (c *DiskCache) CreateTemp(pattern string) (*os.File, error) {
+	return os.CreateTemp(filepath.Join(c.dir, "blobs"), pattern)
+}
+
+// PutFile puts the file name, created with [DiskCache.CreateTemp], into place
+// as the blob with digest d, without copying it. The file is linked into
+// place where possible, so it can still be read, and removed, by its name.
+//
+// PutFile does not check the content of the file. The caller is responsible
+// for ensuring it has the digest d.
+func (c *DiskCache) PutFile(d Digest, name string) error {
+	final := c.GetFile(d)
+	if err := os.Link(name, final); err != nil {
+		// e.g. the blob is already in the cache, or links are not
+		// supported
+		if err := os.Rename(name, final); err != nil {
+			return err
+		}
+	}
+	os.Chtimes(final, c.now(), c.now()) // mainly for tests
+	return nil
+}
+
 // Import imports a blob from the provided reader into the cache. It reads the
 // entire content of the reader, calculates its digest, and stores it in the
 // cache.
@@ -317,6 +345,57 @@ func (c *DiskCache) Unlink(name string) (ok bool, _ error) {
 	return true, err
 }
 
+// Touch sets the time of the blob identified by d to now, to record that it
+// was used, e.g. so the least recently used blobs can be found with [Blobs].
+func (c *DiskCache) Touch(d Digest) error {
+	now := c.now()
+	return os.Chtimes(c.GetFile(d), now, now)
+}
+
+// Remove removes the blob identified by d from the cache. Manifests that
+// reference the blob are not removed. It is not an error if the blob does
+// not exist.
+func (c *DiskCache) Remove(d Digest) error {
+	if err := c.removeContentChunks(d); err != nil {
+		return err
+	}
+	err := os.Remove(c.GetFile(d))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Blobs returns a sequence of the entries of the blobs in the cache, in
+// lexical order of their digests. Files in the blobs directory that are not
+// blobs, such as partial downloads, are skipped.
+func (c *DiskCache) Blobs() iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		entries, err := os.ReadDir(filepath.Join(c.dir, "blobs"))
+		if err != nil {
+			yield(Entry{}, err)
+			return
+		}
+		for _, e := range entries {
+			d, err := ParseDigest(e.Name())
+			if err != nil || !e.Type().IsRegular() {
+				continue
+			}
+			info, err := e.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // removed underfoot
+			}
+			if err != nil {
+				yield(Entry{}, err)
+				return
+			}
+			if !yield(Entry{Digest: d, Size: info.Size(), Time: info.ModTime()}, nil) {
+				return
+			}
+		}
+	}
+}
+
 // GetFile returns the absolute path to the file, in the cache, for the given
 // digest. It does not check if the file exists.
 //
@@ -405,6 +405,44 @@ func TestCommit(t *testing.T) {
 	testutil.CheckTime(t, info.ModTime(), t0)
 }
 
+func TestBlobs(t *testing.T) {
+	check := testutil.Checker(t)
+
+	c, sleep := openTester(t)
+	t0 := epoch
+
+	d1, d2 := mkdigest("1"), mkdigest("22")
+	check(PutBytes(c, d1, "1"))
+	check(PutBytes(c, d2, "22"))
+
+	// partial downloads are not blobs
+	check(os.WriteFile(c.GetFile(d1)+"-partial", nil, 0o666))
+
+	t1 := sleep(time.Hour)
+	check(c.Touch(d2))
+
+	got := make(map[Digest]Entry)
+	for e, err := range c.Blobs() {
+		check(err)
+		got[e.Digest] = e
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("len(Blobs) = %d, want 2", len(got))
+	}
+	if e := got[d1]; e.Size != 1 {
+		t.Errorf("size = %d, want 1", e.Size)
+	}
+	testutil.CheckTime(t, got[d1].Time, t0)
+	testutil.CheckTime(t, got[d2].Time, t1)
+
+	check(c.Remove(d1))
+	check(c.Remove(d1)) // removing a missing blob is not an error
+	if _, err := c.Get(d1); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("err = %v, want fs.ErrNotExist", err)
+	}
+}
+
 func TestManifestInvalidBlob(t *testing.T) {
 	c, _ := openTester(t)
 	d := mkdigest("1")
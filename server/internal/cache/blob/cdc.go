@@ -0,0 +1,248 @@
+package blob
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A ChunkSum is a chunk of a blob and the digest of its data.
+type ChunkSum struct {
+	Chunk  Chunk
+	Digest Digest
+}
+
+// String returns the chunksum in the form "<digest> <start>-<end>", the form
+// of the lines of chunksums responses and of the chunk indexes kept in the
+// cache.
+func (cs ChunkSum) String() string {
+	return fmt.Sprintf("%s %d-%d", cs.Digest, cs.Chunk.Start, cs.Chunk.End)
+}
+
+// gear is the table of random values the rolling hash of [SplitContent] is
+// computed from. It must never change: chunk boundaries, and so which chunks
+// of different blobs match, depend on it.
+var gear = func() (t [256]uint64) {
+	x := uint64(0x6f6c6c616d61) // "ollama"
+	for i := range t {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// SplitContent splits the data read from r into content-defined chunks, and
+// yields them in order along with the digests of their data.
+//
+// Boundaries between chunks are placed where a rolling hash of the last 64
+// bytes matches a pattern, so they depend on the data around them and not on
+// their offsets. Data inserted into, removed from, or changed in a blob only
+// changes the chunks around the change, and the other chunks are the same as
+// those of the original blob, which lets chunks be shared between versions of
+// a blob, and between blobs with data in common.
+//
+// Chunks are avg bytes long on average, rounded down to a power of two, and
+// between a quarter and four times that, except for the last chunk, which may
+// be shorter.
+func SplitContent(r io.Reader, avg int64) iter.Seq2[ChunkSum, error] {
+	return func(yield func(ChunkSum, error) bool) {
+		shift := bits.Len64(uint64(max(avg, 1))) - 1
+		minSize := max(int64(1)<<shift/4, 1)
+		maxSize := int64(1) << shift * 4
+
+		// A boundary is placed after a byte when the top bits of the
+		// hash are all zero, which happens once every 1<<shift bytes
+		// on average.
+		mask := ^uint64(0) << (64 - shift)
+
+		h := sha256.New()
+		var start, n int64 // the offset and length of the chunk
+		var hash uint64
+		emit := func() bool {
+			var d Digest
+			h.Sum(d.sum[:0])
+			h.Reset()
+			cs := ChunkSum{Chunk{start, start + n - 1}, d}
+			start, n, hash = start+n, 0, 0
+			return yield(cs, nil)
+		}
+
+		buf := make([]byte, 1<<20)
+		for {
+			m, err := io.ReadFull(r, buf)
+			b := buf[:m]
+			for len(b) > 0 {
+				// No boundaries are looked for before the
+				// minimum size.
+				i := int(min(max(minSize-n, 0), int64(len(b))))
+				end, cut := len(b), false
+				for ; i < len(b); i++ {
+					hash = hash<<1 + gear[b[i]]
+					if hash&mask == 0 || n+int64(i)+1 >= maxSize {
+						end, cut = i+1, true
+						break
+					}
+				}
+				h.Write(b[:end])
+				n += int64(end)
+				b = b[end:]
+				if cut && !emit() {
+					return
+				}
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				if n > 0 {
+					emit()
+				}
+				return
+			}
+			if err != nil {
+				yield(ChunkSum{}, err)
+				return
+			}
+		}
+	}
+}
+
+// ParseChunkSums parses chunksums in the form written by [ChunkSum.String],
+// one per line.
+func ParseChunkSums(data []byte) ([]ChunkSum, error) {
+	var chunks []ChunkSum
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		digest, chunk, ok := strings.Cut(s.Text(), " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid chunksum %q", s.Text())
+		}
+		d, err := ParseDigest(digest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunksum %q: %w", s.Text(), err)
+		}
+		startStr, endStr, _ := strings.Cut(chunk, "-")
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunksum %q: %w", s.Text(), err)
+		}
+		end, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid chunksum %q: invalid range", s.Text())
+		}
+		chunks = append(chunks, ChunkSum{Chunk{start, end}, d})
+	}
+	return chunks, s.Err()
+}
+
+// ContentChunks returns the chunks of the blob d, as split by [SplitContent]
+// with average size avg. The chunks are computed the first time they are
+// asked for, or given to [DiskCache.PutContentChunks], and kept in the cache
+// with the blob.
+func (c *DiskCache) ContentChunks(d Digest, avg int64) ([]ChunkSum, error) {
+	return c.ContentChunksFunc(context.Background(), d, avg, nil)
+}
+
+// ContentChunksFunc is like [DiskCache.ContentChunks], but if the chunks have
+// to be computed, fn, if not nil, is called with the number of bytes of the
+// blob read so far, and computing them stops with the error of ctx once it is
+// done.
+func (c *DiskCache) ContentChunksFunc(ctx context.Context, d Digest, avg int64, fn func(n int64)) ([]ChunkSum, error) {
+	data, err := os.ReadFile(c.chunksumsFile(d, avg))
+	if err == nil {
+		if chunks, err := ParseChunkSums(data); err == nil {
+			return chunks, nil
+		}
+		// recompute a damaged index
+	}
+
+	f, err := os.Open(c.GetFile(d))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var chunks []ChunkSum
+	for cs, err := range SplitContent(&progressReader{ctx: ctx, r: f, fn: fn}, avg) {
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, cs)
+	}
+	return chunks, c.PutContentChunks(d, avg, chunks)
+}
+
+// progressReader reads from r, calling fn with the number of bytes read so
+// far, until ctx is done.
+type progressReader struct {
+	ctx context.Context
+	r   io.Reader
+	n   int64
+	fn  func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.fn != nil && n > 0 {
+		r.fn(r.n)
+	}
+	return n, err
+}
+
+// PutContentChunks records chunks as the chunks of the blob d, as split by
+// [SplitContent] with average size avg, so they are not computed again by
+// [DiskCache.ContentChunks]. The chunks are not checked against the blob.
+func (c *DiskCache) PutContentChunks(d Digest, avg int64, chunks []ChunkSum) error {
+	var b bytes.Buffer
+	for _, cs := range chunks {
+		b.WriteString(cs.String())
+		b.WriteByte('\n')
+	}
+
+	name := c.chunksumsFile(d, avg)
+	if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+		return err
+	}
+
+	// Write the index to a temporary file and rename it, so it is never
+	// seen half written.
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0o666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (c *DiskCache) chunksumsFile(d Digest, avg int64) string {
+	return absJoin(c.dir, "chunksums", fmt.Sprintf("sha256-%x-%d", d.sum, avg))
+}
+
+// removeContentChunks removes the chunk indexes of the blob d.
+func (c *DiskCache) removeContentChunks(d Digest) error {
+	names, err := filepath.Glob(absJoin(c.dir, "chunksums", fmt.Sprintf("sha256-%x-*", d.sum)))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
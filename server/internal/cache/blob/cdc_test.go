@@ -0,0 +1,140 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/server/internal/testutil"
+)
+
+func splitContent(t *testing.T, data []byte, avg int64) []ChunkSum {
+	t.Helper()
+	var chunks []ChunkSum
+	var next int64
+	for cs, err := range SplitContent(bytes.NewReader(data), avg) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cs.Chunk.Start != next {
+			t.Fatalf("chunk %v does not start at %d", cs.Chunk, next)
+		}
+		if cs.Chunk.Size() > avg*4 {
+			t.Fatalf("chunk %v is larger than %d", cs.Chunk, avg*4)
+		}
+		if got := DigestFromBytes(data[cs.Chunk.Start : cs.Chunk.End+1]); got != cs.Digest {
+			t.Fatalf("chunk %v digest = %v; want %v", cs.Chunk, cs.Digest, got)
+		}
+		next = cs.Chunk.End + 1
+		chunks = append(chunks, cs)
+	}
+	if next != int64(len(data)) {
+		t.Fatalf("chunks cover %d bytes; want %d", next, len(data))
+	}
+	return chunks
+}
+
+func TestSplitContent(t *testing.T) {
+	const avg = 1 << 10
+	data := make([]byte, 256<<10)
+	rand.NewChaCha8([32]byte{}).Read(data)
+
+	chunks := splitContent(t, data, avg)
+	if n := len(chunks); n < 128 || n > 512 {
+		t.Errorf("got %d chunks; want about %d", n, len(data)/avg)
+	}
+
+	// Inserting data only changes the chunks around the insertion.
+	edited := append(bytes.Clone(data[:100<<10]), "inserted"...)
+	edited = append(edited, data[100<<10:]...)
+
+	digests := make(map[Digest]bool)
+	for _, cs := range chunks {
+		digests[cs.Digest] = true
+	}
+	var changed int
+	for _, cs := range splitContent(t, edited, avg) {
+		if !digests[cs.Digest] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 3 {
+		t.Errorf("%d chunks changed; want 1 to 3", changed)
+	}
+
+	if chunks := splitContent(t, nil, avg); len(chunks) != 0 {
+		t.Errorf("empty data has chunks %v", chunks)
+	}
+}
+
+func TestContentChunks(t *testing.T) {
+	check := testutil.Checker(t)
+
+	c, err := Open(t.TempDir())
+	check(err)
+
+	data := bytes.Repeat([]byte("hello, world\n"), 1000)
+	d := DigestFromBytes(data)
+	check(PutBytes(c, d, data))
+
+	want := splitContent(t, data, 64)
+	got, err := c.ContentChunks(d, 64)
+	check(err)
+	if !slices.Equal(got, want) {
+		t.Fatalf("chunks = %v; want %v", got, want)
+	}
+
+	// the index is read back from the cache
+	got, err = c.ContentChunks(d, 64)
+	check(err)
+	if !slices.Equal(got, want) {
+		t.Fatalf("cached chunks = %v; want %v", got, want)
+	}
+
+	// and removed with the blob
+	check(c.Remove(d))
+	if _, err := c.ContentChunks(d, 64); err == nil {
+		t.Fatal("expected error for removed blob")
+	}
+}
+
+func TestContentChunksFunc(t *testing.T) {
+	check := testutil.Checker(t)
+
+	c, err := Open(t.TempDir())
+	check(err)
+
+	data := bytes.Repeat([]byte("hello, world\n"), 1000)
+	d := DigestFromBytes(data)
+	check(PutBytes(c, d, data))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := c.ContentChunksFunc(ctx, d, 64, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v; want %v", err, context.Canceled)
+	}
+
+	var last int64
+	got, err := c.ContentChunksFunc(t.Context(), d, 64, func(n int64) {
+		if n <= last {
+			t.Errorf("progress %d after %d", n, last)
+		}
+		last = n
+	})
+	check(err)
+	if want := splitContent(t, data, 64); !slices.Equal(got, want) {
+		t.Fatalf("chunks = %v; want %v", got, want)
+	}
+	if last != int64(len(data)) {
+		t.Errorf("progress = %d; want %d", last, len(data))
+	}
+
+	// chunks already computed are not read again
+	_, err = c.ContentChunksFunc(t.Context(), d, 64, func(n int64) {
+		t.Errorf("progress %d for computed chunks", n)
+	})
+	check(err)
+}
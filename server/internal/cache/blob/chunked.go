@@ -1,10 +1,16 @@
 package blob
 
 import (
+	"cmp"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"slices"
+	"sync"
 )
 
 // Chunk represents a range of bytes in a blob.
@@ -20,37 +26,173 @@ func (c Chunk) Size() int64 {
 
 // Chunker writes to a blob in chunks.
 // Its zero value is invalid. Use [DiskCache.Chunked] to create a new Chunker.
+//
+// Chunks are written to a partial file next to the blob. Each chunk is
+// recorded in a state file, alongside the partial file, once its data is
+// verified and synced to disk, so a Chunker for the same blob created later,
+// e.g. after the process was interrupted, resumes from the recorded chunks.
+// When all bytes of the blob have been written, the partial file is moved
+// into place.
+//
+// Chunkers for the same blob in one process, such as those of concurrent
+// pulls of models sharing a layer, are the same Chunker, so they don't
+// overwrite each other's state file or move the partial file from under each
+// other. It is closed when all of them are.
 type Chunker struct {
 	digest Digest
 	size   int64
+	name   string   // the name of the blob file
 	f      *os.File // nil means pre-validated
+
+	mu     sync.Mutex
+	chunks []chunkRecord // verified chunks; guarded by mu
+	done   bool          // guarded by mu and fmu
+
+	// fmu is held for reading while chunks are written to f, and for
+	// writing while f is moved into place, so that a Chunker shared by
+	// several users doesn't write to the blob after it was committed.
+	fmu sync.RWMutex
+
+	refs int // the number of users of c; guarded by writing
+}
+
+// writing holds the Chunkers open for each blob file in this process.
+var writing struct {
+	sync.Mutex
+	chunkers map[string]*Chunker
+}
+
+// Writing reports whether a [Chunker] is writing to the blob file name, as
+// returned by [DiskCache.GetFile], in this process. Its partial and state
+// files are in use, however long ago they were last written, such as while a
+// pull waits for its download window.
+func Writing(name string) bool {
+	writing.Lock()
+	defer writing.Unlock()
+	return writing.chunkers[name] != nil
+}
+
+// chunkState is the content of the state file of a Chunker.
+type chunkState struct {
+	Size   int64         `json:"size"`
+	Chunks []chunkRecord `json:"chunks"`
+}
+
+type chunkRecord struct {
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Digest Digest `json:"digest"`
 }
 
 // Chunked returns a new Chunker, ready for use storing a blob of the given
-// size in chunks.
+// size in chunks, resuming from the chunks recorded by a previous Chunker for
+// the blob, if any. If a Chunker for the blob is already open, it is returned
+// instead, and must be closed once more.
 //
 // Use [Chunker.Put] to write data to the blob at specific offsets.
 func (c *DiskCache) Chunked(d Digest, size int64) (*Chunker, error) {
 	name := c.GetFile(d)
+
+	writing.Lock()
+	defer writing.Unlock()
+	if ch := writing.chunkers[name]; ch != nil {
+		if ch.size != size {
+			return nil, fmt.Errorf("blob %v is being written with size %d, not %d", d, ch.size, size)
+		}
+		ch.refs++
+		return ch, nil
+	}
+
 	info, err := os.Stat(name)
 	if err == nil && info.Size() == size {
 		return &Chunker{}, nil
 	}
-	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0o666)
+	if size == 0 {
+		return &Chunker{}, PutBytes(c, d, "")
+	}
+
+	f, err := os.OpenFile(name+"-partial", os.O_CREATE|os.O_RDWR, 0o666)
 	if err != nil {
 		return nil, err
 	}
-	return &Chunker{digest: d, size: size, f: f}, nil
+	ch := &Chunker{digest: d, size: size, name: name, f: f}
+	if err := ch.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if ch.covered() {
+		// all chunks were written, but the blob was not moved into
+		// place
+		if err := ch.commit(); err != nil {
+			return nil, err
+		}
+		return &Chunker{}, nil
+	}
+	if writing.chunkers == nil {
+		writing.chunkers = make(map[string]*Chunker)
+	}
+	ch.refs = 1
+	writing.chunkers[name] = ch
+	return ch, nil
+}
+
+// Completed reports whether chunk, with digest d, has been written to the
+// blob, by this Chunker or a previous one.
+func (c *Chunker) Completed(chunk Chunk, d Digest) bool {
+	if c.f == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done || slices.Contains(c.chunks, chunkRecord{chunk.Start, chunk.End, d})
 }
 
 // Put copies chunk.Size() bytes from r to the blob at the given offset,
 // merging the data with the existing blob. It returns an error if any. As a
 // special case, if r has less than chunk.Size() bytes, Put returns
 // io.ErrUnexpectedEOF.
+//
+// The data must have the digest d, or an error is returned and the chunk is
+// not recorded as written. Chunks already written are skipped, without
+// reading r.
 func (c *Chunker) Put(chunk Chunk, d Digest, r io.Reader) error {
-	if c.f == nil {
+	if c.Completed(chunk, d) {
+		return nil
+	}
+
+	if done, err := c.write(chunk, d, r); done || err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
 		return nil
 	}
+	c.chunks = append(c.chunks, chunkRecord{chunk.Start, chunk.End, d})
+	if c.covered() {
+		c.fmu.Lock()
+		err := c.commit()
+		c.fmu.Unlock()
+
+		// later Chunkers for the blob find it in place, or resume
+		// from the partial file if it couldn't be moved there
+		writing.Lock()
+		delete(writing.chunkers, c.name)
+		writing.Unlock()
+		return err
+	}
+	return c.save()
+}
+
+// write copies chunk from r to the partial file and syncs it, unless the blob
+// was committed by another user of c, which write reports.
+func (c *Chunker) write(chunk Chunk, d Digest, r io.Reader) (done bool, _ error) {
+	c.fmu.RLock()
+	defer c.fmu.RUnlock()
+	if c.done {
+		return true, nil
+	}
 
 	cw := &checkWriter{
 		d:    d,
@@ -62,12 +204,121 @@ func (c *Chunker) Put(chunk Chunk, d Digest, r io.Reader) error {
 
 	_, err := io.CopyN(cw, r, chunk.Size())
 	if err != nil && errors.Is(err, io.EOF) {
-		return io.ErrUnexpectedEOF
+		return false, io.ErrUnexpectedEOF
 	}
-	return err
+	if err != nil {
+		return false, err
+	}
+
+	// The chunk is verified. Make sure it is on disk before recording
+	// it, so that it is not skipped after a crash if it is not.
+	return false, c.f.Sync()
 }
 
-// Close closes the underlying file.
+// Close closes the underlying file, once every user of c closed it. Chunks
+// written are kept for a later Chunker to resume from.
 func (c *Chunker) Close() error {
+	if c.f == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return nil
+	}
+
+	writing.Lock()
+	c.refs--
+	last := c.refs == 0
+	if last {
+		delete(writing.chunkers, c.name)
+	}
+	writing.Unlock()
+
+	if !last {
+		return nil
+	}
 	return c.f.Close()
 }
+
+func (c *Chunker) statePath() string {
+	return c.name + "-partial.json"
+}
+
+// load reads the chunks recorded in the state file. Chunks recorded for a
+// blob of another size, or that are not in the partial file, are ignored.
+func (c *Chunker) load() error {
+	data, err := os.ReadFile(c.statePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var s chunkState
+	if err := json.Unmarshal(data, &s); err != nil || s.Size != c.size {
+		return nil // start over
+	}
+	info, err := c.f.Stat()
+	if err != nil {
+		return err
+	}
+	for _, r := range s.Chunks {
+		if r.Start >= 0 && r.Start <= r.End && r.End < min(c.size, info.Size()) {
+			c.chunks = append(c.chunks, r)
+		}
+	}
+	return nil
+}
+
+// save writes the recorded chunks to the state file. It must be called with
+// c.mu held.
+func (c *Chunker) save() error {
+	data, err := json.Marshal(chunkState{Size: c.size, Chunks: c.chunks})
+	if err != nil {
+		return err
+	}
+
+	// Write the state to a temporary file and rename it, so the state
+	// file is never seen half written.
+	tmp := c.statePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.statePath())
+}
+
+// covered reports whether the recorded chunks cover every byte of the blob.
+// It must be called with c.mu held, or before c is shared.
+func (c *Chunker) covered() bool {
+	chunks := slices.SortedFunc(slices.Values(c.chunks), func(a, b chunkRecord) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	var next int64
+	for _, r := range chunks {
+		if r.Start > next {
+			return false
+		}
+		next = max(next, r.End+1)
+	}
+	return next >= c.size
+}
+
+// commit moves the partial file into place as the blob, and removes the
+// state file. It must be called with c.mu and c.fmu held, or before c is
+// shared.
+func (c *Chunker) commit() error {
+	if err := c.f.Close(); err != nil {
+		return err
+	}
+	c.done = true
+	if err := os.Truncate(c.name+"-partial", c.size); err != nil {
+		return err
+	}
+	if err := os.Rename(c.name+"-partial", c.name); err != nil {
+		return err
+	}
+	os.Remove(c.statePath())
+	return nil
+}
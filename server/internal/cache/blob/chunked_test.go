@@ -0,0 +1,156 @@
+package blob
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ollama/ollama/server/internal/testutil"
+)
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("read") }
+
+func TestChunkedResume(t *testing.T) {
+	check := testutil.Checker(t)
+
+	c, err := Open(t.TempDir())
+	check(err)
+
+	const data = "hello, world"
+	d := DigestFromBytes(data)
+	chunks := []Chunk{{0, 3}, {4, 7}, {8, 11}}
+	put := func(ch *Chunker, i int, s string) error {
+		chunk := chunks[i]
+		return ch.Put(chunk, DigestFromBytes(data[chunk.Start:chunk.End+1]), strings.NewReader(s))
+	}
+
+	ch, err := c.Chunked(d, int64(len(data)))
+	check(err)
+	check(put(ch, 0, "hell"))
+	if err := put(ch, 1, "XXXX"); err == nil {
+		t.Fatal("expected error for chunk with wrong digest")
+	}
+	if !Writing(c.GetFile(d)) {
+		t.Error("open chunker not writing")
+	}
+	check(ch.Close())
+	if Writing(c.GetFile(d)) {
+		t.Error("closed chunker writing")
+	}
+
+	if _, err := c.Get(d); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("err = %v; want not exist", err)
+	}
+
+	ch, err = c.Chunked(d, int64(len(data)))
+	check(err)
+	if !ch.Completed(chunks[0], DigestFromBytes("hell")) {
+		t.Error("verified chunk not resumed")
+	}
+	if ch.Completed(chunks[1], DigestFromBytes("o, w")) {
+		t.Error("unverified chunk resumed")
+	}
+
+	// completed chunks are not read again
+	check(ch.Put(chunks[0], DigestFromBytes("hell"), errReader{}))
+	check(put(ch, 2, "orld"))
+	check(put(ch, 1, "o, w"))
+	if Writing(c.GetFile(d)) {
+		t.Error("complete blob writing")
+	}
+	check(ch.Close())
+
+	got, err := os.ReadFile(c.GetFile(d))
+	check(err)
+	if string(got) != data {
+		t.Errorf("data = %q; want %q", got, data)
+	}
+
+	// the partial and state files are removed
+	entries, err := os.ReadDir(filepath.Join(c.dir, "blobs"))
+	check(err)
+	if len(entries) != 1 {
+		t.Errorf("blobs = %v; want 1 entry", entries)
+	}
+
+	ch, err = c.Chunked(d, int64(len(data)))
+	check(err)
+	if !ch.Completed(chunks[1], DigestFromBytes("XXXX")) {
+		t.Error("complete blob not pre-validated")
+	}
+	check(ch.Put(chunks[1], DigestFromBytes("XXXX"), errReader{}))
+}
+
+func TestChunkedConcurrent(t *testing.T) {
+	check := testutil.Checker(t)
+
+	c, err := Open(t.TempDir())
+	check(err)
+
+	const data = "hello, world"
+	d := DigestFromBytes(data)
+	chunks := []Chunk{{0, 3}, {4, 7}, {8, 11}}
+
+	// two writers of the same blob, such as concurrent pulls of models
+	// sharing a layer, share a Chunker
+	var chs []*Chunker
+	for range 2 {
+		ch, err := c.Chunked(d, int64(len(data)))
+		check(err)
+		chs = append(chs, ch)
+	}
+	if chs[0] != chs[1] {
+		t.Fatal("Chunkers for the same blob are not shared")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(chs)*len(chunks))
+	for _, ch := range chs {
+		for _, chunk := range chunks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s := data[chunk.Start : chunk.End+1]
+				errs <- ch.Put(chunk, DigestFromBytes(s), strings.NewReader(s))
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		check(err)
+	}
+	for _, ch := range chs {
+		check(ch.Close())
+	}
+
+	got, err := os.ReadFile(c.GetFile(d))
+	check(err)
+	if string(got) != data {
+		t.Errorf("data = %q; want %q", got, data)
+	}
+	if Writing(c.GetFile(d)) {
+		t.Error("complete blob writing")
+	}
+
+	// a Chunker closed by one of its users is still open for the other
+	d = DigestFromBytes("other")
+	a, err := c.Chunked(d, 5)
+	check(err)
+	b, err := c.Chunked(d, 5)
+	check(err)
+	check(a.Close())
+	if !Writing(c.GetFile(d)) {
+		t.Error("Chunker closed while in use")
+	}
+	check(b.Put(Chunk{0, 4}, d, strings.NewReader("other")))
+	check(b.Close())
+	if _, err := c.Get(d); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,276 @@
+package ollama
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DockerConfig is the part of a Docker config file, such as
+// $HOME/.docker/config.json, that holds the credentials for registries,
+// keyed by their host or URL.
+type DockerConfig struct {
+	Auths map[string]DockerAuth `json:"auths"`
+}
+
+// DockerAuth is the credentials for a registry in a [DockerConfig]. Auth, if
+// set, is the base64 encoding of "<username>:<password>", as written by
+// "docker login", and takes precedence over Username and Password.
+type DockerAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ReadDockerConfig reads the Docker config file at path. Credential helpers
+// and stores configured in the file are not supported, so only credentials
+// stored in the file itself are used.
+func ReadDockerConfig(path string) (*DockerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c DockerConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Credentials returns the username and password for the registry host in
+// c. It has the signature of [Registry.Credentials].
+func (c *DockerConfig) Credentials(host string) (username, password string, ok bool) {
+	for k, a := range c.Auths {
+		// Keys are hosts, or URLs for registries logged into with
+		// older versions of docker, e.g. https://index.docker.io/v1/.
+		if u, err := url.Parse(k); err == nil && u.Host != "" {
+			k = u.Host
+		}
+		if !strings.EqualFold(k, host) {
+			continue
+		}
+
+		if a.Auth != "" {
+			data, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return "", "", false
+			}
+			username, password, ok = strings.Cut(string(data), ":")
+			return username, password, ok
+		}
+		return a.Username, a.Password, a.Username != ""
+	}
+	return "", "", false
+}
+
+// dockerConfigPath returns the path of the Docker config file, which is in
+// $DOCKER_CONFIG, or in $HOME/.docker if it is not set.
+func dockerConfigPath(home string) string {
+	return filepath.Join(cmp.Or(os.Getenv("DOCKER_CONFIG"), filepath.Join(home, ".docker")), "config.json")
+}
+
+// challenge is a parsed WWW-Authenticate header, e.g.
+//
+//	Bearer realm="https://auth.example.com/token",service="registry",scope="repository:library/smol:pull"
+type challenge struct {
+	scheme string // lower case
+	params map[string]string
+}
+
+// parseChallenge parses the WWW-Authenticate header s. It reports false if
+// s is not a single challenge with a scheme.
+func parseChallenge(s string) (challenge, bool) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(s), " ")
+	if scheme == "" {
+		return challenge{}, false
+	}
+
+	c := challenge{scheme: strings.ToLower(scheme), params: make(map[string]string)}
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		if rest == "" {
+			return c, true
+		}
+
+		var key string
+		var ok bool
+		key, rest, ok = strings.Cut(rest, "=")
+		if !ok {
+			return challenge{}, false
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				return challenge{}, false
+			}
+			rest = rest[i+1:]
+		} else {
+			v, tail, _ := strings.Cut(rest, ",")
+			value.WriteString(strings.TrimSpace(v))
+			rest = tail
+		}
+		c.params[key] = value.String()
+	}
+}
+
+// authScope returns the key the Authorization header for requests to u is
+// kept under, which is its host, and its repository if it has one. Tokens
+// are usually issued for a single repository.
+func authScope(u *url.URL) string {
+	p, ok := strings.CutPrefix(u.Path, "/v2/")
+	if parts := strings.SplitN(p, "/", 3); ok && len(parts) == 3 {
+		return u.Host + "/" + parts[0] + "/" + parts[1]
+	}
+	return u.Host
+}
+
+// authenticate answers the challenge, the WWW-Authenticate header of a
+// response from host, and returns the Authorization header for requests to
+// host to be sent with.
+//
+// Bearer challenges are answered with a token from the realm of the
+// challenge, which is requested with the credentials for host, if there are
+// any, as in the token authentication of the OCI distribution spec, or
+// otherwise signed with [Registry.Key] like the original client. Basic
+// challenges are answered with the credentials for host.
+func (r *Registry) authenticate(ctx context.Context, host, header string) (string, error) {
+	c, ok := parseChallenge(header)
+	if !ok {
+		return "", fmt.Errorf("invalid challenge %q", header)
+	}
+
+	var username, password string
+	var hasCredentials bool
+	if r.Credentials != nil {
+		username, password, hasCredentials = r.Credentials(host)
+	}
+
+	switch c.scheme {
+	case "basic":
+		if !hasCredentials {
+			return "", fmt.Errorf("no credentials for %s", host)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported challenge scheme %q", c.scheme)
+	}
+
+	realm, err := url.Parse(c.params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid challenge realm %q", c.params["realm"])
+	}
+	q := realm.Query()
+	if service := c.params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	for scope := range strings.FieldsSeq(c.params["scope"]) {
+		q.Add("scope", scope)
+	}
+	if !hasCredentials && r.Key != nil {
+		q.Set("ts", strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if r.UserAgent != "" {
+		req.Header.Set("User-Agent", r.UserAgent)
+	}
+	if hasCredentials {
+		req.SetBasicAuth(username, password)
+	} else if r.Key != nil {
+		pub, sig, err := signURL(r.Key, realm.String())
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", pub+":"+sig)
+	}
+
+	res, err := sendRequest(r.client(), req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var v struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	token := cmp.Or(v.Token, v.AccessToken)
+	if token == "" {
+		return "", errors.New("invalid token response: no token")
+	}
+	return "Bearer " + token, nil
+}
+
+// do sends req like sendRequest, with the Authorization header obtained for
+// its repository, if any. If the registry answers with an authentication
+// challenge, it is answered and the request sent again, once, provided its
+// body can be.
+func (r *Registry) do(req *http.Request) (*http.Response, error) {
+	scope := authScope(req.URL)
+	r.authMu.Lock()
+	auth := r.auth[scope]
+	r.authMu.Unlock()
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	res, err := sendRequest(r.client(), req)
+	var e *Error
+	if !errors.As(err, &e) || e.Status != 401 || e.challenge == "" {
+		return res, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return nil, err
+	}
+
+	auth, aerr := r.authenticate(req.Context(), req.URL.Host, e.challenge)
+	if aerr != nil {
+		// the token endpoint's error, such as access being denied, says
+		// more about why the request failed than the challenge did
+		return nil, errors.Join(fmt.Errorf("authenticating with %s: %w", req.URL.Host, aerr), err)
+	}
+
+	r.authMu.Lock()
+	if r.auth == nil {
+		r.auth = make(map[string]string)
+	}
+	r.auth[scope] = auth
+	r.authMu.Unlock()
+
+	req = req.Clone(req.Context())
+	if req.GetBody != nil {
+		req.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Authorization", auth)
+	return sendRequest(r.client(), req)
+}
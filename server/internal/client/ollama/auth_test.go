@@ -0,0 +1,152 @@
+package ollama
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ollama/ollama/server/internal/testutil"
+)
+
+func TestParseChallenge(t *testing.T) {
+	cases := []struct {
+		in     string
+		scheme string
+		params map[string]string
+		ok     bool
+	}{
+		{
+			in:     `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:library/smol:pull,push"`,
+			scheme: "bearer",
+			params: map[string]string{
+				"realm":   "https://auth.example.com/token",
+				"service": "registry.example.com",
+				"scope":   "repository:library/smol:pull,push",
+			},
+			ok: true,
+		},
+		{
+			in:     `Basic realm="Registry \"Realm\""`,
+			scheme: "basic",
+			params: map[string]string{"realm": `Registry "Realm"`},
+			ok:     true,
+		},
+		{
+			in:     `Bearer realm=https://example.com/token, service=example`,
+			scheme: "bearer",
+			params: map[string]string{"realm": "https://example.com/token", "service": "example"},
+			ok:     true,
+		},
+		{in: ``},
+		{in: `Bearer realm`},
+		{in: `Bearer realm="unterminated`},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			c, ok := parseChallenge(tt.in)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v; want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if c.scheme != tt.scheme {
+				t.Errorf("scheme = %q; want %q", c.scheme, tt.scheme)
+			}
+			if !reflect.DeepEqual(c.params, tt.params) {
+				t.Errorf("params = %v; want %v", c.params, tt.params)
+			}
+		})
+	}
+}
+
+func TestAuthScope(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"https://example.com/v2/library/smol/blobs/sha256:abc", "example.com/library/smol"},
+		{"https://example.com/v2/library/smol/manifests/latest", "example.com/library/smol"},
+		{"https://example.com/v2/", "example.com"},
+		{"https://storage.example.com/uploads/123", "storage.example.com"},
+	}
+	for _, tt := range cases {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := authScope(u); got != tt.want {
+			t.Errorf("authScope(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	check := testutil.Checker(t)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	check(os.WriteFile(path, []byte(`{
+		"auths": {
+			"registry.example.com": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("alice:se:cret"))+`"},
+			"https://legacy.example.com/v1/": {"username": "bob", "password": "hunter2"},
+			"invalid.example.com": {"auth": "!!!"}
+		},
+		"credsStore": "desktop"
+	}`), 0o644))
+
+	c, err := ReadDockerConfig(path)
+	check(err)
+
+	cases := []struct {
+		host     string
+		username string
+		password string
+		ok       bool
+	}{
+		{"registry.example.com", "alice", "se:cret", true},
+		{"REGISTRY.example.com", "alice", "se:cret", true},
+		{"legacy.example.com", "bob", "hunter2", true},
+		{"invalid.example.com", "", "", false},
+		{"unknown.example.com", "", "", false},
+	}
+	for _, tt := range cases {
+		username, password, ok := c.Credentials(tt.host)
+		if username != tt.username || password != tt.password || ok != tt.ok {
+			t.Errorf("Credentials(%q) = %q, %q, %v; want %q, %q, %v", tt.host, username, password, ok, tt.username, tt.password, tt.ok)
+		}
+	}
+
+	if _, err := ReadDockerConfig(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("err = %v; want not exist", err)
+	}
+}
+
+func TestRegistryBasicAuth(t *testing.T) {
+	var authorized int
+	rc, _ := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "alice" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		authorized++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// The challenge is not answered without credentials.
+	err := rc.Push(t.Context(), "single", nil)
+	checkErrCode(t, err, 401, "")
+
+	rc.Credentials = func(host string) (string, string, bool) {
+		return "alice", "secret", host == "registry.ollama.ai"
+	}
+	testutil.Check(t, rc.Push(t.Context(), "single", nil))
+	if authorized == 0 {
+		t.Error("no requests were authorized")
+	}
+}
@@ -1,6 +1,10 @@
 // Package ollama provides a client for interacting with an Ollama registry
 // which pushes and pulls model manifests and layers as defined by the
 // [ollama.com/manifest].
+//
+// The client also pushes to and pulls from registries that implement the OCI
+// distribution spec, such as Harbor or the Docker registry, which store
+// models as OCI artifacts with the Ollama media types for their layers.
 package ollama
 
 import (
@@ -22,6 +26,7 @@ import (
 	"iter"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -36,9 +41,11 @@ import (
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/server/internal/cache/blob"
 	"github.com/ollama/ollama/server/internal/internal/backoff"
 	"github.com/ollama/ollama/server/internal/internal/names"
+	"github.com/ollama/ollama/server/internal/throttle"
 
 	_ "embed"
 )
@@ -69,6 +76,23 @@ const (
 	DefaultChunkingThreshold = 64 << 20
 )
 
+// Manifest media types
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestAccept is the Accept header of manifest requests, which lists the
+// media types of the manifests and indexes understood by Resolve.
+var manifestAccept = strings.Join([]string{
+	mediaTypeDockerManifest,
+	mediaTypeOCIManifest,
+	mediaTypeOCIIndex,
+	mediaTypeDockerManifestList,
+}, ", ")
+
 var defaultCache = sync.OnceValues(func() (*blob.DiskCache, error) {
 	dir := os.Getenv("OLLAMA_MODELS")
 	if dir == "" {
@@ -106,6 +130,8 @@ type Error struct {
 	Status  int    `json:"-"` // TODO(bmizerany): remove this
 	Code    string `json:"code"`
 	Message string `json:"message"`
+
+	challenge string // the WWW-Authenticate header of the response, if any
 }
 
 func (e *Error) Error() string {
@@ -176,7 +202,7 @@ func CompleteName(name string) string {
 }
 
 // Registry is a client for performing push and pull operations against an
-// Ollama registry.
+// Ollama registry, or a registry that implements the OCI distribution spec.
 type Registry struct {
 	// Cache is the cache used to store models. If nil, [DefaultCache] is
 	// used.
@@ -222,6 +248,45 @@ type Registry struct {
 	// Mask, if set, is the name used to convert non-fully qualified names
 	// to fully qualified names. If empty, [DefaultMask] is used.
 	Mask string
+
+	// Mirror, if set, is the base URL, such as "http://10.0.0.1:5000", of
+	// a registry mirror that models on the host of the mask are pulled
+	// from instead of the host itself. Pushes are not affected.
+	Mirror string
+
+	// Credentials, if set, returns the username and password to answer
+	// the authentication challenges of the registry host with. See
+	// [DockerConfig.Credentials].
+	Credentials func(host string) (username, password string, ok bool)
+
+	// UploadChunkSize, if positive, is the size of the chunks layers
+	// larger than it are pushed in, using the chunked upload flow of the
+	// OCI distribution spec, for registries that limit the size of
+	// requests. Otherwise, layers are pushed in a single request.
+	UploadChunkSize int64
+
+	// MaxDownloadRate, if positive, is the maximum rate, in bytes per
+	// second, at which all pulls together download. See also
+	// [WithThrottle].
+	MaxDownloadRate int64
+
+	// DownloadWindow is the hours of the day in which pulls download.
+	// Outside of them, pulls wait, pausing downloads in progress. The zero
+	// Window is always open.
+	DownloadWindow throttle.Window
+
+	authMu sync.Mutex
+	auth   map[string]string // Authorization headers by authScope
+
+	limiterOnce sync.Once
+	limiter     *throttle.Limiter // of MaxDownloadRate
+}
+
+func (r *Registry) downloadLimiter() *throttle.Limiter {
+	r.limiterOnce.Do(func() {
+		r.limiter = throttle.NewLimiter(r.MaxDownloadRate)
+	})
+	return r.limiter
 }
 
 func (r *Registry) cache() (*blob.DiskCache, error) {
@@ -231,12 +296,15 @@ func (r *Registry) cache() (*blob.DiskCache, error) {
 	return defaultCache()
 }
 
-func (r *Registry) parseName(name string) (names.Name, error) {
-	mask := defaultMask
+func (r *Registry) mask() names.Name {
 	if r.Mask != "" {
-		mask = names.Parse(r.Mask)
+		return names.Parse(r.Mask)
 	}
-	n := names.Merge(names.Parse(name), mask)
+	return defaultMask
+}
+
+func (r *Registry) parseName(name string) (names.Name, error) {
+	n := names.Merge(names.Parse(name), r.mask())
 	if !n.IsFullyQualified() {
 		return names.Name{}, fmt.Errorf("%w: %q", ErrNameInvalid, name)
 	}
@@ -245,8 +313,12 @@ func (r *Registry) parseName(name string) (names.Name, error) {
 
 // DefaultRegistry returns a new Registry configured from the environment. The
 // key is read from $HOME/.ollama/id_ed25519, MaxStreams is set to the
-// value of OLLAMA_REGISTRY_MAXSTREAMS, and ChunkingDirectory is set to the
-// system's temporary directory.
+// value of OLLAMA_REGISTRY_MAXSTREAMS, Mirror is set to the value of
+// OLLAMA_REGISTRY_MIRROR, MaxDownloadRate and DownloadWindow are set to the
+// values of OLLAMA_MAX_DOWNLOAD_RATE and OLLAMA_DOWNLOAD_WINDOW,
+// Credentials are read from the Docker config file
+// in $DOCKER_CONFIG or $HOME/.docker, if there is one, and
+// ChunkingDirectory is set to the system's temporary directory.
 //
 // It returns an error if any configuration in the environment is invalid.
 func DefaultRegistry() (*Registry, error) {
@@ -273,6 +345,20 @@ func DefaultRegistry() (*Registry, error) {
 			return nil, fmt.Errorf("invalid OLLAMA_REGISTRY_MAXSTREAMS: %w", err)
 		}
 	}
+	if u := envconfig.RegistryMirror(); u != nil {
+		rc.Mirror = u.String()
+	}
+	rc.MaxDownloadRate = envconfig.MaxDownloadRate()
+	rc.DownloadWindow, err = throttle.ParseWindow(envconfig.DownloadWindow())
+	if err != nil {
+		return nil, fmt.Errorf("invalid OLLAMA_DOWNLOAD_WINDOW: %w", err)
+	}
+	cfg, err := ReadDockerConfig(dockerConfigPath(home))
+	if err == nil {
+		rc.Credentials = cfg.Credentials
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("invalid Docker config: %w", err)
+	}
 	return &rc, nil
 }
 
@@ -316,10 +402,17 @@ func (r *Registry) Push(ctx context.Context, name string, p *PushParams) error {
 		return err
 	}
 
+	// Registries other than Ollama's check the config a manifest
+	// references exists, so it is pushed along with the layers.
+	layers := m.Layers
+	if m.Config != nil && m.Config.Digest.IsValid() {
+		layers = append(slices.Clip(layers), m.Config)
+	}
+
 	// Before much else happens, check layers at not null, the blobs exist,
 	// and the sizes match. This prevents long uploads followed by
 	// disappointment.
-	for _, l := range m.Layers {
+	for _, l := range layers {
 		if l == nil {
 			return fmt.Errorf("%w: null layer", ErrManifestInvalid)
 		}
@@ -341,25 +434,31 @@ func (r *Registry) Push(ctx context.Context, name string, p *PushParams) error {
 		panic(err)
 	}
 
+	repoURL := fmt.Sprintf("%s://%s/v2/%s/%s",
+		scheme,
+		n.Host(),
+		n.Namespace(),
+		n.Model(),
+	)
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	var g errgroup.Group
 	g.SetLimit(r.maxStreams())
-	for _, l := range m.Layers {
+	for _, l := range layers {
 		var progress atomic.Int64
 		g.Go(func() (err error) {
 			defer func() { t.update(l, progress.Load(), err) }()
 
 			t.update(l, 0, nil)
 
-			startURL := fmt.Sprintf("%s://%s/v2/%s/%s/blobs/uploads/?digest=%s",
-				scheme,
-				n.Host(),
-				n.Namespace(),
-				n.Model(),
-				l.Digest,
-			)
+			if r.blobExists(ctx, repoURL+"/blobs/"+l.Digest.String(), l.Size) {
+				t.update(l, l.Size, ErrCached)
+				return nil
+			}
+
+			startURL := fmt.Sprintf("%s/blobs/uploads/?digest=%s&size=%d", repoURL, l.Digest, l.Size)
 			res, err := r.send(ctx, "POST", startURL, nil)
 			if err != nil {
 				return err
@@ -372,23 +471,70 @@ func (r *Registry) Push(ctx context.Context, name string, p *PushParams) error {
 			}
 			defer f.Close()
 
-			uploadURL := res.Header.Get("Location")
-			if uploadURL == "" {
+			location := res.Header.Get("Location")
+			if location == "" {
 				t.update(l, l.Size, ErrCached)
 				return nil
 			}
 
-			req, err := r.newRequest(ctx, "PUT", uploadURL, f)
+			// Locations may be relative to the request, as they
+			// usually are in registries other than Ollama's.
+			uploadURL, err := res.Request.URL.Parse(location)
 			if err != nil {
-				return fmt.Errorf("invalid upload URL returned from registry: %q: %w", uploadURL, err)
+				return fmt.Errorf("invalid upload URL returned from registry: %q: %w", location, err)
 			}
-			req.ContentLength = l.Size
 
-			res, err = sendRequest(r.client(), req)
-			if err == nil {
+			// Uploads elsewhere than the registry, such as to
+			// presigned storage URLs, are made to the location
+			// as is. Uploads to the registry itself follow the
+			// OCI distribution spec, which completes uploads
+			// with a PUT of the digest.
+			if !strings.EqualFold(uploadURL.Host, res.Request.URL.Host) {
+				res, err := r.sendUpload(ctx, "PUT", uploadURL, f, 0, l.Size)
+				if err != nil {
+					return err
+				}
 				res.Body.Close()
+				progress.Store(l.Size)
+				return nil
 			}
-			return err
+
+			var off int64
+			if r.UploadChunkSize > 0 && l.Size > r.UploadChunkSize {
+				for off < l.Size {
+					size := min(r.UploadChunkSize, l.Size-off)
+					res, err := r.sendUpload(ctx, "PATCH", uploadURL, f, off, size)
+					if err != nil {
+						return err
+					}
+					res.Body.Close()
+
+					// Each chunk is sent to the location
+					// returned for the one before.
+					if location := res.Header.Get("Location"); location != "" {
+						uploadURL, err = res.Request.URL.Parse(location)
+						if err != nil {
+							return fmt.Errorf("invalid upload URL returned from registry: %q: %w", location, err)
+						}
+					}
+
+					off += size
+					progress.Store(off)
+					t.update(l, off, nil)
+				}
+			}
+
+			q := uploadURL.Query()
+			q.Set("digest", l.Digest.String())
+			uploadURL.RawQuery = q.Encode()
+
+			res, err = r.sendUpload(ctx, "PUT", uploadURL, f, off, l.Size-off)
+			if err != nil {
+				return err
+			}
+			res.Body.Close()
+			progress.Store(l.Size)
+			return nil
 		})
 	}
 
@@ -397,14 +543,13 @@ func (r *Registry) Push(ctx context.Context, name string, p *PushParams) error {
 	}
 
 	// Commit
-	path := fmt.Sprintf("%s://%s/v2/%s/%s/manifests/%s",
-		scheme,
-		n.Host(),
-		n.Namespace(),
-		n.Model(),
-		n.Tag(),
-	)
-	res, err := r.send(ctx, "PUT", path, bytes.NewReader(m.Data))
+	path := fmt.Sprintf("%s/manifests/%s", repoURL, n.Tag())
+	req, err := r.newRequest(ctx, "PUT", path, bytes.NewReader(m.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", manifestMediaType(m.Data))
+	res, err := r.do(req)
 	if err == nil {
 		res.Body.Close()
 	}
@@ -412,6 +557,54 @@ func (r *Registry) Push(ctx context.Context, name string, p *PushParams) error {
 	return err
 }
 
+// blobExists reports whether the registry has the blob at blobURL with the
+// size. Errors are taken to mean it does not, leaving them to the upload
+// that follows to report.
+func (r *Registry) blobExists(ctx context.Context, blobURL string, size int64) bool {
+	res, err := r.send(ctx, "HEAD", blobURL, nil)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode == 200 && res.ContentLength == size
+}
+
+// sendUpload sends the size bytes of f at off to the upload at u, with a
+// Content-Range if the method is PATCH.
+func (r *Registry) sendUpload(ctx context.Context, method string, u *url.URL, f *os.File, off, size int64) (*http.Response, error) {
+	var body io.Reader = http.NoBody
+	if size > 0 {
+		body = io.NewSectionReader(f, off, size)
+	}
+	req, err := r.newRequest(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if size > 0 {
+		// Let the body be sent again if the registry asks for
+		// credentials.
+		req.ContentLength = size
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(f, off, size)), nil
+		}
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if method == "PATCH" {
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", off, off+size-1))
+	}
+	return r.do(req)
+}
+
+// manifestMediaType returns the media type of the manifest data, which is
+// that of Ollama manifests if the manifest does not say.
+func manifestMediaType(data []byte) string {
+	var v struct {
+		MediaType string `json:"mediaType"`
+	}
+	json.Unmarshal(data, &v)
+	return cmp.Or(v.MediaType, mediaTypeDockerManifest)
+}
+
 func canRetry(err error) bool {
 	var re *Error
 	if !errors.As(err, &re) {
@@ -442,6 +635,12 @@ func (r *trackingReader) Read(p []byte) (n int, err error) {
 // chunks of the specified size, and then reassembled and verified. This is
 // typically slower than splitting the model up across layers, and is mostly
 // utilized for layers of type equal to "application/vnd.ollama.image".
+//
+// If the registry splits layers into chunks by their content, chunks found
+// in the layers of other models in the cache with the same namespace and
+// model name, such as other tags and earlier versions of the model, are
+// copied from them instead of downloaded, so updating a model only downloads
+// the chunks that changed.
 func (r *Registry) Pull(ctx context.Context, name string) error {
 	m, err := r.Resolve(ctx, name)
 	if err != nil {
@@ -456,6 +655,11 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 		return err
 	}
 
+	limiter, window := r.throttle(ctx)
+	if err := window.Wait(ctx); err != nil {
+		return err
+	}
+
 	exists := func(l *Layer) bool {
 		info, err := c.Get(l.Digest)
 		return err == nil && info.Size == l.Size
@@ -478,8 +682,10 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 		}
 	}
 
+	var indexes []contentIndex
 	g, ctx := errgroup.WithContext(ctx)
 	g.SetLimit(r.maxStreams())
+	local := newLocalIndex(ctx, c, t, m.Name)
 	for i, l := range layers {
 		if skip[i] {
 			continue
@@ -493,15 +699,48 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 		defer chunked.Close()
 
 		var progress atomic.Int64
+		var resumed bool
+		var localChunks func() map[blob.Digest]localChunk
+		index := contentIndex{digest: l.Digest}
 		for cs, err := range r.chunksums(ctx, name, l) {
 			if err != nil {
 				t.update(l, progress.Load(), err)
 				break
 			}
 
+			if cs.Average > 0 && localChunks == nil {
+				index.avg = cs.Average
+				localChunks = local.chunks(l.MediaType, cs.Average)
+			}
+			index.chunks = append(index.chunks, blob.ChunkSum{Chunk: cs.Chunk, Digest: cs.Digest})
+
+			// Chunks written by an earlier, interrupted, pull
+			// are not downloaded again.
+			if chunked.Completed(cs.Chunk, cs.Digest) {
+				progress.Add(cs.Chunk.Size())
+				resumed = true
+				continue
+			}
+
 			g.Go(func() (err error) {
 				defer func() { t.update(l, progress.Load(), err) }()
 
+				// Chunks found in blobs already in the
+				// cache, such as an earlier version of the
+				// layer, are copied from there. If that
+				// fails, they are downloaded.
+				var src localChunk
+				var ok bool
+				if localChunks != nil {
+					src, ok = localChunks()[cs.Digest]
+				}
+				if ok {
+					if err := copyChunk(c, chunked, cs, src); err == nil {
+						progress.Add(cs.Chunk.Size())
+						return nil
+					}
+				}
+
 				for _, err := range backoff.Loop(ctx, 3*time.Second) {
 					if err != nil {
 						return err
@@ -512,7 +751,7 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 							return err
 						}
 						req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", cs.Chunk.Start, cs.Chunk.End))
-						res, err := sendRequest(r.client(), req)
+						res, err := r.do(req)
 						if err != nil {
 							return err
 						}
@@ -533,7 +772,10 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 						// a client that is measuring
 						// rate based on wall-clock
 						// time-since-last-update.
-						body := &trackingReader{r: res.Body, n: &progress}
+						body := &trackingReader{
+							r: throttle.Reader(ctx, res.Body, window, r.downloadLimiter(), limiter),
+							n: &progress,
+						}
 
 						err = chunked.Put(cs.Chunk, cs.Digest, body)
 						if err != nil {
@@ -542,6 +784,15 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 
 						return nil
 					}()
+					if errors.Is(err, throttle.ErrWindowClosed) {
+						// Pause until the window opens
+						// again, and download the
+						// chunk again.
+						if err := window.Wait(ctx); err != nil {
+							return err
+						}
+						continue
+					}
 					if !canRetry(err) {
 						return err
 					}
@@ -549,11 +800,26 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 				return nil
 			})
 		}
+		if resumed {
+			t.update(l, progress.Load(), nil)
+		}
+		if index.avg > 0 {
+			indexes = append(indexes, index)
+		}
 	}
 	if err := g.Wait(); err != nil {
 		return err
 	}
 
+	// Keep the chunks of the layers, so that later pulls can find them
+	// without reading the layers again.
+	for _, ix := range indexes {
+		if err := c.PutContentChunks(ix.digest, ix.avg, ix.chunks); err != nil {
+			// computed again when needed
+			slog.Debug("failed to store chunksums", "digest", ix.digest, "error", err)
+		}
+	}
+
 	// store the manifest blob
 	md := blob.DigestFromBytes(m.Data)
 	if err := blob.PutBytes(c, md, m.Data); err != nil {
@@ -564,6 +830,30 @@ func (r *Registry) Pull(ctx context.Context, name string) error {
 	return c.Link(m.Name, md)
 }
 
+type throttleKey struct{}
+
+type pullThrottle struct {
+	limiter *throttle.Limiter
+	window  throttle.Window
+}
+
+// WithThrottle returns a context derived from ctx in which [Registry.Pull]
+// downloads at no more than rate bytes per second, if rate is positive, in
+// addition to [Registry.MaxDownloadRate], and only during window, if it is
+// not zero, instead of [Registry.DownloadWindow].
+func WithThrottle(ctx context.Context, rate int64, window throttle.Window) context.Context {
+	return context.WithValue(ctx, throttleKey{}, pullThrottle{throttle.NewLimiter(rate), window})
+}
+
+// throttle returns the limiter and window of a pull with ctx.
+func (r *Registry) throttle(ctx context.Context) (*throttle.Limiter, throttle.Window) {
+	t, _ := ctx.Value(throttleKey{}).(pullThrottle)
+	if t.window.IsZero() {
+		t.window = r.DownloadWindow
+	}
+	return t.limiter, t.window
+}
+
 // Unlink is like [blob.DiskCache.Unlink], but makes name fully qualified
 // before attempting to unlink the model.
 func (r *Registry) Unlink(name string) (ok bool, _ error) {
@@ -684,20 +974,33 @@ func (r *Registry) Resolve(ctx context.Context, name string) (*Manifest, error)
 		return nil, err
 	}
 
-	manifestURL := fmt.Sprintf("%s://%s/v2/%s/%s/manifests/%s", scheme, n.Host(), n.Namespace(), n.Model(), n.Tag())
+	base := r.pullURL(scheme, n)
+	manifestURL := fmt.Sprintf("%s/v2/%s/%s/manifests/%s", base, n.Namespace(), n.Model(), n.Tag())
 	if d.IsValid() {
-		manifestURL = fmt.Sprintf("%s://%s/v2/%s/%s/blobs/%s", scheme, n.Host(), n.Namespace(), n.Model(), d)
+		manifestURL = fmt.Sprintf("%s/v2/%s/%s/blobs/%s", base, n.Namespace(), n.Model(), d)
 	}
 
-	res, err := r.send(ctx, "GET", manifestURL, nil)
+	data, err := r.getManifest(ctx, manifestURL)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+
+	// Models pushed to other registries by other tools may be
+	// behind an index, which lists manifests for different
+	// platforms. Models are the same on every platform, so the
+	// first manifest is used.
+	if d, ok, err := indexManifest(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, errors.Join(ErrManifestInvalid, err))
+	} else if ok {
+		data, err = r.getManifest(ctx, fmt.Sprintf("%s/v2/%s/%s/manifests/%s", base, n.Namespace(), n.Model(), d))
+		if err != nil {
+			return nil, err
+		}
+		if blob.DigestFromBytes(data) != d {
+			return nil, fmt.Errorf("%s: %w: manifest %s has the wrong digest", name, ErrManifestInvalid, d.Short())
+		}
 	}
+
 	// TODO(bmizerany): return digest here
 	m, err := unmarshalManifest(n, data)
 	if err != nil {
@@ -706,10 +1009,241 @@ func (r *Registry) Resolve(ctx context.Context, name string) (*Manifest, error)
 	return m, nil
 }
 
+// getManifest returns the manifest or index at manifestURL.
+func (r *Registry) getManifest(ctx context.Context, manifestURL string) ([]byte, error) {
+	req, err := r.newRequest(ctx, "GET", manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", manifestAccept)
+	res, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+// indexManifest returns the digest of the first manifest listed by data,
+// and true, if data is an OCI image index or Docker manifest list.
+func indexManifest(data []byte) (_ blob.Digest, ok bool, _ error) {
+	var v struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			MediaType string      `json:"mediaType"`
+			Digest    blob.Digest `json:"digest"`
+		} `json:"manifests"`
+	}
+	if json.Unmarshal(data, &v) != nil {
+		return blob.Digest{}, false, nil // left to unmarshalManifest
+	}
+	if v.MediaType != mediaTypeOCIIndex && v.MediaType != mediaTypeDockerManifestList {
+		return blob.Digest{}, false, nil
+	}
+	for _, m := range v.Manifests {
+		if m.MediaType == mediaTypeOCIManifest || m.MediaType == mediaTypeDockerManifest {
+			return m.Digest, true, nil
+		}
+	}
+	return blob.Digest{}, false, errors.New("index lists no manifests")
+}
+
+// OpenBlob opens the blob with digest d of the model name in the remote
+// registry for reading, and returns it along with its size. The caller must
+// close the returned reader.
+func (r *Registry) OpenBlob(ctx context.Context, name string, d blob.Digest) (io.ReadCloser, int64, error) {
+	scheme, n, _, err := r.parseNameExtended(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	blobURL := fmt.Sprintf("%s/v2/%s/%s/blobs/%s", r.pullURL(scheme, n), n.Namespace(), n.Model(), d)
+	res, err := r.send(ctx, "GET", blobURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.StatusCode != 200 || res.ContentLength < 0 {
+		res.Body.Close()
+		return nil, 0, fmt.Errorf("blob %s: unexpected response %d with length %d", d.Short(), res.StatusCode, res.ContentLength)
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// pullURL returns the scheme and host models named n are pulled from, which
+// is r.Mirror for models on the host of the mask, if it is set.
+func (r *Registry) pullURL(scheme string, n names.Name) string {
+	if r.Mirror != "" && strings.EqualFold(n.Host(), r.mask().Host()) {
+		return strings.TrimSuffix(r.Mirror, "/")
+	}
+	return scheme + "://" + n.Host()
+}
+
 type chunksum struct {
 	URL    string
 	Chunk  blob.Chunk
 	Digest blob.Digest
+
+	// Average is the average chunk size given to [blob.SplitContent] to
+	// split the layer, or zero if the chunks were not split by content.
+	Average int64
+}
+
+// ChunkingHeader is the header of chunksums responses that tells how the
+// blob was split into chunks. Its value is "cdc avg=<n>" for blobs split by
+// [blob.SplitContent] with average chunk size n. Registries that do not send
+// it are assumed to split blobs some other way.
+const ChunkingHeader = "Ollama-Chunking"
+
+// FormatChunking returns the value of [ChunkingHeader] for blobs split by
+// [blob.SplitContent] with average chunk size avg.
+func FormatChunking(avg int64) string {
+	return fmt.Sprintf("cdc avg=%d", avg)
+}
+
+// parseChunking returns the average chunk size in a [ChunkingHeader] value,
+// or zero if the value is not of a blob split by content.
+func parseChunking(s string) int64 {
+	var avg int64
+	if _, err := fmt.Sscanf(s, "cdc avg=%d", &avg); err != nil || avg <= 0 {
+		return 0
+	}
+	return avg
+}
+
+// contentIndex is the list of content-defined chunks of a layer.
+type contentIndex struct {
+	digest blob.Digest
+	avg    int64
+	chunks []blob.ChunkSum
+}
+
+// localChunk is a chunk of a blob in the cache.
+type localChunk struct {
+	digest blob.Digest
+	chunk  blob.Chunk
+}
+
+// localIndex finds the chunks of pulled layers in the layers of the models
+// in the cache with the same namespace and model name, such as other tags and
+// earlier versions of the model. The models are listed once, when the pull
+// starts, and their layers are split into chunks in the background, once for
+// every media type and average chunk size of the layers pulled, so pulling
+// other layers isn't held up while they are read.
+type localIndex struct {
+	ctx     context.Context
+	c       *blob.DiskCache
+	t       *Trace
+	sources []*Layer
+
+	mu      sync.Mutex
+	indexes map[localIndexKey]func() map[blob.Digest]localChunk
+}
+
+type localIndexKey struct {
+	mediaType string
+	avg       int64
+}
+
+// newLocalIndex returns the index of the layers in c of the models with the
+// namespace and model name of the model name. Layers that are not complete
+// are skipped.
+func newLocalIndex(ctx context.Context, c *blob.DiskCache, t *Trace, name string) *localIndex {
+	ix := &localIndex{
+		ctx:     ctx,
+		c:       c,
+		t:       t,
+		indexes: make(map[localIndexKey]func() map[blob.Digest]localChunk),
+	}
+
+	n := names.Parse(name)
+	seen := make(map[blob.Digest]bool)
+	for link, err := range c.Links() {
+		if err != nil {
+			break
+		}
+		ln := names.Parse(link)
+		if !ln.IsFullyQualified() ||
+			!strings.EqualFold(ln.Host(), n.Host()) ||
+			!strings.EqualFold(ln.Namespace(), n.Namespace()) ||
+			!strings.EqualFold(ln.Model(), n.Model()) {
+			continue
+		}
+		md, err := c.Resolve(link)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(c.GetFile(md))
+		if err != nil {
+			continue
+		}
+		m, err := unmarshalManifest(ln, data)
+		if err != nil {
+			continue
+		}
+		for _, src := range m.Layers {
+			if seen[src.Digest] {
+				continue
+			}
+			seen[src.Digest] = true
+			if info, err := c.Get(src.Digest); err != nil || info.Size != src.Size {
+				continue
+			}
+			ix.sources = append(ix.sources, src)
+		}
+	}
+	return ix
+}
+
+// chunks returns a function that returns the chunks, keyed by digest, of the
+// layers with mediaType split by [blob.SplitContent] with average chunk size
+// avg. The layers are split in the background the first time chunks is
+// called for mediaType and avg, and the function returned waits for them.
+// Layers that cannot be read are skipped.
+func (ix *localIndex) chunks(mediaType string, avg int64) func() map[blob.Digest]localChunk {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	key := localIndexKey{mediaType, avg}
+	if f, ok := ix.indexes[key]; ok {
+		return f
+	}
+
+	done := make(chan struct{})
+	chunks := make(map[blob.Digest]localChunk)
+	go func() {
+		defer close(done)
+		for _, src := range ix.sources {
+			if src.MediaType != mediaType {
+				continue
+			}
+			sums, err := ix.c.ContentChunksFunc(ix.ctx, src.Digest, avg, func(n int64) {
+				ix.t.index(src, n)
+			})
+			if err != nil {
+				continue
+			}
+			for _, cs := range sums {
+				chunks[cs.Digest] = localChunk{src.Digest, cs.Chunk}
+			}
+		}
+	}()
+
+	f := func() map[blob.Digest]localChunk {
+		<-done
+		return chunks
+	}
+	ix.indexes[key] = f
+	return f
+}
+
+// copyChunk writes the chunk cs to chunked from src.
+func copyChunk(c *blob.DiskCache, chunked *blob.Chunker, cs chunksum, src localChunk) error {
+	f, err := os.Open(c.GetFile(src.digest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return chunked.Put(cs.Chunk, cs.Digest, io.NewSectionReader(f, src.chunk.Start, src.chunk.Size()))
 }
 
 // chunksums returns a sequence of chunksums for the given layer. If the layer is under the
@@ -723,21 +1257,21 @@ func (r *Registry) chunksums(ctx context.Context, name string, l *Layer) iter.Se
 			return
 		}
 
+		whole := chunksum{
+			URL: fmt.Sprintf("%s/v2/%s/%s/blobs/%s",
+				r.pullURL(scheme, n),
+				n.Namespace(),
+				n.Model(),
+				l.Digest,
+			),
+			Chunk:  blob.Chunk{Start: 0, End: l.Size - 1},
+			Digest: l.Digest,
+		}
+
 		if l.Size < r.maxChunkingThreshold() {
 			// any layer under the threshold should be downloaded
 			// in one go.
-			cs := chunksum{
-				URL: fmt.Sprintf("%s://%s/v2/%s/%s/blobs/%s",
-					scheme,
-					n.Host(),
-					n.Namespace(),
-					n.Model(),
-					l.Digest,
-				),
-				Chunk:  blob.Chunk{Start: 0, End: l.Size - 1},
-				Digest: l.Digest,
-			}
-			yield(cs, nil)
+			yield(whole, nil)
 			return
 		}
 
@@ -756,9 +1290,8 @@ func (r *Registry) chunksums(ctx context.Context, name string, l *Layer) iter.Se
 		//
 		// The blobURL is the URL to download the chunks from.
 
-		chunksumsURL := fmt.Sprintf("%s://%s/v2/%s/%s/chunksums/%s",
-			scheme,
-			n.Host(),
+		chunksumsURL := fmt.Sprintf("%s/v2/%s/%s/chunksums/%s",
+			r.pullURL(scheme, n),
 			n.Namespace(),
 			n.Model(),
 			l.Digest,
@@ -769,7 +1302,14 @@ func (r *Registry) chunksums(ctx context.Context, name string, l *Layer) iter.Se
 			yield(chunksum{}, err)
 			return
 		}
-		res, err := sendRequest(r.client(), req)
+		res, err := r.do(req)
+		var e *Error
+		if errors.As(err, &e) && e.Status == 404 {
+			// Registries other than Ollama's have no chunksums
+			// endpoint, so the layer is downloaded in one go.
+			yield(whole, nil)
+			return
+		}
 		if err != nil {
 			yield(chunksum{}, err)
 			return
@@ -781,6 +1321,7 @@ func (r *Registry) chunksums(ctx context.Context, name string, l *Layer) iter.Se
 			return
 		}
 		blobURL := res.Header.Get("Content-Location")
+		avg := parseChunking(res.Header.Get(ChunkingHeader))
 
 		s := bufio.NewScanner(res.Body)
 		s.Split(bufio.ScanWords)
@@ -812,9 +1353,10 @@ func (r *Registry) chunksums(ctx context.Context, name string, l *Layer) iter.Se
 			}
 
 			cs := chunksum{
-				URL:    blobURL,
-				Chunk:  chunk,
-				Digest: d,
+				URL:     blobURL,
+				Chunk:   chunk,
+				Digest:  d,
+				Average: avg,
 			}
 			if !yield(cs, nil) {
 				return
@@ -910,19 +1452,20 @@ func sendRequest(c *http.Client, r *http.Request) (_ *http.Response, err error)
 		}
 
 		re.Status = res.StatusCode
+		re.challenge = res.Header.Get("WWW-Authenticate")
 		return nil, &re
 	}
 	return res, nil
 }
 
 // send is a convenience method for making a request with newRequest and
-// passing it to send with r.client().
+// passing it to do.
 func (r *Registry) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
 	req, err := r.newRequest(ctx, method, path, body)
 	if err != nil {
 		return nil, err
 	}
-	return sendRequest(r.client(), req)
+	return r.do(req)
 }
 
 // makeAuthToken creates an Ollama auth token for the given private key.
@@ -931,45 +1474,47 @@ func (r *Registry) send(ctx context.Context, method, path string, body io.Reader
 // inheriting it from the original Ollama client and ollama.com
 // implementations, so we need to support it for now.
 func makeAuthToken(key crypto.PrivateKey) (string, error) {
-	privKey, _ := key.(*ed25519.PrivateKey)
-	if privKey == nil {
-		return "", fmt.Errorf("unsupported private key type: %T", key)
-	}
-
 	url := fmt.Sprintf("https://ollama.com?ts=%d", time.Now().Unix())
 	// Part 1: the checkData (e.g. the URL with a timestamp)
 
-	// Part 2: the public key
-	pubKeyShort, err := func() ([]byte, error) {
-		sshPubKey, err := ssh.NewPublicKey(privKey.Public())
-		if err != nil {
-			return nil, err
-		}
-		pubKeyParts := bytes.Fields(ssh.MarshalAuthorizedKey(sshPubKey))
-		if len(pubKeyParts) < 2 {
-			return nil, fmt.Errorf("malformed public key: %q", pubKeyParts)
-		}
-		pubKeyShort := pubKeyParts[1]
-		return pubKeyShort, nil
-	}()
+	// Part 2 and 3: the public key and signature
+	pubKeyShort, sig, err := signURL(key, url)
 	if err != nil {
 		return "", err
 	}
 
-	// Part 3: the signature
-	sig := ed25519.Sign(*privKey, []byte(checkData(url)))
-
 	// Assemble the token: <checkData>:<pubKey>:<signature>
 	var b strings.Builder
 	io.WriteString(&b, base64.StdEncoding.EncodeToString([]byte(url)))
 	b.WriteByte(':')
-	b.Write(pubKeyShort)
+	io.WriteString(&b, pubKeyShort)
 	b.WriteByte(':')
-	io.WriteString(&b, base64.StdEncoding.EncodeToString(sig))
+	io.WriteString(&b, sig)
 
 	return b.String(), nil
 }
 
+// signURL signs the checkData of url with key, and returns the public key,
+// as in an authorized_keys file, and the base64 encoded signature.
+func signURL(key crypto.PrivateKey, url string) (pub, sig string, err error) {
+	privKey, _ := key.(*ed25519.PrivateKey)
+	if privKey == nil {
+		return "", "", fmt.Errorf("unsupported private key type: %T", key)
+	}
+
+	sshPubKey, err := ssh.NewPublicKey(privKey.Public())
+	if err != nil {
+		return "", "", err
+	}
+	pubKeyParts := bytes.Fields(ssh.MarshalAuthorizedKey(sshPubKey))
+	if len(pubKeyParts) < 2 {
+		return "", "", fmt.Errorf("malformed public key: %q", pubKeyParts)
+	}
+
+	s := ed25519.Sign(*privKey, []byte(checkData(url)))
+	return string(pubKeyParts[1]), base64.StdEncoding.EncodeToString(s), nil
+}
+
 // The original spec for Ollama tokens was to use the SHA256 of the zero
 // string as part of the signature. I'm not sure why that was, but we still
 // need it to verify the signature.
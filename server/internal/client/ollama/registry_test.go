@@ -16,12 +16,15 @@ import (
 	"path"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ollama/ollama/server/internal/cache/blob"
 	"github.com/ollama/ollama/server/internal/testutil"
+	"github.com/ollama/ollama/server/internal/throttle"
 )
 
 func TestManifestMarshalJSON(t *testing.T) {
@@ -42,6 +45,11 @@ var errRoundTrip = errors.New("forced roundtrip error")
 type recordRoundTripper http.HandlerFunc
 
 func (rr recordRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		// Requests received by servers always have a body.
+		req = req.Clone(req.Context())
+		req.Body = http.NoBody
+	}
 	w := httptest.NewRecorder()
 	rr(w, req)
 	if w.Code == 499 {
@@ -767,3 +775,340 @@ func TestUnlink(t *testing.T) {
 		}
 	})
 }
+
+// ociRegistry is a stand-in for a registry that implements the OCI
+// distribution spec, which requires a token from its token endpoint, where
+// alice authenticates with the password "secret", for every request.
+type ociRegistry struct {
+	mu           sync.Mutex
+	blobs        map[blob.Digest][]byte
+	manifests    map[string][]byte // by repository and reference
+	mediaTypes   map[string]string // of manifests
+	uploads      map[string][]byte
+	patches      int
+	contentTypes []string // of pushed manifests
+}
+
+func newOCIRegistry(t *testing.T) (host string, _ *ociRegistry) {
+	t.Helper()
+	o := &ociRegistry{
+		blobs:      make(map[blob.Digest][]byte),
+		manifests:  make(map[string][]byte),
+		mediaTypes: make(map[string]string),
+		uploads:    make(map[string][]byte),
+	}
+
+	errorf := func(w http.ResponseWriter, status int, code string) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"errors":[{"code":%q}]}`, code)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "alice" || password != "secret" {
+			errorf(w, 401, "UNAUTHORIZED")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token:" + r.URL.Query().Get("scope")})
+	})
+
+	// authorized wraps h to require a token for the repository of the
+	// request, with the push action for anything but GET and HEAD.
+	authorized := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			scope := fmt.Sprintf("repository:%s/%s:pull", r.PathValue("ns"), r.PathValue("model"))
+			if r.Method != "GET" && r.Method != "HEAD" {
+				scope += ",push"
+			}
+			if r.Header.Get("Authorization") != "Bearer token:"+scope {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="stand-in",scope="%s"`, r.Host, scope))
+				errorf(w, 401, "UNAUTHORIZED")
+				return
+			}
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			h(w, r)
+		}
+	}
+
+	repo := func(r *http.Request) string {
+		return r.PathValue("ns") + "/" + r.PathValue("model")
+	}
+
+	mux.HandleFunc("GET /v2/{ns}/{model}/blobs/{digest}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		d, _ := blob.ParseDigest(r.PathValue("digest"))
+		data, ok := o.blobs[d]
+		if !ok {
+			errorf(w, 404, "BLOB_UNKNOWN")
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	mux.HandleFunc("POST /v2/{ns}/{model}/blobs/uploads/", authorized(func(w http.ResponseWriter, r *http.Request) {
+		id := strconv.Itoa(len(o.uploads))
+		o.uploads[id] = nil
+		w.Header().Set("Location", "/v2/"+repo(r)+"/blobs/uploads/"+id+"?_state=0")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	mux.HandleFunc("PATCH /v2/{ns}/{model}/blobs/uploads/{id}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		data, ok := o.uploads[id]
+		if !ok || r.URL.Query().Get("_state") != strconv.Itoa(len(data)) {
+			errorf(w, 404, "BLOB_UPLOAD_UNKNOWN")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Range") != fmt.Sprintf("%d-%d", len(data), len(data)+len(body)-1) {
+			errorf(w, 416, "RANGE_INVALID")
+			return
+		}
+		o.uploads[id] = append(data, body...)
+		o.patches++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s?_state=%d", repo(r), id, len(o.uploads[id])))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	mux.HandleFunc("PUT /v2/{ns}/{model}/blobs/uploads/{id}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		data, ok := o.uploads[id]
+		if !ok || r.URL.Query().Get("_state") != strconv.Itoa(len(data)) {
+			errorf(w, 404, "BLOB_UPLOAD_UNKNOWN")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		data = append(data, body...)
+		d, err := blob.ParseDigest(r.URL.Query().Get("digest"))
+		if err != nil || blob.DigestFromBytes(data) != d {
+			errorf(w, 400, "DIGEST_INVALID")
+			return
+		}
+		delete(o.uploads, id)
+		o.blobs[d] = data
+		w.WriteHeader(http.StatusCreated)
+	}))
+	mux.HandleFunc("PUT /v2/{ns}/{model}/manifests/{ref}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		mediaType := r.Header.Get("Content-Type")
+		o.contentTypes = append(o.contentTypes, mediaType)
+
+		data, _ := io.ReadAll(r.Body)
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil || m.Config == nil {
+			errorf(w, 400, "MANIFEST_INVALID")
+			return
+		}
+		for _, l := range append(m.Layers, m.Config) {
+			if len(o.blobs[l.Digest]) != int(l.Size) {
+				errorf(w, 400, "MANIFEST_BLOB_UNKNOWN")
+				return
+			}
+		}
+		for _, ref := range []string{r.PathValue("ref"), blob.DigestFromBytes(data).String()} {
+			o.manifests[repo(r)+":"+ref] = data
+			o.mediaTypes[repo(r)+":"+ref] = mediaType
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	mux.HandleFunc("GET /v2/{ns}/{model}/manifests/{ref}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		key := repo(r) + ":" + r.PathValue("ref")
+		data, ok := o.manifests[key]
+		if !ok || !strings.Contains(r.Header.Get("Accept"), o.mediaTypes[key]) {
+			errorf(w, 404, "MANIFEST_UNKNOWN")
+			return
+		}
+		w.Header().Set("Content-Type", o.mediaTypes[key])
+		w.Write(data)
+	}))
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s.Listener.Addr().String(), o
+}
+
+func TestRegistryOCI(t *testing.T) {
+	check := testutil.Checker(t)
+
+	host, o := newOCIRegistry(t)
+	credentials := func(h string) (string, string, bool) {
+		return "alice", "secret", h == host
+	}
+	newOCIClient := func() *Registry {
+		t.Helper()
+		c, err := blob.Open(t.TempDir())
+		check(err)
+		return &Registry{
+			Cache:       c,
+			Mask:        host + "/library/_:latest",
+			Credentials: credentials,
+		}
+	}
+
+	src := newOCIClient()
+	src.UploadChunkSize = 5
+
+	mklayer := func(mediaType, data string) *Layer {
+		return &Layer{
+			Digest:    importBytes(t, src.Cache, data),
+			MediaType: mediaType,
+			Size:      int64(len(data)),
+		}
+	}
+	data, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeDockerManifest,
+		"config":        mklayer("application/vnd.docker.container.image.v1+json", `{"model_format":"gguf"}`),
+		"layers": []*Layer{
+			mklayer("application/vnd.ollama.image.model", "hello, oci registry!"),
+			mklayer("application/vnd.ollama.image.system", "hi"),
+		},
+	})
+	check(err)
+	check(src.Cache.Link(host+"/library/smol:latest", importBytes(t, src.Cache, string(data))))
+
+	name := "http://" + host + "/library/smol:latest"
+	check(src.Push(t.Context(), name, nil))
+
+	// the model layer and config are uploaded in chunks of 5 bytes, and
+	// the system layer in one request
+	if o.patches != 9 {
+		t.Errorf("patches = %d; want 9", o.patches)
+	}
+	if want := []string{mediaTypeDockerManifest}; !slices.Equal(o.contentTypes, want) {
+		t.Errorf("manifest content types = %v; want %v", o.contentTypes, want)
+	}
+
+	// pushing again finds the blobs in the registry
+	check(src.Push(t.Context(), name, nil))
+	if o.patches != 9 {
+		t.Errorf("patches = %d; want 9", o.patches)
+	}
+
+	// models behind an index are pulled through its first manifest
+	md := blob.DigestFromBytes(data)
+	index := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[{"mediaType":%q,"digest":%q,"size":%d}]}`,
+		mediaTypeOCIIndex, mediaTypeDockerManifest, md, len(data))
+	o.mu.Lock()
+	o.manifests["library/smol:index"] = []byte(index)
+	o.mediaTypes["library/smol:index"] = mediaTypeOCIIndex
+	o.mu.Unlock()
+
+	for _, tag := range []string{"latest", "index"} {
+		t.Run(tag, func(t *testing.T) {
+			check := testutil.Checker(t)
+
+			// The registry has no chunksums endpoint, so layers
+			// over the threshold are downloaded in one go.
+			dst := newOCIClient()
+			dst.ChunkingThreshold = 1
+
+			name := "http://" + host + "/library/smol:" + tag
+			check(dst.Pull(t.Context(), name))
+
+			m, err := dst.ResolveLocal(name)
+			check(err)
+			if string(m.Data) != string(data) {
+				t.Errorf("manifest = %s; want %s", m.Data, data)
+			}
+			for _, l := range append(m.Layers, m.Config) {
+				got, err := os.ReadFile(dst.Cache.GetFile(l.Digest))
+				check(err)
+				if blob.DigestFromBytes(got) != l.Digest {
+					t.Errorf("layer %s has the wrong data %q", l.Digest.Short(), got)
+				}
+			}
+		})
+	}
+
+	// the token endpoint does not issue tokens without credentials
+	dst := newOCIClient()
+	dst.Credentials = nil
+	err = dst.Pull(t.Context(), name)
+	checkErrCode(t, err, 401, "UNAUTHORIZED")
+}
+
+func TestRegistryPullResume(t *testing.T) {
+	check := testutil.Checker(t)
+
+	const data = "0123456789"
+	d := blob.DigestFromBytes(data)
+
+	var ranges []string
+	failing := true
+	rc, c := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/manifests/"):
+			fmt.Fprintf(w, `{"layers":[{"digest":%q,"size":10}]}`, d)
+		case strings.Contains(r.URL.Path, "/chunksums/"):
+			w.Header().Set("Content-Location", "http://registry.ollama.ai/blob")
+			fmt.Fprintf(w, "%s 0-4\n%s 5-9\n", blob.DigestFromBytes(data[:5]), blob.DigestFromBytes(data[5:]))
+		case r.URL.Path == "/blob":
+			ranges = append(ranges, r.Header.Get("Range"))
+			var start, end int
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+			if failing && start == 5 {
+				io.WriteString(w, "xxxxx") // fails the chunksum
+				return
+			}
+			io.WriteString(w, data[start:end+1])
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+		}
+	})
+	rc.ChunkingThreshold = 1
+	rc.MaxStreams = 1
+
+	if err := rc.Pull(t.Context(), "model"); err == nil {
+		t.Fatal("expected error")
+	}
+	_, err := c.Get(d)
+	checkNotExist(t, err)
+
+	// the verified chunk is not downloaded again
+	failing = false
+	ranges = nil
+	check(rc.Pull(t.Context(), "model"))
+	if want := []string{"bytes=5-9"}; !slices.Equal(ranges, want) {
+		t.Errorf("ranges = %v; want %v", ranges, want)
+	}
+
+	got, err := os.ReadFile(c.GetFile(d))
+	check(err)
+	if string(got) != data {
+		t.Errorf("data = %q; want %q", got, data)
+	}
+}
+
+func TestRegistryPullWindow(t *testing.T) {
+	d := blob.DigestFromBytes("some data")
+	rc, _ := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/blobs/") {
+			t.Error("blob downloaded outside of the window")
+			return
+		}
+		fmt.Fprintf(w, `{"layers":[{"digest":%q,"size":9}]}`, d)
+	})
+
+	now := time.Now()
+	window, err := throttle.ParseWindow(now.Add(2*time.Hour).Format("15:04") + "-" + now.Add(3*time.Hour).Format("15:04"))
+	testutil.Check(t, err)
+
+	// the window of the pull is used instead of the registry's
+	rc.DownloadWindow = window
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	if err := rc.Pull(ctx, "model"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	open, err := throttle.ParseWindow(now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04"))
+	testutil.Check(t, err)
+	rc, _ = newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/blobs/") {
+			io.WriteString(w, "some data")
+			return
+		}
+		fmt.Fprintf(w, `{"layers":[{"digest":%q,"size":9}]}`, d)
+	})
+	rc.DownloadWindow = window
+	rc.MaxDownloadRate = 1000
+	testutil.Check(t, rc.Pull(WithThrottle(t.Context(), 100, open), "model"))
+}
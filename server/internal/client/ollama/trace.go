@@ -24,6 +24,16 @@ type Trace struct {
 	// A function assigned must be safe for concurrent use. The function is
 	// called synchronously and so should not block or take long to run.
 	Update func(_ *Layer, n int64, _ error)
+
+	// Index is called during [Registry.Pull] as a layer of another model
+	// in the local cache is split into chunks, to find the chunks of the
+	// pulled layers it has, which are copied from it instead of
+	// downloaded. n is the number of bytes of the layer read so far.
+	// Layers split before are not read again, and not reported.
+	//
+	// A function assigned must be safe for concurrent use, and should not
+	// block or take long to run.
+	Index func(_ *Layer, n int64)
 }
 
 func (t *Trace) update(l *Layer, n int64, err error) {
@@ -32,6 +42,12 @@ func (t *Trace) update(l *Layer, n int64, err error) {
 	}
 }
 
+func (t *Trace) index(l *Layer, n int64) {
+	if t.Index != nil {
+		t.Index(l, n)
+	}
+}
+
 type traceKey struct{}
 
 // WithTrace returns a context derived from ctx that uses t to report trace
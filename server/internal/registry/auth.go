@@ -0,0 +1,321 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// maxClockSkew is how far the timestamps signed by clients can be
+	// from the registry's clock.
+	maxClockSkew = 15 * time.Minute
+
+	// tokenMaxAge is how long tokens issued by the token endpoint are
+	// valid for.
+	tokenMaxAge = time.Hour
+)
+
+// ReadAuthorizedKeys reads the public keys in the authorized_keys formatted
+// file, e.g. lines of the form
+//
+//	ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI... comment
+//
+// Blank lines and lines starting with # are ignored.
+func ReadAuthorizedKeys(path string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// authorize checks the request r is allowed to pull, or push if write is
+// true. Two kinds of bearer tokens are accepted:
+//
+//   - tokens made by the client for every request, of the form
+//     <base64 url>:<public key>:<base64 signature>, where the signature is
+//     of the URL, which carries a ts query parameter with the time it was
+//     signed. The URL is usually not the registry's, so the token could
+//     have been made for any other registry, and is only accepted for
+//     pulls.
+//   - tokens issued by the token endpoint to clients that answer the
+//     challenge sent with errUnauthorized, which sign the URL of this
+//     registry's token endpoint. They are only accepted for the
+//     repositories and actions of the scopes they were issued for.
+//
+// Mirrors are read-only, so nothing is allowed to push to them.
+func (s *Remote) authorize(r *http.Request, write bool) error {
+	if write && s.Upstream != "" {
+		return errReadOnly
+	}
+	if len(s.AuthorizedKeys) == 0 || !write && !s.Private {
+		return nil
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return errUnauthorized
+	}
+
+	var key ssh.PublicKey
+	if u, pub, sig, ok := cut3(token, ":"); ok {
+		if write {
+			return errUnauthorized
+		}
+
+		data, err := base64.StdEncoding.DecodeString(u)
+		if err != nil {
+			return errUnauthorized
+		}
+
+		key, err = verifySignature(string(data), pub, sig)
+		if err != nil {
+			return errUnauthorized
+		}
+	} else if parts := strings.Split(token, "."); len(parts) == 4 {
+		pub, expires, encodedScope, mac := parts[0], parts[1], parts[2], parts[3]
+		e, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > e {
+			return errUnauthorized
+		}
+
+		scope, err := base64.RawURLEncoding.DecodeString(encodedScope)
+		if err != nil || !s.verify(mac, pub+"\n"+string(scope), e) {
+			return errUnauthorized
+		}
+
+		action := "pull"
+		if write {
+			action = "push"
+		}
+		if !scopeAllows(string(scope), r.PathValue("namespace")+"/"+r.PathValue("model"), action) {
+			return errUnauthorized
+		}
+
+		key, err = parsePublicKey(pub)
+		if err != nil {
+			return errUnauthorized
+		}
+	} else {
+		return errUnauthorized
+	}
+
+	if !s.authorized(key) {
+		return errDenied
+	}
+	return nil
+}
+
+// scopeAllows reports whether one of the space separated scopes, of the
+// form repository:<namespace>/<model>:<actions>, allows action on repo.
+func scopeAllows(scope, repo, action string) bool {
+	for sc := range strings.FieldsSeq(scope) {
+		name, actions, ok := parseScope(sc)
+		if ok && name == repo && slices.Contains(strings.Split(actions, ","), action) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScope parses a scope of the form repository:<name>:<actions>.
+func parseScope(scope string) (name, actions string, ok bool) {
+	rest, ok := strings.CutPrefix(scope, "repository:")
+	if !ok {
+		return "", "", false
+	}
+	i := strings.LastIndex(rest, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// authorized reports whether key is one of s.AuthorizedKeys.
+func (s *Remote) authorized(key ssh.PublicKey) bool {
+	for _, k := range s.AuthorizedKeys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// challenge sets the WWW-Authenticate header of w, which tells clients where
+// to get a token for r.
+func (s *Remote) challenge(w http.ResponseWriter, r *http.Request) {
+	scope := "pull"
+	if r.Method != "GET" && r.Method != "HEAD" {
+		scope = "pull,push"
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/v2/token",service="%s",scope="repository:%s/%s:%s"`,
+		s.baseURL(),
+		s.service(),
+		r.PathValue("namespace"),
+		r.PathValue("model"),
+		scope,
+	))
+}
+
+// service returns the name of the registry in the challenges it sends,
+// which is the host of its URL.
+func (s *Remote) service() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// handleToken issues a token to clients that sign the request URL with
+// their key. The Authorization header is of the form
+// <public key>:<base64 signature>.
+//
+// The URL signed has to be the URL of this registry's token endpoint, as
+// configured by [Remote.URL] rather than taken from the request, so a
+// signature clients made for another registry can't be used to get a
+// token. Tokens are only valid for the scopes requested.
+func (s *Remote) handleToken(w http.ResponseWriter, r *http.Request) error {
+	pub, sig, ok := strings.Cut(r.Header.Get("Authorization"), ":")
+	if !ok {
+		return errUnauthorized
+	}
+
+	key, err := verifySignature(s.baseURL()+r.URL.RequestURI(), pub, sig)
+	if err != nil {
+		return &serverError{401, "UNAUTHORIZED", err.Error()}
+	}
+	if len(s.AuthorizedKeys) > 0 && !s.authorized(key) {
+		return errDenied
+	}
+
+	q := r.URL.Query()
+	if service := q.Get("service"); service != "" && service != s.service() {
+		return &serverError{401, "UNAUTHORIZED", fmt.Sprintf("token requested for service %q", service)}
+	}
+
+	var scopes []string
+	for _, scope := range q["scope"] {
+		for sc := range strings.FieldsSeq(scope) {
+			if _, _, ok := parseScope(sc); ok {
+				scopes = append(scopes, sc)
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		return &serverError{400, "UNSUPPORTED", "token requested without a repository scope"}
+	}
+	scope := strings.Join(scopes, " ")
+
+	pub = base64.StdEncoding.EncodeToString(key.Marshal())
+	expires := time.Now().Add(tokenMaxAge).Unix()
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]any{
+		"token":      fmt.Sprintf("%s.%d.%s.%s", pub, expires, base64.RawURLEncoding.EncodeToString([]byte(scope)), s.sign(pub+"\n"+scope, expires)),
+		"expires_in": int(tokenMaxAge.Seconds()),
+	})
+}
+
+// zeroSum is the SHA256 of the empty string, as hex then base64, which
+// clients sign along with the URL.
+var zeroSum = func() string {
+	sum := sha256.Sum256(nil)
+	return base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(sum[:])))
+}()
+
+// verifySignature checks sig is the signature of "GET,<rawURL>,<zeroSum>"
+// by the public key pub, in the format written by auth.Sign, and that the
+// ts query parameter of rawURL is recent. It returns the parsed key.
+func verifySignature(rawURL, pub, sig string) (ssh.PublicKey, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := strconv.ParseInt(u.Query().Get("ts"), 10, 64)
+	if err != nil {
+		return nil, errors.New("missing or invalid timestamp")
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > maxClockSkew || d < -maxClockSkew {
+		return nil, errors.New("timestamp out of range")
+	}
+
+	key, err := parsePublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	data := fmt.Sprintf("GET,%s,%s", rawURL, zeroSum)
+	if err := key.Verify([]byte(data), &ssh.Signature{Format: key.Type(), Blob: blob}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// parsePublicKey parses the base64 encoded public key pub, e.g. the second
+// field of a line of an authorized_keys file.
+func parsePublicKey(pub string) (ssh.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(pub)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePublicKey(data)
+}
+
+// sign returns the MAC of v and expires with the registry's secret, which
+// is used to sign the tokens it issues.
+func (s *Remote) sign(v string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%d", v, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether sig is the MAC of v and expires.
+func (s *Remote) verify(sig, v string, expires int64) bool {
+	return hmac.Equal([]byte(sig), []byte(s.sign(v, expires)))
+}
+
+// cut3 cuts s into the three parts separated by sep. ok is false if s
+// does not have exactly three parts.
+func cut3(s, sep string) (a, b, c string, ok bool) {
+	parts := strings.Split(s, sep)
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
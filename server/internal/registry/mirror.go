@@ -0,0 +1,387 @@
+package registry
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/client/ollama"
+)
+
+var (
+	errReadOnly = &serverError{405, "UNSUPPORTED", "the registry is a read-only mirror"}
+	errUpstream = &serverError{502, "UPSTREAM_ERROR", "upstream registry request failed"}
+)
+
+// fetch is a download of a blob from upstream into a temporary file. It is
+// shared by all requests for the blob while it is in progress, which read
+// the bytes downloaded so far as they arrive.
+type fetch struct {
+	d        blob.Digest
+	f        *os.File
+	size     int64         // set before ready is closed
+	ready    chan struct{} // closed once size or err is set
+	setReady func()
+
+	refs int // guarded by Remote.mu
+
+	mu   sync.Mutex
+	cond sync.Cond
+	n    int64 // bytes downloaded so far
+	done bool
+	err  error
+}
+
+// ReadAt implements io.ReaderAt, blocking until the bytes asked for are
+// downloaded, or the download ends.
+func (f *fetch) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	for !f.done && f.n < min(off+int64(len(p)), f.size) {
+		f.cond.Wait()
+	}
+	err := f.err
+	f.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return f.f.ReadAt(p, off)
+}
+
+func (s *Remote) client() *ollama.Registry {
+	if s.Client != nil {
+		return s.Client
+	}
+	return &ollama.Registry{}
+}
+
+// upstreamHost returns the host of Upstream, which manifests are stored
+// under unless Host is set.
+func (s *Remote) upstreamHost() string {
+	u, err := url.Parse(s.Upstream)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// upstreamName returns the name of the model in r upstream, including the
+// scheme of Upstream.
+func (s *Remote) upstreamName(r *http.Request) string {
+	return fmt.Sprintf("%s/%s/%s:%s",
+		strings.TrimSuffix(s.Upstream, "/"),
+		r.PathValue("namespace"),
+		r.PathValue("model"),
+		cmp.Or(r.PathValue("tag"), "latest"),
+	)
+}
+
+// mirrorManifest fetches the manifest of the model in r from upstream and
+// links it under name, so that the latest manifest is served from the cache.
+// If upstream can't be reached, the manifest in the cache, if any, is served
+// instead.
+func (s *Remote) mirrorManifest(r *http.Request, name string) error {
+	m, err := s.client().Resolve(r.Context(), s.upstreamName(r))
+	if errors.Is(err, ollama.ErrModelNotFound) {
+		return errManifestUnknown
+	}
+	if err != nil {
+		s.Logger.WarnContext(r.Context(), "upstream unavailable, serving cached manifest", "name", name, "error", err)
+		return nil
+	}
+
+	d := blob.DigestFromBytes(m.Data)
+	if err := blob.PutBytes(s.Cache, d, m.Data); err != nil {
+		return err
+	}
+	if err := s.Cache.Link(name, d); err != nil {
+		return err
+	}
+
+	go s.evict()
+	return nil
+}
+
+// startFetch returns the download of the blob d of the model in r, starting
+// it if it is not in progress. It returns nil if the blob has been added to
+// the cache since the caller looked for it. The caller must call
+// releaseFetch when done with a returned fetch.
+func (s *Remote) startFetch(r *http.Request, d blob.Digest) (*fetch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.fetches[d]
+	if !ok {
+		// Downloads are removed from fetches only once the blob is
+		// in the cache, so checking again under the lock ensures a
+		// blob is never downloaded twice.
+		if _, err := s.Cache.Get(d); err == nil {
+			return nil, nil
+		}
+
+		// The blob is staged in the cache, not the system temp
+		// directory, which is often too small for it, and put in
+		// place without copying it again.
+		tmp, err := s.Cache.CreateTemp("ollama-mirror-")
+		if err != nil {
+			return nil, err
+		}
+
+		f = &fetch{d: d, f: tmp, ready: make(chan struct{}), refs: 1}
+		f.cond.L = &f.mu
+		f.setReady = sync.OnceFunc(func() { close(f.ready) })
+		s.fetches[d] = f
+		go s.download(f, s.upstreamName(r))
+	}
+	f.refs++
+	return f, nil
+}
+
+// releaseFetch drops a reference to f, removing its temporary file once
+// the download and all requests reading it are done.
+func (s *Remote) releaseFetch(f *fetch) {
+	s.mu.Lock()
+	f.refs--
+	last := f.refs == 0
+	s.mu.Unlock()
+
+	if last {
+		f.f.Close()
+		os.Remove(f.f.Name())
+	}
+}
+
+// waitFetch starts or joins the download of the blob d of the model in r,
+// and waits until its size is known. It returns nil if the blob is in the
+// cache.
+func (s *Remote) waitFetch(r *http.Request, d blob.Digest) (*fetch, error) {
+	f, err := s.startFetch(r, d)
+	if err != nil || f == nil {
+		return nil, err
+	}
+
+	select {
+	case <-f.ready:
+	case <-r.Context().Done():
+		s.releaseFetch(f)
+		return nil, r.Context().Err()
+	}
+
+	f.mu.Lock()
+	err = f.err
+	f.mu.Unlock()
+	if err != nil {
+		s.releaseFetch(f)
+		var e *ollama.Error
+		if errors.Is(err, ollama.ErrModelNotFound) || errors.As(err, &e) && e.Status == 404 {
+			return nil, errBlobUnknown
+		}
+		return nil, errUpstream
+	}
+	return f, nil
+}
+
+// download downloads the blob of f from the model name upstream, then adds
+// it to the cache, where later requests find it. It is run once per fetch,
+// which it holds a reference to.
+func (s *Remote) download(f *fetch, name string) {
+	defer s.releaseFetch(f)
+
+	err := s.downloadBlob(f, name)
+	if err != nil {
+		s.Logger.Warn("mirror download failed", "name", name, "digest", f.d, "error", err)
+	}
+
+	s.mu.Lock()
+	delete(s.fetches, f.d)
+	s.mu.Unlock()
+
+	f.mu.Lock()
+	f.done, f.err = true, err
+	f.cond.Broadcast()
+	f.mu.Unlock()
+	f.setReady()
+
+	if err == nil {
+		s.evict()
+	}
+}
+
+func (s *Remote) downloadBlob(f *fetch, name string) error {
+	// The download is shared, so it must not be canceled with the request
+	// that started it.
+	body, size, err := s.client().OpenBlob(context.Background(), name, f.d)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f.size = size
+	f.setReady()
+
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	var n int64
+	for {
+		k, err := body.Read(buf)
+		if k > 0 {
+			if _, err := f.f.WriteAt(buf[:k], n); err != nil {
+				return err
+			}
+			h.Write(buf[:k])
+			n += int64(k)
+
+			f.mu.Lock()
+			f.n = n
+			f.cond.Broadcast()
+			f.mu.Unlock()
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if n != size {
+		return fmt.Errorf("blob %s: got %d bytes; want %d", f.d.Short(), n, size)
+	}
+	if sum := f.d.Sum(); !bytes.Equal(h.Sum(nil), sum[:]) {
+		return fmt.Errorf("blob %s: digest mismatch", f.d.Short())
+	}
+	return s.Cache.PutFile(f.d, f.f.Name())
+}
+
+// serveFetch serves the blob being downloaded by f as it arrives.
+func (s *Remote) serveFetch(w http.ResponseWriter, r *http.Request, f *fetch) {
+	w.Header().Set("Location", s.baseURL()+r.URL.Path)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", f.d.String())
+	http.ServeContent(w, r, "", time.Time{}, io.NewSectionReader(f, 0, f.size))
+}
+
+// evict removes models and blobs from the cache, least recently used
+// first, until the size of the blobs in the cache is no more than MaxSize.
+// Blobs not referenced by any model are removed first, then models and the
+// blobs only they reference. Blobs removed while being pulled are fetched
+// from upstream again if they are asked for.
+func (s *Remote) evict() {
+	if s.MaxSize <= 0 {
+		return
+	}
+
+	s.evictMu.Lock()
+	defer s.evictMu.Unlock()
+
+	if err := s.evictLRU(); err != nil {
+		s.Logger.Warn("mirror eviction failed", "error", err)
+	}
+}
+
+func (s *Remote) evictLRU() error {
+	var blobs []blob.Entry
+	var total int64
+	for e, err := range s.Cache.Blobs() {
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, e)
+		total += e.Size
+	}
+	if total <= s.MaxSize {
+		return nil
+	}
+
+	times := make(map[blob.Digest]time.Time)
+	for _, e := range blobs {
+		times[e.Digest] = e.Time
+	}
+
+	type model struct {
+		name    string
+		time    time.Time
+		digests []blob.Digest // the manifest and its layers
+	}
+
+	var models []model
+	refs := make(map[blob.Digest]int)
+	for name, err := range s.Cache.Links() {
+		if err != nil {
+			return err
+		}
+		d, err := s.Cache.Resolve(name)
+		if err != nil {
+			continue
+		}
+
+		m := model{name: name, time: times[d], digests: []blob.Digest{d}}
+		if data, err := os.ReadFile(s.Cache.GetFile(d)); err == nil {
+			if mf, err := parseManifest(data); err == nil {
+				for _, l := range mf.layers() {
+					m.digests = append(m.digests, l.Digest)
+				}
+			}
+		}
+		for _, d := range m.digests {
+			refs[d]++
+		}
+		models = append(models, m)
+	}
+
+	sizes := make(map[blob.Digest]int64)
+	remove := func(d blob.Digest) error {
+		size, ok := sizes[d]
+		if !ok || refs[d] > 0 {
+			return nil
+		}
+		if err := s.Cache.Remove(d); err != nil {
+			return err
+		}
+		delete(sizes, d)
+		total -= size
+		return nil
+	}
+
+	slices.SortFunc(blobs, func(a, b blob.Entry) int { return a.Time.Compare(b.Time) })
+	for _, e := range blobs {
+		sizes[e.Digest] = e.Size
+	}
+	for _, e := range blobs {
+		if total <= s.MaxSize {
+			return nil
+		}
+		if err := remove(e.Digest); err != nil {
+			return err
+		}
+	}
+
+	slices.SortFunc(models, func(a, b model) int { return a.time.Compare(b.time) })
+	for _, m := range models {
+		if total <= s.MaxSize {
+			return nil
+		}
+		if _, err := s.Cache.Unlink(m.name); err != nil {
+			return err
+		}
+		s.Logger.Info("mirror evicted model", "name", m.name)
+		for _, d := range m.digests {
+			refs[d]--
+			if err := remove(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
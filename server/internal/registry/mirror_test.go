@@ -0,0 +1,160 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/server/internal/client/ollama"
+	"github.com/ollama/ollama/server/internal/testutil"
+)
+
+// upstreamTransport counts the blob requests sent upstream, and fails all
+// requests while down is set.
+type upstreamTransport struct {
+	blobs atomic.Int64
+	down  atomic.Bool
+}
+
+func (t *upstreamTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.down.Load() {
+		return nil, errors.New("upstream down")
+	}
+	if strings.Contains(r.URL.Path, "/blobs/") {
+		t.blobs.Add(1)
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestMirror starts an upstream registry, and a mirror of it, and
+// returns their hosts along with the mirror and its transport.
+func newTestMirror(t *testing.T, maxSize int64) (upstream, mirror string, m *Remote, tr *upstreamTransport) {
+	t.Helper()
+	upstream = newTestRemote(t, &Remote{})
+
+	tr = &upstreamTransport{}
+	m = &Remote{
+		Upstream: "http://" + upstream,
+		Client:   &ollama.Registry{HTTPClient: &http.Client{Transport: tr}},
+		MaxSize:  maxSize,
+	}
+	mirror = newTestRemote(t, m)
+	return upstream, mirror, m, tr
+}
+
+// newTestMirrorClient returns a client that names models as if they were
+// pulled from upstream, but pulls them through mirror.
+func newTestMirrorClient(t *testing.T, upstream, mirror string) *ollama.Registry {
+	t.Helper()
+	rc := newTestClient(t, upstream, nil)
+	rc.Mirror = "http://" + mirror
+	return rc
+}
+
+func TestMirrorPull(t *testing.T) {
+	check := testutil.Checker(t)
+
+	upstream, mirror, m, tr := newTestMirror(t, 0)
+
+	src := newTestClient(t, upstream, nil)
+	putTestModel(t, src, upstream+"/library/smol:latest", "hello, mirror!", strings.Repeat("a", 100))
+	check(src.Push(t.Context(), "http://"+upstream+"/library/smol", nil))
+	want, err := src.ResolveLocal("smol")
+	check(err)
+
+	var wg sync.WaitGroup
+	for _, threshold := range []int64{0, 1, 0, 1} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dst := newTestMirrorClient(t, upstream, mirror)
+			dst.ChunkingThreshold = threshold
+			if err := dst.Pull(t.Context(), "smol"); err != nil {
+				t.Error(err)
+				return
+			}
+
+			got, err := dst.ResolveLocal("smol")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if string(got.Data) != string(want.Data) {
+				t.Errorf("manifest = %s; want %s", got.Data, want.Data)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := tr.blobs.Load(); n != 2 {
+		t.Errorf("blobs fetched upstream %d times; want 2", n)
+	}
+
+	if _, err := m.Cache.Resolve(upstream + "/library/smol:latest"); err != nil {
+		t.Errorf("manifest not cached: %v", err)
+	}
+	for _, l := range want.Layers {
+		if _, err := m.Cache.Get(l.Digest); err != nil {
+			t.Errorf("layer %s not cached: %v", l.Digest.Short(), err)
+		}
+	}
+
+	// models in the cache are served while upstream is down
+	tr.down.Store(true)
+	check(newTestMirrorClient(t, upstream, mirror).Pull(t.Context(), "smol"))
+
+	// but models not in the cache are not
+	if err := newTestMirrorClient(t, upstream, mirror).Pull(t.Context(), "unknown"); !errors.Is(err, ollama.ErrModelNotFound) {
+		t.Errorf("err = %v; want %v", err, ollama.ErrModelNotFound)
+	}
+	tr.down.Store(false)
+
+	if err := newTestMirrorClient(t, upstream, mirror).Pull(t.Context(), "unknown"); !errors.Is(err, ollama.ErrModelNotFound) {
+		t.Errorf("err = %v; want %v", err, ollama.ErrModelNotFound)
+	}
+
+	// mirrors are read-only
+	rc := newTestClient(t, mirror, nil)
+	putTestModel(t, rc, mirror+"/library/smol:latest", "hello, mirror!")
+	checkStatus(t, rc.Push(t.Context(), "http://"+mirror+"/library/smol", nil), 405)
+}
+
+func TestMirrorEvict(t *testing.T) {
+	check := testutil.Checker(t)
+
+	upstream, mirror, m, _ := newTestMirror(t, 400)
+
+	src := newTestClient(t, upstream, nil)
+	putTestModel(t, src, upstream+"/library/a:latest", strings.Repeat("a", 200))
+	putTestModel(t, src, upstream+"/library/b:latest", strings.Repeat("b", 200))
+	check(src.Push(t.Context(), "http://"+upstream+"/library/a", nil))
+	check(src.Push(t.Context(), "http://"+upstream+"/library/b", nil))
+
+	check(newTestMirrorClient(t, upstream, mirror).Pull(t.Context(), "a"))
+	time.Sleep(10 * time.Millisecond)
+	check(newTestMirrorClient(t, upstream, mirror).Pull(t.Context(), "b"))
+	m.evict()
+
+	if _, err := m.Cache.Resolve(upstream + "/library/a:latest"); err == nil {
+		t.Error("least recently used model not evicted")
+	}
+	if _, err := m.Cache.Resolve(upstream + "/library/b:latest"); err != nil {
+		t.Errorf("most recently used model evicted: %v", err)
+	}
+
+	var total int64
+	for e, err := range m.Cache.Blobs() {
+		check(err)
+		total += e.Size
+	}
+	if total > 400 {
+		t.Errorf("cache size = %d; want at most 400", total)
+	}
+
+	// evicted models are fetched again
+	check(newTestMirrorClient(t, upstream, mirror).Pull(t.Context(), "a"))
+}
@@ -0,0 +1,726 @@
+package registry
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/client/ollama"
+	"github.com/ollama/ollama/server/internal/internal/names"
+)
+
+// DefaultRemoteHost is the host part of the names that [Remote] stores
+// manifests under when its Host is not set.
+const DefaultRemoteHost = "registry.local"
+
+// DefaultChunkSize is the average size of the chunks listed by the chunksums
+// endpoint when [Remote.ChunkSize] is not set.
+const DefaultChunkSize = 16 << 20
+
+// Remote implements an http.Handler that serves models from a disk cache to
+// Ollama clients, making it possible to self-host a registry that models can
+// be pushed to and pulled from.
+//
+// It speaks the subset of the registry protocol used by Ollama clients:
+//
+//	GET|HEAD /v2/<namespace>/<model>/manifests/<tag>
+//	PUT      /v2/<namespace>/<model>/manifests/<tag>
+//	GET|HEAD /v2/<namespace>/<model>/blobs/<digest>
+//	POST     /v2/<namespace>/<model>/blobs/uploads/
+//	PATCH    /v2/<namespace>/<model>/blobs/uploads/<id>
+//	PUT      /v2/<namespace>/<model>/blobs/uploads/<id>
+//	DELETE   /v2/<namespace>/<model>/blobs/uploads/<id>
+//	GET      /v2/<namespace>/<model>/chunksums/<digest>
+//	GET      /v2/token
+//
+// If AuthorizedKeys is empty, anyone can push and pull. Otherwise requests
+// that push must be signed by one of the keys, as must requests that pull
+// manifests if Private is true. See [Remote.authorize] for the accepted
+// credentials.
+//
+// If Upstream is set, the registry is instead a read-only, pull-through
+// mirror of another registry: manifests are fetched from upstream on every
+// pull, and blobs the first time they are pulled, with concurrent pulls of a
+// blob sharing a single download. Everything fetched is kept in the cache,
+// which is kept under MaxSize by evicting the least recently used models.
+type Remote struct {
+	Cache  *blob.DiskCache // required
+	Logger *slog.Logger    // required
+
+	// URL is the base URL clients reach the registry at, such as
+	// "https://models.example.com", which prefixes the URLs sent back to
+	// them. It is required, rather than taken from the Host of requests,
+	// since clients sign the URL of the token endpoint to get tokens.
+	URL string
+
+	// Host is the host part of the names manifests are stored under in
+	// Cache. If empty, the host of Upstream, or DefaultRemoteHost if it
+	// is not set either, is used. Setting it to the host
+	// clients use to reach a local Ollama install's registry makes its
+	// models available from a cache shared with that install.
+	Host string
+
+	// AuthorizedKeys are the public keys allowed to push, and to pull if
+	// Private is true.
+	AuthorizedKeys []ssh.PublicKey
+
+	// Private, if true, requires an authorized key to pull manifests.
+	Private bool
+
+	// ChunkSize is the average size of the chunks listed by the
+	// chunksums endpoint. If zero, DefaultChunkSize is used.
+	//
+	// Blobs are split into chunks by their content, with
+	// [blob.SplitContent], so clients can download only the chunks of a
+	// blob they do not already have in other blobs, such as an earlier
+	// version of the same model.
+	ChunkSize int64
+
+	// Upstream, if set, is the base URL, such as
+	// "https://registry.ollama.ai", of the registry mirrored.
+	Upstream string
+
+	// Client is the client used to pull from Upstream. If nil, a client
+	// without a key is used.
+	Client *ollama.Registry
+
+	// MaxSize, if positive, is the total size in bytes of the blobs the
+	// cache of a mirror is kept under.
+	MaxSize int64
+
+	initOnce sync.Once
+	mux      *http.ServeMux
+	secret   []byte
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+	fetches map[blob.Digest]*fetch
+
+	evictMu sync.Mutex
+}
+
+// Registry protocol errors
+var (
+	errBlobUnknown         = &serverError{404, "BLOB_UNKNOWN", "blob unknown to registry"}
+	errBlobUploadUnknown   = &serverError{404, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry"}
+	errDigestInvalid       = &serverError{400, "DIGEST_INVALID", "provided digest did not match uploaded content"}
+	errManifestUnknown     = &serverError{404, "MANIFEST_UNKNOWN", "manifest unknown"}
+	errManifestBlobUnknown = &serverError{400, "MANIFEST_BLOB_UNKNOWN", "manifest references a blob unknown to registry"}
+	errNameInvalid         = &serverError{400, "NAME_INVALID", "invalid model name"}
+	errRangeInvalid        = &serverError{416, "RANGE_INVALID", "invalid content range"}
+	errUnauthorized        = &serverError{401, "UNAUTHORIZED", "authentication required"}
+	errDenied              = &serverError{403, "DENIED", "requested access to the resource is denied"}
+)
+
+// maxManifestSize is the maximum size of a manifest that can be pushed.
+const maxManifestSize = 4 << 20
+
+// uploadTimeout is how long an upload can go without any of its requests
+// before it is canceled and its data removed.
+var uploadTimeout = time.Hour
+
+// upload is an in progress blob upload.
+type upload struct {
+	mu sync.Mutex
+	f  *os.File
+
+	// digest is the digest given when the upload was started, used to
+	// commit uploads that are not given one when they are completed.
+	digest blob.Digest
+
+	// size is the size of the blob given when the upload was started, or
+	// -1 if none was given. No more data is accepted.
+	size int64
+
+	// used is when a request last used the upload, and timer cancels it
+	// after uploadTimeout without any.
+	used  time.Time
+	timer *time.Timer
+}
+
+func (s *Remote) init() {
+	s.initOnce.Do(func() {
+		s.secret = make([]byte, 32)
+		if _, err := rand.Read(s.secret); err != nil {
+			panic(err)
+		}
+
+		s.uploads = make(map[string]*upload)
+		s.fetches = make(map[blob.Digest]*fetch)
+
+		s.mux = http.NewServeMux()
+		handle := func(pattern string, h func(http.ResponseWriter, *http.Request) error) {
+			s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+				rec := w.(*statusCodeRecorder)
+				err := h(rec, r)
+				if err != nil {
+					if errors.Is(err, errUnauthorized) {
+						s.challenge(rec, r)
+					}
+					writeError(rec, err)
+				}
+				logRequest(s.Logger, rec, r, err)
+			})
+		}
+
+		handle("GET /v2/token", s.handleToken)
+		handle("GET /v2/{namespace}/{model}/manifests/{tag}", s.handleGetManifest)
+		handle("PUT /v2/{namespace}/{model}/manifests/{tag}", s.handlePutManifest)
+		handle("GET /v2/{namespace}/{model}/blobs/{digest}", s.handleGetBlob)
+		handle("POST /v2/{namespace}/{model}/blobs/uploads/{$}", s.handleStartUpload)
+		handle("PATCH /v2/{namespace}/{model}/blobs/uploads/{id}", s.handlePatchUpload)
+		handle("PUT /v2/{namespace}/{model}/blobs/uploads/{id}", s.handlePutUpload)
+		handle("DELETE /v2/{namespace}/{model}/blobs/uploads/{id}", s.handleDeleteUpload)
+		handle("GET /v2/{namespace}/{model}/chunksums/{digest}", s.handleChunksums)
+		handle("/", func(http.ResponseWriter, *http.Request) error {
+			return errNotFound
+		})
+	})
+}
+
+func (s *Remote) chunkSize() int64 {
+	return cmp.Or(s.ChunkSize, DefaultChunkSize)
+}
+
+func (s *Remote) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.init()
+	s.mux.ServeHTTP(&statusCodeRecorder{ResponseWriter: w}, r)
+}
+
+// baseURL returns the URL clients use to reach the registry, e.g.
+// https://example.com, which prefixes the URLs sent back to them.
+func (s *Remote) baseURL() string {
+	return strings.TrimSuffix(s.URL, "/")
+}
+
+// name returns the name the manifest of the model in r is stored under in
+// the cache.
+func (s *Remote) name(r *http.Request) (string, error) {
+	name := fmt.Sprintf("%s/%s/%s:%s",
+		cmp.Or(s.Host, s.upstreamHost(), DefaultRemoteHost),
+		r.PathValue("namespace"),
+		r.PathValue("model"),
+		cmp.Or(r.PathValue("tag"), "latest"),
+	)
+	if !names.Parse(name).IsFullyQualified() {
+		return "", errNameInvalid
+	}
+	return name, nil
+}
+
+// repository returns the URL of the repository of the model in r, e.g.
+// https://example.com/v2/library/smol
+func (s *Remote) repository(r *http.Request) string {
+	return fmt.Sprintf("%s/v2/%s/%s", s.baseURL(), r.PathValue("namespace"), r.PathValue("model"))
+}
+
+func (s *Remote) handleGetManifest(w http.ResponseWriter, r *http.Request) error {
+	if err := s.authorize(r, false); err != nil {
+		return err
+	}
+
+	// Manifests can also be addressed by digest, as the tag.
+	d, err := blob.ParseDigest(r.PathValue("tag"))
+	if err != nil {
+		name, err := s.name(r)
+		if err != nil {
+			return err
+		}
+		if s.Upstream != "" {
+			if err := s.mirrorManifest(r, name); err != nil {
+				return err
+			}
+		}
+		d, err = s.Cache.Resolve(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return errManifestUnknown
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if s.Upstream != "" {
+		// record the use of the model for eviction
+		s.Cache.Touch(d)
+	}
+
+	f, err := os.Open(s.Cache.GetFile(d))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errManifestUnknown
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	w.Header().Set("Docker-Content-Digest", d.String())
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+func (s *Remote) handlePutManifest(w http.ResponseWriter, r *http.Request) error {
+	if err := s.authorize(r, true); err != nil {
+		return err
+	}
+
+	name, err := s.name(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxManifestSize {
+		return &serverError{413, "SIZE_INVALID", "manifest too large"}
+	}
+
+	m, err := parseManifest(data)
+	if err != nil {
+		return &serverError{400, "MANIFEST_INVALID", fmt.Sprintf("manifest invalid: %v", err)}
+	}
+
+	for _, l := range m.layers() {
+		info, err := s.Cache.Get(l.Digest)
+		if err != nil || info.Size != l.Size {
+			return errManifestBlobUnknown
+		}
+	}
+
+	d := blob.DigestFromBytes(data)
+	if err := blob.PutBytes(s.Cache, d, data); err != nil {
+		return err
+	}
+	if err := s.Cache.Link(name, d); err != nil {
+		return err
+	}
+
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Location", s.repository(r)+"/manifests/"+r.PathValue("tag"))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// manifest is the part of a model manifest the registry checks.
+type manifest struct {
+	Config *manifestLayer   `json:"config"`
+	Layers []*manifestLayer `json:"layers"`
+}
+
+type manifestLayer struct {
+	Digest blob.Digest `json:"digest"`
+	Size   int64       `json:"size"`
+}
+
+func parseManifest(data []byte) (*manifest, error) {
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if slices.Contains(m.Layers, nil) {
+		return nil, errors.New("null layer")
+	}
+	return &m, nil
+}
+
+// layers returns the layers of m, including its config.
+func (m *manifest) layers() []*manifestLayer {
+	if m.Config == nil {
+		return m.Layers
+	}
+	return append(slices.Clip(m.Layers), m.Config)
+}
+
+// getBlob returns the cache entry of the blob with the digest in r.
+func (s *Remote) getBlob(r *http.Request) (blob.Entry, error) {
+	d, err := blob.ParseDigest(r.PathValue("digest"))
+	if err != nil {
+		return blob.Entry{}, &serverError{400, "DIGEST_INVALID", err.Error()}
+	}
+
+	info, err := s.Cache.Get(d)
+	if errors.Is(err, fs.ErrNotExist) {
+		return blob.Entry{}, errBlobUnknown
+	}
+	return info, err
+}
+
+// handleGetBlob serves the blob in r.
+//
+// Blobs are served without authorization, even if the registry is Private.
+// Clients download chunks without credentials, and a blob can only be
+// found by its digest, which is only known to those who can read a
+// manifest referencing it.
+func (s *Remote) handleGetBlob(w http.ResponseWriter, r *http.Request) error {
+	info, err := s.getBlob(r)
+	if errors.Is(err, errBlobUnknown) && s.Upstream != "" {
+		d, _ := blob.ParseDigest(r.PathValue("digest")) // checked by getBlob
+		f, err := s.waitFetch(r, d)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			defer s.releaseFetch(f)
+			s.serveFetch(w, r, f)
+			return nil
+		}
+		info, err = s.getBlob(r)
+	}
+	if err != nil {
+		return err
+	}
+	if s.Upstream != "" {
+		s.Cache.Touch(info.Digest)
+	}
+
+	f, err := os.Open(s.Cache.GetFile(info.Digest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The original client reads the URL to download the blob from the
+	// Location of the response, which is usually a redirect to a CDN.
+	w.Header().Set("Location", s.baseURL()+r.URL.Path)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", info.Digest.String())
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+func (s *Remote) handleStartUpload(w http.ResponseWriter, r *http.Request) error {
+	if err := s.authorize(r, true); err != nil {
+		return err
+	}
+
+	// A mount or digest of a blob the registry already has completes
+	// the upload. Clients starting an upload with a digest expect no
+	// Location in that case.
+	q := r.URL.Query()
+	if mount := q.Get("mount"); mount != "" {
+		if d, err := blob.ParseDigest(mount); err == nil {
+			if _, err := s.Cache.Get(d); err == nil {
+				w.Header().Set("Docker-Content-Digest", d.String())
+				w.Header().Set("Location", s.repository(r)+"/blobs/"+d.String())
+				w.WriteHeader(http.StatusCreated)
+				return nil
+			}
+		}
+	}
+
+	var d blob.Digest
+	if q.Has("digest") {
+		var err error
+		d, err = blob.ParseDigest(q.Get("digest"))
+		if err != nil {
+			return &serverError{400, "DIGEST_INVALID", err.Error()}
+		}
+		if _, err := s.Cache.Get(d); err == nil {
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		}
+	}
+
+	var size int64 = -1
+	if q.Has("size") {
+		var err error
+		size, err = strconv.ParseInt(q.Get("size"), 10, 64)
+		if err != nil || size < 0 {
+			return &serverError{400, "SIZE_INVALID", "invalid size"}
+		}
+	}
+
+	// The data is staged in the cache, not the system temp directory,
+	// which is often too small for it, and moved into place once
+	// verified.
+	f, err := s.Cache.CreateTemp("ollama-upload-")
+	if err != nil {
+		return err
+	}
+
+	id := rand.Text()
+	u := &upload{f: f, digest: d, size: size, used: time.Now()}
+	u.timer = time.AfterFunc(uploadTimeout, func() { s.expireUpload(id, u) })
+	s.mu.Lock()
+	s.uploads[id] = u
+	s.mu.Unlock()
+
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Location", s.repository(r)+"/blobs/uploads/"+id)
+	w.Header().Set("Range", "0-0")
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// getUpload returns the upload with the id in r, locked.
+func (s *Remote) getUpload(r *http.Request) (*upload, error) {
+	s.mu.Lock()
+	u, ok := s.uploads[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errBlobUploadUnknown
+	}
+
+	u.mu.Lock()
+	if u.f == nil {
+		// completed or canceled while we waited for the lock
+		u.mu.Unlock()
+		return nil, errBlobUploadUnknown
+	}
+	u.used = time.Now()
+	return u, nil
+}
+
+// closeUpload removes the upload with the id in r and its data.
+func (s *Remote) closeUpload(r *http.Request, u *upload) {
+	s.removeUpload(r.PathValue("id"), u)
+}
+
+// removeUpload removes the upload u with id and its data. u must be locked.
+func (s *Remote) removeUpload(id string, u *upload) {
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+
+	u.timer.Stop()
+	u.f.Close()
+	os.Remove(u.f.Name())
+	u.f = nil
+}
+
+// expireUpload removes the upload u with id if no request has used it for
+// uploadTimeout, so abandoned uploads do not keep their data forever.
+func (s *Remote) expireUpload(id string, u *upload) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.f == nil {
+		return
+	}
+
+	// the upload was in use, e.g. by a long request writing its data,
+	// since the timer was set
+	if d := time.Since(u.used); d < uploadTimeout {
+		u.timer.Reset(uploadTimeout - d)
+		return
+	}
+
+	s.Logger.Info("removing abandoned upload", "id", id)
+	s.removeUpload(id, u)
+}
+
+// write writes the body of r to u at the end of the data uploaded so far.
+// A Content-Range, if any, must start there, as chunks are contiguous. It
+// returns the size of the data uploaded so far.
+func (u *upload) write(r *http.Request) (int64, error) {
+	info, err := u.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	start, end := info.Size(), int64(-1)
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		a, b, ok := strings.Cut(strings.TrimPrefix(cr, "bytes="), "-")
+		start, err = strconv.ParseInt(a, 10, 64)
+		if !ok || err != nil || start < 0 {
+			return 0, errRangeInvalid
+		}
+		end, err = strconv.ParseInt(b, 10, 64)
+		if err != nil || end < start {
+			return 0, errRangeInvalid
+		}
+		if start != info.Size() {
+			return 0, errRangeInvalid
+		}
+	}
+	if u.size >= 0 && end >= u.size {
+		return 0, errRangeInvalid
+	}
+
+	body := io.Reader(r.Body)
+	if u.size >= 0 {
+		// one byte more than allowed, to tell a body that is too
+		// long from one that fits
+		body = io.LimitReader(body, u.size-start+1)
+	}
+	n, err := io.Copy(io.NewOffsetWriter(u.f, start), body)
+	if err == nil && (end >= 0 && n != end-start+1 || u.size >= 0 && start+n > u.size) {
+		err = errRangeInvalid
+	}
+	if err != nil {
+		// drop the partial chunk, so the client can send it again
+		// at the same offset
+		if terr := u.f.Truncate(start); terr != nil {
+			return 0, terr
+		}
+		return 0, err
+	}
+
+	info, err = u.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	u.used = time.Now()
+	return info.Size(), nil
+}
+
+func (s *Remote) handlePatchUpload(w http.ResponseWriter, r *http.Request) error {
+	if err := s.authorize(r, true); err != nil {
+		return err
+	}
+
+	u, err := s.getUpload(r)
+	if err != nil {
+		return err
+	}
+	defer u.mu.Unlock()
+
+	size, err := u.write(r)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Docker-Upload-UUID", r.PathValue("id"))
+	w.Header().Set("Location", s.repository(r)+"/blobs/uploads/"+r.PathValue("id"))
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+func (s *Remote) handlePutUpload(w http.ResponseWriter, r *http.Request) error {
+	if err := s.authorize(r, true); err != nil {
+		return err
+	}
+
+	u, err := s.getUpload(r)
+	if err != nil {
+		return err
+	}
+	defer u.mu.Unlock()
+
+	d := u.digest
+	if q := r.URL.Query(); q.Has("digest") {
+		d, err = blob.ParseDigest(q.Get("digest"))
+		if err != nil {
+			return &serverError{400, "DIGEST_INVALID", err.Error()}
+		}
+	}
+	if !d.IsValid() {
+		return &serverError{400, "DIGEST_INVALID", "missing digest"}
+	}
+
+	size, err := u.write(r)
+	if err != nil {
+		return err
+	}
+
+	// The blob is split into chunks for the chunksums endpoint in the
+	// same pass that verifies it, so pulls do not wait for it later.
+	h := sha256.New()
+	var chunks []blob.ChunkSum
+	for cs, err := range blob.SplitContent(io.TeeReader(io.NewSectionReader(u.f, 0, size), h), s.chunkSize()) {
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, cs)
+	}
+	if sum := d.Sum(); !bytes.Equal(h.Sum(nil), sum[:]) {
+		s.closeUpload(r, u)
+		return errDigestInvalid
+	}
+
+	if err := s.Cache.PutFile(d, u.f.Name()); err != nil {
+		s.closeUpload(r, u)
+		return err
+	}
+	s.closeUpload(r, u)
+	if err := s.Cache.PutContentChunks(d, s.chunkSize(), chunks); err != nil {
+		// computed again when first asked for
+		s.Logger.Warn("failed to store chunksums", "digest", d, "error", err)
+	}
+
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Location", s.repository(r)+"/blobs/"+d.String())
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (s *Remote) handleDeleteUpload(w http.ResponseWriter, r *http.Request) error {
+	if err := s.authorize(r, true); err != nil {
+		return err
+	}
+
+	u, err := s.getUpload(r)
+	if err != nil {
+		return err
+	}
+	defer u.mu.Unlock()
+
+	s.closeUpload(r, u)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleChunksums lists the digests of the content-defined chunks of the
+// blob in r, for clients to download and verify in parallel, and to find in
+// blobs they already have. Chunksums are computed once per blob and kept in
+// the cache.
+func (s *Remote) handleChunksums(w http.ResponseWriter, r *http.Request) error {
+	if err := s.authorize(r, false); err != nil {
+		return err
+	}
+
+	info, err := s.getBlob(r)
+	if errors.Is(err, errBlobUnknown) && s.Upstream != "" {
+		// Blobs not yet in the cache of a mirror are served as they
+		// are downloaded, so they are listed as a single chunk.
+		d, _ := blob.ParseDigest(r.PathValue("digest")) // checked by getBlob
+		f, err := s.waitFetch(r, d)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			defer s.releaseFetch(f)
+			w.Header().Set("Content-Location", s.repository(r)+"/blobs/"+f.d.String())
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintf(w, "%s 0-%d\n", f.d, f.size-1)
+			return nil
+		}
+		info, err = s.getBlob(r)
+	}
+	if err != nil {
+		return err
+	}
+
+	chunks, err := s.Cache.ContentChunks(info.Digest, s.chunkSize())
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set(ollama.ChunkingHeader, ollama.FormatChunking(s.chunkSize()))
+	w.Header().Set("Content-Location", s.repository(r)+"/blobs/"+info.Digest.String())
+	w.Header().Set("Content-Type", "text/plain")
+	for _, cs := range chunks {
+		fmt.Fprintln(w, cs)
+	}
+	return nil
+}
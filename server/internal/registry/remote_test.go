@@ -0,0 +1,616 @@
+package registry
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/client/ollama"
+	"github.com/ollama/ollama/server/internal/testutil"
+)
+
+// newTestRemote starts a registry serving r, filling in its Cache, Logger
+// and URL, and returns its host.
+func newTestRemote(t *testing.T, r *Remote) string {
+	t.Helper()
+	c, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Cache = c
+	r.Logger = testutil.Slogger(t)
+
+	s := httptest.NewUnstartedServer(r)
+	r.URL = "http://" + s.Listener.Addr().String()
+	s.Start()
+	t.Cleanup(s.Close)
+	return s.Listener.Addr().String()
+}
+
+// newTestClient returns a client with an empty cache that pulls and pushes
+// from host, and signs its requests with key if it is not nil.
+func newTestClient(t *testing.T, host string, key ed25519.PrivateKey) *ollama.Registry {
+	t.Helper()
+	c, err := blob.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &ollama.Registry{
+		Cache: c,
+		Mask:  host + "/library/_:latest",
+	}
+	if key != nil {
+		rc.Key = &key
+	}
+	return rc
+}
+
+// putTestModel adds a model with the layers to the cache of rc.
+func putTestModel(t *testing.T, rc *ollama.Registry, name string, layers ...string) {
+	t.Helper()
+	var m struct {
+		Layers []*ollama.Layer `json:"layers"`
+	}
+	for _, l := range layers {
+		d := blob.DigestFromBytes(l)
+		if err := blob.PutBytes(rc.Cache, d, l); err != nil {
+			t.Fatal(err)
+		}
+		m.Layers = append(m.Layers, &ollama.Layer{Digest: d, MediaType: "application/vnd.ollama.image.model", Size: int64(len(l))})
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := blob.DigestFromBytes(data)
+	if err := blob.PutBytes(rc.Cache, d, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Cache.Link(name, d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func generateKey(t *testing.T) (ed25519.PrivateKey, ssh.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, key
+}
+
+func checkStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var e *ollama.Error
+	if !errors.As(err, &e) || e.Status != status {
+		t.Fatalf("err = %v; want status %d", err, status)
+	}
+}
+
+func TestRemotePushPull(t *testing.T) {
+	check := testutil.Checker(t)
+
+	host := newTestRemote(t, &Remote{ChunkSize: 5})
+	name := "http://" + host + "/library/smol:latest"
+
+	src := newTestClient(t, host, nil)
+	putTestModel(t, src, host+"/library/smol:latest", "hello, registry!", "a")
+	check(src.Push(t.Context(), name, nil))
+
+	// pushing again finds the blobs cached
+	check(src.Push(t.Context(), name, nil))
+
+	for _, threshold := range []int64{0, 1} {
+		t.Run(fmt.Sprintf("threshold=%d", threshold), func(t *testing.T) {
+			check := testutil.Checker(t)
+
+			dst := newTestClient(t, host, nil)
+			dst.ChunkingThreshold = threshold
+			check(dst.Pull(t.Context(), name))
+
+			want, err := src.ResolveLocal(name)
+			check(err)
+			got, err := dst.ResolveLocal(name)
+			check(err)
+
+			if string(got.Data) != string(want.Data) {
+				t.Fatalf("manifest = %s; want %s", got.Data, want.Data)
+			}
+
+			for _, l := range got.Layers {
+				data, err := os.ReadFile(dst.Cache.GetFile(l.Digest))
+				check(err)
+				if blob.DigestFromBytes(data) != l.Digest {
+					t.Errorf("layer %s has the wrong data %q", l.Digest.Short(), data)
+				}
+			}
+		})
+	}
+
+	dst := newTestClient(t, host, nil)
+	if err := dst.Pull(t.Context(), "http://"+host+"/library/unknown:latest"); !errors.Is(err, ollama.ErrModelNotFound) {
+		t.Errorf("err = %v; want %v", err, ollama.ErrModelNotFound)
+	}
+}
+
+func TestRemoteConcurrentPulls(t *testing.T) {
+	check := testutil.Checker(t)
+
+	host := newTestRemote(t, &Remote{ChunkSize: 256})
+
+	shared := make([]byte, 64<<10)
+	rand.Read(shared)
+
+	src := newTestClient(t, host, nil)
+	names := []string{host + "/library/a:latest", host + "/library/b:latest"}
+	for _, name := range names {
+		putTestModel(t, src, name, string(shared), name)
+		check(src.Push(t.Context(), "http://"+name, nil))
+	}
+
+	// both pulls download the shared layer at the same time
+	dst := newTestClient(t, host, nil)
+	dst.ChunkingThreshold = 1
+	var g errgroup.Group
+	for _, name := range names {
+		g.Go(func() error {
+			return dst.Pull(t.Context(), "http://"+name)
+		})
+	}
+	check(g.Wait())
+
+	for _, name := range names {
+		m, err := dst.ResolveLocal("http://" + name)
+		check(err)
+		for _, l := range m.Layers {
+			data, err := os.ReadFile(dst.Cache.GetFile(l.Digest))
+			check(err)
+			if blob.DigestFromBytes(data) != l.Digest {
+				t.Errorf("%s: layer %s has the wrong data", name, l.Digest.Short())
+			}
+		}
+	}
+}
+
+func TestRemoteChunksums(t *testing.T) {
+	check := testutil.Checker(t)
+
+	host := newTestRemote(t, &Remote{ChunkSize: 5})
+	rc := newTestClient(t, host, nil)
+	putTestModel(t, rc, host+"/library/smol:latest", "hello, registry!")
+	check(rc.Push(t.Context(), "http://"+host+"/library/smol", nil))
+
+	d := blob.DigestFromBytes("hello, registry!")
+	res, err := http.Get(fmt.Sprintf("http://%s/v2/library/smol/chunksums/%s", host, d))
+	check(err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	check(err)
+
+	// The chunks are split by content, so only check they cover the blob
+	// and have the right digests.
+	const data = "hello, registry!"
+	chunks, err := blob.ParseChunkSums(body)
+	check(err)
+	var next int64
+	for _, cs := range chunks {
+		if cs.Chunk.Start != next {
+			t.Fatalf("chunks = %q; chunk %v does not start at %d", body, cs.Chunk, next)
+		}
+		if want := blob.DigestFromBytes(data[cs.Chunk.Start : cs.Chunk.End+1]); cs.Digest != want {
+			t.Errorf("chunk %v digest = %v; want %v", cs.Chunk, cs.Digest, want)
+		}
+		next = cs.Chunk.End + 1
+	}
+	if next != int64(len(data)) {
+		t.Errorf("chunks = %q; want chunks covering %d bytes", body, len(data))
+	}
+
+	if got, want := res.Header.Get(ollama.ChunkingHeader), ollama.FormatChunking(5); got != want {
+		t.Errorf("%s = %q; want %q", ollama.ChunkingHeader, got, want)
+	}
+
+	wantURL := fmt.Sprintf("http://%s/v2/library/smol/blobs/%s", host, d)
+	if got := res.Header.Get("Content-Location"); got != wantURL {
+		t.Errorf("Content-Location = %q; want %q", got, wantURL)
+	}
+}
+
+func TestRemotePullDelta(t *testing.T) {
+	check := testutil.Checker(t)
+
+	c, err := blob.Open(t.TempDir())
+	check(err)
+	remote := &Remote{Cache: c, Logger: testutil.Slogger(t), ChunkSize: 256}
+
+	// count the bytes of blobs downloaded
+	var downloaded atomic.Int64
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		if strings.Contains(r.URL.Path, "/blobs/sha256") {
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+				downloaded.Add(end - start + 1)
+			}
+		}
+		remote.ServeHTTP(w, r)
+	}))
+	remote.URL = "http://" + s.Listener.Addr().String()
+	s.Start()
+	t.Cleanup(s.Close)
+	host := s.Listener.Addr().String()
+
+	v1 := make([]byte, 64<<10)
+	rand.Read(v1)
+	v2 := append([]byte(nil), v1[:30<<10]...)
+	v2 = append(v2, "a few changed tensors"...)
+	v2 = append(v2, v1[31<<10:]...)
+
+	src := newTestClient(t, host, nil)
+	putTestModel(t, src, host+"/library/smol:v1", string(v1))
+	putTestModel(t, src, host+"/library/smol:v2", string(v2))
+	check(src.Push(t.Context(), "http://"+host+"/library/smol:v1", nil))
+	check(src.Push(t.Context(), "http://"+host+"/library/smol:v2", nil))
+
+	dst := newTestClient(t, host, nil)
+	dst.ChunkingThreshold = 1
+	check(dst.Pull(t.Context(), "http://"+host+"/library/smol:v1"))
+	if n := downloaded.Swap(0); n != int64(len(v1)) {
+		t.Fatalf("downloaded %d bytes of v1; want %d", n, len(v1))
+	}
+
+	check(dst.Pull(t.Context(), "http://"+host+"/library/smol:v2"))
+	if n := downloaded.Load(); n == 0 || n > 4<<10 {
+		t.Errorf("downloaded %d bytes of v2; want only the changed chunks", n)
+	}
+
+	d := blob.DigestFromBytes(v2)
+	data, err := os.ReadFile(dst.Cache.GetFile(d))
+	check(err)
+	if blob.DigestFromBytes(data) != d {
+		t.Error("v2 layer has the wrong data")
+	}
+}
+
+func TestRemoteAuth(t *testing.T) {
+	check := testutil.Checker(t)
+
+	key, pub := generateKey(t)
+	other, _ := generateKey(t)
+
+	host := newTestRemote(t, &Remote{AuthorizedKeys: []ssh.PublicKey{pub}, Private: true})
+	name := "http://" + host + "/library/smol:latest"
+
+	for _, tt := range []struct {
+		key    ed25519.PrivateKey
+		status int
+	}{
+		{nil, 401},
+		{other, 403},
+	} {
+		rc := newTestClient(t, host, tt.key)
+		putTestModel(t, rc, host+"/library/smol:latest", "hello, registry!")
+		checkStatus(t, rc.Push(t.Context(), name, nil), tt.status)
+	}
+
+	src := newTestClient(t, host, key)
+	putTestModel(t, src, host+"/library/smol:latest", "hello, registry!")
+	check(src.Push(t.Context(), name, nil))
+
+	check(newTestClient(t, host, key).Pull(t.Context(), name))
+
+	_, err := newTestClient(t, host, nil).Resolve(t.Context(), name)
+	checkStatus(t, err, 401)
+
+	_, err = newTestClient(t, host, other).Resolve(t.Context(), name)
+	checkStatus(t, err, 403)
+}
+
+func TestRemoteAuthReplay(t *testing.T) {
+	check := testutil.Checker(t)
+
+	key, pub := generateKey(t)
+	host := newTestRemote(t, &Remote{AuthorizedKeys: []ssh.PublicKey{pub}, Private: true})
+
+	src := newTestClient(t, host, key)
+	putTestModel(t, src, host+"/library/smol:latest", "hello, registry!")
+	check(src.Push(t.Context(), "http://"+host+"/library/smol:latest", nil))
+
+	// a token the client made for another registry, which that registry
+	// could send on to this one
+	u := fmt.Sprintf("https://ollama.com?ts=%d", time.Now().Unix())
+	signer, err := ssh.NewSignerFromKey(key)
+	check(err)
+	sig, err := signer.Sign(rand.Reader, []byte(fmt.Sprintf("GET,%s,%s", u, zeroSum)))
+	check(err)
+	token := base64.StdEncoding.EncodeToString([]byte(u)) + ":" +
+		base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal()) + ":" +
+		base64.StdEncoding.EncodeToString(sig.Blob)
+
+	send := func(method, u string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, u, nil)
+		check(err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		check(err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	res := send("POST", fmt.Sprintf("http://%s/v2/library/smol/blobs/uploads/", host))
+	if res.StatusCode != 401 || res.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("push status = %d, challenge = %q; want 401 with a challenge", res.StatusCode, res.Header.Get("WWW-Authenticate"))
+	}
+
+	if res := send("GET", fmt.Sprintf("http://%s/v2/library/smol/manifests/latest", host)); res.StatusCode != 200 {
+		t.Errorf("pull status = %d; want 200", res.StatusCode)
+	}
+}
+
+func TestRemoteTokenBinding(t *testing.T) {
+	check := testutil.Checker(t)
+
+	key, pub := generateKey(t)
+	host := newTestRemote(t, &Remote{AuthorizedKeys: []ssh.PublicKey{pub}, Private: true})
+
+	send := func(method, u, token string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, u, nil)
+		check(err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		check(err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	start := fmt.Sprintf("http://%s/v2/library/smol/blobs/uploads/", host)
+	push := getToken(t, send("POST", start, ""), key)
+	if res := send("POST", start, push); res.StatusCode != 202 {
+		t.Errorf("push status = %d; want 202", res.StatusCode)
+	}
+	if res := send("POST", fmt.Sprintf("http://%s/v2/library/other/blobs/uploads/", host), push); res.StatusCode != 401 {
+		t.Errorf("push to another repository status = %d; want 401", res.StatusCode)
+	}
+
+	pull := getToken(t, send("GET", fmt.Sprintf("http://%s/v2/library/smol/manifests/latest", host), ""), key)
+	if res := send("POST", start, pull); res.StatusCode != 401 {
+		t.Errorf("push with a pull token status = %d; want 401", res.StatusCode)
+	}
+
+	// a signature the client made for the token endpoint another registry
+	// named in its challenge, sent on to this one with that registry as the
+	// Host
+	q := url.Values{}
+	q.Set("service", "evil.example.com")
+	q.Set("scope", "repository:library/smol:pull,push")
+	q.Set("ts", strconv.FormatInt(time.Now().Unix(), 10))
+	u := "http://evil.example.com/v2/token?" + q.Encode()
+
+	signer, err := ssh.NewSignerFromKey(key)
+	check(err)
+	sig, err := signer.Sign(rand.Reader, []byte(fmt.Sprintf("GET,%s,%s", u, zeroSum)))
+	check(err)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/v2/token?%s", host, q.Encode()), nil)
+	check(err)
+	req.Host = "evil.example.com"
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal())+":"+base64.StdEncoding.EncodeToString(sig.Blob))
+	res, err := http.DefaultClient.Do(req)
+	check(err)
+	defer res.Body.Close()
+	if res.StatusCode != 401 {
+		t.Errorf("token for another host status = %d; want 401", res.StatusCode)
+	}
+}
+
+func TestRemoteUploadTimeout(t *testing.T) {
+	check := testutil.Checker(t)
+
+	timeout := uploadTimeout
+	uploadTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { uploadTimeout = timeout })
+
+	r := &Remote{}
+	host := newTestRemote(t, r)
+
+	res, err := http.Post(fmt.Sprintf("http://%s/v2/library/smol/blobs/uploads/", host), "", nil)
+	check(err)
+	res.Body.Close()
+	if res.StatusCode != 202 {
+		t.Fatalf("status = %d; want 202", res.StatusCode)
+	}
+
+	r.mu.Lock()
+	var name string
+	for _, u := range r.uploads {
+		name = u.f.Name()
+	}
+	r.mu.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+
+	req, err := http.NewRequest("PATCH", res.Header.Get("Location"), strings.NewReader("hello"))
+	check(err)
+	res, err = http.DefaultClient.Do(req)
+	check(err)
+	res.Body.Close()
+	if res.StatusCode != 404 {
+		t.Errorf("status = %d; want 404", res.StatusCode)
+	}
+
+	if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("upload data not removed: %v", err)
+	}
+}
+
+// getToken answers the challenge of the unauthorized response res with a
+// request signed by key, like the original client.
+func getToken(t *testing.T, res *http.Response, key ed25519.PrivateKey) string {
+	t.Helper()
+	check := testutil.Checker(t)
+
+	if res.StatusCode != 401 {
+		t.Fatalf("status = %d; want 401", res.StatusCode)
+	}
+
+	m := regexp.MustCompile(`realm="([^"]+)",service="([^"]+)",scope="([^"]+)"`).FindStringSubmatch(res.Header.Get("WWW-Authenticate"))
+	if m == nil {
+		t.Fatalf("invalid challenge %q", res.Header.Get("WWW-Authenticate"))
+	}
+
+	u, err := url.Parse(m[1])
+	check(err)
+	q := url.Values{}
+	q.Set("service", m[2])
+	q.Set("scope", m[3])
+	q.Set("ts", strconv.FormatInt(time.Now().Unix(), 10))
+	q.Set("nonce", "abc")
+	u.RawQuery = q.Encode()
+
+	signer, err := ssh.NewSignerFromKey(key)
+	check(err)
+	sig, err := signer.Sign(rand.Reader, []byte(fmt.Sprintf("GET,%s,%s", u, zeroSum)))
+	check(err)
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	check(err)
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal())+":"+base64.StdEncoding.EncodeToString(sig.Blob))
+
+	tr, err := http.DefaultClient.Do(req)
+	check(err)
+	defer tr.Body.Close()
+	if tr.StatusCode != 200 {
+		data, _ := io.ReadAll(tr.Body)
+		t.Fatalf("token status = %d: %s", tr.StatusCode, data)
+	}
+
+	var token struct {
+		Token string `json:"token"`
+	}
+	check(json.NewDecoder(tr.Body).Decode(&token))
+	return token.Token
+}
+
+func TestRemoteChunkedUpload(t *testing.T) {
+	check := testutil.Checker(t)
+
+	key, pub := generateKey(t)
+	host := newTestRemote(t, &Remote{AuthorizedKeys: []ssh.PublicKey{pub}})
+
+	var token string
+	send := func(method, u string, header http.Header, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, u, strings.NewReader(body))
+		check(err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		check(err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	start := fmt.Sprintf("http://%s/v2/library/smol/blobs/uploads/", host)
+	token = getToken(t, send("POST", start, nil, ""), key)
+
+	data := "hello, registry!"
+	d := blob.DigestFromBytes(data)
+
+	// upload starts an upload with query and sends parts of data, returning
+	// the response to the first part not accepted, or else to completing
+	// the upload
+	upload := func(query string, parts ...[2]int) *http.Response {
+		t.Helper()
+		res := send("POST", start+query, nil, "")
+		if res.StatusCode != 202 {
+			t.Fatalf("status = %d; want 202", res.StatusCode)
+		}
+
+		next := res.Header.Get("Location")
+		for _, p := range parts {
+			res := send("PATCH", next, http.Header{"Content-Range": {fmt.Sprintf("%d-%d", p[0], p[1]-1)}}, data[p[0]:p[1]])
+			if res.StatusCode != 202 {
+				return res
+			}
+			next = res.Header.Get("Location")
+		}
+		return send("PUT", next+"?digest="+d.String(), nil, "")
+	}
+
+	// a missing part
+	if res := upload("", [2]int{0, 5}); res.StatusCode != 400 {
+		t.Fatalf("status = %d; want 400", res.StatusCode)
+	}
+
+	// parts are contiguous
+	if res := upload("", [2]int{5, 16}); res.StatusCode != 416 {
+		t.Fatalf("status = %d; want 416", res.StatusCode)
+	}
+	if res := upload("", [2]int{0, 5}, [2]int{7, 16}); res.StatusCode != 416 {
+		t.Fatalf("status = %d; want 416", res.StatusCode)
+	}
+
+	// no more than the size given at the start
+	if res := upload("?size=10", [2]int{0, 5}, [2]int{5, 16}); res.StatusCode != 416 {
+		t.Fatalf("status = %d; want 416", res.StatusCode)
+	}
+
+	if res := upload(fmt.Sprintf("?size=%d", len(data)), [2]int{0, 5}, [2]int{5, 16}); res.StatusCode != 201 {
+		t.Fatalf("status = %d; want 201", res.StatusCode)
+	}
+
+	res := send("HEAD", fmt.Sprintf("http://%s/v2/library/smol/blobs/%s", host, d), nil, "")
+	if res.StatusCode != 200 || res.ContentLength != int64(len(data)) {
+		t.Fatalf("status = %d, length = %d; want 200, %d", res.StatusCode, res.ContentLength, len(data))
+	}
+
+	res = send("POST", start+"?mount="+d.String()+"&from=library/other", nil, "")
+	if res.StatusCode != 201 {
+		t.Fatalf("mount status = %d; want 201", res.StatusCode)
+	}
+
+	// blobs are downloaded from the Location of the response
+	res = send("GET", fmt.Sprintf("http://%s/v2/library/smol/blobs/%s", host, d), http.Header{"Range": {"bytes=7-14"}}, "")
+	got, err := io.ReadAll(res.Body)
+	check(err)
+	if res.StatusCode != 206 || string(got) != "registry" {
+		t.Errorf("status = %d, body = %q; want 206, %q", res.StatusCode, got, "registry")
+	}
+	if _, err := res.Location(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,5 +1,6 @@
-// Package registry implements an http.Handler for handling local Ollama API
-// model management requests. See [Local] for details.
+// Package registry implements http.Handlers for handling local Ollama API
+// model management requests, and for serving models to Ollama clients as a
+// self-hosted registry. See [Local] and [Remote] for details.
 package registry
 
 import (
@@ -16,6 +17,7 @@ import (
 
 	"github.com/ollama/ollama/server/internal/cache/blob"
 	"github.com/ollama/ollama/server/internal/client/ollama"
+	"github.com/ollama/ollama/server/internal/throttle"
 )
 
 // Local implements an http.Handler for handling local Ollama API model
@@ -104,7 +106,6 @@ func (s *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Local) serveHTTP(rec *statusCodeRecorder, r *http.Request) {
-	var errattr slog.Attr
 	proxied, err := func() (bool, error) {
 		switch r.URL.Path {
 		case "/api/delete":
@@ -120,54 +121,66 @@ func (s *Local) serveHTTP(rec *statusCodeRecorder, r *http.Request) {
 		}
 	}()
 	if err != nil {
-		// We always log the error, so fill in the error log attribute
-		errattr = slog.String("error", err.Error())
+		writeError(rec, err)
+	}
 
-		var e *serverError
-		switch {
-		case errors.As(err, &e):
-		case errors.Is(err, ollama.ErrNameInvalid):
-			e = &serverError{400, "bad_request", err.Error()}
-		default:
-			e = errInternalError
-		}
+	if !proxied {
+		// we're only responsible for logging if we handled the request
+		logRequest(s.Logger, rec, r, err)
+	}
+}
 
-		data, err := json.Marshal(e)
-		if err != nil {
-			// unreachable
-			panic(err)
-		}
-		rec.Header().Set("Content-Type", "application/json")
-		rec.WriteHeader(e.Status)
-		rec.Write(data)
+// writeError writes err to w as a JSON serverError. Errors that are not
+// serverErrors are reported as internal errors.
+func writeError(w http.ResponseWriter, err error) {
+	var e *serverError
+	switch {
+	case errors.As(err, &e):
+	case errors.Is(err, ollama.ErrNameInvalid):
+		e = &serverError{400, "bad_request", err.Error()}
+	default:
+		e = errInternalError
+	}
 
-		// fallthrough to log
+	data, err := json.Marshal(e)
+	if err != nil {
+		// unreachable
+		panic(err)
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(data)
+}
 
-	if !proxied {
-		// we're only responsible for logging if we handled the request
-		var level slog.Level
-		if rec.status() >= 500 {
-			level = slog.LevelError
-		} else if rec.status() >= 400 {
-			level = slog.LevelWarn
-		}
+// logRequest logs the request r handled with rec, and err if it failed.
+func logRequest(log *slog.Logger, rec *statusCodeRecorder, r *http.Request, err error) {
+	// We always log the error, so fill in the error log attribute
+	var errattr slog.Attr
+	if err != nil {
+		errattr = slog.String("error", err.Error())
+	}
 
-		s.Logger.LogAttrs(r.Context(), level, "http",
-			errattr, // report first in line to make it easy to find
-
-			// TODO(bmizerany): Write a test to ensure that we are logging
-			// all of this correctly. That also goes for the level+error
-			// logic above.
-			slog.Int("status", rec.status()),
-			slog.String("method", r.Method),
-			slog.String("path", r.URL.Path),
-			slog.Int64("content-length", r.ContentLength),
-			slog.String("remote", r.RemoteAddr),
-			slog.String("proto", r.Proto),
-			slog.String("query", r.URL.RawQuery),
-		)
+	var level slog.Level
+	if rec.status() >= 500 {
+		level = slog.LevelError
+	} else if rec.status() >= 400 {
+		level = slog.LevelWarn
 	}
+
+	log.LogAttrs(r.Context(), level, "http",
+		errattr, // report first in line to make it easy to find
+
+		// TODO(bmizerany): Write a test to ensure that we are logging
+		// all of this correctly. That also goes for the level+error
+		// logic above.
+		slog.Int("status", rec.status()),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int64("content-length", r.ContentLength),
+		slog.String("remote", r.RemoteAddr),
+		slog.String("proto", r.Proto),
+		slog.String("query", r.URL.RawQuery),
+	)
 }
 
 type params struct {
@@ -205,6 +218,11 @@ type params struct {
 	//
 	// Use [stream()] to get the correct value for this field.
 	Stream *bool `json:"stream"`
+
+	// LimitRate and Window throttle the downloads of pulls. See
+	// [ollama.WithThrottle].
+	LimitRate int64  `json:"limit_rate"`
+	Window    string `json:"window"`
 }
 
 // model returns the model name for both old and new API requests.
@@ -257,9 +275,15 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	window, err := throttle.ParseWindow(p.Window)
+	if err != nil {
+		return &serverError{400, "bad_request", err.Error()}
+	}
+	ctx := ollama.WithThrottle(r.Context(), p.LimitRate, window)
+
 	enc := json.NewEncoder(w)
 	if !p.stream() {
-		if err := s.Client.Pull(r.Context(), p.model()); err != nil {
+		if err := s.Client.Pull(ctx, p.model()); err != nil {
 			if errors.Is(err, ollama.ErrModelNotFound) {
 				return errModelNotFound
 			}
@@ -280,6 +304,11 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 	progress := make(map[*ollama.Layer]int64)
 
 	progressCopy := make(map[*ollama.Layer]int64, len(progress))
+
+	// layers of other models read to find chunks of the pulled layers,
+	// reported by status, since they aren't pulled
+	indexing := make(map[*ollama.Layer]int64)
+	indexingCopy := make(map[*ollama.Layer]int64)
 	pushUpdate := func() {
 		defer maybeFlush()
 
@@ -289,7 +318,15 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 		// download. Needs more thought. This is fine for now.
 		mu.Lock()
 		maps.Copy(progressCopy, progress)
+		maps.Copy(indexingCopy, indexing)
 		mu.Unlock()
+		for l, n := range indexingCopy {
+			enc.Encode(progressUpdateJSON{
+				Status:    "indexing " + l.Digest.Short(),
+				Total:     l.Size,
+				Completed: n,
+			})
+		}
 		for l, n := range progress {
 			enc.Encode(progressUpdateJSON{
 				Digest:    l.Digest,
@@ -304,7 +341,7 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 		pushUpdate()
 		t.Reset(100 * time.Millisecond)
 	})
-	ctx := ollama.WithTrace(r.Context(), &ollama.Trace{
+	ctx = ollama.WithTrace(ctx, &ollama.Trace{
 		Update: func(l *ollama.Layer, n int64, err error) {
 			if n > 0 {
 				start() // flush initial state
@@ -313,6 +350,12 @@ func (s *Local) handlePull(w http.ResponseWriter, r *http.Request) error {
 			progress[l] = n
 			mu.Unlock()
 		},
+		Index: func(l *ollama.Layer, n int64) {
+			start()
+			mu.Lock()
+			indexing[l] = n
+			mu.Unlock()
+		},
 	})
 
 	done := make(chan error, 1)
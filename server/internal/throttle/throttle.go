@@ -0,0 +1,219 @@
+// Package throttle limits the rate of transfers, and restricts them to a
+// window of hours of the day.
+package throttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWindowClosed is returned by readers made by [Reader] when their window
+// closes.
+var ErrWindowClosed = errors.New("outside of the transfer window")
+
+// now is replaced in tests.
+var now = time.Now
+
+// A Limiter limits the rate of the transfers sharing it. A nil Limiter does
+// not limit.
+type Limiter struct {
+	rate float64 // bytes per second
+
+	mu   sync.Mutex
+	next time.Time // when the bytes reserved so far have been transferred
+}
+
+// NewLimiter returns a Limiter of rate bytes per second, or nil if rate is
+// not positive.
+func NewLimiter(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{rate: float64(rate)}
+}
+
+// Rate returns the rate of l in bytes per second, or zero if l is nil.
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// Wait waits until n more bytes can be transferred. Bursts of up to a second
+// of transfer are allowed after idle periods.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	t := now()
+	if burst := t.Add(-time.Second); l.next.Before(burst) {
+		l.next = burst
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	d := l.next.Sub(t)
+	l.mu.Unlock()
+
+	return sleep(ctx, d)
+}
+
+// A Window is a range of hours of the day, in local time, such as 22:00-06:00.
+// The zero Window is always open.
+type Window struct {
+	start, end time.Duration // since midnight
+	set        bool
+}
+
+// ParseWindow parses a window of the form HH:MM-HH:MM. Windows ending before
+// they start span midnight. The empty string is the zero Window.
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return Window{}, nil
+	}
+
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid window %q: want HH:MM-HH:MM", s)
+	}
+
+	var w Window
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if w.start == w.end {
+		return Window{}, fmt.Errorf("invalid window %q: empty", s)
+	}
+	w.set = true
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w Window) String() string {
+	if !w.set {
+		return ""
+	}
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.start) + "-" + clock(w.end)
+}
+
+// IsZero reports whether w is the zero Window.
+func (w Window) IsZero() bool {
+	return !w.set
+}
+
+// Open reports whether w is open at t.
+func (w Window) Open(t time.Time) bool {
+	if !w.set {
+		return true
+	}
+	d := sinceMidnight(t)
+	if w.start < w.end {
+		return w.start <= d && d < w.end
+	}
+	return d >= w.start || d < w.end
+}
+
+// Wait waits until w is open.
+func (w Window) Wait(ctx context.Context) error {
+	t := now()
+	if w.Open(t) {
+		return nil
+	}
+	d := w.start - sinceMidnight(t)
+	if d < 0 {
+		d += 24 * time.Hour
+	}
+	return sleep(ctx, d)
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second +
+		time.Duration(t.Nanosecond())
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Reader returns a reader that reads from r no faster than the rate of each
+// of the limiters allows, and fails with [ErrWindowClosed] once w is closed.
+// If there is nothing to limit, r is returned as is.
+func Reader(ctx context.Context, r io.Reader, w Window, limiters ...*Limiter) io.Reader {
+	var ls []*Limiter
+	var rate int64
+	for _, l := range limiters {
+		if l != nil {
+			ls = append(ls, l)
+			if rate == 0 || l.Rate() < rate {
+				rate = l.Rate()
+			}
+		}
+	}
+	if len(ls) == 0 && w.IsZero() {
+		return r
+	}
+
+	// Reads are split so that waits are short, and progress is seen
+	// often, even at low rates.
+	n := 32 << 10
+	if rate > 0 {
+		n = int(min(int64(n), max(rate/8, 1)))
+	}
+	return &reader{ctx: ctx, r: r, w: w, limiters: ls, n: n}
+}
+
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	w        Window
+	limiters []*Limiter
+	n        int // maximum read size
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if !r.w.Open(now()) {
+		return 0, ErrWindowClosed
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		if werr := l.Wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
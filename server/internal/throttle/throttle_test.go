@@ -0,0 +1,100 @@
+package throttle
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func clock(hour, minute int) time.Time {
+	return time.Date(2025, 1, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseWindow(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"22:00-06:00", "22:00-06:00", false},
+		{"9:30 - 17:00", "09:30-17:00", false},
+		{"22:00", "", true},
+		{"22:00-", "", true},
+		{"25:00-06:00", "", true},
+		{"10:00-10:00", "", true},
+	}
+	for _, tt := range cases {
+		w, err := ParseWindow(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWindow(%q) err = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got := w.String(); got != tt.want {
+			t.Errorf("ParseWindow(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWindowOpen(t *testing.T) {
+	night, _ := ParseWindow("22:00-06:00")
+	day, _ := ParseWindow("09:00-17:30")
+	cases := []struct {
+		w    Window
+		t    time.Time
+		want bool
+	}{
+		{Window{}, clock(12, 0), true},
+		{night, clock(23, 0), true},
+		{night, clock(2, 0), true},
+		{night, clock(22, 0), true},
+		{night, clock(6, 0), false},
+		{night, clock(12, 0), false},
+		{day, clock(9, 0), true},
+		{day, clock(17, 29), true},
+		{day, clock(17, 30), false},
+		{day, clock(8, 59), false},
+	}
+	for _, tt := range cases {
+		if got := tt.w.Open(tt.t); got != tt.want {
+			t.Errorf("%v.Open(%v) = %v; want %v", tt.w, tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestReaderWindowClosed(t *testing.T) {
+	w, _ := ParseWindow("22:00-06:00")
+	defer func(f func() time.Time) { now = f }(now)
+
+	now = func() time.Time { return clock(23, 0) }
+	r := Reader(t.Context(), strings.NewReader("hello"), w)
+	buf := make([]byte, 2)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	now = func() time.Time { return clock(6, 0) }
+	if _, err := r.Read(buf); !errors.Is(err, ErrWindowClosed) {
+		t.Fatalf("err = %v; want %v", err, ErrWindowClosed)
+	}
+}
+
+func TestReaderRate(t *testing.T) {
+	if r := strings.NewReader(""); Reader(t.Context(), r, Window{}, nil) != r {
+		t.Error("reader without limits is wrapped")
+	}
+
+	// The first second of transfer is a burst, so reading two seconds'
+	// worth takes about a second.
+	l := NewLimiter(1000)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, Reader(t.Context(), strings.NewReader(strings.Repeat("x", 2000)), Window{}, l))
+	if err != nil || n != 2000 {
+		t.Fatalf("copied %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond || d > 3*time.Second {
+		t.Errorf("copy took %v; want about 1s", d)
+	}
+}
@@ -114,6 +114,18 @@ func (mp ModelPath) BaseURL() *url.URL {
 	}
 }
 
+// PullURL returns the base URL models are pulled from. This is the registry
+// mirror, if one is configured and mp is on the default registry, and
+// BaseURL otherwise.
+func (mp ModelPath) PullURL() *url.URL {
+	if mp.Registry == DefaultRegistry {
+		if u := envconfig.RegistryMirror(); u != nil {
+			return u
+		}
+	}
+	return mp.BaseURL()
+}
+
 func GetManifestPath() (string, error) {
 	path := filepath.Join(envconfig.Models(), "manifests")
 	if err := os.MkdirAll(path, 0o755); err != nil {
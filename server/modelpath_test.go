@@ -154,3 +154,25 @@ func TestParseModelPath(t *testing.T) {
 		})
 	}
 }
+
+func TestPullURL(t *testing.T) {
+	t.Setenv("OLLAMA_REGISTRY_MIRROR", "http://10.0.0.1:5000")
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"repo", "http://10.0.0.1:5000"},
+		{"ns/repo:tag", "http://10.0.0.1:5000"},
+		{"example.com/ns/repo:tag", "https://example.com"},
+		{"http://example.com/ns/repo:tag", "http://example.com"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.arg, func(t *testing.T) {
+			if got := ParseModelPath(tc.arg).PullURL().String(); got != tc.want {
+				t.Errorf("got: %q want: %q", got, tc.want)
+			}
+		})
+	}
+}
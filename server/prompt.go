@@ -19,7 +19,7 @@ type tokenizeFunc func(context.Context, string) ([]int, error)
 
 var errTooManyImages = errors.New("vision model only supports a single image per message")
 
-// chatPrompt accepts a list of messages and returns the prompt and images that should be used for the next chat turn.
+// chatPrompt accepts a list of messages and returns the prompt and multimodal data (images and audio) that should be used for the next chat turn.
 // chatPrompt truncates any messages that exceed the context window of the model, making sure to always include 1) the
 // latest message and 2) system messages
 func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool) (prompt string, images []llm.ImageData, _ error) {
@@ -37,6 +37,9 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 		imageNumTokens = 768
 	}
 
+	// each 30 second chunk of audio is represented by 750 embeddings
+	const audioNumTokens = 750
+
 	n := len(msgs) - 1
 	// in reverse, find all messages that fit into context window
 	for i := n; i >= 0; i-- {
@@ -73,6 +76,10 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 			}
 		}
 
+		for _, m := range msgs[i:] {
+			ctxLen += audioNumTokens * len(m.Audio)
+		}
+
 		if ctxLen > opts.NumCtx {
 			slog.Debug("truncating input messages which exceed context length", "truncated", len(msgs[i:]))
 			break
@@ -137,6 +144,18 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 
 			images = append(images, imgData)
 		}
+
+		// audio shares the multimodal inputs with images but is tagged separately
+		for _, a := range msg.Audio {
+			audioTag := fmt.Sprintf("[audio-%d]", len(images))
+			if !strings.Contains(prompt, "[audio]") {
+				prefix += audioTag
+			} else {
+				prompt = strings.Replace(prompt, "[audio]", audioTag, 1)
+			}
+
+			images = append(images, llm.ImageData{ID: len(images), Data: a})
+		}
 		msgs[currMsgIdx+cnt].Content = prefix + imgPrompt + prompt
 	}
 
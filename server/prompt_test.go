@@ -228,6 +228,32 @@ func TestChatPrompt(t *testing.T) {
 				images: [][]byte{[]byte("one hotdog"), []byte("two hotdogs")},
 			},
 		},
+		{
+			name:  "image and audio same prompt",
+			model: visionModel,
+			limit: 2048,
+			msgs: []api.Message{
+				{Role: "user", Content: "Does the [audio] match the picture?", Images: []api.ImageData{[]byte("one hotdog")}, Audio: []api.AudioData{[]byte("barking")}},
+			},
+			expect: expect{
+				prompt: "[img-0]Does the [audio-1] match the picture? ",
+				images: [][]byte{[]byte("one hotdog"), []byte("barking")},
+			},
+		},
+		{
+			name:  "truncate messages with audio",
+			model: visionModel,
+			limit: 1024,
+			msgs: []api.Message{
+				{Role: "user", Content: "Transcribe this", Audio: []api.AudioData{[]byte("speech")}},
+				{Role: "assistant", Content: "Sure."},
+				{Role: "user", Content: "And this one", Audio: []api.AudioData{[]byte("more speech")}},
+			},
+			expect: expect{
+				prompt: "Sure. [audio-0]And this one ",
+				images: [][]byte{[]byte("more speech")},
+			},
+		},
 		{
 			name:  "messages with mllama (no images)",
 			model: mllamaModel,
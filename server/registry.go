@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/client/ollama"
+	"github.com/ollama/ollama/server/internal/registry"
+)
+
+// RegistryConfig configures the registry served by ServeRegistry.
+type RegistryConfig struct {
+	// Dir is the directory models pushed to the registry are stored in.
+	Dir string
+
+	// URL is the base URL clients reach the registry at, such as
+	// https://models.example.com. It is required.
+	URL string
+
+	// AuthorizedKeys is the path of an authorized_keys file with the public
+	// keys allowed to push. If empty, anyone can push.
+	AuthorizedKeys string
+
+	// Private, if true, also requires one of the authorized keys to pull.
+	Private bool
+
+	// CertFile and KeyFile, if set, are the TLS certificate and key the
+	// registry is served with.
+	CertFile, KeyFile string
+
+	// Mirror, if set, is the URL of a registry, such as
+	// https://registry.ollama.ai, that the registry is a read-only,
+	// pull-through mirror of.
+	Mirror string
+
+	// MaxSize, if positive, is the size in bytes the models kept by a
+	// mirror are limited to.
+	MaxSize int64
+}
+
+// ServeRegistry serves a registry that Ollama clients can push models to and
+// pull models from on ln.
+func ServeRegistry(ln net.Listener, cfg RegistryConfig) error {
+	c, err := blob.Open(cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	var keys []ssh.PublicKey
+	if cfg.AuthorizedKeys != "" {
+		keys, err = registry.ReadAuthorizedKeys(cfg.AuthorizedKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	if u, err := url.Parse(cfg.URL); err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid registry URL %q", cfg.URL)
+	}
+
+	if cfg.Mirror != "" {
+		u, err := url.Parse(cfg.Mirror)
+		if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid mirror URL %q", cfg.Mirror)
+		}
+	}
+
+	if len(keys) == 0 {
+		if cfg.Private {
+			return errors.New("a private registry requires authorized keys")
+		}
+		if cfg.Mirror == "" {
+			slog.Warn("no authorized keys, anyone can push to the registry")
+		}
+	}
+
+	srv := &http.Server{
+		Handler: &registry.Remote{
+			Cache:          c,
+			Logger:         slog.Default(),
+			URL:            cfg.URL,
+			AuthorizedKeys: keys,
+			Private:        cfg.Private,
+			Upstream:       cfg.Mirror,
+			Client:         &ollama.Registry{UserAgent: ollama.UserAgent()},
+			MaxSize:        cfg.MaxSize,
+		},
+	}
+
+	slog.Info("serving registry", "addr", ln.Addr(), "url", cfg.URL, "dir", cfg.Dir, "keys", len(keys), "private", cfg.Private, "mirror", cfg.Mirror, "max_size", format.HumanBytes(cfg.MaxSize))
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		return srv.ServeTLS(ln, cfg.CertFile, cfg.KeyFile)
+	}
+	return srv.Serve(ln)
+}
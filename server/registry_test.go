@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/server/internal/throttle"
+	"github.com/ollama/ollama/types/model"
+)
+
+// startRegistry serves a registry configured by cfg on a random port, and
+// returns its address.
+func startRegistry(t *testing.T, cfg RegistryConfig) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.URL == "" {
+		cfg.URL = "http://" + ln.Addr().String()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeRegistry(ln, cfg)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-done; err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, http.ErrServerClosed) {
+			t.Error(err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestServeRegistry(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	sshPub := writeKey(t, home)
+
+	keys := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := os.WriteFile(keys, ssh.MarshalAuthorizedKey(sshPub), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startRegistry(t, RegistryConfig{Dir: t.TempDir(), AuthorizedKeys: keys, Private: true})
+	name := addr + "/library/test:latest"
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  name,
+		Files: map[string]string{"test.gguf": digest},
+	}); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+
+	want, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PushModel(t.Context(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// pull into an empty models directory
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	if err := PullModel(t.Context(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want.Layers, got.Layers, cmpopts.IgnoreUnexported(Layer{})); diff != "" {
+		t.Errorf("layers mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(want.Config, got.Config, cmpopts.IgnoreUnexported(Layer{})); diff != "" {
+		t.Errorf("config mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, l := range append(got.Layers, got.Config) {
+		p, err := GetBlobsPath(l.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi, err := os.Stat(p); err != nil || fi.Size() != l.Size {
+			t.Errorf("layer %s not pulled: %v", l.Digest, err)
+		}
+	}
+}
+
+func TestServeRegistryMirror(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	upstream := startRegistry(t, RegistryConfig{Dir: t.TempDir()})
+	name := upstream + "/library/test:latest"
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  name,
+		Files: map[string]string{"test.gguf": digest},
+	}); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+
+	want, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PushModel(t.Context(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// models from the default registry are pulled through the mirror,
+	// keeping their names
+	mirror := startRegistry(t, RegistryConfig{Dir: t.TempDir(), Mirror: "http://" + upstream})
+	t.Setenv("OLLAMA_REGISTRY_MIRROR", "http://"+mirror)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	if err := PullModel(t.Context(), "test", &registryOptions{}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want.Layers, got.Layers, cmpopts.IgnoreUnexported(Layer{})); diff != "" {
+		t.Errorf("layers mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// closedWindow returns a download window that opens in two hours.
+func closedWindow(t *testing.T) string {
+	t.Helper()
+	now := time.Now()
+	return now.Add(2*time.Hour).Format("15:04") + "-" + now.Add(3*time.Hour).Format("15:04")
+}
+
+func TestPullThrottle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	addr := startRegistry(t, RegistryConfig{Dir: t.TempDir()})
+	name := addr + "/library/test:latest"
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  name,
+		Files: map[string]string{"test.gguf": digest},
+	}); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	if err := PushModel(t.Context(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("invalid window", func(t *testing.T) {
+		w := createRequest(t, s.PullHandler, api.PullRequest{Model: name, Insecure: true, Window: "22:00"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("code = %d; want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("closed window", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+		t.Setenv("OLLAMA_DOWNLOAD_WINDOW", closedWindow(t))
+
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		defer cancel()
+
+		var statuses []string
+		err := PullModel(ctx, name, &registryOptions{Insecure: true}, func(r api.ProgressResponse) {
+			statuses = append(statuses, r.Status)
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v; want %v", err, context.DeadlineExceeded)
+		}
+		if !slices.ContainsFunc(statuses, func(s string) bool { return strings.HasPrefix(s, "waiting for download window") }) {
+			t.Errorf("statuses = %v; want waiting for download window", statuses)
+		}
+		if _, err := ParseNamedManifest(model.ParseName(name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("err = %v; want %v", err, os.ErrNotExist)
+		}
+	})
+
+	t.Run("limit rate", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+		t.Setenv("OLLAMA_MAX_DOWNLOAD_RATE", "1MB")
+
+		w := createRequest(t, s.PullHandler, api.PullRequest{Model: name, Insecure: true, LimitRate: 1000})
+		if w.Code != http.StatusOK {
+			t.Fatalf("code = %d; want %d", w.Code, http.StatusOK)
+		}
+		if _, err := ParseNamedManifest(model.ParseName(name)); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestBlobDownloadThrottles(t *testing.T) {
+	t.Setenv("OLLAMA_MAX_DOWNLOAD_RATE", "")
+
+	closed, err := throttle.ParseWindow(closedWindow(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b blobDownload
+	first := &registryOptions{Limiter: throttle.NewLimiter(1 << 30)}
+	second := &registryOptions{Window: closed}
+	leaveFirst := b.join(first)
+	leaveSecond := b.join(second)
+
+	// the limits of every pull sharing the download apply to it
+	_, limiters := b.throttles()
+	if !slices.Contains(limiters, first.Limiter) {
+		t.Errorf("limiters = %v; want the limiter of the first pull", limiters)
+	}
+	r := &throttledReader{ctx: t.Context(), r: strings.NewReader("data"), b: &b}
+	if _, err := r.Read(make([]byte, 4)); !errors.Is(err, throttle.ErrWindowClosed) {
+		t.Fatalf("err = %v; want %v", err, throttle.ErrWindowClosed)
+	}
+
+	// and no longer once the pull stops waiting for it
+	leaveSecond()
+	if n, err := r.Read(make([]byte, 4)); n != 4 || err != nil {
+		t.Fatalf("n, err = %d, %v; want 4, nil", n, err)
+	}
+	leaveFirst()
+	if _, limiters := b.throttles(); slices.Contains(limiters, first.Limiter) {
+		t.Errorf("limiters = %v; want no limiter of the first pull", limiters)
+	}
+}
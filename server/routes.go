@@ -19,7 +19,9 @@ import (
 	"os/signal"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -32,10 +34,12 @@ import (
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/model/audioproc"
 	"github.com/ollama/ollama/model/models/mllama"
 	"github.com/ollama/ollama/openai"
 	"github.com/ollama/ollama/server/internal/client/ollama"
 	"github.com/ollama/ollama/server/internal/registry"
+	"github.com/ollama/ollama/server/internal/throttle"
 	"github.com/ollama/ollama/template"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
@@ -70,6 +74,7 @@ func init() {
 var (
 	errRequired    = errors.New("is required")
 	errBadTemplate = errors.New("template error")
+	errBadAdapter  = errors.New("invalid adapter")
 )
 
 func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options, error) {
@@ -87,7 +92,7 @@ func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options
 
 // scheduleRunner schedules a runner after validating inputs such as capabilities and model options.
 // It returns the allocated runner, model instance, and consolidated options if successful and error otherwise.
-func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
+func (s *Server) scheduleRunner(ctx context.Context, name string, adapter string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
 	if name == "" {
 		return nil, nil, nil, fmt.Errorf("model %w", errRequired)
 	}
@@ -101,6 +106,10 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 		return nil, nil, nil, fmt.Errorf("%s %w", name, err)
 	}
 
+	if err := useAdapter(model, adapter); err != nil {
+		return nil, nil, nil, err
+	}
+
 	opts, err := modelOptions(model, requestOpts)
 	if err != nil {
 		return nil, nil, nil, err
@@ -117,6 +126,42 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 	return runner.llama, model, &opts, nil
 }
 
+// useAdapter replaces the adapters of m with the adapter of the model named
+// adapter, which must have been created from the same base model
+func useAdapter(m *Model, adapter string) error {
+	if adapter == "" {
+		return nil
+	}
+
+	a, err := GetModel(adapter)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: model %q not found", errBadAdapter, adapter)
+	} else if err != nil {
+		return err
+	}
+
+	if len(a.AdapterPaths) != 1 {
+		return fmt.Errorf("%w: %q must have exactly one adapter", errBadAdapter, adapter)
+	}
+
+	if a.ModelPath != m.ModelPath {
+		return fmt.Errorf("%w: %q was not created for %q", errBadAdapter, adapter, m.ShortName)
+	}
+
+	m.AdapterPaths = a.AdapterPaths
+	return nil
+}
+
+// runtimeAdapter is the adapter runners that swap adapters per request
+// apply to the completions of m
+func runtimeAdapter(m *Model) string {
+	if len(m.AdapterPaths) == 1 {
+		return m.AdapterPaths[0]
+	}
+
+	return ""
+}
+
 func (s *Server) GenerateHandler(c *gin.Context) {
 	checkpointStart := time.Now()
 	var req api.GenerateRequest
@@ -181,7 +226,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		caps = append(caps, CapabilityInsert)
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), req.Adapter, caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support generate", req.Model)})
 		return
@@ -306,6 +351,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			Images:  images,
 			Format:  req.Format,
 			Options: opts,
+			Adapter: runtimeAdapter(m),
 		}, func(cr llm.CompletionResponse) {
 			res := api.GenerateResponse{
 				Model:      req.Model,
@@ -422,7 +468,7 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), "", []Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -497,6 +543,296 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+func (s *Server) RerankHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.RerankRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.TopN < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "top_n must not be negative"})
+		return
+	}
+
+	truncate := req.Truncate == nil || *req.Truncate
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), "", []Capability{CapabilityRerank}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	if len(req.Documents) == 0 {
+		c.JSON(http.StatusOK, api.RerankResponse{Model: req.Model, Results: []api.RerankResult{}})
+		return
+	}
+
+	info, err := getRerankInfo(m.ModelPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctxLen := min(opts.NumCtx, int(info.contextLength))
+
+	query, err := r.Tokenize(c.Request.Context(), req.Query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// each pair is [CLS] query [SEP] document [SEP], and only documents
+	// are truncated to fit
+	room := ctxLen - len(query) - 3
+	if room < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query exceeds maximum context length"})
+		return
+	}
+
+	var count int
+	inputs := make([][]int, len(req.Documents))
+	for i, doc := range req.Documents {
+		tokens, err := r.Tokenize(c.Request.Context(), doc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(tokens) > room {
+			if !truncate {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "input length exceeds maximum context length"})
+				return
+			}
+			tokens = tokens[:room]
+		}
+
+		inputs[i] = slices.Concat([]int{info.cls}, query, []int{info.sep}, tokens, []int{info.sep})
+		count += len(inputs[i])
+	}
+
+	var g errgroup.Group
+	results := make([]api.RerankResult, len(inputs))
+	for i, input := range inputs {
+		g.Go(func() error {
+			score, err := r.EmbeddingTokens(c.Request.Context(), input)
+			if err != nil {
+				return err
+			}
+
+			if len(score) == 0 {
+				return errors.New("no score returned for document")
+			}
+
+			results[i] = api.RerankResult{Index: i, Document: req.Documents[i], RelevanceScore: score[0]}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
+		return
+	}
+
+	slices.SortStableFunc(results, func(a, b api.RerankResult) int {
+		return cmp.Compare(b.RelevanceScore, a.RelevanceScore)
+	})
+
+	if req.TopN > 0 && req.TopN < len(results) {
+		results = results[:req.TopN]
+	}
+
+	c.JSON(http.StatusOK, api.RerankResponse{
+		Model:           req.Model,
+		Results:         results,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+	})
+}
+
+// rerankInfo is what the rerank handler needs from the model file of a
+// cross-encoder
+type rerankInfo struct {
+	// cls and sep are the tokens the query and each document are put
+	// between, as the [CLS] query [SEP] document [SEP] pair expected by
+	// cross-encoders
+	cls, sep      int
+	contextLength uint64
+}
+
+// rerankInfos caches the rerankInfo of each model file, so it isn't decoded
+// for every request
+var rerankInfos sync.Map
+
+func getRerankInfo(modelPath string) (rerankInfo, error) {
+	if v, ok := rerankInfos.Load(modelPath); ok {
+		return v.(rerankInfo), nil
+	}
+
+	f, err := llm.LoadModel(modelPath, 0)
+	if err != nil {
+		return rerankInfo{}, err
+	}
+
+	info := rerankInfo{
+		cls:           int(cmp.Or(f.KV().Uint("tokenizer.ggml.cls_token_id"), f.KV().Uint("tokenizer.ggml.bos_token_id", 101))),
+		sep:           int(f.KV().Uint("tokenizer.ggml.seperator_token_id", 102)),
+		contextLength: f.KV().ContextLength(),
+	}
+
+	rerankInfos.Store(modelPath, info)
+	return info, nil
+}
+
+func (s *Server) TranscribeHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	// leave room for the base64 encoding of the audio and the other fields
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, (maxTranscribeSize+2)/3*4+1<<20)
+
+	var req api.TranscribeRequest
+	var maxBytesErr *http.MaxBytesError
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if errors.As(err, &maxBytesErr) {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("audio is larger than %d MB", maxTranscribeSize>>20)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case len(req.Audio) == 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "audio is required"})
+		return
+	case len(req.Audio) > maxTranscribeSize:
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("audio is larger than %d MB", maxTranscribeSize>>20)})
+		return
+	}
+
+	if req.Language != "" && !isLanguageToken(req.Language) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid language '%s'", req.Language)})
+		return
+	}
+
+	chunks, duration, err := splitAudio(req.Audio)
+	if errors.Is(err, audioproc.ErrTooLong) {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("audio is longer than %v minutes", maxTranscribeDuration.Minutes())})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), "", []Capability{CapabilityTranscribe}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	// the decoder has learned positions so the prompt and the transcription of
+	// each chunk have to fit in its context. The metadata was read when the
+	// capabilities of the model were checked.
+	ctxLen := cmp.Or(int(m.decodeKV().ContextLength()), 448)
+	if _, ok := req.Options["temperature"]; !ok {
+		opts.Temperature = 0
+	}
+
+	if opts.NumPredict < 0 || opts.NumPredict > ctxLen/2 {
+		opts.NumPredict = ctxLen / 2
+	}
+
+	language := req.Language
+	previous := req.Prompt
+
+	var segments []api.TranscribeSegment
+	for _, chunk := range chunks {
+		if previous != "" {
+			tokens, err := r.Tokenize(c.Request.Context(), previous)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			// leave room for the special tokens that follow the previous text
+			if n := ctxLen/2 - 8; len(tokens) > n {
+				previous, err = r.Detokenize(c.Request.Context(), tokens[len(tokens)-n:])
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+
+		var sb strings.Builder
+		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
+			Prompt:  transcribePrompt(previous, language, req.Timestamps),
+			Images:  []llm.ImageData{{ID: 0, Data: chunk.Data}},
+			Options: opts,
+		}, func(cr llm.CompletionResponse) {
+			sb.WriteString(cr.Content)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		detected, chunkSegments := parseTranscription(sb.String(), chunk)
+		if language == "" {
+			language = detected
+		}
+
+		var texts []string
+		for _, segment := range chunkSegments {
+			texts = append(texts, segment.Text)
+		}
+
+		previous = strings.Join(texts, " ")
+		segments = append(segments, chunkSegments...)
+	}
+
+	var texts []string
+	for _, segment := range segments {
+		texts = append(texts, segment.Text)
+	}
+
+	resp := api.TranscribeResponse{
+		Model:         req.Model,
+		Text:          strings.Join(texts, " "),
+		Language:      language,
+		Duration:      duration,
+		TotalDuration: time.Since(checkpointStart),
+		LoadDuration:  checkpointLoaded.Sub(checkpointStart),
+	}
+
+	if req.Timestamps {
+		resp.Segments = segments
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
 func normalize(vec []float32) []float32 {
 	var sum float32
 	for _, v := range vec {
@@ -530,7 +866,7 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), "", []Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -583,6 +919,12 @@ func (s *Server) PullHandler(c *gin.Context) {
 		return
 	}
 
+	window, err := throttle.ParseWindow(req.Window)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -592,6 +934,8 @@ func (s *Server) PullHandler(c *gin.Context) {
 
 		regOpts := &registryOptions{
 			Insecure: req.Insecure,
+			Limiter:  throttle.NewLimiter(req.LimitRate),
+			Window:   window,
 		}
 
 		ctx, cancel := context.WithCancel(c.Request.Context())
@@ -641,6 +985,7 @@ func (s *Server) PushHandler(c *gin.Context) {
 
 		regOpts := &registryOptions{
 			Insecure: req.Insecure,
+			Sign:     req.Sign,
 		}
 
 		ctx, cancel := context.WithCancel(c.Request.Context())
@@ -986,6 +1331,152 @@ func (s *Server) CopyHandler(c *gin.Context) {
 	}
 }
 
+func (s *Server) ExportHandler(c *gin.Context) {
+	var r api.ExportRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(r.Models) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "models are required"})
+		return
+	}
+
+	var names []model.Name
+	for _, m := range r.Models {
+		n := model.ParseName(m)
+		if !n.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %q is invalid", m)})
+			return
+		}
+		n, err := getExistingName(n)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := ParseNamedManifest(n); errors.Is(err, os.ErrNotExist) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", m)})
+			return
+		}
+		if !slices.Contains(names, n) {
+			names = append(names, n)
+		}
+	}
+
+	b, err := newBundle(c.Request.Context(), names)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	size, err := b.size()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Length", strconv.FormatInt(size, 10))
+	c.Status(http.StatusOK)
+	if err := b.writeTo(c.Writer); err != nil {
+		// the response has started, so the client only sees a
+		// truncated bundle
+		slog.Error("export failed", "models", names, "error", err)
+	}
+}
+
+func (s *Server) ImportHandler(c *gin.Context) {
+	force, _ := strconv.ParseBool(c.Query("force"))
+	names, err := ImportModels(c.Request.Body, force)
+	if errors.Is(err, errBundleInvalid) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, errModelUnsigned) || errors.Is(err, errModelUntrusted) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, errModelExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := api.ImportResponse{Models: []string{}}
+	for _, n := range names {
+		resp.Models = append(resp.Models, n.DisplayShortest())
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) GCHandler(c *gin.Context) {
+	var req api.GCRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := GarbageCollect(req.DryRun)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) VerifyHandler(c *gin.Context) {
+	var req api.VerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var names []model.Name
+	for _, m := range req.Models {
+		n := model.ParseName(m)
+		if !n.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name %q is invalid", m)})
+			return
+		}
+		n, err := getExistingName(n)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := ParseNamedManifest(n); errors.Is(err, os.ErrNotExist) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", m)})
+			return
+		}
+		names = append(names, n)
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		fn := func(r api.VerifyResponse) {
+			ch <- r
+		}
+
+		problems, err := VerifyModels(c.Request.Context(), names, req.Repair, &registryOptions{Insecure: req.Insecure}, fn)
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+		ch <- api.VerifyResponse{ProgressResponse: api.ProgressResponse{Status: "success"}, Problems: problems}
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		waitForStream(c, ch)
+		return
+	}
+
+	streamResponse(c, ch)
+}
+
 func (s *Server) HeadBlobHandler(c *gin.Context) {
 	path, err := GetBlobsPath(c.Param("digest"))
 	if err != nil {
@@ -1191,6 +1682,10 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
 	r.POST("/api/copy", s.CopyHandler)
+	r.POST("/api/export", s.ExportHandler)
+	r.POST("/api/import", s.ImportHandler)
+	r.POST("/api/gc", s.GCHandler)
+	r.POST("/api/verify", s.VerifyHandler)
 
 	// Inference
 	r.GET("/api/ps", s.PsHandler)
@@ -1198,9 +1693,12 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 	r.POST("/api/chat", s.ChatHandler)
 	r.POST("/api/embed", s.EmbedHandler)
 	r.POST("/api/embeddings", s.EmbeddingsHandler)
+	r.POST("/api/rerank", s.RerankHandler)
+	r.POST("/api/transcribe", s.TranscribeHandler)
 
 	// Inference (OpenAI compatibility)
 	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/audio/transcriptions", openai.TranscriptionsMiddleware(), s.TranscribeHandler)
 	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
 	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
 	r.GET("/v1/models", openai.ListMiddleware(), s.ListHandler)
@@ -1274,7 +1772,11 @@ func Serve(ln net.Listener) error {
 	s := &Server{addr: ln.Addr()}
 
 	var rc *ollama.Registry
-	if useClient2 {
+	if useClient2 && envconfig.TrustedKeys() != "" {
+		// The new client does not verify signatures, so pulls must go
+		// through the old one to enforce the trust policy.
+		slog.Warn("client2 experiment disabled by OLLAMA_TRUSTED_KEYS")
+	} else if useClient2 {
 		var err error
 		rc, err = ollama.DefaultRegistry()
 		if err != nil {
@@ -1344,6 +1846,11 @@ func waitForStream(c *gin.Context, ch chan interface{}) {
 				c.JSON(http.StatusOK, r)
 				return
 			}
+		case api.VerifyResponse:
+			if r.Status == "success" {
+				c.JSON(http.StatusOK, r)
+				return
+			}
 		case gin.H:
 			status, ok := r["status"].(int)
 			if !ok {
@@ -1472,6 +1979,9 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	if len(req.Tools) > 0 {
 		caps = append(caps, CapabilityTools)
 	}
+	if slices.ContainsFunc(req.Messages, func(m api.Message) bool { return len(m.Audio) > 0 }) {
+		caps = append(caps, CapabilityAudio)
+	}
 
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
@@ -1484,7 +1994,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), req.Adapter, caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support chat", req.Model)})
 		return
@@ -1530,6 +2040,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			Images:  images,
 			Format:  req.Format,
 			Options: opts,
+			Adapter: runtimeAdapter(m),
 		}, func(r llm.CompletionResponse) {
 			res := api.ChatResponse{
 				Model:      req.Model,
@@ -1626,7 +2137,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 func handleScheduleError(c *gin.Context, name string, err error) {
 	switch {
-	case errors.Is(err, errCapabilities), errors.Is(err, errRequired):
+	case errors.Is(err, errCapabilities), errors.Is(err, errRequired), errors.Is(err, errBadAdapter):
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	case errors.Is(err, context.Canceled):
 		c.JSON(499, gin.H{"error": "request canceled"})
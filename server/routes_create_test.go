@@ -3,7 +3,9 @@ package server
 import (
 	"bytes"
 	"cmp"
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,12 +16,16 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
 )
 
 var stream bool = false
@@ -712,6 +718,61 @@ func TestCreateDetectTemplate(t *testing.T) {
 	})
 }
 
+func TestCreateQuantize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(1),
+		"llama.block_count":    uint32(1),
+	}, []ggml.Tensor{
+		{Name: "blk.0.attn_norm.weight", Kind: 0, Shape: []uint64{256}, WriterTo: bytes.NewReader(make([]byte, 256*4))},
+		{Name: "blk.0.ffn_up.weight", Kind: 1, Shape: []uint64{4, 256}, WriterTo: bytes.NewReader(make([]byte, 4*256*2))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:     "test",
+		Files:    map[string]string{"test.gguf": digest},
+		Quantize: "q8_0",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(m.ModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ft := g.KV().FileType().String(); ft != "Q8_0" {
+		t.Errorf("file type = %s, want Q8_0", ft)
+	}
+
+	for _, tensor := range g.Tensors().Items() {
+		want := map[string]uint32{"blk.0.attn_norm.weight": 0, "blk.0.ffn_up.weight": 8}[tensor.Name]
+		if tensor.Kind != want {
+			t.Errorf("%s: kind = %d, want %d", tensor.Name, tensor.Kind, want)
+		}
+	}
+}
+
 func TestDetectModelTypeFromFiles(t *testing.T) {
 	t.Run("gguf file", func(t *testing.T) {
 		_, digest := createBinFile(t, nil, nil)
@@ -808,3 +869,457 @@ func TestDetectModelTypeFromFiles(t *testing.T) {
 		}
 	})
 }
+
+type imatrixRunner struct {
+	llm.LlamaServer
+	llm.ImatrixRequest
+	llm.ImatrixResponse
+}
+
+func (m *imatrixRunner) Imatrix(_ context.Context, req llm.ImatrixRequest) (*llm.ImatrixResponse, error) {
+	m.ImatrixRequest = req
+	return &m.ImatrixResponse, nil
+}
+
+func TestCreateImatrix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	mock := imatrixRunner{
+		ImatrixResponse: llm.ImatrixResponse{
+			Chunks: 1,
+			Values: map[string][]float32{"blk.0.ffn_up.weight": slices.Repeat([]float32{1}, 256)},
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{llama: &mock}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(1),
+		"llama.block_count":    uint32(1),
+	}, []ggml.Tensor{
+		{Name: "blk.0.attn_q.weight", Kind: 1, Shape: []uint64{4, 256}, WriterTo: bytes.NewReader(make([]byte, 4*256*2))},
+		{Name: "blk.0.ffn_up.weight", Kind: 1, Shape: []uint64{4, 256}, WriterTo: bytes.NewReader(make([]byte, 4*256*2))},
+	})
+
+	text := []byte("the quick brown fox jumps over the lazy dog")
+	calibration := fmt.Sprintf("sha256:%x", sha256.Sum256(text))
+	blob, err := GetBlobsPath(calibration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(blob, text, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("without quantize", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:    "test",
+			Files:   map[string]string{"test.gguf": digest},
+			Imatrix: calibration,
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:          "test",
+		Files:         map[string]string{"test.gguf": digest},
+		Quantize:      "q4_0",
+		QuantizeMixed: true,
+		Imatrix:       calibration,
+		Stream:        &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	if mock.Content != string(text) {
+		t.Errorf("calibration text = %q, want %q", mock.Content, text)
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := slices.IndexFunc(m.Layers, func(l Layer) bool { return l.MediaType == imatrixMediaType })
+	if i < 0 {
+		t.Fatal("manifest has no importance matrix layer")
+	}
+
+	imatrix, err := readImatrix(m.Layers[i].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imatrix.Chunks != 1 || imatrix.Dataset != calibration {
+		t.Errorf("imatrix chunks = %d, dataset = %s", imatrix.Chunks, imatrix.Dataset)
+	}
+
+	f, err := os.Open(filepath.Join(p, "blobs", strings.Replace(m.Layers[0].Digest, ":", "-", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tensor := range g.Tensors().Items() {
+		// mixed keeps attention weights at 8 bits
+		want := map[string]uint32{"blk.0.attn_q.weight": 8, "blk.0.ffn_up.weight": 2}[tensor.Name]
+		if tensor.Kind != want {
+			t.Errorf("%s: kind = %d, want %d", tensor.Name, tensor.Kind, want)
+		}
+	}
+}
+
+func TestCreateDoRAAdapter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+
+	_, digest := createBinFile(t, map[string]any{"general.architecture": "llama"}, nil)
+	_, adapter := createBinFile(t, map[string]any{
+		"general.architecture": "llama",
+		"general.type":         "adapter",
+		"adapter.type":         "lora",
+	}, []ggml.Tensor{
+		{Name: "blk.0.attn_output.weight.lora_a", Kind: 0, Shape: []uint64{2, 8}, WriterTo: bytes.NewReader(make([]byte, 2*8*4))},
+		{Name: "blk.0.attn_output.weight.lora_b", Kind: 0, Shape: []uint64{8, 2}, WriterTo: bytes.NewReader(make([]byte, 8*2*4))},
+		{Name: "blk.0.attn_output.weight.lora_m", Kind: 0, Shape: []uint64{8}, WriterTo: bytes.NewReader(make([]byte, 8*4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:     "test",
+		Files:    map[string]string{"test.gguf": digest},
+		Adapters: map[string]string{"adapter.gguf": adapter},
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), errDoRAUnsupported.Error()) {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+}
+
+func TestCreateMergeAdapters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+
+	f32s := func(x ...float32) *bytes.Buffer {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, x); err != nil {
+			t.Fatal(err)
+		}
+		return &b
+	}
+
+	_, digest := createBinFile(t, map[string]any{"general.architecture": "llama"}, []ggml.Tensor{
+		{Name: "blk.0.attn_q.weight", Kind: 0, Shape: []uint64{2, 2}, WriterTo: f32s(1, 2, 3, 4)},
+	})
+
+	adapter := func(a, b []float32) string {
+		_, digest := createBinFile(t, map[string]any{
+			"general.architecture": "llama",
+			"general.type":         "adapter",
+			"adapter.type":         "lora",
+			"adapter.lora.alpha":   float32(1),
+		}, []ggml.Tensor{
+			{Name: "blk.0.attn_q.weight.lora_a", Kind: 0, Shape: []uint64{1, 2}, WriterTo: f32s(a...)},
+			{Name: "blk.0.attn_q.weight.lora_b", Kind: 0, Shape: []uint64{2, 1}, WriterTo: f32s(b...)},
+		})
+		return digest
+	}
+
+	// updates are [[1, 0], [0, 0]] and [[0, 0], [0, 1]]
+	first, second := adapter([]float32{1, 0}, []float32{1, 0}), adapter([]float32{0, 1}, []float32{0, 1})
+
+	t.Run("bad options", func(t *testing.T) {
+		for _, r := range []api.CreateRequest{
+			{Name: "test", Files: map[string]string{"test.gguf": digest}, MergeMethod: "linear", Stream: &stream},
+			{Name: "test", Files: map[string]string{"test.gguf": digest}, AdapterWeights: []float32{1}, Stream: &stream},
+			{Name: "test", Files: map[string]string{"test.gguf": digest}, MergeAdapters: true, MergeMethod: "dare", Stream: &stream},
+			{Name: "test", Files: map[string]string{"test.gguf": digest}, MergeAdapters: true, Stream: &stream},
+			{
+				Name:           "test",
+				Files:          map[string]string{"test.gguf": digest},
+				Adapters:       map[string]string{"first.gguf": first},
+				MergeAdapters:  true,
+				AdapterWeights: []float32{1, 2},
+				Stream:         &stream,
+			},
+		} {
+			if w := createRequest(t, s.CreateHandler, r); w.Code != http.StatusBadRequest {
+				t.Errorf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+		}
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:           "test",
+		Files:          map[string]string{"test.gguf": digest},
+		Adapters:       map[string]string{"b.gguf": second, "a.gguf": first},
+		MergeAdapters:  true,
+		AdapterWeights: []float32{2, -1},
+		Stream:         &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var layer *Layer
+	for _, l := range m.Layers {
+		switch l.MediaType {
+		case "application/vnd.ollama.image.adapter":
+			t.Errorf("unexpected adapter layer %s", l.Digest)
+		case "application/vnd.ollama.image.model":
+			layer = &l
+		}
+	}
+
+	if layer == nil || layer.Digest == digest {
+		t.Fatalf("expected a new model layer, got %v", layer)
+	}
+
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tensor := g.Tensors().Items("blk.0.attn_q.weight")[0]
+	got := make([]float32, 4)
+	if err := binary.Read(io.NewSectionReader(f, int64(g.Tensors().Offset+tensor.Offset), int64(tensor.Size())), binary.LittleEndian, got); err != nil {
+		t.Fatal(err)
+	}
+
+	// adapters are weighted in the order of their file names
+	if want := []float32{1 + 2, 2, 3, 4 - 1}; !slices.Equal(got, want) {
+		t.Errorf("merged weight = %v, want %v", got, want)
+	}
+}
+
+func TestCreateMergeModels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+
+	f32s := func(x ...float32) *bytes.Buffer {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, x); err != nil {
+			t.Fatal(err)
+		}
+		return &b
+	}
+
+	create := func(name, arch string, blk0, blk1 []float32) {
+		_, digest := createBinFile(t, map[string]any{
+			"general.architecture": arch,
+			arch + ".block_count":  uint32(2),
+		}, []ggml.Tensor{
+			{Name: "blk.0.attn_q.weight", Kind: 0, Shape: []uint64{2}, WriterTo: f32s(blk0...)},
+			{Name: "blk.1.attn_q.weight", Kind: 0, Shape: []uint64{2}, WriterTo: f32s(blk1...)},
+		})
+
+		if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   name,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		}); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	create("a", "llama", []float32{1, 1}, []float32{1, 1})
+	create("b", "llama", []float32{3, 3}, []float32{5, 5})
+	create("base", "llama", []float32{1, 1}, []float32{1, 1})
+	create("gemma", "gemma", []float32{1, 1}, []float32{1, 1})
+
+	weights := func(name string) []float32 {
+		t.Helper()
+
+		m, err := ParseNamedManifest(model.ParseName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, layer := range m.Layers {
+			if layer.MediaType != "application/vnd.ollama.image.model" {
+				continue
+			}
+
+			blob, err := GetBlobsPath(layer.Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			g, _, err := ggml.Decode(f, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var x []float32
+			for _, tensor := range g.Tensors().Items() {
+				v := make([]float32, 2)
+				if err := binary.Read(io.NewSectionReader(f, int64(g.Tensors().Offset+tensor.Offset), int64(tensor.Size())), binary.LittleEndian, v); err != nil {
+					t.Fatal(err)
+				}
+				x = append(x, v...)
+			}
+			return x
+		}
+
+		t.Fatalf("%s has no model layer", name)
+		return nil
+	}
+
+	weight := func(w float32) *float32 { return &w }
+
+	cases := []struct {
+		name  string
+		merge api.MergeRequest
+		want  []float32
+	}{
+		{
+			name:  "linear",
+			merge: api.MergeRequest{Models: []api.MergeModel{{Model: "b"}}},
+			want:  []float32{2, 2, 3, 3},
+		},
+		{
+			name: "layers",
+			merge: api.MergeRequest{Models: []api.MergeModel{
+				{Model: "b", Weight: weight(0.25), Layers: []int{1, 1}},
+			}},
+			want: []float32{1, 1, 2, 2},
+		},
+		{
+			name: "task arithmetic",
+			merge: api.MergeRequest{
+				Method: "task_arithmetic",
+				Base:   "base",
+				Models: []api.MergeModel{{Model: "b", Weight: weight(0.5)}},
+			},
+			want: []float32{2, 2, 3, 3},
+		},
+		{
+			// b + 0.5(a - b) + 0.5(base - b)
+			name: "task arithmetic weighted",
+			merge: api.MergeRequest{
+				Method: "task_arithmetic",
+				Base:   "b",
+				Models: []api.MergeModel{
+					{Model: "a", Weight: weight(0.5)},
+					{Model: "base", Weight: weight(0.5)},
+				},
+			},
+			want: []float32{1, 1, 1, 1},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Name:   "merged",
+				From:   "a",
+				Merge:  &tt.merge,
+				Stream: &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			if got := weights("merged"); !slices.Equal(got, tt.want) {
+				t.Errorf("merged weights = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("bad requests", func(t *testing.T) {
+		for _, merge := range []api.MergeRequest{
+			{},
+			{Method: "ties", Models: []api.MergeModel{{Model: "b"}}},
+			{Method: "slerp", Models: []api.MergeModel{{Model: "b"}, {Model: "base"}}},
+			{Method: "task_arithmetic", Models: []api.MergeModel{{Model: "b"}}},
+			{Base: "base", Models: []api.MergeModel{{Model: "b"}}},
+			{Models: []api.MergeModel{{Model: "b", Layers: []int{2, 1}}}},
+			{Models: []api.MergeModel{{Model: "missing"}}},
+			{Models: []api.MergeModel{{Model: "gemma"}}},
+			{Method: "task_arithmetic", Base: "base", Models: []api.MergeModel{{Model: "a"}}},
+		} {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Name:   "merged",
+				From:   "a",
+				Merge:  &merge,
+				Stream: &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%+v: expected status code 400, actual %d: %s", merge, w.Code, w.Body.String())
+			}
+		}
+	})
+}
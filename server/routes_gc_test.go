@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/server/internal/cache/blob"
+)
+
+func TestGC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	for _, r := range []api.CreateRequest{
+		{Name: "test", Files: map[string]string{"test.gguf": digest}},
+		{Name: "test2", Files: map[string]string{"test.gguf": digest}, Template: "{{ .Prompt }}"},
+	} {
+		if w := createRequest(t, s.CreateHandler, r); w.Code != http.StatusOK {
+			t.Fatalf("create: %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	old := time.Now().Add(-2 * gcGracePeriod)
+	write := func(path, data string, mtime time.Time) string {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	orphan := "sha256-" + strings.Repeat("a", 64)
+	recent := "sha256-" + strings.Repeat("b", 64)
+	removed := []string{
+		write(filepath.Join(p, "blobs", orphan), "orphan", old),
+		write(filepath.Join(p, "blobs", recent+"-partial"), "partial", old),
+		write(filepath.Join(p, "blobs", recent+"-partial-0"), "{}", old),
+		write(filepath.Join(p, "chunksums", orphan+"-64"), "", old),
+	}
+	// a paused pull, whose resume state was written recently
+	paused := "sha256-" + strings.Repeat("c", 64)
+
+	// a pull in progress, waiting for its download window
+	c, err := blob.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := blob.DigestFromBytes("active")
+	chunked, err := c.Chunked(active, int64(len("active")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunked.Close()
+
+	kept := []string{
+		write(filepath.Join(p, "blobs", recent), "recent", time.Now()),
+		write(filepath.Join(p, "blobs", orphan+"-partial"), "partial", time.Now()),
+		write(filepath.Join(p, "blobs", paused+"-partial"), "partial", old),
+		write(filepath.Join(p, "blobs", paused+"-partial.json"), "{}", time.Now()),
+		write(c.GetFile(active)+"-partial", "", old),
+		write(c.GetFile(active)+"-partial.json", "{}", old),
+	}
+
+	gc := func(dryRun bool) api.GCResponse {
+		t.Helper()
+		w := createRequest(t, s.GCHandler, api.GCRequest{DryRun: dryRun})
+		if w.Code != http.StatusOK {
+			t.Fatalf("gc: %d %s", w.Code, w.Body.String())
+		}
+		var resp api.GCResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	exist := func(paths []string, want bool) {
+		t.Helper()
+		for _, path := range paths {
+			if _, err := os.Stat(path); (err == nil) != want {
+				t.Errorf("%s exists = %v; want %v", filepath.Base(path), err == nil, want)
+			}
+		}
+	}
+
+	resp := gc(true)
+	if resp.Blobs != 1 || resp.Partials != 3 {
+		t.Errorf("blobs, partials = %d, %d; want 1, 3", resp.Blobs, resp.Partials)
+	}
+	if want := int64(len("orphan") + len("partial") + len("{}")); resp.Reclaimable != want {
+		t.Errorf("reclaimable = %d; want %d", resp.Reclaimable, want)
+	}
+	exist(removed, true)
+
+	if len(resp.Models) != 2 {
+		t.Fatalf("models = %v; want 2", resp.Models)
+	}
+	for _, m := range resp.Models {
+		// the weights are shared, the configs are not
+		if m.Shared == 0 || m.Unique == 0 || m.Shared+m.Unique != m.Size {
+			t.Errorf("%s usage = %+v", m.Name, m)
+		}
+	}
+	if resp.Models[0].Name != "test2:latest" || resp.Models[0].Shared != resp.Models[1].Shared {
+		t.Errorf("models = %+v", resp.Models)
+	}
+
+	resp = gc(false)
+	if resp.Blobs != 1 || resp.Partials != 3 {
+		t.Errorf("blobs, partials = %d, %d; want 1, 3", resp.Blobs, resp.Partials)
+	}
+	exist(removed, false)
+	exist(kept, true)
+
+	// the models are untouched
+	if w := createRequest(t, s.ShowHandler, api.ShowRequest{Name: "test2"}); w.Code != http.StatusOK {
+		t.Errorf("show: %d %s", w.Code, w.Body.String())
+	}
+
+	if resp := gc(false); resp.Blobs != 0 || resp.Partials != 0 {
+		t.Errorf("second gc removed %d blobs and %d partials", resp.Blobs, resp.Partials)
+	}
+}
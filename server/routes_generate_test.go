@@ -731,6 +731,30 @@ func TestGenerate(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid adapter", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Adapter: "test",
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid adapter: \"test\" must have exactly one adapter"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Adapter: "missing",
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
 	t.Run("load model", func(t *testing.T) {
 		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
 			Model: "test",
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+// mockReranker tokenizes each word as a token, scores each document by its
+// value in scores, and records the inputs it scored
+type mockReranker struct {
+	mockRunner
+	scores map[string]float32
+
+	mu     sync.Mutex
+	words  []string // the word of each token
+	inputs [][]int
+}
+
+func (m *mockReranker) Tokenize(_ context.Context, s string) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []int
+	for _, w := range strings.Fields(s) {
+		t := slices.Index(m.words, w)
+		if t < 0 {
+			t = len(m.words)
+			m.words = append(m.words, w)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+func (m *mockReranker) EmbeddingTokens(_ context.Context, tokens []int) ([]float32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inputs = append(m.inputs, tokens)
+
+	// [CLS] query [SEP] document [SEP]
+	var doc []string
+	for _, t := range tokens[slices.Index(tokens, 2)+1 : len(tokens)-1] {
+		doc = append(doc, m.words[t])
+	}
+	return []float32{m.scores[strings.Join(doc, " ")]}, nil
+}
+
+func TestRerank(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockReranker{
+		scores: map[string]float32{"a": 0.1, "b": 0.9, "c": 0.5},
+		words:  []string{"[PAD]", "[CLS]", "[SEP]"},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn: func(discover.GpuInfoList, string, *ggml.GGML, []string, []string, api.Options, int) (llm.LlamaServer, error) {
+				return &mock, nil
+			},
+			getGpuFn:     discover.GetGPUInfo,
+			getCpuFn:     discover.GetCPUInfo,
+			reschedDelay: 250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{llama: &mock}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	create := func(name string, pooling uint32) {
+		t.Helper()
+		_, digest := createBinFile(t, ggml.KV{
+			"general.architecture":              "bert",
+			"bert.block_count":                  uint32(1),
+			"bert.context_length":               uint32(512),
+			"bert.embedding_length":             uint32(4),
+			"bert.attention.head_count":         uint32(1),
+			"bert.pooling_type":                 pooling,
+			"tokenizer.ggml.tokens":             []string{"[PAD]", "[CLS]", "[SEP]"},
+			"tokenizer.ggml.token_type":         []int32{3, 3, 3},
+			"tokenizer.ggml.cls_token_id":       uint32(1),
+			"tokenizer.ggml.seperator_token_id": uint32(2),
+		}, nil)
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model: name,
+			Files: map[string]string{"file.gguf": digest},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	create("reranker", 4)
+	create("embedder", 1)
+
+	rerank := func(req api.RerankRequest) ([]api.RerankResult, int) {
+		t.Helper()
+		w := createRequest(t, s.RerankHandler, req)
+		if w.Code != http.StatusOK {
+			return nil, w.Code
+		}
+
+		var resp api.RerankResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Results, w.Code
+	}
+
+	documents := []string{"a", "b", "c"}
+	all := []api.RerankResult{
+		{Index: 1, Document: "b", RelevanceScore: 0.9},
+		{Index: 2, Document: "c", RelevanceScore: 0.5},
+		{Index: 0, Document: "a", RelevanceScore: 0.1},
+	}
+
+	t.Run("order", func(t *testing.T) {
+		results, code := rerank(api.RerankRequest{Model: "reranker", Query: "q", Documents: documents})
+		if code != http.StatusOK {
+			t.Fatalf("status = %d", code)
+		}
+		if diff := cmp.Diff(all, results); diff != "" {
+			t.Errorf("results mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("top_n", func(t *testing.T) {
+		for _, tt := range []struct {
+			topN int
+			want []api.RerankResult
+		}{
+			{0, all},
+			{2, all[:2]},
+			{5, all},
+		} {
+			results, code := rerank(api.RerankRequest{Model: "reranker", Query: "q", Documents: documents, TopN: tt.topN})
+			if code != http.StatusOK {
+				t.Fatalf("top_n %d: status = %d", tt.topN, code)
+			}
+			if diff := cmp.Diff(tt.want, results); diff != "" {
+				t.Errorf("top_n %d: results mismatch (-want +got):\n%s", tt.topN, diff)
+			}
+		}
+
+		if _, code := rerank(api.RerankRequest{Model: "reranker", Query: "q", Documents: documents, TopN: -1}); code != http.StatusBadRequest {
+			t.Errorf("negative top_n: status = %d; want 400", code)
+		}
+	})
+
+	t.Run("capability", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{Model: "embedder", Query: "q", Documents: documents})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "does not support rerank") {
+			t.Errorf("status = %d, body = %s; want 400 for the missing capability", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		mock.inputs = nil
+
+		document := strings.Repeat("word ", 8)
+		if _, code := rerank(api.RerankRequest{Model: "reranker", Query: "q", Documents: []string{document}, Options: map[string]any{"num_ctx": 8}}); code != http.StatusOK {
+			t.Fatalf("status = %d", code)
+		}
+
+		// only the document is truncated, leaving room for the query
+		// and both separators
+		q, _ := mock.Tokenize(t.Context(), "q")
+		word, _ := mock.Tokenize(t.Context(), "word")
+		want := [][]int{{1, q[0], 2, word[0], word[0], word[0], word[0], 2}}
+		if diff := cmp.Diff(want, mock.inputs); diff != "" {
+			t.Errorf("inputs mismatch (-want +got):\n%s", diff)
+		}
+
+		truncate := false
+		if _, code := rerank(api.RerankRequest{Model: "reranker", Query: "q", Documents: []string{document}, Truncate: &truncate, Options: map[string]any{"num_ctx": 8}}); code != http.StatusBadRequest {
+			t.Errorf("status = %d; want 400 without truncation", code)
+		}
+
+		if _, code := rerank(api.RerankRequest{Model: "reranker", Query: "q q", Documents: []string{document}, Options: map[string]any{"num_ctx": 4}}); code != http.StatusBadRequest {
+			t.Errorf("status = %d; want 400 for a query longer than the context", code)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestVerify(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	writeKey(t, home)
+
+	addr := startRegistry(t, RegistryConfig{Dir: t.TempDir()})
+	name := addr + "/library/test:latest"
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  name,
+		Files: map[string]string{"test.gguf": digest},
+	}); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	if err := PushModel(t.Context(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a model whose weights are not a model file
+	weights, err := NewLayer(strings.NewReader("not a model"), "application/vnd.ollama.image.model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewLayer(strings.NewReader("{}"), "application/vnd.docker.container.image.v1+json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteManifest(model.ParseName("invalid"), config, []Layer{weights}); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(req api.VerifyRequest) []api.VerifyProblem {
+		t.Helper()
+		stream := false
+		req.Stream = &stream
+		w := createRequest(t, s.VerifyHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("verify: %d %s", w.Code, w.Body.String())
+		}
+		var resp api.VerifyResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Problems
+	}
+
+	problems := verify(api.VerifyRequest{})
+	if len(problems) != 1 || problems[0].Model != "invalid:latest" || !strings.Contains(problems[0].Error, "invalid model file") {
+		t.Fatalf("problems = %+v; want invalid model file", problems)
+	}
+
+	if problems := verify(api.VerifyRequest{Models: []string{name}}); len(problems) != 0 {
+		t.Fatalf("problems = %+v; want none", problems)
+	}
+
+	// move the tag upstream to another manifest, which the repair must not
+	// replace the local model with
+	mf, err := manifestFilepath(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := os.ReadFile(mf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OLLAMA_NOPRUNE", "1") // keep the blobs of the local manifest
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   name,
+		From:   name,
+		System: "moved",
+	}); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	if err := PushModel(t.Context(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mf, manifest, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the weights without changing their size
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if err := os.WriteFile(p, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems = verify(api.VerifyRequest{Models: []string{name}})
+	if len(problems) != 1 || problems[0].Digest != digest || problems[0].Repaired {
+		t.Fatalf("problems = %+v; want digest mismatch of %s", problems, digest)
+	}
+
+	problems = verify(api.VerifyRequest{Models: []string{name}, Repair: true, Insecure: true})
+	if len(problems) != 1 || !problems[0].Repaired {
+		t.Fatalf("problems = %+v; want repaired", problems)
+	}
+	if got, err := os.ReadFile(p); err != nil || string(got) != string(data) {
+		t.Errorf("blob not restored: %v", err)
+	}
+	if got, err := os.ReadFile(mf); err != nil || string(got) != string(manifest) {
+		t.Errorf("manifest replaced by the repair: %v", err)
+	}
+
+	// files that can't be pulled again are left in place
+	problems = verify(api.VerifyRequest{Models: []string{"invalid"}, Repair: true, Insecure: true})
+	if len(problems) != 1 || problems[0].Repaired || !strings.Contains(problems[0].Error, "not repaired") {
+		t.Fatalf("problems = %+v; want not repaired", problems)
+	}
+	if _, err := os.Stat(mustBlobPath(t, weights.Digest)); err != nil {
+		t.Errorf("invalid model file removed: %v", err)
+	}
+
+	local := addr + "/library/local:latest"
+	_, localDigest := createBinFile(t, map[string]any{"general.name": "local"}, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  local,
+		Files: map[string]string{"test.gguf": localDigest},
+	}); w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	if err := os.WriteFile(mustBlobPath(t, localDigest), corrupt[:len(corrupt)-1], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems = verify(api.VerifyRequest{Models: []string{local}, Repair: true, Insecure: true})
+	if len(problems) != 1 || problems[0].Repaired || !strings.Contains(problems[0].Error, "not repaired") {
+		t.Fatalf("problems = %+v; want not repaired", problems)
+	}
+	if _, err := os.Stat(mustBlobPath(t, localDigest)); err != nil {
+		t.Errorf("locally created model file removed: %v", err)
+	}
+
+	if w := createRequest(t, s.VerifyHandler, api.VerifyRequest{Models: []string{"unknown"}}); w.Code != http.StatusNotFound {
+		t.Errorf("verify unknown: %d; want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func mustBlobPath(t *testing.T, digest string) string {
+	t.Helper()
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -138,6 +139,12 @@ func (s *Scheduler) processPending(ctx context.Context) {
 				slog.Warn("mllama doesn't support parallel requests yet")
 			}
 
+			// the encoder cache of whisper only holds a single sequence
+			if slices.Contains(pending.model.Config.ModelFamilies, "whisper") && numParallel != 1 {
+				numParallel = 1
+				slog.Warn("whisper doesn't support parallel requests yet")
+			}
+
 			for {
 				var runnerToExpire *runnerRef
 				s.loadedMu.Lock()
@@ -439,6 +446,7 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 		gpus:            gpus,
 		estimatedVRAM:   llama.EstimatedVRAM(),
 		estimatedTotal:  llama.EstimatedTotal(),
+		adapters:        slices.Clone(req.model.AdapterPaths),
 		loading:         true,
 		refCount:        1,
 	}
@@ -535,6 +543,10 @@ func (s *Scheduler) filterGPUsWithoutLoadingModels(allGpus discover.GpuInfoList)
 }
 
 // TODO consolidate sched_types.go
+// maxSwappedAdapters is the number of adapters a runner that swaps adapters
+// per request keeps loaded before it's reloaded
+var maxSwappedAdapters = 4
+
 type runnerRef struct {
 	refMu sync.Mutex
 	// refCond   sync.Cond // Signaled on transition from 1 -> 0 refCount
@@ -547,6 +559,10 @@ type runnerRef struct {
 	estimatedVRAM  uint64
 	estimatedTotal uint64
 
+	// adapters are the paths of the adapters loaded by the runner, including
+	// those loaded on demand by runners that swap adapters per request
+	adapters []string
+
 	sessionDuration time.Duration
 	expireTimer     *time.Timer
 	expiresAt       time.Time
@@ -599,16 +615,67 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	if !reflect.DeepEqual(runner.model.AdapterPaths, req.model.AdapterPaths) || // have the adapters changed?
+	if runner.adaptersChanged(req) ||
 		!reflect.DeepEqual(runner.model.ProjectorPaths, req.model.ProjectorPaths) || // have the projectors changed?
 		!reflect.DeepEqual(optsExisting, optsNew) || // have the runner options changed?
 		runner.llama.Ping(ctx) != nil {
 		return true
 	}
 
+	runner.addAdapter(req)
 	return false
 }
 
+// adaptersChanged reports whether the runner must be reloaded to use the
+// adapters of req. Runners that apply an adapter per request can switch
+// between the base model and any single adapter without reloading.
+func (runner *runnerRef) adaptersChanged(req *LlmRequest) bool {
+	if reflect.DeepEqual(runner.model.AdapterPaths, req.model.AdapterPaths) {
+		return false
+	}
+
+	if len(runner.model.AdapterPaths) > 1 || len(req.model.AdapterPaths) > 1 {
+		return true
+	}
+
+	if !runner.llama.SwapsAdapters() {
+		return true
+	}
+
+	// adapters stay loaded until the runner is, so it's reloaded to free
+	// them once too many have been used
+	if len(req.model.AdapterPaths) == 0 || slices.Contains(runner.adapters, req.model.AdapterPaths[0]) {
+		return false
+	}
+
+	return len(runner.adapters) >= maxSwappedAdapters
+}
+
+// addAdapter records the adapter of req, which a runner that swaps adapters
+// loads when it's first used, and adds its size to the estimates of the
+// runner. Adapters are loaded next to the weights they update so they are
+// assumed to be split between the GPUs and system memory like the model.
+func (runner *runnerRef) addAdapter(req *LlmRequest) {
+	if len(req.model.AdapterPaths) != 1 || slices.Contains(runner.adapters, req.model.AdapterPaths[0]) {
+		return
+	}
+
+	path := req.model.AdapterPaths[0]
+	runner.adapters = append(runner.adapters, path)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("couldn't estimate adapter size", "adapter", path, "error", err)
+		return
+	}
+
+	size := uint64(fi.Size())
+	if runner.estimatedTotal > 0 {
+		runner.estimatedVRAM += uint64(float64(size) * float64(runner.estimatedVRAM) / float64(runner.estimatedTotal))
+	}
+	runner.estimatedTotal += size
+}
+
 // Free memory reporting on GPUs can lag for a while even after the runner
 // exits, so we have to keep checking until we see the available memory recover,
 // otherwise subsequent model loads will get far less layers loaded or worse
@@ -825,7 +892,7 @@ func (s *Scheduler) expireRunner(model *Model) {
 // If not, pick a runner to unload, else return nil and the request can be loaded
 func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList) *runnerRef {
 	slog.Debug("evaluating if CPU model load will fit in available system memory")
-	estimate := llm.EstimateGPULayers(gpus, f, req.model.ProjectorPaths, req.opts)
+	estimate := llm.EstimateGPULayers(gpus, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts)
 	if estimate.TotalSize <= gpus[0].FreeMemory {
 		slog.Debug("cpu inference mode, model fits in available system memory", "model", format.HumanBytes2(estimate.TotalSize), "available", format.HumanBytes2(gpus[0].FreeMemory))
 		return nil
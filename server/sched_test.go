@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -662,6 +664,74 @@ func TestNeedsReload(t *testing.T) {
 	require.False(t, resp)
 }
 
+func TestNeedsReloadAdapters(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	llm := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}
+	do := api.DefaultOptions()
+	runner := &runnerRef{
+		model:       &Model{AdapterPaths: []string{"adapter1"}},
+		Options:     &do,
+		llama:       llm,
+		numParallel: 1,
+	}
+	req := &LlmRequest{
+		model: &Model{AdapterPaths: []string{"adapter2"}},
+		opts:  api.DefaultOptions(),
+	}
+	require.True(t, runner.needsReload(ctx, req))
+
+	llm.swapsAdapters = true
+	require.False(t, runner.needsReload(ctx, req))
+
+	req.model.AdapterPaths = nil
+	require.False(t, runner.needsReload(ctx, req))
+
+	req.model.AdapterPaths = []string{"adapter1", "adapter2"}
+	require.True(t, runner.needsReload(ctx, req))
+}
+
+func TestNeedsReloadAdapterLimit(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	dir := t.TempDir()
+	adapter := func(i int) string {
+		p := filepath.Join(dir, fmt.Sprintf("adapter%d", i))
+		require.NoError(t, os.WriteFile(p, make([]byte, 100), 0o644))
+		return p
+	}
+
+	do := api.DefaultOptions()
+	runner := &runnerRef{
+		model:          &Model{},
+		Options:        &do,
+		llama:          &mockLlm{swapsAdapters: true},
+		numParallel:    1,
+		estimatedVRAM:  500,
+		estimatedTotal: 1000,
+	}
+
+	for i := range maxSwappedAdapters {
+		req := &LlmRequest{model: &Model{AdapterPaths: []string{adapter(i)}}, opts: api.DefaultOptions()}
+		require.False(t, runner.needsReload(ctx, req))
+		require.False(t, runner.needsReload(ctx, req))
+	}
+
+	// the adapters loaded are added to the estimates
+	require.Len(t, runner.adapters, maxSwappedAdapters)
+	require.Equal(t, uint64(1000+100*maxSwappedAdapters), runner.estimatedTotal)
+	require.Greater(t, runner.estimatedVRAM, uint64(500))
+
+	// adapters already loaded and the base model are still used
+	require.False(t, runner.needsReload(ctx, &LlmRequest{model: &Model{AdapterPaths: []string{adapter(0)}}, opts: api.DefaultOptions()}))
+	require.False(t, runner.needsReload(ctx, &LlmRequest{model: &Model{}, opts: api.DefaultOptions()}))
+
+	// one more adapter reloads the runner to free the others
+	require.True(t, runner.needsReload(ctx, &LlmRequest{model: &Model{AdapterPaths: []string{adapter(maxSwappedAdapters)}}, opts: api.DefaultOptions()}))
+}
+
 func TestUnloadAllRunners(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer done()
@@ -756,6 +826,8 @@ type mockLlm struct {
 	completionResp     error
 	embeddingResp      []float32
 	embeddingRespErr   error
+	imatrixResp        *llm.ImatrixResponse
+	imatrixErr         error
 	tokenizeResp       []int
 	tokenizeRespErr    error
 	detokenizeResp     string
@@ -765,6 +837,7 @@ type mockLlm struct {
 	estimatedVRAM      uint64
 	estimatedTotal     uint64
 	estimatedVRAMByGPU map[string]uint64
+	swapsAdapters      bool
 }
 
 func (s *mockLlm) Ping(ctx context.Context) error             { return s.pingResp }
@@ -777,6 +850,14 @@ func (s *mockLlm) Embedding(ctx context.Context, input string) ([]float32, error
 	return s.embeddingResp, s.embeddingRespErr
 }
 
+func (s *mockLlm) EmbeddingTokens(ctx context.Context, tokens []int) ([]float32, error) {
+	return s.embeddingResp, s.embeddingRespErr
+}
+
+func (s *mockLlm) Imatrix(ctx context.Context, req llm.ImatrixRequest) (*llm.ImatrixResponse, error) {
+	return s.imatrixResp, s.imatrixErr
+}
+
 func (s *mockLlm) Tokenize(ctx context.Context, content string) ([]int, error) {
 	return s.tokenizeResp, s.tokenizeRespErr
 }
@@ -792,3 +873,4 @@ func (s *mockLlm) Close() error {
 func (s *mockLlm) EstimatedVRAM() uint64                  { return s.estimatedVRAM }
 func (s *mockLlm) EstimatedTotal() uint64                 { return s.estimatedTotal }
 func (s *mockLlm) EstimatedVRAMByGPU(gpuid string) uint64 { return s.estimatedVRAMByGPU[gpuid] }
+func (s *mockLlm) SwapsAdapters() bool                    { return s.swapsAdapters }
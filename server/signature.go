@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/auth"
+	"github.com/ollama/ollama/server/internal/registry"
+)
+
+// Signatures of manifests are pushed next to them as OCI artifacts that
+// refer to the signed manifest as their subject. Since not every registry
+// implements the referrers API, the artifact is tagged with the digest of the
+// signed manifest, e.g. sha256-<hex>.sig, where pulls look for it.
+const (
+	mediaTypeSignature   = "application/vnd.ollama.signature.v1+json"
+	mediaTypeEmpty       = "application/vnd.oci.empty.v1+json"
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+
+	maxSignatureSize = 64 << 10
+
+	// maxSignatures is the number of signatures, one per tag, kept for a
+	// manifest.
+	maxSignatures = 64
+)
+
+var (
+	errModelUnsigned  = errors.New("model is not signed")
+	errModelUntrusted = errors.New("model is not signed by a trusted key")
+)
+
+// signature is the content of a signature blob.
+type signature struct {
+	// Repository, Tag and Digest are the namespace/model repository, the
+	// tag and the digest of the signed manifest. All are signed, so a
+	// signature cannot be moved to another model or tag.
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+
+	// Key is the public key of the signer in authorized_keys format.
+	Key       string `json:"key"`
+	Signature []byte `json:"signature"`
+}
+
+// signatureManifest is the manifest of a signature artifact.
+type signatureManifest struct {
+	SchemaVersion int     `json:"schemaVersion"`
+	MediaType     string  `json:"mediaType"`
+	ArtifactType  string  `json:"artifactType"`
+	Config        Layer   `json:"config"`
+	Layers        []Layer `json:"layers"`
+	Subject       *Layer  `json:"subject,omitempty"`
+}
+
+// signedData returns the data signed for the manifest with digest tagged tag
+// in repository.
+func signedData(repository, tag, digest string) []byte {
+	return fmt.Appendf(nil, "ollama-manifest-signature-v2\n%s\n%s\n%s\n", repository, tag, digest)
+}
+
+// sign signs the manifest with digest tagged tag in repository with the key
+// of the server.
+func sign(ctx context.Context, repository, tag, digest string) (*signature, error) {
+	// Sign returns <base64 public key>:<base64 signature>
+	s, err := auth.Sign(ctx, signedData(repository, tag, digest))
+	if err != nil {
+		return nil, err
+	}
+	pub, sig, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, errors.New("malformed signature")
+	}
+	pubBytes, err := base64.StdEncoding.DecodeString(pub)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParsePublicKey(pubBytes)
+	if err != nil {
+		return nil, err
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signature{
+		Repository: repository,
+		Tag:        tag,
+		Digest:     digest,
+		Key:        strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Signature:  sigBytes,
+	}, nil
+}
+
+// verify reports whether s is a valid signature by one of the trusted keys
+// of the manifest with digest tagged tag in repository.
+func (s *signature) verify(trusted []ssh.PublicKey, repository, tag, digest string) bool {
+	if s.Repository != repository || s.Tag != tag || s.Digest != digest {
+		return false
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s.Key))
+	if err != nil {
+		return false
+	}
+
+	data := signedData(repository, tag, digest)
+	for _, k := range trusted {
+		if !bytes.Equal(k.Marshal(), key.Marshal()) {
+			continue
+		}
+		if err := k.Verify(data, &ssh.Signature{Format: k.Type(), Blob: s.Signature}); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// signatureTag returns the tag the signatures of the manifest with digest are
+// pushed to.
+func signatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// pushSignature signs the manifest data, pushed to mp with mediaType, with the
+// key of the server, and pushes the signature.
+func pushSignature(ctx context.Context, mp ModelPath, data []byte, mediaType string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
+	fn(api.ProgressResponse{Status: "signing manifest"})
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	repository := mp.GetNamespaceRepository()
+
+	sig, err := sign(ctx, repository, mp.Tag, digest)
+	if err != nil {
+		return fmt.Errorf("signing manifest: %w", err)
+	}
+	sigJSON, err := json.Marshal(sig)
+	if err != nil {
+		return err
+	}
+
+	layer, err := NewLayer(bytes.NewReader(sigJSON), mediaTypeSignature)
+	if err != nil {
+		return err
+	}
+	config, err := NewLayer(strings.NewReader("{}"), mediaTypeEmpty)
+	if err != nil {
+		return err
+	}
+	for _, l := range []Layer{layer, config} {
+		if err := uploadBlob(ctx, mp, l, regOpts, fn); err != nil {
+			return err
+		}
+	}
+
+	// the signatures of the other tags of the manifest are kept, so that
+	// pushing it under a new tag doesn't invalidate them
+	layers := []Layer{layer}
+	for _, l := range pushedSignatures(ctx, mp, digest, regOpts) {
+		if l.Digest != layer.Digest && len(layers) < maxSignatures {
+			layers = append(layers, l)
+		}
+	}
+
+	manifestJSON, err := json.Marshal(signatureManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		ArtifactType:  mediaTypeSignature,
+		Config:        config,
+		Layers:        layers,
+		Subject:       &Layer{MediaType: mediaType, Digest: digest, Size: int64(len(data))},
+	})
+	if err != nil {
+		return err
+	}
+
+	fn(api.ProgressResponse{Status: "pushing signature"})
+	requestURL := mp.BaseURL().JoinPath("v2", repository, "manifests", signatureTag(digest))
+	headers := make(http.Header)
+	headers.Set("Content-Type", mediaTypeOCIManifest)
+	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(manifestJSON), regOpts)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// pushedSignatures returns the signature layers of the manifest with digest
+// already pushed to mp, or nil if it has none or they can't be fetched.
+func pushedSignatures(ctx context.Context, mp ModelPath, digest string, regOpts *registryOptions) []Layer {
+	m, err := pullSignatureManifest(ctx, mp.BaseURL(), mp, digest, regOpts)
+	if err != nil {
+		return nil
+	}
+
+	var layers []Layer
+	for _, l := range m.Layers {
+		if l.MediaType == mediaTypeSignature && l.Size <= maxSignatureSize {
+			layers = append(layers, l)
+		}
+	}
+	return layers
+}
+
+// pullSignatureManifest fetches the manifest of the signatures of the
+// manifest with digest in mp from the registry at base.
+func pullSignatureManifest(ctx context.Context, base *url.URL, mp ModelPath, digest string, regOpts *registryOptions) (*signatureManifest, error) {
+	requestURL := base.JoinPath("v2", mp.GetNamespaceRepository(), "manifests", signatureTag(digest))
+	headers := make(http.Header)
+	headers.Set("Accept", mediaTypeOCIManifest)
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errModelUnsigned
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var m signatureManifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSignatureSize)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid signature manifest: %w", err)
+	}
+	if m.Subject == nil || m.Subject.Digest != digest {
+		return nil, errors.New("invalid signature manifest: subject does not match the model")
+	}
+	return &m, nil
+}
+
+// verifyManifestSignature checks the manifest with digest pulled from mp is
+// signed by one of the keys in path, the OLLAMA_TRUSTED_KEYS file, for the
+// tag of mp. It returns errModelUnsigned if the manifest has no signatures,
+// and errModelUntrusted if none of its signatures are valid signatures by a
+// trusted key.
+func verifyManifestSignature(ctx context.Context, mp ModelPath, digest, path string, regOpts *registryOptions) error {
+	trusted, err := registry.ReadAuthorizedKeys(path)
+	if err != nil {
+		return fmt.Errorf("reading trusted keys: %w", err)
+	}
+
+	m, err := pullSignatureManifest(ctx, mp.PullURL(), mp, digest, regOpts)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range m.Layers {
+		if l.MediaType != mediaTypeSignature || l.Size > maxSignatureSize {
+			continue
+		}
+
+		s, err := pullSignature(ctx, mp, l, regOpts)
+		if err != nil {
+			return err
+		}
+		if s.verify(trusted, mp.GetNamespaceRepository(), mp.Tag, digest) {
+			return nil
+		}
+	}
+	return errModelUntrusted
+}
+
+// pullSignature fetches and decodes the signature blob l from mp.
+func pullSignature(ctx context.Context, mp ModelPath, l Layer, regOpts *registryOptions) (*signature, error) {
+	requestURL := mp.PullURL().JoinPath("v2", mp.GetNamespaceRepository(), "blobs", l.Digest)
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, nil, nil, regOpts)
+	if err != nil {
+		return nil, fmt.Errorf("pulling signature: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return nil, fmt.Errorf("pulling signature: %w", err)
+	}
+	if fmt.Sprintf("sha256:%x", sha256.Sum256(data)) != l.Digest {
+		return nil, fmt.Errorf("pulling signature: %w", errDigestMismatch)
+	}
+
+	var s signature
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	return &s, nil
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// writeKey writes a new key to $HOME/.ollama/id_ed25519 in home, and
+// returns its public key.
+func writeKey(t *testing.T, home string) ssh.PublicKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(home, ".ollama"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, ".ollama", "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sshPub
+}
+
+func TestSignature(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	key := writeKey(t, home)
+	addr := startRegistry(t, RegistryConfig{Dir: t.TempDir()})
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	for _, name := range []string{"signed:latest", "signed:other", "signed:v2", "unsigned:latest"} {
+		name = addr + "/library/" + name
+		if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:  name,
+			Files: map[string]string{"test.gguf": digest},
+		}); w.Code != http.StatusOK {
+			t.Fatalf("create: %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	if err := PushModel(t.Context(), addr+"/library/signed:latest", &registryOptions{Insecure: true, Sign: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := PushModel(t.Context(), addr+"/library/unsigned:latest", &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same manifest pushed under other tags, only signed for one of them
+	if err := PushModel(t.Context(), addr+"/library/signed:other", &registryOptions{Insecure: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := PushModel(t.Context(), addr+"/library/signed:v2", &registryOptions{Insecure: true, Sign: true}, func(api.ProgressResponse) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	trusted := filepath.Join(t.TempDir(), "trusted_keys")
+	if err := os.WriteFile(trusted, ssh.MarshalAuthorizedKey(key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	untrusted := filepath.Join(t.TempDir(), "trusted_keys")
+	if err := os.WriteFile(untrusted, ssh.MarshalAuthorizedKey(writeKey(t, t.TempDir())), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		keys string
+		want error
+	}{
+		{"signed:latest", "", nil},
+		{"unsigned:latest", "", nil},
+		{"signed:latest", trusted, nil},
+		{"signed:v2", trusted, nil},
+		{"signed:other", trusted, errModelUntrusted},
+		{"unsigned:latest", trusted, errModelUnsigned},
+		{"signed:latest", untrusted, errModelUntrusted},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_TRUSTED_KEYS", tt.keys)
+			t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+			name := addr + "/library/" + tt.name
+			err := PullModel(t.Context(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v; want %v", err, tt.want)
+			}
+
+			// refused models are not pulled
+			_, err = ParseNamedManifest(model.ParseName(name))
+			if tt.want != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("manifest err = %v; want %v", err, os.ErrNotExist)
+			}
+			if tt.want == nil && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/model/audioproc"
+	"github.com/ollama/ollama/model/models/whisper"
+)
+
+const (
+	// maxTranscribeSize is the size of the largest audio file accepted for
+	// transcription. The request body can be larger since the audio is base64
+	// encoded.
+	maxTranscribeSize = 25 << 20
+
+	// maxTranscribeDuration is the length of the longest audio accepted for
+	// transcription. Audio is decoded in memory so this bounds the memory
+	// used by compressed audio, which can expand to many more samples.
+	maxTranscribeDuration = 30 * time.Minute
+)
+
+// transcribeChunk is a piece of audio short enough for the encoder along with
+// its offset from the start of the clip, in seconds
+type transcribeChunk struct {
+	Data   []byte
+	Offset float64
+	Length float64
+}
+
+// splitAudio decodes audio and splits it into WAV files of at most
+// whisper.ChunkLength seconds. It also returns the duration of the audio.
+// Audio longer than maxTranscribeDuration is rejected with
+// audioproc.ErrTooLong.
+func splitAudio(bts []byte) ([]transcribeChunk, float64, error) {
+	samples, sampleRate, err := audioproc.DecodeMax(bytes.NewReader(bts), maxTranscribeDuration)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	samples = audioproc.Resample(samples, sampleRate, whisper.SampleRate)
+
+	var chunks []transcribeChunk
+	for i, chunk := range slices.Collect(slices.Chunk(samples, whisper.ChunkLength*whisper.SampleRate)) {
+		var b bytes.Buffer
+		if err := audioproc.EncodeWAV(&b, chunk, whisper.SampleRate); err != nil {
+			return nil, 0, err
+		}
+
+		chunks = append(chunks, transcribeChunk{
+			Data:   b.Bytes(),
+			Offset: float64(i * whisper.ChunkLength),
+			Length: float64(len(chunk)) / whisper.SampleRate,
+		})
+	}
+
+	return chunks, float64(len(samples)) / whisper.SampleRate, nil
+}
+
+// transcribePrompt builds the decoder prompt for a chunk of audio. previous is
+// text conditioning the transcription, such as the transcription of the
+// previous chunk. Without a language the model emits the language and task
+// tokens itself.
+func transcribePrompt(previous, language string, timestamps bool) string {
+	var sb strings.Builder
+	sb.WriteString("[audio-0]")
+	if previous != "" {
+		sb.WriteString("<|startofprev|> ")
+		sb.WriteString(strings.TrimSpace(previous))
+	}
+
+	sb.WriteString("<|startoftranscript|>")
+	if language != "" {
+		sb.WriteString("<|" + language + "|><|transcribe|>")
+		if !timestamps {
+			sb.WriteString("<|notimestamps|>")
+		}
+	}
+
+	return sb.String()
+}
+
+var transcribeSpecialRE = regexp.MustCompile(`<\|([^|]*)\|>`)
+
+// parseTranscription splits the output of the decoder into segments using the
+// timestamp tokens, offset by the position of the chunk in the audio. Output
+// without timestamps becomes a single segment covering the chunk. It also
+// returns the language token emitted by the model, if any.
+func parseTranscription(s string, chunk transcribeChunk) (string, []api.TranscribeSegment) {
+	var language string
+	var segments []api.TranscribeSegment
+
+	start := -1.0
+	var sb strings.Builder
+	flush := func(end float64) {
+		if text := strings.TrimSpace(sb.String()); text != "" {
+			segments = append(segments, api.TranscribeSegment{
+				Start: chunk.Offset + max(start, 0),
+				End:   chunk.Offset + min(max(end, start), chunk.Length),
+				Text:  text,
+			})
+		}
+
+		sb.Reset()
+	}
+
+	last := 0
+	for _, match := range transcribeSpecialRE.FindAllStringSubmatchIndex(s, -1) {
+		sb.WriteString(s[last:match[0]])
+		last = match[1]
+
+		token := s[match[2]:match[3]]
+		if t, err := strconv.ParseFloat(token, 64); err == nil {
+			if start < 0 || sb.Len() == 0 {
+				// the first timestamp of a pair starts a segment
+				start = t
+				sb.Reset()
+				continue
+			}
+
+			flush(t)
+			start = -1
+			continue
+		}
+
+		if language == "" && isLanguageToken(token) {
+			language = token
+		}
+	}
+
+	sb.WriteString(s[last:])
+	flush(chunk.Length)
+
+	return language, segments
+}
+
+// isLanguageToken reports whether token looks like one of the language codes
+// in the Whisper vocabulary, e.g. "en", "haw" or "yue"
+func isLanguageToken(token string) bool {
+	if len(token) < 2 || len(token) > 3 {
+		return false
+	}
+
+	for _, r := range token {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+
+	return true
+}
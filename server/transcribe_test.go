@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/model/audioproc"
+)
+
+func TestSplitAudio(t *testing.T) {
+	var b bytes.Buffer
+	if err := audioproc.EncodeWAV(&b, make([]float32, 16000*45), 16000); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, duration, err := splitAudio(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if duration != 45 {
+		t.Errorf("duration = %v, want 45", duration)
+	}
+
+	if len(chunks) != 2 {
+		t.Fatalf("chunks = %d, want 2", len(chunks))
+	}
+
+	if chunks[1].Offset != 30 || chunks[1].Length != 15 {
+		t.Errorf("chunk = %+v, want offset 30 and length 15", chunks[1])
+	}
+
+	samples, _, err := audioproc.Decode(bytes.NewReader(chunks[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(samples) != 16000*30 {
+		t.Errorf("samples = %d, want %d", len(samples), 16000*30)
+	}
+}
+
+func TestSplitAudioTooLong(t *testing.T) {
+	// a low sample rate keeps the file small
+	var b bytes.Buffer
+	if err := audioproc.EncodeWAV(&b, make([]float32, int(maxTranscribeDuration.Seconds())*100+1), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := splitAudio(b.Bytes()); !errors.Is(err, audioproc.ErrTooLong) {
+		t.Errorf("err = %v, want %v", err, audioproc.ErrTooLong)
+	}
+}
+
+func TestTranscribePrompt(t *testing.T) {
+	cases := []struct {
+		previous, language string
+		timestamps         bool
+		want               string
+	}{
+		{want: "[audio-0]<|startoftranscript|>"},
+		{language: "en", want: "[audio-0]<|startoftranscript|><|en|><|transcribe|><|notimestamps|>"},
+		{language: "de", timestamps: true, want: "[audio-0]<|startoftranscript|><|de|><|transcribe|>"},
+		{previous: " Ollama, llama.cpp ", language: "en", want: "[audio-0]<|startofprev|> Ollama, llama.cpp<|startoftranscript|><|en|><|transcribe|><|notimestamps|>"},
+	}
+
+	for _, tt := range cases {
+		if got := transcribePrompt(tt.previous, tt.language, tt.timestamps); got != tt.want {
+			t.Errorf("transcribePrompt(%q, %q, %v) = %q, want %q", tt.previous, tt.language, tt.timestamps, got, tt.want)
+		}
+	}
+}
+
+func TestParseTranscription(t *testing.T) {
+	cases := []struct {
+		name     string
+		output   string
+		chunk    transcribeChunk
+		language string
+		segments []api.TranscribeSegment
+	}{
+		{
+			name:     "no timestamps",
+			output:   " Hello world.",
+			chunk:    transcribeChunk{Length: 2.5},
+			segments: []api.TranscribeSegment{{Start: 0, End: 2.5, Text: "Hello world."}},
+		},
+		{
+			name:     "detected language",
+			output:   "<|fr|><|transcribe|><|notimestamps|> Bonjour.",
+			chunk:    transcribeChunk{Offset: 30, Length: 10},
+			language: "fr",
+			segments: []api.TranscribeSegment{{Start: 30, End: 40, Text: "Bonjour."}},
+		},
+		{
+			name:   "timestamps",
+			output: "<|0.00|> Hello there.<|1.50|><|1.50|> General Kenobi.<|4.20|>",
+			chunk:  transcribeChunk{Offset: 30, Length: 30},
+			segments: []api.TranscribeSegment{
+				{Start: 30, End: 31.5, Text: "Hello there."},
+				{Start: 31.5, End: 34.2, Text: "General Kenobi."},
+			},
+		},
+		{
+			name:   "unterminated segment",
+			output: "<|en|><|transcribe|><|0.00|> One.<|1.00|><|1.00|> Two",
+			chunk:  transcribeChunk{Length: 3},
+			segments: []api.TranscribeSegment{
+				{Start: 0, End: 1, Text: "One."},
+				{Start: 1, End: 3, Text: "Two"},
+			},
+			language: "en",
+		},
+		{
+			name:   "past the end of the chunk",
+			output: "<|0.00|> One.<|29.98|>",
+			chunk:  transcribeChunk{Length: 12},
+			segments: []api.TranscribeSegment{
+				{Start: 0, End: 12, Text: "One."},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			language, segments := parseTranscription(tt.output, tt.chunk)
+			if language != tt.language {
+				t.Errorf("language = %q, want %q", language, tt.language)
+			}
+
+			if diff := cmp.Diff(tt.segments, segments); diff != "" {
+				t.Errorf("segments mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+// verifyChunkSize is how much of a blob is hashed between progress updates.
+const verifyChunkSize = 64 << 20
+
+var (
+	errBlobMissing = errors.New("blob is missing")
+	errBlobSize    = errors.New("blob has the wrong size")
+)
+
+// VerifyModels checks the models with the given names, or all models if
+// there are none: that their manifests can be read, that the blobs they use
+// exist and match their digests, and that their GGUF layers have valid
+// headers. Unlike fixBlobs, it reads the contents of every blob.
+//
+// If repair is true, blobs that are missing or don't match their digest are
+// downloaded again with regOpts from the registry of a model using them that
+// serves them. Only the blobs are downloaded: the manifests are left as they
+// are, so a repaired model is the same model even if its tag has since moved
+// upstream. Other problems, such as a blob of a model that was created locally
+// or a blob that matches its digest but isn't a valid model file, are reported
+// and the blob is left in place since downloading it again can't fix it.
+// Problems fixed by the download are reported as repaired.
+func VerifyModels(ctx context.Context, names []model.Name, repair bool, regOpts *registryOptions, fn func(api.VerifyResponse)) ([]api.VerifyProblem, error) {
+	var problems []api.VerifyProblem
+
+	if len(names) == 0 {
+		dir, err := GetManifestPath()
+		if err != nil {
+			return nil, err
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, "*", "*", "*", "*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(dir, match)
+			if err != nil {
+				return nil, err
+			}
+			if n := model.ParseNameFromFilepath(rel); n.IsValid() {
+				names = append(names, n)
+			} else {
+				problems = append(problems, api.VerifyProblem{Model: rel, Error: "invalid model name"})
+			}
+		}
+	}
+
+	// the blobs used by the models, and the models using each
+	users := make(map[string][]model.Name)
+	layers := make(map[string]Layer)
+	for _, n := range names {
+		m, err := ParseNamedManifest(n)
+		if err != nil {
+			problems = append(problems, api.VerifyProblem{Model: n.DisplayShortest(), Error: fmt.Sprintf("invalid manifest: %v", err)})
+			continue
+		}
+		for _, l := range append(m.Layers, m.Config) {
+			if l.Digest == "" {
+				continue
+			}
+			if !slices.Contains(users[l.Digest], n) {
+				users[l.Digest] = append(users[l.Digest], n)
+			}
+			layers[l.Digest] = l
+		}
+	}
+
+	digests := make([]string, 0, len(layers))
+	for d := range layers {
+		digests = append(digests, d)
+	}
+	slices.Sort(digests)
+
+	broken := make(map[string]error)
+	for _, d := range digests {
+		err := checkBlob(ctx, layers[d], fn)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			broken[d] = err
+		}
+	}
+
+	repairErrs := make(map[string]error)
+	invalid := make(map[string]error)
+	kept := make(map[string]string)
+	if repair && len(broken) > 0 {
+		for _, d := range digests {
+			err, ok := broken[d]
+			if !ok {
+				continue
+			}
+
+			if !errors.Is(err, errBlobMissing) && !errors.Is(err, errBlobSize) && !errors.Is(err, errDigestMismatch) {
+				kept[d] = "pulling it again wouldn't change it"
+				continue
+			}
+
+			i := slices.IndexFunc(users[d], func(n model.Name) bool {
+				return blobAvailable(ctx, n, d, regOpts)
+			})
+			if i < 0 {
+				kept[d] = "no registry of a model using it has it"
+				continue
+			}
+
+			if p, err := GetBlobsPath(d); err == nil {
+				os.Remove(p)
+			}
+
+			// the blob is downloaded by its digest, not by pulling the
+			// model again, which could resolve its tag to another
+			// manifest
+			if _, err := downloadBlob(ctx, downloadOpts{
+				mp:      ParseModelPath(users[d][i].String()),
+				digest:  d,
+				regOpts: regOpts,
+				fn: func(r api.ProgressResponse) {
+					fn(api.VerifyResponse{ProgressResponse: r})
+				},
+			}); err != nil {
+				repairErrs[d] = err
+			} else if err := checkBlob(ctx, layers[d], fn); err != nil {
+				invalid[d] = err
+			}
+		}
+	}
+
+	for _, d := range digests {
+		err, ok := broken[d]
+		if !ok {
+			continue
+		}
+		for _, n := range users[d] {
+			p := api.VerifyProblem{Model: n.DisplayShortest(), Digest: d, Error: err.Error()}
+			if repair {
+				if reason, ok := kept[d]; ok {
+					p.Error = fmt.Sprintf("%s; not repaired, %s", err, reason)
+				} else if rerr := repairErrs[d]; rerr != nil {
+					p.Error = fmt.Sprintf("%s; repair failed: %v", err, rerr)
+				} else if verr := invalid[d]; verr != nil {
+					p.Error = fmt.Sprintf("%s; still invalid after repair: %v", err, verr)
+				} else {
+					p.Repaired = true
+				}
+			}
+			problems = append(problems, p)
+		}
+	}
+	return problems, nil
+}
+
+// blobAvailable reports whether the registry of the model n serves the blob
+// with the given digest for it, so it can be pulled again.
+func blobAvailable(ctx context.Context, n model.Name, digest string, regOpts *registryOptions) bool {
+	mp := ParseModelPath(n.String())
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "blobs", digest)
+	resp, err := makeRequestWithRetry(ctx, http.MethodHead, requestURL, nil, nil, regOpts)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// checkBlob checks the blob of l exists, has the size and digest of l, and,
+// if it is a GGUF layer, that its header can be decoded.
+func checkBlob(ctx context.Context, l Layer, fn func(api.VerifyResponse)) error {
+	p, err := GetBlobsPath(l.Digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return errBlobMissing
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() != l.Size {
+		return fmt.Errorf("%w: %d bytes, want %d", errBlobSize, fi.Size(), l.Size)
+	}
+
+	status := fmt.Sprintf("verifying %s", l.Digest[7:19])
+	h := sha256.New()
+	var completed int64
+	for {
+		fn(api.VerifyResponse{ProgressResponse: api.ProgressResponse{Status: status, Digest: l.Digest, Total: l.Size, Completed: completed}})
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := io.CopyN(h, f, verifyChunkSize)
+		completed += n
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	fn(api.VerifyResponse{ProgressResponse: api.ProgressResponse{Status: status, Digest: l.Digest, Total: l.Size, Completed: completed}})
+
+	if fmt.Sprintf("sha256:%x", h.Sum(nil)) != l.Digest {
+		return errDigestMismatch
+	}
+
+	switch l.MediaType {
+	case "application/vnd.ollama.image.model",
+		"application/vnd.ollama.image.projector",
+		"application/vnd.ollama.image.adapter":
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, _, err := ggml.Decode(f, 0); err != nil {
+			return fmt.Errorf("invalid model file: %w", err)
+		}
+	}
+	return nil
+}